@@ -0,0 +1,74 @@
+// Package brotli implements the Brotli compressed data format (RFC 7932)
+// used by WOFF2.
+//
+// The decoder handles the complete format, including the static
+// dictionary. The encoder emits one prefix code per alphabet and meta-block
+// with LZ77 matches found by hash chains; it favors simplicity over the
+// compression ratio of the reference encoder.
+package brotli
+
+import "errors"
+
+var (
+	// ErrCorrupt is returned for malformed Brotli streams.
+	ErrCorrupt = errors.New("brotli: corrupt stream")
+
+	// ErrTooLarge is returned when a stream decompresses to more than the
+	// size limit passed to Decode.
+	ErrTooLarge = errors.New("brotli: decompressed data too large")
+)
+
+// maxMetaBlockLength is the largest MLEN of a meta-block.
+const maxMetaBlockLength = 1 << 24
+
+// Alphabet sizes of the insert-and-copy length and block count codes.
+const (
+	numCommandSymbols    = 704
+	numBlockCountSymbols = 26
+)
+
+// lengthCode is an insert length, copy length or block count prefix
+// code: the value is base plus an nbits-wide extra value.
+type lengthCode struct {
+	base  uint32
+	nbits uint8
+}
+
+// insertLengthCodes is the insert length table of RFC 7932 section 5.
+var insertLengthCodes = [24]lengthCode{
+	{0, 0}, {1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}, {6, 1}, {8, 1},
+	{10, 2}, {14, 2}, {18, 3}, {26, 3}, {34, 4}, {50, 4}, {66, 5}, {98, 5},
+	{130, 6}, {194, 7}, {322, 8}, {578, 9}, {1090, 10}, {2114, 12}, {6210, 14}, {22594, 24},
+}
+
+// copyLengthCodes is the copy length table of RFC 7932 section 5.
+var copyLengthCodes = [24]lengthCode{
+	{2, 0}, {3, 0}, {4, 0}, {5, 0}, {6, 0}, {7, 0}, {8, 0}, {9, 0},
+	{10, 1}, {12, 1}, {14, 2}, {18, 2}, {22, 3}, {30, 3}, {38, 4}, {54, 4},
+	{70, 5}, {102, 5}, {134, 6}, {198, 7}, {326, 8}, {582, 9}, {1094, 10}, {2118, 24},
+}
+
+// blockCountCodes is the block count table of RFC 7932 section 6.
+var blockCountCodes = [numBlockCountSymbols]lengthCode{
+	{1, 2}, {5, 2}, {9, 2}, {13, 2}, {17, 3}, {25, 3}, {33, 3}, {41, 3},
+	{49, 4}, {65, 4}, {81, 4}, {97, 4}, {113, 5}, {145, 5}, {177, 5}, {209, 5},
+	{241, 6}, {305, 6}, {369, 7}, {497, 8}, {753, 9}, {1265, 10}, {2289, 11}, {4337, 12},
+	{8433, 13}, {16625, 24},
+}
+
+// commandCells maps the cells of 64 insert-and-copy length codes,
+// starting at code 128, to the first insert and copy length code of the
+// cell (RFC 7932 section 5).
+var commandCells = [9][2]int{
+	{0, 0}, {0, 8}, {8, 0}, {8, 8}, {0, 16}, {16, 0}, {8, 16}, {16, 8}, {16, 16},
+}
+
+// codeLengthOrder is the order in which the code lengths of the code
+// length alphabet are stored (RFC 7932 section 3.5).
+var codeLengthOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// maxDistance returns the largest backward distance of a window of
+// wbits bits.
+func maxDistance(wbits uint) int {
+	return 1<<wbits - 16
+}
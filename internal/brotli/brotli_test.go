@@ -0,0 +1,89 @@
+package brotli
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/boxesandglue/textshape/internal/testutil"
+)
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var alphabet []byte
+	for i := range 256 {
+		alphabet = append(alphabet, byte(i))
+	}
+	font, err := os.ReadFile(testutil.FindTestFont("Roboto-Regular.ttf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"byte", []byte{'a'}},
+		{"run", bytes.Repeat([]byte{0}, 100000)},
+		{"text", bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 50)},
+		// Every literal has the same code length
+		{"alphabet", append(alphabet, alphabet...)},
+		// Stored uncompressed
+		{"random", random},
+		{"font", font},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := Encode(tt.data)
+			dec, err := Decode(enc, len(tt.data))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !bytes.Equal(dec, tt.data) {
+				t.Fatalf("round trip of %d bytes returned %d different bytes", len(tt.data), len(dec))
+			}
+			if len(tt.data) > 1000 && tt.name != "random" && len(enc) >= len(tt.data) {
+				t.Errorf("%d bytes compressed to %d", len(tt.data), len(enc))
+			}
+		})
+	}
+}
+
+func TestDecodeReference(t *testing.T) {
+	// COPYING compressed by the reference encoder at quality 11, with
+	// dictionary references and context modeling
+	enc, err := os.ReadFile("testdata/COPYING.br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("../../testdata/COPYING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(enc, len(want))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("decoded %q", got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	data := bytes.Repeat([]byte("abcdefgh"), 1000)
+	enc := Encode(data)
+	if _, err := Decode(enc, len(data)-1); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("limit: %v, want ErrTooLarge", err)
+	}
+	if _, err := Decode(enc[:len(enc)/2], len(data)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("truncated: %v, want ErrCorrupt", err)
+	}
+	// A large window is not RFC 7932
+	if _, err := Decode([]byte{0x11, 0x01}, 100); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("large window: %v, want ErrCorrupt", err)
+	}
+}
@@ -0,0 +1,597 @@
+package brotli
+
+import "math/bits"
+
+// bitReader reads a stream least significant bit first.
+type bitReader struct {
+	data  []byte
+	pos   int
+	val   uint64
+	nbits uint
+	err   error
+}
+
+// readBits reads an n-bit value, n <= 24.
+func (br *bitReader) readBits(n uint) int {
+	for br.nbits < n {
+		if br.pos >= len(br.data) {
+			br.err = ErrCorrupt
+			return 0
+		}
+		br.val |= uint64(br.data[br.pos]) << br.nbits
+		br.pos++
+		br.nbits += 8
+	}
+	v := int(br.val & (1<<n - 1))
+	br.val >>= n
+	br.nbits -= n
+	return v
+}
+
+// align skips to the next byte boundary. It reports whether the skipped
+// padding bits are zero.
+func (br *bitReader) align() bool {
+	pad := br.readBits(br.nbits % 8)
+	// Give back the whole bytes already buffered
+	br.pos -= int(br.nbits / 8)
+	br.val, br.nbits = 0, 0
+	return pad == 0
+}
+
+// readBytes reads n bytes at a byte boundary.
+func (br *bitReader) readBytes(n int) []byte {
+	if n > len(br.data)-br.pos {
+		br.err = ErrCorrupt
+		return nil
+	}
+	b := br.data[br.pos : br.pos+n]
+	br.pos += n
+	return b
+}
+
+// huffman is a canonical prefix code.
+type huffman struct {
+	count  [16]uint16 // number of codes of each length
+	symbol []uint16   // symbols in code order
+}
+
+// newHuffman builds the canonical code of the code lengths; 0 marks an
+// unused symbol.
+func newHuffman(lengths []uint8) *huffman {
+	h := &huffman{}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	var offs [16]uint16
+	for l := 1; l < 15; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	h.symbol = make([]uint16, len(lengths)-int(h.count[0]))
+	for s, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = uint16(s)
+			offs[l]++
+		}
+	}
+	return h
+}
+
+// decode reads a symbol. A code with a single symbol uses no bits.
+func (h *huffman) decode(br *bitReader) int {
+	if len(h.symbol) == 1 {
+		return int(h.symbol[0])
+	}
+	code, first, index := 0, 0, 0
+	for l := 1; l < 16; l++ {
+		code |= br.readBits(1)
+		count := int(h.count[l])
+		if code-first < count {
+			return int(h.symbol[index+code-first])
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	br.err = ErrCorrupt
+	return 0
+}
+
+// readPrefixCode reads a simple or complex prefix code (RFC 7932 sections
+// 3.4 and 3.5).
+func readPrefixCode(br *bitReader, alphabetSize int) (*huffman, error) {
+	hskip := br.readBits(2)
+	if hskip == 1 {
+		return readSimplePrefixCode(br, alphabetSize)
+	}
+
+	var clLengths [18]uint8
+	space, num, last := 32, 0, 0
+	for i := hskip; i < 18 && space > 0; i++ {
+		var v int
+		switch br.readBits(2) {
+		case 0:
+			v = 0
+		case 1:
+			v = 4
+		case 2:
+			v = 3
+		default:
+			if br.readBits(1) == 0 {
+				v = 2
+			} else if br.readBits(1) == 0 {
+				v = 1
+			} else {
+				v = 5
+			}
+		}
+		clLengths[codeLengthOrder[i]] = uint8(v)
+		if v != 0 {
+			space -= 32 >> v
+			num++
+			last = codeLengthOrder[i]
+		}
+	}
+	if br.err != nil || (num != 1 && space != 0) {
+		return nil, ErrCorrupt
+	}
+	cl := &huffman{symbol: []uint16{uint16(last)}}
+	if num > 1 {
+		cl = newHuffman(clLengths[:])
+	}
+
+	lengths := make([]uint8, alphabetSize)
+	prevLen, repeatLen := uint8(8), uint8(0)
+	repeat := 0
+	space = 32768
+	for s := 0; s < alphabetSize && space > 0; {
+		c := cl.decode(br)
+		if br.err != nil {
+			return nil, br.err
+		}
+		if c < 16 {
+			repeat = 0
+			lengths[s] = uint8(c)
+			s++
+			if c != 0 {
+				prevLen = uint8(c)
+				space -= 32768 >> c
+			}
+			continue
+		}
+		extra, newLen := uint(2), prevLen
+		if c == 17 {
+			extra, newLen = 3, 0
+		}
+		if repeatLen != newLen {
+			repeat, repeatLen = 0, newLen
+		}
+		old := repeat
+		if repeat > 0 {
+			repeat = (repeat - 2) << extra
+		}
+		repeat += br.readBits(extra) + 3
+		delta := repeat - old
+		if s+delta > alphabetSize {
+			return nil, ErrCorrupt
+		}
+		for range delta {
+			lengths[s] = newLen
+			s++
+		}
+		if newLen != 0 {
+			space -= delta * (32768 >> newLen)
+		}
+	}
+	if br.err != nil || space != 0 {
+		return nil, ErrCorrupt
+	}
+	return newHuffman(lengths), nil
+}
+
+// readSimplePrefixCode reads a prefix code of one to four symbols.
+func readSimplePrefixCode(br *bitReader, alphabetSize int) (*huffman, error) {
+	nsym := br.readBits(2) + 1
+	width := uint(bits.Len(uint(alphabetSize - 1)))
+	syms := make([]int, nsym)
+	for i := range syms {
+		syms[i] = br.readBits(width)
+		if syms[i] >= alphabetSize {
+			return nil, ErrCorrupt
+		}
+		for _, s := range syms[:i] {
+			if s == syms[i] {
+				return nil, ErrCorrupt
+			}
+		}
+	}
+	if nsym == 1 {
+		return &huffman{symbol: []uint16{uint16(syms[0])}}, br.err
+	}
+	codeLengths := [][]uint8{2: {1, 1}, 3: {1, 2, 2}, 4: {2, 2, 2, 2}}[nsym]
+	if nsym == 4 && br.readBits(1) == 1 {
+		codeLengths = []uint8{1, 2, 3, 3}
+	}
+	lengths := make([]uint8, alphabetSize)
+	for i, s := range syms {
+		lengths[s] = codeLengths[i]
+	}
+	return newHuffman(lengths), br.err
+}
+
+// readVarLenUint8 reads a value 0…255 (RFC 7932 section 9.2).
+func readVarLenUint8(br *bitReader) int {
+	if br.readBits(1) == 0 {
+		return 0
+	}
+	n := uint(br.readBits(3))
+	if n == 0 {
+		return 1
+	}
+	return 1<<n + br.readBits(n)
+}
+
+// blockState tracks the block type and count of one of the literal,
+// insert-and-copy and distance categories.
+type blockState struct {
+	ntypes     int
+	typeCode   *huffman
+	countCode  *huffman
+	count      int
+	last, prev int
+}
+
+// readHeader reads NBLTYPES and the first block count of the category.
+func (b *blockState) readHeader(br *bitReader) error {
+	b.ntypes = readVarLenUint8(br) + 1
+	b.count, b.last, b.prev = maxMetaBlockLength, 0, 1
+	if b.ntypes < 2 {
+		return br.err
+	}
+	var err error
+	if b.typeCode, err = readPrefixCode(br, b.ntypes+2); err != nil {
+		return err
+	}
+	if b.countCode, err = readPrefixCode(br, numBlockCountSymbols); err != nil {
+		return err
+	}
+	b.count = b.readCount(br)
+	return br.err
+}
+
+func (b *blockState) readCount(br *bitReader) int {
+	c := blockCountCodes[b.countCode.decode(br)]
+	return int(c.base) + br.readBits(uint(c.nbits))
+}
+
+// next consumes one symbol of the category, switching blocks when the
+// current one is exhausted, and returns the block type.
+func (b *blockState) next(br *bitReader) int {
+	if b.count == 0 {
+		t := b.typeCode.decode(br)
+		switch t {
+		case 0:
+			t = b.prev
+		case 1:
+			t = b.last + 1
+		default:
+			t -= 2
+		}
+		if t >= b.ntypes {
+			t -= b.ntypes
+		}
+		b.prev, b.last = b.last, t
+		b.count = b.readCount(br)
+	}
+	b.count--
+	return b.last
+}
+
+// readContextMap reads a context map of size entries (RFC 7932 section
+// 7.3).
+func readContextMap(br *bitReader, size, ntrees int) ([]uint8, error) {
+	m := make([]uint8, size)
+	if ntrees < 2 {
+		return m, nil
+	}
+	rleMax := 0
+	if br.readBits(1) == 1 {
+		rleMax = br.readBits(4) + 1
+	}
+	h, err := readPrefixCode(br, ntrees+rleMax)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < size; {
+		c := h.decode(br)
+		switch {
+		case c == 0:
+			i++
+		case c <= rleMax:
+			i += 1<<c + br.readBits(uint(c))
+			if i > size {
+				return nil, ErrCorrupt
+			}
+		default:
+			m[i] = uint8(c - rleMax)
+			i++
+		}
+		if br.err != nil {
+			return nil, br.err
+		}
+	}
+	if br.readBits(1) == 1 {
+		var mtf [256]uint8
+		for i := range mtf {
+			mtf[i] = uint8(i)
+		}
+		for i, idx := range m {
+			v := mtf[idx]
+			m[i] = v
+			copy(mtf[1:idx+1], mtf[:idx])
+			mtf[0] = v
+		}
+	}
+	return m, br.err
+}
+
+// literalContext returns the context ID of a literal following p2, p1
+// (RFC 7932 section 7.1).
+func literalContext(mode int, p1, p2 byte) int {
+	switch mode {
+	case 0:
+		return int(p1 & 0x3f)
+	case 1:
+		return int(p1 >> 2)
+	case 2:
+		return int(lut0[p1] | lut1[p2])
+	}
+	return int(lut2[p1]<<3 | lut2[p2])
+}
+
+// Distance codes 0…15 refer to the ring buffer of the last distances
+// (RFC 7932 section 4).
+var (
+	shortDistanceIndex  = [16]int{0, 1, 2, 3, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1}
+	shortDistanceOffset = [16]int{0, 0, 0, 0, -1, 1, -2, 2, -3, 3, -1, 1, -2, 2, -3, 3}
+)
+
+type decoder struct {
+	br          bitReader
+	out         []byte
+	limit       int
+	maxBackward int
+	dists       [4]int // last distances, most recent first
+}
+
+// Decode decompresses a Brotli stream. It returns ErrTooLarge if the
+// output would exceed maxSize bytes.
+func Decode(data []byte, maxSize int) ([]byte, error) {
+	d := &decoder{br: bitReader{data: data}, limit: maxSize, dists: [4]int{4, 11, 15, 16}}
+	if err := d.decode(); err != nil {
+		return nil, err
+	}
+	return d.out, nil
+}
+
+func (d *decoder) decode() error {
+	br := &d.br
+	wbits, err := readWindowBits(br)
+	if err != nil {
+		return err
+	}
+	d.maxBackward = maxDistance(wbits)
+	for {
+		last := br.readBits(1) == 1
+		if last && br.readBits(1) == 1 {
+			break
+		}
+		nibbles := uint(br.readBits(2))
+		if nibbles == 3 {
+			// Metadata is skipped
+			if last || br.readBits(1) != 0 {
+				return ErrCorrupt
+			}
+			skipBytes := uint(br.readBits(2))
+			skip := 0
+			if skipBytes > 0 {
+				skip = br.readBits(8 * skipBytes)
+				if skipBytes > 1 && skip>>(8*(skipBytes-1)) == 0 {
+					return ErrCorrupt
+				}
+				skip++
+			}
+			if !br.align() {
+				return ErrCorrupt
+			}
+			br.readBytes(skip)
+			if br.err != nil {
+				return br.err
+			}
+			continue
+		}
+		nibbles += 4
+		mlen := br.readBits(4 * nibbles)
+		if nibbles > 4 && mlen>>(4*(nibbles-1)) == 0 {
+			return ErrCorrupt
+		}
+		mlen++
+		if br.err != nil {
+			return br.err
+		}
+		if len(d.out)+mlen > d.limit {
+			return ErrTooLarge
+		}
+		if !last && br.readBits(1) == 1 {
+			if !br.align() {
+				return ErrCorrupt
+			}
+			d.out = append(d.out, br.readBytes(mlen)...)
+		} else if err := d.metaBlock(mlen); err != nil {
+			return err
+		}
+		if br.err != nil {
+			return br.err
+		}
+		if last {
+			break
+		}
+	}
+	return br.err
+}
+
+// readWindowBits reads WBITS from the stream header (RFC 7932 section
+// 9.1).
+func readWindowBits(br *bitReader) (uint, error) {
+	if br.readBits(1) == 0 {
+		return 16, br.err
+	}
+	if n := br.readBits(3); n != 0 {
+		return uint(17 + n), br.err
+	}
+	switch n := br.readBits(3); n {
+	case 0:
+		return 17, br.err
+	case 1:
+		// Large windows are not part of RFC 7932
+		return 0, ErrCorrupt
+	default:
+		return uint(8 + n), br.err
+	}
+}
+
+// metaBlock decodes a compressed meta-block of mlen bytes.
+func (d *decoder) metaBlock(mlen int) error {
+	br := &d.br
+	var lit, cmd, dist blockState
+	for _, b := range []*blockState{&lit, &cmd, &dist} {
+		if err := b.readHeader(br); err != nil {
+			return err
+		}
+	}
+	npostfix := uint(br.readBits(2))
+	ndirect := br.readBits(4) << npostfix
+	modes := make([]int, lit.ntypes)
+	for i := range modes {
+		modes[i] = br.readBits(2)
+	}
+	ntreesL := readVarLenUint8(br) + 1
+	litMap, err := readContextMap(br, 64*lit.ntypes, ntreesL)
+	if err != nil {
+		return err
+	}
+	ntreesD := readVarLenUint8(br) + 1
+	distMap, err := readContextMap(br, 4*dist.ntypes, ntreesD)
+	if err != nil {
+		return err
+	}
+	litCodes, err := readPrefixCodes(br, ntreesL, 256)
+	if err != nil {
+		return err
+	}
+	cmdCodes, err := readPrefixCodes(br, cmd.ntypes, numCommandSymbols)
+	if err != nil {
+		return err
+	}
+	distCodes, err := readPrefixCodes(br, ntreesD, 16+ndirect+48<<npostfix)
+	if err != nil {
+		return err
+	}
+
+	end := len(d.out) + mlen
+	for len(d.out) < end {
+		c := cmdCodes[cmd.next(br)].decode(br)
+		implicit := c < 128
+		var insCode, copyCode int
+		if implicit {
+			insCode, copyCode = c>>3&7, c&7+8*(c>>6)
+		} else {
+			cell := commandCells[(c-128)>>6]
+			insCode, copyCode = cell[0]+c>>3&7, cell[1]+c&7
+		}
+		insLen := int(insertLengthCodes[insCode].base) + br.readBits(uint(insertLengthCodes[insCode].nbits))
+		copyLen := int(copyLengthCodes[copyCode].base) + br.readBits(uint(copyLengthCodes[copyCode].nbits))
+		if len(d.out)+insLen > end {
+			return ErrCorrupt
+		}
+		for range insLen {
+			var p1, p2 byte
+			if n := len(d.out); n > 1 {
+				p1, p2 = d.out[n-1], d.out[n-2]
+			} else if n == 1 {
+				p1 = d.out[0]
+			}
+			t := lit.next(br)
+			d.out = append(d.out, byte(litCodes[litMap[64*t+literalContext(modes[t], p1, p2)]].decode(br)))
+		}
+		if br.err != nil {
+			return br.err
+		}
+		if len(d.out) == end {
+			break
+		}
+
+		dcode := 0
+		if !implicit {
+			t := dist.next(br)
+			dcode = distCodes[distMap[4*t+min(copyLen, 5)-2]].decode(br)
+		}
+		var distance int
+		switch {
+		case dcode < 16:
+			distance = d.dists[shortDistanceIndex[dcode]] + shortDistanceOffset[dcode]
+		case dcode < 16+ndirect:
+			distance = dcode - 15
+		default:
+			x := dcode - ndirect - 16
+			nbits := 1 + uint(x>>(npostfix+1))
+			offset := (2+(x>>npostfix&1))<<nbits - 4
+			distance = (offset+br.readBits(nbits))<<npostfix + x&(1<<npostfix-1) + ndirect + 1
+		}
+		if br.err != nil {
+			return br.err
+		}
+		if distance <= 0 {
+			return ErrCorrupt
+		}
+
+		maxDist := min(d.maxBackward, len(d.out))
+		if distance > maxDist {
+			if copyLen < 4 || copyLen > 24 || dictSizeBits[copyLen] == 0 {
+				return ErrCorrupt
+			}
+			wordID := distance - maxDist - 1
+			nbits := dictSizeBits[copyLen]
+			index, id := wordID&(1<<nbits-1), wordID>>nbits
+			if id >= len(transforms) {
+				return ErrCorrupt
+			}
+			off := dictOffsets[copyLen] + index*copyLen
+			d.out = transformWord(d.out, dictionary[off:off+copyLen], id)
+		} else {
+			if dcode != 0 {
+				d.dists = [4]int{distance, d.dists[0], d.dists[1], d.dists[2]}
+			}
+			if len(d.out)+copyLen > end {
+				return ErrCorrupt
+			}
+			for range copyLen {
+				d.out = append(d.out, d.out[len(d.out)-distance])
+			}
+		}
+		if len(d.out) > end {
+			return ErrCorrupt
+		}
+	}
+	return br.err
+}
+
+func readPrefixCodes(br *bitReader, n, alphabetSize int) ([]*huffman, error) {
+	codes := make([]*huffman, n)
+	for i := range codes {
+		var err error
+		if codes[i], err = readPrefixCode(br, alphabetSize); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
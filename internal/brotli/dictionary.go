@@ -0,0 +1,231 @@
+package brotli
+
+import _ "embed"
+
+// dictionary is the static dictionary of RFC 7932 Appendix A.
+//
+//go:embed dictionary.bin
+var dictionary string
+
+// dictSizeBits is NDBITS of RFC 7932 section 8: words of length l are
+// indexed with dictSizeBits[l] bits.
+var dictSizeBits = [25]uint8{
+	0, 0, 0, 0, 10, 10, 11, 11, 10, 10, 10, 10, 10,
+	9, 9, 8, 7, 7, 8, 7, 7, 6, 6, 5, 5,
+}
+
+// dictOffsets is DOFFSET of RFC 7932 section 8: the words of length l
+// start at dictOffsets[l].
+var dictOffsets [25]int
+
+func init() {
+	for l := 4; l < 24; l++ {
+		dictOffsets[l+1] = dictOffsets[l] + l<<dictSizeBits[l]
+	}
+}
+
+// Word transform types of RFC 7932 Appendix B. omitLast1…9 are 1…9 and
+// omitFirst1…9 are 12…20.
+const (
+	identity       = 0
+	uppercaseFirst = 10
+	uppercaseAll   = 11
+)
+
+const (
+	omitLast1 = 1 + iota
+	omitLast2
+	omitLast3
+	omitLast4
+	omitLast5
+	omitLast6
+	omitLast7
+	omitLast8
+	omitLast9
+)
+
+const (
+	omitFirst1 = 12 + iota
+	omitFirst2
+	omitFirst3
+	omitFirst4
+	omitFirst5
+	omitFirst6
+	omitFirst7
+	omitFirst8
+	omitFirst9
+)
+
+// transforms is the transform list of RFC 7932 Appendix B.
+var transforms = [121]struct {
+	prefix string
+	kind   int
+	suffix string
+}{
+	{"", identity, ""},
+	{"", identity, " "},
+	{" ", identity, " "},
+	{"", omitFirst1, ""},
+	{"", uppercaseFirst, " "},
+	{"", identity, " the "},
+	{" ", identity, ""},
+	{"s ", identity, " "},
+	{"", identity, " of "},
+	{"", uppercaseFirst, ""},
+	{"", identity, " and "},
+	{"", omitFirst2, ""},
+	{"", omitLast1, ""},
+	{", ", identity, " "},
+	{"", identity, ", "},
+	{" ", uppercaseFirst, " "},
+	{"", identity, " in "},
+	{"", identity, " to "},
+	{"e ", identity, " "},
+	{"", identity, "\""},
+	{"", identity, "."},
+	{"", identity, "\">"},
+	{"", identity, "\n"},
+	{"", omitLast3, ""},
+	{"", identity, "]"},
+	{"", identity, " for "},
+	{"", omitFirst3, ""},
+	{"", omitLast2, ""},
+	{"", identity, " a "},
+	{"", identity, " that "},
+	{" ", uppercaseFirst, ""},
+	{"", identity, ". "},
+	{".", identity, ""},
+	{" ", identity, ", "},
+	{"", omitFirst4, ""},
+	{"", identity, " with "},
+	{"", identity, "'"},
+	{"", identity, " from "},
+	{"", identity, " by "},
+	{"", omitFirst5, ""},
+	{"", omitFirst6, ""},
+	{" the ", identity, ""},
+	{"", omitLast4, ""},
+	{"", identity, ". The "},
+	{"", uppercaseAll, ""},
+	{"", identity, " on "},
+	{"", identity, " as "},
+	{"", identity, " is "},
+	{"", omitLast7, ""},
+	{"", omitLast1, "ing "},
+	{"", identity, "\n\t"},
+	{"", identity, ":"},
+	{" ", identity, ". "},
+	{"", identity, "ed "},
+	{"", omitFirst9, ""},
+	{"", omitFirst7, ""},
+	{"", omitLast6, ""},
+	{"", identity, "("},
+	{"", uppercaseFirst, ", "},
+	{"", omitLast8, ""},
+	{"", identity, " at "},
+	{"", identity, "ly "},
+	{" the ", identity, " of "},
+	{"", omitLast5, ""},
+	{"", omitLast9, ""},
+	{" ", uppercaseFirst, ", "},
+	{"", uppercaseFirst, "\""},
+	{".", identity, "("},
+	{"", uppercaseAll, " "},
+	{"", uppercaseFirst, "\">"},
+	{"", identity, "=\""},
+	{" ", identity, "."},
+	{".com/", identity, ""},
+	{" the ", identity, " of the "},
+	{"", uppercaseFirst, "'"},
+	{"", identity, ". This "},
+	{"", identity, ","},
+	{".", identity, " "},
+	{"", uppercaseFirst, "("},
+	{"", uppercaseFirst, "."},
+	{"", identity, " not "},
+	{" ", identity, "=\""},
+	{"", identity, "er "},
+	{" ", uppercaseAll, " "},
+	{"", identity, "al "},
+	{" ", uppercaseAll, ""},
+	{"", identity, "='"},
+	{"", uppercaseAll, "\""},
+	{"", uppercaseFirst, ". "},
+	{" ", identity, "("},
+	{"", identity, "ful "},
+	{" ", uppercaseFirst, ". "},
+	{"", identity, "ive "},
+	{"", identity, "less "},
+	{"", uppercaseAll, "'"},
+	{"", identity, "est "},
+	{" ", uppercaseFirst, "."},
+	{"", uppercaseAll, "\">"},
+	{" ", identity, "='"},
+	{"", uppercaseFirst, ","},
+	{"", identity, "ize "},
+	{"", uppercaseAll, "."},
+	{"\u00a0", identity, ""},
+	{" ", identity, ","},
+	{"", uppercaseFirst, "=\""},
+	{"", uppercaseAll, "=\""},
+	{"", identity, "ous "},
+	{"", uppercaseAll, ", "},
+	{"", uppercaseFirst, "='"},
+	{" ", uppercaseFirst, ","},
+	{" ", uppercaseAll, "=\""},
+	{" ", uppercaseAll, ", "},
+	{"", uppercaseAll, ","},
+	{"", uppercaseAll, "("},
+	{"", uppercaseAll, ". "},
+	{" ", uppercaseAll, "."},
+	{"", uppercaseAll, "='"},
+	{" ", uppercaseAll, ". "},
+	{" ", uppercaseFirst, "=\""},
+	{" ", uppercaseAll, "='"},
+	{" ", uppercaseFirst, "='"},
+}
+
+// transformWord appends the dictionary word transformed by transform id
+// to dst.
+func transformWord(dst []byte, word string, id int) []byte {
+	t := transforms[id]
+	dst = append(dst, t.prefix...)
+	switch {
+	case t.kind >= omitLast1 && t.kind <= omitLast9:
+		word = word[:max(len(word)-t.kind, 0)]
+	case t.kind >= omitFirst1 && t.kind <= omitFirst9:
+		word = word[min(t.kind-omitFirst1+1, len(word)):]
+	}
+	start := len(dst)
+	dst = append(dst, word...)
+	switch t.kind {
+	case uppercaseFirst:
+		ferment(dst[start:], 0)
+	case uppercaseAll:
+		for i := start; i < len(dst); {
+			i += ferment(dst[start:], i-start)
+		}
+	}
+	return append(dst, t.suffix...)
+}
+
+// ferment upper-cases the UTF-8 character at word[pos] the way RFC 7932
+// does and returns its length.
+func ferment(word []byte, pos int) int {
+	switch {
+	case word[pos] < 192:
+		if word[pos] >= 'a' && word[pos] <= 'z' {
+			word[pos] ^= 32
+		}
+		return 1
+	case word[pos] < 224:
+		if pos+1 < len(word) {
+			word[pos+1] ^= 32
+		}
+		return 2
+	}
+	if pos+2 < len(word) {
+		word[pos+2] ^= 5
+	}
+	return 3
+}
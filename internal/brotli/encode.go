@@ -0,0 +1,536 @@
+package brotli
+
+import (
+	"math/bits"
+	"slices"
+	"sort"
+)
+
+// bitWriter writes a stream least significant bit first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// writeBits writes the n low bits of v, n <= 32.
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	w.acc |= v << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// align pads the stream with zero bits to a byte boundary.
+func (w *bitWriter) align() {
+	if w.nbits > 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+}
+
+// prefixCode is a canonical prefix code built from symbol counts.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16 // bit-reversed, ready to be written
+	used    []int    // symbols with a nonzero count
+}
+
+func newPrefixCode(freq []uint32, limit int) *prefixCode {
+	c := &prefixCode{lengths: huffmanLengths(freq, limit), codes: make([]uint16, len(freq))}
+	for s, f := range freq {
+		if f > 0 {
+			c.used = append(c.used, s)
+		}
+	}
+	if len(c.used) == 0 {
+		c.used = []int{0}
+	}
+	var count [16]int
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]int
+	code := 0
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range c.lengths {
+		if l != 0 {
+			c.codes[s] = uint16(bits.Reverse16(uint16(next[l])) >> (16 - l))
+			next[l]++
+		}
+	}
+	return c
+}
+
+func (w *bitWriter) writeSymbol(c *prefixCode, s int) {
+	w.writeBits(uint64(c.codes[s]), uint(c.lengths[s]))
+}
+
+// huffmanLengths returns the code lengths of a prefix code for the symbol
+// counts, limited to limit bits. A lone symbol gets length 0: it is coded
+// with no bits.
+func huffmanLengths(freq []uint32, limit int) []uint8 {
+	lengths := make([]uint8, len(freq))
+	var syms []int
+	for s, f := range freq {
+		if f > 0 {
+			syms = append(syms, s)
+		}
+	}
+	if len(syms) < 2 {
+		return lengths
+	}
+	// Raise the smallest counts until the tree is shallow enough
+	for minCount := uint32(1); !buildHuffman(freq, syms, minCount, limit, lengths); minCount *= 2 {
+	}
+	return lengths
+}
+
+// buildHuffman fills in the code lengths of a Huffman tree over syms with
+// counts of at least minCount. It reports false if a code is longer than
+// limit.
+func buildHuffman(freq []uint32, syms []int, minCount uint32, limit int, lengths []uint8) bool {
+	type node struct {
+		weight uint64
+		parent int
+	}
+	n := len(syms)
+	leaves := slices.Clone(syms)
+	weight := func(s int) uint64 { return uint64(max(freq[s], minCount)) }
+	sort.SliceStable(leaves, func(i, j int) bool { return weight(leaves[i]) < weight(leaves[j]) })
+	nodes := make([]node, n, 2*n-1)
+	for i, s := range leaves {
+		nodes[i].weight = weight(s)
+	}
+	// Leaves and internal nodes are both taken in order of weight
+	leaf, inner := 0, n
+	pick := func() int {
+		if leaf < n && (inner >= len(nodes) || nodes[leaf].weight <= nodes[inner].weight) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for range n - 1 {
+		a, b := pick(), pick()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight})
+		nodes[a].parent, nodes[b].parent = len(nodes)-1, len(nodes)-1
+	}
+	depth := make([]int, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[nodes[i].parent] + 1
+		if depth[i] > limit {
+			return false
+		}
+	}
+	for i, s := range leaves {
+		lengths[s] = uint8(depth[i])
+	}
+	return true
+}
+
+// codeLengthCodes is the static code of the code length code lengths
+// (RFC 7932 section 3.5), as bits and bit count.
+var codeLengthCodes = [6][2]uint8{{0, 2}, {7, 4}, {3, 3}, {2, 2}, {1, 2}, {15, 4}}
+
+// writePrefixCode writes the code lengths of c (RFC 7932 sections 3.4
+// and 3.5).
+func (w *bitWriter) writePrefixCode(c *prefixCode, alphabetSize int) {
+	if len(c.used) <= 4 {
+		syms := slices.Clone(c.used)
+		sort.SliceStable(syms, func(i, j int) bool { return c.lengths[syms[i]] < c.lengths[syms[j]] })
+		w.writeBits(1, 2)
+		w.writeBits(uint64(len(syms)-1), 2)
+		width := uint(bits.Len(uint(alphabetSize - 1)))
+		for _, s := range syms {
+			w.writeBits(uint64(s), width)
+		}
+		if len(syms) == 4 {
+			if c.lengths[syms[0]] == 1 {
+				w.writeBits(1, 1)
+			} else {
+				w.writeBits(0, 1)
+			}
+		}
+		return
+	}
+
+	// Run-length code the lengths up to the last used symbol. Runs of
+	// zeros become code 17; consecutive 17s would extend each other's
+	// count, so they are kept apart by a single 0.
+	var clSyms, clExtra []uint8
+	for i, last := 0, c.used[len(c.used)-1]; i <= last; {
+		if c.lengths[i] != 0 {
+			clSyms, clExtra = append(clSyms, c.lengths[i]), append(clExtra, 0)
+			i++
+			continue
+		}
+		run := 0
+		for c.lengths[i+run] == 0 {
+			run++
+		}
+		i += run
+		for run > 0 {
+			if run < 3 {
+				for range run {
+					clSyms, clExtra = append(clSyms, 0), append(clExtra, 0)
+				}
+				break
+			}
+			k := min(run, 10)
+			clSyms, clExtra = append(clSyms, 17), append(clExtra, uint8(k-3))
+			run -= k
+			if run >= 3 {
+				clSyms, clExtra = append(clSyms, 0), append(clExtra, 0)
+				run--
+			}
+		}
+	}
+	clFreq := make([]uint32, 18)
+	for _, s := range clSyms {
+		clFreq[s]++
+	}
+	cl := newPrefixCode(clFreq, 5)
+
+	clLengths := slices.Clone(cl.lengths)
+	end := 0
+	if len(cl.used) == 1 {
+		// A lone code length symbol is coded with no bits but still
+		// needs a nonzero length; all 18 lengths are then stored.
+		clLengths[cl.used[0]] = 1
+		end = 18
+	} else {
+		for i, s := range codeLengthOrder {
+			if clLengths[s] != 0 {
+				end = i + 1
+			}
+		}
+	}
+	hskip := 0
+	if clLengths[1] == 0 && clLengths[2] == 0 {
+		hskip = 2
+		if clLengths[3] == 0 {
+			hskip = 3
+		}
+	}
+	w.writeBits(uint64(hskip), 2)
+	for _, s := range codeLengthOrder[hskip:end] {
+		code := codeLengthCodes[clLengths[s]]
+		w.writeBits(uint64(code[0]), uint(code[1]))
+	}
+	for i, s := range clSyms {
+		w.writeSymbol(cl, int(s))
+		if s == 17 {
+			w.writeBits(uint64(clExtra[i]), 3)
+		}
+	}
+}
+
+// LZ77 match finder parameters.
+const (
+	hashBits  = 16
+	minMatch  = 4
+	maxChain  = 64
+	niceMatch = 258
+)
+
+// matcher finds backward matches through hash chains of 4-byte
+// sequences.
+type matcher struct {
+	data    []byte
+	head    []int32 // last position+1 of each hash
+	prev    []int32 // previous position+1 with the same hash
+	maxDist int
+}
+
+func newMatcher(data []byte, maxDist int) *matcher {
+	return &matcher{
+		data:    data,
+		head:    make([]int32, 1<<hashBits),
+		prev:    make([]int32, len(data)),
+		maxDist: maxDist,
+	}
+}
+
+func (m *matcher) hash(i int) uint32 {
+	d := m.data[i:]
+	v := uint32(d[0]) | uint32(d[1])<<8 | uint32(d[2])<<16 | uint32(d[3])<<24
+	return v * 0x1E35A7BD >> (32 - hashBits)
+}
+
+func (m *matcher) insert(i int) {
+	if i+minMatch > len(m.data) {
+		return
+	}
+	h := m.hash(i)
+	m.prev[i] = m.head[h]
+	m.head[h] = int32(i + 1)
+}
+
+// find returns the longest match at i that ends before end.
+func (m *matcher) find(i, end int) (length, dist int) {
+	if i+minMatch > end {
+		return 0, 0
+	}
+	cur := m.data[i:end]
+	for cand, chain := int(m.head[m.hash(i)])-1, maxChain; cand >= 0 && chain > 0; cand, chain = int(m.prev[cand])-1, chain-1 {
+		d := i - cand
+		if d > m.maxDist {
+			break
+		}
+		if length > 0 && m.data[cand+length] != cur[length] {
+			continue
+		}
+		l := 0
+		for l < len(cur) && m.data[cand+l] == cur[l] {
+			l++
+		}
+		if l > length {
+			length, dist = l, d
+			if l >= niceMatch || l == len(cur) {
+				break
+			}
+		}
+	}
+	// A short match far back costs more than its literals
+	if length < minMatch || (length == minMatch && dist > 1<<14) {
+		return 0, 0
+	}
+	return length, dist
+}
+
+// command inserts literals and then copies copyLen bytes from dist
+// bytes back. A copyLen of 0 ends the meta-block after the literals.
+type command struct {
+	insertLen, copyLen, dist int
+}
+
+// commands parses data[start:end] into commands, greedily with one step
+// of lazy matching.
+func (m *matcher) commands(start, end int) []command {
+	var cmds []command
+	lit := start
+	for i := start; i < end; {
+		l, d := m.find(i, end)
+		m.insert(i)
+		if l == 0 {
+			i++
+			continue
+		}
+		for i+1 < end {
+			l2, d2 := m.find(i+1, end)
+			if l2 <= l {
+				break
+			}
+			i++
+			m.insert(i)
+			l, d = l2, d2
+		}
+		cmds = append(cmds, command{insertLen: i - lit, copyLen: l, dist: d})
+		for j := i + 1; j < i+l; j++ {
+			m.insert(j)
+		}
+		i += l
+		lit = i
+	}
+	if lit < end {
+		cmds = append(cmds, command{insertLen: end - lit})
+	}
+	return cmds
+}
+
+// lengthCodeOf returns the code of table covering n.
+func lengthCodeOf(table *[24]lengthCode, n int) int {
+	c := 0
+	for c+1 < len(table) && int(table[c+1].base) <= n {
+		c++
+	}
+	return c
+}
+
+// commandCode returns the insert-and-copy length code of an insert and
+// copy length code pair. implicit selects the codes that reuse the last
+// distance without a distance code, if the pair has one.
+func commandCode(ins, cp int, implicit bool) int {
+	if implicit && ins < 8 && cp < 16 {
+		return cp>>3<<6 | ins<<3 | cp&7
+	}
+	cell := slices.Index(commandCells[:], [2]int{ins &^ 7, cp &^ 7})
+	return 128 + cell<<6 | (ins&7)<<3 | cp&7
+}
+
+// distanceCode returns the distance code and extra bits of a distance
+// with NPOSTFIX and NDIRECT 0.
+func distanceCode(dist int) (code int, extra uint64, nbits uint) {
+	v := dist + 3
+	nbits = uint(bits.Len(uint(v)) - 2)
+	prefix := v >> nbits & 1
+	return 16 + 2*(int(nbits)-1) + prefix, uint64(v - (2+prefix)<<nbits), nbits
+}
+
+// symbols are the prefix-coded values of a command.
+type symbols struct {
+	cmd       command
+	code      int
+	distCode  int // -1 if no distance code is written
+	distExtra uint64
+	distBits  uint
+}
+
+type encoder struct {
+	w        bitWriter
+	data     []byte
+	m        *matcher
+	lastDist int
+}
+
+// Encode compresses data as a Brotli stream.
+func Encode(data []byte) []byte {
+	wbits := uint(16)
+	for wbits < 24 && maxDistance(wbits) < len(data) {
+		wbits++
+	}
+	e := &encoder{data: data, lastDist: 4}
+	writeWindowBits(&e.w, wbits)
+	if len(data) == 0 {
+		// ISLAST, ISLASTEMPTY
+		e.w.writeBits(3, 2)
+		e.w.align()
+		return e.w.buf
+	}
+	e.m = newMatcher(data, maxDistance(wbits))
+	for start := 0; start < len(data); start += maxMetaBlockLength {
+		end := min(start+maxMetaBlockLength, len(data))
+		e.metaBlock(start, end)
+	}
+	e.w.align()
+	if stored := storeUncompressed(data, wbits); len(stored) < len(e.w.buf) {
+		return stored
+	}
+	return e.w.buf
+}
+
+func writeWindowBits(w *bitWriter, wbits uint) {
+	switch {
+	case wbits == 16:
+		w.writeBits(0, 1)
+	case wbits == 17:
+		w.writeBits(1, 7)
+	default:
+		w.writeBits(uint64(1|(wbits-17)<<1), 4)
+	}
+}
+
+// writeMetaBlockHeader writes ISLAST, MNIBBLES and MLEN-1.
+func (w *bitWriter) writeMetaBlockHeader(mlen int, last bool) {
+	if last {
+		// ISLAST, not ISLASTEMPTY
+		w.writeBits(1, 2)
+	} else {
+		w.writeBits(0, 1)
+	}
+	nibbles := uint(4)
+	for mlen-1 >= 1<<(4*nibbles) {
+		nibbles++
+	}
+	w.writeBits(uint64(nibbles-4), 2)
+	w.writeBits(uint64(mlen-1), 4*nibbles)
+}
+
+// storeUncompressed returns data as a stream of uncompressed
+// meta-blocks.
+func storeUncompressed(data []byte, wbits uint) []byte {
+	w := &bitWriter{}
+	writeWindowBits(w, wbits)
+	for start := 0; start < len(data); start += maxMetaBlockLength {
+		end := min(start+maxMetaBlockLength, len(data))
+		w.writeMetaBlockHeader(end-start, false)
+		// ISUNCOMPRESSED
+		w.writeBits(1, 1)
+		w.align()
+		w.buf = append(w.buf, data[start:end]...)
+	}
+	w.writeBits(3, 2)
+	w.align()
+	return w.buf
+}
+
+// metaBlock writes data[start:end] as a compressed meta-block with one
+// block type and one prefix code per category.
+func (e *encoder) metaBlock(start, end int) {
+	cmds := e.m.commands(start, end)
+	syms := make([]symbols, len(cmds))
+	litFreq := make([]uint32, 256)
+	cmdFreq := make([]uint32, numCommandSymbols)
+	distFreq := make([]uint32, 64)
+	pos := start
+	for i, c := range cmds {
+		for _, b := range e.data[pos : pos+c.insertLen] {
+			litFreq[b]++
+		}
+		pos += c.insertLen + c.copyLen
+		s := symbols{cmd: c, distCode: -1}
+		ins := lengthCodeOf(&insertLengthCodes, c.insertLen)
+		switch {
+		case c.copyLen == 0:
+			// The meta-block ends before the copy
+			s.code = commandCode(ins, 0, true)
+		case c.dist == e.lastDist:
+			s.code = commandCode(ins, lengthCodeOf(&copyLengthCodes, c.copyLen), true)
+			if s.code >= 128 {
+				s.distCode = 0
+			}
+		default:
+			s.code = commandCode(ins, lengthCodeOf(&copyLengthCodes, c.copyLen), false)
+			s.distCode, s.distExtra, s.distBits = distanceCode(c.dist)
+			e.lastDist = c.dist
+		}
+		cmdFreq[s.code]++
+		if s.distCode >= 0 {
+			distFreq[s.distCode]++
+		}
+		syms[i] = s
+	}
+	litCode := newPrefixCode(litFreq, 15)
+	cmdCode := newPrefixCode(cmdFreq, 15)
+	distCode := newPrefixCode(distFreq, 15)
+
+	w := &e.w
+	w.writeMetaBlockHeader(end-start, end == len(e.data))
+	if end != len(e.data) {
+		// ISUNCOMPRESSED
+		w.writeBits(0, 1)
+	}
+	// NBLTYPESL, NBLTYPESI and NBLTYPESD of 1, NPOSTFIX and NDIRECT of 0,
+	// the LSB6 context mode and NTREESL and NTREESD of 1
+	w.writeBits(0, 3+6+2+2)
+	w.writePrefixCode(litCode, 256)
+	w.writePrefixCode(cmdCode, numCommandSymbols)
+	w.writePrefixCode(distCode, 64)
+
+	pos = start
+	for _, s := range syms {
+		w.writeSymbol(cmdCode, s.code)
+		ins := insertLengthCodes[lengthCodeOf(&insertLengthCodes, s.cmd.insertLen)]
+		w.writeBits(uint64(s.cmd.insertLen-int(ins.base)), uint(ins.nbits))
+		if s.cmd.copyLen > 0 {
+			cp := copyLengthCodes[lengthCodeOf(&copyLengthCodes, s.cmd.copyLen)]
+			w.writeBits(uint64(s.cmd.copyLen-int(cp.base)), uint(cp.nbits))
+		}
+		for _, b := range e.data[pos : pos+s.cmd.insertLen] {
+			w.writeSymbol(litCode, int(b))
+		}
+		pos += s.cmd.insertLen + s.cmd.copyLen
+		if s.distCode >= 0 {
+			w.writeSymbol(distCode, s.distCode)
+			w.writeBits(s.distExtra, s.distBits)
+		}
+	}
+}
@@ -30,6 +30,7 @@ func FindTestFont(name string) string {
 		filepath.Join(moduleRoot, "testdata", name),
 		filepath.Join(moduleRoot, "ot", "testdata", name),
 		filepath.Join(moduleRoot, "subset", "testdata", name),
+		filepath.Join(moduleRoot, "harfbuzz-tests", "fonts", name),
 	}
 
 	for _, p := range fallbacks {
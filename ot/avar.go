@@ -145,3 +145,29 @@ func (a *Avar) MapCoords(coords []int) []int {
 	}
 	return result
 }
+
+// AxisValueMap is one entry of an avar segment map, in F2DOT14.
+type AxisValueMap struct {
+	FromCoord, ToCoord int16
+}
+
+// SegmentMap returns the segment map of an axis.
+func (a *Avar) SegmentMap(axisIndex int) []AxisValueMap {
+	if a == nil || axisIndex < 0 || axisIndex >= a.axisCount {
+		return nil
+	}
+	segments := a.axisMaps[axisIndex].segments
+	maps := make([]AxisValueMap, len(segments))
+	for i, s := range segments {
+		maps[i] = AxisValueMap{FromCoord: s.fromCoord, ToCoord: s.toCoord}
+	}
+	return maps
+}
+
+// AxisCount returns the number of axes with a segment map.
+func (a *Avar) AxisCount() int {
+	if a == nil {
+		return 0
+	}
+	return a.axisCount
+}
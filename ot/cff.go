@@ -19,14 +19,16 @@ type CFF struct {
 	LocalSubrs  [][]byte  // Local subroutines
 	Charset     []GlyphID // Glyph ID to SID mapping
 
-	FDArray     []FontDict
-	FDSelect    []byte
+	FDArray     []FontDict // Font DICTs (CID fonts)
+	FDSelect    []byte     // Font DICT index per glyph (CID fonts)
 	PrivateDict PrivateDict
 	TopDict     TopDict
 	header      cffHeader
 
 	// CID fonts
 	IsCID bool
+
+	cidToGID map[int]GlyphID // from Charset, built once for CID fonts
 }
 
 type cffHeader struct {
@@ -51,6 +53,7 @@ type TopDict struct {
 
 	// CID fonts
 	ROS      [3]int // Registry, Ordering, Supplement (SIDs)
+	CIDCount int
 	FDArray  int
 	FDSelect int
 	IsCID    bool
@@ -76,7 +79,10 @@ type PrivateDict struct {
 
 // FontDict contains per-font dictionary data (for CID fonts).
 type FontDict struct {
-	Private [2]int // [size, offset]
+	FontName    int    // SID
+	Private     [2]int // [size, offset]
+	PrivateDict PrivateDict
+	LocalSubrs  [][]byte // Local subroutines of this Font DICT
 }
 
 // ParseCFF parses a CFF table from raw data.
@@ -154,25 +160,16 @@ func ParseCFF(data []byte) (*CFF, error) {
 
 	// Parse Private DICT
 	if cff.TopDict.Private[0] > 0 && cff.TopDict.Private[1] > 0 {
-		privOffset := cff.TopDict.Private[1]
-		privSize := cff.TopDict.Private[0]
-		if privOffset+privSize <= len(data) {
-			cff.PrivateDict, err = parsePrivateDict(data[privOffset : privOffset+privSize])
-			if err != nil {
-				return nil, fmt.Errorf("CFF: parsing Private DICT: %w", err)
-			}
+		cff.PrivateDict, cff.LocalSubrs, err = parsePrivate(data, cff.TopDict.Private)
+		if err != nil {
+			return nil, fmt.Errorf("CFF: parsing Private DICT: %w", err)
+		}
+	}
 
-			// Parse Local Subrs (offset relative to Private DICT)
-			if cff.PrivateDict.Subrs > 0 {
-				localSubrsOffset := privOffset + cff.PrivateDict.Subrs
-				if localSubrsOffset < len(data) {
-					cff.LocalSubrs, _, err = parseINDEX(data[localSubrsOffset:])
-					if err != nil {
-						// Not fatal - some fonts don't have local subrs
-						cff.LocalSubrs = nil
-					}
-				}
-			}
+	// CID fonts have a Private DICT per Font DICT
+	if cff.IsCID {
+		if err := cff.parseFDArray(); err != nil {
+			return nil, err
 		}
 	}
 
@@ -187,10 +184,158 @@ func ParseCFF(data []byte) (*CFF, error) {
 			}
 		}
 	}
+	if cff.IsCID && len(cff.Charset) > 0 {
+		cff.cidToGID = make(map[int]GlyphID, len(cff.Charset))
+		for gid := len(cff.Charset) - 1; gid >= 0; gid-- {
+			cff.cidToGID[int(cff.Charset[gid])] = GlyphID(gid)
+		}
+	}
 
 	return cff, nil
 }
 
+// parsePrivate parses the Private DICT at private ([size, offset]) and
+// its Local Subrs.
+func parsePrivate(data []byte, private [2]int) (PrivateDict, [][]byte, error) {
+	privSize, privOffset := private[0], private[1]
+	if privOffset < 0 || privSize < 0 || privOffset+privSize > len(data) {
+		return PrivateDict{}, nil, nil
+	}
+	pd, err := parsePrivateDict(data[privOffset : privOffset+privSize])
+	if err != nil {
+		return pd, nil, err
+	}
+
+	// Parse Local Subrs (offset relative to Private DICT)
+	var localSubrs [][]byte
+	if pd.Subrs > 0 {
+		localSubrsOffset := privOffset + pd.Subrs
+		if localSubrsOffset < len(data) {
+			localSubrs, _, err = parseINDEX(data[localSubrsOffset:])
+			if err != nil {
+				// Not fatal - some fonts don't have local subrs
+				localSubrs = nil
+			}
+		}
+	}
+	return pd, localSubrs, nil
+}
+
+// parseFDArray parses the Font DICTs and the FDSelect of a CID font.
+func (c *CFF) parseFDArray() error {
+	if c.TopDict.FDArray <= 0 || c.TopDict.FDArray >= len(c.data) {
+		return errors.New("CFF: CID font without FDArray")
+	}
+	fontDicts, _, err := parseINDEX(c.data[c.TopDict.FDArray:])
+	if err != nil {
+		return fmt.Errorf("CFF: parsing FDArray INDEX: %w", err)
+	}
+	c.FDArray = make([]FontDict, len(fontDicts))
+	for i, fd := range fontDicts {
+		c.FDArray[i] = parseFontDict(fd)
+		c.FDArray[i].PrivateDict, c.FDArray[i].LocalSubrs, err = parsePrivate(c.data, c.FDArray[i].Private)
+		if err != nil {
+			return fmt.Errorf("CFF: parsing Private DICT of Font DICT %d: %w", i, err)
+		}
+	}
+
+	if c.TopDict.FDSelect > 0 {
+		c.FDSelect, err = parseFDSelect(c.data, c.TopDict.FDSelect, len(c.CharStrings))
+		if err != nil {
+			return fmt.Errorf("CFF: parsing FDSelect: %w", err)
+		}
+	}
+	for gid, fd := range c.FDSelect {
+		if int(fd) >= len(c.FDArray) {
+			return fmt.Errorf("CFF: glyph %d uses Font DICT %d of %d", gid, fd, len(c.FDArray))
+		}
+	}
+	return nil
+}
+
+// parseFontDict parses a Font DICT of the FDArray.
+func parseFontDict(data []byte) FontDict {
+	var dict FontDict
+	operands := make([]int, 0, 16)
+	pos := 0
+
+	for pos < len(data) {
+		b := data[pos]
+
+		// Operand
+		if b >= 32 && b <= 254 || b == 28 || b == 29 || b == 30 {
+			val, consumed := decodeDictOperand(data[pos:])
+			operands = append(operands, val)
+			pos += consumed
+			continue
+		}
+
+		// Operator
+		op := int(b)
+		pos++
+		if b == 12 && pos < len(data) {
+			op = 12<<8 | int(data[pos])
+			pos++
+		}
+
+		switch op {
+		case dictFontName:
+			if len(operands) > 0 {
+				dict.FontName = operands[len(operands)-1]
+			}
+		case dictPrivate:
+			if len(operands) >= 2 {
+				dict.Private[0] = operands[len(operands)-2] // size
+				dict.Private[1] = operands[len(operands)-1] // offset
+			}
+		}
+
+		operands = operands[:0]
+	}
+
+	return dict
+}
+
+// parseFDSelect parses the FDSelect table (formats 0 and 3) into a Font
+// DICT index per glyph.
+func parseFDSelect(data []byte, offset int, numGlyphs int) ([]byte, error) {
+	if offset >= len(data) {
+		return nil, errors.New("FDSelect offset out of bounds")
+	}
+	fds := make([]byte, numGlyphs)
+	pos := offset + 1
+
+	switch format := data[offset]; format {
+	case 0:
+		if pos+numGlyphs > len(data) {
+			return nil, errors.New("FDSelect format 0 truncated")
+		}
+		copy(fds, data[pos:pos+numGlyphs])
+	case 3:
+		if pos+2 > len(data) {
+			return nil, errors.New("FDSelect format 3 truncated")
+		}
+		nRanges := int(binary.BigEndian.Uint16(data[pos:]))
+		pos += 2
+		if pos+nRanges*3+2 > len(data) {
+			return nil, errors.New("FDSelect format 3 truncated")
+		}
+		for i := 0; i < nRanges; i++ {
+			first := int(binary.BigEndian.Uint16(data[pos:]))
+			fd := data[pos+2]
+			// The next range's first glyph, or the sentinel
+			end := int(binary.BigEndian.Uint16(data[pos+3:]))
+			for gid := first; gid < end && gid < numGlyphs; gid++ {
+				fds[gid] = fd
+			}
+			pos += 3
+		}
+	default:
+		return nil, fmt.Errorf("unsupported FDSelect format %d", format)
+	}
+	return fds, nil
+}
+
 // parseINDEX parses a CFF INDEX structure.
 // Returns the data items and bytes consumed.
 func parseINDEX(data []byte) ([][]byte, int, error) {
@@ -336,6 +481,13 @@ func parseTopDict(data []byte) (TopDict, error) {
 				dict.ROS[1] = operands[len(operands)-2]
 				dict.ROS[2] = operands[len(operands)-1]
 				dict.IsCID = true
+				if dict.CIDCount == 0 {
+					dict.CIDCount = 8720 // Default
+				}
+			}
+		case dictCIDCount:
+			if len(operands) > 0 {
+				dict.CIDCount = operands[len(operands)-1]
 			}
 		case dictFDArray:
 			if len(operands) > 0 {
@@ -577,6 +729,68 @@ func (c *CFF) NumGlyphs() int {
 	return len(c.CharStrings)
 }
 
+// FDIndex returns the index into FDArray of the Font DICT of a glyph in
+// a CID font. It returns 0 for other fonts.
+func (c *CFF) FDIndex(glyph GlyphID) int {
+	if int(glyph) < len(c.FDSelect) {
+		return int(c.FDSelect[glyph])
+	}
+	return 0
+}
+
+// GlyphLocalSubrs returns the Local Subrs that the CharString of a glyph
+// calls: those of its Font DICT in a CID font, otherwise LocalSubrs.
+func (c *CFF) GlyphLocalSubrs(glyph GlyphID) [][]byte {
+	if !c.IsCID {
+		return c.LocalSubrs
+	}
+	if fd := c.FDIndex(glyph); fd < len(c.FDArray) {
+		return c.FDArray[fd].LocalSubrs
+	}
+	return nil
+}
+
+// CIDForGlyph returns the CID of a glyph in a CID font. The charset of
+// a CID font maps glyph IDs to CIDs instead of SIDs. Returns (0, false)
+// for fonts that are not CID-keyed or invalid glyphs.
+func (c *CFF) CIDForGlyph(glyph GlyphID) (int, bool) {
+	if !c.IsCID || int(glyph) >= len(c.CharStrings) {
+		return 0, false
+	}
+	if int(glyph) >= len(c.Charset) {
+		// No charset: CIDs equal glyph IDs
+		return int(glyph), len(c.Charset) == 0
+	}
+	return int(c.Charset[glyph]), true
+}
+
+// GetGlyphFromCID returns the glyph ID for a given CID. This is the
+// CIDToGIDMap needed for PDF CIDFontType0 fonts.
+// Returns (0, false) if the CID is not in the font.
+func (c *CFF) GetGlyphFromCID(cid int) (GlyphID, bool) {
+	if !c.IsCID {
+		return 0, false
+	}
+	if len(c.Charset) == 0 {
+		if cid >= 0 && cid < len(c.CharStrings) {
+			return GlyphID(cid), true
+		}
+		return 0, false
+	}
+
+	if c.cidToGID != nil {
+		gid, ok := c.cidToGID[cid]
+		return gid, ok
+	}
+	// Charset set by the caller: linear search like GetGlyphFromName
+	for gid, glyphCID := range c.Charset {
+		if int(glyphCID) == cid {
+			return GlyphID(gid), true
+		}
+	}
+	return 0, false
+}
+
 // GetString returns the string for a given SID.
 func (c *CFF) GetString(sid int) string {
 	if sid < cffStdStringCount {
@@ -5,11 +5,18 @@ import (
 	"math"
 )
 
-// cffDrawInterpreter interprets CFF Type 2 CharStrings to produce glyph outlines.
+// cffDrawInterpreter interprets CFF Type 2 CharStrings and streams the
+// resulting path to a Pen.
 // HarfBuzz equivalent: cs_interpreter_t + cff1_cs_opset_t + path_procs_t
 // in hb-cff-interp-cs-common.hh and hb-cff1-interp-cs.hh
 type cffDrawInterpreter struct {
-	segments []Segment
+	pen Pen
+
+	// open is true while a contour is in progress; drawn records
+	// whether anything was emitted at all.
+	// HarfBuzz equivalent: path_open in hb_draw_state_t (hb-draw.hh)
+	open  bool
+	drawn bool
 
 	// Argument stack. HarfBuzz uses double (number_t), we use float64.
 	// HarfBuzz equivalent: arg_stack_t in hb-cff-interp-common.hh:428
@@ -50,31 +57,37 @@ type cffDrawInterpreter struct {
 const cffMaxOps = 200000   // HarfBuzz: HB_CFF_MAX_OPS
 const cffMaxCallDepth = 10 // HarfBuzz: kMaxCallLimit
 
-// cffGlyphOutline extracts the outline for a CFF glyph.
+// cffDrawGlyph streams the outline of a CFF glyph to pen. CFF has no
+// explicit closepath; every contour is closed with pen.Close() before
+// the next moveto and at the end of the charstring. Returns false if the
+// glyph is empty or the charstring is invalid (in which case pen may
+// have received a partial path).
 // HarfBuzz equivalent: OT::cff1::accelerator_t::get_path in hb-ot-cff1-table.cc:444-564
-func cffGlyphOutline(cff *CFF, gid GlyphID) (GlyphOutline, bool) {
+func cffDrawGlyph(cff *CFF, gid GlyphID, pen Pen) bool {
 	if int(gid) >= len(cff.CharStrings) {
-		return GlyphOutline{}, false
+		return false
 	}
 	cs := cff.CharStrings[gid]
 	if len(cs) == 0 {
-		return GlyphOutline{}, false
+		return false
 	}
 
+	localSubrs := cff.GlyphLocalSubrs(gid)
 	interp := cffDrawInterpreter{
+		pen:         pen,
 		stack:       make([]float64, 0, 48),
 		globalSubrs: cff.GlobalSubrs,
-		localSubrs:  cff.LocalSubrs,
+		localSubrs:  localSubrs,
 		globalBias:  calcSubrBias(len(cff.GlobalSubrs)),
-		localBias:   calcSubrBias(len(cff.LocalSubrs)),
+		localBias:   calcSubrBias(len(localSubrs)),
 	}
 
 	interp.execute(cs)
-
-	if interp.err || len(interp.segments) == 0 {
-		return GlyphOutline{}, false
+	if interp.open {
+		pen.Close()
 	}
-	return GlyphOutline{Segments: interp.segments}, true
+
+	return !interp.err && interp.drawn
 }
 
 // argCount returns the number of arguments on the stack (accounting for arg_start).
@@ -511,33 +524,27 @@ func (di *cffDrawInterpreter) execute(data []byte) {
 	}
 }
 
-// emitMoveTo adds a MoveTo segment at the current point.
+// emitMoveTo closes the open contour and starts a new one at the
+// current point.
+// HarfBuzz equivalent: PATH::moveto in cff1_path_procs_path_t (hb-ot-cff1-table.cc:493-497)
 func (di *cffDrawInterpreter) emitMoveTo() {
-	di.segments = append(di.segments, Segment{
-		Op:   SegmentMoveTo,
-		Args: [3]OutlinePoint{{float32(di.x), float32(di.y)}},
-	})
+	if di.open {
+		di.pen.Close()
+	}
+	di.pen.MoveTo(float32(di.x), float32(di.y))
+	di.open = true
+	di.drawn = true
 }
 
-// emitLineTo adds a LineTo segment at the current point.
+// emitLineTo draws a line to the current point.
 func (di *cffDrawInterpreter) emitLineTo() {
-	di.segments = append(di.segments, Segment{
-		Op:   SegmentLineTo,
-		Args: [3]OutlinePoint{{float32(di.x), float32(di.y)}},
-	})
+	di.pen.LineTo(float32(di.x), float32(di.y))
 }
 
-// emitCubicTo adds a CubeTo segment and updates the current point.
+// emitCubicTo draws a cubic curve and updates the current point.
 // HarfBuzz equivalent: PATH::curve in cff1_path_procs_path_t (hb-ot-cff1-table.cc:504-511)
 func (di *cffDrawInterpreter) emitCubicTo(pt1x, pt1y, pt2x, pt2y, pt3x, pt3y float64) {
-	di.segments = append(di.segments, Segment{
-		Op: SegmentCubeTo,
-		Args: [3]OutlinePoint{
-			{float32(pt1x), float32(pt1y)},
-			{float32(pt2x), float32(pt2y)},
-			{float32(pt3x), float32(pt3y)},
-		},
-	})
+	di.pen.CubicTo(float32(pt1x), float32(pt1y), float32(pt2x), float32(pt2y), float32(pt3x), float32(pt3y))
 	di.x = pt3x
 	di.y = pt3y
 }
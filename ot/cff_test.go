@@ -0,0 +1,72 @@
+package ot
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseCFFCIDKeyed(t *testing.T) {
+	// NotoSansCJK subset with four glyphs in four Font DICTs
+	data, err := os.ReadFile("../harfbuzz-tests/fonts/4cbbc461be066fccc611dcc634af6e8cb2705537.ttf")
+	if err != nil {
+		t.Skip("CID font not found:", err)
+	}
+	font, err := ParseFont(data, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse font: %v", err)
+	}
+	cffData, err := font.TableData(TagCFF)
+	if err != nil {
+		t.Fatalf("TableData: %v", err)
+	}
+	cff, err := ParseCFF(cffData)
+	if err != nil {
+		t.Fatalf("ParseCFF: %v", err)
+	}
+
+	if !cff.IsCID {
+		t.Fatal("font is not CID-keyed")
+	}
+	if got := cff.GetString(cff.TopDict.ROS[0]) + "-" + cff.GetString(cff.TopDict.ROS[1]); got != "Adobe-Identity" {
+		t.Errorf("ROS %q, want Adobe-Identity", got)
+	}
+	if cff.TopDict.CIDCount != 65535 {
+		t.Errorf("CIDCount %d, want 65535", cff.TopDict.CIDCount)
+	}
+
+	wantFDs := []string{
+		"NotoSansCJK-Regular-Alphabetic",
+		"NotoSansCJK-Regular-Generic",
+		"NotoSansCJK-Regular-HWidth",
+		"NotoSansCJK-Regular-Proportional",
+	}
+	if len(cff.FDArray) != len(wantFDs) {
+		t.Fatalf("%d Font DICTs, want %d", len(cff.FDArray), len(wantFDs))
+	}
+	for i, fd := range cff.FDArray {
+		if name := cff.GetString(fd.FontName); name != wantFDs[i] {
+			t.Errorf("Font DICT %d is %q, want %q", i, name, wantFDs[i])
+		}
+	}
+
+	for gid, want := range []struct{ fd, cid int }{{1, 0}, {3, 57}, {0, 59047}, {2, 63095}} {
+		if fd := cff.FDIndex(GlyphID(gid)); fd != want.fd {
+			t.Errorf("glyph %d in Font DICT %d, want %d", gid, fd, want.fd)
+		}
+		if cid, ok := cff.CIDForGlyph(GlyphID(gid)); !ok || cid != want.cid {
+			t.Errorf("CIDForGlyph(%d) = %d, %v, want %d", gid, cid, ok, want.cid)
+		}
+		if g, ok := cff.GetGlyphFromCID(want.cid); !ok || g != GlyphID(gid) {
+			t.Errorf("GetGlyphFromCID(%d) = %d, %v, want %d", want.cid, g, ok, gid)
+		}
+	}
+	if _, ok := cff.GetGlyphFromCID(1); ok {
+		t.Errorf("GetGlyphFromCID(1) found a glyph")
+	}
+	if n := len(cff.GlyphLocalSubrs(1)); n != 1 {
+		t.Errorf("glyph 1 has %d Local Subrs, want 1", n)
+	}
+	if cff.GetGlyphName(1) != "" {
+		t.Errorf("CID font glyph has a name")
+	}
+}
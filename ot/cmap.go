@@ -154,6 +154,13 @@ func (c *Cmap) IsSymbol() bool {
 // For Symbol fonts, applies PUA mapping based on font page.
 // Source: HarfBuzz hb-ot-cmap-table.hh accelerator_t constructor
 func (c *Cmap) Lookup(cp Codepoint) (GlyphID, bool) {
+	return c.subtable.Lookup(c.SubtableCodepoint(cp))
+}
+
+// SubtableCodepoint returns the code under which Lookup searches cp in
+// the cmap subtable. For Symbol fonts this is the PUA code for the font
+// page, if there is one; otherwise it is cp.
+func (c *Cmap) SubtableCodepoint(cp Codepoint) Codepoint {
 	if c.isSymbol {
 		// Apply PUA mapping for symbol fonts
 		var mapped Codepoint
@@ -170,11 +177,11 @@ func (c *Cmap) Lookup(cp Codepoint) (GlyphID, bool) {
 			// Fall through to direct lookup
 		}
 		if mapped != 0 {
-			return c.subtable.Lookup(mapped)
+			return mapped
 		}
 		// Fall through to try direct lookup
 	}
-	return c.subtable.Lookup(cp)
+	return cp
 }
 
 // LookupVariation returns the glyph ID for a codepoint with variation selector.
@@ -683,6 +690,63 @@ func (f *cmapFormat14) lookupNonDefault(offset int, cp Codepoint) (GlyphID, bool
 	return GlyphID(gid), true
 }
 
+// VariationSequence is a Unicode Variation Sequence of cmap format 14.
+type VariationSequence struct {
+	Unicode  Codepoint
+	Selector Codepoint
+	// Glyph is the variant glyph; for default sequences it is 0 and the
+	// sequence uses the nominal glyph of Unicode.
+	Glyph   GlyphID
+	Default bool
+}
+
+// CollectVariationSequences returns all variation sequences of the
+// format 14 subtable, ordered by selector and codepoint.
+// HarfBuzz equivalent: CmapSubtableFormat14::collect_unicodes and
+// collect_mapping (hb-ot-cmap-table.hh)
+func (c *Cmap) CollectVariationSequences() []VariationSequence {
+	if c.format14 == nil {
+		return nil
+	}
+	f := c.format14
+	var seqs []VariationSequence
+	for _, rec := range f.records {
+		vs := Codepoint(rec.varSelector)
+		var defaults, variants []VariationSequence
+		if off := int(rec.defaultUVSOff); off != 0 && off+4 <= len(f.data) {
+			numRanges := int(binary.BigEndian.Uint32(f.data[off:]))
+			for i := 0; i < numRanges && off+8+i*4 <= len(f.data); i++ {
+				r := off + 4 + i*4
+				start := Codepoint(f.data[r])<<16 | Codepoint(f.data[r+1])<<8 | Codepoint(f.data[r+2])
+				for cp := start; cp <= start+Codepoint(f.data[r+3]); cp++ {
+					defaults = append(defaults, VariationSequence{Unicode: cp, Selector: vs, Default: true})
+				}
+			}
+		}
+		if off := int(rec.nonDefaultUVSOff); off != 0 && off+4 <= len(f.data) {
+			numMappings := int(binary.BigEndian.Uint32(f.data[off:]))
+			for i := 0; i < numMappings && off+9+i*5 <= len(f.data); i++ {
+				m := off + 4 + i*5
+				cp := Codepoint(f.data[m])<<16 | Codepoint(f.data[m+1])<<8 | Codepoint(f.data[m+2])
+				gid := GlyphID(binary.BigEndian.Uint16(f.data[m+3:]))
+				variants = append(variants, VariationSequence{Unicode: cp, Selector: vs, Glyph: gid})
+			}
+		}
+
+		// Merge both lists by codepoint
+		for len(defaults) > 0 || len(variants) > 0 {
+			if len(variants) == 0 || len(defaults) > 0 && defaults[0].Unicode <= variants[0].Unicode {
+				seqs = append(seqs, defaults[0])
+				defaults = defaults[1:]
+			} else {
+				seqs = append(seqs, variants[0])
+				variants = variants[1:]
+			}
+		}
+	}
+	return seqs
+}
+
 // --- Cmap Collection Methods (HarfBuzz-style) ---
 
 // cmapCollector is the interface for collecting cmap mappings.
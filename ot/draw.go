@@ -0,0 +1,477 @@
+package ot
+
+import (
+	"math"
+	"strconv"
+)
+
+// Streaming outline drawing.
+//
+// HarfBuzz equivalent: hb_draw_funcs_t / hb_draw_session_t (hb-draw.h,
+// hb-draw.hh). The glyf path builder and the CFF charstring interpreter
+// call a Pen directly instead of building a []Segment, so callers that
+// emit paths (PDF content streams, SVG, rasterizers) do not pay for an
+// intermediate GlyphOutline per glyph. Face.GlyphOutline is itself
+// implemented as a recording pen on top of Face.DrawGlyph.
+//
+// Coordinates are in font units with the y axis pointing up.
+
+// Pen receives the drawing operations of a glyph outline.
+//
+// Every contour starts with MoveTo and ends with Close. Close implies a
+// straight line back to the contour's start point if the current point
+// differs from it; TrueType contours carry that line explicitly, CFF
+// contours do not.
+type Pen interface {
+	MoveTo(x, y float32)
+	LineTo(x, y float32)
+	QuadTo(cx, cy, x, y float32)
+	CubicTo(c1x, c1y, c2x, c2y, x, y float32)
+	Close()
+}
+
+// DrawFuncs adapts a set of callbacks to the Pen interface, in the
+// spirit of hb_draw_funcs_t. Nil callbacks are skipped.
+type DrawFuncs struct {
+	MoveToFunc  func(x, y float32)
+	LineToFunc  func(x, y float32)
+	QuadToFunc  func(cx, cy, x, y float32)
+	CubicToFunc func(c1x, c1y, c2x, c2y, x, y float32)
+	CloseFunc   func()
+}
+
+// MoveTo implements Pen.
+func (d *DrawFuncs) MoveTo(x, y float32) {
+	if d.MoveToFunc != nil {
+		d.MoveToFunc(x, y)
+	}
+}
+
+// LineTo implements Pen.
+func (d *DrawFuncs) LineTo(x, y float32) {
+	if d.LineToFunc != nil {
+		d.LineToFunc(x, y)
+	}
+}
+
+// QuadTo implements Pen.
+func (d *DrawFuncs) QuadTo(cx, cy, x, y float32) {
+	if d.QuadToFunc != nil {
+		d.QuadToFunc(cx, cy, x, y)
+	}
+}
+
+// CubicTo implements Pen.
+func (d *DrawFuncs) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	if d.CubicToFunc != nil {
+		d.CubicToFunc(c1x, c1y, c2x, c2y, x, y)
+	}
+}
+
+// Close implements Pen.
+func (d *DrawFuncs) Close() {
+	if d.CloseFunc != nil {
+		d.CloseFunc()
+	}
+}
+
+// outlineRecorder is the Pen behind Face.GlyphOutline. GlyphOutline has
+// no close operation, so Close is dropped.
+type outlineRecorder struct {
+	segments []Segment
+}
+
+func (r *outlineRecorder) MoveTo(x, y float32) {
+	r.segments = append(r.segments, Segment{Op: SegmentMoveTo, Args: [3]OutlinePoint{{x, y}}})
+}
+
+func (r *outlineRecorder) LineTo(x, y float32) {
+	r.segments = append(r.segments, Segment{Op: SegmentLineTo, Args: [3]OutlinePoint{{x, y}}})
+}
+
+func (r *outlineRecorder) QuadTo(cx, cy, x, y float32) {
+	r.segments = append(r.segments, Segment{Op: SegmentQuadTo, Args: [3]OutlinePoint{{cx, cy}, {x, y}}})
+}
+
+func (r *outlineRecorder) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	r.segments = append(r.segments, Segment{Op: SegmentCubeTo, Args: [3]OutlinePoint{{c1x, c1y}, {c2x, c2y}, {x, y}}})
+}
+
+func (r *outlineRecorder) Close() {}
+
+// Draw replays the outline into pen. Contours are closed before every
+// MoveTo after the first and at the end.
+func (o GlyphOutline) Draw(pen Pen) {
+	open := false
+	for _, s := range o.Segments {
+		a := s.Args
+		switch s.Op {
+		case SegmentMoveTo:
+			if open {
+				pen.Close()
+			}
+			pen.MoveTo(a[0].X, a[0].Y)
+			open = true
+		case SegmentLineTo:
+			pen.LineTo(a[0].X, a[0].Y)
+		case SegmentQuadTo:
+			pen.QuadTo(a[0].X, a[0].Y, a[1].X, a[1].Y)
+		case SegmentCubeTo:
+			pen.CubicTo(a[0].X, a[0].Y, a[1].X, a[1].Y, a[2].X, a[2].Y)
+		}
+	}
+	if open {
+		pen.Close()
+	}
+}
+
+// AffineMatrix is a 2D affine transform mapping (x, y) to
+// (XX·x + XY·y + DX, YX·x + YY·y + DY).
+type AffineMatrix struct {
+	XX, YX, XY, YY, DX, DY float32
+}
+
+// IdentityMatrix is the identity transform.
+var IdentityMatrix = AffineMatrix{XX: 1, YY: 1}
+
+// Apply transforms a point.
+func (m AffineMatrix) Apply(x, y float32) (float32, float32) {
+	return m.XX*x + m.XY*y + m.DX, m.YX*x + m.YY*y + m.DY
+}
+
+// Multiply returns the transform that applies n first and then m.
+func (m AffineMatrix) Multiply(n AffineMatrix) AffineMatrix {
+	return AffineMatrix{
+		XX: m.XX*n.XX + m.XY*n.YX,
+		YX: m.YX*n.XX + m.YY*n.YX,
+		XY: m.XX*n.XY + m.XY*n.YY,
+		YY: m.YX*n.XY + m.YY*n.YY,
+		DX: m.XX*n.DX + m.XY*n.DY + m.DX,
+		DY: m.YX*n.DX + m.YY*n.DY + m.DY,
+	}
+}
+
+// TransformPen applies an affine transform to every point before
+// forwarding it to Pen. Affine maps preserve Bézier curves, so curve
+// types are passed through unchanged.
+type TransformPen struct {
+	Pen    Pen
+	Matrix AffineMatrix
+}
+
+// MoveTo implements Pen.
+func (t *TransformPen) MoveTo(x, y float32) {
+	t.Pen.MoveTo(t.Matrix.Apply(x, y))
+}
+
+// LineTo implements Pen.
+func (t *TransformPen) LineTo(x, y float32) {
+	t.Pen.LineTo(t.Matrix.Apply(x, y))
+}
+
+// QuadTo implements Pen.
+func (t *TransformPen) QuadTo(cx, cy, x, y float32) {
+	cx, cy = t.Matrix.Apply(cx, cy)
+	x, y = t.Matrix.Apply(x, y)
+	t.Pen.QuadTo(cx, cy, x, y)
+}
+
+// CubicTo implements Pen.
+func (t *TransformPen) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	c1x, c1y = t.Matrix.Apply(c1x, c1y)
+	c2x, c2y = t.Matrix.Apply(c2x, c2y)
+	x, y = t.Matrix.Apply(x, y)
+	t.Pen.CubicTo(c1x, c1y, c2x, c2y, x, y)
+}
+
+// Close implements Pen.
+func (t *TransformPen) Close() {
+	t.Pen.Close()
+}
+
+// BoundsPen computes the exact bounding box of the drawn path, including
+// curve extrema (not merely the control points). The zero value is
+// ready to use.
+type BoundsPen struct {
+	xMin, yMin, xMax, yMax float32
+	x, y                   float32
+	ok                     bool
+}
+
+// Bounds returns the bounding box, or ok == false if nothing was drawn.
+func (b *BoundsPen) Bounds() (xMin, yMin, xMax, yMax float32, ok bool) {
+	return b.xMin, b.yMin, b.xMax, b.yMax, b.ok
+}
+
+// Reset forgets all drawn geometry.
+func (b *BoundsPen) Reset() {
+	*b = BoundsPen{}
+}
+
+func (b *BoundsPen) add(x, y float32) {
+	if !b.ok {
+		b.xMin, b.xMax, b.yMin, b.yMax = x, x, y, y
+		b.ok = true
+		return
+	}
+	b.xMin, b.xMax = min(b.xMin, x), max(b.xMax, x)
+	b.yMin, b.yMax = min(b.yMin, y), max(b.yMax, y)
+}
+
+// MoveTo implements Pen.
+func (b *BoundsPen) MoveTo(x, y float32) {
+	b.add(x, y)
+	b.x, b.y = x, y
+}
+
+// LineTo implements Pen.
+func (b *BoundsPen) LineTo(x, y float32) {
+	b.add(x, y)
+	b.x, b.y = x, y
+}
+
+// QuadTo implements Pen.
+func (b *BoundsPen) QuadTo(cx, cy, x, y float32) {
+	b.add(x, y)
+	// Extremum where B'(t) = 0: t = (p0 - p1) / (p0 - 2p1 + p2).
+	for _, t := range [2]float32{quadExtremum(b.x, cx, x), quadExtremum(b.y, cy, y)} {
+		if t > 0 && t < 1 {
+			mt := 1 - t
+			b.add(mt*mt*b.x+2*mt*t*cx+t*t*x, mt*mt*b.y+2*mt*t*cy+t*t*y)
+		}
+	}
+	b.x, b.y = x, y
+}
+
+func quadExtremum(p0, p1, p2 float32) float32 {
+	d := p0 - 2*p1 + p2
+	if d == 0 {
+		return -1
+	}
+	return (p0 - p1) / d
+}
+
+// CubicTo implements Pen.
+func (b *BoundsPen) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	b.add(x, y)
+	var ts [4]float32
+	n := cubicExtrema(ts[:0], b.x, c1x, c2x, x)
+	n = cubicExtrema(n, b.y, c1y, c2y, y)
+	for _, t := range n {
+		mt := 1 - t
+		a, bb, c, d := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+		b.add(a*b.x+bb*c1x+c*c2x+d*x, a*b.y+bb*c1y+c*c2y+d*y)
+	}
+	b.x, b.y = x, y
+}
+
+// cubicExtrema appends the parameters in (0, 1) where the derivative of
+// the one-dimensional cubic Bézier p0..p3 vanishes.
+func cubicExtrema(ts []float32, p0, p1, p2, p3 float32) []float32 {
+	// B'(t)/3 = a t² + b t + c
+	a := float64(-p0 + 3*p1 - 3*p2 + p3)
+	bb := float64(2 * (p0 - 2*p1 + p2))
+	c := float64(p1 - p0)
+	add := func(t float64) {
+		if t > 0 && t < 1 {
+			ts = append(ts, float32(t))
+		}
+	}
+	if math.Abs(a) < 1e-12 {
+		if bb != 0 {
+			add(-c / bb)
+		}
+		return ts
+	}
+	disc := bb*bb - 4*a*c
+	if disc < 0 {
+		return ts
+	}
+	sq := math.Sqrt(disc)
+	add((-bb + sq) / (2 * a))
+	add((-bb - sq) / (2 * a))
+	return ts
+}
+
+// Close implements Pen.
+func (b *BoundsPen) Close() {}
+
+// Cu2QuPen converts cubic curves to quadratic splines before forwarding
+// them, for writing CFF-derived outlines into glyf. Every cubic is split
+// into the smallest number of equal-parameter pieces whose single-quad
+// approximation stays within Tolerance font units (default 1); this is
+// the error bound used by fontTools' cu2qu for its midpoint
+// approximation.
+type Cu2QuPen struct {
+	Pen       Pen
+	Tolerance float32
+	x, y      float32
+}
+
+// MoveTo implements Pen.
+func (c *Cu2QuPen) MoveTo(x, y float32) {
+	c.Pen.MoveTo(x, y)
+	c.x, c.y = x, y
+}
+
+// LineTo implements Pen.
+func (c *Cu2QuPen) LineTo(x, y float32) {
+	c.Pen.LineTo(x, y)
+	c.x, c.y = x, y
+}
+
+// QuadTo implements Pen.
+func (c *Cu2QuPen) QuadTo(cx, cy, x, y float32) {
+	c.Pen.QuadTo(cx, cy, x, y)
+	c.x, c.y = x, y
+}
+
+// maxCu2QuPieces bounds the number of quads per cubic.
+const maxCu2QuPieces = 64
+
+// CubicTo implements Pen.
+func (c *Cu2QuPen) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	tol := c.Tolerance
+	if tol <= 0 {
+		tol = 1
+	}
+	p0x, p0y := c.x, c.y
+	// The midpoint quad Q = (3(P1+P2) - (P0+P3)) / 4 deviates from the
+	// cubic by at most √3/36 · |P3 - 3P2 + 3P1 - P0|; splitting into n
+	// pieces divides the third difference by n³.
+	dx := float64(x - 3*c2x + 3*c1x - p0x)
+	dy := float64(y - 3*c2y + 3*c1y - p0y)
+	e := math.Sqrt(3) / 36 * math.Hypot(dx, dy)
+	n := int(math.Ceil(math.Cbrt(e / float64(tol))))
+	n = max(1, min(n, maxCu2QuPieces))
+
+	// Derivative at t: 3[(1-t)²(P1-P0) + 2(1-t)t(P2-P1) + t²(P3-P2)].
+	deriv := func(t float32) (float32, float32) {
+		mt := 1 - t
+		return 3 * (mt*mt*(c1x-p0x) + 2*mt*t*(c2x-c1x) + t*t*(x-c2x)),
+			3 * (mt*mt*(c1y-p0y) + 2*mt*t*(c2y-c1y) + t*t*(y-c2y))
+	}
+	point := func(t float32) (float32, float32) {
+		mt := 1 - t
+		a, b, cc, d := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+		return a*p0x + b*c1x + cc*c2x + d*x, a*p0y + b*c1y + cc*c2y + d*y
+	}
+	ax, ay := p0x, p0y
+	h := 1 / float32(n)
+	for i := range n {
+		t0, t1 := float32(i)*h, float32(i+1)*h
+		bx, by := x, y
+		if i < n-1 {
+			bx, by = point(t1)
+		}
+		d0x, d0y := deriv(t0)
+		d1x, d1y := deriv(t1)
+		// Sub-cubic control points.
+		s1x, s1y := ax+d0x*h/3, ay+d0y*h/3
+		s2x, s2y := bx-d1x*h/3, by-d1y*h/3
+		qx := (3*(s1x+s2x) - (ax + bx)) / 4
+		qy := (3*(s1y+s2y) - (ay + by)) / 4
+		c.Pen.QuadTo(qx, qy, bx, by)
+		ax, ay = bx, by
+	}
+	c.x, c.y = x, y
+}
+
+// Close implements Pen.
+func (c *Cu2QuPen) Close() {
+	c.Pen.Close()
+}
+
+// Qu2CuPen converts quadratic curves to the equivalent cubics (exact
+// degree elevation), e.g. for writing glyf outlines into CFF or for
+// consumers that only accept cubics such as PDF content streams.
+type Qu2CuPen struct {
+	Pen  Pen
+	x, y float32
+}
+
+// MoveTo implements Pen.
+func (q *Qu2CuPen) MoveTo(x, y float32) {
+	q.Pen.MoveTo(x, y)
+	q.x, q.y = x, y
+}
+
+// LineTo implements Pen.
+func (q *Qu2CuPen) LineTo(x, y float32) {
+	q.Pen.LineTo(x, y)
+	q.x, q.y = x, y
+}
+
+// QuadTo implements Pen.
+func (q *Qu2CuPen) QuadTo(cx, cy, x, y float32) {
+	q.Pen.CubicTo(
+		q.x+2.0/3.0*(cx-q.x), q.y+2.0/3.0*(cy-q.y),
+		x+2.0/3.0*(cx-x), y+2.0/3.0*(cy-y),
+		x, y)
+	q.x, q.y = x, y
+}
+
+// CubicTo implements Pen.
+func (q *Qu2CuPen) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	q.Pen.CubicTo(c1x, c1y, c2x, c2y, x, y)
+	q.x, q.y = x, y
+}
+
+// Close implements Pen.
+func (q *Qu2CuPen) Close() {
+	q.Pen.Close()
+}
+
+// SVGPathPen writes SVG path data ("M0 0L10 0Q…Z") in the coordinate
+// system it is given; wrap it in a TransformPen to flip the y axis for
+// SVG's y-down user space. The zero value is ready to use.
+type SVGPathPen struct {
+	buf []byte
+}
+
+// String returns the path data written so far.
+func (s *SVGPathPen) String() string {
+	return string(s.buf)
+}
+
+// Reset discards the path data written so far.
+func (s *SVGPathPen) Reset() {
+	s.buf = s.buf[:0]
+}
+
+func (s *SVGPathPen) op(c byte, coords ...float32) {
+	s.buf = append(s.buf, c)
+	for i, v := range coords {
+		if i > 0 {
+			s.buf = append(s.buf, ' ')
+		}
+		s.buf = strconv.AppendFloat(s.buf, float64(v), 'f', -1, 32)
+	}
+}
+
+// MoveTo implements Pen.
+func (s *SVGPathPen) MoveTo(x, y float32) { s.op('M', x, y) }
+
+// LineTo implements Pen.
+func (s *SVGPathPen) LineTo(x, y float32) { s.op('L', x, y) }
+
+// QuadTo implements Pen.
+func (s *SVGPathPen) QuadTo(cx, cy, x, y float32) { s.op('Q', cx, cy, x, y) }
+
+// CubicTo implements Pen.
+func (s *SVGPathPen) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	s.op('C', c1x, c1y, c2x, c2y, x, y)
+}
+
+// Close implements Pen.
+func (s *SVGPathPen) Close() { s.op('Z') }
+
+var (
+	_ Pen = (*DrawFuncs)(nil)
+	_ Pen = (*TransformPen)(nil)
+	_ Pen = (*BoundsPen)(nil)
+	_ Pen = (*Cu2QuPen)(nil)
+	_ Pen = (*Qu2CuPen)(nil)
+	_ Pen = (*SVGPathPen)(nil)
+	_ Pen = (*Rasterizer)(nil)
+)
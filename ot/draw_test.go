@@ -0,0 +1,201 @@
+package ot
+
+import (
+	"math"
+	"testing"
+)
+
+// TestDrawGlyphMatchesOutline replays DrawGlyph through a recorder and
+// compares it with GlyphOutline for a TrueType and a CFF font. The pen
+// must see exactly one Close per MoveTo.
+func TestDrawGlyphMatchesOutline(t *testing.T) {
+	for _, name := range []string{"Roboto-Regular.ttf", "SourceSansPro-Regular.otf"} {
+		t.Run(name, func(t *testing.T) {
+			face, err := NewFace(loadFont(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range "AaBgÄé&@" {
+				gid, ok := face.Cmap().Lookup(Codepoint(r))
+				if !ok {
+					continue
+				}
+				o, ok := face.GlyphOutline(gid)
+				if !ok {
+					t.Errorf("%q: no outline", r)
+					continue
+				}
+				var rec outlineRecorder
+				moves, closes := 0, 0
+				pen := &DrawFuncs{
+					MoveToFunc:  func(x, y float32) { moves++; rec.MoveTo(x, y) },
+					LineToFunc:  rec.LineTo,
+					QuadToFunc:  rec.QuadTo,
+					CubicToFunc: rec.CubicTo,
+					CloseFunc:   func() { closes++ },
+				}
+				if !face.DrawGlyph(gid, pen) {
+					t.Errorf("%q: DrawGlyph = false", r)
+					continue
+				}
+				if moves != closes {
+					t.Errorf("%q: %d MoveTo but %d Close", r, moves, closes)
+				}
+				if len(rec.segments) != len(o.Segments) {
+					t.Fatalf("%q: %d segments, GlyphOutline has %d", r, len(rec.segments), len(o.Segments))
+				}
+				for i := range rec.segments {
+					if rec.segments[i] != o.Segments[i] {
+						t.Fatalf("%q: segment %d = %+v, want %+v", r, i, rec.segments[i], o.Segments[i])
+					}
+				}
+			}
+			space, _ := face.Cmap().Lookup(' ')
+			if face.DrawGlyph(space, &BoundsPen{}) {
+				t.Error("DrawGlyph(space) = true")
+			}
+		})
+	}
+}
+
+func TestGlyphOutlineDrawRoundTrip(t *testing.T) {
+	face, err := NewFace(loadFont(t, "Roboto-Regular.ttf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid, _ := face.Cmap().Lookup('B')
+	o, _ := face.GlyphOutline(gid)
+	var rec outlineRecorder
+	o.Draw(&rec)
+	if len(rec.segments) != len(o.Segments) {
+		t.Fatalf("Draw replayed %d segments, want %d", len(rec.segments), len(o.Segments))
+	}
+}
+
+func TestTransformPen(t *testing.T) {
+	var rec outlineRecorder
+	m := AffineMatrix{XX: 2, YY: -1, DX: 10, DY: 5}
+	p := &TransformPen{Pen: &rec, Matrix: m}
+	p.MoveTo(1, 1)
+	p.QuadTo(2, 3, 4, 5)
+	want := []Segment{
+		{Op: SegmentMoveTo, Args: [3]OutlinePoint{{12, 4}}},
+		{Op: SegmentQuadTo, Args: [3]OutlinePoint{{14, 2}, {18, 0}}},
+	}
+	for i := range want {
+		if rec.segments[i] != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, rec.segments[i], want[i])
+		}
+	}
+	// Multiply applies the right-hand matrix first.
+	scale := AffineMatrix{XX: 2, YY: 2}
+	shift := AffineMatrix{XX: 1, YY: 1, DX: 3}
+	if x, y := shift.Multiply(scale).Apply(1, 1); x != 5 || y != 2 {
+		t.Errorf("shift·scale (1,1) = (%v,%v), want (5,2)", x, y)
+	}
+	if x, y := IdentityMatrix.Apply(7, 8); x != 7 || y != 8 {
+		t.Errorf("identity (7,8) = (%v,%v)", x, y)
+	}
+}
+
+// TestBoundsPen checks that curve extrema, not control points, bound
+// the path.
+func TestBoundsPen(t *testing.T) {
+	var b BoundsPen
+	if _, _, _, _, ok := b.Bounds(); ok {
+		t.Error("empty BoundsPen reports ok")
+	}
+	b.MoveTo(0, 0)
+	b.QuadTo(50, 100, 100, 0) // peak at y = 50
+	b.CubicTo(100, -100, 0, -100, 0, 0)
+	b.Close()
+	xMin, yMin, xMax, yMax, ok := b.Bounds()
+	if !ok {
+		t.Fatal("Bounds ok = false")
+	}
+	near := func(a, b float32) bool { return math.Abs(float64(a-b)) < 0.01 }
+	if !near(xMin, 0) || !near(xMax, 100) || !near(yMax, 50) || !near(yMin, -75) {
+		t.Errorf("bounds = (%v,%v)-(%v,%v), want (0,-75)-(100,50)", xMin, yMin, xMax, yMax)
+	}
+
+	// The glyph's exact bounds lie within its control-point box.
+	face, err := NewFace(loadFont(t, "SourceSansPro-Regular.otf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid, _ := face.Cmap().Lookup('o')
+	b.Reset()
+	face.DrawGlyph(gid, &b)
+	xMin, yMin, xMax, yMax, _ = b.Bounds()
+	ext, _ := face.GlyphExtents(gid)
+	if xMin < float32(ext.XMin)-1 || xMax > float32(ext.XMax)+1 ||
+		yMin < float32(ext.YMin)-1 || yMax > float32(ext.YMax)+1 {
+		t.Errorf("BoundsPen (%v,%v)-(%v,%v) outside extents %+v", xMin, yMin, xMax, yMax, ext)
+	}
+}
+
+// TestCu2QuPen converts a quarter circle and checks that every emitted
+// quad stays within tolerance of the cubic.
+func TestCu2QuPen(t *testing.T) {
+	const k = 0.5523 * 1000
+	for _, tol := range []float32{1, 0.1} {
+		var rec outlineRecorder
+		p := &Cu2QuPen{Pen: &rec, Tolerance: tol}
+		p.MoveTo(1000, 0)
+		p.CubicTo(1000, k, k, 1000, 0, 1000)
+		p.Close()
+
+		quads := rec.segments[1:]
+		if len(quads) < 2 {
+			t.Fatalf("tol %v: %d quads", tol, len(quads))
+		}
+		prev := OutlinePoint{1000, 0}
+		for _, s := range quads {
+			if s.Op != SegmentQuadTo {
+				t.Fatalf("tol %v: op %d, want QuadTo", tol, s.Op)
+			}
+			// Sample the quad and measure the distance to the circle,
+			// which the cubic approximates to within 0.3 units.
+			for i := 0; i <= 8; i++ {
+				u := float32(i) / 8
+				mu := 1 - u
+				x := mu*mu*prev.X + 2*mu*u*s.Args[0].X + u*u*s.Args[1].X
+				y := mu*mu*prev.Y + 2*mu*u*s.Args[0].Y + u*u*s.Args[1].Y
+				if d := math.Abs(math.Hypot(float64(x), float64(y)) - 1000); d > float64(tol)+0.3 {
+					t.Errorf("tol %v: quad point (%v,%v) off by %v", tol, x, y, d)
+				}
+			}
+			prev = s.Args[1]
+		}
+		if prev != (OutlinePoint{0, 1000}) {
+			t.Errorf("tol %v: spline ends at %v", tol, prev)
+		}
+	}
+}
+
+func TestQu2CuPen(t *testing.T) {
+	var rec outlineRecorder
+	p := &Qu2CuPen{Pen: &rec}
+	p.MoveTo(0, 0)
+	p.QuadTo(30, 60, 90, 0)
+	want := Segment{Op: SegmentCubeTo, Args: [3]OutlinePoint{{20, 40}, {50, 40}, {90, 0}}}
+	if got := rec.segments[1]; got != want {
+		t.Errorf("cubic = %+v, want %+v", got, want)
+	}
+}
+
+func TestSVGPathPen(t *testing.T) {
+	var p SVGPathPen
+	p.MoveTo(0, 0)
+	p.LineTo(10, 0)
+	p.QuadTo(10, 10, 5, 10.5)
+	p.CubicTo(1, 1, 2, 2, 0, 0)
+	p.Close()
+	if got, want := p.String(), "M0 0L10 0Q10 10 5 10.5C1 1 2 2 0 0Z"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	p.Reset()
+	if p.String() != "" {
+		t.Errorf("after Reset String() = %q", p.String())
+	}
+}
@@ -0,0 +1,731 @@
+package ot
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// Implements the EBLC (location) + EBDT (data) + EBSC (scale) table
+// trio for monochrome and grayscale embedded bitmaps.
+//
+// EBLC Spec: https://docs.microsoft.com/en-us/typography/opentype/spec/eblc
+// EBDT Spec: https://docs.microsoft.com/en-us/typography/opentype/spec/ebdt
+// EBSC Spec: https://docs.microsoft.com/en-us/typography/opentype/spec/ebsc
+//
+// HarfBuzz does not rasterize EBDT bitmaps (it only knows the color
+// variant CBDT, see cbdt.go); the reference implementation here is
+// FreeType's sfnt/ttsbit.c (tt_sbit_decoder_*). EBLC has the same
+// BitmapSizeTable layout as CBLC but, unlike our CBLC reader, we
+// support all five IndexSubtable formats because bitmap-only fonts
+// routinely use the metrics-carrying formats 2 and 5.
+//
+// Supported image formats: 1, 2 (small metrics, byte/bit aligned),
+// 5 (metrics in EBLC, bit aligned), 6, 7 (big metrics, byte/bit
+// aligned), 8, 9 (composite glyphs with small/big metrics). Formats 3
+// (obsolete) and 4 (Apple compressed) are not supported. Bit depths 1,
+// 2, 4 and 8 are decoded into an *image.Alpha where 0 is background
+// and 255 is full ink.
+//
+// Apple's bloc/bdat tables share the layout and are used as a fallback
+// when EBLC/EBDT are absent.
+
+// TagEBLC is the OpenType tag for the EBLC table (bitmap location).
+var TagEBLC = MakeTag('E', 'B', 'L', 'C')
+
+// TagEBDT is the OpenType tag for the EBDT table (bitmap data).
+var TagEBDT = MakeTag('E', 'B', 'D', 'T')
+
+// TagEBSC is the OpenType tag for the EBSC table (bitmap scaling).
+var TagEBSC = MakeTag('E', 'B', 'S', 'C')
+
+// Apple's predecessors of EBLC/EBDT with identical layout.
+var (
+	tagBloc = MakeTag('b', 'l', 'o', 'c')
+	tagBdat = MakeTag('b', 'd', 'a', 't')
+)
+
+// SbitLineMetrics holds the line metrics of a bitmap strike in pixels.
+type SbitLineMetrics struct {
+	Ascender              int8
+	Descender             int8
+	WidthMax              uint8
+	CaretSlopeNumerator   int8
+	CaretSlopeDenominator int8
+	CaretOffset           int8
+	MinOriginSB           int8
+	MinAdvanceSB          int8
+	MaxBeforeBL           int8
+	MinAfterBL            int8
+}
+
+func parseSbitLineMetrics(b []byte) SbitLineMetrics {
+	return SbitLineMetrics{
+		Ascender:              int8(b[0]),
+		Descender:             int8(b[1]),
+		WidthMax:              b[2],
+		CaretSlopeNumerator:   int8(b[3]),
+		CaretSlopeDenominator: int8(b[4]),
+		CaretOffset:           int8(b[5]),
+		MinOriginSB:           int8(b[6]),
+		MinAdvanceSB:          int8(b[7]),
+		MaxBeforeBL:           int8(b[8]),
+		MinAfterBL:            int8(b[9]),
+	}
+}
+
+// BigGlyphMetrics holds the metrics of one bitmap glyph in pixels. Small
+// glyph metrics are widened into this type; which direction they
+// describe is recorded in BitmapGlyph.SmallMetrics and the strike flags.
+type BigGlyphMetrics struct {
+	Height       uint8
+	Width        uint8
+	HoriBearingX int8
+	HoriBearingY int8
+	HoriAdvance  uint8
+	VertBearingX int8
+	VertBearingY int8
+	VertAdvance  uint8
+}
+
+// Bitmap strike flags (BitmapSizeTable.flags).
+const (
+	bitmapFlagHorizontal = 0x01
+	bitmapFlagVertical   = 0x02
+)
+
+func parseBigGlyphMetrics(b []byte) BigGlyphMetrics {
+	return BigGlyphMetrics{
+		Height:       b[0],
+		Width:        b[1],
+		HoriBearingX: int8(b[2]),
+		HoriBearingY: int8(b[3]),
+		HoriAdvance:  b[4],
+		VertBearingX: int8(b[5]),
+		VertBearingY: int8(b[6]),
+		VertAdvance:  b[7],
+	}
+}
+
+// parseSmallGlyphMetrics widens a 5-byte SmallGlyphMetrics record. The
+// bearing/advance triple is stored in the vertical fields if the strike
+// is flagged vertical-only, otherwise in the horizontal fields.
+func parseSmallGlyphMetrics(b []byte, flags uint8) BigGlyphMetrics {
+	m := BigGlyphMetrics{Height: b[0], Width: b[1]}
+	if flags&(bitmapFlagHorizontal|bitmapFlagVertical) == bitmapFlagVertical {
+		m.VertBearingX, m.VertBearingY, m.VertAdvance = int8(b[2]), int8(b[3]), b[4]
+	} else {
+		m.HoriBearingX, m.HoriBearingY, m.HoriAdvance = int8(b[2]), int8(b[3]), b[4]
+	}
+	return m
+}
+
+// BitmapComponent is one component of a composite bitmap glyph (image
+// formats 8 and 9). Offsets position the component's top-left corner
+// relative to the composite's top-left corner, in pixels.
+type BitmapComponent struct {
+	GlyphID GlyphID
+	XOffset int8
+	YOffset int8
+}
+
+// BitmapGlyph is a decoded EBDT bitmap.
+type BitmapGlyph struct {
+	// Image holds the glyph coverage; composite glyphs are already
+	// assembled from their components. Nil for empty glyphs (e.g.
+	// space), which still carry metrics.
+	Image *image.Alpha
+	// Components lists the components of a composite glyph (image
+	// formats 8 and 9), nil for simple glyphs.
+	Components []BitmapComponent
+	// Metrics are the glyph metrics in pixels of the returned image.
+	Metrics BigGlyphMetrics
+	// SmallMetrics reports whether the source carried small glyph
+	// metrics (one direction only, see the strike's Flags).
+	SmallMetrics bool
+	// Line holds the horizontal line metrics of the strike.
+	Line        SbitLineMetrics
+	PPEMX       int
+	PPEMY       int
+	BitDepth    int
+	ImageFormat int
+	// Scaled is true if the bitmap was scaled from a substitute strike
+	// named by the EBSC table.
+	Scaled bool
+}
+
+// eblcIndexSubtable is one [firstGlyph, lastGlyph] range of a strike.
+type eblcIndexSubtable struct {
+	// offsets holds glyph_count+1 offsets relative to imageDataOffset
+	// for index formats 1 and 3, and numGlyphs+1 offsets for format 4
+	// (parallel to glyphIDs).
+	offsets []uint32
+	// glyphIDs lists the covered glyphs for the sparse formats 4 and 5.
+	glyphIDs        []GlyphID
+	firstGlyphIndex GlyphID
+	lastGlyphIndex  GlyphID
+	indexFormat     uint16
+	imageFormat     uint16
+	imageDataOffset uint32
+	// imageSize and metrics are set for the constant-size formats 2
+	// and 5.
+	imageSize uint32
+	metrics   BigGlyphMetrics
+}
+
+// eblcStrike is one BitmapSizeTable with its parsed IndexSubtables.
+type eblcStrike struct {
+	subtables  []eblcIndexSubtable
+	Hori       SbitLineMetrics
+	Vert       SbitLineMetrics
+	StartGlyph GlyphID
+	EndGlyph   GlyphID
+	PPEMX      uint8
+	PPEMY      uint8
+	BitDepth   uint8
+	Flags      uint8
+}
+
+// EBLC holds a parsed EBLC (or bloc) table.
+type EBLC struct {
+	strikes []eblcStrike
+	Version uint32
+}
+
+// ParseEBLC parses the EBLC table. The header and BitmapSizeTable
+// layout is identical to CBLC (see ParseCBLC); IndexSubtables of all
+// five formats are materialized.
+func ParseEBLC(data []byte) (*EBLC, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalidTable
+	}
+	e := &EBLC{Version: binary.BigEndian.Uint32(data[0:])}
+	numSizes := binary.BigEndian.Uint32(data[4:])
+	if 8+int(numSizes)*48 > len(data) {
+		return nil, ErrInvalidTable
+	}
+	e.strikes = make([]eblcStrike, numSizes)
+	for i := range int(numSizes) {
+		base := 8 + i*48
+		idxArrOff := int(binary.BigEndian.Uint32(data[base:]))
+		numSubtables := int(binary.BigEndian.Uint32(data[base+8:]))
+		strike := eblcStrike{
+			Hori:       parseSbitLineMetrics(data[base+16:]),
+			Vert:       parseSbitLineMetrics(data[base+28:]),
+			StartGlyph: binary.BigEndian.Uint16(data[base+40:]),
+			EndGlyph:   binary.BigEndian.Uint16(data[base+42:]),
+			PPEMX:      data[base+44],
+			PPEMY:      data[base+45],
+			BitDepth:   data[base+46],
+			Flags:      data[base+47],
+		}
+		if idxArrOff+numSubtables*8 > len(data) {
+			return nil, ErrInvalidTable
+		}
+		for j := range numSubtables {
+			recBase := idxArrOff + j*8
+			sub, ok := parseEBLCIndexSubtable(data, idxArrOff,
+				binary.BigEndian.Uint16(data[recBase:]),
+				binary.BigEndian.Uint16(data[recBase+2:]),
+				int(binary.BigEndian.Uint32(data[recBase+4:])))
+			if !ok {
+				// Unknown or truncated subtable: glyphs in its range
+				// have no bitmap, the rest of the strike is usable.
+				continue
+			}
+			strike.subtables = append(strike.subtables, sub)
+		}
+		e.strikes[i] = strike
+	}
+	return e, nil
+}
+
+// parseEBLCIndexSubtable decodes one IndexSubtable. subOff is relative
+// to the IndexSubtableArray at arrOff.
+func parseEBLCIndexSubtable(data []byte, arrOff int, first, last GlyphID, subOff int) (eblcIndexSubtable, bool) {
+	sub := eblcIndexSubtable{firstGlyphIndex: first, lastGlyphIndex: last}
+	abs := arrOff + subOff
+	if last < first || abs+8 > len(data) {
+		return sub, false
+	}
+	sub.indexFormat = binary.BigEndian.Uint16(data[abs:])
+	sub.imageFormat = binary.BigEndian.Uint16(data[abs+2:])
+	sub.imageDataOffset = binary.BigEndian.Uint32(data[abs+4:])
+	p := abs + 8
+	glyphCount := int(last) - int(first) + 1
+	switch sub.indexFormat {
+	case 1:
+		if p+(glyphCount+1)*4 > len(data) {
+			return sub, false
+		}
+		sub.offsets = make([]uint32, glyphCount+1)
+		for k := range sub.offsets {
+			sub.offsets[k] = binary.BigEndian.Uint32(data[p+k*4:])
+		}
+	case 2:
+		if p+12 > len(data) {
+			return sub, false
+		}
+		sub.imageSize = binary.BigEndian.Uint32(data[p:])
+		sub.metrics = parseBigGlyphMetrics(data[p+4:])
+	case 3:
+		if p+(glyphCount+1)*2 > len(data) {
+			return sub, false
+		}
+		sub.offsets = make([]uint32, glyphCount+1)
+		for k := range sub.offsets {
+			sub.offsets[k] = uint32(binary.BigEndian.Uint16(data[p+k*2:]))
+		}
+	case 4:
+		if p+4 > len(data) {
+			return sub, false
+		}
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		p += 4
+		if n > 0xFFFF || p+(n+1)*4 > len(data) {
+			return sub, false
+		}
+		sub.glyphIDs = make([]GlyphID, n+1)
+		sub.offsets = make([]uint32, n+1)
+		for k := range n + 1 {
+			sub.glyphIDs[k] = binary.BigEndian.Uint16(data[p+k*4:])
+			sub.offsets[k] = uint32(binary.BigEndian.Uint16(data[p+k*4+2:]))
+		}
+	case 5:
+		if p+16 > len(data) {
+			return sub, false
+		}
+		sub.imageSize = binary.BigEndian.Uint32(data[p:])
+		sub.metrics = parseBigGlyphMetrics(data[p+4:])
+		n := int(binary.BigEndian.Uint32(data[p+12:]))
+		p += 16
+		if n > 0xFFFF || p+n*2 > len(data) {
+			return sub, false
+		}
+		sub.glyphIDs = make([]GlyphID, n)
+		for k := range n {
+			sub.glyphIDs[k] = binary.BigEndian.Uint16(data[p+k*2:])
+		}
+	default:
+		return sub, false
+	}
+	return sub, true
+}
+
+// HasData returns true if the table carries at least one strike.
+func (e *EBLC) HasData() bool {
+	return e.Version != 0 && len(e.strikes) > 0
+}
+
+// StrikePPEMs returns the (ppemX, ppemY) pairs of all strikes in table
+// order.
+func (e *EBLC) StrikePPEMs() [][2]int {
+	out := make([][2]int, len(e.strikes))
+	for i := range e.strikes {
+		out[i] = [2]int{int(e.strikes[i].PPEMX), int(e.strikes[i].PPEMY)}
+	}
+	return out
+}
+
+// exactStrike returns the strike whose ppemY equals ppem, or nil.
+func (e *EBLC) exactStrike(ppemX, ppemY int) *eblcStrike {
+	for i := range e.strikes {
+		s := &e.strikes[i]
+		if int(s.PPEMY) == ppemY && (ppemX == 0 || int(s.PPEMX) == ppemX) {
+			return s
+		}
+	}
+	return nil
+}
+
+// chooseStrike returns the best strike for ppem with the same preference
+// as CBLC.chooseStrike: the smallest strike at least as large as the
+// request, else the largest. ppem <= 0 selects the largest strike.
+func (e *EBLC) chooseStrike(ppem int) *eblcStrike {
+	if len(e.strikes) == 0 {
+		return nil
+	}
+	if ppem <= 0 {
+		ppem = 1 << 30
+	}
+	best := &e.strikes[0]
+	for i := 1; i < len(e.strikes); i++ {
+		s := &e.strikes[i]
+		sp, bp := int(s.PPEMY), int(best.PPEMY)
+		if (ppem <= sp && sp < bp) || (ppem > bp && sp > bp) {
+			best = s
+		}
+	}
+	return best
+}
+
+// locate finds the EBDT byte range and the subtable for gid.
+func (st *eblcStrike) locate(gid GlyphID) (sub *eblcIndexSubtable, start, end int, ok bool) {
+	for i := range st.subtables {
+		s := &st.subtables[i]
+		if gid < s.firstGlyphIndex || gid > s.lastGlyphIndex {
+			continue
+		}
+		base := int(s.imageDataOffset)
+		switch s.indexFormat {
+		case 1, 3:
+			idx := int(gid - s.firstGlyphIndex)
+			if idx+1 >= len(s.offsets) || s.offsets[idx+1] <= s.offsets[idx] {
+				return nil, 0, 0, false
+			}
+			return s, base + int(s.offsets[idx]), base + int(s.offsets[idx+1]), true
+		case 2:
+			idx := int(gid - s.firstGlyphIndex)
+			start = base + idx*int(s.imageSize)
+			return s, start, start + int(s.imageSize), s.imageSize > 0
+		case 4:
+			for k := 0; k+1 < len(s.glyphIDs); k++ {
+				if s.glyphIDs[k] == gid {
+					if s.offsets[k+1] <= s.offsets[k] {
+						return nil, 0, 0, false
+					}
+					return s, base + int(s.offsets[k]), base + int(s.offsets[k+1]), true
+				}
+			}
+		case 5:
+			// glyphIDs is sorted; a linear scan is fine for the
+			// handful of glyphs such ranges typically hold.
+			for k, g := range s.glyphIDs {
+				if g == gid {
+					start = base + k*int(s.imageSize)
+					return s, start, start + int(s.imageSize), s.imageSize > 0
+				}
+			}
+		}
+	}
+	return nil, 0, 0, false
+}
+
+// maxBitmapComponentDepth bounds composite recursion so that cyclic
+// component references in broken fonts terminate.
+const maxBitmapComponentDepth = 8
+
+// glyph decodes gid from ebdt within this strike.
+func (st *eblcStrike) glyph(gid GlyphID, ebdt []byte, depth int) *BitmapGlyph {
+	if depth > maxBitmapComponentDepth {
+		return nil
+	}
+	sub, start, end, ok := st.locate(gid)
+	if !ok || end > len(ebdt) || start < 0 {
+		return nil
+	}
+	b := ebdt[start:end]
+	g := &BitmapGlyph{
+		Line:        st.Hori,
+		PPEMX:       int(st.PPEMX),
+		PPEMY:       int(st.PPEMY),
+		BitDepth:    int(st.BitDepth),
+		ImageFormat: int(sub.imageFormat),
+	}
+	var bitAligned bool
+	switch sub.imageFormat {
+	case 1, 2:
+		if len(b) < 5 {
+			return nil
+		}
+		g.Metrics, g.SmallMetrics = parseSmallGlyphMetrics(b, st.Flags), true
+		bitAligned = sub.imageFormat == 2
+		b = b[5:]
+	case 5:
+		if sub.indexFormat != 2 && sub.indexFormat != 5 {
+			return nil // format 5 images need metrics from the index
+		}
+		g.Metrics = sub.metrics
+		bitAligned = true
+	case 6, 7:
+		if len(b) < 8 {
+			return nil
+		}
+		g.Metrics = parseBigGlyphMetrics(b)
+		bitAligned = sub.imageFormat == 7
+		b = b[8:]
+	case 8, 9:
+		var n int
+		if sub.imageFormat == 8 {
+			if len(b) < 8 {
+				return nil
+			}
+			g.Metrics, g.SmallMetrics = parseSmallGlyphMetrics(b, st.Flags), true
+			n, b = int(binary.BigEndian.Uint16(b[6:])), b[8:] // 1 pad byte
+		} else {
+			if len(b) < 10 {
+				return nil
+			}
+			g.Metrics = parseBigGlyphMetrics(b)
+			n, b = int(binary.BigEndian.Uint16(b[8:])), b[10:]
+		}
+		if len(b) < n*4 {
+			return nil
+		}
+		g.Image = image.NewAlpha(image.Rect(0, 0, int(g.Metrics.Width), int(g.Metrics.Height)))
+		for k := range n {
+			c := BitmapComponent{
+				GlyphID: binary.BigEndian.Uint16(b[k*4:]),
+				XOffset: int8(b[k*4+2]),
+				YOffset: int8(b[k*4+3]),
+			}
+			g.Components = append(g.Components, c)
+			cg := st.glyph(c.GlyphID, ebdt, depth+1)
+			if cg == nil || cg.Image == nil {
+				continue
+			}
+			compositeBitmap(g.Image, cg.Image, int(c.XOffset), int(c.YOffset))
+		}
+		return g
+	default:
+		return nil
+	}
+	img, ok := decodeBitmap(b, int(g.Metrics.Width), int(g.Metrics.Height), int(st.BitDepth), bitAligned)
+	if !ok {
+		return nil
+	}
+	g.Image = img
+	return g
+}
+
+// decodeBitmap unpacks MSB-first pixel data of the given depth into an
+// alpha image. Byte-aligned data pads every row to a byte boundary;
+// bit-aligned data packs rows back to back.
+func decodeBitmap(data []byte, w, h, depth int, bitAligned bool) (*image.Alpha, bool) {
+	if w == 0 || h == 0 {
+		return nil, true
+	}
+	var scale uint8
+	switch depth {
+	case 1:
+		scale = 255
+	case 2:
+		scale = 85
+	case 4:
+		scale = 17
+	case 8:
+		scale = 1
+	default:
+		return nil, false
+	}
+	rowBits := w * depth
+	if !bitAligned {
+		rowBits = (rowBits + 7) &^ 7
+	}
+	if (rowBits*h+7)/8 > len(data) {
+		return nil, false
+	}
+	img := image.NewAlpha(image.Rect(0, 0, w, h))
+	mask := uint8(1<<depth - 1)
+	for y := range h {
+		bit := y * rowBits
+		for x := range w {
+			shift := 8 - depth - bit&7
+			v := data[bit>>3] >> shift & mask
+			img.Pix[y*img.Stride+x] = v * scale
+			bit += depth
+		}
+	}
+	return img, true
+}
+
+// compositeBitmap merges src into dst at (x, y), keeping the maximum
+// coverage of overlapping pixels.
+func compositeBitmap(dst, src *image.Alpha, x, y int) {
+	b := src.Bounds()
+	for sy := b.Min.Y; sy < b.Max.Y; sy++ {
+		dy := y + sy - b.Min.Y
+		if dy < 0 || dy >= dst.Rect.Dy() {
+			continue
+		}
+		for sx := b.Min.X; sx < b.Max.X; sx++ {
+			dx := x + sx - b.Min.X
+			if dx < 0 || dx >= dst.Rect.Dx() {
+				continue
+			}
+			v := src.Pix[src.PixOffset(sx, sy)]
+			if i := dst.PixOffset(dx, dy); v > dst.Pix[i] {
+				dst.Pix[i] = v
+			}
+		}
+	}
+}
+
+// BitmapScale is one EBSC BitmapScaleTable: requests for PPEMX×PPEMY
+// are served by scaling the SubstitutePPEMX×SubstitutePPEMY strike.
+type BitmapScale struct {
+	Hori            SbitLineMetrics
+	Vert            SbitLineMetrics
+	PPEMX           uint8
+	PPEMY           uint8
+	SubstitutePPEMX uint8
+	SubstitutePPEMY uint8
+}
+
+// EBSC holds a parsed EBSC table.
+type EBSC struct {
+	Scales  []BitmapScale
+	Version uint32
+}
+
+// ParseEBSC parses the EBSC table.
+//
+// On-disk layout: uint16 major, uint16 minor, uint32 numSizes, then
+// numSizes BitmapScaleTable records of 28 bytes each (two
+// SbitLineMetrics, ppemX, ppemY, substitutePpemX, substitutePpemY).
+func ParseEBSC(data []byte) (*EBSC, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalidTable
+	}
+	e := &EBSC{Version: binary.BigEndian.Uint32(data[0:])}
+	n := int(binary.BigEndian.Uint32(data[4:]))
+	if 8+n*28 > len(data) {
+		return nil, ErrInvalidTable
+	}
+	e.Scales = make([]BitmapScale, n)
+	for i := range n {
+		b := data[8+i*28:]
+		e.Scales[i] = BitmapScale{
+			Hori:            parseSbitLineMetrics(b),
+			Vert:            parseSbitLineMetrics(b[12:]),
+			PPEMX:           b[24],
+			PPEMY:           b[25],
+			SubstitutePPEMX: b[26],
+			SubstitutePPEMY: b[27],
+		}
+	}
+	return e, nil
+}
+
+// GlyphBitmap returns the bitmap for gid at ppem. A strike with exactly
+// that ppem is used if present; otherwise, if ebsc names a substitute
+// strike for ppem, its bitmap is scaled (nearest neighbor) to ppem;
+// otherwise the best strike as chosen for CBLC is returned unscaled, and
+// PPEMX/PPEMY of the result tell the caller which size it got. ebsc may
+// be nil. ppem <= 0 selects the largest strike.
+func (e *EBLC) GlyphBitmap(gid GlyphID, ppem int, ebdt []byte, ebsc *EBSC) *BitmapGlyph {
+	if ppem > 0 {
+		if st := e.exactStrike(0, ppem); st != nil {
+			return st.glyph(gid, ebdt, 0)
+		}
+		if ebsc != nil {
+			for _, sc := range ebsc.Scales {
+				if int(sc.PPEMY) != ppem {
+					continue
+				}
+				st := e.exactStrike(int(sc.SubstitutePPEMX), int(sc.SubstitutePPEMY))
+				if st == nil {
+					continue
+				}
+				g := st.glyph(gid, ebdt, 0)
+				if g == nil {
+					return nil
+				}
+				return scaleBitmapGlyph(g, sc)
+			}
+		}
+	}
+	st := e.chooseStrike(ppem)
+	if st == nil {
+		return nil
+	}
+	return st.glyph(gid, ebdt, 0)
+}
+
+// scaleBitmapGlyph scales g from its strike size to the EBSC target.
+func scaleBitmapGlyph(g *BitmapGlyph, sc BitmapScale) *BitmapGlyph {
+	if g.PPEMX == 0 || g.PPEMY == 0 {
+		return nil
+	}
+	sx := func(v int) int { return scaleRound(v, int(sc.PPEMX), g.PPEMX) }
+	sy := func(v int) int { return scaleRound(v, int(sc.PPEMY), g.PPEMY) }
+	out := *g
+	m := g.Metrics
+	out.Metrics = BigGlyphMetrics{
+		Height:       uint8(clampInt(sy(int(m.Height)), 0, 255)),
+		Width:        uint8(clampInt(sx(int(m.Width)), 0, 255)),
+		HoriBearingX: int8(clampInt(sx(int(m.HoriBearingX)), -128, 127)),
+		HoriBearingY: int8(clampInt(sy(int(m.HoriBearingY)), -128, 127)),
+		HoriAdvance:  uint8(clampInt(sx(int(m.HoriAdvance)), 0, 255)),
+		VertBearingX: int8(clampInt(sx(int(m.VertBearingX)), -128, 127)),
+		VertBearingY: int8(clampInt(sy(int(m.VertBearingY)), -128, 127)),
+		VertAdvance:  uint8(clampInt(sy(int(m.VertAdvance)), 0, 255)),
+	}
+	out.Line = sc.Hori
+	out.PPEMX, out.PPEMY = int(sc.PPEMX), int(sc.PPEMY)
+	out.Scaled = true
+	out.Image = nil
+	if g.Image != nil {
+		w, h := int(out.Metrics.Width), int(out.Metrics.Height)
+		if w > 0 && h > 0 {
+			src := g.Image
+			dst := image.NewAlpha(image.Rect(0, 0, w, h))
+			sw, sh := src.Rect.Dx(), src.Rect.Dy()
+			for y := range h {
+				srcY := y * sh / h
+				for x := range w {
+					dst.Pix[y*dst.Stride+x] = src.Pix[srcY*src.Stride+x*sw/w]
+				}
+			}
+			out.Image = dst
+		}
+	}
+	return &out
+}
+
+func scaleRound(v, num, den int) int {
+	p := v * num
+	if p >= 0 {
+		return (p + den/2) / den
+	}
+	return -((-p + den/2) / den)
+}
+
+func clampInt(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
+
+// HasBitmapGlyphs returns true if the font carries monochrome or
+// grayscale bitmaps in EBLC/EBDT (or Apple's bloc/bdat).
+func (f *Font) HasBitmapGlyphs() bool {
+	eblc, _ := f.bitmapTables()
+	return eblc != nil && eblc.HasData()
+}
+
+// bitmapTables returns the parsed location table and the raw data table,
+// preferring EBLC/EBDT over bloc/bdat.
+func (f *Font) bitmapTables() (*EBLC, []byte) {
+	for _, tags := range [][2]Tag{{TagEBLC, TagEBDT}, {tagBloc, tagBdat}} {
+		locData, err := f.TableData(tags[0])
+		if err != nil {
+			continue
+		}
+		data, err := f.TableData(tags[1])
+		if err != nil {
+			continue
+		}
+		loc, err := ParseEBLC(locData)
+		if err != nil {
+			continue
+		}
+		return loc, data
+	}
+	return nil, nil
+}
+
+// GlyphBitmap returns the embedded monochrome/grayscale bitmap for gid
+// at ppem, or nil if the font has no EBDT bitmap for it. If no strike
+// has exactly ppem, the EBSC table (when present) selects a substitute
+// strike that is scaled to ppem; failing that, the nearest strike is
+// returned unscaled. Color bitmaps (CBDT, sbix) are served by
+// GlyphColorPNG instead.
+func (f *Font) GlyphBitmap(gid GlyphID, ppem int) *BitmapGlyph {
+	eblc, ebdt := f.bitmapTables()
+	if eblc == nil {
+		return nil
+	}
+	var ebsc *EBSC
+	if data, err := f.TableData(TagEBSC); err == nil {
+		ebsc, _ = ParseEBSC(data)
+	}
+	return eblc.GlyphBitmap(gid, ppem, ebdt, ebsc)
+}
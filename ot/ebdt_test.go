@@ -0,0 +1,288 @@
+package ot
+
+import (
+	"testing"
+)
+
+// There is no redistributable bitmap-only font in the corpus, so the
+// EBLC/EBDT/EBSC tables below are built by hand, like the sbix fixture
+// in png_color_test.go. Strike 0 (12 ppem, 1 bpp) exercises every index
+// format and the image formats 1, 2, 5, 6, 8 and 9; strike 1 (16 ppem, 8 bpp)
+// carries a format 7 glyph.
+
+type eblcTestSubtable struct {
+	first, last GlyphID
+	header      []byte // IndexSubtableHeader + format specific body
+}
+
+func buildEBLC(strikes [][]eblcTestSubtable, ppems []uint8, depths []uint8) []byte {
+	tbl := appendBE32(nil, 0x00020000)
+	tbl = appendBE32(tbl, uint32(len(strikes)))
+	sizeTablesEnd := 8 + 48*len(strikes)
+	var arrays []byte
+	for i, subs := range strikes {
+		arrOff := sizeTablesEnd + len(arrays)
+		// IndexSubtableArray followed by the subtables.
+		arr := []byte{}
+		body := []byte{}
+		for _, s := range subs {
+			arr = appendBE16(arr, s.first)
+			arr = appendBE16(arr, s.last)
+			arr = appendBE32(arr, uint32(len(subs)*8+len(body)))
+			body = append(body, s.header...)
+		}
+		arrays = append(arrays, arr...)
+		arrays = append(arrays, body...)
+
+		st := appendBE32(nil, uint32(arrOff))
+		st = appendBE32(st, uint32(len(arr)+len(body)))
+		st = appendBE32(st, uint32(len(subs)))
+		st = appendBE32(st, 0)                                  // colorRef
+		st = append(st, 10, 0xFE, 8, 1, 0, 0, 0, 0, 0, 0, 0, 0) // hori
+		st = append(st, make([]byte, 12)...)                    // vert
+		st = appendBE16(st, subs[0].first)
+		st = appendBE16(st, subs[len(subs)-1].last)
+		st = append(st, ppems[i], ppems[i], depths[i], bitmapFlagHorizontal)
+		tbl = append(tbl, st...)
+	}
+	return append(tbl, arrays...)
+}
+
+func eblcTestHeader(indexFormat, imageFormat uint16, imageDataOffset uint32) []byte {
+	b := appendBE16(nil, indexFormat)
+	b = appendBE16(b, imageFormat)
+	return appendBE32(b, imageDataOffset)
+}
+
+func buildEBDTFixture(t *testing.T) (eblc, ebdt []byte) {
+	t.Helper()
+	ebdt = appendBE32(nil, 0x00020000)
+
+	// gid 1, image format 1: small metrics, byte-aligned rows 101/010.
+	g1 := len(ebdt)
+	ebdt = append(ebdt, 2, 3, 0, 2, 4, 0xA0, 0x40)
+	// gid 2, image format 2: small metrics, bit-aligned 101 010.
+	g2 := len(ebdt)
+	ebdt = append(ebdt, 2, 3, 0, 2, 4, 0xA8)
+	// gids 3, 4, image format 5 (index format 2): 2x2, one byte each.
+	g34 := len(ebdt)
+	ebdt = append(ebdt, 0x90, 0x60)
+	// gid 6, image format 5 (index format 5).
+	g6 := len(ebdt)
+	ebdt = append(ebdt, 0xF0)
+	// gid 7, image format 8: composite of gid 1 at (0,0) and gid 2 at (3,0).
+	g7 := len(ebdt)
+	ebdt = append(ebdt, 2, 6, 0, 2, 7, 0)
+	ebdt = appendBE16(ebdt, 2)
+	ebdt = append(ebdt, 0, 1, 0, 0, 0, 2, 3, 0)
+	g7end := len(ebdt)
+	// gid 8, image format 6: big metrics, byte-aligned rows ###/..#.
+	g8 := len(ebdt)
+	ebdt = append(ebdt, 2, 3, 0, 2, 5, 0xFF, 0, 3, 0xE0, 0x20)
+	// gid 9, image format 9: big-metrics composite of gid 8 at (0,0) and
+	// gid 1 at (3,0).
+	g9 := len(ebdt)
+	ebdt = append(ebdt, 2, 6, 0, 2, 7, 0xFD, 0, 3)
+	ebdt = appendBE16(ebdt, 2)
+	ebdt = append(ebdt, 0, 8, 0, 0, 0, 1, 3, 0)
+	g9end := len(ebdt)
+	// Strike 1, gid 1, image format 7: big metrics, 8 bpp, 2x1.
+	s1g1 := len(ebdt)
+	ebdt = append(ebdt, 1, 2, 0, 1, 3, 0, 0, 0, 0x80, 0xFF)
+	s1end := len(ebdt)
+
+	bigMetrics2x2 := []byte{2, 2, 0, 2, 3, 0, 0, 0}
+
+	sub1 := eblcTestHeader(1, 1, uint32(g1))
+	sub1 = appendBE32(sub1, 0)
+	sub1 = appendBE32(sub1, uint32(g2-g1))
+	sub2 := eblcTestHeader(3, 2, uint32(g2))
+	sub2 = appendBE16(sub2, 0)
+	sub2 = appendBE16(sub2, uint16(g34-g2))
+	sub3 := eblcTestHeader(2, 5, uint32(g34))
+	sub3 = appendBE32(sub3, 1)
+	sub3 = append(sub3, bigMetrics2x2...)
+	sub4 := eblcTestHeader(5, 5, uint32(g6))
+	sub4 = appendBE32(sub4, 1)
+	sub4 = append(sub4, bigMetrics2x2...)
+	sub4 = appendBE32(sub4, 1)
+	sub4 = appendBE16(sub4, 6)
+	sub4 = appendBE16(sub4, 0) // pad to 32-bit
+	sub5 := eblcTestHeader(4, 8, uint32(g7))
+	sub5 = appendBE32(sub5, 1)
+	sub5 = appendBE16(sub5, 7)
+	sub5 = appendBE16(sub5, 0)
+	sub5 = appendBE16(sub5, 0)
+	sub5 = appendBE16(sub5, uint16(g7end-g7))
+
+	sub6 := eblcTestHeader(1, 6, uint32(g8))
+	sub6 = appendBE32(sub6, 0)
+	sub6 = appendBE32(sub6, uint32(g9-g8))
+	sub7 := eblcTestHeader(1, 9, uint32(g9))
+	sub7 = appendBE32(sub7, 0)
+	sub7 = appendBE32(sub7, uint32(g9end-g9))
+
+	strike1 := eblcTestHeader(1, 7, uint32(s1g1))
+	strike1 = appendBE32(strike1, 0)
+	strike1 = appendBE32(strike1, uint32(s1end-s1g1))
+
+	eblc = buildEBLC([][]eblcTestSubtable{
+		{
+			{1, 1, sub1},
+			{2, 2, sub2},
+			{3, 4, sub3},
+			{6, 6, sub4},
+			{7, 7, sub5},
+			{8, 8, sub6},
+			{9, 9, sub7},
+		},
+		{{1, 1, strike1}},
+	}, []uint8{12, 16}, []uint8{1, 8})
+	return eblc, ebdt
+}
+
+func alphaRows(g *BitmapGlyph) []string {
+	var rows []string
+	b := g.Image.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := ""
+		for x := b.Min.X; x < b.Max.X; x++ {
+			switch v := g.Image.AlphaAt(x, y).A; v {
+			case 0:
+				row += "."
+			case 255:
+				row += "#"
+			default:
+				row += "+"
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestEBDTImageFormats(t *testing.T) {
+	eblcData, ebdt := buildEBDTFixture(t)
+	eblc, err := ParseEBLC(eblcData)
+	if err != nil {
+		t.Fatalf("ParseEBLC: %v", err)
+	}
+	if !eblc.HasData() {
+		t.Fatal("HasData() = false")
+	}
+	cases := []struct {
+		gid    GlyphID
+		format int
+		want   []string
+	}{
+		{1, 1, []string{"#.#", ".#."}},
+		{2, 2, []string{"#.#", ".#."}},
+		{3, 5, []string{"#.", ".#"}},
+		{4, 5, []string{".#", "#."}},
+		{6, 5, []string{"##", "##"}},
+		{7, 8, []string{"#.##.#", ".#..#."}},
+		{8, 6, []string{"###", "..#"}},
+		{9, 9, []string{"####.#", "..#.#."}},
+	}
+	for _, c := range cases {
+		g := eblc.GlyphBitmap(c.gid, 12, ebdt, nil)
+		if g == nil {
+			t.Errorf("gid %d: GlyphBitmap = nil", c.gid)
+			continue
+		}
+		if g.ImageFormat != c.format || g.PPEMY != 12 || g.BitDepth != 1 {
+			t.Errorf("gid %d: format %d ppem %d depth %d", c.gid, g.ImageFormat, g.PPEMY, g.BitDepth)
+		}
+		got := alphaRows(g)
+		if len(got) != len(c.want) {
+			t.Errorf("gid %d: rows = %q, want %q", c.gid, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("gid %d: rows = %q, want %q", c.gid, got, c.want)
+				break
+			}
+		}
+	}
+	if g := eblc.GlyphBitmap(1, 12, ebdt, nil); g.Metrics.HoriAdvance != 4 || g.Metrics.HoriBearingY != 2 || !g.SmallMetrics {
+		t.Errorf("gid 1 metrics = %+v", g.Metrics)
+	}
+	if g := eblc.GlyphBitmap(7, 12, ebdt, nil); len(g.Components) != 2 || g.Components[1] != (BitmapComponent{2, 3, 0}) {
+		t.Errorf("gid 7 components = %+v", g.Components)
+	}
+	if g := eblc.GlyphBitmap(8, 12, ebdt, nil); g.SmallMetrics || g.Metrics.HoriAdvance != 5 || g.Metrics.VertBearingX != -1 || g.Metrics.VertAdvance != 3 {
+		t.Errorf("gid 8 metrics = %+v", g.Metrics)
+	}
+	if g := eblc.GlyphBitmap(9, 12, ebdt, nil); g.SmallMetrics || g.Metrics.Width != 6 || g.Metrics.VertBearingX != -3 || len(g.Components) != 2 || g.Components[0] != (BitmapComponent{8, 0, 0}) {
+		t.Errorf("gid 9 = %+v %+v", g.Metrics, g.Components)
+	}
+	if g := eblc.GlyphBitmap(5, 12, ebdt, nil); g != nil {
+		t.Errorf("gid 5 (not covered) = %+v, want nil", g)
+	}
+}
+
+func TestEBDTGrayscaleStrike(t *testing.T) {
+	eblcData, ebdt := buildEBDTFixture(t)
+	eblc, err := ParseEBLC(eblcData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := eblc.GlyphBitmap(1, 16, ebdt, nil)
+	if g == nil {
+		t.Fatal("GlyphBitmap(1, 16) = nil")
+	}
+	if g.BitDepth != 8 || g.ImageFormat != 7 || g.SmallMetrics {
+		t.Errorf("depth %d format %d small %v", g.BitDepth, g.ImageFormat, g.SmallMetrics)
+	}
+	if a, b := g.Image.AlphaAt(0, 0).A, g.Image.AlphaAt(1, 0).A; a != 0x80 || b != 0xFF {
+		t.Errorf("pixels = %#x %#x, want 0x80 0xff", a, b)
+	}
+	// No exact strike and no EBSC: the nearest larger strike is used.
+	if g := eblc.GlyphBitmap(1, 14, ebdt, nil); g == nil || g.PPEMY != 16 || g.Scaled {
+		t.Errorf("GlyphBitmap(1, 14) = %+v, want unscaled 16 ppem strike", g)
+	}
+}
+
+func TestEBSCScaling(t *testing.T) {
+	eblcData, ebdt := buildEBDTFixture(t)
+	eblc, err := ParseEBLC(eblcData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ebscData := appendBE32(nil, 0x00020000)
+	ebscData = appendBE32(ebscData, 1)
+	ebscData = append(ebscData, make([]byte, 24)...)
+	ebscData = append(ebscData, 24, 24, 12, 12)
+	ebsc, err := ParseEBSC(ebscData)
+	if err != nil {
+		t.Fatalf("ParseEBSC: %v", err)
+	}
+	g := eblc.GlyphBitmap(1, 24, ebdt, ebsc)
+	if g == nil {
+		t.Fatal("GlyphBitmap(1, 24) = nil")
+	}
+	if !g.Scaled || g.PPEMY != 24 {
+		t.Errorf("Scaled = %v, PPEMY = %d", g.Scaled, g.PPEMY)
+	}
+	if g.Metrics.Width != 6 || g.Metrics.Height != 4 || g.Metrics.HoriAdvance != 8 {
+		t.Errorf("scaled metrics = %+v", g.Metrics)
+	}
+	want := []string{"##..##", "##..##", "..##..", "..##.."}
+	got := alphaRows(g)
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("scaled rows = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestFontGlyphBitmapAbsent(t *testing.T) {
+	f := loadFontForColor(t, "Roboto-Regular.ttf")
+	if f.HasBitmapGlyphs() {
+		t.Error("HasBitmapGlyphs() = true on Roboto")
+	}
+	if g := f.GlyphBitmap(1, 12); g != nil {
+		t.Errorf("GlyphBitmap(1, 12) = %+v, want nil", g)
+	}
+}
@@ -340,3 +340,43 @@ func (fv *FeatureVariations) RecordCount() int {
 	}
 	return len(fv.records)
 }
+
+// ConditionSet returns the condition set of a FeatureVariationRecord, or
+// nil if it is absent.
+func (fv *FeatureVariations) ConditionSet(index int) *ConditionSet {
+	if fv == nil || index < 0 || index >= len(fv.records) {
+		return nil
+	}
+	return fv.records[index].conditionSet
+}
+
+// SubstitutedFeatures returns the feature indices substituted by a
+// FeatureVariationRecord, in record order.
+func (fv *FeatureVariations) SubstitutedFeatures(index int) []uint16 {
+	if fv == nil || index < 0 || index >= len(fv.records) || fv.records[index].featureTableSubst == nil {
+		return nil
+	}
+	records := fv.records[index].featureTableSubst.records
+	features := make([]uint16, len(records))
+	for i, rec := range records {
+		features[i] = rec.featureIndex
+	}
+	return features
+}
+
+// Conditions returns the conditions of the set.
+func (cs *ConditionSet) Conditions() []*Condition {
+	if cs == nil {
+		return nil
+	}
+	return cs.conditions
+}
+
+// AxisRange returns the axis index and the F2DOT14 filter range of a
+// format 1 condition. ok is false for other formats.
+func (c *Condition) AxisRange() (axisIndex uint16, min, max int16, ok bool) {
+	if c == nil || c.format != 1 {
+		return 0, 0, 0, false
+	}
+	return c.axisIndex, c.filterRangeMin, c.filterRangeMax, true
+}
@@ -3,6 +3,7 @@ package ot
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 // Font represents an OpenType font.
@@ -17,7 +18,8 @@ type tableRecord struct {
 }
 
 // ParseFont parses an OpenType font from data.
-// For TrueType Collections (.ttc) or DFONTs, use index to select a font.
+// For TrueType Collections (.ttc), WOFF2 collections or DFONTs, use index
+// to select a font. WOFF and WOFF2 fonts are decoded to an sfnt in memory.
 func ParseFont(data []byte, index int) (*Font, error) {
 	if len(data) < 12 {
 		return nil, ErrInvalidFont
@@ -33,6 +35,9 @@ func ParseFont(data []byte, index int) (*Font, error) {
 	if magic == 0x00000100 { // DFONT resource data offset
 		return parseDfont(data, index)
 	}
+	if magic == 0x774F4646 || magic == 0x774F4632 { // 'wOFF', 'wOF2'
+		return parseWOFF(data, magic, index)
+	}
 
 	// Single font
 	if index != 0 {
@@ -72,6 +77,24 @@ func parseTTC(data []byte, index int) (*Font, error) {
 	return parseOffsetTable(data, int(offset), 0)
 }
 
+// parseWOFF decodes a WOFF or WOFF2 font and parses the resulting sfnt.
+func parseWOFF(data []byte, magic uint32, index int) (*Font, error) {
+	var sfnt []byte
+	var err error
+	if magic == 0x774F4646 {
+		if index != 0 {
+			return nil, ErrInvalidFont
+		}
+		sfnt, err = decodeWOFF(data)
+	} else {
+		sfnt, err = decodeWOFF2(data, index)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseOffsetTable(sfnt, 0, 0)
+}
+
 // parseDfont parses a dfont resource map (Apple format).
 // See https://github.com/kreativekorp/ksfl/wiki/Macintosh-Resource-File-Format
 func parseDfont(data []byte, index int) (*Font, error) {
@@ -200,6 +223,16 @@ func (f *Font) HasTable(tag Tag) bool {
 	return ok
 }
 
+// TableTags returns the tags of the tables in the font, sorted.
+func (f *Font) TableTags() []Tag {
+	tags := make([]Tag, 0, len(f.tables))
+	for tag := range f.tables {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	return tags
+}
+
 // TableData returns the raw data for a table.
 func (f *Font) TableData(tag Tag) ([]byte, error) {
 	rec, ok := f.tables[tag]
@@ -607,7 +640,7 @@ func (f *Font) HasColorSVG() bool {
 // nil if no SVG entry covers it. The returned bytes are decompressed
 // (gzip is auto-inflated). The same SVG document may cover several
 // glyphs in a range — the caller must locate the right `<g id="glyphN">`
-// subtree inside the returned XML.
+// subtree inside the returned XML, or use GlyphColorSVGElement.
 //
 // HarfBuzz equivalent: hb_ot_color_glyph_reference_svg
 // (hb-ot-color.cc:306-310). HB returns the raw (possibly gzipped) blob;
@@ -31,7 +31,10 @@ type GDEF struct {
 
 	// Mark glyph sets (version >= 1.2, optional)
 	markGlyphSetsDef *MarkGlyphSetsDef
-	data             []byte
+
+	// Item variation store (version >= 1.3, optional)
+	varStore *ItemVariationStore
+	data     []byte
 
 	// Version (major.minor)
 	versionMajor uint16
@@ -58,9 +61,9 @@ type LigGlyph struct {
 // CaretValue represents a caret position within a ligature.
 type CaretValue struct {
 	format     uint16
-	coordinate int16  // Format 1: X or Y coordinate
-	pointIndex uint16 // Format 2: contour point index
-	// Format 3: coordinate + Device table (not fully implemented)
+	coordinate int16   // Format 1: X or Y coordinate
+	pointIndex uint16  // Format 2: contour point index
+	device     *Device // Format 3: device table of the coordinate
 }
 
 // MarkGlyphSetsDef contains mark glyph set definitions.
@@ -144,9 +147,27 @@ func ParseGDEF(data []byte) (*GDEF, error) {
 		gdef.markGlyphSetsDef = mgsd
 	}
 
+	// Parse ItemVariationStore (version >= 1.3)
+	if versionMinor >= 3 && len(data) >= 18 {
+		if off := int(binary.BigEndian.Uint32(data[14:])); off != 0 && off < len(data) {
+			vs, err := ParseItemVariationStore(data[off:])
+			if err != nil {
+				return nil, err
+			}
+			gdef.varStore = vs
+		}
+	}
+
 	return gdef, nil
 }
 
+// VarStore returns the item variation store referenced by VariationIndex
+// device tables in GPOS and GDEF, or nil if there is none.
+// HarfBuzz equivalent: GDEF::get_var_store (hb-ot-layout-gdef-table.hh)
+func (g *GDEF) VarStore() *ItemVariationStore {
+	return g.varStore
+}
+
 // parseAttachList parses the AttachList subtable.
 func parseAttachList(data []byte, offset int) (*AttachList, error) {
 	if offset+4 > len(data) {
@@ -258,7 +279,9 @@ func parseLigCaretList(data []byte, offset int) (*LigCaretList, error) {
 				cv.pointIndex = binary.BigEndian.Uint16(data[cvOff+2:])
 			case 3:
 				cv.coordinate = int16(binary.BigEndian.Uint16(data[cvOff+2:]))
-				// Device table offset at cvOff+4 (not fully implemented)
+				if cvOff+6 <= len(data) {
+					cv.device = parseDevice(data, cvOff, int(binary.BigEndian.Uint16(data[cvOff+4:])))
+				}
 			}
 
 			lcl.ligGlyphs[i].caretValues[j] = cv
@@ -440,6 +463,11 @@ func (cv *CaretValue) Coordinate() int16 {
 	return cv.coordinate
 }
 
+// Device returns the device table of a format 3 CaretValue, or nil.
+func (cv *CaretValue) Device() *Device {
+	return cv.device
+}
+
 // PointIndex returns the contour point index for a CaretValue (format 2).
 func (cv *CaretValue) PointIndex() uint16 {
 	return cv.pointIndex
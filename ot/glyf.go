@@ -143,7 +143,7 @@ func (g *Glyf) GetContourPointCount(gid GlyphID) int {
 	}
 
 	// Composite glyph: sum of component point counts
-	components := g.parseComposite(glyph.Data)
+	components := parseCompositeComponents(glyph.Data)
 	total := 0
 	for _, comp := range components {
 		total += g.GetContourPointCount(comp.GlyphID)
@@ -215,13 +215,25 @@ const (
 	overlapCompound uint16 = 0x0400
 )
 
+// Exported composite component flags, as found in CompositeComponent.Flags.
+const (
+	CompositeArgsAreXYValues = argsAreXYValues
+	CompositeRoundXYToGrid   = roundXYToGrid
+	CompositeUseMyMetrics    = useMyMetrics
+	CompositeScaledOffset    = uint16(0x0800) // SCALED_COMPONENT_OFFSET
+)
+
 // CompositeComponent represents a component in a composite glyph.
 type CompositeComponent struct {
 	GlyphID GlyphID
 	Flags   uint16
-	Arg1    int16
-	Arg2    int16
-	// Transform matrix components (optional)
+	// Arg1 and Arg2 are the x/y offset if Flags has
+	// CompositeArgsAreXYValues, else the parent and component point
+	// numbers to align.
+	Arg1 int16
+	Arg2 int16
+	// Transform matrix components (optional): Scale for a uniform
+	// scale, ScaleX/ScaleY for an x/y scale, all four for a 2x2 matrix.
 	Scale   float32
 	ScaleX  float32
 	ScaleY  float32
@@ -229,6 +241,28 @@ type CompositeComponent struct {
 	Scale10 float32
 }
 
+// Matrix returns the component's 2x2 transform (without offset).
+func (c *CompositeComponent) Matrix() AffineMatrix {
+	switch {
+	case c.Flags&weHaveAScale != 0:
+		return AffineMatrix{XX: c.Scale, YY: c.Scale}
+	case c.Flags&weHaveXYScale != 0:
+		return AffineMatrix{XX: c.ScaleX, YY: c.ScaleY}
+	case c.Flags&weHave2x2 != 0:
+		return AffineMatrix{XX: c.ScaleX, YX: c.Scale01, XY: c.Scale10, YY: c.ScaleY}
+	}
+	return IdentityMatrix
+}
+
+// ParseCompositeGlyph parses the components of composite glyph data.
+// Returns nil for simple or empty glyphs.
+func ParseCompositeGlyph(data []byte) []CompositeComponent {
+	if len(data) < 10 || int16(binary.BigEndian.Uint16(data)) >= 0 {
+		return nil
+	}
+	return parseCompositeComponents(data)
+}
+
 // GetComponents returns the component glyph IDs for a composite glyph.
 // For simple glyphs, returns nil.
 func (g *Glyf) GetComponents(gid GlyphID) []GlyphID {
@@ -237,7 +271,7 @@ func (g *Glyf) GetComponents(gid GlyphID) []GlyphID {
 		return nil
 	}
 
-	components := g.parseComposite(glyph.Data)
+	components := parseCompositeComponents(glyph.Data)
 	result := make([]GlyphID, len(components))
 	for i, comp := range components {
 		result[i] = comp.GlyphID
@@ -245,8 +279,8 @@ func (g *Glyf) GetComponents(gid GlyphID) []GlyphID {
 	return result
 }
 
-// parseComposite parses composite glyph components.
-func (g *Glyf) parseComposite(data []byte) []CompositeComponent {
+// parseCompositeComponents parses composite glyph components.
+func parseCompositeComponents(data []byte) []CompositeComponent {
 	if len(data) < 10 {
 		return nil
 	}
@@ -286,13 +320,29 @@ func (g *Glyf) parseComposite(data []byte) []CompositeComponent {
 			offset += 2
 		}
 
-		// Skip transform components (we just need glyph IDs for closure)
+		// Parse transform components (F2Dot14)
 		if flags&weHaveAScale != 0 {
-			offset += 2 // F2Dot14
+			if offset+2 > len(data) {
+				break
+			}
+			comp.Scale = f2dot14(binary.BigEndian.Uint16(data[offset:]))
+			offset += 2
 		} else if flags&weHaveXYScale != 0 {
-			offset += 4 // 2 x F2Dot14
+			if offset+4 > len(data) {
+				break
+			}
+			comp.ScaleX = f2dot14(binary.BigEndian.Uint16(data[offset:]))
+			comp.ScaleY = f2dot14(binary.BigEndian.Uint16(data[offset+2:]))
+			offset += 4
 		} else if flags&weHave2x2 != 0 {
-			offset += 8 // 4 x F2Dot14
+			if offset+8 > len(data) {
+				break
+			}
+			comp.ScaleX = f2dot14(binary.BigEndian.Uint16(data[offset:]))
+			comp.Scale01 = f2dot14(binary.BigEndian.Uint16(data[offset+2:]))
+			comp.Scale10 = f2dot14(binary.BigEndian.Uint16(data[offset+4:]))
+			comp.ScaleY = f2dot14(binary.BigEndian.Uint16(data[offset+6:]))
+			offset += 8
 		}
 
 		components = append(components, comp)
@@ -363,6 +413,140 @@ func RemapComposite(data []byte, glyphMap map[GlyphID]GlyphID) []byte {
 	return result
 }
 
+// DropGlyphInstructions returns a glyph record without its hinting
+// instructions. Simple glyphs get an empty instruction array, composite
+// glyphs lose WE_HAVE_INSTRUCTIONS and the instructions after the last
+// component. Malformed records are returned unchanged.
+// HarfBuzz equivalent: Glyph::drop_hints_bytes (OT/glyf/Glyph.hh)
+func DropGlyphInstructions(data []byte) []byte {
+	if len(data) < 10 {
+		return data
+	}
+	numberOfContours := int16(binary.BigEndian.Uint16(data))
+
+	if numberOfContours >= 0 {
+		offset := 10 + int(numberOfContours)*2
+		if offset+2 > len(data) {
+			return data
+		}
+		instructionLength := int(binary.BigEndian.Uint16(data[offset:]))
+		if instructionLength == 0 || offset+2+instructionLength > len(data) {
+			return data
+		}
+		result := make([]byte, 0, len(data)-instructionLength)
+		result = append(result, data[:offset]...)
+		result = append(result, 0, 0)
+		return append(result, data[offset+2+instructionLength:]...)
+	}
+
+	result := make([]byte, len(data))
+	copy(result, data)
+	offset := 10
+	for {
+		if offset+4 > len(result) {
+			return data
+		}
+		flags := binary.BigEndian.Uint16(result[offset:])
+		binary.BigEndian.PutUint16(result[offset:], flags&^weHaveInstr)
+		offset += 4
+		if flags&argAreWords != 0 {
+			offset += 4
+		} else {
+			offset += 2
+		}
+		if flags&weHaveAScale != 0 {
+			offset += 2
+		} else if flags&weHaveXYScale != 0 {
+			offset += 4
+		} else if flags&weHave2x2 != 0 {
+			offset += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	if offset > len(result) {
+		return data
+	}
+	return result[:offset]
+}
+
+// InstanceCompositeGlyph rewrites a composite glyph with new component
+// offsets and bounding box. offsets holds one (x, y) pair per component
+// and is only applied to components positioned by x/y values; the
+// argument size is widened to words where needed. Component transforms
+// and instructions are kept.
+// HarfBuzz equivalent: CompositeGlyphRecord::compile_with_point
+// (OT/glyf/CompositeGlyph.hh)
+func InstanceCompositeGlyph(data []byte, offsets [][2]int16, bbox GlyphBBox) []byte {
+	if len(data) < 10 || int16(binary.BigEndian.Uint16(data)) >= 0 {
+		return data
+	}
+
+	result := make([]byte, 10, len(data)+2*len(offsets))
+	copy(result, data[:2])
+	binary.BigEndian.PutUint16(result[2:], uint16(bbox.XMin))
+	binary.BigEndian.PutUint16(result[4:], uint16(bbox.YMin))
+	binary.BigEndian.PutUint16(result[6:], uint16(bbox.XMax))
+	binary.BigEndian.PutUint16(result[8:], uint16(bbox.YMax))
+
+	offset := 10
+	for i := 0; ; i++ {
+		if offset+4 > len(data) {
+			return data
+		}
+		flags := binary.BigEndian.Uint16(data[offset:])
+		gid := binary.BigEndian.Uint16(data[offset+2:])
+		offset += 4
+
+		argSize := 2
+		if flags&argAreWords != 0 {
+			argSize = 4
+		}
+		if offset+argSize > len(data) {
+			return data
+		}
+		args := data[offset : offset+argSize]
+		offset += argSize
+
+		if flags&argsAreXYValues != 0 && i < len(offsets) {
+			x, y := offsets[i][0], offsets[i][1]
+			if x >= -128 && x <= 127 && y >= -128 && y <= 127 {
+				flags &^= argAreWords
+				args = []byte{byte(int8(x)), byte(int8(y))}
+			} else {
+				flags |= argAreWords
+				args = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, uint16(x)), uint16(y))
+			}
+		}
+
+		transformSize := 0
+		if flags&weHaveAScale != 0 {
+			transformSize = 2
+		} else if flags&weHaveXYScale != 0 {
+			transformSize = 4
+		} else if flags&weHave2x2 != 0 {
+			transformSize = 8
+		}
+		if offset+transformSize > len(data) {
+			return data
+		}
+
+		result = binary.BigEndian.AppendUint16(result, flags)
+		result = binary.BigEndian.AppendUint16(result, gid)
+		result = append(result, args...)
+		result = append(result, data[offset:offset+transformSize]...)
+		offset += transformSize
+
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+
+	// Instructions (if any) follow the last component unchanged.
+	return append(result, data[offset:]...)
+}
+
 // BuildLoca builds a loca table from glyph offsets.
 // If useShort is true, uses 16-bit format (offsets must be even and < 131072).
 func BuildLoca(offsets []uint32, useShort bool) []byte {
@@ -65,6 +65,38 @@ type ValueRecord struct {
 	YPlacement int16 // Vertical adjustment for placement
 	XAdvance   int16 // Horizontal adjustment for advance
 	YAdvance   int16 // Vertical adjustment for advance
+
+	// Device tables of the four values (nil if absent)
+	XPlaDevice *Device
+	YPlaDevice *Device
+	XAdvDevice *Device
+	YAdvDevice *Device
+}
+
+// DeltaFormatVariationIndex marks a Device table that is a VariationIndex
+// table.
+const DeltaFormatVariationIndex = 0x8000
+
+// Device is a Device or VariationIndex table. Only VariationIndex tables
+// are decoded; they select a delta set of the GDEF item variation store.
+// HarfBuzz equivalent: Device and VariationDevice (hb-ot-layout-common.hh)
+type Device struct {
+	DeltaFormat uint16
+	VarIdx      uint32 // outer<<16 | inner, for DeltaFormatVariationIndex
+}
+
+// parseDevice parses the Device table at base+off; it returns nil for a
+// null offset.
+func parseDevice(data []byte, base, off int) *Device {
+	if off == 0 || base+off+6 > len(data) {
+		return nil
+	}
+	d := data[base+off:]
+	dev := &Device{DeltaFormat: binary.BigEndian.Uint16(d[4:])}
+	if dev.DeltaFormat == DeltaFormatVariationIndex {
+		dev.VarIdx = binary.BigEndian.Uint32(d)
+	}
+	return dev
 }
 
 // valueFormatLen returns the number of int16 values in a ValueRecord with the given format.
@@ -84,7 +116,9 @@ func valueFormatSize(format uint16) int {
 }
 
 // parseValueRecord parses a ValueRecord from data.
-func parseValueRecord(data []byte, offset int, format uint16) (ValueRecord, int) {
+// Device table offsets are relative to base, the start of the parent
+// table (the subtable, or the PairSet of a PairPos format 1).
+func parseValueRecord(data []byte, base, offset int, format uint16) (ValueRecord, int) {
 	var vr ValueRecord
 	off := offset
 
@@ -104,17 +138,20 @@ func parseValueRecord(data []byte, offset int, format uint16) (ValueRecord, int)
 		vr.YAdvance = int16(binary.BigEndian.Uint16(data[off:]))
 		off += 2
 	}
-	// Skip device tables (we don't support variable fonts yet)
 	if format&ValueFormatXPlaDevice != 0 {
+		vr.XPlaDevice = parseDevice(data, base, int(binary.BigEndian.Uint16(data[off:])))
 		off += 2
 	}
 	if format&ValueFormatYPlaDevice != 0 {
+		vr.YPlaDevice = parseDevice(data, base, int(binary.BigEndian.Uint16(data[off:])))
 		off += 2
 	}
 	if format&ValueFormatXAdvDevice != 0 {
+		vr.XAdvDevice = parseDevice(data, base, int(binary.BigEndian.Uint16(data[off:])))
 		off += 2
 	}
 	if format&ValueFormatYAdvDevice != 0 {
+		vr.YAdvDevice = parseDevice(data, base, int(binary.BigEndian.Uint16(data[off:])))
 		off += 2
 	}
 
@@ -385,7 +422,7 @@ func parseSinglePos(data []byte, offset int) (*SinglePos, error) {
 	switch format {
 	case 1:
 		// Single ValueRecord for all glyphs
-		vr, _ := parseValueRecord(data, offset+6, valueFormat)
+		vr, _ := parseValueRecord(data, offset, offset+6, valueFormat)
 		sp.valueRecord = vr
 		return sp, nil
 
@@ -400,7 +437,7 @@ func parseSinglePos(data []byte, offset int) (*SinglePos, error) {
 		sp.valueRecords = make([]ValueRecord, valueCount)
 		off := offset + 8
 		for i := 0; i < valueCount; i++ {
-			vr, size := parseValueRecord(data, off, valueFormat)
+			vr, size := parseValueRecord(data, offset, off, valueFormat)
 			sp.valueRecords[i] = vr
 			off += size
 		}
@@ -559,9 +596,9 @@ func parsePairPosFormat1(data []byte, offset int, pp *PairPos) (*PairPos, error)
 		for j := 0; j < pairCount; j++ {
 			records[j].SecondGlyph = GlyphID(binary.BigEndian.Uint16(data[off:]))
 			off += 2
-			records[j].Value1, _ = parseValueRecord(data, off, pp.valueFormat1)
+			records[j].Value1, _ = parseValueRecord(data, absOff, off, pp.valueFormat1)
 			off += valueFormatSize(pp.valueFormat1)
-			records[j].Value2, _ = parseValueRecord(data, off, pp.valueFormat2)
+			records[j].Value2, _ = parseValueRecord(data, absOff, off, pp.valueFormat2)
 			off += valueFormatSize(pp.valueFormat2)
 		}
 		pp.pairSets[i] = records
@@ -607,9 +644,9 @@ func parsePairPosFormat2(data []byte, offset int, pp *PairPos) (*PairPos, error)
 	for c1 := 0; c1 < int(class1Count); c1++ {
 		pp.classMatrix[c1] = make([]PairClassRecord, class2Count)
 		for c2 := 0; c2 < int(class2Count); c2++ {
-			pp.classMatrix[c1][c2].Value1, _ = parseValueRecord(data, off, pp.valueFormat1)
+			pp.classMatrix[c1][c2].Value1, _ = parseValueRecord(data, offset, off, pp.valueFormat1)
 			off += valueFormatSize(pp.valueFormat1)
-			pp.classMatrix[c1][c2].Value2, _ = parseValueRecord(data, off, pp.valueFormat2)
+			pp.classMatrix[c1][c2].Value2, _ = parseValueRecord(data, offset, off, pp.valueFormat2)
 			off += valueFormatSize(pp.valueFormat2)
 		}
 	}
@@ -1445,7 +1482,9 @@ type Anchor struct {
 	Y      int16 // Y coordinate in design units
 	// Format 2 adds: anchorPoint (contour point index)
 	AnchorPoint uint16
-	// Format 3 adds: device table offsets (not yet implemented)
+	// Format 3 adds: device tables for X and Y (nil if absent)
+	XDevice *Device
+	YDevice *Device
 }
 
 // parseAnchor parses an Anchor table from data at the given offset.
@@ -1470,7 +1509,10 @@ func parseAnchor(data []byte, offset int) (*Anchor, error) {
 		}
 		anchor.AnchorPoint = binary.BigEndian.Uint16(data[offset+6:])
 	}
-	// Format 3 with device tables could be added here
+	if format == 3 && offset+10 <= len(data) {
+		anchor.XDevice = parseDevice(data, offset, int(binary.BigEndian.Uint16(data[offset+6:])))
+		anchor.YDevice = parseDevice(data, offset, int(binary.BigEndian.Uint16(data[offset+8:])))
+	}
 
 	return anchor, nil
 }
@@ -1650,6 +1650,10 @@ func (g *GSUB) ParseFeatureList() (*FeatureList, error) {
 type FeatureRecord struct {
 	Lookups []uint16
 	Tag     Tag
+
+	// Params holds the raw FeatureParams table of 'size', 'ssXX' and
+	// 'cvXX' features, nil otherwise.
+	Params []byte
 }
 
 // GetFeature returns the feature record at the given index.
@@ -1689,11 +1693,42 @@ func (f *FeatureList) GetFeature(index int) (*FeatureRecord, error) {
 	for i := 0; i < lookupCount; i++ {
 		feat.Lookups[i] = binary.BigEndian.Uint16(f.data[absOff+4+i*2:])
 	}
+	if paramsOff := int(binary.BigEndian.Uint16(f.data[absOff:])); paramsOff != 0 {
+		feat.Params = featureParams(f.data, absOff+paramsOff, tag)
+	}
 
 	f.cache[index] = feat
 	return feat, nil
 }
 
+// featureParams returns the FeatureParams table at off, whose layout is
+// determined by the feature tag.
+// HarfBuzz equivalent: FeatureParams::get_size (hb-ot-layout-common.hh)
+func featureParams(data []byte, off int, tag Tag) []byte {
+	size := 0
+	switch {
+	case tag == MakeTag('s', 'i', 'z', 'e'):
+		// designSize, subfamilyID, subfamilyNameID, rangeStart, rangeEnd
+		size = 10
+	case byte(tag>>24) == 's' && byte(tag>>16) == 's':
+		// version, uiNameID
+		size = 4
+	case byte(tag>>24) == 'c' && byte(tag>>16) == 'v':
+		// format, 4 name IDs, numNamedParameters, firstParamUILabelNameID,
+		// charCount, character[charCount] (uint24)
+		if off+14 > len(data) {
+			return nil
+		}
+		size = 14 + 3*int(binary.BigEndian.Uint16(data[off+12:]))
+	default:
+		return nil
+	}
+	if off+size > len(data) {
+		return nil
+	}
+	return data[off : off+size]
+}
+
 // FindFeature finds a feature by tag and returns its lookup indices.
 func (f *FeatureList) FindFeature(tag Tag) []uint16 {
 	// Collect unique lookup indices from all features with matching tag
@@ -2162,6 +2197,58 @@ func (sl *ScriptList) GetDefaultScript() *LangSys {
 	return nil
 }
 
+// ScriptRecord is a script of a ScriptList with all its language
+// systems.
+type ScriptRecord struct {
+	Tag            Tag
+	DefaultLangSys *LangSys // nil if the script has none
+	LangSys        []LangSysRecord
+}
+
+// LangSysRecord is a tagged, non-default language system of a script.
+type LangSysRecord struct {
+	Tag     Tag
+	LangSys *LangSys
+}
+
+// Count returns the number of scripts.
+func (sl *ScriptList) Count() int {
+	return sl.count
+}
+
+// Scripts returns all scripts with their language systems, in table
+// order. Malformed entries are skipped.
+func (sl *ScriptList) Scripts() []ScriptRecord {
+	scripts := make([]ScriptRecord, 0, sl.count)
+	for i := 0; i < sl.count; i++ {
+		recOff := sl.offset + 2 + i*6
+		rec := ScriptRecord{Tag: Tag(binary.BigEndian.Uint32(sl.data[recOff:]))}
+		off := sl.offset + int(binary.BigEndian.Uint16(sl.data[recOff+4:]))
+		if off+4 > len(sl.data) {
+			continue
+		}
+		rec.DefaultLangSys = sl.parseScript(off)
+
+		langSysCount := int(binary.BigEndian.Uint16(sl.data[off+2:]))
+		for j := 0; j < langSysCount; j++ {
+			lsOff := off + 4 + j*6
+			if lsOff+6 > len(sl.data) {
+				break
+			}
+			ls := sl.parseLangSys(off + int(binary.BigEndian.Uint16(sl.data[lsOff+4:])))
+			if ls == nil {
+				continue
+			}
+			rec.LangSys = append(rec.LangSys, LangSysRecord{
+				Tag:     Tag(binary.BigEndian.Uint32(sl.data[lsOff:])),
+				LangSys: ls,
+			})
+		}
+		scripts = append(scripts, rec)
+	}
+	return scripts
+}
+
 // parseScript parses a Script table and returns its default LangSys.
 func (sl *ScriptList) parseScript(off int) *LangSys {
 	if off+4 > len(sl.data) {
@@ -129,8 +129,114 @@ func (g *Gvar) GetGlyphDeltas(glyphID GlyphID, normalizedCoords []int, numPoints
 // origCoords contains the original point coordinates for IUP interpolation.
 // If nil, a simplified interpolation is used.
 func (g *Gvar) GetGlyphDeltasWithCoords(glyphID GlyphID, normalizedCoords []int, numPoints int, origCoords []GlyphPoint) *GlyphDeltas {
+	return g.glyphDeltas(glyphID, normalizedCoords, numPoints, origCoords, true)
+}
+
+// GetCompositeGlyphDeltas computes the deltas of a composite glyph, whose
+// points are its component offsets followed by the 4 phantom points.
+// Points without an explicit delta stay unchanged: composite glyphs have
+// no contours, so no deltas are inferred.
+func (g *Gvar) GetCompositeGlyphDeltas(glyphID GlyphID, normalizedCoords []int, numPoints int) *GlyphDeltas {
+	return g.glyphDeltas(glyphID, normalizedCoords, numPoints, nil, false)
+}
+
+// glyphDeltas accumulates the deltas of all tuple variations; infer
+// selects whether untouched points are interpolated.
+func (g *Gvar) glyphDeltas(glyphID GlyphID, normalizedCoords []int, numPoints int, origCoords []GlyphPoint, infer bool) *GlyphDeltas {
+	var deltas *GlyphDeltas
+	g.forEachTuple(glyphID, numPoints, func(peak, start, end []int16, decode func() ([]int, []int16, []int16)) {
+		if deltas == nil {
+			deltas = &GlyphDeltas{
+				XDeltas: make([]float64, numPoints),
+				YDeltas: make([]float64, numPoints),
+			}
+		}
+
+		// Calculate scalar for this tuple
+		scalar := g.calculateScalar(peak, start, end, normalizedCoords)
+		if scalar == 0 {
+			return
+		}
+		pointIndices, xDeltas, yDeltas := decode()
+
+		// Apply deltas with scalar
+		if len(pointIndices) == 0 {
+			// All points
+			for i := 0; i < numPoints && i < len(xDeltas); i++ {
+				deltas.XDeltas[i] += float64(xDeltas[i]) * scalar
+				deltas.YDeltas[i] += float64(yDeltas[i]) * scalar
+			}
+		} else if infer {
+			// Specific points - need interpolation for missing points
+			g.applyDeltasWithInterpolation(deltas, pointIndices, xDeltas, yDeltas, scalar, numPoints, origCoords)
+		} else {
+			for i, ptIdx := range pointIndices {
+				if ptIdx < numPoints && i < len(xDeltas) {
+					deltas.XDeltas[ptIdx] += float64(xDeltas[i]) * scalar
+					deltas.YDeltas[ptIdx] += float64(yDeltas[i]) * scalar
+				}
+			}
+		}
+	})
+	return deltas
+}
+
+// GlyphTuple is one tuple variation of a glyph with a delta for every
+// point. Start and End hold the effective intermediate region, which is
+// derived from Peak when the tuple does not store one.
+type GlyphTuple struct {
+	Peak, Start, End []int16 // F2DOT14, one per axis
+	XDeltas, YDeltas []float64
+}
+
+// GlyphTuples returns the tuple variations of a glyph. numPoints
+// includes the 4 phantom points. With origCoords, deltas of points the
+// tuple does not reference are inferred as in GetGlyphDeltasWithCoords;
+// without them (composite glyphs) they are zero.
+func (g *Gvar) GlyphTuples(glyphID GlyphID, numPoints int, origCoords []GlyphPoint) []GlyphTuple {
+	var tuples []GlyphTuple
+	g.forEachTuple(glyphID, numPoints, func(peak, start, end []int16, decode func() ([]int, []int16, []int16)) {
+		if len(peak) != g.axisCount {
+			return
+		}
+		t := GlyphTuple{Peak: peak, Start: start, End: end}
+		if start == nil || end == nil {
+			t.Start = make([]int16, g.axisCount)
+			t.End = make([]int16, g.axisCount)
+			for i, p := range peak {
+				t.Start[i], t.End[i] = min(p, 0), max(p, 0)
+			}
+		}
+		t.XDeltas = make([]float64, numPoints)
+		t.YDeltas = make([]float64, numPoints)
+		deltas := &GlyphDeltas{XDeltas: t.XDeltas, YDeltas: t.YDeltas}
+		pointIndices, xDeltas, yDeltas := decode()
+		switch {
+		case len(pointIndices) == 0:
+			for i := 0; i < numPoints && i < len(xDeltas); i++ {
+				t.XDeltas[i], t.YDeltas[i] = float64(xDeltas[i]), float64(yDeltas[i])
+			}
+		case origCoords != nil:
+			g.applyDeltasWithInterpolation(deltas, pointIndices, xDeltas, yDeltas, 1, numPoints, origCoords)
+		default:
+			for i, ptIdx := range pointIndices {
+				if ptIdx < numPoints && i < len(xDeltas) {
+					t.XDeltas[ptIdx] += float64(xDeltas[i])
+					t.YDeltas[ptIdx] += float64(yDeltas[i])
+				}
+			}
+		}
+		tuples = append(tuples, t)
+	})
+	return tuples
+}
+
+// forEachTuple calls fn for every tuple variation of a glyph with its
+// peak and (optional) intermediate region. decode parses the tuple's
+// point numbers (nil for all points) and packed deltas.
+func (g *Gvar) forEachTuple(glyphID GlyphID, numPoints int, fn func(peak, start, end []int16, decode func() ([]int, []int16, []int16))) {
 	if g == nil || int(glyphID) >= g.glyphCount {
-		return nil
+		return
 	}
 
 	// Get the glyph's variation data
@@ -139,16 +245,16 @@ func (g *Gvar) GetGlyphDeltasWithCoords(glyphID GlyphID, normalizedCoords []int,
 
 	if startOffset == endOffset {
 		// No variation data for this glyph
-		return nil
+		return
 	}
 
 	if int(endOffset) > len(g.data) {
-		return nil
+		return
 	}
 
 	glyphData := g.data[startOffset:endOffset]
 	if len(glyphData) < 4 {
-		return nil
+		return
 	}
 
 	// Parse TupleVariationCount
@@ -157,14 +263,8 @@ func (g *Gvar) GetGlyphDeltasWithCoords(glyphID GlyphID, normalizedCoords []int,
 	sharedPointNumbers := (tupleVarCount & 0x8000) != 0
 	dataOffset := binary.BigEndian.Uint16(glyphData[2:])
 
-	if tupleCount == 0 {
-		return nil
-	}
-
-	// Initialize result
-	deltas := &GlyphDeltas{
-		XDeltas: make([]float64, numPoints),
-		YDeltas: make([]float64, numPoints),
+	if tupleCount == 0 || int(dataOffset) > len(glyphData) {
+		return
 	}
 
 	// Parse shared point numbers if present
@@ -176,7 +276,7 @@ func (g *Gvar) GetGlyphDeltasWithCoords(glyphID GlyphID, normalizedCoords []int,
 		serializedDataStart += consumed
 	}
 
-	// Parse each tuple variation header and accumulate deltas
+	// Parse each tuple variation header
 	headerOffset := 4
 	serializedOffset := serializedDataStart
 
@@ -231,43 +331,29 @@ func (g *Gvar) GetGlyphDeltasWithCoords(glyphID GlyphID, normalizedCoords []int,
 			}
 		}
 
-		// Calculate scalar for this tuple
-		scalar := g.calculateScalar(peakCoords, startCoords, endCoords, normalizedCoords)
-		if scalar == 0 {
-			serializedOffset += variationDataSize
-			continue
-		}
-
-		// Parse point numbers for this tuple
-		var pointIndices []int
-		deltaDataStart := serializedOffset
-		if privatePointNumbers {
-			var consumed int
-			pointIndices, consumed = g.parsePointNumbers(glyphData[serializedOffset:])
-			deltaDataStart += consumed
-		} else {
-			pointIndices = sharedPoints
-		}
-
-		// Parse deltas
-		xDeltas, yDeltas, _ := g.parseDeltas(glyphData[deltaDataStart:], len(pointIndices), numPoints)
-
-		// Apply deltas with scalar
-		if len(pointIndices) == 0 {
-			// All points
-			for i := 0; i < numPoints && i < len(xDeltas); i++ {
-				deltas.XDeltas[i] += float64(xDeltas[i]) * scalar
-				deltas.YDeltas[i] += float64(yDeltas[i]) * scalar
+		serialized := serializedOffset
+		decode := func() ([]int, []int16, []int16) {
+			if serialized > len(glyphData) {
+				return nil, nil, nil
 			}
-		} else {
-			// Specific points - need interpolation for missing points
-			g.applyDeltasWithInterpolation(deltas, pointIndices, xDeltas, yDeltas, scalar, numPoints, origCoords)
+			// Parse point numbers for this tuple
+			pointIndices := sharedPoints
+			deltaDataStart := serialized
+			if privatePointNumbers {
+				var consumed int
+				pointIndices, consumed = g.parsePointNumbers(glyphData[serialized:])
+				deltaDataStart += consumed
+			}
+			if deltaDataStart > len(glyphData) {
+				return nil, nil, nil
+			}
+			xDeltas, yDeltas, _ := g.parseDeltas(glyphData[deltaDataStart:], len(pointIndices), numPoints)
+			return pointIndices, xDeltas, yDeltas
 		}
+		fn(peakCoords, startCoords, endCoords, decode)
 
 		serializedOffset += variationDataSize
 	}
-
-	return deltas
 }
 
 // calculateScalar computes the scalar value for a tuple variation.
@@ -580,3 +666,33 @@ func iupInterpolate(coord, coord1, coord2 int16, delta1, delta2 float64) float64
 	t := float64(coord-coord1) / float64(coord2-coord1)
 	return delta1 + t*(delta2-delta1)
 }
+
+// SharedTuples returns the raw shared tuple records (axisCount F2DOT14
+// coordinates each).
+func (g *Gvar) SharedTuples() []byte {
+	start := int(g.sharedTuplesOffset)
+	end := start + g.sharedTupleCount*g.axisCount*2
+	if g.sharedTupleCount == 0 || end > len(g.data) {
+		return nil
+	}
+	return g.data[start:end]
+}
+
+// SharedTupleCount returns the number of shared tuples.
+func (g *Gvar) SharedTupleCount() int {
+	return g.sharedTupleCount
+}
+
+// GlyphVariationData returns the raw GlyphVariationData of a glyph, or
+// nil if the glyph has no variations.
+func (g *Gvar) GlyphVariationData(glyphID GlyphID) []byte {
+	if int(glyphID) >= g.glyphCount {
+		return nil
+	}
+	start := int(g.glyphVarDataOffset) + int(g.glyphVarDataOffsets[glyphID])
+	end := int(g.glyphVarDataOffset) + int(g.glyphVarDataOffsets[glyphID+1])
+	if start >= end || end > len(g.data) {
+		return nil
+	}
+	return g.data[start:end]
+}
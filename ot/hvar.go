@@ -467,3 +467,129 @@ func (dm *DeltaSetIndexMap) Map(glyph uint32) uint32 {
 
 	return (outer << 16) | inner
 }
+
+// ParseItemVariationStore parses an ItemVariationStore, as referenced by
+// HVAR, VVAR, MVAR and GDEF.
+func ParseItemVariationStore(data []byte) (*ItemVariationStore, error) {
+	return parseItemVariationStore(data)
+}
+
+// ParseDeltaSetIndexMap parses a DeltaSetIndexMap.
+func ParseDeltaSetIndexMap(data []byte) (*DeltaSetIndexMap, error) {
+	return parseDeltaSetIndexMap(data)
+}
+
+// Regions returns the region list of the store.
+func (vs *ItemVariationStore) Regions() *VarRegionList {
+	return vs.regions
+}
+
+// DataSetCount returns the number of VarData subtables (outer indices).
+func (vs *ItemVariationStore) DataSetCount() int {
+	return len(vs.dataSets)
+}
+
+// ItemCount returns the number of delta sets (inner indices) of a VarData
+// subtable.
+func (vs *ItemVariationStore) ItemCount(outer int) int {
+	if outer >= len(vs.dataSets) || len(vs.dataSets[outer].data) < 6 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(vs.dataSets[outer].data))
+}
+
+// ItemDeltas returns the unscaled deltas of a delta set together with
+// the region index each delta applies to. It returns nil slices for
+// indices out of range.
+// HarfBuzz equivalent: VarData::get_item_delta_fast (hb-ot-layout-common.hh)
+func (vs *ItemVariationStore) ItemDeltas(varIdx uint32) (regionIndices []uint16, deltas []int32) {
+	outer, inner := int(varIdx>>16), int(varIdx&0xFFFF)
+	if outer >= len(vs.dataSets) {
+		return nil, nil
+	}
+	varData := vs.dataSets[outer].data
+	if len(varData) < 6 {
+		return nil, nil
+	}
+
+	itemCount := int(binary.BigEndian.Uint16(varData[0:]))
+	wordSizeCount := binary.BigEndian.Uint16(varData[2:])
+	regionIndexCount := int(binary.BigEndian.Uint16(varData[4:]))
+	longWords := (wordSizeCount & 0x8000) != 0
+	wordCount := int(wordSizeCount & 0x7FFF)
+	if inner >= itemCount || len(varData) < 6+regionIndexCount*2 {
+		return nil, nil
+	}
+
+	wordSize, smallSize := 2, 1
+	if longWords {
+		wordSize, smallSize = 4, 2
+	}
+	rowSize := wordCount*wordSize + (regionIndexCount-wordCount)*smallSize
+	rowOffset := 6 + regionIndexCount*2 + inner*rowSize
+	if wordCount > regionIndexCount || rowOffset+rowSize > len(varData) {
+		return nil, nil
+	}
+
+	regionIndices = make([]uint16, regionIndexCount)
+	deltas = make([]int32, regionIndexCount)
+	row := varData[rowOffset:]
+	off := 0
+	for i := range regionIndices {
+		regionIndices[i] = binary.BigEndian.Uint16(varData[6+i*2:])
+		size := smallSize
+		if i < wordCount {
+			size = wordSize
+		}
+		switch size {
+		case 1:
+			deltas[i] = int32(int8(row[off]))
+		case 2:
+			deltas[i] = int32(int16(binary.BigEndian.Uint16(row[off:])))
+		case 4:
+			deltas[i] = int32(binary.BigEndian.Uint32(row[off:]))
+		}
+		off += size
+	}
+	return regionIndices, deltas
+}
+
+// RegionAxisCoordinates is the start, peak and end of a region along one
+// axis, in F2DOT14.
+type RegionAxisCoordinates struct {
+	Start, Peak, End int16
+}
+
+// AxisCount returns the number of axes of each region.
+func (rl *VarRegionList) AxisCount() int {
+	return rl.axisCount
+}
+
+// RegionCount returns the number of regions.
+func (rl *VarRegionList) RegionCount() int {
+	return rl.regionCount
+}
+
+// Region returns the per-axis coordinates of a region, or nil if the
+// index is out of range.
+func (rl *VarRegionList) Region(regionIndex int) []RegionAxisCoordinates {
+	if rl == nil || regionIndex < 0 || regionIndex >= rl.regionCount {
+		return nil
+	}
+	axes := make([]RegionAxisCoordinates, rl.axisCount)
+	off := 4 + regionIndex*rl.axisCount*6
+	for i := range axes {
+		axes[i] = RegionAxisCoordinates{
+			Start: int16(binary.BigEndian.Uint16(rl.data[off:])),
+			Peak:  int16(binary.BigEndian.Uint16(rl.data[off+2:])),
+			End:   int16(binary.BigEndian.Uint16(rl.data[off+4:])),
+		}
+		off += 6
+	}
+	return axes
+}
+
+// Count returns the number of entries in the map.
+func (dm *DeltaSetIndexMap) Count() int {
+	return int(dm.mapCount)
+}
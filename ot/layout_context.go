@@ -0,0 +1,155 @@
+package ot
+
+// SequenceContext is a format-independent view of a contextual (GSUB 5,
+// GPOS 7) or chained contextual (GSUB 6, GPOS 8) subtable. It exposes
+// the parsed data for tools such as the subsetter; shaping uses the
+// subtables directly.
+//
+// HarfBuzz equivalent: OT::Context / OT::ChainContext
+// (hb-ot-layout-gsubgpos.hh), which GSUB and GPOS share in the same way.
+type SequenceContext struct {
+	Format  uint16
+	Chained bool
+
+	// Formats 1 and 2: coverage of the first input glyph.
+	Coverage *Coverage
+
+	// Format 2: class definitions. Non-chained subtables only set
+	// InputClassDef.
+	BacktrackClassDef *ClassDef
+	InputClassDef     *ClassDef
+	LookaheadClassDef *ClassDef
+
+	// Formats 1 and 2: rule sets, indexed by coverage index (format 1)
+	// or by the class of the first input glyph (format 2). Rules hold
+	// glyph IDs (format 1) or class values (format 2); Backtrack and
+	// Lookahead are empty for non-chained subtables.
+	RuleSets [][]ChainRule
+
+	// Format 3: one coverage per sequence position.
+	BacktrackCoverages []*Coverage
+	InputCoverages     []*Coverage
+	LookaheadCoverages []*Coverage
+	LookupRecords      []LookupRecord
+}
+
+// SequenceContext returns the subtable's format-independent view.
+func (cs *ContextSubst) SequenceContext() *SequenceContext {
+	sc := &SequenceContext{
+		Format:         cs.format,
+		Coverage:       cs.coverage,
+		InputClassDef:  cs.classDef,
+		InputCoverages: cs.inputCoverages,
+		LookupRecords:  cs.lookupRecords,
+	}
+	if cs.ruleSets != nil {
+		sc.RuleSets = make([][]ChainRule, len(cs.ruleSets))
+		for i, rules := range cs.ruleSets {
+			for _, r := range rules {
+				sc.RuleSets[i] = append(sc.RuleSets[i], ChainRule{Input: r.Input, LookupRecords: r.LookupRecords})
+			}
+		}
+	}
+	return sc
+}
+
+// SequenceContext returns the subtable's format-independent view.
+func (ccs *ChainContextSubst) SequenceContext() *SequenceContext {
+	return &SequenceContext{
+		Format:             ccs.format,
+		Chained:            true,
+		Coverage:           ccs.coverage,
+		BacktrackClassDef:  ccs.backtrackClassDef,
+		InputClassDef:      ccs.inputClassDef,
+		LookaheadClassDef:  ccs.lookaheadClassDef,
+		RuleSets:           ccs.chainRuleSets,
+		BacktrackCoverages: ccs.backtrackCoverages,
+		InputCoverages:     ccs.inputCoverages,
+		LookaheadCoverages: ccs.lookaheadCoverages,
+		LookupRecords:      ccs.lookupRecords,
+	}
+}
+
+// SequenceContext returns the subtable's format-independent view.
+func (cp *ContextPos) SequenceContext() *SequenceContext {
+	sc := &SequenceContext{
+		Format:         cp.format,
+		Coverage:       cp.coverage,
+		InputClassDef:  cp.classDef,
+		InputCoverages: cp.inputCoverages,
+		LookupRecords:  gposLookupRecords(cp.lookupRecords),
+	}
+	if cp.ruleSets != nil {
+		sc.RuleSets = make([][]ChainRule, len(cp.ruleSets))
+		for i, rules := range cp.ruleSets {
+			for _, r := range rules {
+				sc.RuleSets[i] = append(sc.RuleSets[i], ChainRule{
+					Input:         r.Input,
+					LookupRecords: gposLookupRecords(r.LookupRecords),
+				})
+			}
+		}
+	}
+	return sc
+}
+
+// SequenceContext returns the subtable's format-independent view.
+func (ccp *ChainContextPos) SequenceContext() *SequenceContext {
+	sc := &SequenceContext{
+		Format:             ccp.format,
+		Chained:            true,
+		Coverage:           ccp.coverage,
+		BacktrackClassDef:  ccp.backtrackClassDef,
+		InputClassDef:      ccp.inputClassDef,
+		LookaheadClassDef:  ccp.lookaheadClassDef,
+		BacktrackCoverages: ccp.backtrackCoverages,
+		InputCoverages:     ccp.inputCoverages,
+		LookaheadCoverages: ccp.lookaheadCoverages,
+		LookupRecords:      gposLookupRecords(ccp.lookupRecords),
+	}
+	if ccp.chainRuleSets != nil {
+		sc.RuleSets = make([][]ChainRule, len(ccp.chainRuleSets))
+		for i, rules := range ccp.chainRuleSets {
+			for _, r := range rules {
+				sc.RuleSets[i] = append(sc.RuleSets[i], ChainRule{
+					Backtrack:     r.Backtrack,
+					Input:         r.Input,
+					Lookahead:     r.Lookahead,
+					LookupRecords: gposLookupRecords(r.LookupRecords),
+				})
+			}
+		}
+	}
+	return sc
+}
+
+func gposLookupRecords(records []GPOSLookupRecord) []LookupRecord {
+	if records == nil {
+		return nil
+	}
+	out := make([]LookupRecord, len(records))
+	for i, r := range records {
+		out[i] = LookupRecord(r)
+	}
+	return out
+}
+
+// Coverage returns the coverage of the substituted glyph.
+func (r *ReverseChainSingleSubst) Coverage() *Coverage {
+	return r.coverage
+}
+
+// BacktrackCoverages returns the backtrack coverages, nearest first.
+func (r *ReverseChainSingleSubst) BacktrackCoverages() []*Coverage {
+	return r.backtrackCoverages
+}
+
+// LookaheadCoverages returns the lookahead coverages.
+func (r *ReverseChainSingleSubst) LookaheadCoverages() []*Coverage {
+	return r.lookaheadCoverages
+}
+
+// Substitutes returns the substitute glyphs in coverage index order.
+func (r *ReverseChainSingleSubst) Substitutes() []GlyphID {
+	return r.substitutes
+}
@@ -207,6 +207,28 @@ func (p *Post) ItalicAngleDegrees() float64 {
 // Name represents the name table.
 type Name struct {
 	entries map[uint16]string // nameID -> string
+	records []NameRecord
+}
+
+// NameRecord is a record of the name table with its encoded string.
+type NameRecord struct {
+	PlatformID uint16
+	EncodingID uint16
+	LanguageID uint16
+	NameID     uint16
+	Value      []byte
+}
+
+// String decodes the record: UTF-16BE for the Unicode and Windows
+// platforms, bytes as Latin-1 for Mac Roman. Other encodings decode to "".
+func (r NameRecord) String() string {
+	switch {
+	case r.PlatformID == 0 || r.PlatformID == 3:
+		return decodeUTF16BE(r.Value)
+	case r.PlatformID == 1 && r.EncodingID == 0:
+		return string(r.Value)
+	}
+	return ""
 }
 
 // ParseName parses the name table.
@@ -233,7 +255,7 @@ func ParseName(data []byte) (*Name, error) {
 
 		platformID := binary.BigEndian.Uint16(data[recordOffset:])
 		encodingID := binary.BigEndian.Uint16(data[recordOffset+2:])
-		// languageID := binary.BigEndian.Uint16(data[recordOffset+4:])
+		languageID := binary.BigEndian.Uint16(data[recordOffset+4:])
 		nameID := binary.BigEndian.Uint16(data[recordOffset+6:])
 		length := binary.BigEndian.Uint16(data[recordOffset+8:])
 		offset := binary.BigEndian.Uint16(data[recordOffset+10:])
@@ -247,6 +269,13 @@ func ParseName(data []byte) (*Name, error) {
 		}
 
 		stringData := data[stringOffset : stringOffset+int(length)]
+		n.records = append(n.records, NameRecord{
+			PlatformID: platformID,
+			EncodingID: encodingID,
+			LanguageID: languageID,
+			NameID:     nameID,
+			Value:      stringData,
+		})
 
 		var str string
 		if platformID == 3 || platformID == 0 {
@@ -276,6 +305,11 @@ func decodeUTF16BE(data []byte) string {
 	return string(runes)
 }
 
+// Records returns all records of the name table in table order.
+func (n *Name) Records() []NameRecord {
+	return n.records
+}
+
 // Get returns the string for a nameID.
 func (n *Name) Get(nameID uint16) string {
 	return n.entries[nameID]
@@ -308,17 +342,33 @@ type Face struct {
 	name     *Name
 	cmap     *Cmap
 	fvar     *Fvar
+	outlines *faceOutlines
+	upem     uint16
+	isCFF    bool
+
+	// Synthetic bold/slant applied by DrawGlyph, GlyphOutline and
+	// GlyphExtents (HarfBuzz: hb_font_t x_strength, y_strength,
+	// embolden_in_place, slant_xy). Strengths are in font units.
+	xEmbolden       float32
+	yEmbolden       float32
+	xStrength       int16
+	yStrength       int16
+	emboldenInPlace bool
+	slant           float32
+}
+
+// faceOutlines holds the lazily parsed outline tables of a Face. Faces
+// derived with other synthetic settings share it.
+type faceOutlines struct {
 	glyf     *Glyf
 	cff      *CFF
 	glyfOnce sync.Once
 	cffOnce  sync.Once
-	upem     uint16
-	isCFF    bool
 }
 
 // NewFace creates a new Face from a Font, parsing required tables.
 func NewFace(font *Font) (*Face, error) {
-	f := &Face{Font: font}
+	f := &Face{Font: font, outlines: &faceOutlines{}}
 
 	// Parse head (required)
 	if data, err := font.TableData(TagHead); err == nil {
@@ -557,24 +607,26 @@ func (f *Face) Fvar() *Fvar {
 
 // getGlyf returns the parsed glyf table, lazily initializing it.
 func (f *Face) getGlyf() *Glyf {
-	f.glyfOnce.Do(func() {
+	o := f.outlines
+	o.glyfOnce.Do(func() {
 		if !f.isCFF {
-			f.glyf, _ = ParseGlyfFromFont(f.Font)
+			o.glyf, _ = ParseGlyfFromFont(f.Font)
 		}
 	})
-	return f.glyf
+	return o.glyf
 }
 
 // getCFF returns the parsed CFF table, lazily initializing it.
 func (f *Face) getCFF() *CFF {
-	f.cffOnce.Do(func() {
+	o := f.outlines
+	o.cffOnce.Do(func() {
 		if f.isCFF {
 			if data, err := f.Font.TableData(TagCFF); err == nil {
-				f.cff, _ = ParseCFF(data)
+				o.cff, _ = ParseCFF(data)
 			}
 		}
 	})
-	return f.cff
+	return o.cff
 }
 
 // LoadFaceFromData loads a font from byte data and returns a Face.
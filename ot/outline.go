@@ -33,9 +33,11 @@ type GlyphOutline struct {
 	Segments []Segment
 }
 
-// pathBuilder converts raw TrueType contour points into path segments.
+// pathBuilder converts raw TrueType contour points into drawing
+// operations on a Pen.
 // This is a port of HarfBuzz's path-builder.hh logic.
 type pathBuilder struct {
+	pen Pen
 
 	// State tracking for the current contour.
 	// TrueType contours can start with off-curve points, and consecutive
@@ -43,28 +45,20 @@ type pathBuilder struct {
 	firstOnCurve  *OutlinePoint // first on-curve point seen
 	firstOffCurve *OutlinePoint // first off-curve point (if contour starts off-curve)
 	lastOffCurve  *OutlinePoint // pending off-curve point from previous consumePoint
-	segments      []Segment
+	drawn         bool          // a MoveTo has been emitted
 }
 
 func (pb *pathBuilder) moveTo(p OutlinePoint) {
-	pb.segments = append(pb.segments, Segment{
-		Op:   SegmentMoveTo,
-		Args: [3]OutlinePoint{p},
-	})
+	pb.pen.MoveTo(p.X, p.Y)
+	pb.drawn = true
 }
 
 func (pb *pathBuilder) lineTo(p OutlinePoint) {
-	pb.segments = append(pb.segments, Segment{
-		Op:   SegmentLineTo,
-		Args: [3]OutlinePoint{p},
-	})
+	pb.pen.LineTo(p.X, p.Y)
 }
 
 func (pb *pathBuilder) quadTo(ctrl, end OutlinePoint) {
-	pb.segments = append(pb.segments, Segment{
-		Op:   SegmentQuadTo,
-		Args: [3]OutlinePoint{ctrl, end},
-	})
+	pb.pen.QuadTo(ctrl.X, ctrl.Y, end.X, end.Y)
 }
 
 // consumePoint processes a single contour point. The onCurve flag indicates
@@ -129,6 +123,7 @@ func (pb *pathBuilder) contourEnd() {
 			pb.moveTo(mid)
 			pb.quadTo(*pb.lastOffCurve, *pb.firstOffCurve)
 			pb.quadTo(*pb.firstOffCurve, mid)
+			pb.pen.Close()
 		}
 		pb.reset()
 		return
@@ -149,6 +144,7 @@ func (pb *pathBuilder) contourEnd() {
 	} else {
 		pb.lineTo(*pb.firstOnCurve)
 	}
+	pb.pen.Close()
 
 	pb.reset()
 }
@@ -170,42 +166,93 @@ func midpoint(a, b OutlinePoint) OutlinePoint {
 // Returns the outline and true if the glyph has outline data.
 // For empty glyphs (e.g. space), returns an empty outline and false.
 // Supports both TrueType (glyf table, quadratic) and CFF (cubic) outlines.
+// DrawGlyph streams the same path without allocating the segment slice.
 func (f *Face) GlyphOutline(gid GlyphID) (GlyphOutline, bool) {
+	var rec outlineRecorder
+	if !f.DrawGlyph(gid, &rec) || len(rec.segments) == 0 {
+		return GlyphOutline{}, false
+	}
+	return GlyphOutline{Segments: rec.segments}, true
+}
+
+// DrawGlyph streams the outline of gid to pen in font units and reports
+// whether the glyph has outline data. Empty glyphs (e.g. space) draw
+// nothing and return false. Synthetic slant and bold set with
+// SetSyntheticSlant and SetSyntheticBold are applied.
+//
+// HarfBuzz equivalent: hb_font_draw_glyph (hb-font.cc), dispatching to
+// OT::glyf_accelerator_t::get_path (hb-ot-glyf-table.hh) and
+// OT::cff1::accelerator_t::get_path (hb-ot-cff1-table.cc:444-564).
+func (f *Face) DrawGlyph(gid GlyphID, pen Pen) bool {
+	if f.xStrength == 0 && f.yStrength == 0 {
+		if f.slant != 0 {
+			pen = &TransformPen{Pen: pen, Matrix: f.slantMatrix()}
+		}
+		return f.drawGlyphRaw(gid, pen)
+	}
+
+	// Embolden needs the whole contour, so record it first.
+	// HarfBuzz: hb_font_t::draw_glyph (hb-font.hh)
+	var o syntheticOutline
+	var rec Pen = &o
+	if f.slant != 0 {
+		rec = &TransformPen{Pen: rec, Matrix: f.slantMatrix()}
+	}
+	if !f.drawGlyphRaw(gid, rec) {
+		return false
+	}
+	o.Close()
+	var xShift float32
+	if !f.emboldenInPlace {
+		xShift = float32(f.xStrength) / 2
+	}
+	yShift := float32(f.yStrength) / 2
+	o.embolden(float32(f.xStrength), float32(f.yStrength), xShift, yShift)
+	o.replay(pen)
+	return true
+}
+
+// drawGlyphRaw streams the unmodified font outline of gid to pen.
+func (f *Face) drawGlyphRaw(gid GlyphID, pen Pen) bool {
 	if f.isCFF {
-		// CFF outline extraction.
-		// HarfBuzz equivalent: OT::cff1::accelerator_t::get_path in hb-ot-cff1-table.cc:444-564
 		cff := f.getCFF()
 		if cff == nil {
-			return GlyphOutline{}, false
+			return false
 		}
-		return cffGlyphOutline(cff, gid)
+		return cffDrawGlyph(cff, gid, pen)
 	}
 
 	g := f.getGlyf()
 	if g == nil {
-		return GlyphOutline{}, false
+		return false
 	}
-
-	return g.glyphOutline(gid)
+	return g.drawGlyph(gid, pen, 0)
 }
 
-// glyphOutline extracts the outline for a glyph from the glyf table.
-func (g *Glyf) glyphOutline(gid GlyphID) (GlyphOutline, bool) {
+// maxCompositeDepth bounds composite glyph recursion.
+// HarfBuzz equivalent: HB_MAX_NESTING_LEVEL (hb-limits.hh)
+const maxCompositeDepth = 64
+
+// drawGlyph streams the outline for a glyph from the glyf table.
+func (g *Glyf) drawGlyph(gid GlyphID, pen Pen, depth int) bool {
+	if depth > maxCompositeDepth {
+		return false
+	}
 	glyph := g.GetGlyph(gid)
 	if glyph == nil || glyph.Data == nil {
-		return GlyphOutline{}, false
+		return false
 	}
 
 	if glyph.NumberOfContours >= 0 {
-		return g.simpleGlyphOutline(glyph)
+		return g.drawSimpleGlyph(glyph, pen)
 	}
-	return g.compositeGlyphOutline(glyph)
+	return g.drawCompositeGlyph(glyph, pen, depth)
 }
 
-// simpleGlyphOutline extracts the outline for a simple glyph.
-func (g *Glyf) simpleGlyphOutline(glyph *GlyphData) (GlyphOutline, bool) {
+// drawSimpleGlyph streams the outline for a simple glyph.
+func (g *Glyf) drawSimpleGlyph(glyph *GlyphData, pen Pen) bool {
 	if glyph.NumberOfContours == 0 {
-		return GlyphOutline{}, false
+		return false
 	}
 
 	data := glyph.Data
@@ -213,64 +260,43 @@ func (g *Glyf) simpleGlyphOutline(glyph *GlyphData) (GlyphOutline, bool) {
 
 	// Read endPtsOfContours (at offset 10 after glyph header).
 	if len(data) < 10+numContours*2 {
-		return GlyphOutline{}, false
-	}
-	endPts := make([]int, numContours)
-	for i := 0; i < numContours; i++ {
-		endPts[i] = int(binary.BigEndian.Uint16(data[10+i*2:]))
+		return false
 	}
 
 	points, _, err := ParseSimpleGlyph(data)
 	if err != nil || len(points) == 0 {
-		return GlyphOutline{}, false
+		return false
 	}
 
-	var pb pathBuilder
+	pb := pathBuilder{pen: pen}
 	contourIdx := 0
+	nextEnd := int(binary.BigEndian.Uint16(data[10:]))
 	for i, pt := range points {
 		pb.consumePoint(float32(pt.X), float32(pt.Y), pt.OnCurve)
-		if contourIdx < numContours && i == endPts[contourIdx] {
+		if contourIdx < numContours && i == nextEnd {
 			pb.contourEnd()
 			contourIdx++
+			if contourIdx < numContours {
+				nextEnd = int(binary.BigEndian.Uint16(data[10+contourIdx*2:]))
+			}
 		}
 	}
 
-	if len(pb.segments) == 0 {
-		return GlyphOutline{}, false
-	}
-	return GlyphOutline{Segments: pb.segments}, true
+	return pb.drawn
 }
 
-// compositeGlyphOutline extracts the outline for a composite glyph by
-// recursively resolving components and applying their transforms.
-func (g *Glyf) compositeGlyphOutline(glyph *GlyphData) (GlyphOutline, bool) {
+// drawCompositeGlyph streams the outline for a composite glyph by
+// recursively drawing its components through their affine transforms.
+func (g *Glyf) drawCompositeGlyph(glyph *GlyphData, pen Pen, depth int) bool {
 	components := g.parseCompositeWithTransform(glyph.Data)
-	if len(components) == 0 {
-		return GlyphOutline{}, false
-	}
-
-	var allSegments []Segment
+	drawn := false
 	for _, comp := range components {
-		sub, ok := g.glyphOutline(comp.GlyphID)
-		if !ok {
-			continue
-		}
-		// Apply the component's affine transform to each segment point.
-		for _, seg := range sub.Segments {
-			var transformed Segment
-			transformed.Op = seg.Op
-			n := argsCount(seg.Op)
-			for j := 0; j < n; j++ {
-				transformed.Args[j] = comp.transformPoint(seg.Args[j])
-			}
-			allSegments = append(allSegments, transformed)
+		tp := TransformPen{Pen: pen, Matrix: comp.matrix()}
+		if g.drawGlyph(comp.GlyphID, &tp, depth+1) {
+			drawn = true
 		}
 	}
-
-	if len(allSegments) == 0 {
-		return GlyphOutline{}, false
-	}
-	return GlyphOutline{Segments: allSegments}, true
+	return drawn
 }
 
 // argsCount returns the number of meaningful Args entries for a segment op.
@@ -297,11 +323,9 @@ type compositeTransform struct {
 	yx, yy  float32
 }
 
-func (ct *compositeTransform) transformPoint(p OutlinePoint) OutlinePoint {
-	return OutlinePoint{
-		X: ct.xx*p.X + ct.xy*p.Y + ct.dx,
-		Y: ct.yx*p.X + ct.yy*p.Y + ct.dy,
-	}
+// matrix returns the component transform as an AffineMatrix.
+func (ct *compositeTransform) matrix() AffineMatrix {
+	return AffineMatrix{XX: ct.xx, YX: ct.yx, XY: ct.xy, YY: ct.yy, DX: ct.dx, DY: ct.dy}
 }
 
 // parseCompositeWithTransform parses composite glyph components including
@@ -397,15 +421,16 @@ type GlyphBBox struct {
 // GlyphExtents returns the bounding box of the given glyph's outline.
 // Works for both TrueType (glyf) and CFF/CFF2 outlines. Returns
 // (zero-bbox, false) for glyphs with no outline (space, control
-// characters, missing data).
+// characters, missing data). Synthetic slant and bold grow the box
+// the way hb_font_get_glyph_extents does.
 //
 // Used by math typesetting to replace the pauschal "Height = Size −
 // Depth" model with per-glyph extents — important for sub/sup placement
 // against ascender-only letters (a, c, e) vs ascender + descender
 // letters (g, p, q).
 func (f *Face) GlyphExtents(gid GlyphID) (GlyphBBox, bool) {
-	o, ok := f.GlyphOutline(gid)
-	if !ok || len(o.Segments) == 0 {
+	var o outlineRecorder
+	if !f.drawGlyphRaw(gid, &o) || len(o.segments) == 0 {
 		return GlyphBBox{}, false
 	}
 	first := true
@@ -430,7 +455,7 @@ func (f *Face) GlyphExtents(gid GlyphID) (GlyphBBox, bool) {
 			maxY = p.Y
 		}
 	}
-	for _, s := range o.Segments {
+	for _, s := range o.segments {
 		n := argsCount(s.Op)
 		for i := 0; i < n; i++ {
 			consume(s.Args[i])
@@ -439,10 +464,12 @@ func (f *Face) GlyphExtents(gid GlyphID) (GlyphBBox, bool) {
 	if first {
 		return GlyphBBox{}, false
 	}
-	return GlyphBBox{
+	bbox := GlyphBBox{
 		XMin: int16(math.Round(float64(minX))),
 		YMin: int16(math.Round(float64(minY))),
 		XMax: int16(math.Round(float64(maxX))),
 		YMax: int16(math.Round(float64(maxY))),
-	}, true
+	}
+	f.syntheticGlyphExtents(&bbox)
+	return bbox, true
 }
@@ -268,7 +268,8 @@ func TestPathBuilderImplicitOnCurve(t *testing.T) {
 	// Points: off(0,100), off(100,100), on(100,0), on(0,0)
 	// Between the two off-curve points, an implicit on-curve midpoint (50,100)
 	// should be generated.
-	var pb pathBuilder
+	var rec outlineRecorder
+	pb := pathBuilder{pen: &rec}
 
 	pb.consumePoint(0, 100, false)
 	pb.consumePoint(100, 100, false)
@@ -276,16 +277,16 @@ func TestPathBuilderImplicitOnCurve(t *testing.T) {
 	pb.consumePoint(0, 0, true)
 	pb.contourEnd()
 
-	if len(pb.segments) == 0 {
+	if len(rec.segments) == 0 {
 		t.Fatal("Expected segments from pathBuilder")
 	}
-	if pb.segments[0].Op != SegmentMoveTo {
-		t.Errorf("First segment should be MoveTo, got %d", pb.segments[0].Op)
+	if rec.segments[0].Op != SegmentMoveTo {
+		t.Errorf("First segment should be MoveTo, got %d", rec.segments[0].Op)
 	}
 
 	// Should have at least one QuadTo from the implicit midpoint logic.
 	hasQuad := false
-	for _, seg := range pb.segments {
+	for _, seg := range rec.segments {
 		if seg.Op == SegmentQuadTo {
 			hasQuad = true
 			break
@@ -56,6 +56,7 @@ var (
 	TagVhea = MakeTag('v', 'h', 'e', 'a')
 	TagVmtx = MakeTag('v', 'm', 't', 'x')
 	TagVORG = MakeTag('V', 'O', 'R', 'G')
+	TagBASE = MakeTag('B', 'A', 'S', 'E')
 )
 
 // Parser provides methods for reading binary OpenType data.
@@ -79,6 +79,22 @@ var macRomanNames = [258]string{
 	"Ccaron", "ccaron", "dcroat",
 }
 
+// macRomanNameIndex maps the standard glyph names to their index.
+var macRomanNameIndex = func() map[string]int {
+	m := make(map[string]int, len(macRomanNames))
+	for i, name := range macRomanNames {
+		m[name] = i
+	}
+	return m
+}()
+
+// StandardGlyphNameIndex returns the index of a glyph name in the 258
+// standard Macintosh glyph names of post format 1.0 and 2.0.
+func StandardGlyphNameIndex(name string) (int, bool) {
+	i, ok := macRomanNameIndex[name]
+	return i, ok
+}
+
 // ParsePostTable parses the post table with full glyph name support.
 // HarfBuzz equivalent: hb-ot-post-table.hh accelerator_t::init()
 func ParsePostTable(data []byte) (*PostTable, error) {
@@ -0,0 +1,369 @@
+package ot
+
+import (
+	"image"
+	"math"
+)
+
+// Anti-aliased scanline rasterizer for glyph outlines.
+//
+// HarfBuzz leaves rasterization to the client (FreeType, Skia, …); we
+// provide a small one so that specimens and previews can be produced
+// without cgo. The algorithm is the signed-area accumulation approach
+// used by font-rs and golang.org/x/image/vector: every line segment
+// deposits, per pixel, the signed area it sweeps to its right; a running
+// sum along each row then yields the winding number weighted by
+// coverage. Taking min(|sum|, 1) is the nonzero fill rule (for overlap
+// regions the winding number exceeds 1 and saturates), which matches
+// how TrueType and CFF outlines are meant to be filled.
+//
+// Curves are flattened into line segments with a tolerance of
+// rasterFlatness pixels. All coordinates are float32 pixels with the y
+// axis pointing down, so sub-pixel glyph positions are preserved.
+
+// rasterFlatness is the maximum distance in pixels between a curve and
+// its flattened polyline.
+const rasterFlatness = 0.1
+
+// Rasterizer accumulates filled paths into a coverage buffer.
+//
+// Coordinates are in pixels relative to the rasterizer's bounds: the
+// pixel at Bounds().Min covers the unit square [Min.X, Min.X+1) ×
+// [Min.Y, Min.Y+1). Geometry outside the bounds is clipped.
+type Rasterizer struct {
+	// acc holds one row of (width + 2) cells per scanline; the two
+	// extra cells absorb area deposited right of the bounds so that
+	// each row still sums to zero.
+	acc    []float32
+	bounds image.Rectangle
+	stride int
+
+	penX, penY     float32
+	startX, startY float32
+	open           bool
+}
+
+// NewRasterizer returns a rasterizer covering the pixel rectangle r.
+func NewRasterizer(r image.Rectangle) *Rasterizer {
+	z := &Rasterizer{}
+	z.Reset(r)
+	return z
+}
+
+// Reset clears the coverage buffer and sets new bounds.
+func (z *Rasterizer) Reset(r image.Rectangle) {
+	z.bounds = r.Canon()
+	z.stride = z.bounds.Dx() + 2
+	n := z.stride * z.bounds.Dy()
+	if cap(z.acc) < n {
+		z.acc = make([]float32, n)
+	} else {
+		z.acc = z.acc[:n]
+		clear(z.acc)
+	}
+	z.open = false
+}
+
+// Bounds returns the pixel rectangle covered by the rasterizer.
+func (z *Rasterizer) Bounds() image.Rectangle {
+	return z.bounds
+}
+
+// MoveTo starts a new contour at (x, y), closing the current one.
+func (z *Rasterizer) MoveTo(x, y float32) {
+	z.Close()
+	z.penX, z.penY = x, y
+	z.startX, z.startY = x, y
+	z.open = true
+}
+
+// LineTo adds a straight segment from the pen to (x, y).
+func (z *Rasterizer) LineTo(x, y float32) {
+	z.line(z.penX, z.penY, x, y)
+	z.penX, z.penY = x, y
+}
+
+// QuadTo adds a quadratic Bézier segment with control point (cx, cy).
+func (z *Rasterizer) QuadTo(cx, cy, x, y float32) {
+	ax, ay := z.penX, z.penY
+	// Chord deviation after n uniform steps is |P0 - 2P1 + P2| / (4n²).
+	ddx, ddy := ax-2*cx+x, ay-2*cy+y
+	dd := float32(math.Sqrt(float64(ddx*ddx + ddy*ddy)))
+	n := int(math.Ceil(math.Sqrt(float64(dd / (4 * rasterFlatness)))))
+	if n <= 1 {
+		z.LineTo(x, y)
+		return
+	}
+	for i := 1; i < n; i++ {
+		t := float32(i) / float32(n)
+		mt := 1 - t
+		z.LineTo(
+			mt*mt*ax+2*mt*t*cx+t*t*x,
+			mt*mt*ay+2*mt*t*cy+t*t*y)
+	}
+	z.LineTo(x, y)
+}
+
+// CubicTo adds a cubic Bézier segment with control points (c1x, c1y)
+// and (c2x, c2y).
+func (z *Rasterizer) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	ax, ay := z.penX, z.penY
+	// |B''| ≤ 6·max(|P0 - 2P1 + P2|, |P1 - 2P2 + P3|); chord deviation
+	// after n steps is at most |B''| / (8n²).
+	d1x, d1y := ax-2*c1x+c2x, ay-2*c1y+c2y
+	d2x, d2y := c1x-2*c2x+x, c1y-2*c2y+y
+	dd := math.Sqrt(float64(max(d1x*d1x+d1y*d1y, d2x*d2x+d2y*d2y)))
+	n := int(math.Ceil(math.Sqrt(6 * dd / (8 * rasterFlatness))))
+	if n <= 1 {
+		z.LineTo(x, y)
+		return
+	}
+	for i := 1; i < n; i++ {
+		t := float32(i) / float32(n)
+		mt := 1 - t
+		a, b, c, d := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+		z.LineTo(a*ax+b*c1x+c*c2x+d*x, a*ay+b*c1y+c*c2y+d*y)
+	}
+	z.LineTo(x, y)
+}
+
+// Close closes the current contour with a line back to its start.
+func (z *Rasterizer) Close() {
+	if z.open && (z.penX != z.startX || z.penY != z.startY) {
+		z.LineTo(z.startX, z.startY)
+	}
+	z.open = false
+}
+
+// line deposits the signed area of the segment (ax, ay)-(bx, by).
+func (z *Rasterizer) line(ax, ay, bx, by float32) {
+	ax -= float32(z.bounds.Min.X)
+	bx -= float32(z.bounds.Min.X)
+	ay -= float32(z.bounds.Min.Y)
+	by -= float32(z.bounds.Min.Y)
+	dir := float32(1)
+	if ay > by {
+		dir, ax, ay, bx, by = -1, bx, by, ax, ay
+	}
+	// Near-horizontal segments contribute (almost) nothing and would
+	// make dxdy numerically unstable.
+	if by-ay <= 1e-6 {
+		return
+	}
+	height := z.bounds.Dy()
+	if by <= 0 || ay >= float32(height) {
+		return
+	}
+	dxdy := (bx - ax) / (by - ay)
+	x := ax
+	y := float32(math.Floor(float64(ay)))
+	yMax := float32(math.Ceil(float64(by)))
+	last := z.stride - 1
+	cell := func(row []float32, i int) *float32 {
+		return &row[max(0, min(i, last))]
+	}
+	for ; y < yMax; y++ {
+		dy := min(y+1, by) - max(y, ay)
+		xNext := x + dy*dxdy
+		if y < 0 {
+			x = xNext
+			continue
+		}
+		if int(y) >= height {
+			break
+		}
+		row := z.acc[int(y)*z.stride : (int(y)+1)*z.stride]
+		d := dy * dir
+		x0, x1 := x, xNext
+		if x0 > x1 {
+			x0, x1 = x1, x0
+		}
+		x0Floor := float32(math.Floor(float64(x0)))
+		x0i := int(x0Floor)
+		x1Ceil := float32(math.Ceil(float64(x1)))
+		x1i := int(x1Ceil)
+		if x1i <= x0i+1 {
+			// The segment stays within one pixel column.
+			xmf := 0.5*(x+xNext) - x0Floor
+			*cell(row, x0i) += d - d*xmf
+			*cell(row, x0i+1) += d * xmf
+		} else {
+			s := 1 / (x1 - x0)
+			x0f := x0 - x0Floor
+			oneMinusX0f := 1 - x0f
+			a0 := 0.5 * s * oneMinusX0f * oneMinusX0f
+			x1f := x1 - x1Ceil + 1
+			am := 0.5 * s * x1f * x1f
+			*cell(row, x0i) += d * a0
+			if x1i == x0i+2 {
+				*cell(row, x0i+1) += d * (1 - a0 - am)
+			} else {
+				a1 := s * (1.5 - x0f)
+				*cell(row, x0i+1) += d * (a1 - a0)
+				for xi := x0i + 2; xi < x1i-1; xi++ {
+					*cell(row, xi) += d * s
+				}
+				a2 := a1 + s*float32(x1i-x0i-3)
+				*cell(row, x1i-1) += d * (1 - a2 - am)
+			}
+			*cell(row, x1i) += d * am
+		}
+		x = xNext
+	}
+}
+
+// Draw composites the accumulated coverage onto dst with the "over"
+// operator. Pixels outside dst's bounds are skipped.
+func (z *Rasterizer) Draw(dst *image.Alpha) {
+	z.Close()
+	r := z.bounds.Intersect(dst.Rect)
+	if r.Empty() {
+		return
+	}
+	w := z.bounds.Dx()
+	for y := z.bounds.Min.Y; y < z.bounds.Max.Y; y++ {
+		row := z.acc[(y-z.bounds.Min.Y)*z.stride:]
+		var acc float32
+		for i := range w {
+			acc += row[i]
+			x := z.bounds.Min.X + i
+			if y < r.Min.Y || y >= r.Max.Y || x < r.Min.X || x >= r.Max.X {
+				continue
+			}
+			a := acc
+			if a < 0 {
+				a = -a
+			}
+			if a > 1 {
+				a = 1
+			}
+			if a == 0 {
+				continue
+			}
+			p := &dst.Pix[dst.PixOffset(x, y)]
+			*p += uint8(a*float32(255-*p) + 0.5)
+		}
+	}
+}
+
+// AddOutline adds a glyph outline in font units. scale converts font
+// units to pixels and (x, y) is the pixel position of the glyph origin;
+// the outline's y axis (up) is flipped to the raster's (down).
+func (z *Rasterizer) AddOutline(o GlyphOutline, scale, x, y float32) {
+	for _, s := range o.Segments {
+		a := s.Args
+		switch s.Op {
+		case SegmentMoveTo:
+			z.MoveTo(x+a[0].X*scale, y-a[0].Y*scale)
+		case SegmentLineTo:
+			z.LineTo(x+a[0].X*scale, y-a[0].Y*scale)
+		case SegmentQuadTo:
+			z.QuadTo(x+a[0].X*scale, y-a[0].Y*scale, x+a[1].X*scale, y-a[1].Y*scale)
+		case SegmentCubeTo:
+			z.CubicTo(x+a[0].X*scale, y-a[0].Y*scale, x+a[1].X*scale, y-a[1].Y*scale,
+				x+a[2].X*scale, y-a[2].Y*scale)
+		}
+	}
+	z.Close()
+}
+
+// pixelMatrix maps font units to pixels: scale converts the units, the
+// y axis is flipped and the glyph origin lands on (x, y).
+func pixelMatrix(scale, x, y float32) AffineMatrix {
+	return AffineMatrix{XX: scale, YY: -scale, DX: x, DY: y}
+}
+
+// glyphPixelBounds streams gid through bp and returns the pixel
+// rectangle covering its ink under m. bp is reset first so that callers
+// can reuse it across glyphs.
+func (f *Face) glyphPixelBounds(gid GlyphID, m AffineMatrix, bp *BoundsPen) image.Rectangle {
+	bp.Reset()
+	if !f.DrawGlyph(gid, &TransformPen{Pen: bp, Matrix: m}) {
+		return image.Rectangle{}
+	}
+	xMin, yMin, xMax, yMax, ok := bp.Bounds()
+	if !ok {
+		return image.Rectangle{}
+	}
+	return image.Rect(
+		int(math.Floor(float64(xMin))), int(math.Floor(float64(yMin))),
+		int(math.Ceil(float64(xMax))), int(math.Ceil(float64(yMax))))
+}
+
+// RenderGlyph rasterizes gid at ppem pixels per em with its origin at
+// the (possibly fractional) pixel position (x, y). The returned image's
+// Rect is the glyph's pixel bounding box in that coordinate system, so
+// it can be composited with draw.Draw at its own bounds. Returns nil for
+// glyphs without an outline.
+func (f *Face) RenderGlyph(gid GlyphID, ppem, x, y float32) *image.Alpha {
+	m := pixelMatrix(ppem/float32(f.upem), x, y)
+	var bp BoundsPen
+	r := f.glyphPixelBounds(gid, m, &bp)
+	if r.Empty() {
+		return nil
+	}
+	dst := image.NewAlpha(r)
+	z := NewRasterizer(r)
+	f.DrawGlyph(gid, &TransformPen{Pen: z, Matrix: m})
+	z.Draw(dst)
+	return dst
+}
+
+// DrawBuffer rasterizes a shaped buffer into dst at ppem pixels per em.
+// (x, y) is the pixel position of the first glyph's origin on the
+// baseline. Glyph positions are advanced by each GlyphPos' XAdvance and
+// YAdvance and displaced by XOffset/YOffset, all in font units; glyphs
+// keep their fractional pixel positions. Coverage is composited onto
+// dst with the "over" operator.
+func (f *Face) DrawBuffer(dst *image.Alpha, buf *Buffer, ppem, x, y float32) {
+	scale := ppem / float32(f.upem)
+	z := &Rasterizer{}
+	var bp BoundsPen
+	tp := &TransformPen{Pen: z}
+	f.forEachPositionedGlyph(buf, scale, x, y, func(gid GlyphID, gx, gy float32) {
+		tp.Matrix = pixelMatrix(scale, gx, gy)
+		r := f.glyphPixelBounds(gid, tp.Matrix, &bp).Intersect(dst.Rect)
+		if r.Empty() {
+			return
+		}
+		z.Reset(r)
+		f.DrawGlyph(gid, tp)
+		z.Draw(dst)
+	})
+}
+
+// RenderBuffer rasterizes a shaped buffer at ppem pixels per em into a
+// new image whose Rect is the ink bounding box, in pixel coordinates
+// relative to the origin of the first glyph (so Rect.Min.Y is typically
+// negative). Returns nil if no glyph has an outline.
+func (f *Face) RenderBuffer(buf *Buffer, ppem float32) *image.Alpha {
+	scale := ppem / float32(f.upem)
+	var bounds image.Rectangle
+	var bp BoundsPen
+	f.forEachPositionedGlyph(buf, scale, 0, 0, func(gid GlyphID, gx, gy float32) {
+		bounds = bounds.Union(f.glyphPixelBounds(gid, pixelMatrix(scale, gx, gy), &bp))
+	})
+	if bounds.Empty() {
+		return nil
+	}
+	dst := image.NewAlpha(bounds)
+	f.DrawBuffer(dst, buf, ppem, 0, 0)
+	return dst
+}
+
+// forEachPositionedGlyph calls fn with the glyph ID and pixel origin of
+// every glyph in buf.
+func (f *Face) forEachPositionedGlyph(buf *Buffer, scale, x, y float32, fn func(gid GlyphID, gx, gy float32)) {
+	var penX, penY float32
+	for i, info := range buf.Info {
+		var pos GlyphPos
+		if i < len(buf.Pos) {
+			pos = buf.Pos[i]
+		}
+		gx := x + (penX+float32(pos.XOffset))*scale
+		gy := y - (penY+float32(pos.YOffset))*scale
+		fn(info.GlyphID, gx, gy)
+		penX += float32(pos.XAdvance)
+		penY += float32(pos.YAdvance)
+	}
+}
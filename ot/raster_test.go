@@ -0,0 +1,195 @@
+package ot
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden images in testdata/raster")
+
+// TestRasterizerSquareCoverage checks the exact area coverage of an
+// axis-aligned square placed on half-pixel boundaries: corners are a
+// quarter covered, edges half, the interior fully.
+func TestRasterizerSquareCoverage(t *testing.T) {
+	z := NewRasterizer(image.Rect(0, 0, 5, 5))
+	z.MoveTo(1.5, 1.5)
+	z.LineTo(3.5, 1.5)
+	z.LineTo(3.5, 3.5)
+	z.LineTo(1.5, 3.5)
+	z.Close()
+	dst := image.NewAlpha(image.Rect(0, 0, 5, 5))
+	z.Draw(dst)
+	want := [5][5]uint8{
+		{0, 0, 0, 0, 0},
+		{0, 64, 128, 64, 0},
+		{0, 128, 255, 128, 0},
+		{0, 64, 128, 64, 0},
+		{0, 0, 0, 0, 0},
+	}
+	for y := range 5 {
+		for x := range 5 {
+			if got := dst.AlphaAt(x, y).A; got != want[y][x] {
+				t.Errorf("pixel (%d,%d) = %d, want %d", x, y, got, want[y][x])
+			}
+		}
+	}
+}
+
+// TestRasterizerNonzero draws two overlapping squares with the same
+// orientation (union under nonzero) and a counter-wound inner square
+// (a hole).
+func TestRasterizerNonzero(t *testing.T) {
+	square := func(z *Rasterizer, x0, y0, x1, y1 float32, cw bool) {
+		z.MoveTo(x0, y0)
+		if cw {
+			z.LineTo(x1, y0)
+			z.LineTo(x1, y1)
+			z.LineTo(x0, y1)
+		} else {
+			z.LineTo(x0, y1)
+			z.LineTo(x1, y1)
+			z.LineTo(x1, y0)
+		}
+		z.Close()
+	}
+	z := NewRasterizer(image.Rect(0, 0, 10, 10))
+	square(z, 0, 0, 6, 6, true)
+	square(z, 4, 4, 10, 10, true)
+	square(z, 1, 1, 3, 3, false)
+	dst := image.NewAlpha(z.Bounds())
+	z.Draw(dst)
+	for _, c := range []struct {
+		x, y int
+		want uint8
+	}{
+		{0, 0, 255}, {5, 5, 255}, {9, 9, 255}, {2, 2, 0}, {8, 1, 0},
+	} {
+		if got := dst.AlphaAt(c.x, c.y).A; got != c.want {
+			t.Errorf("pixel (%d,%d) = %d, want %d", c.x, c.y, got, c.want)
+		}
+	}
+}
+
+// TestRasterizerSubpixel shifts a glyph by a quarter pixel and expects
+// the coverage to change while the total ink stays the same.
+func TestRasterizerSubpixel(t *testing.T) {
+	face, err := NewFace(loadFont(t, "Roboto-Regular.ttf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid, _ := face.Cmap().Lookup('l')
+	a := face.RenderGlyph(gid, 24, 0, 0)
+	b := face.RenderGlyph(gid, 24, 0.25, 0)
+	if a == nil || b == nil {
+		t.Fatal("RenderGlyph returned nil")
+	}
+	ink := func(img *image.Alpha) (sum int) {
+		for _, v := range img.Pix {
+			sum += int(v)
+		}
+		return sum
+	}
+	if a.Rect == b.Rect && string(a.Pix) == string(b.Pix) {
+		t.Error("quarter-pixel shift produced an identical bitmap")
+	}
+	if ia, ib := ink(a), ink(b); ia-ib > ia/50 || ib-ia > ia/50 {
+		t.Errorf("total ink differs: %d vs %d", ia, ib)
+	}
+}
+
+// TestRenderBufferGolden shapes a pangram with a TrueType and a CFF font
+// and compares the rendering to golden PNGs. Run with -update to
+// regenerate them after an intentional change.
+func TestRenderBufferGolden(t *testing.T) {
+	for _, c := range []struct {
+		font, golden string
+	}{
+		{"Roboto-Regular.ttf", "roboto-hamburgefonstiv.png"},
+		{"SourceSansPro-Regular.otf", "sourcesans-hamburgefonstiv.png"},
+	} {
+		t.Run(c.font, func(t *testing.T) {
+			path := findTestFont(c.font)
+			if path == "" {
+				t.Skipf("%s not found", c.font)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			font, err := ParseFont(data, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shaper, err := NewShaper(font)
+			if err != nil {
+				t.Fatal(err)
+			}
+			face, err := NewFace(font)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := NewBuffer()
+			buf.AddString("Hamburgefonstiv AVAWa")
+			buf.GuessSegmentProperties()
+			shaper.Shape(buf, nil)
+
+			got := face.RenderBuffer(buf, 32)
+			if got == nil {
+				t.Fatal("RenderBuffer returned nil")
+			}
+			// Goldens are stored as black-on-white grayscale with the
+			// origin at the top left so they are viewable as is.
+			norm := image.NewGray(image.Rect(0, 0, got.Rect.Dx(), got.Rect.Dy()))
+			for i, v := range got.Pix {
+				norm.Pix[i] = 255 - v
+			}
+
+			goldenPath := filepath.Join("testdata", "raster", c.golden)
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(goldenPath), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				f, err := os.Create(goldenPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if err := png.Encode(f, norm); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			f, err := os.Open(goldenPath)
+			if err != nil {
+				t.Fatalf("open golden (run with -update to create): %v", err)
+			}
+			defer f.Close()
+			img, err := png.Decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds() != norm.Rect {
+				t.Fatalf("bounds = %v, golden %v", norm.Rect, img.Bounds())
+			}
+			// Allow ±2 per pixel: FMA contraction differs across
+			// architectures.
+			bad := 0
+			for y := 0; y < norm.Rect.Dy(); y++ {
+				for x := 0; x < norm.Rect.Dx(); x++ {
+					r, _, _, _ := img.At(x, y).RGBA()
+					want := int(r >> 8)
+					if d := int(norm.GrayAt(x, y).Y) - want; d > 2 || d < -2 {
+						bad++
+					}
+				}
+			}
+			if bad > 0 {
+				t.Errorf("%d pixels differ from %s", bad, goldenPath)
+			}
+		})
+	}
+}
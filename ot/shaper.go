@@ -1102,11 +1102,17 @@ type Shaper struct {
 	xStrength       int16
 	yStrength       int16
 	emboldenInPlace bool
+
+	// syntheticFace is face with the synthetic bold and slant above, nil
+	// without them. face itself may be shared and is never modified.
+	syntheticFace *Face
 }
 
 // SetSyntheticBold sets synthetic bold parameters.
 // x and y are embolden strengths in em-units (e.g. 0.02).
 // If inPlace is true, advances and origins are not modified (only extents/drawing).
+// The Face returned by Face draws the matching emboldened glyphs; the
+// Face the shaper was created from is not changed.
 // HarfBuzz equivalent: hb_font_set_synthetic_bold()
 func (s *Shaper) SetSyntheticBold(x, y float32, inPlace bool) {
 	s.xEmbolden = x
@@ -1114,6 +1120,7 @@ func (s *Shaper) SetSyntheticBold(x, y float32, inPlace bool) {
 	s.emboldenInPlace = inPlace
 	s.xStrength = int16(math.Round(float64(s.face.Upem()) * float64(x)))
 	s.yStrength = int16(math.Round(float64(s.face.Upem()) * float64(y)))
+	s.updateSyntheticFace()
 }
 
 // SyntheticBold returns the current synthetic bold parameters.
@@ -1123,9 +1130,11 @@ func (s *Shaper) SyntheticBold() (x, y float32, inPlace bool) {
 
 // SetSyntheticSlant sets the synthetic slant value.
 // HarfBuzz equivalent: hb_font_set_synthetic_slant()
-// Slant has no effect on shaping positions; it only affects extents and drawing.
+// Slant has no effect on shaping positions; it only affects extents and
+// drawing through the Face returned by Face.
 func (s *Shaper) SetSyntheticSlant(slant float32) {
 	s.slant = slant
+	s.updateSyntheticFace()
 }
 
 // SyntheticSlant returns the current synthetic slant value.
@@ -1133,6 +1142,24 @@ func (s *Shaper) SyntheticSlant() float32 {
 	return s.slant
 }
 
+// Face returns the Face the shaper reads metrics from. With synthetic
+// bold or slant set it is a copy carrying those settings, so glyphs drawn
+// through it match the shaped advances.
+func (s *Shaper) Face() *Face {
+	if s.syntheticFace != nil {
+		return s.syntheticFace
+	}
+	return s.face
+}
+
+// updateSyntheticFace derives syntheticFace from the synthetic settings.
+func (s *Shaper) updateSyntheticFace() {
+	s.syntheticFace = nil
+	if s.xEmbolden != 0 || s.yEmbolden != 0 || s.slant != 0 {
+		s.syntheticFace = s.face.withSynthetic(s.xEmbolden, s.yEmbolden, s.emboldenInPlace, s.slant)
+	}
+}
+
 // getGlyphHAdvanceWithBold returns the horizontal advance for a glyph including
 // synthetic bold. Used internally for v-origin calculations.
 func (s *Shaper) getGlyphHAdvanceWithBold(glyph GlyphID) int16 {
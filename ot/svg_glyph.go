@@ -0,0 +1,556 @@
+package ot
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Per-glyph extraction from shared SVG documents.
+//
+// HarfBuzz has no equivalent: hb_ot_color_glyph_reference_svg hands the
+// whole document to the renderer. An SVG document may however describe
+// many glyphs (TestSVGmultiGlyphs.otf puts glyphs 3-7 into one
+// document), so a consumer that converts a single glyph (e.g. into a
+// PDF Type 3 glyph procedure) has to locate the `id="glyphN"` element
+// and drag along everything it references through `href="#…"` or
+// `url(#…)`. GlyphElementSVG does that once, on the raw bytes, so
+// attribute order, prefixes and numeric formatting survive untouched.
+//
+// Coordinate system (SVG spec section "Glyph semantics and metrics"):
+// the glyph origin is the SVG origin, the y axis points down, so
+// everything above the baseline has negative y. A viewBox on the root
+// element only scales: its width and height are mapped onto
+// unitsPerEm (honoring preserveAspectRatio) and its (min-x, min-y)
+// corner is translated to the SVG origin, i.e. the glyph origin. For
+// viewBox="0 128 128 128" at 2048 units per em that is
+// matrix(16 0 0 16 0 -2048): the translation is -min-y × scale, not a
+// fixed -upem. The extracted document bakes that mapping into a
+// transform, so its user units are font units, and its viewBox is the
+// area the glyph may cover: the advance between ascender and
+// descender, grown to the outline extents. To draw it into a y-up glyph
+// space (PDF, font outlines), apply the matrix [1 0 0 -1 0 0].
+
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+)
+
+// svgNode records the byte range of one element in an SVG document.
+type svgNode struct {
+	name       string // qualified name as written, e.g. "path" or "svg:g"
+	start      int    // offset of '<'
+	tagEnd     int    // offset just past the start tag
+	closeStart int    // offset of the end tag ('</'), == end for empty elements
+	end        int    // offset just past the element
+	parent     int    // index into svgTree.nodes, -1 for the root
+	id         string
+	refs       []string // ids referenced by this element's attributes or text
+}
+
+// svgTree is the flat, document-ordered element list of an SVG document.
+type svgTree struct {
+	doc   []byte
+	nodes []svgNode
+	byID  map[string]int
+	// Root attributes needed to rebuild a standalone document.
+	nsDecls             []xml.Attr
+	viewBox             string
+	preserveAspectRatio string
+}
+
+// parseSVGTree tokenizes doc and records element boundaries. It returns
+// nil if the document is not well-formed XML.
+func parseSVGTree(doc []byte) *svgTree {
+	t := &svgTree{doc: doc, byID: make(map[string]int)}
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	dec.Strict = false
+	var stack []int
+	for {
+		off := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			parent := -1
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			n := svgNode{
+				name:   svgRawName(doc[off:]),
+				start:  off,
+				tagEnd: int(dec.InputOffset()),
+				parent: parent,
+			}
+			for _, a := range tok.Attr {
+				if a.Name.Local == "id" && a.Name.Space == "" {
+					n.id = a.Value
+				}
+				if a.Name.Local == "href" && strings.HasPrefix(a.Value, "#") {
+					n.refs = append(n.refs, a.Value[1:])
+				}
+				n.refs = appendURLRefs(n.refs, a.Value)
+				if parent == -1 {
+					switch {
+					case a.Name.Space == "xmlns", a.Name.Space == "" && a.Name.Local == "xmlns":
+						t.nsDecls = append(t.nsDecls, a)
+					case a.Name.Space == "" && a.Name.Local == "viewBox":
+						t.viewBox = a.Value
+					case a.Name.Space == "" && a.Name.Local == "preserveAspectRatio":
+						t.preserveAspectRatio = a.Value
+					}
+				}
+			}
+			if n.id != "" {
+				if _, dup := t.byID[n.id]; !dup {
+					t.byID[n.id] = len(t.nodes)
+				}
+			}
+			stack = append(stack, len(t.nodes))
+			t.nodes = append(t.nodes, n)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil
+			}
+			n := &t.nodes[stack[len(stack)-1]]
+			stack = stack[:len(stack)-1]
+			n.end = int(dec.InputOffset())
+			// Empty elements (<use/>) produce a synthetic EndElement
+			// without consuming input.
+			n.closeStart = min(off, n.end)
+		case xml.CharData:
+			// Only <style> carries references in text (CSS url(#…)).
+			if len(stack) > 0 {
+				n := &t.nodes[stack[len(stack)-1]]
+				if svgLocalName(n.name) == "style" {
+					n.refs = appendURLRefs(n.refs, string(tok))
+				}
+			}
+		}
+	}
+	if len(t.nodes) == 0 || len(stack) != 0 {
+		return nil
+	}
+	return t
+}
+
+// svgRawName returns the element name exactly as written after '<'.
+func svgRawName(b []byte) string {
+	if len(b) == 0 || b[0] != '<' {
+		return ""
+	}
+	end := 1
+	for end < len(b) {
+		switch b[end] {
+		case ' ', '\t', '\n', '\r', '/', '>':
+			return string(b[1:end])
+		}
+		end++
+	}
+	return string(b[1:end])
+}
+
+func svgLocalName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// appendURLRefs appends every id referenced as url(#id) in s.
+func appendURLRefs(refs []string, s string) []string {
+	for {
+		i := strings.Index(s, "url(")
+		if i < 0 {
+			return refs
+		}
+		s = s[i+4:]
+		j := strings.IndexByte(s, ')')
+		if j < 0 {
+			return refs
+		}
+		ref := strings.Trim(s[:j], " \t\n\r'\"")
+		if strings.HasPrefix(ref, "#") && len(ref) > 1 {
+			refs = append(refs, ref[1:])
+		}
+		s = s[j+1:]
+	}
+}
+
+// svgRange is a half-open byte range of emitted document content.
+type svgRange struct{ start, end int }
+
+func svgCovered(ranges []svgRange, n *svgNode) bool {
+	for _, r := range ranges {
+		if n.start >= r.start && n.end <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// collectRefs appends the references of every element inside [start, end).
+func (t *svgTree) collectRefs(refs []string, start, end int) []string {
+	for i := range t.nodes {
+		n := &t.nodes[i]
+		if n.start >= start && n.end <= end {
+			refs = append(refs, n.refs...)
+		}
+	}
+	return refs
+}
+
+// extract builds the standalone document for the element with the
+// given id, showing box. It returns nil if no such element exists.
+func (t *svgTree) extract(id string, upem uint16, box GlyphBBox) []byte {
+	gi, ok := t.byID[id]
+	if !ok {
+		return nil
+	}
+	g := &t.nodes[gi]
+
+	var body bytes.Buffer
+	var emitted []svgRange
+	var refs []string
+	if g.parent == -1 {
+		// The root element itself is the glyph: keep all of its content.
+		body.Write(t.doc[g.tagEnd:g.closeStart])
+		emitted = append(emitted, svgRange{g.start, g.end})
+		refs = t.collectRefs(refs, g.start, g.end)
+	} else {
+		// Re-open the ancestors (below the root) so inherited
+		// transforms and presentation attributes are preserved.
+		var ancestors []int
+		for p := g.parent; p > 0; p = t.nodes[p].parent {
+			ancestors = append(ancestors, p)
+		}
+		for i := len(ancestors) - 1; i >= 0; i-- {
+			a := &t.nodes[ancestors[i]]
+			body.Write(t.doc[a.start:a.tagEnd])
+			refs = append(refs, a.refs...)
+		}
+		body.Write(t.doc[g.start:g.end])
+		for _, ai := range ancestors {
+			body.WriteString("</" + t.nodes[ai].name + ">")
+		}
+		emitted = append(emitted, svgRange{g.start, g.end})
+		refs = t.collectRefs(refs, g.start, g.end)
+	}
+
+	// Transitively resolve references into <defs>. Stylesheets are
+	// always kept: class selectors cannot be traced statically.
+	var defs []int
+	addDef := func(i int) {
+		n := &t.nodes[i]
+		if svgCovered(emitted, n) {
+			return
+		}
+		// Drop earlier defs nested inside the new one.
+		kept := defs[:0]
+		for _, d := range defs {
+			if t.nodes[d].start < n.start || t.nodes[d].end > n.end {
+				kept = append(kept, d)
+			}
+		}
+		defs = append(kept, i)
+		emitted = append(emitted, svgRange{n.start, n.end})
+		refs = t.collectRefs(refs, n.start, n.end)
+	}
+	for i := range t.nodes {
+		if svgLocalName(t.nodes[i].name) == "style" {
+			addDef(i)
+		}
+	}
+	for len(refs) > 0 {
+		ref := refs[len(refs)-1]
+		refs = refs[:len(refs)-1]
+		if i, ok := t.byID[ref]; ok && i != 0 {
+			addDef(i)
+		}
+	}
+	sort.Ints(defs)
+
+	var out bytes.Buffer
+	out.WriteString("<svg")
+	hasDefault, hasXlink := false, false
+	for _, a := range t.nsDecls {
+		if a.Name.Space == "" {
+			hasDefault = true
+			out.WriteString(` xmlns="`)
+		} else {
+			hasXlink = hasXlink || a.Name.Local == "xlink"
+			out.WriteString(" xmlns:" + a.Name.Local + `="`)
+		}
+		xml.EscapeText(&out, []byte(a.Value))
+		out.WriteByte('"')
+	}
+	if !hasDefault {
+		out.WriteString(` xmlns="` + svgNamespace + `"`)
+	}
+	if !hasXlink {
+		out.WriteString(` xmlns:xlink="` + xlinkNamespace + `"`)
+	}
+	if box.XMax <= box.XMin || box.YMax <= box.YMin {
+		box = GlyphBBox{XMax: int16(upem), YMax: int16(upem)}
+	}
+	out.WriteString(` viewBox="` + strconv.Itoa(int(box.XMin)) + " " + strconv.Itoa(-int(box.YMax)) + " " +
+		strconv.Itoa(int(box.XMax)-int(box.XMin)) + " " + strconv.Itoa(int(box.YMax)-int(box.YMin)) + `">`)
+
+	m, ok := svgViewBoxTransform(t.viewBox, t.preserveAspectRatio, float64(upem))
+	if ok {
+		out.WriteString(`<g transform="matrix(`)
+		for i, v := range m {
+			if i > 0 {
+				out.WriteByte(' ')
+			}
+			out.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		out.WriteString(`)">`)
+	}
+	if len(defs) > 0 {
+		out.WriteString("<defs>")
+		for _, d := range defs {
+			n := &t.nodes[d]
+			out.Write(t.doc[n.start:n.end])
+		}
+		out.WriteString("</defs>")
+	}
+	out.Write(body.Bytes())
+	if ok {
+		out.WriteString("</g>")
+	}
+	out.WriteString("</svg>")
+	return out.Bytes()
+}
+
+// svgViewBoxTransform returns the matrix (a b c d e f) scaling the root
+// viewBox onto the em square and moving its (min-x, min-y) corner to the
+// origin, or ok == false if no mapping is needed.
+// The default preserveAspectRatio is "xMidYMid meet".
+func svgViewBoxTransform(viewBox, par string, upem float64) (m [6]float64, ok bool) {
+	fields := strings.FieldsFunc(viewBox, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) != 4 {
+		return m, false
+	}
+	var vb [4]float64
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return m, false
+		}
+		vb[i] = v
+	}
+	if vb[2] <= 0 || vb[3] <= 0 {
+		return m, false
+	}
+	sx, sy := upem/vb[2], upem/vb[3]
+	var tx, ty float64
+	align, slice := "xMidYMid", false
+	if f := strings.Fields(par); len(f) > 0 {
+		align = f[0]
+		slice = len(f) > 1 && f[1] == "slice"
+	}
+	if align != "none" {
+		s := min(sx, sy)
+		if slice {
+			s = max(sx, sy)
+		}
+		sx, sy = s, s
+		freeX, freeY := upem-vb[2]*s, upem-vb[3]*s
+		switch {
+		case strings.HasPrefix(align, "xMid"):
+			tx = freeX / 2
+		case strings.HasPrefix(align, "xMax"):
+			tx = freeX
+		}
+		switch {
+		case strings.HasSuffix(align, "YMid"):
+			ty = freeY / 2
+		case strings.HasSuffix(align, "YMax"):
+			ty = freeY
+		}
+	}
+	m = [6]float64{sx, 0, 0, sy, tx - vb[0]*sx, ty - vb[1]*sy}
+	if m == [6]float64{1, 0, 0, 1, 0, 0} {
+		return m, false
+	}
+	return m, true
+}
+
+// GlyphElementSVG returns a standalone SVG document that draws only the
+// given glyph: the `id="glyphN"` element (with its ancestors below the
+// root), every element it references directly or transitively through
+// `href="#…"` or `url(#…)` moved into a <defs> block, and any <style>
+// elements. Returns nil if no document covers gid or the document has
+// no element for it.
+//
+// The user units of the result are font units with the y axis pointing
+// down; a root viewBox in the source document is baked into a transform
+// on a wrapping <g>. The viewBox of the result shows box, given in
+// font units with the y axis pointing up, or the em square above the
+// baseline if box is empty. Callers drawing into a y-up glyph space
+// apply the matrix [1 0 0 -1 0 0].
+func (s *SVG) GlyphElementSVG(gid GlyphID, upem uint16, box GlyphBBox) []byte {
+	doc := s.GlyphSVG(gid)
+	if doc == nil {
+		return nil
+	}
+	t := parseSVGTree(doc)
+	if t == nil {
+		return nil
+	}
+	return t.extract("glyph"+strconv.Itoa(int(gid)), upem, box)
+}
+
+// GlyphColorSVGElement returns a standalone SVG document containing
+// only the given glyph's element and the definitions it references,
+// with a font-unit viewBox that spans the glyph's advance between
+// ascender and descender and its outline extents. See
+// SVG.GlyphElementSVG for the coordinate conventions. Returns nil if the
+// font has no SVG glyph for gid.
+func (f *Font) GlyphColorSVGElement(gid GlyphID) []byte {
+	data, err := f.TableData(TagSVG)
+	if err != nil {
+		return nil
+	}
+	svg, err := ParseSVG(data)
+	if err != nil {
+		return nil
+	}
+	upem := uint16(1000)
+	var box GlyphBBox
+	if face, err := NewFace(f); err == nil {
+		upem = face.Upem()
+		box = GlyphBBox{
+			YMin: face.Descender(),
+			XMax: int16(face.HorizontalAdvance(gid)),
+			YMax: face.Ascender(),
+		}
+		if ext, ok := face.GlyphExtents(gid); ok {
+			box.XMin, box.YMin = min(box.XMin, ext.XMin), min(box.YMin, ext.YMin)
+			box.XMax, box.YMax = max(box.XMax, ext.XMax), max(box.YMax, ext.YMax)
+		}
+	}
+	return svg.GlyphElementSVG(gid, upem, box)
+}
+
+// RemapSVGGlyphs rewrites an SVG document for a subset font. Elements
+// with `id="glyphN"` are renamed to the new glyph ID from glyphMap, as
+// are `#glyphN` references to them. Glyph elements of glyphs missing
+// from glyphMap are removed unless a retained element references them;
+// those stay with the id "glyphN.ref" so they cannot collide with a
+// renumbered glyph. Everything else is copied byte for byte. Returns
+// nil if the document is not well-formed XML.
+//
+// HarfBuzz has no equivalent (hb-subset copies the SVG table
+// unchanged); this follows fontTools' subset.svg.
+func RemapSVGGlyphs(doc []byte, glyphMap map[GlyphID]GlyphID) []byte {
+	t := parseSVGTree(doc)
+	if t == nil {
+		return nil
+	}
+	glyphOf := func(id string) (GlyphID, bool) {
+		s, ok := strings.CutPrefix(id, "glyph")
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.ParseUint(s, 10, 16)
+		if err != nil || strconv.FormatUint(n, 10) != s {
+			return 0, false
+		}
+		return GlyphID(n), true
+	}
+
+	// Candidates for removal are the elements of dropped glyphs that
+	// contain no retained glyph.
+	dropped := make(map[int]bool)
+	for i := range t.nodes {
+		if gid, ok := glyphOf(t.nodes[i].id); ok {
+			if _, keep := glyphMap[gid]; !keep {
+				dropped[i] = true
+			}
+		}
+	}
+	for i := range t.nodes {
+		if gid, ok := glyphOf(t.nodes[i].id); ok {
+			if _, keep := glyphMap[gid]; keep {
+				for p := t.nodes[i].parent; p >= 0; p = t.nodes[p].parent {
+					delete(dropped, p)
+				}
+			}
+		}
+	}
+	removed := func(i int) bool {
+		for ; i >= 0; i = t.nodes[i].parent {
+			if dropped[i] {
+				return true
+			}
+		}
+		return false
+	}
+	// Restore dropped glyphs referenced by retained content until
+	// nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for i := range t.nodes {
+			if removed(i) {
+				continue
+			}
+			for _, ref := range t.nodes[i].refs {
+				if j, ok := t.byID[ref]; ok {
+					for ; j >= 0; j = t.nodes[j].parent {
+						if dropped[j] {
+							delete(dropped, j)
+							changed = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	rename := func(id string) string {
+		gid, ok := glyphOf(id)
+		if !ok {
+			return id
+		}
+		if newGID, keep := glyphMap[gid]; keep {
+			return "glyph" + strconv.Itoa(int(newGID))
+		}
+		return id + ".ref"
+	}
+	out := make([]byte, 0, len(doc))
+	pos := 0
+	for i := 0; i < len(t.nodes); i++ {
+		n := &t.nodes[i]
+		if n.start < pos {
+			continue // inside a removed element
+		}
+		out = append(out, doc[pos:n.start]...)
+		if dropped[i] {
+			pos = n.end
+			continue
+		}
+		out = append(out, svgGlyphIDPattern.ReplaceAllFunc(doc[n.start:n.tagEnd], func(m []byte) []byte {
+			sub := svgGlyphIDPattern.FindSubmatch(m)
+			return append(append(append([]byte{}, sub[1]...), rename(string(sub[2]))...), sub[3]...)
+		})...)
+		pos = n.tagEnd
+	}
+	return append(out, doc[pos:]...)
+}
+
+// svgGlyphIDPattern matches glyph ids in id attributes and in references
+// (href="#glyphN", url(#glyphN)) of a start tag.
+var svgGlyphIDPattern = regexp.MustCompile(`(\sid\s*=\s*["']|#)(glyph\d+)(["')])`)
@@ -2,11 +2,11 @@ package ot
 
 import (
 	"bytes"
-	"os"
+	"encoding/binary"
+	"encoding/xml"
+	"strconv"
 	"strings"
 	"testing"
-
-	"github.com/boxesandglue/textshape/internal/testutil"
 )
 
 // HarfBuzz reference: the SVG accessors validated here mirror
@@ -14,28 +14,11 @@ import (
 // hb_ot_color_glyph_reference_svg (hb-ot-color.cc:306). Test fonts come
 // from HarfBuzz's own corpus under SIL OFL 1.1.
 
-func loadFontForSVG(t *testing.T, name string) *Font {
-	t.Helper()
-	path := testutil.FindTestFont(name)
-	if path == "" {
-		t.Skipf("%s not found", name)
-	}
-	data, err := os.ReadFile(path)
-	if err != nil {
-		t.Fatalf("read %s: %v", name, err)
-	}
-	font, err := ParseFont(data, 0)
-	if err != nil {
-		t.Fatalf("parse %s: %v", name, err)
-	}
-	return font
-}
-
 // chromacheck-svg.ttf is the minimal SVG-OT smoke test: one document
 // covering exactly glyph 1. Useful for proving the parser does not
 // stumble on the tightest possible table.
 func TestSVGChromaCheck(t *testing.T) {
-	f := loadFontForSVG(t, "chromacheck-svg.ttf")
+	f := loadFont(t, "chromacheck-svg.ttf")
 	if !f.HasColorSVG() {
 		t.Fatal("HasColorSVG() = false, want true")
 	}
@@ -61,7 +44,7 @@ func TestSVGChromaCheck(t *testing.T) {
 // must resolve to the same document bytes — HB's bsearch+range_lookup
 // guarantee (SVG.hh:46) that we mirror here.
 func TestSVGMultiGlyphs(t *testing.T) {
-	f := loadFontForSVG(t, "TestSVGmultiGlyphs.otf")
+	f := loadFont(t, "TestSVGmultiGlyphs.otf")
 	if !f.HasColorSVG() {
 		t.Fatal("HasColorSVG() = false")
 	}
@@ -104,7 +87,7 @@ func TestSVGMultiGlyphs(t *testing.T) {
 // transparently inflates so callers receive XML bytes regardless of
 // on-disk encoding.
 func TestSVGGzip(t *testing.T) {
-	f := loadFontForSVG(t, "TestSVGgzip.otf")
+	f := loadFont(t, "TestSVGgzip.otf")
 	if !f.HasColorSVG() {
 		t.Fatal("HasColorSVG() = false")
 	}
@@ -124,7 +107,7 @@ func TestSVGGzip(t *testing.T) {
 
 func TestSVGNonColorFontReturnsNil(t *testing.T) {
 	// Roboto is a plain TTF: no SVG table at all.
-	f := loadFontForSVG(t, "Roboto-Regular.ttf")
+	f := loadFont(t, "Roboto-Regular.ttf")
 	if f.HasColorSVG() {
 		t.Error("HasColorSVG() = true on Roboto, want false")
 	}
@@ -132,3 +115,163 @@ func TestSVGNonColorFontReturnsNil(t *testing.T) {
 		t.Errorf("GlyphColorSVG(1) on Roboto = %q, want nil", got)
 	}
 }
+
+// TestSVGGlyphElementMultiGlyphs extracts single glyphs from the shared
+// document for glyphs 3-7: only the glyph's own element and the
+// gradient it references may survive, and the 128-unit viewBox of the
+// source must be mapped onto the 2048-unit em square.
+func TestSVGGlyphElementMultiGlyphs(t *testing.T) {
+	f := loadFont(t, "TestSVGmultiGlyphs.otf")
+	doc := f.GlyphColorSVGElement(4)
+	if doc == nil {
+		t.Fatal("GlyphColorSVGElement(4) = nil")
+	}
+	if err := xml.Unmarshal(doc, new(struct{})); err != nil {
+		t.Fatalf("extracted document is not well-formed: %v\n%s", err, doc)
+	}
+	s := string(doc)
+	for _, want := range []string{`id="glyph4"`, `id="c"`, `viewBox="0 -2048 2048 2048"`, `transform="matrix(16 0 0 16 0 -2048)"`} {
+		if !strings.Contains(s, want) {
+			t.Errorf("extracted document lacks %s:\n%s", want, s)
+		}
+	}
+	for _, unwanted := range []string{`id="glyph3"`, `id="glyph5"`, `id="b"`, `id="d"`} {
+		if strings.Contains(s, unwanted) {
+			t.Errorf("extracted document contains %s", unwanted)
+		}
+	}
+	if got := f.GlyphColorSVGElement(2); got != nil {
+		t.Errorf("GlyphColorSVGElement(2) = %q, want nil", got)
+	}
+}
+
+// TestSVGGlyphElementViewBox checks that the viewBox spans the descender
+// and the outline extents, not only the em square above the baseline.
+func TestSVGGlyphElementViewBox(t *testing.T) {
+	f := loadFont(t, "TestSVGmultiGlyphs.otf")
+	// TableData returns a slice of the font data, so this patches the
+	// parsed font in place: ascender 1900, descender -600.
+	hhea, err := f.TableData(TagHhea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint16(hhea[4:], 1900)
+	binary.BigEndian.PutUint16(hhea[6:], uint16(0x10000-600))
+
+	// Glyph 4 has an advance of 2048 and extents (64,64)-(1984,1984)
+	doc := string(f.GlyphColorSVGElement(4))
+	if want := `viewBox="0 -1984 2048 2584"`; !strings.Contains(doc, want) {
+		t.Errorf("extracted document lacks %s:\n%s", want, doc)
+	}
+	if !strings.Contains(doc, `transform="matrix(16 0 0 16 0 -2048)"`) {
+		t.Errorf("content transform changed with the viewBox:\n%s", doc)
+	}
+
+	data, _ := f.TableData(TagSVG)
+	svg, err := ParseSVG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		box  GlyphBBox
+		want string
+	}{
+		{GlyphBBox{XMin: -100, YMin: -500, XMax: 2200, YMax: 1900}, `viewBox="-100 -1900 2300 2400"`},
+		{GlyphBBox{}, `viewBox="0 -2048 2048 2048"`}, // the em square
+	} {
+		if doc := string(svg.GlyphElementSVG(4, 2048, tc.box)); !strings.Contains(doc, tc.want) {
+			t.Errorf("box %+v: extracted document lacks %s", tc.box, tc.want)
+		}
+	}
+}
+
+// TestSVGGlyphElementTransitiveRefs checks that references are followed
+// through <use xlink:href> and url(#…) chains.
+func TestSVGGlyphElementTransitiveRefs(t *testing.T) {
+	f := loadFont(t, "TestSVGmultiGlyphs.otf")
+	for gid := GlyphID(8); gid <= 13; gid++ {
+		doc := f.GlyphColorSVGElement(gid)
+		if doc == nil {
+			t.Fatalf("GlyphColorSVGElement(%d) = nil", gid)
+		}
+		type elem struct {
+			ID   string `xml:"id,attr"`
+			Href string `xml:"http://www.w3.org/1999/xlink href,attr"`
+			Kids []elem `xml:",any"`
+		}
+		var root elem
+		if err := xml.Unmarshal(doc, &root); err != nil {
+			t.Fatalf("gid %d: not well-formed: %v", gid, err)
+		}
+		ids := map[string]bool{}
+		var hrefs []string
+		var walk func(e elem)
+		walk = func(e elem) {
+			if e.ID != "" {
+				ids[e.ID] = true
+			}
+			if strings.HasPrefix(e.Href, "#") {
+				hrefs = append(hrefs, e.Href[1:])
+			}
+			for _, k := range e.Kids {
+				walk(k)
+			}
+		}
+		walk(root)
+		if !ids["glyph"+strconv.Itoa(int(gid))] {
+			t.Errorf("gid %d: glyph element missing", gid)
+		}
+		for _, h := range hrefs {
+			if !ids[h] {
+				t.Errorf("gid %d: dangling reference #%s", gid, h)
+			}
+		}
+	}
+}
+
+// TestSVGGlyphElementRootIsGlyph covers documents whose root <svg>
+// carries the glyph id itself (chromacheck, TestSVGgzip): the whole
+// content is the glyph.
+func TestSVGGlyphElementRootIsGlyph(t *testing.T) {
+	f := loadFont(t, "chromacheck-svg.ttf")
+	doc := string(f.GlyphColorSVGElement(1))
+	if !strings.Contains(doc, `<path fill="#320000"`) {
+		t.Errorf("glyph content missing: %s", doc)
+	}
+	if strings.Contains(doc, "transform=") {
+		t.Errorf("document without viewBox must not be transformed: %s", doc)
+	}
+
+	f = loadFont(t, "TestSVGgzip.otf")
+	doc = string(f.GlyphColorSVGElement(3))
+	if err := xml.Unmarshal([]byte(doc), new(struct{})); err != nil {
+		t.Fatalf("not well-formed: %v", err)
+	}
+	if !strings.Contains(doc, `clip-path="url(#a)"`) || !strings.Contains(doc, `<clipPath id="a">`) {
+		t.Errorf("root glyph content incomplete: %.200s", doc)
+	}
+}
+
+func TestRemapSVGGlyphs(t *testing.T) {
+	doc := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">` +
+		`<defs><path id="p" d="M0 0h10v10z"/></defs>` +
+		`<g id="glyph1"><use xlink:href="#glyph2"/></g>` +
+		`<g id="glyph2"><use xlink:href="#p"/></g>` +
+		`<g id="glyph3" fill="red"><use xlink:href="#p"/></g>` +
+		`<g id="glyph4"><rect width="5" height="5"/></g></svg>`
+
+	// glyph2 is dropped but drawn by glyph1, glyph4 is dropped
+	got := string(RemapSVGGlyphs([]byte(doc), map[GlyphID]GlyphID{1: 3, 3: 1}))
+	want := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">` +
+		`<defs><path id="p" d="M0 0h10v10z"/></defs>` +
+		`<g id="glyph3"><use xlink:href="#glyph2.ref"/></g>` +
+		`<g id="glyph2.ref"><use xlink:href="#p"/></g>` +
+		`<g id="glyph1" fill="red"><use xlink:href="#p"/></g></svg>`
+	if got != want {
+		t.Errorf("RemapSVGGlyphs:\n got %s\nwant %s", got, want)
+	}
+
+	if RemapSVGGlyphs([]byte(`<svg><g id="glyph1">`), map[GlyphID]GlyphID{1: 1}) != nil {
+		t.Error("malformed document not rejected")
+	}
+}
@@ -0,0 +1,309 @@
+package ot
+
+import (
+	"math"
+)
+
+// Synthetic bold and slant for glyph drawing and extents.
+//
+// HarfBuzz equivalent: hb_font_t::draw_glyph and
+// hb_font_t::synthetic_glyph_extents (hb-font.hh), hb_outline_t
+// (hb-outline.cc). The shaper widens advances and shifts origins for
+// synthetic bold (see Shaper.SetSyntheticBold); the Face applies the
+// matching geometry to outlines so both stay in sync. A Shaper keeps its
+// settings to itself and hands out a derived Face (see Shaper.Face), so
+// Faces shared between shapers are never modified.
+
+// SetSyntheticBold sets synthetic bold parameters for DrawGlyph,
+// GlyphOutline and GlyphExtents. x and y are embolden strengths in
+// em-units (e.g. 0.02). If inPlace is true the outline grows evenly to
+// both sides; otherwise it grows to the right, matching the widened
+// advances produced by a Shaper with the same settings.
+// HarfBuzz equivalent: hb_font_set_synthetic_bold()
+func (f *Face) SetSyntheticBold(x, y float32, inPlace bool) {
+	f.xEmbolden = x
+	f.yEmbolden = y
+	f.emboldenInPlace = inPlace
+	f.xStrength = int16(math.Round(float64(f.upem) * float64(x)))
+	f.yStrength = int16(math.Round(float64(f.upem) * float64(y)))
+}
+
+// SyntheticBold returns the current synthetic bold parameters.
+func (f *Face) SyntheticBold() (x, y float32, inPlace bool) {
+	return f.xEmbolden, f.yEmbolden, f.emboldenInPlace
+}
+
+// SetSyntheticSlant sets the synthetic slant applied to outlines and
+// extents: every point is sheared by x += slant·y.
+// HarfBuzz equivalent: hb_font_set_synthetic_slant()
+func (f *Face) SetSyntheticSlant(slant float32) {
+	f.slant = slant
+}
+
+// SyntheticSlant returns the current synthetic slant value.
+func (f *Face) SyntheticSlant() float32 {
+	return f.slant
+}
+
+// withSynthetic returns a copy of f with the given synthetic bold and
+// slant. The copy shares the parsed tables of f.
+func (f *Face) withSynthetic(xEmbolden, yEmbolden float32, inPlace bool, slant float32) *Face {
+	d := *f
+	d.SetSyntheticBold(xEmbolden, yEmbolden, inPlace)
+	d.SetSyntheticSlant(slant)
+	return &d
+}
+
+// slantMatrix returns the shear applied for synthetic slant.
+// HarfBuzz: hb_draw_session_t with slant_xy (hb-draw.hh).
+func (f *Face) slantMatrix() AffineMatrix {
+	return AffineMatrix{XX: 1, XY: f.slant, YY: 1}
+}
+
+// syntheticGlyphExtents adjusts an outline bounding box for synthetic
+// slant and bold.
+// HarfBuzz equivalent: hb_font_t::synthetic_glyph_extents (hb-font.hh)
+func (f *Face) syntheticGlyphExtents(b *GlyphBBox) {
+	if f.slant != 0 {
+		y1 := float64(b.YMax) * float64(f.slant)
+		y2 := float64(b.YMin) * float64(f.slant)
+		b.XMin += int16(math.Floor(min(y1, y2)))
+		b.XMax += int16(math.Ceil(max(y1, y2)))
+	}
+	if f.xStrength != 0 || f.yStrength != 0 {
+		b.YMax += f.yStrength
+		if f.emboldenInPlace {
+			b.XMin -= f.xStrength / 2
+			b.XMax += f.xStrength - f.xStrength/2
+		} else {
+			b.XMax += f.xStrength
+		}
+	}
+}
+
+// syntheticOutline records a path as a flat point list with contour
+// end indices, so it can be emboldened and replayed.
+// HarfBuzz equivalent: hb_outline_t / hb_outline_recording_pen_funcs
+// (hb-outline.hh)
+type syntheticOutline struct {
+	points   []syntheticPoint
+	contours []int // exclusive end index of each contour in points
+
+	open                   bool
+	startX, startY, cx, cy float32
+}
+
+type syntheticPoint struct {
+	x, y float32
+	op   SegmentOp
+}
+
+func (o *syntheticOutline) MoveTo(x, y float32) {
+	o.Close()
+	o.points = append(o.points, syntheticPoint{x, y, SegmentMoveTo})
+	o.open = true
+	o.startX, o.startY, o.cx, o.cy = x, y, x, y
+}
+
+func (o *syntheticOutline) LineTo(x, y float32) {
+	o.points = append(o.points, syntheticPoint{x, y, SegmentLineTo})
+	o.cx, o.cy = x, y
+}
+
+func (o *syntheticOutline) QuadTo(cx, cy, x, y float32) {
+	o.points = append(o.points,
+		syntheticPoint{cx, cy, SegmentQuadTo},
+		syntheticPoint{x, y, SegmentQuadTo})
+	o.cx, o.cy = x, y
+}
+
+func (o *syntheticOutline) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	o.points = append(o.points,
+		syntheticPoint{c1x, c1y, SegmentCubeTo},
+		syntheticPoint{c2x, c2y, SegmentCubeTo},
+		syntheticPoint{x, y, SegmentCubeTo})
+	o.cx, o.cy = x, y
+}
+
+// Close ends the contour, recording the implied line back to its start
+// as HarfBuzz's draw state does before close_path.
+func (o *syntheticOutline) Close() {
+	if !o.open {
+		return
+	}
+	if o.cx != o.startX || o.cy != o.startY {
+		o.LineTo(o.startX, o.startY)
+	}
+	o.contours = append(o.contours, len(o.points))
+	o.open = false
+}
+
+// replay draws the recorded outline into pen.
+// HarfBuzz equivalent: hb_outline_t::replay (hb-outline.cc)
+func (o *syntheticOutline) replay(pen Pen) {
+	first := 0
+	for _, end := range o.contours {
+		pts := o.points[first:end]
+		for i := 0; i < len(pts); i++ {
+			p := pts[i]
+			switch p.op {
+			case SegmentMoveTo:
+				pen.MoveTo(p.x, p.y)
+			case SegmentLineTo:
+				pen.LineTo(p.x, p.y)
+			case SegmentQuadTo:
+				if i+1 < len(pts) {
+					q := pts[i+1]
+					pen.QuadTo(p.x, p.y, q.x, q.y)
+					i++
+				}
+			case SegmentCubeTo:
+				if i+2 < len(pts) {
+					q, r := pts[i+1], pts[i+2]
+					pen.CubicTo(p.x, p.y, q.x, q.y, r.x, r.y)
+					i += 2
+				}
+			}
+		}
+		pen.Close()
+		first = end
+	}
+}
+
+// controlArea returns the signed area of the control polygon; negative
+// for clockwise (TrueType) orientation.
+// HarfBuzz equivalent: hb_outline_t::control_area (hb-outline.cc)
+func (o *syntheticOutline) controlArea() float32 {
+	var a float32
+	first := 0
+	for _, end := range o.contours {
+		for i := first; i < end; i++ {
+			j := i + 1
+			if j >= end {
+				j = first
+			}
+			pi, pj := o.points[i], o.points[j]
+			a += pi.x*pj.y - pi.y*pj.x
+		}
+		first = end
+	}
+	return a * .5
+}
+
+// embolden moves every point outwards along the bisector of its
+// adjacent edges, by xStrength/2 horizontally and yStrength/2
+// vertically, then translates the outline by (xShift, yShift).
+// Contour direction is taken from the sign of the control area, so
+// both TrueType (clockwise) and CFF (counter-clockwise) outlines grow.
+// HarfBuzz equivalent: hb_outline_t::embolden (hb-outline.cc), itself a
+// port of FreeType's FT_Outline_EmboldenXY.
+func (o *syntheticOutline) embolden(xStrength, yStrength, xShift, yShift float32) {
+	if xStrength == 0 && yStrength == 0 {
+		return
+	}
+	if len(o.points) == 0 {
+		return
+	}
+
+	xStrength /= 2
+	yStrength /= 2
+
+	negative := o.controlArea() < 0
+
+	normalize := func(x, y float32) (float32, float32, float32) {
+		l := float32(math.Hypot(float64(x), float64(y)))
+		if l != 0 {
+			x /= l
+			y /= l
+		}
+		return x, y, l
+	}
+
+	pts := o.points
+	first := 0
+	for _, end := range o.contours {
+		last := end - 1
+		var inX, inY, outX, outY, anchorX, anchorY float32
+		var lIn, lOut, lAnchor float32
+
+		// j cycles through the points; i advances only when points are
+		// moved; anchor k marks the first moved point.
+		for i, j, k := last, first, -1; j != i && i != k; {
+			if j != k {
+				outX, outY, lOut = normalize(pts[j].x-pts[i].x, pts[j].y-pts[i].y)
+				if lOut == 0 {
+					j = nextPoint(j, first, last)
+					continue
+				}
+			} else {
+				outX, outY, lOut = anchorX, anchorY, lAnchor
+			}
+
+			if lIn != 0 {
+				if k < 0 {
+					k = i
+					anchorX, anchorY, lAnchor = inX, inY, lIn
+				}
+
+				d := inX*outX + inY*outY
+				var shiftX, shiftY float32
+
+				// Shift only if the turn is less than ~160 degrees.
+				if d > -15.0/16.0 {
+					d += 1
+
+					// Shift along the lateral bisector in the proper
+					// orientation.
+					shiftX = inY + outY
+					shiftY = inX + outX
+					if negative {
+						shiftX = -shiftX
+					} else {
+						shiftY = -shiftY
+					}
+
+					// Restrict the shift magnitude to better handle
+					// collapsing segments.
+					q := outX*inY - outY*inX
+					if negative {
+						q = -q
+					}
+					l := min(lIn, lOut)
+
+					// Non-strict inequalities avoid dividing by zero
+					// when q == l == 0.
+					if xStrength*q <= l*d {
+						shiftX = shiftX * xStrength / d
+					} else {
+						shiftX = shiftX * l / q
+					}
+					if yStrength*q <= l*d {
+						shiftY = shiftY * yStrength / d
+					} else {
+						shiftY = shiftY * l / q
+					}
+				}
+
+				for ; i != j; i = nextPoint(i, first, last) {
+					pts[i].x += xShift + shiftX
+					pts[i].y += yShift + shiftY
+				}
+			} else {
+				i = j
+			}
+
+			inX, inY, lIn = outX, outY, lOut
+			j = nextPoint(j, first, last)
+		}
+
+		first = end
+	}
+}
+
+// nextPoint returns the index after i in the cyclic range [first, last].
+func nextPoint(i, first, last int) int {
+	if i < last {
+		return i + 1
+	}
+	return first
+}
@@ -0,0 +1,161 @@
+package ot
+
+import (
+	"math"
+	"testing"
+)
+
+// TestEmboldenSquare grows a square in both contour orientations; each
+// edge must move outwards by half the strength.
+func TestEmboldenSquare(t *testing.T) {
+	for _, cw := range []bool{false, true} {
+		var o syntheticOutline
+		o.MoveTo(0, 0)
+		if cw {
+			o.LineTo(0, 100)
+			o.LineTo(100, 100)
+			o.LineTo(100, 0)
+		} else {
+			o.LineTo(100, 0)
+			o.LineTo(100, 100)
+			o.LineTo(0, 100)
+		}
+		o.Close()
+		o.embolden(20, 40, 0, 0)
+
+		var b BoundsPen
+		o.replay(&b)
+		xMin, yMin, xMax, yMax, _ := b.Bounds()
+		if xMin != -10 || xMax != 110 || yMin != -20 || yMax != 120 {
+			t.Errorf("cw=%v: bounds (%v,%v)-(%v,%v), want (-10,-20)-(110,120)", cw, xMin, yMin, xMax, yMax)
+		}
+	}
+}
+
+// TestSyntheticBoldOutline checks that the emboldened outline covers the
+// synthetic extents and that its right edge follows the widened advance.
+func TestSyntheticBoldOutline(t *testing.T) {
+	for _, name := range []string{"Roboto-Regular.ttf", "SourceSansPro-Regular.otf"} {
+		t.Run(name, func(t *testing.T) {
+			shaper, err := NewShaper(loadFont(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			face := shaper.Face()
+			gid, _ := face.Cmap().Lookup('I')
+			plain, ok := face.GlyphExtents(gid)
+			if !ok {
+				t.Fatal("no extents for 'I'")
+			}
+
+			shaper.SetSyntheticBold(0.04, 0.02, false)
+			if x, y, _ := face.SyntheticBold(); x != 0 || y != 0 {
+				t.Fatalf("shared face.SyntheticBold() = %v, %v", x, y)
+			}
+			face = shaper.Face()
+			if x, y, inPlace := face.SyntheticBold(); x != 0.04 || y != 0.02 || inPlace {
+				t.Fatalf("face.SyntheticBold() = %v, %v, %v", x, y, inPlace)
+			}
+			xs, ys := face.xStrength, face.yStrength
+			bold, _ := face.GlyphExtents(gid)
+			want := plain
+			want.XMax += xs
+			want.YMax += ys
+			if bold != want {
+				t.Errorf("bold extents = %+v, want %+v", bold, want)
+			}
+
+			var b BoundsPen
+			if !face.DrawGlyph(gid, &b) {
+				t.Fatal("DrawGlyph = false")
+			}
+			xMin, yMin, xMax, yMax, _ := b.Bounds()
+			for _, c := range []struct {
+				got  float32
+				want int16
+			}{{xMin, want.XMin}, {yMin, want.YMin}, {xMax, want.XMax}, {yMax, want.YMax}} {
+				if math.Abs(float64(c.got)-float64(c.want)) > 1 {
+					t.Errorf("drawn bounds (%v,%v)-(%v,%v), want %+v", xMin, yMin, xMax, yMax, want)
+					break
+				}
+			}
+
+			face.SetSyntheticBold(0.04, 0.02, true)
+			inPlace, _ := face.GlyphExtents(gid)
+			if inPlace.XMin != plain.XMin-xs/2 || inPlace.XMax != plain.XMax+xs-xs/2 {
+				t.Errorf("in-place extents = %+v, plain %+v, strength %d", inPlace, plain, xs)
+			}
+		})
+	}
+}
+
+func TestSyntheticSlantOutline(t *testing.T) {
+	shaper, err := NewShaper(loadFont(t, "Roboto-Regular.ttf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	face := shaper.Face()
+	gid, _ := face.Cmap().Lookup('l')
+	plain, _ := face.GlyphExtents(gid)
+	plainOutline, _ := face.GlyphOutline(gid)
+
+	shaper.SetSyntheticSlant(0.2)
+	if ext, _ := face.GlyphExtents(gid); ext != plain {
+		t.Errorf("shared face extents %+v changed from %+v", ext, plain)
+	}
+	face = shaper.Face()
+	if face.SyntheticSlant() != 0.2 {
+		t.Fatalf("face.SyntheticSlant() = %v", face.SyntheticSlant())
+	}
+	slanted, _ := face.GlyphExtents(gid)
+	if d := slanted.XMax - plain.XMax; d != int16(math.Ceil(float64(plain.YMax)*0.2)) {
+		t.Errorf("XMax grew by %d for yMax %d", d, plain.YMax)
+	}
+	o, _ := face.GlyphOutline(gid)
+	for i, s := range o.Segments {
+		p, q := s.Args[0], plainOutline.Segments[i].Args[0]
+		if p.Y != q.Y || math.Abs(float64(p.X-(q.X+0.2*q.Y))) > 1e-3 {
+			t.Fatalf("segment %d: %v, want %v sheared", i, p, q)
+		}
+	}
+}
+
+// TestSyntheticFaceNotShared checks that synthetic settings of one shaper
+// leave other shapers of the same Face alone.
+func TestSyntheticFaceNotShared(t *testing.T) {
+	shaper, err := NewShaper(loadFont(t, "Roboto-Regular.ttf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	face := shaper.Face()
+	bold, err := NewShaperFromFace(face)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := NewShaperFromFace(face)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid, _ := face.Cmap().Lookup('I')
+	want, _ := face.GlyphExtents(gid)
+
+	bold.SetSyntheticBold(0.04, 0.04, false)
+	bold.SetSyntheticSlant(0.2)
+	if bold.Face() == face {
+		t.Fatal("bold shaper returns the shared face")
+	}
+	if got, _ := bold.Face().GlyphExtents(gid); got == want {
+		t.Errorf("bold extents %+v not emboldened", got)
+	}
+	for _, f := range []*Face{face, plain.Face()} {
+		if got, _ := f.GlyphExtents(gid); got != want {
+			t.Errorf("shared extents %+v, want %+v", got, want)
+		}
+	}
+
+	bold.SetSyntheticBold(0, 0, false)
+	bold.SetSyntheticSlant(0)
+	if bold.Face() != face {
+		t.Error("shaper without synthetic settings returns a copy")
+	}
+}
@@ -0,0 +1,741 @@
+package ot
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/boxesandglue/textshape/internal/brotli"
+)
+
+// maxWOFFSize limits the decoded size of a WOFF or WOFF2 font.
+const maxWOFFSize = 1 << 28
+
+// decodeWOFF converts a WOFF 1.0 font to an sfnt.
+// fontTools equivalent: SFNTReader with flavor "woff" (ttLib/sfnt.py)
+func decodeWOFF(data []byte) ([]byte, error) {
+	if len(data) < 44 {
+		return nil, ErrInvalidFont
+	}
+	flavor := binary.BigEndian.Uint32(data[4:])
+	numTables := int(binary.BigEndian.Uint16(data[12:]))
+	if 44+20*numTables > len(data) {
+		return nil, ErrInvalidFont
+	}
+
+	tags := make([]Tag, numTables)
+	tables := make([][]byte, numTables)
+	total := 0
+	for i := range numTables {
+		rec := data[44+20*i:]
+		offset := int(binary.BigEndian.Uint32(rec[4:]))
+		compLength := int(binary.BigEndian.Uint32(rec[8:]))
+		origLength := int(binary.BigEndian.Uint32(rec[12:]))
+		total += origLength
+		if compLength > origLength || total > maxWOFFSize || offset > len(data) || compLength > len(data)-offset {
+			return nil, ErrInvalidFont
+		}
+		tags[i] = Tag(binary.BigEndian.Uint32(rec))
+		tables[i] = data[offset : offset+compLength]
+		if compLength == origLength {
+			continue
+		}
+
+		// Compressed with zlib
+		zr, err := zlib.NewReader(bytes.NewReader(tables[i]))
+		if err != nil {
+			return nil, ErrInvalidFont
+		}
+		table, err := io.ReadAll(io.LimitReader(zr, int64(origLength)+1))
+		if err != nil || len(table) != origLength {
+			return nil, ErrInvalidFont
+		}
+		tables[i] = table
+	}
+	return buildSfnt(flavor, tags, tables), nil
+}
+
+// woff2KnownTags are the tags of the WOFF2 table directory flags 0-62.
+var woff2KnownTags = [63]Tag{
+	TagCmap, TagHead, TagHhea, TagHmtx, TagMaxp, TagName, TagOS2, TagPost,
+	MakeTag('c', 'v', 't', ' '), MakeTag('f', 'p', 'g', 'm'), TagGlyf, TagLoca,
+	MakeTag('p', 'r', 'e', 'p'), TagCFF, TagVORG, TagEBDT, TagEBLC,
+	MakeTag('g', 'a', 's', 'p'), MakeTag('h', 'd', 'm', 'x'), TagKern,
+	MakeTag('L', 'T', 'S', 'H'), MakeTag('P', 'C', 'L', 'T'), MakeTag('V', 'D', 'M', 'X'),
+	TagVhea, TagVmtx, TagBASE, TagGDEF, TagGPOS, TagGSUB, TagEBSC,
+	MakeTag('J', 'S', 'T', 'F'), TagMATH, TagCBDT, TagCBLC, TagCOLR, TagCPAL,
+	TagSVG, TagSbix, MakeTag('a', 'c', 'n', 't'), TagAvar, MakeTag('b', 'd', 'a', 't'),
+	MakeTag('b', 'l', 'o', 'c'), MakeTag('b', 's', 'l', 'n'), MakeTag('c', 'v', 'a', 'r'),
+	MakeTag('f', 'd', 's', 'c'), MakeTag('f', 'e', 'a', 't'), MakeTag('f', 'm', 't', 'x'),
+	TagFvar, TagGvar, MakeTag('h', 's', 't', 'y'), MakeTag('j', 'u', 's', 't'),
+	MakeTag('l', 'c', 'a', 'r'), MakeTag('m', 'o', 'r', 't'), MakeTag('m', 'o', 'r', 'x'),
+	MakeTag('o', 'p', 'b', 'd'), MakeTag('p', 'r', 'o', 'p'), MakeTag('t', 'r', 'a', 'k'),
+	MakeTag('Z', 'a', 'p', 'f'), MakeTag('S', 'i', 'l', 'f'), MakeTag('G', 'l', 'a', 't'),
+	MakeTag('G', 'l', 'o', 'c'), MakeTag('F', 'e', 'a', 't'), MakeTag('S', 'i', 'l', 'l'),
+}
+
+// woff2Entry is a WOFF2 table directory entry.
+type woff2Entry struct {
+	tag         Tag
+	origLength  int
+	length      int // length in the decompressed stream
+	transformed bool
+	data        []byte
+}
+
+// decodeWOFF2 converts a WOFF2 font, or font index of a WOFF2 collection,
+// to an sfnt, reconstructing transformed glyf, loca and hmtx tables.
+// fontTools equivalent: WOFF2Reader (ttLib/woff2.py)
+func decodeWOFF2(data []byte, index int) ([]byte, error) {
+	if len(data) < 48 {
+		return nil, ErrInvalidFont
+	}
+	p := NewParser(data)
+	p.SetOffset(4)
+	flavor, _ := p.U32()
+	p.Skip(4) // length
+	numTables, _ := p.U16()
+	p.Skip(6) // reserved, totalSfntSize
+	compressedLength, _ := p.U32()
+	p.SetOffset(48)
+
+	entries := make([]woff2Entry, numTables)
+	total := 0
+	for i := range entries {
+		e := &entries[i]
+		flags, err := p.U8()
+		if err != nil {
+			return nil, ErrInvalidFont
+		}
+		if flags&63 == 63 {
+			if e.tag, err = p.Tag(); err != nil {
+				return nil, ErrInvalidFont
+			}
+		} else {
+			e.tag = woff2KnownTags[flags&63]
+		}
+		origLength, err := readUIntBase128(p)
+		if err != nil {
+			return nil, err
+		}
+		e.origLength = int(origLength)
+		e.length = e.origLength
+
+		// Version 0 is the transform for glyf and loca but the null
+		// transform for other tables
+		version := flags >> 6
+		switch {
+		case e.tag == TagGlyf || e.tag == TagLoca:
+			e.transformed = version == 0
+			if version != 0 && version != 3 {
+				return nil, ErrInvalidFont
+			}
+		case e.tag == TagHmtx && version == 1:
+			e.transformed = true
+		case version != 0:
+			return nil, ErrInvalidFont
+		}
+		if e.transformed {
+			transformLength, err := readUIntBase128(p)
+			if err != nil {
+				return nil, err
+			}
+			e.length = int(transformLength)
+			if e.tag == TagLoca && e.length != 0 {
+				return nil, ErrInvalidFont
+			}
+		}
+		total += e.length
+		if total > maxWOFFSize {
+			return nil, ErrInvalidFont
+		}
+	}
+
+	// Table indices of the requested font
+	var indices []int
+	if flavor == 0x74746366 { // 'ttcf'
+		p.Skip(4) // version
+		numFonts, err := read255UInt16(p)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= int(numFonts) {
+			return nil, ErrInvalidFont
+		}
+		for i := range int(numFonts) {
+			n, err := read255UInt16(p)
+			if err != nil {
+				return nil, err
+			}
+			fontFlavor, err := p.U32()
+			if err != nil {
+				return nil, ErrInvalidFont
+			}
+			for range n {
+				idx, err := read255UInt16(p)
+				if err != nil {
+					return nil, err
+				}
+				if int(idx) >= len(entries) {
+					return nil, ErrInvalidFont
+				}
+				if i == index {
+					indices = append(indices, int(idx))
+				}
+			}
+			if i == index {
+				flavor = fontFlavor
+			}
+		}
+	} else {
+		if index != 0 {
+			return nil, ErrInvalidFont
+		}
+		for i := range entries {
+			indices = append(indices, i)
+		}
+	}
+
+	compressed, err := p.Bytes(int(compressedLength))
+	if err != nil {
+		return nil, ErrInvalidFont
+	}
+	stream, err := brotli.Decode(compressed, total)
+	if err != nil || len(stream) != total {
+		return nil, ErrInvalidFont
+	}
+	for i := range entries {
+		n := entries[i].length
+		entries[i].data, stream = stream[:n:n], stream[n:]
+	}
+
+	// Find the tables that the transforms depend on
+	find := func(tag Tag) *woff2Entry {
+		for _, i := range indices {
+			if entries[i].tag == tag {
+				return &entries[i]
+			}
+		}
+		return nil
+	}
+	glyf, loca, hmtx := find(TagGlyf), find(TagLoca), find(TagHmtx)
+	if (glyf == nil) != (loca == nil) || glyf != nil && glyf.transformed != loca.transformed {
+		return nil, ErrInvalidFont
+	}
+	var glyfData, locaData, hmtxData []byte
+	var xMins []int16
+	if glyf != nil && glyf.transformed {
+		if glyfData, locaData, xMins, err = reconstructGlyf(glyf.data); err != nil {
+			return nil, err
+		}
+		if len(locaData) != loca.origLength {
+			return nil, ErrInvalidFont
+		}
+	}
+	if hmtx != nil && hmtx.transformed {
+		hhea := find(TagHhea)
+		if xMins == nil || hhea == nil {
+			return nil, ErrInvalidFont
+		}
+		if hmtxData, err = reconstructHmtx(hmtx.data, hhea.data, xMins); err != nil {
+			return nil, err
+		}
+	}
+
+	tags := make([]Tag, len(indices))
+	tables := make([][]byte, len(indices))
+	for i, idx := range indices {
+		e := &entries[idx]
+		tags[i], tables[i] = e.tag, e.data
+		switch {
+		case e == glyf && glyf.transformed:
+			tables[i] = glyfData
+		case e == loca && loca.transformed:
+			tables[i] = locaData
+		case e == hmtx && hmtx.transformed:
+			tables[i] = hmtxData
+		}
+	}
+	return buildSfnt(flavor, tags, tables), nil
+}
+
+// readUIntBase128 reads a WOFF2 UIntBase128 value.
+func readUIntBase128(p *Parser) (uint32, error) {
+	var v uint32
+	for i := range 5 {
+		b, err := p.U8()
+		if err != nil || i == 0 && b == 0x80 || v&0xFE000000 != 0 {
+			// Leading zeros or overflow
+			return 0, ErrInvalidFont
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, ErrInvalidFont
+}
+
+// read255UInt16 reads a WOFF2 255UInt16 value.
+func read255UInt16(p *Parser) (uint16, error) {
+	code, err := p.U8()
+	if err != nil {
+		return 0, ErrInvalidFont
+	}
+	switch code {
+	case 253: // wordCode
+		v, err := p.U16()
+		if err != nil {
+			return 0, ErrInvalidFont
+		}
+		return v, nil
+	case 254: // oneMoreByteCode2
+		v, err := p.U8()
+		if err != nil {
+			return 0, ErrInvalidFont
+		}
+		return 506 + uint16(v), nil
+	case 255: // oneMoreByteCode1
+		v, err := p.U8()
+		if err != nil {
+			return 0, ErrInvalidFont
+		}
+		return 253 + uint16(v), nil
+	}
+	return uint16(code), nil
+}
+
+// reconstructGlyf rebuilds glyf and loca from a transformed glyf table.
+// It also returns the xMin of every glyph for the hmtx transform.
+func reconstructGlyf(data []byte) (glyf, loca []byte, xMins []int16, err error) {
+	p := NewParser(data)
+	p.Skip(2) // reserved
+	optionFlags, _ := p.U16()
+	numGlyphs, _ := p.U16()
+	indexFormat, err := p.U16()
+	if err != nil || indexFormat > 1 {
+		return nil, nil, nil, ErrInvalidFont
+	}
+	var streams [7]*Parser
+	offset := 36
+	for i := range streams {
+		size, err := p.U32()
+		if err != nil || int(size) > len(data)-offset {
+			return nil, nil, nil, ErrInvalidFont
+		}
+		streams[i] = NewParser(data[offset : offset+int(size)])
+		offset += int(size)
+	}
+	nContours, nPoints, flags, glyphs, composites, bboxes, instructions :=
+		streams[0], streams[1], streams[2], streams[3], streams[4], streams[5], streams[6]
+	bitmapSize := 4 * ((int(numGlyphs) + 31) / 32)
+	bboxBitmap, err := bboxes.Bytes(bitmapSize)
+	if err != nil {
+		return nil, nil, nil, ErrInvalidFont
+	}
+	var overlapBitmap []byte
+	if optionFlags&1 != 0 {
+		if offset+bitmapSize > len(data) {
+			return nil, nil, nil, ErrInvalidFont
+		}
+		overlapBitmap = data[offset : offset+bitmapSize]
+	}
+
+	xMins = make([]int16, numGlyphs)
+	offsets := make([]int, 0, int(numGlyphs)+1)
+	var points []SimpleGlyphPoint
+	for gid := range int(numGlyphs) {
+		offsets = append(offsets, len(glyf))
+		n, err := nContours.I16()
+		if err != nil {
+			return nil, nil, nil, ErrInvalidFont
+		}
+		bit := byte(0x80 >> (gid & 7))
+		explicitBBox := bboxBitmap[gid>>3]&bit != 0
+		var bbox []byte
+		if explicitBBox {
+			if bbox, err = bboxes.Bytes(8); err != nil {
+				return nil, nil, nil, ErrInvalidFont
+			}
+		}
+
+		switch {
+		case n == 0:
+			if explicitBBox {
+				return nil, nil, nil, ErrInvalidFont
+			}
+			continue
+
+		case n < 0:
+			// Composite glyphs must have an explicit bounding box
+			if n != -1 || !explicitBBox {
+				return nil, nil, nil, ErrInvalidFont
+			}
+			records, hasInstructions, err := compositeRecords(composites)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			glyf = binary.BigEndian.AppendUint16(glyf, 0xFFFF)
+			glyf = append(append(glyf, bbox...), records...)
+			if hasInstructions {
+				if glyf, err = appendInstructions(glyf, glyphs, instructions); err != nil {
+					return nil, nil, nil, err
+				}
+			}
+
+		default:
+			var endPts []uint16
+			numPoints := 0
+			for range n {
+				k, err := read255UInt16(nPoints)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				numPoints += int(k)
+				if numPoints > 0xFFFF {
+					return nil, nil, nil, ErrInvalidFont
+				}
+				endPts = append(endPts, uint16(numPoints-1))
+			}
+			if points, err = readTriplets(points[:0], numPoints, flags, glyphs); err != nil {
+				return nil, nil, nil, err
+			}
+			glyf = binary.BigEndian.AppendUint16(glyf, uint16(n))
+			if explicitBBox {
+				glyf = append(glyf, bbox...)
+			} else {
+				glyf = appendPointsBBox(glyf, points)
+			}
+			for _, e := range endPts {
+				glyf = binary.BigEndian.AppendUint16(glyf, e)
+			}
+			if glyf, err = appendInstructions(glyf, glyphs, instructions); err != nil {
+				return nil, nil, nil, err
+			}
+			overlap := overlapBitmap != nil && overlapBitmap[gid>>3]&bit != 0
+			glyf = appendSimpleGlyphPoints(glyf, points, overlap)
+		}
+
+		xMins[gid] = int16(binary.BigEndian.Uint16(glyf[offsets[gid]+2:]))
+		for len(glyf)%4 != 0 {
+			glyf = append(glyf, 0)
+		}
+	}
+	offsets = append(offsets, len(glyf))
+
+	if indexFormat == 0 && len(glyf) > 0x1FFFE {
+		return nil, nil, nil, ErrInvalidFont
+	}
+	for _, off := range offsets {
+		if indexFormat == 0 {
+			loca = binary.BigEndian.AppendUint16(loca, uint16(off/2))
+		} else {
+			loca = binary.BigEndian.AppendUint32(loca, uint32(off))
+		}
+	}
+	return glyf, loca, xMins, nil
+}
+
+// compositeRecords reads the component records of a composite glyph and
+// reports whether instructions follow them.
+func compositeRecords(p *Parser) ([]byte, bool, error) {
+	const (
+		arg1And2AreWords   = 0x0001
+		weHaveAScale       = 0x0008
+		moreComponents     = 0x0020
+		weHaveAnXAndYScale = 0x0040
+		weHaveATwoByTwo    = 0x0080
+		weHaveInstructions = 0x0100
+	)
+	start := p.Offset()
+	hasInstructions := false
+	for {
+		flags, err := p.U16()
+		if err != nil {
+			return nil, false, ErrInvalidFont
+		}
+		size := 2 + 2 // glyphIndex, two byte arguments
+		if flags&arg1And2AreWords != 0 {
+			size += 2
+		}
+		switch {
+		case flags&weHaveAScale != 0:
+			size += 2
+		case flags&weHaveAnXAndYScale != 0:
+			size += 4
+		case flags&weHaveATwoByTwo != 0:
+			size += 8
+		}
+		if err := p.Skip(size); err != nil {
+			return nil, false, ErrInvalidFont
+		}
+		hasInstructions = hasInstructions || flags&weHaveInstructions != 0
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return p.Data()[start:p.Offset()], hasInstructions, nil
+}
+
+// appendInstructions appends the instruction length from the glyph stream
+// and the instructions from the instruction stream.
+func appendInstructions(glyf []byte, glyphs, instructions *Parser) ([]byte, error) {
+	n, err := read255UInt16(glyphs)
+	if err != nil {
+		return nil, err
+	}
+	code, err := instructions.Bytes(int(n))
+	if err != nil {
+		return nil, ErrInvalidFont
+	}
+	glyf = binary.BigEndian.AppendUint16(glyf, n)
+	return append(glyf, code...), nil
+}
+
+// readTriplets decodes numPoints points from the flag and glyph streams.
+func readTriplets(points []SimpleGlyphPoint, numPoints int, flags, glyphs *Parser) ([]SimpleGlyphPoint, error) {
+	// withSign applies the sign in bit 0 of flag to v.
+	withSign := func(flag uint8, v int) int {
+		if flag&1 != 0 {
+			return v
+		}
+		return -v
+	}
+	var x, y int
+	for range numPoints {
+		flag, err := flags.U8()
+		if err != nil {
+			return nil, ErrInvalidFont
+		}
+		f := flag & 0x7F
+		var size int
+		switch {
+		case f < 84:
+			size = 1
+		case f < 120:
+			size = 2
+		case f < 124:
+			size = 3
+		default:
+			size = 4
+		}
+		b, err := glyphs.Bytes(size)
+		if err != nil {
+			return nil, ErrInvalidFont
+		}
+
+		var dx, dy int
+		switch {
+		case f < 10:
+			dy = withSign(f, int(f&14)<<7+int(b[0]))
+		case f < 20:
+			dx = withSign(f, int((f-10)&14)<<7+int(b[0]))
+		case f < 84:
+			b0 := int(f - 20)
+			dx = withSign(f, 1+b0&0x30+int(b[0])>>4)
+			dy = withSign(f>>1, 1+(b0&0x0C)<<2+int(b[0])&0x0F)
+		case f < 120:
+			b0 := int(f - 84)
+			dx = withSign(f, 1+(b0/12)<<8+int(b[0]))
+			dy = withSign(f>>1, 1+((b0%12)>>2)<<8+int(b[1]))
+		case f < 124:
+			dx = withSign(f, int(b[0])<<4+int(b[1])>>4)
+			dy = withSign(f>>1, int(b[1]&0x0F)<<8+int(b[2]))
+		default:
+			dx = withSign(f, int(binary.BigEndian.Uint16(b)))
+			dy = withSign(f>>1, int(binary.BigEndian.Uint16(b[2:])))
+		}
+		x += dx
+		y += dy
+		if x < -32768 || x > 32767 || y < -32768 || y > 32767 {
+			return nil, ErrInvalidFont
+		}
+		// Bit 7 is set for off-curve points
+		points = append(points, SimpleGlyphPoint{X: int16(x), Y: int16(y), OnCurve: flag&0x80 == 0})
+	}
+	return points, nil
+}
+
+// appendPointsBBox appends the bounding box of points.
+func appendPointsBBox(glyf []byte, points []SimpleGlyphPoint) []byte {
+	var xMin, yMin, xMax, yMax int16
+	for i, pt := range points {
+		if i == 0 || pt.X < xMin {
+			xMin = pt.X
+		}
+		if i == 0 || pt.X > xMax {
+			xMax = pt.X
+		}
+		if i == 0 || pt.Y < yMin {
+			yMin = pt.Y
+		}
+		if i == 0 || pt.Y > yMax {
+			yMax = pt.Y
+		}
+	}
+	for _, v := range [4]int16{xMin, yMin, xMax, yMax} {
+		glyf = binary.BigEndian.AppendUint16(glyf, uint16(v))
+	}
+	return glyf
+}
+
+// appendSimpleGlyphPoints appends the flags and coordinates of a simple
+// glyph, using short vectors and repeated flags where possible.
+func appendSimpleGlyphPoints(glyf []byte, points []SimpleGlyphPoint, overlap bool) []byte {
+	const (
+		onCurvePoint      = 0x01
+		xShortVector      = 0x02
+		yShortVector      = 0x04
+		repeatFlag        = 0x08
+		xIsSameOrPositive = 0x10
+		yIsSameOrPositive = 0x20
+		overlapSimple     = 0x40
+	)
+	var xs, ys []byte
+	var lastFlag byte
+	repeat := 0
+	var prevX, prevY int16
+	for i, pt := range points {
+		var flag byte
+		if pt.OnCurve {
+			flag |= onCurvePoint
+		}
+		if i == 0 && overlap {
+			flag |= overlapSimple
+		}
+		dx, dy := int(pt.X)-int(prevX), int(pt.Y)-int(prevY)
+		prevX, prevY = pt.X, pt.Y
+		switch {
+		case dx == 0:
+			flag |= xIsSameOrPositive
+		case dx > -256 && dx < 256:
+			flag |= xShortVector
+			if dx > 0 {
+				flag |= xIsSameOrPositive
+			} else {
+				dx = -dx
+			}
+			xs = append(xs, byte(dx))
+		default:
+			xs = binary.BigEndian.AppendUint16(xs, uint16(dx))
+		}
+		switch {
+		case dy == 0:
+			flag |= yIsSameOrPositive
+		case dy > -256 && dy < 256:
+			flag |= yShortVector
+			if dy > 0 {
+				flag |= yIsSameOrPositive
+			} else {
+				dy = -dy
+			}
+			ys = append(ys, byte(dy))
+		default:
+			ys = binary.BigEndian.AppendUint16(ys, uint16(dy))
+		}
+
+		if i > 0 && flag == lastFlag && repeat < 255 {
+			if repeat == 0 {
+				glyf[len(glyf)-1] |= repeatFlag
+				glyf = append(glyf, 0)
+			}
+			repeat++
+			glyf[len(glyf)-1] = byte(repeat)
+			continue
+		}
+		repeat = 0
+		lastFlag = flag
+		glyf = append(glyf, flag)
+	}
+	return append(append(glyf, xs...), ys...)
+}
+
+// reconstructHmtx rebuilds hmtx from a transformed hmtx table, taking the
+// omitted left side bearings from the glyph xMins.
+func reconstructHmtx(data, hhea []byte, xMins []int16) ([]byte, error) {
+	if len(hhea) < 36 || len(data) < 1 {
+		return nil, ErrInvalidFont
+	}
+	numGlyphs := len(xMins)
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	flags := data[0]
+	if numHMetrics < 1 || numHMetrics > numGlyphs || flags&^3 != 0 || flags == 0 {
+		return nil, ErrInvalidFont
+	}
+	p := NewParser(data[1:])
+	advances, err := p.Bytes(2 * numHMetrics)
+	if err != nil {
+		return nil, ErrInvalidFont
+	}
+	lsb := func(gid int, omitted bool) (uint16, error) {
+		if omitted {
+			return uint16(xMins[gid]), nil
+		}
+		return p.U16()
+	}
+
+	hmtx := make([]byte, 0, 4*numHMetrics+2*(numGlyphs-numHMetrics))
+	for gid := range numGlyphs {
+		if gid < numHMetrics {
+			hmtx = append(hmtx, advances[2*gid:2*gid+2]...)
+		}
+		v, err := lsb(gid, gid < numHMetrics && flags&1 != 0 || gid >= numHMetrics && flags&2 != 0)
+		if err != nil {
+			return nil, ErrInvalidFont
+		}
+		hmtx = binary.BigEndian.AppendUint16(hmtx, v)
+	}
+	return hmtx, nil
+}
+
+// buildSfnt assembles tables into an sfnt with a sorted table directory.
+func buildSfnt(flavor uint32, tags []Tag, tables [][]byte) []byte {
+	order := make([]int, len(tags))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return tags[order[i]] < tags[order[j]] })
+
+	size := 12 + 16*len(tags)
+	for _, t := range tables {
+		size += (len(t) + 3) &^ 3
+	}
+	sfnt := make([]byte, 12+16*len(tags), size)
+	entrySelector := 0
+	for 2<<entrySelector <= len(tags) {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+	binary.BigEndian.PutUint32(sfnt[0:], flavor)
+	binary.BigEndian.PutUint16(sfnt[4:], uint16(len(tags)))
+	binary.BigEndian.PutUint16(sfnt[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(sfnt[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(sfnt[10:], uint16(16*len(tags)-searchRange))
+	for i, idx := range order {
+		table := tables[idx]
+		rec := sfnt[12+16*i:]
+		binary.BigEndian.PutUint32(rec[0:], uint32(tags[idx]))
+		binary.BigEndian.PutUint32(rec[4:], tableChecksum(table))
+		binary.BigEndian.PutUint32(rec[8:], uint32(len(sfnt)))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(table)))
+		sfnt = append(sfnt, table...)
+		for len(sfnt)%4 != 0 {
+			sfnt = append(sfnt, 0)
+		}
+	}
+	return sfnt
+}
+
+// tableChecksum returns the OpenType checksum of a table.
+func tableChecksum(data []byte) uint32 {
+	var sum uint32
+	for len(data) >= 4 {
+		sum += binary.BigEndian.Uint32(data)
+		data = data[4:]
+	}
+	var last [4]byte
+	copy(last[:], data)
+	return sum + binary.BigEndian.Uint32(last[:])
+}
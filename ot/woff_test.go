@@ -0,0 +1,219 @@
+package ot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/boxesandglue/textshape/internal/brotli"
+	"github.com/boxesandglue/textshape/internal/testutil"
+)
+
+// The Roboto-subset fixtures hold the same subset of Roboto-Regular as an
+// sfnt, as WOFF and as WOFF2 with the glyf, loca and hmtx transforms, all
+// written by the subset package. The fontawesome-webfont fixtures are
+// Font Awesome 4.7 as distributed, encoded by other tools; its WOFF2 font
+// transforms glyf and loca but not hmtx.
+
+func readWOFFFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	path := testutil.FindTestFont(name)
+	if path == "" {
+		t.Skipf("%s not found", name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return data
+}
+
+func parseWOFFFixture(t *testing.T, name string) *Font {
+	t.Helper()
+	font, err := ParseFont(readWOFFFixture(t, name), 0)
+	if err != nil {
+		t.Fatalf("parse %s: %v", name, err)
+	}
+	return font
+}
+
+// equalTables reports the tables of got that differ from want, except
+// for the skipped ones.
+func equalTables(t *testing.T, want, got *Font, skip ...Tag) {
+	t.Helper()
+	if !slices.Equal(got.TableTags(), want.TableTags()) {
+		t.Fatalf("tables %v, want %v", got.TableTags(), want.TableTags())
+	}
+	for _, tag := range want.TableTags() {
+		if slices.Contains(skip, tag) {
+			continue
+		}
+		w, _ := want.TableData(tag)
+		g, err := got.TableData(tag)
+		if err != nil || !bytes.Equal(g, w) {
+			t.Errorf("%s: %d bytes differ from %d (%v)", tag, len(g), len(w), err)
+		}
+	}
+}
+
+func TestParseWOFF(t *testing.T) {
+	want := parseWOFFFixture(t, "Roboto-subset.ttf")
+	got := parseWOFFFixture(t, "Roboto-subset.woff")
+	equalTables(t, want, got)
+}
+
+func TestParseWOFF2(t *testing.T) {
+	want := parseWOFFFixture(t, "Roboto-subset.ttf")
+	got := parseWOFFFixture(t, "Roboto-subset.woff2")
+
+	// head differs in checkSumAdjustment and flags bit 11, glyf and loca
+	// in the encoding of the reconstructed glyphs
+	equalTables(t, want, got, TagHead, TagGlyf, TagLoca)
+	wantHead, _ := want.TableData(TagHead)
+	gotHead, _ := got.TableData(TagHead)
+	if !bytes.Equal(gotHead[:8], wantHead[:8]) || !bytes.Equal(gotHead[18:], wantHead[18:]) {
+		t.Errorf("head differs")
+	}
+
+	if composites := equalGlyphs(t, want, got); composites == 0 {
+		t.Errorf("no composite glyphs in fixture")
+	}
+}
+
+func TestParseWOFF2Independent(t *testing.T) {
+	want := parseWOFFFixture(t, "fontawesome-webfont.ttf")
+	equalTables(t, want, parseWOFFFixture(t, "fontawesome-webfont.woff"))
+
+	got := parseWOFFFixture(t, "fontawesome-webfont.woff2")
+	equalTables(t, want, got, TagHead, TagGlyf, TagLoca)
+	wantHead, _ := want.TableData(TagHead)
+	gotHead, _ := got.TableData(TagHead)
+	if !bytes.Equal(gotHead[:8], wantHead[:8]) || !bytes.Equal(gotHead[18:], wantHead[18:]) {
+		t.Errorf("head differs")
+	}
+	equalGlyphs(t, want, got)
+}
+
+// equalGlyphs reports the glyphs of got whose outlines differ from want
+// and returns the number of composite glyphs.
+func equalGlyphs(t *testing.T, want, got *Font) (composites int) {
+	t.Helper()
+	wantGlyf, err := ParseGlyfFromFont(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotGlyf, err := ParseGlyfFromFont(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for gid := range want.NumGlyphs() {
+		w, g := wantGlyf.GetGlyph(GlyphID(gid)), gotGlyf.GetGlyph(GlyphID(gid))
+		if w == nil || g == nil || w.NumberOfContours != g.NumberOfContours {
+			t.Errorf("glyph %d: got %+v, want %+v", gid, g, w)
+			continue
+		}
+		switch {
+		case w.NumberOfContours < 0:
+			// Composites differ at most in padding
+			composites++
+			if !bytes.HasPrefix(w.Data, g.Data) && !bytes.HasPrefix(g.Data, w.Data) {
+				t.Errorf("glyph %d: composite differs", gid)
+			}
+		case w.NumberOfContours > 0:
+			wp, _, _ := ParseSimpleGlyph(w.Data)
+			gp, _, err := ParseSimpleGlyph(g.Data)
+			if err != nil || !slices.Equal(gp, wp) || !bytes.Equal(g.Data[:10], w.Data[:10]) {
+				t.Errorf("glyph %d: outline differs (%v)", gid, err)
+			}
+		}
+	}
+	return composites
+}
+
+// buildWOFF2Collection returns a WOFF2 collection of the tables of
+// fonts, stored with the null transform. Tables with equal tags and data
+// are shared.
+func buildWOFF2Collection(t *testing.T, fonts ...*Font) []byte {
+	t.Helper()
+	var dir, stream, collection []byte
+	var shared [][]byte
+	numTables := 0
+	collection = binary.BigEndian.AppendUint32(collection, 0x00010000)
+	collection = append(collection, byte(len(fonts)))
+	for _, font := range fonts {
+		collection = append(collection, byte(len(font.TableTags())))
+		collection = binary.BigEndian.AppendUint32(collection, 0x00010000)
+		for _, tag := range font.TableTags() {
+			data, _ := font.TableData(tag)
+			idx := slices.IndexFunc(shared, func(d []byte) bool { return bytes.Equal(d, data) })
+			if idx < 0 {
+				idx = numTables
+				numTables++
+				shared = append(shared, data)
+				flags := byte(63)
+				if tag == TagGlyf || tag == TagLoca {
+					flags |= 3 << 6
+				}
+				dir = binary.BigEndian.AppendUint32(append(dir, flags), uint32(tag))
+				dir = appendTestUIntBase128(dir, len(data))
+				stream = append(stream, data...)
+			}
+			collection = append(collection, byte(idx))
+		}
+	}
+	compressed := brotli.Encode(stream)
+
+	out := make([]byte, 48)
+	out = append(append(append(out, dir...), collection...), compressed...)
+	binary.BigEndian.PutUint32(out[0:], 0x774F4632)
+	binary.BigEndian.PutUint32(out[4:], 0x74746366)
+	binary.BigEndian.PutUint32(out[8:], uint32(len(out)))
+	binary.BigEndian.PutUint16(out[12:], uint16(numTables))
+	binary.BigEndian.PutUint32(out[20:], uint32(len(compressed)))
+	return out
+}
+
+func appendTestUIntBase128(b []byte, v int) []byte {
+	var groups []byte
+	for {
+		groups = append(groups, byte(v&0x7F))
+		if v >>= 7; v == 0 {
+			break
+		}
+	}
+	for i := len(groups) - 1; i > 0; i-- {
+		b = append(b, groups[i]|0x80)
+	}
+	return append(b, groups[0])
+}
+
+func TestParseWOFF2Collection(t *testing.T) {
+	roboto := parseWOFFFixture(t, "Roboto-subset.ttf")
+	chromacheck := parseWOFFFixture(t, "chromacheck-svg.ttf")
+	data := buildWOFF2Collection(t, roboto, chromacheck)
+	for i, want := range []*Font{roboto, chromacheck} {
+		got, err := ParseFont(data, i)
+		if err != nil {
+			t.Fatalf("font %d: %v", i, err)
+		}
+		equalTables(t, want, got)
+	}
+	if _, err := ParseFont(data, 2); !errors.Is(err, ErrInvalidFont) {
+		t.Errorf("font 2: %v, want ErrInvalidFont", err)
+	}
+}
+
+func TestParseWOFFErrors(t *testing.T) {
+	for _, name := range []string{"Roboto-subset.woff", "Roboto-subset.woff2"} {
+		data := readWOFFFixture(t, name)
+		if _, err := ParseFont(data, 1); !errors.Is(err, ErrInvalidFont) {
+			t.Errorf("%s index 1: %v, want ErrInvalidFont", name, err)
+		}
+		if _, err := ParseFont(data[:len(data)-100], 0); !errors.Is(err, ErrInvalidFont) {
+			t.Errorf("%s truncated: %v, want ErrInvalidFont", name, err)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package subset
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// BASE table subsetting.
+//
+// The table is rebuilt from the source bytes. Each axis keeps its
+// baseline tags and the BaseScript records of the retained scripts.
+// BaseCoord reference glyphs are remapped; coordinates whose glyph is
+// not retained fall back to format 1. Device tables are dropped except
+// VariationIndex tables of a variable font, whose item variation store
+// is subset along; at an instanced location their deltas are baked into
+// the coordinates.
+
+// baseWriter rebuilds the subtables of a BASE table. The table is
+// written twice when variation indices are kept: the first pass collects
+// them in vb, the second writes their new indices.
+type baseWriter struct {
+	p     *Plan
+	data  []byte
+	store *ot.ItemVariationStore
+
+	// applyDeltas bakes the deltas at the instance location; static
+	// drops the device tables afterwards.
+	applyDeltas bool
+	static      bool
+
+	vb       *varStoreBuilder
+	varStore []byte
+	collect  bool
+	kept     bool // a VariationIndex device was written
+	err      error
+}
+
+// subsetBASE subsets the BASE table. Returns nil if no script remains.
+// HarfBuzz equivalent: BASE::subset (hb-ot-layout-base-table.hh)
+func (p *Plan) subsetBASE() ([]byte, error) {
+	data, err := p.source.TableData(ot.TagBASE)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, ot.ErrInvalidTable
+	}
+	w := &baseWriter{p: p, data: data, static: p.IsInstanced()}
+	if binary.BigEndian.Uint16(data[2:]) >= 1 && len(data) >= 12 {
+		if off := int(binary.BigEndian.Uint32(data[8:])); off != 0 && off < len(data) {
+			if w.store, err = ot.ParseItemVariationStore(data[off:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if w.store != nil {
+		w.applyDeltas = p.normalizedCoords != nil
+		if !w.static {
+			w.vb = p.newVarStoreBuilder(w.store)
+			w.collect = true
+			w.axes()
+			w.collect = false
+			w.varStore = w.vb.build()
+		}
+	}
+	horiz, vert := w.axes()
+	if w.err != nil {
+		return nil, w.err
+	}
+	if horiz == nil && vert == nil {
+		return nil, nil
+	}
+
+	if !w.kept {
+		return w.pack(appendUint16s(nil, 1, 0, 0, 0), []int{4, 6}, [][]byte{horiz, vert}), w.err
+	}
+	out := w.pack(appendUint16s(nil, 1, 1, 0, 0, 0, 0), []int{4, 6}, [][]byte{horiz, vert})
+	binary.BigEndian.PutUint32(out[8:], uint32(len(out)))
+	out = append(out, w.varStore...)
+	return out, w.err
+}
+
+// axes subsets the horizontal and vertical Axis tables.
+func (w *baseWriter) axes() (horiz, vert []byte) {
+	w.kept = false
+	if off := w.offset(0, 4); off != 0 {
+		horiz = w.axis(off)
+	}
+	if off := w.offset(0, 6); off != 0 {
+		vert = w.axis(off)
+	}
+	return horiz, vert
+}
+
+// offset returns the absolute position of the Offset16 at base+at, or 0
+// for a null offset.
+func (w *baseWriter) offset(base, at int) int {
+	if base+at+2 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return 0
+	}
+	off := int(binary.BigEndian.Uint16(w.data[base+at:]))
+	if off == 0 {
+		return 0
+	}
+	return base + off
+}
+
+// pack is packTable with a check for 16-bit offset overflow.
+func (w *baseWriter) pack(header []byte, offsetAt []int, children [][]byte) []byte {
+	size := len(header)
+	for _, child := range children {
+		if child != nil && size > 0xFFFF {
+			w.err = ErrOffsetOverflow
+		}
+		size += len(child)
+	}
+	return packTable(header, offsetAt, children)
+}
+
+// axis subsets an Axis table. Returns nil if no script is retained.
+func (w *baseWriter) axis(off int) []byte {
+	var tagList []byte
+	if t := w.offset(off, 0); t != 0 {
+		if t+2 > len(w.data) {
+			w.err = ot.ErrInvalidTable
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(w.data[t:]))
+		if t+2+n*4 > len(w.data) {
+			w.err = ot.ErrInvalidTable
+			return nil
+		}
+		tagList = append([]byte(nil), w.data[t:t+2+n*4]...)
+	}
+	t := w.offset(off, 2)
+	if t == 0 {
+		return nil
+	}
+	scriptList := w.scriptList(t)
+	if scriptList == nil {
+		return nil
+	}
+	return w.pack(make([]byte, 4), []int{0, 2}, [][]byte{tagList, scriptList})
+}
+
+// scriptList subsets a BaseScriptList to the retained scripts. Returns
+// nil if none remains.
+func (w *baseWriter) scriptList(off int) []byte {
+	if off+2 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(w.data[off:]))
+	if off+2+n*6 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	var tags []uint32
+	var scripts [][]byte
+	for i := range n {
+		rec := off + 2 + i*6
+		tag := binary.BigEndian.Uint32(w.data[rec:])
+		if !w.p.input.ShouldKeepScript(ot.Tag(tag)) {
+			continue
+		}
+		if s := w.offset(off, i*6+6); s != 0 {
+			tags = append(tags, tag)
+			scripts = append(scripts, w.script(s))
+		}
+	}
+	if len(scripts) == 0 {
+		return nil
+	}
+	header := appendUint16s(nil, uint16(len(scripts)))
+	offsetAt := make([]int, len(scripts))
+	for i, tag := range tags {
+		offsetAt[i] = len(header) + 4
+		header = appendUint16s(binary.BigEndian.AppendUint32(header, tag), 0)
+	}
+	return w.pack(header, offsetAt, scripts)
+}
+
+// script subsets a BaseScript table.
+func (w *baseWriter) script(off int) []byte {
+	if off+6 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	var values, defaultMinMax []byte
+	if t := w.offset(off, 0); t != 0 {
+		values = w.values(t)
+	}
+	if t := w.offset(off, 2); t != 0 {
+		defaultMinMax = w.minMax(t)
+	}
+	n := int(binary.BigEndian.Uint16(w.data[off+4:]))
+	if off+6+n*6 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	header := appendUint16s(nil, 0, 0, uint16(n))
+	offsetAt := []int{0, 2}
+	children := [][]byte{values, defaultMinMax}
+	for i := range n {
+		rec := off + 6 + i*6
+		offsetAt = append(offsetAt, len(header)+4)
+		header = appendUint16s(binary.BigEndian.AppendUint32(header, binary.BigEndian.Uint32(w.data[rec:])), 0)
+		var minMax []byte
+		if t := w.offset(off, 6+i*6+4); t != 0 {
+			minMax = w.minMax(t)
+		}
+		children = append(children, minMax)
+	}
+	return w.pack(header, offsetAt, children)
+}
+
+// values subsets a BaseValues table.
+func (w *baseWriter) values(off int) []byte {
+	if off+4 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(w.data[off+2:]))
+	if off+4+n*2 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	header := append([]byte(nil), w.data[off:off+4+n*2]...)
+	offsetAt := make([]int, n)
+	children := make([][]byte, n)
+	for i := range n {
+		offsetAt[i] = 4 + i*2
+		binary.BigEndian.PutUint16(header[4+i*2:], 0)
+		if t := w.offset(off, 4+i*2); t != 0 {
+			children[i] = w.coord(t)
+		}
+	}
+	return w.pack(header, offsetAt, children)
+}
+
+// minMax subsets a MinMax table with its FeatMinMax records.
+func (w *baseWriter) minMax(off int) []byte {
+	if off+6 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(w.data[off+4:]))
+	if off+6+n*8 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	header := appendUint16s(nil, 0, 0, uint16(n))
+	offsetAt := []int{0, 2}
+	for i := range n {
+		rec := off + 6 + i*8
+		offsetAt = append(offsetAt, len(header)+4, len(header)+6)
+		header = appendUint16s(binary.BigEndian.AppendUint32(header, binary.BigEndian.Uint32(w.data[rec:])), 0, 0)
+	}
+	children := make([][]byte, len(offsetAt))
+	for i, at := range offsetAt {
+		if t := w.offset(off, at); t != 0 {
+			children[i] = w.coord(t)
+		}
+	}
+	return w.pack(header, offsetAt, children)
+}
+
+// coord subsets a BaseCoord table.
+func (w *baseWriter) coord(off int) []byte {
+	if off+4 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	format := binary.BigEndian.Uint16(w.data[off:])
+	coord := int16(binary.BigEndian.Uint16(w.data[off+2:]))
+	switch format {
+	case 2:
+		// Reference glyphs that are not retained lose their contour point
+		if off+8 > len(w.data) {
+			w.err = ot.ErrInvalidTable
+			return nil
+		}
+		if newGID, ok := w.p.glyphMap[binary.BigEndian.Uint16(w.data[off+4:])]; ok {
+			return appendUint16s(nil, 2, uint16(coord), newGID, binary.BigEndian.Uint16(w.data[off+6:]))
+		}
+	case 3:
+		d := w.offset(off, 4)
+		if d == 0 || d+6 > len(w.data) ||
+			binary.BigEndian.Uint16(w.data[d+4:]) != ot.DeltaFormatVariationIndex || w.store == nil {
+			break
+		}
+		varIdx := binary.BigEndian.Uint32(w.data[d:])
+		if w.applyDeltas {
+			coord += int16(math.Round(w.store.GetDelta(varIdx, w.p.normalizedCoords)))
+		}
+		if w.static {
+			break
+		}
+		if w.collect {
+			w.vb.add(varIdx)
+		}
+		newIdx := w.vb.mapped(varIdx)
+		if newIdx == noVariationsIndex {
+			break
+		}
+		w.kept = true
+		return appendUint16s(nil, 3, uint16(coord), 6, uint16(newIdx>>16), uint16(newIdx), ot.DeltaFormatVariationIndex)
+	}
+	return appendUint16s(nil, 1, uint16(coord))
+}
@@ -0,0 +1,185 @@
+package subset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// baseTestCoord is a BaseCoord read by baseCoords.
+type baseTestCoord struct {
+	format uint16
+	coord  int16
+	varIdx int64 // -1 without VariationIndex device
+}
+
+// baseCoords returns the BaseCoords of a BASE table keyed by axis and
+// script ("horiz/latn"), in table order.
+func baseCoords(data []byte) map[string][]baseTestCoord {
+	u16 := func(off int) int { return int(binary.BigEndian.Uint16(data[off:])) }
+	out := make(map[string][]baseTestCoord)
+	for i, axisName := range []string{"horiz", "vert"} {
+		axis := u16(4 + i*2)
+		if axis == 0 {
+			continue
+		}
+		list := axis + u16(axis+2)
+		for s := range u16(list) {
+			key := axisName + "/" + string(data[list+2+s*6:list+6+s*6])
+			script := list + u16(list+6+s*6)
+			coord := func(base, at int) {
+				if u16(base+at) == 0 {
+					return
+				}
+				off := base + u16(base+at)
+				c := baseTestCoord{format: uint16(u16(off)), coord: int16(u16(off + 2)), varIdx: -1}
+				if c.format == 3 && u16(off+4) != 0 {
+					dev := off + u16(off+4)
+					if u16(dev+4) == ot.DeltaFormatVariationIndex {
+						c.varIdx = int64(binary.BigEndian.Uint32(data[dev:]))
+					}
+				}
+				out[key] = append(out[key], c)
+			}
+			minMax := func(off int) {
+				coord(off, 0)
+				coord(off, 2)
+				for f := range u16(off + 4) {
+					coord(off, 6+f*8+4)
+					coord(off, 6+f*8+6)
+				}
+			}
+			if u16(script) != 0 {
+				values := script + u16(script)
+				for v := range u16(values + 2) {
+					coord(values, 4+v*2)
+				}
+			}
+			if u16(script+2) != 0 {
+				minMax(script + u16(script+2))
+			}
+			for l := range u16(script + 4) {
+				minMax(script + u16(script+6+l*6+4))
+			}
+		}
+	}
+	return out
+}
+
+// baseKeys returns the sorted keys of baseCoords.
+func baseKeys(coords map[string][]baseTestCoord) string {
+	var keys []string
+	for k := range coords {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
+
+func TestSubsetBASEScripts(t *testing.T) {
+	for _, name := range []string{"6991b13ce889466be6de3f66e891de2bc0f117ee.ttf", "NotoSerifHK-subset.ttf"} {
+		font := loadFont(t, name)
+		src := baseCoords(mustTableData(t, font, ot.TagBASE))
+
+		// All scripts are kept by default
+		input := NewInput()
+		input.AddString("Aa")
+		_, _, subFont := subsetAndParse(t, font, input)
+		if got := baseCoords(mustTableData(t, subFont, ot.TagBASE)); fmt.Sprint(got) != fmt.Sprint(src) {
+			t.Errorf("%s: BASE\n got %v\nwant %v", name, got, src)
+		}
+
+		input = NewInput()
+		input.AddString("Aa")
+		input.KeepScript(ot.MakeTag('l', 'a', 't', 'n'))
+		input.KeepScript(ot.MakeTag('h', 'a', 'n', 'i'))
+		_, _, subFont = subsetAndParse(t, font, input)
+		got := baseCoords(mustTableData(t, subFont, ot.TagBASE))
+		if keys := baseKeys(got); keys != "horiz/hani horiz/latn vert/hani vert/latn" {
+			t.Errorf("%s: scripts %s", name, keys)
+		}
+		for key, coords := range got {
+			if fmt.Sprint(coords) != fmt.Sprint(src[key]) {
+				t.Errorf("%s: %s is %v, want %v", name, key, coords, src[key])
+			}
+		}
+
+		input = NewInput()
+		input.AddString("Aa")
+		input.KeepScript(ot.MakeTag('a', 'r', 'a', 'b'))
+		if _, _, subFont := subsetAndParse(t, font, input); subFont.HasTable(ot.TagBASE) {
+			t.Errorf("%s: BASE kept without scripts", name)
+		}
+	}
+}
+
+func TestSubsetBASEVariations(t *testing.T) {
+	font := loadFont(t, "NotoSansCJK-VF.abc.ttf")
+	srcData := mustTableData(t, font, ot.TagBASE)
+	src := baseCoords(srcData)
+	store, err := ot.ParseItemVariationStore(srcData[binary.BigEndian.Uint32(srcData[8:]):])
+	if err != nil {
+		t.Fatalf("ParseItemVariationStore: %v", err)
+	}
+
+	// Variable: the devices are kept with a subset store
+	input := NewInput()
+	input.AddString("Aa")
+	_, _, subFont := subsetAndParse(t, font, input)
+	data := mustTableData(t, subFont, ot.TagBASE)
+	if binary.BigEndian.Uint16(data[2:]) != 1 || binary.BigEndian.Uint32(data[8:]) == 0 {
+		t.Fatalf("BASE %d.%d without item variation store", data[1], data[3])
+	}
+	subStore, err := ot.ParseItemVariationStore(data[binary.BigEndian.Uint32(data[8:]):])
+	if err != nil {
+		t.Fatalf("ParseItemVariationStore: %v", err)
+	}
+	varied := 0
+	for key, coords := range baseCoords(data) {
+		if len(coords) != len(src[key]) {
+			t.Fatalf("%s: %d coords, want %d", key, len(coords), len(src[key]))
+		}
+		for i, c := range coords {
+			want := src[key][i]
+			if c.coord != want.coord || (c.varIdx < 0) != (want.varIdx < 0) {
+				t.Errorf("%s: coord %d is %+v, want %+v", key, i, c, want)
+				continue
+			}
+			if c.varIdx < 0 {
+				continue
+			}
+			varied++
+			coords := []int{1 << 14}
+			if got, want := subStore.GetDelta(uint32(c.varIdx), coords), store.GetDelta(uint32(want.varIdx), coords); got != want {
+				t.Errorf("%s: coord %d delta %v, want %v", key, i, got, want)
+			}
+		}
+	}
+	if varied == 0 {
+		t.Fatal("no varied coordinates")
+	}
+
+	// Instanced: the deltas are baked
+	input.PinAxisLocation(ot.TagAxisWeight, 900)
+	_, _, subFont = subsetAndParse(t, font, input)
+	data = mustTableData(t, subFont, ot.TagBASE)
+	if binary.BigEndian.Uint16(data[2:]) != 0 {
+		t.Errorf("instanced BASE version %d.%d, want 1.0", data[1], data[3])
+	}
+	for key, coords := range baseCoords(data) {
+		for i, c := range coords {
+			want := src[key][i]
+			if want.varIdx >= 0 {
+				want.coord += int16(math.Round(store.GetDelta(uint32(want.varIdx), []int{1 << 14})))
+			}
+			if c.format == 3 || c.coord != want.coord {
+				t.Errorf("%s: instanced coord %d is %+v, want %d", key, i, c, want.coord)
+			}
+		}
+	}
+}
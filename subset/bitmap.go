@@ -0,0 +1,295 @@
+package subset
+
+import (
+	"encoding/binary"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// Color bitmap tables: CBLC/CBDT and sbix.
+//
+// Both tables store one image per glyph and strike. The images are
+// copied unchanged; only the per-strike glyph index is rebuilt for the
+// new glyph IDs. Strikes without any retained glyph are dropped, and
+// Input.KeepStrikeNearest drops all strikes but the one a renderer would
+// pick at the requested size.
+
+// cblcGlyph is the CBDT image of a glyph in a strike.
+type cblcGlyph struct {
+	data        []byte
+	indexFormat uint16
+	imageFormat uint16
+}
+
+// locateCBLCGlyph finds the image of gid in the IndexSubtableArray at
+// arrOff. Only index formats 1 and 3 are supported: the images of the
+// other formats rely on metrics stored in CBLC.
+// HarfBuzz equivalent: IndexSubtableRecord::get_image_data (CBDT.hh)
+func locateCBLCGlyph(cblc, cbdt []byte, arrOff, numSubtables int, gid ot.GlyphID) (cblcGlyph, bool) {
+	for i := range numSubtables {
+		rec := arrOff + i*8
+		first := binary.BigEndian.Uint16(cblc[rec:])
+		last := binary.BigEndian.Uint16(cblc[rec+2:])
+		if gid < first || gid > last {
+			continue
+		}
+		sub := arrOff + int(binary.BigEndian.Uint32(cblc[rec+4:]))
+		if sub+8 > len(cblc) {
+			return cblcGlyph{}, false
+		}
+		g := cblcGlyph{
+			indexFormat: binary.BigEndian.Uint16(cblc[sub:]),
+			imageFormat: binary.BigEndian.Uint16(cblc[sub+2:]),
+		}
+		base := int(binary.BigEndian.Uint32(cblc[sub+4:]))
+		idx := int(gid - first)
+		var start, end int
+		switch g.indexFormat {
+		case 1:
+			p := sub + 8 + idx*4
+			if p+8 > len(cblc) {
+				return g, false
+			}
+			start = int(binary.BigEndian.Uint32(cblc[p:]))
+			end = int(binary.BigEndian.Uint32(cblc[p+4:]))
+		case 3:
+			p := sub + 8 + idx*2
+			if p+4 > len(cblc) {
+				return g, false
+			}
+			start = int(binary.BigEndian.Uint16(cblc[p:]))
+			end = int(binary.BigEndian.Uint16(cblc[p+2:]))
+		default:
+			return g, false
+		}
+		if end <= start || base+end > len(cbdt) {
+			return g, false
+		}
+		g.data = cbdt[base+start : base+end]
+		return g, true
+	}
+	return cblcGlyph{}, false
+}
+
+// cblcRecord is an IndexSubtableRecord of a subset strike. offset is
+// relative to the start of the strike's subtables.
+type cblcRecord struct {
+	first, last ot.GlyphID
+	offset      int
+}
+
+// subsetCBLC subsets the CBLC and CBDT tables. Consecutive new glyph IDs
+// with the same image format share an IndexSubtable; the index format
+// of the source is kept unless 16-bit offsets overflow. Returns nil
+// tables if no strike retains a glyph.
+// HarfBuzz equivalent: CBLC::subset (CBDT.hh)
+func (p *Plan) subsetCBLC() (cblc, cbdt []byte, err error) {
+	cblcData, err := p.source.TableData(ot.TagCBLC)
+	if err != nil {
+		return nil, nil, err
+	}
+	cbdtData, err := p.source.TableData(ot.TagCBDT)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cblcData) < 8 || len(cbdtData) < 4 {
+		return nil, nil, ot.ErrInvalidTable
+	}
+	numSizes := int(binary.BigEndian.Uint32(cblcData[4:]))
+	if 8+numSizes*48 > len(cblcData) {
+		return nil, nil, ot.ErrInvalidTable
+	}
+	ppems := make([]int, numSizes)
+	for i := range ppems {
+		ppems[i] = int(cblcData[8+i*48+45])
+	}
+
+	type strike struct {
+		size      []byte
+		records   []cblcRecord
+		subtables []byte
+	}
+	var strikes []strike
+	cbdt = append([]byte(nil), cbdtData[:4]...)
+	for i := range numSizes {
+		base := 8 + i*48
+		if !p.input.ShouldKeepStrike(ppems[i], ppems) {
+			continue
+		}
+		arrOff := int(binary.BigEndian.Uint32(cblcData[base:]))
+		numSubtables := int(binary.BigEndian.Uint32(cblcData[base+8:]))
+		if arrOff+numSubtables*8 > len(cblcData) {
+			return nil, nil, ot.ErrInvalidTable
+		}
+
+		var s strike
+		var run cblcRecord
+		var runFormat, runImageFormat uint16
+		var runDataOffset int
+		var offsets []int
+		flush := func() {
+			if len(offsets) == 0 {
+				return
+			}
+			indexFormat := runFormat
+			if offsets[len(offsets)-1] > 0xFFFF {
+				indexFormat = 1
+			}
+			run.offset = len(s.subtables)
+			s.subtables = appendUint16s(s.subtables, indexFormat, runImageFormat)
+			s.subtables = binary.BigEndian.AppendUint32(s.subtables, uint32(runDataOffset))
+			for _, off := range offsets {
+				if indexFormat == 1 {
+					s.subtables = binary.BigEndian.AppendUint32(s.subtables, uint32(off))
+				} else {
+					s.subtables = binary.BigEndian.AppendUint16(s.subtables, uint16(off))
+				}
+			}
+			// Format 3 subtables are padded to 32-bit alignment
+			if len(s.subtables)%4 != 0 {
+				s.subtables = append(s.subtables, 0, 0)
+			}
+			s.records = append(s.records, run)
+			offsets = nil
+		}
+		for newGID := range p.numOutputGlyphs {
+			oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]
+			var g cblcGlyph
+			if ok {
+				g, ok = locateCBLCGlyph(cblcData, cbdtData, arrOff, numSubtables, oldGID)
+			}
+			if !ok || (len(offsets) > 0 && g.imageFormat != runImageFormat) {
+				flush()
+			}
+			if !ok {
+				continue
+			}
+			if len(offsets) == 0 {
+				run = cblcRecord{first: ot.GlyphID(newGID)}
+				runFormat, runImageFormat = g.indexFormat, g.imageFormat
+				runDataOffset = len(cbdt)
+				offsets = []int{0}
+			}
+			run.last = ot.GlyphID(newGID)
+			cbdt = append(cbdt, g.data...)
+			offsets = append(offsets, len(cbdt)-runDataOffset)
+		}
+		flush()
+		if len(s.records) == 0 {
+			continue
+		}
+		s.size = append([]byte(nil), cblcData[base:base+48]...)
+		strikes = append(strikes, s)
+	}
+	if len(strikes) == 0 {
+		return nil, nil, nil
+	}
+
+	cblc = make([]byte, 8, 8+len(strikes)*48)
+	copy(cblc, cblcData[:4])
+	binary.BigEndian.PutUint32(cblc[4:], uint32(len(strikes)))
+	for _, s := range strikes {
+		cblc = append(cblc, s.size...)
+	}
+	for i, s := range strikes {
+		size := cblc[8+i*48:]
+		arraySize := len(s.records) * 8
+		binary.BigEndian.PutUint32(size[0:], uint32(len(cblc)))
+		binary.BigEndian.PutUint32(size[4:], uint32(arraySize+len(s.subtables)))
+		binary.BigEndian.PutUint32(size[8:], uint32(len(s.records)))
+		binary.BigEndian.PutUint16(size[40:], s.records[0].first)
+		binary.BigEndian.PutUint16(size[42:], s.records[len(s.records)-1].last)
+		for _, r := range s.records {
+			cblc = appendUint16s(cblc, r.first, r.last)
+			cblc = binary.BigEndian.AppendUint32(cblc, uint32(arraySize+r.offset))
+		}
+		cblc = append(cblc, s.subtables...)
+	}
+	return cblc, cbdt, nil
+}
+
+// sbixDupe is the graphic type of an sbix glyph that refers to another
+// glyph of the same strike.
+var sbixDupe = ot.MakeTag('d', 'u', 'p', 'e')
+
+// subsetSbix subsets the sbix table. The glyph data of each strike is
+// copied for the new glyph IDs; 'dupe' glyphs are redirected to the new
+// ID of their target and dropped if the target is not retained. Returns
+// nil if no strike retains a glyph.
+// HarfBuzz equivalent: sbix::subset (sbix.hh)
+func (p *Plan) subsetSbix() ([]byte, error) {
+	data, err := p.source.TableData(ot.TagSbix)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, ot.ErrInvalidTable
+	}
+	numStrikes := int(binary.BigEndian.Uint32(data[4:]))
+	numGlyphs := p.source.NumGlyphs()
+	if 8+numStrikes*4 > len(data) {
+		return nil, ot.ErrInvalidTable
+	}
+	offsets := make([]int, numStrikes)
+	ppems := make([]int, numStrikes)
+	for i := range offsets {
+		offsets[i] = int(binary.BigEndian.Uint32(data[8+i*4:]))
+		if offsets[i]+4+(numGlyphs+1)*4 > len(data) {
+			return nil, ot.ErrInvalidTable
+		}
+		ppems[i] = int(binary.BigEndian.Uint16(data[offsets[i]:]))
+	}
+
+	var strikes [][]byte
+	for i, off := range offsets {
+		if !p.input.ShouldKeepStrike(ppems[i], ppems) {
+			continue
+		}
+		src := data[off:]
+		out := make([]byte, 4+(p.numOutputGlyphs+1)*4)
+		copy(out, src[:4])
+		hasGlyphs := false
+		for newGID := range p.numOutputGlyphs {
+			binary.BigEndian.PutUint32(out[4+newGID*4:], uint32(len(out)))
+			oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]
+			if !ok || int(oldGID) >= numGlyphs {
+				continue
+			}
+			start := int(binary.BigEndian.Uint32(src[4+int(oldGID)*4:]))
+			end := int(binary.BigEndian.Uint32(src[4+int(oldGID)*4+4:]))
+			if end <= start+8 || end > len(src) {
+				continue
+			}
+			glyph := src[start:end]
+			if ot.Tag(binary.BigEndian.Uint32(glyph[4:])) == sbixDupe {
+				if len(glyph) < 10 {
+					continue
+				}
+				target, ok := p.glyphMap[binary.BigEndian.Uint16(glyph[8:])]
+				if !ok {
+					continue
+				}
+				glyph = append([]byte(nil), glyph...)
+				binary.BigEndian.PutUint16(glyph[8:], target)
+			}
+			out = append(out, glyph...)
+			hasGlyphs = true
+		}
+		binary.BigEndian.PutUint32(out[4+p.numOutputGlyphs*4:], uint32(len(out)))
+		if hasGlyphs {
+			strikes = append(strikes, out)
+		}
+	}
+	if len(strikes) == 0 {
+		return nil, nil
+	}
+
+	out := make([]byte, 8+len(strikes)*4)
+	copy(out, data[:4])
+	binary.BigEndian.PutUint32(out[4:], uint32(len(strikes)))
+	for i, s := range strikes {
+		binary.BigEndian.PutUint32(out[8+i*4:], uint32(len(out)))
+		out = append(out, s...)
+	}
+	return out, nil
+}
@@ -0,0 +1,273 @@
+package subset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// buildTestFont adds the given tables to the glyf outlines and required
+// tables of Roboto.
+func buildTestFont(t *testing.T, tables map[ot.Tag][]byte) *ot.Font {
+	t.Helper()
+	font := loadFont(t, "Roboto-Regular.ttf")
+	builder := NewFontBuilder()
+	for _, tag := range []ot.Tag{ot.TagHead, ot.TagHhea, ot.TagMaxp, ot.TagHmtx, ot.TagLoca,
+		ot.TagGlyf, ot.TagCmap, ot.TagName, ot.TagPost, ot.TagOS2} {
+		builder.AddTable(tag, mustTableData(t, font, tag))
+	}
+	for tag, data := range tables {
+		builder.AddTable(tag, data)
+	}
+	data, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	out, err := ot.ParseFont(data, 0)
+	if err != nil {
+		t.Fatalf("ParseFont: %v", err)
+	}
+	return out
+}
+
+// fakePNG is the image of glyph gid in the strike with ppem.
+func fakePNG(gid, ppem int) []byte {
+	return []byte(fmt.Sprintf("png %d@%d", gid, ppem))
+}
+
+// buildCBLCFont adds CBLC and CBDT tables with a strike per ppem to
+// Roboto. Each strike has format 17 images for glyphs 1 to 3 in one
+// IndexSubtable of format 1.
+func buildCBLCFont(t *testing.T, ppems ...int) *ot.Font {
+	t.Helper()
+	cblc := binary.BigEndian.AppendUint32(nil, 0x00030000)
+	cblc = binary.BigEndian.AppendUint32(cblc, uint32(len(ppems)))
+	cbdt := binary.BigEndian.AppendUint32(nil, 0x00030000)
+	var index []byte
+	arrays := 8 + len(ppems)*48
+	for _, ppem := range ppems {
+		size := make([]byte, 48)
+		binary.BigEndian.PutUint32(size[0:], uint32(arrays+len(index)))
+		binary.BigEndian.PutUint32(size[4:], 8+8+4*4)
+		binary.BigEndian.PutUint32(size[8:], 1)
+		binary.BigEndian.PutUint16(size[40:], 1)
+		binary.BigEndian.PutUint16(size[42:], 3)
+		size[44], size[45], size[46], size[47] = byte(ppem), byte(ppem), 32, 1
+		cblc = append(cblc, size...)
+
+		index = appendUint16s(index, 1, 3, 0, 8, 1, 17)
+		index = binary.BigEndian.AppendUint32(index, uint32(len(cbdt)))
+		strike := len(cbdt)
+		index = binary.BigEndian.AppendUint32(index, 0)
+		for gid := 1; gid <= 3; gid++ {
+			png := fakePNG(gid, ppem)
+			cbdt = append(cbdt, byte(ppem), byte(ppem), 0, byte(ppem), byte(ppem))
+			cbdt = append(binary.BigEndian.AppendUint32(cbdt, uint32(len(png))), png...)
+			index = binary.BigEndian.AppendUint32(index, uint32(len(cbdt)-strike))
+		}
+	}
+	return buildTestFont(t, map[ot.Tag][]byte{ot.TagCBLC: append(cblc, index...), ot.TagCBDT: cbdt})
+}
+
+// cblcStrikePPEMs returns the ppemY of the strikes of a CBLC table.
+func cblcStrikePPEMs(data []byte) []int {
+	var ppems []int
+	for i := range int(binary.BigEndian.Uint32(data[4:])) {
+		ppems = append(ppems, int(data[8+i*48+45]))
+	}
+	return ppems
+}
+
+func TestSubsetCBLC(t *testing.T) {
+	for _, name := range []string{"NotoColorEmoji.subset.ttf", "NotoColorEmoji.subset.index_format3.ttf"} {
+		font := loadFont(t, name)
+		srcCBLC, err := ot.ParseCBLC(mustTableData(t, font, ot.TagCBLC))
+		if err != nil {
+			t.Fatalf("%s: ParseCBLC: %v", name, err)
+		}
+		srcCBDT := mustTableData(t, font, ot.TagCBDT)
+		srcIndexFormat := mustTableData(t, font, ot.TagCBLC)[8+8+48]
+
+		for _, flags := range []Flags{0, FlagRetainGIDs} {
+			input := NewInput()
+			input.AddGlyphs(2, 4)
+			input.Flags = flags
+			plan, err := CreatePlan(font, input)
+			if err != nil {
+				t.Fatalf("CreatePlan: %v", err)
+			}
+			result, err := plan.Execute()
+			if err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			subFont, err := ot.ParseFont(result, 0)
+			if err != nil {
+				t.Fatalf("Failed to parse subset: %v", err)
+			}
+			cblcData := mustTableData(t, subFont, ot.TagCBLC)
+			cblc, err := ot.ParseCBLC(cblcData)
+			if err != nil {
+				t.Fatalf("%s flags %#x: ParseCBLC: %v", name, flags, err)
+			}
+			cbdt := mustTableData(t, subFont, ot.TagCBDT)
+			if len(cbdt) >= len(srcCBDT) {
+				t.Errorf("%s flags %#x: CBDT has %d bytes, source %d", name, flags, len(cbdt), len(srcCBDT))
+			}
+			if got := cblcData[8+8+48]; got != srcIndexFormat {
+				t.Errorf("%s flags %#x: index format %d, want %d", name, flags, got, srcIndexFormat)
+			}
+
+			for newGID := range plan.NumOutputGlyphs() {
+				got := cblc.GlyphPNG(ot.GlyphID(newGID), 0, cbdt)
+				oldGID, ok := plan.OldGlyph(ot.GlyphID(newGID))
+				want := srcCBLC.GlyphPNG(oldGID, 0, srcCBDT)
+				if !ok || want == nil {
+					if got != nil {
+						t.Errorf("%s flags %#x: glyph %d has a bitmap", name, flags, newGID)
+					}
+					continue
+				}
+				if got == nil || !bytes.Equal(got.PNG, want.PNG) || got.Width != want.Width ||
+					got.XOffset != want.XOffset || got.YOffset != want.YOffset {
+					t.Errorf("%s flags %#x: bitmap of glyph %d (was %d) differs", name, flags, newGID, oldGID)
+				}
+			}
+		}
+	}
+}
+
+func TestSubsetCBLCStrikes(t *testing.T) {
+	font := buildCBLCFont(t, 32, 64)
+	tests := []struct {
+		ppem  int
+		ppems []int
+	}{
+		{0, []int{32, 64}},
+		{20, []int{32}},
+		{32, []int{32}},
+		{40, []int{64}},
+		{100, []int{64}},
+	}
+	for _, tt := range tests {
+		input := NewInput()
+		input.AddGlyphs(1, 3)
+		input.Flags = FlagNoLayoutClosure
+		input.KeepStrikeNearest(tt.ppem)
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset: %v", err)
+		}
+		cblcData := mustTableData(t, subFont, ot.TagCBLC)
+		if got := cblcStrikePPEMs(cblcData); fmt.Sprint(got) != fmt.Sprint(tt.ppems) {
+			t.Errorf("ppem %d: strikes %v, want %v", tt.ppem, got, tt.ppems)
+		}
+		cblc, err := ot.ParseCBLC(cblcData)
+		if err != nil {
+			t.Fatalf("ppem %d: ParseCBLC: %v", tt.ppem, err)
+		}
+		cbdt := mustTableData(t, subFont, ot.TagCBDT)
+		for _, ppem := range tt.ppems {
+			for oldGID := range plan.GlyphSet() {
+				newGID, _ := plan.MapGlyph(oldGID)
+				got := cblc.GlyphPNG(newGID, ppem, cbdt)
+				if oldGID != 1 && oldGID != 3 {
+					if got != nil {
+						t.Errorf("ppem %d: glyph %d has a bitmap", ppem, oldGID)
+					}
+					continue
+				}
+				if got == nil || !bytes.Equal(got.PNG, fakePNG(int(oldGID), ppem)) {
+					t.Errorf("ppem %d: bitmap of glyph %d is %v", ppem, oldGID, got)
+				}
+			}
+		}
+	}
+}
+
+func TestSubsetSbix(t *testing.T) {
+	// Glyph 1 is an image, glyph 2 repeats it and glyph 3 repeats the
+	// dropped glyph 4.
+	font := loadFont(t, "Roboto-Regular.ttf")
+	numGlyphs := font.NumGlyphs()
+	sbix := appendUint16s(nil, 1, 1)
+	sbix = binary.BigEndian.AppendUint32(sbix, 2)
+	var strikes []byte
+	for _, ppem := range []int{20, 40} {
+		sbix = binary.BigEndian.AppendUint32(sbix, uint32(16+len(strikes)))
+		strike := appendUint16s(nil, uint16(ppem), 72)
+		var glyphs []byte
+		for gid := range numGlyphs + 1 {
+			strike = binary.BigEndian.AppendUint32(strike, uint32(4+(numGlyphs+1)*4+len(glyphs)))
+			switch gid {
+			case 1, 4:
+				glyphs = appendUint16s(glyphs, 0, 0)
+				glyphs = append(append(glyphs, "png "...), fakePNG(gid, ppem)...)
+			case 2:
+				glyphs = append(append(appendUint16s(glyphs, 0, 0), "dupe"...), 0, 1)
+			case 3:
+				glyphs = append(append(appendUint16s(glyphs, 0, 0), "dupe"...), 0, 4)
+			}
+		}
+		strikes = append(strikes, append(strike, glyphs...)...)
+	}
+	font = buildTestFont(t, map[ot.Tag][]byte{ot.TagSbix: append(sbix, strikes...)})
+
+	for _, ppem := range []int{0, 30} {
+		input := NewInput()
+		input.AddGlyphs(1, 2, 3)
+		input.Flags = FlagNoLayoutClosure
+		input.KeepStrikeNearest(ppem)
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset: %v", err)
+		}
+		subSbix, err := ot.ParseSbix(mustTableData(t, subFont, ot.TagSbix), subFont.NumGlyphs())
+		if err != nil {
+			t.Fatalf("ParseSbix: %v", err)
+		}
+		want := []int{20, 40}
+		if ppem != 0 {
+			want = []int{40}
+		}
+		if len(subSbix.Strikes) != len(want) {
+			t.Fatalf("ppem %d: %d strikes, want %d", ppem, len(subSbix.Strikes), len(want))
+		}
+		for i, strikePPEM := range want {
+			if got := int(subSbix.Strikes[i].PPEM); got != strikePPEM {
+				t.Errorf("ppem %d: strike %d has ppem %d, want %d", ppem, i, got, strikePPEM)
+			}
+			for oldGID, wantGID := range map[ot.GlyphID]int{1: 1, 2: 1, 3: -1} {
+				newGID, _ := plan.MapGlyph(oldGID)
+				blob := subSbix.GlyphBlob(newGID, strikePPEM)
+				if wantGID < 0 {
+					if blob != nil {
+						t.Errorf("ppem %d: glyph %d has an image", ppem, oldGID)
+					}
+					continue
+				}
+				if blob == nil || !bytes.Equal(blob.Data, fakePNG(wantGID, strikePPEM)) {
+					t.Errorf("ppem %d: image of glyph %d at %d is %v", ppem, oldGID, strikePPEM, blob)
+				}
+			}
+		}
+	}
+}
@@ -512,19 +512,22 @@ func (p *Plan) subsetCFF() ([]byte, error) {
 	sidmap := newSIDRemap()
 
 	// 2. Collect SIDs from TopDict first (like HarfBuzz: collect_sids_in_dicts)
-	newTopDictSIDs := topDictSIDs{
-		Version:    sidmap.add(cff.TopDict.Version, cff.Strings),
-		Notice:     sidmap.add(cff.TopDict.Notice, cff.Strings),
-		FullName:   sidmap.add(cff.TopDict.FullName, cff.Strings),
-		FamilyName: sidmap.add(cff.TopDict.FamilyName, cff.Strings),
-		Weight:     sidmap.add(cff.TopDict.Weight, cff.Strings),
+	var newTopDictSIDs topDictSIDs
+	if cff.IsCID {
+		newTopDictSIDs.Registry = sidmap.add(cff.TopDict.ROS[0], cff.Strings)
+		newTopDictSIDs.Ordering = sidmap.add(cff.TopDict.ROS[1], cff.Strings)
 	}
+	newTopDictSIDs.Version = sidmap.add(cff.TopDict.Version, cff.Strings)
+	newTopDictSIDs.Notice = sidmap.add(cff.TopDict.Notice, cff.Strings)
+	newTopDictSIDs.FullName = sidmap.add(cff.TopDict.FullName, cff.Strings)
+	newTopDictSIDs.FamilyName = sidmap.add(cff.TopDict.FamilyName, cff.Strings)
+	newTopDictSIDs.Weight = sidmap.add(cff.TopDict.Weight, cff.Strings)
 
 	// 3. Collect CharStrings for kept glyphs
 	// Like HarfBuzz: only glyphs in glyphSet get their original CharString,
 	// all other slots (padding for FlagRetainGIDs) get just endchar
 	usedCharStrings := make([][]byte, p.numOutputGlyphs)
-	subsetCharStrings := make([][]byte, 0, len(p.glyphSet))
+	inSubset := make([]bool, p.numOutputGlyphs)
 
 	for newGID := 0; newGID < p.numOutputGlyphs; newGID++ {
 		oldGID, exists := p.reverseMap[ot.GlyphID(newGID)]
@@ -535,16 +538,43 @@ func (p *Plan) subsetCFF() ([]byte, error) {
 		// Only include actual CharString if glyph is in the subset
 		if p.glyphSet[oldGID] && int(oldGID) < len(cff.CharStrings) {
 			usedCharStrings[newGID] = cff.CharStrings[oldGID]
-			subsetCharStrings = append(subsetCharStrings, cff.CharStrings[oldGID])
+			inSubset[newGID] = true
 		} else {
 			// Padding slot or out-of-range: just endchar (like HarfBuzz)
 			usedCharStrings[newGID] = []byte{14} // endchar
 		}
 	}
 
+	if cff.IsCID {
+		return p.subsetCIDCFF(usedCharStrings, inSubset, sidmap, newTopDictSIDs)
+	}
+
+	globalSubrs, localSubrs := cff.GlobalSubrs, cff.LocalSubrs
+	if p.input.Flags&(FlagDesubroutinize|FlagSubroutinize) != 0 {
+		for i, cs := range usedCharStrings {
+			usedCharStrings[i] = flattenCharString(cs, globalSubrs, localSubrs)
+		}
+		globalSubrs, localSubrs = nil, nil
+	}
+	if p.input.Flags&FlagNoHinting != 0 {
+		usedCharStrings, globalSubrs, localSubrs = dropCharStringHints(usedCharStrings, globalSubrs, localSubrs)
+		unhinted := *cff
+		unhinted.PrivateDict = dropPrivateDictHints(cff.PrivateDict)
+		cff = &unhinted
+	}
+	if p.input.Flags&FlagSubroutinize != 0 {
+		usedCharStrings, globalSubrs, localSubrs = subroutinizeCharStrings(usedCharStrings, true)
+	}
+	subsetCharStrings := make([][]byte, 0, len(p.glyphSet))
+	for newGID, cs := range usedCharStrings {
+		if inSubset[newGID] {
+			subsetCharStrings = append(subsetCharStrings, cs)
+		}
+	}
+
 	// 4. Collect subroutine closure ONLY from glyphs actually in the subset
 	// (like HarfBuzz's subr_subsetter_t::collect_subrs)
-	globalClosure, localClosure := collectSubrClosure(subsetCharStrings, cff.GlobalSubrs, cff.LocalSubrs)
+	globalClosure, localClosure := collectSubrClosure(subsetCharStrings, globalSubrs, localSubrs)
 
 	// 5. Create subroutine remaps (like HarfBuzz's subr_remap_t)
 	globalRemap := newSubrRemap()
@@ -553,19 +583,19 @@ func (p *Plan) subsetCFF() ([]byte, error) {
 	localRemap.create(localClosure)
 
 	// Calculate old biases
-	oldGlobalBias := calcSubrBias(len(cff.GlobalSubrs))
-	oldLocalBias := calcSubrBias(len(cff.LocalSubrs))
+	oldGlobalBias := calcSubrBias(len(globalSubrs))
+	oldLocalBias := calcSubrBias(len(localSubrs))
 
 	// 6. Remap CharStrings with new subroutine numbers
 	// Pre-compute total hint count per charstring (including subroutines) for correct hintmask byte skipping
 	for i := range usedCharStrings {
-		hc := computeTotalHintCount(usedCharStrings[i], cff.GlobalSubrs, cff.LocalSubrs, oldGlobalBias, oldLocalBias)
+		hc := computeTotalHintCount(usedCharStrings[i], globalSubrs, localSubrs, oldGlobalBias, oldLocalBias)
 		usedCharStrings[i] = remapCharStringSubrs(usedCharStrings[i], globalRemap, localRemap, oldGlobalBias, oldLocalBias, hc)
 	}
 
 	// 7. Build new subroutine arrays (only used ones, in order)
-	newGlobalSubrs := extractUsedSubrs(cff.GlobalSubrs, globalClosure, globalRemap, localRemap, cff.GlobalSubrs, cff.LocalSubrs, oldGlobalBias, oldLocalBias)
-	newLocalSubrs := extractUsedSubrs(cff.LocalSubrs, localClosure, globalRemap, localRemap, cff.GlobalSubrs, cff.LocalSubrs, oldGlobalBias, oldLocalBias)
+	newGlobalSubrs := extractUsedSubrs(globalSubrs, globalClosure, globalRemap, localRemap, globalSubrs, localSubrs, oldGlobalBias, oldLocalBias)
+	newLocalSubrs := extractUsedSubrs(localSubrs, localClosure, globalRemap, localRemap, globalSubrs, localSubrs, oldGlobalBias, oldLocalBias)
 
 	// 8. Build new Charset with remapped SIDs
 	newCharset := buildCFFCharsetWithRemap(cff.Charset, p.reverseMap, p.numOutputGlyphs, sidmap, cff.Strings)
@@ -669,6 +699,7 @@ type charsetRange struct {
 
 // buildCFFCharsetWithRemap builds an optimized charset with SID remapping (like HarfBuzz).
 // Automatically chooses Format 0, 1, or 2 based on which is smallest.
+// With a nil sidmap the values are kept as they are (CIDs of a CID font).
 func buildCFFCharsetWithRemap(origCharset []ot.GlyphID, reverseMap map[ot.GlyphID]ot.GlyphID, numGlyphs int, sidmap *sidRemap, origStrings []string) []byte {
 	if numGlyphs <= 1 {
 		return []byte{0} // Format 0, only .notdef
@@ -684,8 +715,10 @@ func buildCFFCharsetWithRemap(origCharset []ot.GlyphID, reverseMap map[ot.GlyphI
 
 		var sid int
 		if int(oldGID) < len(origCharset) {
-			origSID := int(origCharset[oldGID])
-			sid = sidmap.add(origSID, origStrings)
+			sid = int(origCharset[oldGID])
+			if sidmap != nil {
+				sid = sidmap.add(sid, origStrings)
+			}
 		} else {
 			sid = newGID
 		}
@@ -762,6 +795,8 @@ func buildCharsetFormat2(ranges []charsetRange) []byte {
 
 // topDictSIDs holds the remapped SIDs for TopDict fields.
 type topDictSIDs struct {
+	Registry   int // CID fonts
+	Ordering   int // CID fonts
 	Version    int
 	Notice     int
 	FullName   int
@@ -797,24 +832,9 @@ func serializeCFFWithSIDMap(original *ot.CFF, charStrings [][]byte,
 	charStringsINDEX := buildINDEX(charStrings)
 
 	// Private DICT
-	var privateDict bytes.Buffer
-	writePrivateDict(&privateDict, &original.PrivateDict, false, 0)
-
-	// Local Subrs offset calculation
 	localSubrsINDEX := buildINDEX(localSubrs)
-	subrsOperatorSize := 0
-	if len(localSubrs) > 0 {
-		currentLen := privateDict.Len()
-		estimatedOffset := currentLen + 3
-		encodedSize := len(encodeCFFInt(estimatedOffset)) + 1
-		actualOffset := currentLen + encodedSize
-		if actualOffset != estimatedOffset {
-			encodedSize = len(encodeCFFInt(actualOffset)) + 1
-		}
-		subrsOperatorSize = encodedSize
-	}
-
-	privateDictSize := privateDict.Len() + subrsOperatorSize
+	privateDict := buildPrivateDict(&original.PrivateDict, len(localSubrs) > 0)
+	privateDictSize := len(privateDict)
 
 	// Build Top DICT with remapped SIDs (like HarfBuzz)
 	topDictData := buildTopDictWithSIDs(original, topSIDs, 0, 0, privateDictSize, 0)
@@ -842,9 +862,11 @@ func serializeCFFWithSIDMap(original *ot.CFF, charStrings [][]byte,
 	topDictData = buildTopDictWithSIDs(original, topSIDs, charsetOffset, charStringsOffset, privateDictSize, privateDictOffset)
 	topDictINDEX := buildINDEX([][]byte{topDictData})
 
-	// Recalculate if size changed
-	if len(topDictINDEX) != topDictINDEXSize {
+	// Recalculate until the size is stable; the new offsets may need a
+	// longer encoding themselves.
+	for len(topDictINDEX) != topDictINDEXSize {
 		sizeDiff := len(topDictINDEX) - topDictINDEXSize
+		topDictINDEXSize = len(topDictINDEX)
 
 		charsetOffset += sizeDiff
 		charStringsOffset += sizeDiff
@@ -880,28 +902,8 @@ func serializeCFFWithSIDMap(original *ot.CFF, charStrings [][]byte,
 	// CharStrings INDEX
 	buf.Write(charStringsINDEX)
 
-	// Private DICT - write with correct Subrs offset
-	privateDict.Reset()
-	subrsOffset := 0
-	if len(localSubrs) > 0 {
-		writePrivateDict(&privateDict, &original.PrivateDict, false, 0)
-		baseSize := privateDict.Len()
-
-		estimatedOffset := baseSize + 3
-		encodedSize := len(encodeCFFInt(estimatedOffset)) + 1
-		actualOffset := baseSize + encodedSize
-		if actualOffset != estimatedOffset {
-			encodedSize = len(encodeCFFInt(actualOffset)) + 1
-			actualOffset = baseSize + encodedSize
-		}
-		subrsOffset = actualOffset
-
-		privateDict.Reset()
-		writePrivateDict(&privateDict, &original.PrivateDict, true, subrsOffset)
-	} else {
-		writePrivateDict(&privateDict, &original.PrivateDict, false, 0)
-	}
-	buf.Write(privateDict.Bytes())
+	// Private DICT - with the Subrs offset
+	buf.Write(privateDict)
 
 	// Local Subrs INDEX
 	if len(localSubrs) > 0 {
@@ -915,22 +917,7 @@ func serializeCFFWithSIDMap(original *ot.CFF, charStrings [][]byte,
 func buildTopDictWithSIDs(original *ot.CFF, sids topDictSIDs, charsetOff, charStringsOff, privateSize, privateOff int) []byte {
 	var buf bytes.Buffer
 
-	// Write SID entries (like HarfBuzz's cff1_top_dict_op_serializer_t)
-	if sids.Version != 0 {
-		writeDictInt(&buf, sids.Version, 0) // version
-	}
-	if sids.Notice != 0 {
-		writeDictInt(&buf, sids.Notice, 1) // Notice
-	}
-	if sids.FullName != 0 {
-		writeDictInt(&buf, sids.FullName, 2) // FullName
-	}
-	if sids.FamilyName != 0 {
-		writeDictInt(&buf, sids.FamilyName, 3) // FamilyName
-	}
-	if sids.Weight != 0 {
-		writeDictInt(&buf, sids.Weight, 4) // Weight
-	}
+	writeTopDictSIDs(&buf, sids)
 
 	// FontBBox (operator 5) - REQUIRED
 	writeIntArray(&buf, original.TopDict.FontBBox[:], 5)
@@ -949,6 +936,26 @@ func buildTopDictWithSIDs(original *ot.CFF, sids topDictSIDs, charsetOff, charSt
 	return buf.Bytes()
 }
 
+// writeTopDictSIDs writes the SID entries of a Top DICT.
+// (like HarfBuzz's cff1_top_dict_op_serializer_t)
+func writeTopDictSIDs(buf *bytes.Buffer, sids topDictSIDs) {
+	if sids.Version != 0 {
+		writeDictInt(buf, sids.Version, 0) // version
+	}
+	if sids.Notice != 0 {
+		writeDictInt(buf, sids.Notice, 1) // Notice
+	}
+	if sids.FullName != 0 {
+		writeDictInt(buf, sids.FullName, 2) // FullName
+	}
+	if sids.FamilyName != 0 {
+		writeDictInt(buf, sids.FamilyName, 3) // FamilyName
+	}
+	if sids.Weight != 0 {
+		writeDictInt(buf, sids.Weight, 4) // Weight
+	}
+}
+
 // buildINDEX creates a CFF INDEX structure.
 func buildINDEX(data [][]byte) []byte {
 	count := len(data)
@@ -1103,6 +1110,30 @@ func calcSubrBias(count int) int {
 	return 32768
 }
 
+// buildPrivateDict returns the Private DICT, with the Subrs offset
+// pointing right behind it if it has Local Subrs.
+func buildPrivateDict(pd *ot.PrivateDict, hasSubrs bool) []byte {
+	var buf bytes.Buffer
+	writePrivateDict(&buf, pd, false, 0)
+	if !hasSubrs {
+		return buf.Bytes()
+	}
+
+	// The offset includes the Subrs operator itself
+	baseSize := buf.Len()
+	estimatedOffset := baseSize + 3
+	encodedSize := len(encodeCFFInt(estimatedOffset)) + 1
+	actualOffset := baseSize + encodedSize
+	if actualOffset != estimatedOffset {
+		encodedSize = len(encodeCFFInt(actualOffset)) + 1
+		actualOffset = baseSize + encodedSize
+	}
+
+	buf.Reset()
+	writePrivateDict(&buf, pd, true, actualOffset)
+	return buf.Bytes()
+}
+
 // writePrivateDict writes all Private DICT fields like HarfBuzz does.
 // This ensures maximum compatibility with PDF renderers.
 func writePrivateDict(buf *bytes.Buffer, pd *ot.PrivateDict, includeSubrs bool, subrsOffset int) {
@@ -0,0 +1,327 @@
+package subset
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// CID-keyed CFF subsetting.
+//
+// A CID font has no Private DICT of its own: every glyph belongs to a
+// Font DICT (selected by FDSelect) with its own Private DICT and Local
+// Subrs. The subset keeps the Font DICTs of the retained glyphs, renumbers
+// them and subsets the Local Subrs of each one separately.
+// HarfBuzz equivalent: cff1_subset_plan (hb-subset-cff1.cc) and
+// hb_plan_subset_cff_fdselect (hb-subset-cff-common.cc)
+
+// cidFontDict is a retained Font DICT of a CID font.
+type cidFontDict struct {
+	fontName    int // remapped SID
+	privateDict ot.PrivateDict
+	localSubrs  [][]byte
+}
+
+// subsetCIDCFF subsets a CID-keyed CFF table. charStrings holds the
+// charstrings of the output glyphs and inSubset marks those that are
+// not padding.
+func (p *Plan) subsetCIDCFF(charStrings [][]byte, inSubset []bool, sidmap *sidRemap, topSIDs topDictSIDs) ([]byte, error) {
+	cff := p.cff
+
+	// 1. Font DICT of every output glyph, and the used Font DICTs
+	oldFDs := make([]int, len(charStrings))
+	usedFD := make([]bool, len(cff.FDArray))
+	for newGID := range charStrings {
+		oldGID, exists := p.reverseMap[ot.GlyphID(newGID)]
+		if !exists {
+			oldGID = ot.GlyphID(newGID) // FlagRetainGIDs
+		}
+		oldFDs[newGID] = cff.FDIndex(oldGID)
+		if inSubset[newGID] {
+			usedFD[oldFDs[newGID]] = true
+		}
+	}
+
+	// 2. Renumber the used Font DICTs in their original order
+	fdMap := make(map[int]int)
+	var keptFDs []int
+	for fd, used := range usedFD {
+		if used {
+			fdMap[fd] = len(keptFDs)
+			keptFDs = append(keptFDs, fd)
+		}
+	}
+	if len(keptFDs) == 0 {
+		// Keep a Font DICT for the padding glyphs
+		fdMap[0] = 0
+		keptFDs = []int{0}
+	}
+	fdSelect := make([]int, len(charStrings))
+	for newGID, fd := range oldFDs {
+		newFD, ok := fdMap[fd]
+		if !ok && newGID > 0 {
+			// Padding glyph of a dropped Font DICT: continue the
+			// previous range
+			newFD = fdSelect[newGID-1]
+		}
+		fdSelect[newGID] = newFD
+	}
+
+	fontDicts := make([]cidFontDict, len(keptFDs))
+	for i, fd := range keptFDs {
+		fontDicts[i] = cidFontDict{
+			privateDict: cff.FDArray[fd].PrivateDict,
+			localSubrs:  cff.FDArray[fd].LocalSubrs,
+		}
+	}
+
+	// 3. Charstring transformations. Hints are dropped with the Local
+	// Subrs of each glyph's Font DICT, keeping the subroutines; new
+	// subroutines are all global.
+	globalSubrs := cff.GlobalSubrs
+	flags := p.input.Flags
+	if flags&(FlagDesubroutinize|FlagSubroutinize) != 0 {
+		for i, cs := range charStrings {
+			charStrings[i] = flattenCharString(cs, globalSubrs, fontDicts[fdSelect[i]].localSubrs)
+		}
+		globalSubrs = nil
+		for i := range fontDicts {
+			fontDicts[i].localSubrs = nil
+		}
+	} else if len(fontDicts) > 1 {
+		// Local calls in global subroutines are remapped for the first
+		// Font DICT (step 7): glyphs of the others that reach one are
+		// flattened.
+		for i, cs := range charStrings {
+			if fd := fdSelect[i]; fd != 0 && callsLocalFromGlobal(cs, globalSubrs, fontDicts[fd].localSubrs) {
+				charStrings[i] = flattenCharString(cs, globalSubrs, fontDicts[fd].localSubrs)
+			}
+		}
+	}
+	if flags&FlagNoHinting != 0 {
+		localSubrs := make([][][]byte, len(fontDicts))
+		for i := range fontDicts {
+			localSubrs[i] = fontDicts[i].localSubrs
+		}
+		charStrings, globalSubrs, localSubrs = dropCIDCharStringHints(charStrings, fdSelect, globalSubrs, localSubrs)
+		for i := range fontDicts {
+			fontDicts[i].localSubrs = localSubrs[i]
+			fontDicts[i].privateDict = dropPrivateDictHints(fontDicts[i].privateDict)
+		}
+	}
+	if flags&FlagSubroutinize != 0 {
+		charStrings, globalSubrs, _ = subroutinizeCharStrings(charStrings, false)
+	}
+
+	// 4. Subroutine closures: one global, one local per Font DICT
+	glyphsByFD := make([][][]byte, len(fontDicts))
+	for newGID, cs := range charStrings {
+		if inSubset[newGID] {
+			fd := fdSelect[newGID]
+			glyphsByFD[fd] = append(glyphsByFD[fd], cs)
+		}
+	}
+	globalClosure := make(map[int]bool)
+	localClosures := make([]map[int]bool, len(fontDicts))
+	for fd := range fontDicts {
+		g, l := collectSubrClosure(glyphsByFD[fd], globalSubrs, fontDicts[fd].localSubrs)
+		for n := range g {
+			globalClosure[n] = true
+		}
+		localClosures[fd] = l
+	}
+
+	// 5. Subroutine remaps
+	globalRemap := newSubrRemap()
+	globalRemap.create(globalClosure)
+	localRemaps := make([]*subrRemap, len(fontDicts))
+	for fd := range fontDicts {
+		localRemaps[fd] = newSubrRemap()
+		localRemaps[fd].create(localClosures[fd])
+	}
+	oldGlobalBias := calcSubrBias(len(globalSubrs))
+
+	// 6. Remap CharStrings with new subroutine numbers
+	for i := range charStrings {
+		localSubrs := fontDicts[fdSelect[i]].localSubrs
+		oldLocalBias := calcSubrBias(len(localSubrs))
+		hc := computeTotalHintCount(charStrings[i], globalSubrs, localSubrs, oldGlobalBias, oldLocalBias)
+		charStrings[i] = remapCharStringSubrs(charStrings[i], globalRemap, localRemaps[fdSelect[i]], oldGlobalBias, oldLocalBias, hc)
+	}
+
+	// 7. Build new subroutine arrays. Like HarfBuzz, local calls in
+	// global subroutines are remapped for the first Font DICT; only its
+	// glyphs still reach them (step 3).
+	firstLocal := fontDicts[0].localSubrs
+	newGlobalSubrs := extractUsedSubrs(globalSubrs, globalClosure, globalRemap, localRemaps[0],
+		globalSubrs, firstLocal, oldGlobalBias, calcSubrBias(len(firstLocal)))
+	for fd := range fontDicts {
+		localSubrs := fontDicts[fd].localSubrs
+		fontDicts[fd].localSubrs = extractUsedSubrs(localSubrs, localClosures[fd], globalRemap, localRemaps[fd],
+			globalSubrs, localSubrs, oldGlobalBias, calcSubrBias(len(localSubrs)))
+	}
+
+	// 8. Font names, charset (glyph ID to CID, not remapped) and FDSelect
+	for i, fd := range keptFDs {
+		fontDicts[i].fontName = sidmap.add(cff.FDArray[fd].FontName, cff.Strings)
+	}
+	charset := buildCFFCharsetWithRemap(cff.Charset, p.reverseMap, p.numOutputGlyphs, nil, nil)
+
+	// 9. Serialize
+	return serializeCIDCFF(cff, charStrings, newGlobalSubrs, charset, buildFDSelect(fdSelect), fontDicts, sidmap, topSIDs)
+}
+
+// buildFDSelect builds the smaller of FDSelect format 0 and format 3.
+func buildFDSelect(fds []int) []byte {
+	type fdRange struct{ first, fd int }
+	var ranges []fdRange
+	for gid, fd := range fds {
+		if len(ranges) == 0 || ranges[len(ranges)-1].fd != fd {
+			ranges = append(ranges, fdRange{gid, fd})
+		}
+	}
+
+	format3Size := 1 + 2 + len(ranges)*3 + 2
+	if format3Size >= 1+len(fds) {
+		buf := make([]byte, 1+len(fds))
+		buf[0] = 0 // Format 0
+		for gid, fd := range fds {
+			buf[1+gid] = byte(fd)
+		}
+		return buf
+	}
+
+	buf := make([]byte, format3Size)
+	buf[0] = 3 // Format 3
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(ranges)))
+	for i, r := range ranges {
+		binary.BigEndian.PutUint16(buf[3+i*3:], uint16(r.first))
+		buf[3+i*3+2] = byte(r.fd)
+	}
+	binary.BigEndian.PutUint16(buf[3+len(ranges)*3:], uint16(len(fds))) // sentinel
+	return buf
+}
+
+// serializeCIDCFF writes a CID-keyed CFF table. Offsets in the Top DICT
+// and the Font DICTs use the 5-byte integer encoding (like HarfBuzz), so
+// the layout can be computed before the offsets are known.
+func serializeCIDCFF(original *ot.CFF, charStrings, globalSubrs [][]byte, charset, fdSelect []byte,
+	fontDicts []cidFontDict, sidmap *sidRemap, topSIDs topDictSIDs) ([]byte, error) {
+
+	nameINDEX := buildINDEX([][]byte{[]byte(original.Name)})
+	stringData := make([][]byte, len(sidmap.strings))
+	for i, s := range sidmap.strings {
+		stringData[i] = []byte(s)
+	}
+	stringINDEX := buildINDEX(stringData)
+	globalSubrsINDEX := buildINDEX(globalSubrs)
+	charStringsINDEX := buildINDEX(charStrings)
+
+	privateDicts := make([][]byte, len(fontDicts))
+	localSubrsINDEXes := make([][]byte, len(fontDicts))
+	for i := range fontDicts {
+		privateDicts[i] = buildPrivateDict(&fontDicts[i].privateDict, len(fontDicts[i].localSubrs) > 0)
+		if len(fontDicts[i].localSubrs) > 0 {
+			localSubrsINDEXes[i] = buildINDEX(fontDicts[i].localSubrs)
+		}
+	}
+
+	// The sizes do not depend on the offsets
+	var offsets cidOffsets
+	offsets.private = make([]int, len(fontDicts))
+	topDictINDEX := buildINDEX([][]byte{buildCIDTopDict(original, topSIDs, offsets)})
+	fdArrayINDEX := buildFDArrayINDEX(fontDicts, privateDicts, offsets.private)
+
+	offset := 4 + len(nameINDEX) + len(topDictINDEX) + len(stringINDEX) + len(globalSubrsINDEX)
+	offsets.charset = offset
+	offset += len(charset)
+	offsets.fdSelect = offset
+	offset += len(fdSelect)
+	offsets.charStrings = offset
+	offset += len(charStringsINDEX)
+	offsets.fdArray = offset
+	offset += len(fdArrayINDEX)
+	for i := range fontDicts {
+		offsets.private[i] = offset
+		offset += len(privateDicts[i]) + len(localSubrsINDEXes[i])
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(offset)
+
+	// Header (like HarfBuzz: offSize = 4)
+	buf.Write([]byte{1, 0, 4, 4})
+	buf.Write(nameINDEX)
+	buf.Write(buildINDEX([][]byte{buildCIDTopDict(original, topSIDs, offsets)}))
+	buf.Write(stringINDEX)
+	buf.Write(globalSubrsINDEX)
+	buf.Write(charset)
+	buf.Write(fdSelect)
+	buf.Write(charStringsINDEX)
+	buf.Write(buildFDArrayINDEX(fontDicts, privateDicts, offsets.private))
+	for i := range fontDicts {
+		buf.Write(privateDicts[i])
+		buf.Write(localSubrsINDEXes[i])
+	}
+
+	return buf.Bytes(), nil
+}
+
+// cidOffsets holds the offsets of the structures of a CID-keyed CFF.
+type cidOffsets struct {
+	charset     int
+	fdSelect    int
+	charStrings int
+	fdArray     int
+	private     []int // per Font DICT
+}
+
+// buildCIDTopDict creates the Top DICT of a CID-keyed CFF.
+func buildCIDTopDict(original *ot.CFF, sids topDictSIDs, offsets cidOffsets) []byte {
+	var buf bytes.Buffer
+
+	// ROS must be the first operator
+	buf.Write(encodeCFFInt(sids.Registry))
+	buf.Write(encodeCFFInt(sids.Ordering))
+	writeDictInt(&buf, original.TopDict.ROS[2], 12<<8|30)
+
+	writeTopDictSIDs(&buf, sids)
+	writeIntArray(&buf, original.TopDict.FontBBox[:], 5)
+	writeDictInt(&buf, original.TopDict.CIDCount, 12<<8|34)
+
+	writeDictOffset(&buf, offsets.charset, 15)
+	writeDictOffset(&buf, offsets.fdSelect, 12<<8|37)
+	writeDictOffset(&buf, offsets.charStrings, 17)
+	writeDictOffset(&buf, offsets.fdArray, 12<<8|36)
+
+	return buf.Bytes()
+}
+
+// buildFDArrayINDEX creates the Font DICT INDEX.
+func buildFDArrayINDEX(fontDicts []cidFontDict, privateDicts [][]byte, privateOffsets []int) []byte {
+	dicts := make([][]byte, len(fontDicts))
+	for i, fd := range fontDicts {
+		var buf bytes.Buffer
+		if fd.fontName != 0 {
+			writeDictInt(&buf, fd.fontName, 12<<8|38) // FontName
+		}
+		buf.Write(encodeCFFInt(len(privateDicts[i])))
+		writeDictOffset(&buf, privateOffsets[i], 18) // Private
+		dicts[i] = buf.Bytes()
+	}
+	return buildINDEX(dicts)
+}
+
+// writeDictOffset writes an offset operand in the 5-byte integer encoding,
+// followed by an operator.
+func writeDictOffset(buf *bytes.Buffer, val int, op int) {
+	buf.WriteByte(29)
+	binary.Write(buf, binary.BigEndian, int32(val))
+	if op >= 256 {
+		buf.WriteByte(12)
+		buf.WriteByte(byte(op & 0xff))
+	} else {
+		buf.WriteByte(byte(op))
+	}
+}
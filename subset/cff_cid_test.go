@@ -0,0 +1,169 @@
+package subset
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestCIDCFFSubset(t *testing.T) {
+	tests := []struct {
+		name   string
+		font   string
+		glyphs []ot.GlyphID
+		fds    []string // font names of the kept Font DICTs
+	}{
+		{
+			// NotoSansCJK subset: four glyphs in four Font DICTs
+			name:   "drop Font DICTs",
+			font:   "4cbbc461be066fccc611dcc634af6e8cb2705537.ttf",
+			glyphs: []ot.GlyphID{2},
+			fds:    []string{"NotoSansCJK-Regular-Alphabetic", "NotoSansCJK-Regular-Generic"},
+		},
+		{
+			// Two Font DICTs with Local Subrs
+			name:   "local subroutines",
+			font:   "6991b13ce889466be6de3f66e891de2bc0f117ee.ttf",
+			glyphs: []ot.GlyphID{3, 8, 10},
+			fds:    []string{"LOCLTest-Regular-Latin", "LOCLTest-Regular-Ideographs"},
+		},
+		{
+			name:   "single Font DICT",
+			font:   "6991b13ce889466be6de3f66e891de2bc0f117ee.ttf",
+			glyphs: []ot.GlyphID{2, 5},
+			fds:    []string{"LOCLTest-Regular-Latin"},
+		},
+	}
+	flagSets := []Flags{0, FlagRetainGIDs, FlagDesubroutinize, FlagSubroutinize, FlagNoHinting}
+
+	for _, tt := range tests {
+		font := loadFont(t, tt.font)
+		cff, err := ot.ParseCFF(mustTableData(t, font, ot.TagCFF))
+		if err != nil || !cff.IsCID {
+			t.Fatalf("%s is not a CID-keyed CFF font (%v)", tt.font, err)
+		}
+		want := glyphPaths(t, font)
+		subrCount := 0 // of the subset without flags
+		for _, flags := range flagSets {
+			input := NewInput()
+			for _, gid := range tt.glyphs {
+				input.AddGlyph(gid)
+			}
+			input.Flags = flags
+			plan, _, subFont := subsetAndParse(t, font, input)
+			subCFF, err := ot.ParseCFF(mustTableData(t, subFont, ot.TagCFF))
+			if err != nil {
+				t.Fatalf("%s (flags %#x): ParseCFF: %v", tt.name, flags, err)
+			}
+
+			if !subCFF.IsCID {
+				t.Errorf("%s (flags %#x): subset is not CID-keyed", tt.name, flags)
+			}
+			if got, want := subCFF.GetString(subCFF.TopDict.ROS[0]), cff.GetString(cff.TopDict.ROS[0]); got != want {
+				t.Errorf("%s (flags %#x): Registry %q, want %q", tt.name, flags, got, want)
+			}
+			var fds []string
+			for _, fd := range subCFF.FDArray {
+				fds = append(fds, subCFF.GetString(fd.FontName))
+			}
+			if len(fds) != len(tt.fds) {
+				t.Fatalf("%s (flags %#x): Font DICTs %v, want %v", tt.name, flags, fds, tt.fds)
+			}
+			n := len(subCFF.GlobalSubrs)
+			for _, fd := range subCFF.FDArray {
+				n += len(fd.LocalSubrs)
+			}
+			switch flags {
+			case 0:
+				subrCount = n
+			case FlagNoHinting:
+				// Hints are dropped without flattening
+				if subrCount > 0 && n == 0 {
+					t.Errorf("%s (flags %#x): all %d subroutines lost", tt.name, flags, subrCount)
+				}
+			}
+			for i := range fds {
+				if fds[i] != tt.fds[i] {
+					t.Errorf("%s (flags %#x): Font DICT %d is %q, want %q", tt.name, flags, i, fds[i], tt.fds[i])
+				}
+			}
+
+			got := glyphPaths(t, subFont)
+			for newGID := range got {
+				oldGID, ok := plan.OldGlyph(ot.GlyphID(newGID))
+				if !ok || !plan.GlyphSet()[oldGID] {
+					continue
+				}
+				if got[newGID] != want[oldGID] {
+					t.Errorf("%s (flags %#x): glyph %d outline changed", tt.name, flags, oldGID)
+				}
+				wantCID, _ := cff.CIDForGlyph(oldGID)
+				if cid, _ := subCFF.CIDForGlyph(ot.GlyphID(newGID)); cid != wantCID {
+					t.Errorf("%s (flags %#x): glyph %d has CID %d, want %d", tt.name, flags, oldGID, cid, wantCID)
+				}
+				if gid, _ := subCFF.GetGlyphFromCID(wantCID); gid != ot.GlyphID(newGID) {
+					t.Errorf("%s (flags %#x): CID %d maps to glyph %d, want %d", tt.name, flags, wantCID, gid, newGID)
+				}
+				if fd := subCFF.FDArray[subCFF.FDIndex(ot.GlyphID(newGID))]; subCFF.GetString(fd.FontName) !=
+					cff.GetString(cff.FDArray[cff.FDIndex(oldGID)].FontName) {
+					t.Errorf("%s (flags %#x): glyph %d in the wrong Font DICT", tt.name, flags, oldGID)
+				}
+			}
+		}
+	}
+}
+
+func TestDropCIDCharStringHints(t *testing.T) {
+	// Both glyphs call local subroutine 0 of their Font DICT: 5 6 hstem
+	// in the first, 10 10 rmoveto in the second.
+	glyphs := [][]byte{
+		{32, 10, 149, 149, 21, 14}, // callsubr 0; 10 10 rmoveto endchar
+		{32, 10, 14},               // callsubr 0; endchar
+	}
+	localSubrs := [][][]byte{
+		{{144, 145, 1, 11}},
+		{{149, 149, 21, 11}},
+	}
+	gotGlyphs, _, gotLocal := dropCIDCharStringHints(glyphs, []int{0, 1}, nil, localSubrs)
+	for i, want := range [][]byte{{149, 149, 21, 14}, {32, 10, 14}} {
+		if !bytes.Equal(gotGlyphs[i], want) {
+			t.Errorf("glyph %d: % x, want % x", i, gotGlyphs[i], want)
+		}
+	}
+	for fd, want := range [][]byte{{11}, {149, 149, 21, 11}} {
+		if !bytes.Equal(gotLocal[fd][0], want) {
+			t.Errorf("Font DICT %d: local subroutine 0 is % x, want % x", fd, gotLocal[fd][0], want)
+		}
+	}
+}
+
+func TestCallsLocalFromGlobal(t *testing.T) {
+	glyph := []byte{32, 29, 14} // callgsubr 0; endchar
+	local := [][]byte{{11}}
+	if !callsLocalFromGlobal(glyph, [][]byte{{32, 10, 11}}, local) {
+		t.Errorf("local call in a global subroutine not found")
+	}
+	if callsLocalFromGlobal(glyph, [][]byte{{11}}, local) {
+		t.Errorf("global subroutine without local call reported")
+	}
+}
+
+func TestBuildFDSelect(t *testing.T) {
+	// Few ranges: format 3
+	fds := make([]int, 20)
+	for i := 10; i < 20; i++ {
+		fds[i] = 1
+	}
+	got := buildFDSelect(fds)
+	if got[0] != 3 || len(got) != 1+2+2*3+2 {
+		t.Errorf("FDSelect % x, want format 3 with 2 ranges", got)
+	}
+
+	// Alternating Font DICTs: format 0
+	got = buildFDSelect([]int{0, 1, 0, 1})
+	want := []byte{0, 0, 1, 0, 1}
+	if string(got) != string(want) {
+		t.Errorf("FDSelect % x, want % x", got, want)
+	}
+}
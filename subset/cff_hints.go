@@ -0,0 +1,477 @@
+package subset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// Hint removal for CFF charstrings.
+//
+// HarfBuzz equivalent: subr_subsetter_t::drop_hints_in_str and
+// cff_private_dict_op_serializer_t (hb-subset-cff-common.hh)
+//
+// Hint operators are removed together with their arguments from the
+// charstring or subroutine that contains them, so the subroutines stay
+// shared between glyphs. A glyph whose hint operators take arguments
+// pushed by another subroutine cannot be edited this way; it is
+// flattened first. So is a glyph that would delete a different range
+// from a subroutine than an earlier glyph: the length of a hintmask
+// depends on the number of stems declared before the call. Subroutines
+// left without operators are no longer called.
+
+// charStringMaxDepth is the subroutine nesting limit of Type 2
+// charstrings.
+const charStringMaxDepth = 10
+
+// csProgram identifies a charstring (kind csGlyph) or subroutine. fd
+// selects the Local Subrs of a CID font.
+type csProgram struct {
+	kind  int
+	index int
+	fd    int
+}
+
+const (
+	csGlyph = iota
+	csGlobalSubr
+	csLocalSubr
+)
+
+// csOperand is an operand on the charstring stack, with the bytes that
+// pushed it.
+type csOperand struct {
+	prog       csProgram
+	start, end int
+	value      int
+}
+
+// csCall is a subroutine call whose number is pushed by the caller.
+type csCall struct {
+	prog       csProgram
+	num        csOperand
+	start, end int // the call operator
+	target     csProgram
+}
+
+// csByteRange is a byte range of a program to delete.
+type csByteRange struct {
+	prog       csProgram
+	start, end int
+}
+
+// hintDropper collects the hint operators of the glyphs of a CFF font.
+type hintDropper struct {
+	glyphs, globalSubrs [][]byte
+	localSubrs          [][][]byte // per Font DICT
+	fds                 []int      // Font DICT of each glyph, nil for one
+	globalBias          int
+	localBias           []int
+
+	// Per glyph walk
+	fd        int
+	stack     []csOperand
+	hintCount int
+	cleared   bool // a stack clearing operator has been seen
+	invalid   bool // a truncated operand or unknown subroutine
+	localCall bool // a global subroutine calls a local one
+	deletes   []csByteRange
+	calls     []csCall
+}
+
+func newHintDropper(charStrings [][]byte, fds []int, globalSubrs [][]byte, localSubrs [][][]byte) *hintDropper {
+	d := &hintDropper{
+		glyphs:      append([][]byte(nil), charStrings...),
+		globalSubrs: globalSubrs,
+		localSubrs:  localSubrs,
+		fds:         fds,
+		globalBias:  calcSubrBias(len(globalSubrs)),
+	}
+	for _, subrs := range localSubrs {
+		d.localBias = append(d.localBias, calcSubrBias(len(subrs)))
+	}
+	return d
+}
+
+func (d *hintDropper) program(prog csProgram) []byte {
+	switch prog.kind {
+	case csGlobalSubr:
+		return d.globalSubrs[prog.index]
+	case csLocalSubr:
+		return d.localSubrs[prog.fd][prog.index]
+	}
+	return d.glyphs[prog.index]
+}
+
+// dropCharStringHints removes the hint operators (hstem, vstem, hintmask,
+// cntrmask) from the charstrings and the subroutines they call. The
+// subroutine numbering is unchanged.
+func dropCharStringHints(charStrings, globalSubrs, localSubrs [][]byte) (glyphs, gsubrs, lsubrs [][]byte) {
+	glyphs, gsubrs, fdSubrs := dropCIDCharStringHints(charStrings, nil, globalSubrs, [][][]byte{localSubrs})
+	return glyphs, gsubrs, fdSubrs[0]
+}
+
+// dropCIDCharStringHints is dropCharStringHints for a CID font, whose
+// glyphs call the Local Subrs of their Font DICT, given by fds.
+func dropCIDCharStringHints(charStrings [][]byte, fds []int, globalSubrs [][]byte, localSubrs [][][]byte) (glyphs, gsubrs [][]byte, lsubrs [][][]byte) {
+	d := newHintDropper(charStrings, fds, globalSubrs, localSubrs)
+
+	deletes := make(map[csProgram][]csByteRange)
+	ends := make(map[csByteRange]int) // subroutine ranges by start
+	var calls []csCall
+	for gid := range d.glyphs {
+		d.reset(gid)
+		_, ok := d.walk(csProgram{kind: csGlyph, index: gid}, 0)
+		if !d.invalid && (!ok || !d.consistent(ends)) {
+			// Hint arguments cross a subroutine boundary, or the
+			// subroutines would be edited differently for this glyph
+			d.glyphs[gid] = flattenCharString(d.glyphs[gid], globalSubrs, localSubrs[d.fd])
+			d.reset(gid)
+			d.walk(csProgram{kind: csGlyph, index: gid}, 0)
+		}
+		if d.invalid {
+			// Malformed glyphs are kept as they are
+			continue
+		}
+		for _, r := range d.deletes {
+			if r.prog.kind != csGlyph {
+				ends[csByteRange{prog: r.prog, start: r.start}] = r.end
+			}
+			deletes[r.prog] = append(deletes[r.prog], r)
+		}
+		calls = append(calls, d.calls...)
+	}
+
+	// Remove the calls of subroutines that lost all their operators,
+	// until no more become empty.
+	empty := func(prog csProgram) bool {
+		out := applyDeletes(d.program(prog), deletes[prog])
+		return len(out) == 0 || len(out) == 1 && out[0] == 11
+	}
+	removed := make(map[csByteRange]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, c := range calls {
+			key := csByteRange{c.prog, c.start, c.end}
+			if removed[key] || !empty(c.target) {
+				continue
+			}
+			removed[key] = true
+			deletes[c.prog] = append(deletes[c.prog], key, csByteRange{c.prog, c.num.start, c.num.end})
+			changed = true
+		}
+	}
+
+	gsubrs = make([][]byte, len(globalSubrs))
+	for i := range gsubrs {
+		gsubrs[i] = applyDeletes(globalSubrs[i], deletes[csProgram{kind: csGlobalSubr, index: i}])
+	}
+	lsubrs = make([][][]byte, len(localSubrs))
+	for fd, subrs := range localSubrs {
+		lsubrs[fd] = make([][]byte, len(subrs))
+		for i := range subrs {
+			lsubrs[fd][i] = applyDeletes(subrs[i], deletes[csProgram{csLocalSubr, i, fd}])
+		}
+	}
+	for i := range d.glyphs {
+		d.glyphs[i] = applyDeletes(d.glyphs[i], deletes[csProgram{kind: csGlyph, index: i}])
+	}
+	return d.glyphs, gsubrs, lsubrs
+}
+
+// callsLocalFromGlobal reports whether a charstring reaches a global
+// subroutine that calls a local one, whose number then depends on the
+// Font DICT of the glyph. It also reports true if the charstring cannot
+// be followed to its end.
+func callsLocalFromGlobal(charString []byte, globalSubrs, localSubrs [][]byte) bool {
+	d := newHintDropper([][]byte{charString}, nil, globalSubrs, [][][]byte{localSubrs})
+	d.reset(0)
+	_, ok := d.walk(csProgram{kind: csGlyph}, 0)
+	return d.localCall || !ok
+}
+
+// reset prepares the walk of a glyph.
+func (d *hintDropper) reset(gid int) {
+	d.fd = 0
+	if d.fds != nil {
+		d.fd = d.fds[gid]
+	}
+	d.stack, d.hintCount, d.cleared, d.invalid, d.localCall = d.stack[:0], 0, false, false, false
+	d.deletes, d.calls = nil, nil
+}
+
+// consistent reports whether the subroutine ranges deleted by the walk
+// agree with each other and with the ranges of earlier glyphs in ends.
+func (d *hintDropper) consistent(ends map[csByteRange]int) bool {
+	own := make(map[csByteRange]int)
+	for _, r := range d.deletes {
+		if r.prog.kind == csGlyph {
+			continue
+		}
+		key := csByteRange{prog: r.prog, start: r.start}
+		if end, ok := ends[key]; ok && end != r.end {
+			return false
+		}
+		if end, ok := own[key]; ok && end != r.end {
+			return false
+		}
+		own[key] = r.end
+	}
+	return true
+}
+
+// walk interprets a program, recording the hint operators to delete. It
+// returns whether the glyph ended, and false for ok if a hint operator
+// takes arguments pushed by another program or the program is invalid.
+func (d *hintDropper) walk(prog csProgram, depth int) (ended, ok bool) {
+	if depth > charStringMaxDepth {
+		d.invalid = true
+		return true, false
+	}
+	data := d.program(prog)
+	pos := 0
+	for pos < len(data) {
+		start := pos
+		value, n := readCharStringNumber(data, pos)
+		if n > 0 {
+			d.stack = append(d.stack, csOperand{prog, pos, pos + n, value})
+			pos += n
+			continue
+		}
+		if n < 0 {
+			d.invalid = true
+			return true, false
+		}
+		op := int(data[pos])
+		pos++
+		if op == 12 && pos < len(data) {
+			op = 12<<8 | int(data[pos])
+			pos++
+		}
+
+		switch op {
+		case 1, 18, 3, 23, 19, 20: // hstem, hstemhm, vstem, vstemhm, hintmask, cntrmask
+			d.hintCount += len(d.stack) / 2
+			if op == 19 || op == 20 {
+				pos += (d.hintCount + 7) / 8
+				if pos > len(data) {
+					pos = len(data)
+				}
+			}
+			args := d.stack
+			if !d.cleared && len(args)%2 == 1 {
+				// The first operand is the glyph width; it moves to the
+				// next stack clearing operator.
+				if prog.kind != csGlyph || args[0].prog != prog {
+					return false, false
+				}
+				args = args[1:]
+			}
+			for _, a := range args {
+				if a.prog != prog {
+					return false, false
+				}
+				d.deletes = append(d.deletes, csByteRange{prog, a.start, a.end})
+			}
+			d.deletes = append(d.deletes, csByteRange{prog, start, pos})
+			d.cleared = true
+			d.stack = d.stack[:0]
+		case 10, 29: // callsubr, callgsubr
+			if len(d.stack) == 0 {
+				d.invalid = true
+				return true, false
+			}
+			num := d.stack[len(d.stack)-1]
+			d.stack = d.stack[:len(d.stack)-1]
+			target := csProgram{csLocalSubr, num.value + d.localBias[d.fd], d.fd}
+			count := len(d.localSubrs[d.fd])
+			if op == 29 {
+				target = csProgram{kind: csGlobalSubr, index: num.value + d.globalBias}
+				count = len(d.globalSubrs)
+			} else if prog.kind == csGlobalSubr {
+				d.localCall = true
+			}
+			if target.index < 0 || target.index >= count {
+				d.invalid = true
+				return true, false
+			}
+			if num.prog == prog {
+				d.calls = append(d.calls, csCall{prog: prog, num: num, start: start, end: pos, target: target})
+			}
+			if ended, ok := d.walk(target, depth+1); ended || !ok {
+				return ended, ok
+			}
+		case 11: // return
+			return false, true
+		case 14: // endchar
+			return true, true
+		case 4, 21, 22: // vmoveto, rmoveto, hmoveto
+			d.cleared = true
+			d.stack = d.stack[:0]
+		default:
+			d.stack = d.stack[:0]
+		}
+	}
+	return false, true
+}
+
+// readCharStringNumber decodes the operand at pos. It returns its size,
+// 0 if pos holds an operator, or -1 if the operand is truncated. Fixed
+// 16.16 operands read as 0.
+func readCharStringNumber(data []byte, pos int) (int, int) {
+	b := data[pos]
+	switch {
+	case b >= 32 && b <= 246:
+		return int(b) - 139, 1
+	case b >= 247 && b <= 250:
+		if pos+1 >= len(data) {
+			return 0, -1
+		}
+		return (int(b)-247)*256 + int(data[pos+1]) + 108, 2
+	case b >= 251 && b <= 254:
+		if pos+1 >= len(data) {
+			return 0, -1
+		}
+		return -(int(b)-251)*256 - int(data[pos+1]) - 108, 2
+	case b == 28:
+		if pos+2 >= len(data) {
+			return 0, -1
+		}
+		return int(int16(binary.BigEndian.Uint16(data[pos+1:]))), 3
+	case b == 255:
+		if pos+4 >= len(data) {
+			return 0, -1
+		}
+		return 0, 5
+	}
+	return 0, 0
+}
+
+// applyDeletes returns data without the given byte ranges.
+func applyDeletes(data []byte, ranges []csByteRange) []byte {
+	if len(ranges) == 0 {
+		return data
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	out := make([]byte, 0, len(data))
+	pos := 0
+	for _, r := range ranges {
+		if r.start < pos {
+			continue // already deleted
+		}
+		out = append(out, data[pos:r.start]...)
+		pos = r.end
+	}
+	return append(out, data[pos:]...)
+}
+
+// flattenCharString inlines the subroutine calls of a charstring.
+// HarfBuzz equivalent: cff_flatten_t (hb-subset-cff-common.hh)
+func flattenCharString(data []byte, globalSubrs, localSubrs [][]byte) []byte {
+	f := &charStringFlattener{
+		globalSubrs: globalSubrs,
+		localSubrs:  localSubrs,
+		globalBias:  calcSubrBias(len(globalSubrs)),
+		localBias:   calcSubrBias(len(localSubrs)),
+	}
+	if !f.flatten(data, 0) {
+		// The glyph ended without endchar
+		f.out.WriteByte(14)
+	}
+	return f.out.Bytes()
+}
+
+type charStringFlattener struct {
+	globalSubrs, localSubrs [][]byte
+	globalBias, localBias   int
+
+	out       bytes.Buffer
+	stack     []int // values of the operands
+	lastStart int   // output offset of the last operand
+	hintCount int
+}
+
+// flatten appends data to the output, inlining its calls. It returns
+// whether the glyph ended.
+func (f *charStringFlattener) flatten(data []byte, depth int) bool {
+	if depth > charStringMaxDepth {
+		return true
+	}
+	pos := 0
+	for pos < len(data) {
+		start := pos
+		value, n := readCharStringNumber(data, pos)
+		if n > 0 {
+			f.lastStart = f.out.Len()
+			f.out.Write(data[pos : pos+n])
+			f.stack = append(f.stack, value)
+			pos += n
+			continue
+		}
+		if n < 0 {
+			return false
+		}
+		op := int(data[pos])
+		pos++
+		if op == 12 && pos < len(data) {
+			op = 12<<8 | int(data[pos])
+			pos++
+		}
+
+		switch op {
+		case 10, 29: // callsubr, callgsubr
+			if len(f.stack) == 0 {
+				return false
+			}
+			num := f.stack[len(f.stack)-1]
+			f.stack = f.stack[:len(f.stack)-1]
+			f.out.Truncate(f.lastStart)
+			subrs, bias := f.localSubrs, f.localBias
+			if op == 29 {
+				subrs, bias = f.globalSubrs, f.globalBias
+			}
+			if idx := num + bias; idx >= 0 && idx < len(subrs) {
+				if f.flatten(subrs[idx], depth+1) {
+					return true
+				}
+			}
+		case 11: // return
+			return false
+		case 14: // endchar
+			f.out.Write(data[start:pos])
+			return true
+		case 1, 18, 3, 23, 19, 20: // hstem, hstemhm, vstem, vstemhm, hintmask, cntrmask
+			f.hintCount += len(f.stack) / 2
+			if op == 19 || op == 20 {
+				pos += (f.hintCount + 7) / 8
+				if pos > len(data) {
+					pos = len(data)
+				}
+			}
+			f.out.Write(data[start:pos])
+			f.stack = f.stack[:0]
+		default:
+			f.out.Write(data[start:pos])
+			f.stack = f.stack[:0]
+		}
+	}
+	return false
+}
+
+// dropPrivateDictHints returns the Private DICT without its hinting
+// values (blue zones and stem widths).
+// HarfBuzz equivalent: dict_opset_t::is_hint_op (hb-cff-interp-dict-common.hh)
+func dropPrivateDictHints(pd ot.PrivateDict) ot.PrivateDict {
+	pd.BlueValues = nil
+	pd.OtherBlues = nil
+	pd.FamilyBlues = nil
+	pd.FamilyOtherBlues = nil
+	pd.StemSnapH = nil
+	pd.StemSnapV = nil
+	pd.StdHW = 0
+	pd.StdVW = 0
+	pd.BlueFuzz = 1
+	return pd
+}
@@ -0,0 +1,197 @@
+package subset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestDropCharStringHints(t *testing.T) {
+	// One-byte operands are v+139. With few subroutines the bias is 107,
+	// so subroutine i is called with i-107 (byte 32+i).
+	tests := []struct {
+		name       string
+		glyph      []byte
+		gsubrs     [][]byte
+		wantGlyph  []byte
+		wantGsubrs [][]byte
+	}{
+		{
+			name:      "width kept",
+			glyph:     []byte{189, 149, 159, 1, 139, 139, 21, 14}, // 50 10 20 hstem 0 0 rmoveto endchar
+			wantGlyph: []byte{189, 139, 139, 21, 14},
+		},
+		{
+			name: "hintmask",
+			// 10 20 hstemhm 30 40 hintmask <mask> 0 0 rmoveto endchar
+			glyph:     []byte{149, 159, 18, 169, 179, 19, 0xC0, 139, 139, 21, 14},
+			wantGlyph: []byte{139, 139, 21, 14},
+		},
+		{
+			name:       "subroutine with hints only",
+			glyph:      []byte{32, 29, 149, 149, 21, 14}, // callgsubr 0; 10 10 rmoveto endchar
+			gsubrs:     [][]byte{{144, 145, 1, 11}},      // 5 6 hstem return
+			wantGlyph:  []byte{149, 149, 21, 14},
+			wantGsubrs: [][]byte{{11}},
+		},
+		{
+			name:       "hint arguments from subroutine",
+			glyph:      []byte{32, 29, 1, 149, 149, 21, 14}, // callgsubr 0; hstem 10 10 rmoveto endchar
+			gsubrs:     [][]byte{{149, 159, 11}},            // 10 20 return
+			wantGlyph:  []byte{149, 149, 21, 14},
+			wantGsubrs: [][]byte{{149, 159, 11}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			glyphs, gsubrs, _ := dropCharStringHints([][]byte{tt.glyph}, tt.gsubrs, nil)
+			if !bytes.Equal(glyphs[0], tt.wantGlyph) {
+				t.Errorf("glyph % x, want % x", glyphs[0], tt.wantGlyph)
+			}
+			for i := range tt.wantGsubrs {
+				if !bytes.Equal(gsubrs[i], tt.wantGsubrs[i]) {
+					t.Errorf("gsubr %d: % x, want % x", i, gsubrs[i], tt.wantGsubrs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDropCharStringHintsSharedMask(t *testing.T) {
+	// The hintmask of the shared subroutine is one byte long after one
+	// stem and two bytes after nine, so the glyphs would delete different
+	// ranges from it. The second glyph is flattened instead.
+	stems := bytes.Repeat([]byte{149}, 18)
+	glyphs := [][]byte{
+		{149, 159, 18, 32, 29, 139, 139, 21, 14},            // 10 20 hstemhm callgsubr 0; 0 0 rmoveto endchar
+		append(append(stems, 18), 32, 29, 139, 139, 21, 14), // 9 stems, the same
+	}
+	gsubrs := [][]byte{{19, 0xFF, 139, 11}} // hintmask <mask> [0] return
+
+	gotGlyphs, gotGsubrs, _ := dropCharStringHints(glyphs, gsubrs, nil)
+	for i, want := range [][]byte{
+		{32, 29, 139, 139, 21, 14},
+		{139, 139, 21, 14},
+	} {
+		if !bytes.Equal(gotGlyphs[i], want) {
+			t.Errorf("glyph %d: % x, want % x", i, gotGlyphs[i], want)
+		}
+	}
+	if want := []byte{139, 11}; !bytes.Equal(gotGsubrs[0], want) {
+		t.Errorf("gsubr 0: % x, want % x", gotGsubrs[0], want)
+	}
+}
+
+// glyphPaths draws all glyphs of a font.
+func glyphPaths(t *testing.T, font *ot.Font) []string {
+	t.Helper()
+	face, err := ot.NewFace(font)
+	if err != nil {
+		t.Fatalf("NewFace: %v", err)
+	}
+	var paths []string
+	for gid := 0; gid < font.NumGlyphs(); gid++ {
+		var pen ot.SVGPathPen
+		face.DrawGlyph(ot.GlyphID(gid), &pen)
+		paths = append(paths, pen.String())
+	}
+	return paths
+}
+
+func TestCFFSubsetNoHinting(t *testing.T) {
+	font := loadFont(t, "SourceSansPro-Regular.otf")
+	input := NewInput()
+	input.AddString("Hamburgefonstiv 0123456789 ÄÖÜ&@")
+	input.Flags = FlagPassUnrecognized
+	_, hinted, hintedFont := subsetAndParse(t, font, input)
+	input.Flags |= FlagNoHinting
+	_, unhinted, subFont := subsetAndParse(t, font, input)
+	if len(unhinted) >= len(hinted) {
+		t.Errorf("unhinted subset %d bytes, hinted %d", len(unhinted), len(hinted))
+	}
+
+	cff, err := ot.ParseCFF(mustTableData(t, subFont, ot.TagCFF))
+	if err != nil {
+		t.Fatalf("ParseCFF: %v", err)
+	}
+	glyphs, gsubrs, lsubrs := dropCharStringHints(cff.CharStrings, cff.GlobalSubrs, cff.LocalSubrs)
+	for i := range glyphs {
+		if !bytes.Equal(glyphs[i], cff.CharStrings[i]) {
+			t.Errorf("glyph %d still has hints", i)
+		}
+	}
+	for i := range gsubrs {
+		if !bytes.Equal(gsubrs[i], cff.GlobalSubrs[i]) {
+			t.Errorf("global subr %d still has hints", i)
+		}
+	}
+	for i := range lsubrs {
+		if !bytes.Equal(lsubrs[i], cff.LocalSubrs[i]) {
+			t.Errorf("local subr %d still has hints", i)
+		}
+	}
+	if len(cff.PrivateDict.BlueValues) != 0 || cff.PrivateDict.StdVW != 0 {
+		t.Errorf("Private DICT keeps hinting values")
+	}
+
+	want, got := glyphPaths(t, hintedFont), glyphPaths(t, subFont)
+	for gid := range want {
+		if got[gid] != want[gid] {
+			t.Errorf("glyph %d outline changed", gid)
+		}
+	}
+}
+
+func TestGlyfSubsetNoHinting(t *testing.T) {
+	font := loadFont(t, "Roboto-Regular.ttf")
+	input := NewInput()
+	input.AddString("Hamburgefonstiv ÄÖÜé")
+	input.Flags = FlagPassUnrecognized
+	_, _, hintedFont := subsetAndParse(t, font, input)
+	input.Flags |= FlagNoHinting
+	_, _, subFont := subsetAndParse(t, font, input)
+
+	for _, tag := range []ot.Tag{ot.TagCvt, ot.TagFpgm, ot.TagPrep, ot.TagGasp} {
+		if subFont.HasTable(tag) {
+			t.Errorf("table %s kept", tag)
+		}
+	}
+	if !hintedFont.HasTable(ot.TagFpgm) {
+		t.Errorf("hinted subset lost fpgm")
+	}
+
+	glyf, err := ot.ParseGlyfFromFont(subFont)
+	if err != nil {
+		t.Fatalf("ParseGlyfFromFont: %v", err)
+	}
+	composites := 0
+	for gid := 0; gid < subFont.NumGlyphs(); gid++ {
+		data := glyf.GetGlyphBytes(ot.GlyphID(gid))
+		if len(data) < 10 {
+			continue
+		}
+		if !bytes.Equal(ot.DropGlyphInstructions(data), data) {
+			t.Errorf("glyph %d has instructions", gid)
+		}
+		if int16(binary.BigEndian.Uint16(data)) < 0 {
+			composites++
+		}
+	}
+	if composites == 0 {
+		t.Errorf("no composite glyphs in the subset")
+	}
+
+	maxp, _ := subFont.TableData(ot.TagMaxp)
+	if n := binary.BigEndian.Uint16(maxp[26:]); n != 0 {
+		t.Errorf("maxSizeOfInstructions %d, want 0", n)
+	}
+
+	want, got := glyphPaths(t, hintedFont), glyphPaths(t, subFont)
+	for gid := range want {
+		if got[gid] != want[gid] {
+			t.Errorf("glyph %d outline changed", gid)
+		}
+	}
+}
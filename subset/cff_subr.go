@@ -0,0 +1,359 @@
+package subset
+
+import (
+	"sort"
+)
+
+// CFF subroutinization.
+//
+// HarfBuzz only desubroutinizes (cff_flatten_t); the subroutinizer
+// follows the greedy approach of compreffor, the CFF compressor of
+// fontTools: repeated token sequences of the flattened charstrings are
+// found with a suffix array and become subroutines in the order of their
+// estimated savings. Subroutines are not nested. The most used ones get
+// the call numbers with the shortest encoding, in both the global and
+// the local INDEX.
+//
+// Measured on SourceSansPro-Regular (TestCFFSubsetSubroutinize), CFF
+// table bytes with the subset original subroutines, desubroutinized and
+// resubroutinized:
+//
+//	"Hello"                 701  596  596
+//	"Hamburgefonstiv ..."  5521 5322 4642
+//	pangram, both cases    5853 4776 4525
+//
+// For "Hello" no repeated sequence saves more than its calls cost, so no
+// subroutine is made and the result is the desubroutinized font.
+
+// charStringMaxStack is the Type 2 argument stack limit.
+const charStringMaxStack = 48
+
+// csTokenizer splits flattened charstrings into tokens: an operand or an
+// operator with its mask bytes.
+type csTokenizer struct {
+	ids   map[string]int32
+	bytes []string
+
+	// Barriers are tokens that never repeat, with negative ids;
+	// subroutines do not cross them.
+	barriers [][]byte
+}
+
+// tokenize appends the tokens of a charstring to seq, with the operand
+// count on the stack before each token in depth.
+func (tk *csTokenizer) tokenize(data []byte, seq []int32, depth []int) ([]int32, []int) {
+	stack, hintCount := 0, 0
+	pos := 0
+	for pos < len(data) {
+		start := pos
+		_, n := readCharStringNumber(data, pos)
+		if n < 0 {
+			n = len(data) - pos
+		}
+		isBarrier := false
+		if n > 0 {
+			pos += n
+		} else {
+			op := int(data[pos])
+			pos++
+			if op == 12 && pos < len(data) {
+				op = 12<<8 | int(data[pos])
+				pos++
+			}
+			switch op {
+			case 1, 18, 3, 23: // hstem, hstemhm, vstem, vstemhm
+				hintCount += stack / 2
+			case 19, 20: // hintmask, cntrmask
+				// The mask length depends on the stems of the glyph.
+				hintCount += stack / 2
+				pos += (hintCount + 7) / 8
+				if pos > len(data) {
+					pos = len(data)
+				}
+				isBarrier = true
+			case 14: // endchar
+				isBarrier = true
+			}
+		}
+
+		depth = append(depth, stack)
+		if isBarrier {
+			seq = append(seq, tk.newBarrier(data[start:pos]))
+		} else {
+			seq = append(seq, tk.id(string(data[start:pos])))
+		}
+		if n > 0 {
+			stack++
+		} else {
+			stack = 0
+		}
+	}
+	return seq, depth
+}
+
+func (tk *csTokenizer) id(token string) int32 {
+	if id, ok := tk.ids[token]; ok {
+		return id
+	}
+	id := int32(len(tk.bytes))
+	tk.ids[token] = id
+	tk.bytes = append(tk.bytes, token)
+	return id
+}
+
+func (tk *csTokenizer) newBarrier(token []byte) int32 {
+	tk.barriers = append(tk.barriers, token)
+	return -int32(len(tk.barriers))
+}
+
+// size returns the byte length of a token.
+func (tk *csTokenizer) size(id int32) int {
+	if id < 0 {
+		return len(tk.barriers[-id-1])
+	}
+	return len(tk.bytes[id])
+}
+
+// appendTokens appends the bytes of tokens to out.
+func (tk *csTokenizer) appendTokens(out []byte, ids []int32) []byte {
+	for _, id := range ids {
+		if id < 0 {
+			out = append(out, tk.barriers[-id-1]...)
+		} else {
+			out = append(out, tk.bytes[id]...)
+		}
+	}
+	return out
+}
+
+// subrCandidate is a repeated token sequence.
+type subrCandidate struct {
+	length    int     // tokens
+	size      int     // bytes
+	positions []int32 // start positions in the token sequence
+	savings   int
+}
+
+// subroutinizeCharStrings builds subroutines from the flattened
+// charstrings and returns the charstrings calling them. With useLocal,
+// the subroutines are distributed over the global and the local INDEX;
+// otherwise all are global.
+func subroutinizeCharStrings(charStrings [][]byte, useLocal bool) (glyphs, gsubrs, lsubrs [][]byte) {
+	tk := &csTokenizer{ids: make(map[string]int32)}
+
+	// One sequence for all glyphs, separated by barriers
+	var seq []int32
+	var depth []int
+	glyphStart := make([]int, len(charStrings)+1)
+	for i, cs := range charStrings {
+		glyphStart[i] = len(seq)
+		seq, depth = tk.tokenize(cs, seq, depth)
+		seq = append(seq, tk.newBarrier(nil))
+		depth = append(depth, 0)
+	}
+	glyphStart[len(charStrings)] = len(seq)
+
+	sizePrefix := make([]int, len(seq)+1)
+	for i, id := range seq {
+		sizePrefix[i+1] = sizePrefix[i] + tk.size(id)
+	}
+
+	candidates := findSubrCandidates(seq, sizePrefix)
+
+	// Apply the candidates greedily, skipping occurrences that overlap
+	// ones already replaced.
+	replaced := make([]bool, len(seq))
+	callAt := make(map[int]int) // position -> subroutine
+	var subrs []subrCandidate
+	for _, c := range candidates {
+		var occurrences []int32
+		end := int32(-1)
+		for _, pos := range c.positions {
+			if pos < end || depth[pos] >= charStringMaxStack-1 {
+				continue
+			}
+			free := true
+			for k := pos; k < pos+int32(c.length); k++ {
+				if replaced[k] {
+					free = false
+					break
+				}
+			}
+			if free {
+				occurrences = append(occurrences, pos)
+				end = pos + int32(c.length)
+			}
+		}
+		if subrSavings(len(occurrences), c.size, len(subrs)) <= 0 {
+			continue
+		}
+		id := len(subrs)
+		for _, pos := range occurrences {
+			for k := pos; k < pos+int32(c.length); k++ {
+				replaced[k] = true
+			}
+			callAt[int(pos)] = id
+		}
+		c.positions = occurrences
+		subrs = append(subrs, c)
+	}
+
+	// Assign the most used subroutines to the cheapest call numbers.
+	globalCount, localCount := len(subrs), 0
+	if useLocal {
+		globalCount, localCount = (len(subrs)+1)/2, len(subrs)/2
+	}
+	type slot struct {
+		local bool
+		index int
+		cost  int
+	}
+	var slots []slot
+	for i := 0; i < globalCount; i++ {
+		slots = append(slots, slot{false, i, len(encodeCharStringInt(i - calcSubrBias(globalCount)))})
+	}
+	for i := 0; i < localCount; i++ {
+		slots = append(slots, slot{true, i, len(encodeCharStringInt(i - calcSubrBias(localCount)))})
+	}
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].cost < slots[j].cost })
+	order := make([]int, len(subrs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(subrs[order[i]].positions) > len(subrs[order[j]].positions)
+	})
+	assigned := make([]slot, len(subrs))
+	gsubrs = make([][]byte, globalCount)
+	lsubrs = make([][]byte, localCount)
+	for i, id := range order {
+		s := slots[i]
+		assigned[id] = s
+		pos := int(subrs[id].positions[0])
+		body := tk.appendTokens(nil, seq[pos:pos+subrs[id].length])
+		body = append(body, 11) // return
+		if s.local {
+			lsubrs[s.index] = body
+		} else {
+			gsubrs[s.index] = body
+		}
+	}
+
+	glyphs = make([][]byte, len(charStrings))
+	for i := range charStrings {
+		var out []byte
+		end := glyphStart[i+1] - 1 // without the separator
+		for pos := glyphStart[i]; pos < end; {
+			if id, ok := callAt[pos]; ok {
+				s := assigned[id]
+				if s.local {
+					out = append(out, encodeCharStringInt(s.index-calcSubrBias(localCount))...)
+					out = append(out, 10) // callsubr
+				} else {
+					out = append(out, encodeCharStringInt(s.index-calcSubrBias(globalCount))...)
+					out = append(out, 29) // callgsubr
+				}
+				pos += subrs[id].length
+				continue
+			}
+			out = tk.appendTokens(out, seq[pos:pos+1])
+			pos++
+		}
+		glyphs[i] = out
+	}
+	if len(lsubrs) == 0 {
+		lsubrs = nil
+	}
+	if len(gsubrs) == 0 {
+		gsubrs = nil
+	}
+	return glyphs, gsubrs, lsubrs
+}
+
+// subrSavings estimates the bytes saved by a subroutine of size bytes
+// called count times, given the number of subroutines created so far.
+func subrSavings(count, size, created int) int {
+	if count < 2 {
+		return 0
+	}
+	callCost := 2 // operand and operator
+	if created >= 2*215 {
+		callCost = 3
+	}
+	// The subroutine adds return and an INDEX offset.
+	return count*(size-callCost) - (size + 1 + 2)
+}
+
+// findSubrCandidates returns the repeated token sequences with positive
+// estimated savings, best first.
+func findSubrCandidates(seq []int32, sizePrefix []int) []subrCandidate {
+	// Suffix array of the positions that start with a regular token
+	var sa []int32
+	for i, id := range seq {
+		if id >= 0 {
+			sa = append(sa, int32(i))
+		}
+	}
+	sort.Slice(sa, func(i, j int) bool {
+		a, b := sa[i], sa[j]
+		for {
+			x, y := seq[a], seq[b]
+			if x != y || x < 0 {
+				if x == y {
+					return a < b
+				}
+				return x < y
+			}
+			a++
+			b++
+		}
+	})
+	lcp := make([]int, len(sa))
+	for i := 1; i < len(sa); i++ {
+		a, b, n := sa[i-1], sa[i], 0
+		for seq[a+int32(n)] == seq[b+int32(n)] && seq[a+int32(n)] >= 0 {
+			n++
+		}
+		lcp[i] = n
+	}
+
+	// Each LCP interval is a sequence repeated at the interval's
+	// suffixes; only its longest form is considered.
+	var candidates []subrCandidate
+	type interval struct{ lcp, lb int }
+	stack := []interval{{0, 0}}
+	for i := 1; i <= len(sa); i++ {
+		l := 0
+		if i < len(sa) {
+			l = lcp[i]
+		}
+		lb := i - 1
+		for l < stack[len(stack)-1].lcp {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			positions := append([]int32(nil), sa[top.lb:i]...)
+			sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+			size := sizePrefix[positions[0]+int32(top.lcp)] - sizePrefix[positions[0]]
+			c := subrCandidate{length: top.lcp, size: size, positions: positions}
+			if c.savings = subrSavings(len(positions), size, 0); c.savings > 0 {
+				candidates = append(candidates, c)
+			}
+			lb = top.lb
+		}
+		if l > stack[len(stack)-1].lcp {
+			stack = append(stack, interval{l, lb})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.savings != b.savings {
+			return a.savings > b.savings
+		}
+		if a.length != b.length {
+			return a.length > b.length
+		}
+		return a.positions[0] < b.positions[0]
+	})
+	return candidates
+}
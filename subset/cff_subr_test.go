@@ -0,0 +1,87 @@
+package subset
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestSubroutinizeCharStrings(t *testing.T) {
+	// 10 20 rmoveto 30 40 50 60 70 80 rrcurveto endchar, twice with a
+	// different start
+	curve := []byte{169, 179, 189, 199, 209, 219, 8}
+	glyphs := [][]byte{
+		append([]byte{149, 159, 21}, append(curve, 14)...),
+		append([]byte{159, 149, 21}, append(curve, 14)...),
+		append(append([]byte{149, 159, 21}, curve...), append(curve, 14)...),
+	}
+	for _, useLocal := range []bool{false, true} {
+		out, gsubrs, lsubrs := subroutinizeCharStrings(glyphs, useLocal)
+		if len(gsubrs)+len(lsubrs) == 0 {
+			t.Fatalf("useLocal=%v: no subroutines", useLocal)
+		}
+		if !useLocal && len(lsubrs) != 0 {
+			t.Errorf("useLocal=false: %d local subroutines", len(lsubrs))
+		}
+		size, flatSize := 0, 0
+		for i := range glyphs {
+			if got := flattenCharString(out[i], gsubrs, lsubrs); !bytes.Equal(got, glyphs[i]) {
+				t.Errorf("useLocal=%v: glyph %d flattens to % x, want % x", useLocal, i, got, glyphs[i])
+			}
+			size += len(out[i])
+			flatSize += len(glyphs[i])
+		}
+		if size >= flatSize {
+			t.Errorf("useLocal=%v: charstrings %d bytes, flat %d", useLocal, size, flatSize)
+		}
+	}
+}
+
+func TestCFFSubsetSubroutinize(t *testing.T) {
+	font := loadFont(t, "SourceSansPro-Regular.otf")
+	subsetCFF := func(text string, flags Flags) (*ot.Font, *ot.CFF, int) {
+		input := NewInput()
+		input.AddString(text)
+		input.Flags = flags
+		_, _, subFont := subsetAndParse(t, font, input)
+		data := mustTableData(t, subFont, ot.TagCFF)
+		cff, err := ot.ParseCFF(data)
+		if err != nil {
+			t.Fatalf("ParseCFF: %v", err)
+		}
+		return subFont, cff, len(data)
+	}
+
+	texts := []string{
+		"Hello",
+		"Hamburgefonstiv 0123456789 ÄÖÜ&@",
+		"The quick brown fox jumps over the lazy dog. THE QUICK BROWN FOX JUMPS OVER THE LAZY DOG!",
+	}
+	for _, text := range texts {
+		refFont, _, refSize := subsetCFF(text, 0)
+		desubFont, desubCFF, desubSize := subsetCFF(text, FlagDesubroutinize)
+		subrFont, subrCFF, subrSize := subsetCFF(text, FlagSubroutinize)
+		t.Logf("%q: CFF %d bytes, desubroutinized %d, resubroutinized %d (%d global, %d local)",
+			text, refSize, desubSize, subrSize, len(subrCFF.GlobalSubrs), len(subrCFF.LocalSubrs))
+
+		if len(desubCFF.GlobalSubrs) != 0 || len(desubCFF.LocalSubrs) != 0 {
+			t.Errorf("%q: desubroutinized font has %d global and %d local subroutines",
+				text, len(desubCFF.GlobalSubrs), len(desubCFF.LocalSubrs))
+		}
+		if subrSize > desubSize || subrSize > refSize {
+			t.Errorf("%q: resubroutinized CFF %d bytes, desubroutinized %d, original subroutines %d",
+				text, subrSize, desubSize, refSize)
+		}
+
+		want := glyphPaths(t, refFont)
+		for name, f := range map[string]*ot.Font{"desubroutinized": desubFont, "resubroutinized": subrFont} {
+			got := glyphPaths(t, f)
+			for gid := range want {
+				if got[gid] != want[gid] {
+					t.Errorf("%q: %s glyph %d outline changed", text, name, gid)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package subset
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// cmapMapping is used for cmap construction
+type cmapMapping struct {
+	cp  rune
+	gid ot.GlyphID
+}
+
+// cmapEncoding is an encoding record of the output cmap.
+type cmapEncoding struct {
+	platformID uint16
+	encodingID uint16
+	subtable   []byte
+}
+
+// subsetCmap subsets the cmap table.
+//
+// Unicode fonts get a (3,1) format 4 subtable, and a (3,10) format 12
+// subtable if there are characters outside the BMP. Symbol fonts get a
+// (3,0) format 4 subtable with the codes of the original subtable. The
+// variation sequences of the retained characters go to a (0,5) format 14
+// subtable (non-default ones only if their glyph is retained), and
+// FlagMacRomanCmap adds a (1,0) subtable.
+// HarfBuzz equivalent: cmap::subset (hb-ot-cmap-table.hh)
+func (p *Plan) subsetCmap(builder *FontBuilder) error {
+	if p.cmap == nil || len(p.unicodeMap) == 0 {
+		return nil
+	}
+
+	// Collect mappings
+	var mappings []cmapMapping
+	for cp, gid := range p.unicodeMap {
+		mappings = append(mappings, cmapMapping{cp, gid})
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].cp < mappings[j].cp })
+
+	// Encoding records, sorted by platform and encoding ID
+	var encodings []cmapEncoding
+	if uvs := p.buildCmapFormat14(); uvs != nil {
+		encodings = append(encodings, cmapEncoding{0, 5, uvs})
+	}
+	if p.input.Flags&FlagMacRomanCmap != 0 {
+		encodings = append(encodings, cmapEncoding{1, 0, buildCmapMacRoman(p.macRomanMappings(mappings))})
+	}
+	if p.cmap.IsSymbol() {
+		encodings = append(encodings, cmapEncoding{3, 0, buildCmapFormat4(p.symbolMappings(mappings))})
+	} else {
+		// Format 4 holds the BMP characters, format 12 all of them
+		encodings = append(encodings, cmapEncoding{3, 1, buildCmapFormat4(mappings)})
+		if mappings[len(mappings)-1].cp > 0xFFFF {
+			encodings = append(encodings, cmapEncoding{3, 10, buildCmapFormat12(mappings)})
+		}
+	}
+
+	builder.AddTable(ot.TagCmap, buildCmapTable(encodings))
+	return nil
+}
+
+// buildCmapTable builds the cmap table from its encoding records.
+func buildCmapTable(encodings []cmapEncoding) []byte {
+	size := 4 + 8*len(encodings)
+	for _, e := range encodings {
+		size += len(e.subtable)
+	}
+	cmap := make([]byte, 4, size)
+	binary.BigEndian.PutUint16(cmap[0:], 0)                      // version
+	binary.BigEndian.PutUint16(cmap[2:], uint16(len(encodings))) // numTables
+
+	offset := 4 + 8*len(encodings)
+	for _, e := range encodings {
+		cmap = binary.BigEndian.AppendUint16(cmap, e.platformID)
+		cmap = binary.BigEndian.AppendUint16(cmap, e.encodingID)
+		cmap = binary.BigEndian.AppendUint32(cmap, uint32(offset))
+		offset += len(e.subtable)
+	}
+	for _, e := range encodings {
+		cmap = append(cmap, e.subtable...)
+	}
+	return cmap
+}
+
+// symbolMappings returns the mappings with the codes of the symbol
+// subtable (e.g. U+F041 for 'A') instead of the requested characters.
+func (p *Plan) symbolMappings(mappings []cmapMapping) []cmapMapping {
+	codes := make(map[rune]ot.GlyphID, len(mappings))
+	for _, m := range mappings {
+		codes[rune(p.cmap.SubtableCodepoint(ot.Codepoint(m.cp)))] = m.gid
+	}
+	return sortedCmapMappings(codes)
+}
+
+// macRomanMappings returns the mappings of the characters that are in
+// the Mac Roman character set, with their Mac Roman codes. Symbol fonts
+// use the low byte of their symbol codes.
+func (p *Plan) macRomanMappings(mappings []cmapMapping) []cmapMapping {
+	codes := make(map[rune]ot.GlyphID)
+	for _, m := range mappings {
+		code := rune(-1)
+		if p.cmap.IsSymbol() {
+			if c := rune(p.cmap.SubtableCodepoint(ot.Codepoint(m.cp))); c <= 0xFF || c >= 0xF000 && c <= 0xF0FF {
+				code = c & 0xFF
+			}
+		} else if m.cp < 0x80 {
+			code = m.cp
+		} else if c, ok := unicodeToMacRoman[m.cp]; ok {
+			code = rune(c)
+		}
+		if code >= 0 {
+			codes[code] = m.gid
+		}
+	}
+	return sortedCmapMappings(codes)
+}
+
+// sortedCmapMappings returns the mappings of codes sorted by code.
+func sortedCmapMappings(codes map[rune]ot.GlyphID) []cmapMapping {
+	mappings := make([]cmapMapping, 0, len(codes))
+	for cp, gid := range codes {
+		mappings = append(mappings, cmapMapping{cp, gid})
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].cp < mappings[j].cp })
+	return mappings
+}
+
+// buildCmapFormat4 builds a format 4 cmap subtable for BMP characters.
+func buildCmapFormat4(mappings []cmapMapping) []byte {
+	// Group consecutive mappings with same delta
+	type segment struct {
+		startCode uint16
+		endCode   uint16
+		delta     int16
+		idOffset  uint16
+	}
+
+	var segments []segment
+	var glyphIDs []uint16
+
+	i := 0
+	for i < len(mappings) {
+		start := mappings[i]
+		if start.cp > 0xFFFF {
+			i++
+			continue
+		}
+
+		// Try to build a segment with constant delta
+		delta := int(start.gid) - int(start.cp)
+		end := start
+
+		j := i + 1
+		for j < len(mappings) {
+			next := mappings[j]
+			if next.cp > 0xFFFF {
+				break
+			}
+			if int(next.gid)-int(next.cp) != delta || int(next.cp) != int(end.cp)+1 {
+				break
+			}
+			end = next
+			j++
+		}
+
+		segments = append(segments, segment{
+			startCode: uint16(start.cp),
+			endCode:   uint16(end.cp),
+			delta:     int16(delta),
+			idOffset:  0,
+		})
+
+		i = j
+	}
+
+	// Add terminating segment
+	segments = append(segments, segment{
+		startCode: 0xFFFF,
+		endCode:   0xFFFF,
+		delta:     1,
+		idOffset:  0,
+	})
+
+	segCount := len(segments)
+	segCountX2 := segCount * 2
+
+	// Calculate search params
+	searchRange := 2
+	entrySelector := 0
+	for searchRange*2 <= segCountX2 {
+		searchRange *= 2
+		entrySelector++
+	}
+	rangeShift := segCountX2 - searchRange
+
+	// Build format 4 subtable
+	headerLen := 16 + segCount*8 + len(glyphIDs)*2
+	subtableLen := headerLen
+
+	subtable := make([]byte, subtableLen)
+	binary.BigEndian.PutUint16(subtable[0:], 4)                      // format
+	binary.BigEndian.PutUint16(subtable[2:], uint16(subtableLen))    // length
+	binary.BigEndian.PutUint16(subtable[4:], 0)                      // language
+	binary.BigEndian.PutUint16(subtable[6:], uint16(segCountX2))     // segCountX2
+	binary.BigEndian.PutUint16(subtable[8:], uint16(searchRange))    // searchRange
+	binary.BigEndian.PutUint16(subtable[10:], uint16(entrySelector)) // entrySelector
+	binary.BigEndian.PutUint16(subtable[12:], uint16(rangeShift))    // rangeShift
+
+	// endCode array
+	off := 14
+	for _, seg := range segments {
+		binary.BigEndian.PutUint16(subtable[off:], seg.endCode)
+		off += 2
+	}
+
+	// reservedPad
+	binary.BigEndian.PutUint16(subtable[off:], 0)
+	off += 2
+
+	// startCode array
+	for _, seg := range segments {
+		binary.BigEndian.PutUint16(subtable[off:], seg.startCode)
+		off += 2
+	}
+
+	// idDelta array
+	for _, seg := range segments {
+		binary.BigEndian.PutUint16(subtable[off:], uint16(seg.delta))
+		off += 2
+	}
+
+	// idRangeOffset array
+	for _, seg := range segments {
+		binary.BigEndian.PutUint16(subtable[off:], seg.idOffset)
+		off += 2
+	}
+
+	return subtable
+}
+
+// buildCmapFormat12 builds a format 12 cmap subtable.
+func buildCmapFormat12(mappings []cmapMapping) []byte {
+	// Group consecutive mappings
+	type group struct {
+		startChar  uint32
+		endChar    uint32
+		startGlyph uint32
+	}
+
+	var groups []group
+
+	i := 0
+	for i < len(mappings) {
+		start := mappings[i]
+		startGlyph := uint32(start.gid)
+		endChar := start.cp
+
+		j := i + 1
+		for j < len(mappings) {
+			next := mappings[j]
+			if int(next.cp) != int(endChar)+1 || uint32(next.gid) != startGlyph+uint32(j-i) {
+				break
+			}
+			endChar = next.cp
+			j++
+		}
+
+		groups = append(groups, group{
+			startChar:  uint32(start.cp),
+			endChar:    uint32(endChar),
+			startGlyph: startGlyph,
+		})
+
+		i = j
+	}
+
+	// Build format 12 subtable
+	subtableLen := 16 + len(groups)*12
+
+	subtable := make([]byte, subtableLen)
+	binary.BigEndian.PutUint16(subtable[0:], 12)                   // format
+	binary.BigEndian.PutUint16(subtable[2:], 0)                    // reserved
+	binary.BigEndian.PutUint32(subtable[4:], uint32(subtableLen))  // length
+	binary.BigEndian.PutUint32(subtable[8:], 0)                    // language
+	binary.BigEndian.PutUint32(subtable[12:], uint32(len(groups))) // numGroups
+
+	off := 16
+	for _, g := range groups {
+		binary.BigEndian.PutUint32(subtable[off:], g.startChar)
+		binary.BigEndian.PutUint32(subtable[off+4:], g.endChar)
+		binary.BigEndian.PutUint32(subtable[off+8:], g.startGlyph)
+		off += 12
+	}
+
+	return subtable
+}
+
+// buildCmapFormat14 builds a format 14 subtable with the variation
+// sequences of the retained characters. It returns nil if there are none.
+func (p *Plan) buildCmapFormat14() []byte {
+	type selectorRecord struct {
+		selector   ot.Codepoint
+		defaults   []ot.Codepoint
+		nonDefault []cmapMapping
+	}
+	var records []*selectorRecord
+	for _, seq := range p.cmap.CollectVariationSequences() {
+		if _, ok := p.unicodeMap[rune(seq.Unicode)]; !ok {
+			continue
+		}
+		var newGID ot.GlyphID
+		if !seq.Default {
+			var ok bool
+			if newGID, ok = p.glyphMap[seq.Glyph]; !ok {
+				continue
+			}
+		}
+		if len(records) == 0 || records[len(records)-1].selector != seq.Selector {
+			records = append(records, &selectorRecord{selector: seq.Selector})
+		}
+		rec := records[len(records)-1]
+		if seq.Default {
+			rec.defaults = append(rec.defaults, seq.Unicode)
+		} else {
+			rec.nonDefault = append(rec.nonDefault, cmapMapping{rune(seq.Unicode), newGID})
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	// Header and variation selector records; the offsets are filled in
+	// below
+	subtable := make([]byte, 10+11*len(records))
+	binary.BigEndian.PutUint16(subtable[0:], 14)                   // format
+	binary.BigEndian.PutUint32(subtable[6:], uint32(len(records))) // numVarSelectorRecords
+	for i, rec := range records {
+		r := 10 + 11*i
+		putUint24(subtable[r:], uint32(rec.selector))
+
+		if len(rec.defaults) > 0 {
+			binary.BigEndian.PutUint32(subtable[r+3:], uint32(len(subtable))) // defaultUVSOffset
+			// Ranges of consecutive characters, up to 256 each
+			var ranges [][2]uint32 // start, additionalCount
+			for _, cp := range rec.defaults {
+				if n := len(ranges); n > 0 && ranges[n-1][0]+ranges[n-1][1]+1 == uint32(cp) && ranges[n-1][1] < 255 {
+					ranges[n-1][1]++
+				} else {
+					ranges = append(ranges, [2]uint32{uint32(cp), 0})
+				}
+			}
+			subtable = binary.BigEndian.AppendUint32(subtable, uint32(len(ranges)))
+			for _, rg := range ranges {
+				subtable = append(subtable, byte(rg[0]>>16), byte(rg[0]>>8), byte(rg[0]), byte(rg[1]))
+			}
+		}
+
+		if len(rec.nonDefault) > 0 {
+			binary.BigEndian.PutUint32(subtable[r+7:], uint32(len(subtable))) // nonDefaultUVSOffset
+			subtable = binary.BigEndian.AppendUint32(subtable, uint32(len(rec.nonDefault)))
+			for _, m := range rec.nonDefault {
+				subtable = append(subtable, byte(m.cp>>16), byte(m.cp>>8), byte(m.cp))
+				subtable = binary.BigEndian.AppendUint16(subtable, uint16(m.gid))
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(subtable[2:], uint32(len(subtable))) // length
+	return subtable
+}
+
+// putUint24 writes a 24-bit big-endian value.
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+// buildCmapMacRoman builds a (1,0) subtable: format 0 if all glyph IDs
+// fit in a byte, otherwise format 6.
+func buildCmapMacRoman(mappings []cmapMapping) []byte {
+	fitsByte := true
+	for _, m := range mappings {
+		if m.gid > 0xFF {
+			fitsByte = false
+			break
+		}
+	}
+
+	if fitsByte {
+		subtable := make([]byte, 6+256)
+		binary.BigEndian.PutUint16(subtable[0:], 0)                     // format
+		binary.BigEndian.PutUint16(subtable[2:], uint16(len(subtable))) // length
+		binary.BigEndian.PutUint16(subtable[4:], 0)                     // language
+		for _, m := range mappings {
+			subtable[6+m.cp] = byte(m.gid)
+		}
+		return subtable
+	}
+
+	firstCode := mappings[0].cp
+	entryCount := int(mappings[len(mappings)-1].cp-firstCode) + 1
+	subtable := make([]byte, 10+2*entryCount)
+	binary.BigEndian.PutUint16(subtable[0:], 6)                     // format
+	binary.BigEndian.PutUint16(subtable[2:], uint16(len(subtable))) // length
+	binary.BigEndian.PutUint16(subtable[4:], 0)                     // language
+	binary.BigEndian.PutUint16(subtable[6:], uint16(firstCode))     // firstCode
+	binary.BigEndian.PutUint16(subtable[8:], uint16(entryCount))    // entryCount
+	for _, m := range mappings {
+		binary.BigEndian.PutUint16(subtable[10+2*(m.cp-firstCode):], uint16(m.gid))
+	}
+	return subtable
+}
+
+// unicodeToMacRoman maps the characters of the upper half of the Mac
+// Roman character set to their codes.
+var unicodeToMacRoman = func() map[rune]byte {
+	upper := [128]rune{
+		0x00C4, 0x00C5, 0x00C7, 0x00C9, 0x00D1, 0x00D6, 0x00DC, 0x00E1, // 0x80
+		0x00E0, 0x00E2, 0x00E4, 0x00E3, 0x00E5, 0x00E7, 0x00E9, 0x00E8,
+		0x00EA, 0x00EB, 0x00ED, 0x00EC, 0x00EE, 0x00EF, 0x00F1, 0x00F3, // 0x90
+		0x00F2, 0x00F4, 0x00F6, 0x00F5, 0x00FA, 0x00F9, 0x00FB, 0x00FC,
+		0x2020, 0x00B0, 0x00A2, 0x00A3, 0x00A7, 0x2022, 0x00B6, 0x00DF, // 0xA0
+		0x00AE, 0x00A9, 0x2122, 0x00B4, 0x00A8, 0x2260, 0x00C6, 0x00D8,
+		0x221E, 0x00B1, 0x2264, 0x2265, 0x00A5, 0x00B5, 0x2202, 0x2211, // 0xB0
+		0x220F, 0x03C0, 0x222B, 0x00AA, 0x00BA, 0x03A9, 0x00E6, 0x00F8,
+		0x00BF, 0x00A1, 0x00AC, 0x221A, 0x0192, 0x2248, 0x2206, 0x00AB, // 0xC0
+		0x00BB, 0x2026, 0x00A0, 0x00C0, 0x00C3, 0x00D5, 0x0152, 0x0153,
+		0x2013, 0x2014, 0x201C, 0x201D, 0x2018, 0x2019, 0x00F7, 0x25CA, // 0xD0
+		0x00FF, 0x0178, 0x2044, 0x20AC, 0x2039, 0x203A, 0xFB01, 0xFB02,
+		0x2021, 0x00B7, 0x201A, 0x201E, 0x2030, 0x00C2, 0x00CA, 0x00C1, // 0xE0
+		0x00CB, 0x00C8, 0x00CD, 0x00CE, 0x00CF, 0x00CC, 0x00D3, 0x00D4,
+		0xF8FF, 0x00D2, 0x00DA, 0x00DB, 0x00D9, 0x0131, 0x02C6, 0x02DC, // 0xF0
+		0x00AF, 0x02D8, 0x02D9, 0x02DA, 0x00B8, 0x02DD, 0x02DB, 0x02C7,
+	}
+	m := make(map[rune]byte, len(upper))
+	for i, r := range upper {
+		m[r] = byte(0x80 + i)
+	}
+	return m
+}()
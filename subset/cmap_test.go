@@ -0,0 +1,206 @@
+package subset
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func mustTableData(t *testing.T, font *ot.Font, tag ot.Tag) []byte {
+	t.Helper()
+	data, err := font.TableData(tag)
+	if err != nil {
+		t.Fatalf("%s: %v", tag, err)
+	}
+	return data
+}
+
+// cmapSubtables returns the subtables of a cmap by platform and
+// encoding ID.
+func cmapSubtables(data []byte) map[[2]uint16][]byte {
+	subtables := make(map[[2]uint16][]byte)
+	numTables := int(binary.BigEndian.Uint16(data[2:]))
+	for i := 0; i < numTables; i++ {
+		r := data[4+8*i:]
+		key := [2]uint16{binary.BigEndian.Uint16(r), binary.BigEndian.Uint16(r[2:])}
+		subtables[key] = data[binary.BigEndian.Uint32(r[4:]):]
+	}
+	return subtables
+}
+
+func TestCmapSubsetFormat12(t *testing.T) {
+	// U+2642, U+1F3FB and U+1F481 with a default sequence U+2642 U+FE0F
+	font := loadFont(t, "3cf6f8ac6d647473a43a3100e7494b202b2cfafe.ttf")
+	input := NewInput()
+	input.AddUnicodes(0x2642, 0x1F3FB)
+	plan, _, subFont := subsetAndParse(t, font, input)
+	cmap, data := loadCmap(t, subFont), mustTableData(t, subFont, ot.TagCmap)
+
+	subtables := cmapSubtables(data)
+	for key, format := range map[[2]uint16]uint16{{0, 5}: 14, {3, 1}: 4, {3, 10}: 12} {
+		st, ok := subtables[key]
+		if !ok {
+			t.Errorf("no (%d,%d) subtable", key[0], key[1])
+			continue
+		}
+		if f := binary.BigEndian.Uint16(st); f != format {
+			t.Errorf("(%d,%d) subtable has format %d, want %d", key[0], key[1], f, format)
+		}
+	}
+	if len(subtables) != 3 {
+		t.Errorf("%d subtables, want 3", len(subtables))
+	}
+
+	srcCmap := loadCmap(t, font)
+	for _, cp := range []rune{0x2642, 0x1F3FB} {
+		oldGID, _ := srcCmap.Lookup(ot.Codepoint(cp))
+		want, _ := plan.MapGlyph(oldGID)
+		if got, ok := cmap.Lookup(ot.Codepoint(cp)); !ok || got != want {
+			t.Errorf("U+%04X maps to %d, want %d", cp, got, want)
+		}
+	}
+	if _, ok := cmap.Lookup(0x1F481); ok {
+		t.Errorf("U+1F481 kept")
+	}
+	want, _ := cmap.Lookup(0x2642)
+	if got, ok := cmap.LookupVariation(0x2642, 0xFE0F); !ok || got != want {
+		t.Errorf("U+2642 U+FE0F maps to %d, %v, want %d", got, ok, want)
+	}
+}
+
+func TestCmapSubsetVariationSequences(t *testing.T) {
+	// U+904D has ideographic variation sequences with the variant glyphs
+	// 8, 9, 10 and 11 and a default sequence with U+E01E8.
+	font := loadFont(t, "6991b13ce889466be6de3f66e891de2bc0f117ee.ttf")
+	input := NewInput()
+	input.AddVariationSequence(0x904D, 0xE01E6)
+	input.Flags = FlagNoLayoutClosure // 'locl' would keep all variants
+	plan, _, subFont := subsetAndParse(t, font, input)
+	cmap := loadCmap(t, subFont)
+
+	if !plan.GlyphSet()[8] || plan.GlyphSet()[9] {
+		t.Fatalf("glyph set %v, want variant glyph 8 only", plan.GlyphSet())
+	}
+	variant, _ := plan.MapGlyph(8)
+	nominal, _ := cmap.Lookup(0x904D)
+
+	tests := []struct {
+		selector ot.Codepoint
+		glyph    ot.GlyphID
+		ok       bool
+	}{
+		{0xE01E6, variant, true},
+		{0xE01E7, variant, true}, // same variant glyph
+		{0xE01EF, variant, true},
+		{0xE01E8, nominal, true}, // default sequence
+		{0xE01E5, 0, false},      // glyph 10 dropped
+		{0xE01E9, 0, false},      // glyph 9 dropped
+	}
+	for _, tt := range tests {
+		got, ok := cmap.LookupVariation(0x904D, tt.selector)
+		if ok != tt.ok || got != tt.glyph {
+			t.Errorf("U+904D U+%X maps to %d, %v, want %d, %v", tt.selector, got, ok, tt.glyph, tt.ok)
+		}
+	}
+	if n := len(cmap.CollectVariationSequences()); n != 4 {
+		t.Errorf("%d variation sequences, want 4", n)
+	}
+
+	// Without the base character, the sequences are dropped
+	input = NewInput()
+	input.AddUnicode('J')
+	_, _, subFont = subsetAndParse(t, font, input)
+	if _, ok := cmapSubtables(mustTableData(t, subFont, ot.TagCmap))[[2]uint16{0, 5}]; ok {
+		t.Errorf("format 14 subtable kept without variation sequences")
+	}
+}
+
+func TestCmapSubsetSymbol(t *testing.T) {
+	// A (3,0) subtable with the codes U+F200 to U+F2FF
+	font := loadFont(t, "TradArabicTest.ttf")
+	srcCmap := loadCmap(t, font)
+	if !srcCmap.IsSymbol() {
+		t.Fatal("font is not a symbol font")
+	}
+	input := NewInput()
+	input.AddUnicodes(0xF200, 0xF201, 0xF210)
+	plan, _, subFont := subsetAndParse(t, font, input)
+	cmap, data := loadCmap(t, subFont), mustTableData(t, subFont, ot.TagCmap)
+
+	subtables := cmapSubtables(data)
+	if _, ok := subtables[[2]uint16{3, 0}]; !ok || len(subtables) != 1 {
+		t.Fatalf("%d subtables, want only (3,0)", len(subtables))
+	}
+	if !cmap.IsSymbol() {
+		t.Errorf("subset is not a symbol font")
+	}
+	for _, cp := range []ot.Codepoint{0xF200, 0xF201, 0xF210} {
+		oldGID, ok := srcCmap.Lookup(cp)
+		if !ok {
+			t.Fatalf("U+%04X not in the font", cp)
+		}
+		want, _ := plan.MapGlyph(oldGID)
+		if got, ok := cmap.Lookup(cp); !ok || got != want {
+			t.Errorf("U+%04X maps to %d, want %d", cp, got, want)
+		}
+	}
+	if n := len(cmap.CollectMapping()); n != 3 {
+		t.Errorf("%d mappings, want 3", n)
+	}
+}
+
+func TestCmapSubsetMacRoman(t *testing.T) {
+	font := loadFont(t, "SourceSansPro-Regular.otf")
+	srcCmap := loadCmap(t, font)
+
+	for _, tt := range []struct {
+		name   string
+		flags  Flags
+		format uint16
+	}{
+		{"format 0", 0, 0},
+		{"format 6", FlagRetainGIDs, 6},
+	} {
+		input := NewInput()
+		input.AddString("AÄé€→")
+		input.Flags = tt.flags | FlagMacRomanCmap
+		plan, _, subFont := subsetAndParse(t, font, input)
+		data := mustTableData(t, subFont, ot.TagCmap)
+
+		mac, ok := cmapSubtables(data)[[2]uint16{1, 0}]
+		if !ok {
+			t.Fatalf("%s: no (1,0) subtable", tt.name)
+		}
+		if f := binary.BigEndian.Uint16(mac); f != tt.format {
+			t.Fatalf("%s: format %d, want %d", tt.name, f, tt.format)
+		}
+		lookup := func(code int) ot.GlyphID {
+			if tt.format == 0 {
+				return ot.GlyphID(mac[6+code])
+			}
+			first := int(binary.BigEndian.Uint16(mac[6:]))
+			count := int(binary.BigEndian.Uint16(mac[8:]))
+			if code < first || code >= first+count {
+				return 0
+			}
+			return ot.GlyphID(binary.BigEndian.Uint16(mac[10+2*(code-first):]))
+		}
+
+		for cp, code := range map[rune]int{'A': 'A', 'Ä': 0x80, 'é': 0x8E, '€': 0xDB} {
+			oldGID, _ := srcCmap.Lookup(ot.Codepoint(cp))
+			want, _ := plan.MapGlyph(oldGID)
+			if got := lookup(code); got != want || want == 0 {
+				t.Errorf("%s: Mac Roman %#x (%c) maps to %d, want %d", tt.name, code, cp, got, want)
+			}
+		}
+		// U+2192 is not in Mac Roman
+		arrow, _ := srcCmap.Lookup(0x2192)
+		newArrow, _ := plan.MapGlyph(arrow)
+		for code := 0; code < 256; code++ {
+			if lookup(code) == newArrow {
+				t.Errorf("%s: arrow glyph at Mac Roman %#x", tt.name, code)
+			}
+		}
+	}
+}
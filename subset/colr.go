@@ -0,0 +1,957 @@
+package subset
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// COLR and CPAL subsetting.
+//
+// HarfBuzz equivalent: COLR::subset and CPAL::subset (OT/Color/COLR/COLR.hh,
+// OT/Color/CPAL/CPAL.hh). The COLRv1 paint graphs are read from the raw
+// table; paint tables, color lines and transforms shared by several
+// parents are written once.
+
+// colrTable is a source COLR table with its v1 lists resolved to
+// absolute offsets.
+type colrTable struct {
+	data []byte
+	v0   *ot.COLR
+
+	basePaints  map[ot.GlyphID]int // BaseGlyphList: glyph -> paint
+	layerPaints []int              // LayerList
+	clips       []colrClip         // ClipList, sorted by glyph
+
+	varIdxMap *ot.DeltaSetIndexMap
+	varStore  *ot.ItemVariationStore
+}
+
+// colrClip is a Clip record: the clip box of a glyph range.
+type colrClip struct {
+	start, end ot.GlyphID
+	box        int
+}
+
+// parseCOLR parses a COLR table for subsetting.
+func parseCOLR(data []byte) (*colrTable, error) {
+	v0, err := ot.ParseCOLR(data)
+	if err != nil {
+		return nil, err
+	}
+	c := &colrTable{data: data, v0: v0, basePaints: make(map[ot.GlyphID]int)}
+	if v0.Version == 0 || len(data) < 34 {
+		return c, nil
+	}
+	be := binary.BigEndian
+
+	if off := int(be.Uint32(data[14:])); off != 0 {
+		if off+4 > len(data) {
+			return nil, ot.ErrInvalidTable
+		}
+		n := int(be.Uint32(data[off:]))
+		if n > (len(data)-off-4)/6 {
+			return nil, ot.ErrInvalidTable
+		}
+		for i := 0; i < n; i++ {
+			rec := data[off+4+i*6:]
+			c.basePaints[ot.GlyphID(be.Uint16(rec))] = off + int(be.Uint32(rec[2:]))
+		}
+	}
+	if off := int(be.Uint32(data[18:])); off != 0 {
+		if off+4 > len(data) {
+			return nil, ot.ErrInvalidTable
+		}
+		n := int(be.Uint32(data[off:]))
+		if n > (len(data)-off-4)/4 {
+			return nil, ot.ErrInvalidTable
+		}
+		c.layerPaints = make([]int, n)
+		for i := range c.layerPaints {
+			c.layerPaints[i] = off + int(be.Uint32(data[off+4+i*4:]))
+		}
+	}
+	if off := int(be.Uint32(data[22:])); off != 0 {
+		if off+5 > len(data) || data[off] != 1 {
+			return nil, ot.ErrInvalidTable
+		}
+		n := int(be.Uint32(data[off+1:]))
+		if n > (len(data)-off-5)/7 {
+			return nil, ot.ErrInvalidTable
+		}
+		for i := 0; i < n; i++ {
+			rec := data[off+5+i*7:]
+			c.clips = append(c.clips, colrClip{
+				start: ot.GlyphID(be.Uint16(rec)),
+				end:   ot.GlyphID(be.Uint16(rec[2:])),
+				box:   off + int(uint24(rec[4:])),
+			})
+		}
+	}
+	if off := int(be.Uint32(data[26:])); off != 0 && off < len(data) {
+		if c.varIdxMap, err = ot.ParseDeltaSetIndexMap(data[off:]); err != nil {
+			return nil, err
+		}
+	}
+	if off := int(be.Uint32(data[30:])); off != 0 && off < len(data) {
+		if c.varStore, err = ot.ParseItemVariationStore(data[off:]); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// clipBox returns the source offset of the clip box of a glyph.
+func (c *colrTable) clipBox(gid ot.GlyphID) (int, bool) {
+	i := sort.Search(len(c.clips), func(i int) bool { return c.clips[i].end >= gid })
+	if i < len(c.clips) && c.clips[i].start <= gid {
+		return c.clips[i].box, true
+	}
+	return 0, false
+}
+
+// varIdx maps a variation index of a paint through the DeltaSetIndexMap.
+func (c *colrTable) varIdx(idx uint32) uint32 {
+	return c.varIdxMap.Map(idx)
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// paintField is a field of a COLRv1 paint table after its format byte.
+type paintField uint8
+
+const (
+	fieldPaint     paintField = iota // Offset24 to a Paint
+	fieldColorLine                   // Offset24 to a (Var)ColorLine
+	fieldTransform                   // Offset24 to a (Var)Affine2x3
+	fieldGlyph                       // uint16 glyph ID (PaintGlyph)
+	fieldColrGlyph                   // uint16 color glyph ID (PaintColrGlyph)
+	fieldPalette                     // uint16 palette index
+	fieldValue                       // FWORD, UFWORD or F2DOT14 that may vary
+	fieldByte                        // uint8 composite mode
+	fieldLayers                      // uint8 numLayers, uint32 firstLayerIndex
+)
+
+func (f paintField) size() int {
+	switch f {
+	case fieldPaint, fieldColorLine, fieldTransform:
+		return 3
+	case fieldByte:
+		return 1
+	case fieldLayers:
+		return 5
+	}
+	return 2
+}
+
+// paintFields lists the fields of the static paint formats. Each variable
+// format (the odd formats 3 to 31 but PaintColrGlyph) has the fields of
+// the format before it followed by a uint32 varIndexBase for its values,
+// but PaintVarTransform, which varies through its VarAffine2x3.
+var paintFields = [...][]paintField{
+	1:  {fieldLayers},
+	2:  {fieldPalette, fieldValue},
+	4:  {fieldColorLine, fieldValue, fieldValue, fieldValue, fieldValue, fieldValue, fieldValue},
+	6:  {fieldColorLine, fieldValue, fieldValue, fieldValue, fieldValue, fieldValue, fieldValue},
+	8:  {fieldColorLine, fieldValue, fieldValue, fieldValue, fieldValue},
+	10: {fieldPaint, fieldGlyph},
+	11: {fieldColrGlyph},
+	12: {fieldPaint, fieldTransform},
+	14: {fieldPaint, fieldValue, fieldValue},
+	16: {fieldPaint, fieldValue, fieldValue},
+	18: {fieldPaint, fieldValue, fieldValue, fieldValue, fieldValue},
+	20: {fieldPaint, fieldValue},
+	22: {fieldPaint, fieldValue, fieldValue, fieldValue},
+	24: {fieldPaint, fieldValue},
+	26: {fieldPaint, fieldValue, fieldValue, fieldValue},
+	28: {fieldPaint, fieldValue, fieldValue},
+	30: {fieldPaint, fieldValue, fieldValue, fieldValue, fieldValue},
+	32: {fieldPaint, fieldByte, fieldPaint},
+}
+
+// paintFormat returns the fields of a paint format, whether it is
+// variable, and the size of the paint table.
+func paintFormat(format uint8) (fields []paintField, variable bool, size int) {
+	base := format
+	if format >= 3 && format <= 31 && format%2 == 1 && format != 11 {
+		base, variable = format-1, true
+	}
+	if int(base) >= len(paintFields) || paintFields[base] == nil {
+		return nil, false, 0
+	}
+	fields = paintFields[base]
+	size = 1
+	for _, f := range fields {
+		size += f.size()
+	}
+	if hasVarIndexBase(format) {
+		size += 4
+	}
+	return fields, variable, size
+}
+
+// hasVarIndexBase reports whether a paint format ends in a varIndexBase.
+func hasVarIndexBase(format uint8) bool {
+	return format >= 3 && format <= 31 && format%2 == 1 && format != 11 && format != 13
+}
+
+// colrClosure collects the glyphs, palette entries and layers the color
+// glyphs reference.
+// HarfBuzz equivalent: COLR::closure_glyphs, closure_V0palette_indices
+// and closure_forV1 (OT/Color/COLR/COLR.hh)
+type colrClosure struct {
+	c        *colrTable
+	glyphs   map[ot.GlyphID]bool
+	palettes map[uint16]bool
+	layers   map[uint32]bool
+	visited  map[int]bool
+}
+
+func newCOLRClosure(c *colrTable) *colrClosure {
+	return &colrClosure{
+		c:        c,
+		glyphs:   make(map[ot.GlyphID]bool),
+		palettes: make(map[uint16]bool),
+		layers:   make(map[uint32]bool),
+		visited:  make(map[int]bool),
+	}
+}
+
+// addGlyph adds the v0 layers and the v1 paint graph of a glyph.
+func (cl *colrClosure) addGlyph(gid ot.GlyphID) {
+	for _, l := range cl.c.v0.GlyphLayers(gid) {
+		cl.glyphs[l.GlyphID] = true
+		cl.addPalette(l.ColorIndex)
+	}
+	if off, ok := cl.c.basePaints[gid]; ok {
+		cl.paint(off, 0)
+	}
+}
+
+func (cl *colrClosure) addPalette(idx uint16) {
+	if idx != ot.ForegroundColorIndex {
+		cl.palettes[idx] = true
+	}
+}
+
+func (cl *colrClosure) paint(off, depth int) {
+	data := cl.c.data
+	if depth > maxClosureNesting || cl.visited[off] || off >= len(data) {
+		return
+	}
+	cl.visited[off] = true
+	fields, variable, size := paintFormat(data[off])
+	if size == 0 || off+size > len(data) {
+		return
+	}
+	pos := off + 1
+	for _, f := range fields {
+		switch f {
+		case fieldPaint:
+			cl.paint(off+int(uint24(data[pos:])), depth+1)
+		case fieldColorLine:
+			cl.colorLine(off+int(uint24(data[pos:])), variable)
+		case fieldGlyph:
+			cl.glyphs[ot.GlyphID(binary.BigEndian.Uint16(data[pos:]))] = true
+		case fieldColrGlyph:
+			gid := ot.GlyphID(binary.BigEndian.Uint16(data[pos:]))
+			if base, ok := cl.c.basePaints[gid]; ok {
+				cl.glyphs[gid] = true
+				cl.paint(base, depth+1)
+			}
+		case fieldPalette:
+			cl.addPalette(binary.BigEndian.Uint16(data[pos:]))
+		case fieldLayers:
+			n := uint32(data[pos])
+			first := binary.BigEndian.Uint32(data[pos+1:])
+			for i := first; i < first+n && int(i) < len(cl.c.layerPaints); i++ {
+				cl.layers[i] = true
+				cl.paint(cl.c.layerPaints[i], depth+1)
+			}
+		}
+		pos += f.size()
+	}
+}
+
+func (cl *colrClosure) colorLine(off int, variable bool) {
+	data := cl.c.data
+	stopSize := colorStopSize(variable)
+	if off+3 > len(data) {
+		return
+	}
+	n := int(binary.BigEndian.Uint16(data[off+1:]))
+	for i := 0; i < n && off+3+(i+1)*stopSize <= len(data); i++ {
+		cl.addPalette(binary.BigEndian.Uint16(data[off+3+i*stopSize+2:]))
+	}
+}
+
+// colorStopSize returns the size of a ColorStop or VarColorStop.
+func colorStopSize(variable bool) int {
+	if variable {
+		return 10
+	}
+	return 6
+}
+
+// computeCOLRClosure adds the glyphs the color glyphs are drawn with and
+// plans the retained palette entries and layers.
+func (p *Plan) computeCOLRClosure() {
+	if p.colr == nil {
+		return
+	}
+	cl := newCOLRClosure(p.colr)
+	for gid := range p.glyphSet {
+		cl.addGlyph(gid)
+	}
+	for gid := range cl.glyphs {
+		p.glyphSet[gid] = true
+	}
+	p.colrGlyphs = make(map[ot.GlyphID]bool, len(p.glyphSet))
+	for gid := range p.glyphSet {
+		p.colrGlyphs[gid] = true
+	}
+
+	// Palette entries and layers keep their order
+	palettes := make([]uint16, 0, len(cl.palettes))
+	for idx := range cl.palettes {
+		palettes = append(palettes, idx)
+	}
+	sort.Slice(palettes, func(i, j int) bool { return palettes[i] < palettes[j] })
+	p.colrPalettes = make(map[uint16]uint16, len(palettes))
+	for i, idx := range palettes {
+		p.colrPalettes[idx] = uint16(i)
+	}
+	layers := make([]uint32, 0, len(cl.layers))
+	for idx := range cl.layers {
+		layers = append(layers, idx)
+	}
+	sort.Slice(layers, func(i, j int) bool { return layers[i] < layers[j] })
+	p.colrLayers = make(map[uint32]uint32, len(layers))
+	for i, idx := range layers {
+		p.colrLayers[idx] = uint32(i)
+	}
+}
+
+// colrPalette returns the new palette index of a color index.
+func (p *Plan) colrPalette(idx uint16) uint16 {
+	if idx == ot.ForegroundColorIndex {
+		return idx
+	}
+	return p.colrPalettes[idx]
+}
+
+// colrObject is a paint table, color line or transform of the output.
+type colrObject struct {
+	data  []byte
+	links []colrLink
+	pos   int
+}
+
+// colrLink is an Offset24 at position at of an object's data.
+type colrLink struct {
+	at, child int
+}
+
+// colrWriter serializes the paint graphs of the retained color glyphs.
+// Each source subtable becomes one object, so subtables shared by several
+// parents stay shared.
+type colrWriter struct {
+	p *Plan
+	c *colrTable
+
+	// applyDeltas adds the deltas at the instance location (or the new
+	// default location) to the values; static writes the static formats
+	// of a fully instanced font.
+	applyDeltas bool
+	static      bool
+
+	objects []*colrObject
+	index   map[int]int // source offset -> object
+
+	// varBases maps a source varIndexBase and value count to the output
+	// base; varEntries holds the source delta set of each output index.
+	varBases   map[[2]uint32]uint32
+	varEntries []uint32
+
+	err error
+}
+
+func (p *Plan) newCOLRWriter() *colrWriter {
+	return &colrWriter{
+		p:           p,
+		c:           p.colr,
+		applyDeltas: p.normalizedCoords != nil && p.colr.varStore != nil,
+		static:      p.IsInstanced(),
+		index:       make(map[int]int),
+		varBases:    make(map[[2]uint32]uint32),
+	}
+}
+
+// deltas returns the rounded deltas of n values at the instance location,
+// or nil if there are none.
+func (w *colrWriter) deltas(base uint32, n int) []int32 {
+	if !w.applyDeltas || base == noVariationsIndex {
+		return nil
+	}
+	out := make([]int32, n)
+	for i := range out {
+		d := w.c.varStore.GetDelta(w.c.varIdx(base+uint32(i)), w.p.normalizedCoords)
+		out[i] = int32(math.Round(d))
+	}
+	return out
+}
+
+// varBase returns the output varIndexBase of n values.
+func (w *colrWriter) varBase(base uint32, n int) uint32 {
+	if base == noVariationsIndex || w.c.varStore == nil {
+		return noVariationsIndex
+	}
+	key := [2]uint32{base, uint32(n)}
+	if b, ok := w.varBases[key]; ok {
+		return b
+	}
+	b := uint32(len(w.varEntries))
+	for i := 0; i < n; i++ {
+		w.varEntries = append(w.varEntries, w.c.varIdx(base+uint32(i)))
+	}
+	w.varBases[key] = b
+	return b
+}
+
+// object registers the object of a source subtable. It returns false if
+// the subtable was written before.
+func (w *colrWriter) object(off int) (int, *colrObject, bool) {
+	if i, ok := w.index[off]; ok {
+		return i, nil, false
+	}
+	obj := &colrObject{}
+	w.index[off] = len(w.objects)
+	w.objects = append(w.objects, obj)
+	return len(w.objects) - 1, obj, true
+}
+
+// paint writes the paint table at a source offset with its subgraph.
+func (w *colrWriter) paint(off, depth int) int {
+	data := w.c.data
+	if depth > maxClosureNesting || off >= len(data) {
+		w.err = ot.ErrInvalidTable
+		return 0
+	}
+	format := data[off]
+	fields, variable, size := paintFormat(format)
+	if size == 0 || off+size > len(data) {
+		w.err = ot.ErrInvalidTable
+		return 0
+	}
+	idx, obj, isNew := w.object(off)
+	if !isNew {
+		return idx
+	}
+	src := data[off : off+size]
+
+	base := uint32(noVariationsIndex)
+	numValues := 0
+	varied := hasVarIndexBase(format)
+	if varied {
+		base = binary.BigEndian.Uint32(src[size-4:])
+		for _, f := range fields {
+			if f == fieldValue {
+				numValues++
+			}
+		}
+	}
+	deltas := w.deltas(base, numValues)
+
+	if variable && w.static {
+		format--
+	}
+	out := []byte{format}
+	pos, value := 1, 0
+	for _, f := range fields {
+		switch f {
+		case fieldPaint, fieldColorLine, fieldTransform:
+			child := off + int(uint24(src[pos:]))
+			var obj int
+			switch f {
+			case fieldPaint:
+				obj = w.paint(child, depth+1)
+			case fieldColorLine:
+				obj = w.colorLine(child, variable)
+			default:
+				obj = w.transform(child, variable)
+			}
+			w.objects[idx].links = append(w.objects[idx].links, colrLink{len(out), obj})
+			out = append(out, 0, 0, 0)
+		case fieldGlyph, fieldColrGlyph:
+			newGID := w.p.glyphMap[ot.GlyphID(binary.BigEndian.Uint16(src[pos:]))]
+			out = binary.BigEndian.AppendUint16(out, uint16(newGID))
+		case fieldPalette:
+			out = binary.BigEndian.AppendUint16(out, w.p.colrPalette(binary.BigEndian.Uint16(src[pos:])))
+		case fieldValue:
+			v := int16(binary.BigEndian.Uint16(src[pos:]))
+			if deltas != nil {
+				v += int16(deltas[value])
+			}
+			value++
+			out = binary.BigEndian.AppendUint16(out, uint16(v))
+		case fieldByte:
+			out = append(out, src[pos])
+		case fieldLayers:
+			first := binary.BigEndian.Uint32(src[pos+1:])
+			out = append(out, src[pos])
+			out = binary.BigEndian.AppendUint32(out, w.p.colrLayers[first])
+		}
+		pos += f.size()
+	}
+	if varied && !w.static {
+		out = binary.BigEndian.AppendUint32(out, w.varBase(base, numValues))
+	}
+	obj.data = out
+	return idx
+}
+
+// colorLine writes a ColorLine or VarColorLine.
+func (w *colrWriter) colorLine(off int, variable bool) int {
+	data := w.c.data
+	stopSize := colorStopSize(variable)
+	if off+3 > len(data) {
+		w.err = ot.ErrInvalidTable
+		return 0
+	}
+	n := int(binary.BigEndian.Uint16(data[off+1:]))
+	if off+3+n*stopSize > len(data) {
+		w.err = ot.ErrInvalidTable
+		return 0
+	}
+	idx, obj, isNew := w.object(off)
+	if !isNew {
+		return idx
+	}
+	out := append([]byte{data[off]}, data[off+1:off+3]...)
+	for i := 0; i < n; i++ {
+		stop := data[off+3+i*stopSize:]
+		stopOffset := int16(binary.BigEndian.Uint16(stop))
+		alpha := int16(binary.BigEndian.Uint16(stop[4:]))
+		base := uint32(noVariationsIndex)
+		if variable {
+			base = binary.BigEndian.Uint32(stop[6:])
+			if d := w.deltas(base, 2); d != nil {
+				stopOffset += int16(d[0])
+				alpha += int16(d[1])
+			}
+		}
+		out = appendUint16s(out, uint16(stopOffset), w.p.colrPalette(binary.BigEndian.Uint16(stop[2:])), uint16(alpha))
+		if variable && !w.static {
+			out = binary.BigEndian.AppendUint32(out, w.varBase(base, 2))
+		}
+	}
+	obj.data = out
+	return idx
+}
+
+// transform writes an Affine2x3 or VarAffine2x3.
+func (w *colrWriter) transform(off int, variable bool) int {
+	data := w.c.data
+	size := 24
+	if variable {
+		size += 4
+	}
+	if off+size > len(data) {
+		w.err = ot.ErrInvalidTable
+		return 0
+	}
+	idx, obj, isNew := w.object(off)
+	if !isNew {
+		return idx
+	}
+	base := uint32(noVariationsIndex)
+	if variable {
+		base = binary.BigEndian.Uint32(data[off+24:])
+	}
+	deltas := w.deltas(base, 6)
+	var out []byte
+	for i := 0; i < 6; i++ {
+		v := int32(binary.BigEndian.Uint32(data[off+i*4:]))
+		if deltas != nil {
+			v += deltas[i]
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(v))
+	}
+	if variable && !w.static {
+		out = binary.BigEndian.AppendUint32(out, w.varBase(base, 6))
+	}
+	obj.data = out
+	return idx
+}
+
+// clipBox returns the ClipBox at a source offset.
+func (w *colrWriter) clipBox(off int) []byte {
+	data := w.c.data
+	if off+9 > len(data) || (data[off] == 2 && off+13 > len(data)) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	variable := data[off] == 2
+	base := uint32(noVariationsIndex)
+	if variable {
+		base = binary.BigEndian.Uint32(data[off+9:])
+	}
+	deltas := w.deltas(base, 4)
+	format := data[off]
+	if variable && w.static {
+		format = 1
+	}
+	out := []byte{format}
+	for i := 0; i < 4; i++ {
+		v := int16(binary.BigEndian.Uint16(data[off+1+i*2:]))
+		if deltas != nil {
+			v += int16(deltas[i])
+		}
+		out = binary.BigEndian.AppendUint16(out, uint16(v))
+	}
+	if variable && !w.static {
+		out = binary.BigEndian.AppendUint32(out, w.varBase(base, 4))
+	}
+	return out
+}
+
+// layout places the objects reachable from the roots with every parent
+// before its children, as Offset24 values are unsigned, and returns the
+// serialized objects.
+func (w *colrWriter) layout(roots []int) ([]byte, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make([]uint8, len(w.objects))
+	var order []int
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch state[i] {
+		case visiting:
+			return false // offset cycle
+		case done:
+			return true
+		}
+		state[i] = visiting
+		for _, l := range w.objects[i].links {
+			if !visit(l.child) {
+				return false
+			}
+		}
+		state[i] = done
+		order = append(order, i)
+		return true
+	}
+	for _, r := range roots {
+		if !visit(r) {
+			return nil, ot.ErrInvalidTable
+		}
+	}
+
+	var out []byte
+	for k := len(order) - 1; k >= 0; k-- {
+		obj := w.objects[order[k]]
+		obj.pos = len(out)
+		out = append(out, obj.data...)
+	}
+	for _, i := range order {
+		obj := w.objects[i]
+		for _, l := range obj.links {
+			d := w.objects[l.child].pos - obj.pos
+			if d <= 0 || d >= 1<<24 {
+				return nil, ot.ErrInvalidTable
+			}
+			putUint24(out[obj.pos+l.at:], uint32(d))
+		}
+	}
+	return out, nil
+}
+
+// buildClipList writes the clips of the retained color glyphs, given in
+// new glyph order. Glyphs with consecutive new IDs and the same source
+// clip box share a Clip record.
+func (w *colrWriter) buildClipList(glyphs []ot.GlyphID) []byte {
+	type clipRange struct {
+		start, end ot.GlyphID
+		box        int
+	}
+	var ranges []clipRange
+	for _, oldGID := range glyphs {
+		box, ok := w.c.clipBox(oldGID)
+		if !ok {
+			continue
+		}
+		newGID := w.p.glyphMap[oldGID]
+		if n := len(ranges); n > 0 && ranges[n-1].box == box && ranges[n-1].end+1 == newGID {
+			ranges[n-1].end = newGID
+			continue
+		}
+		ranges = append(ranges, clipRange{newGID, newGID, box})
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	out := []byte{1}
+	out = binary.BigEndian.AppendUint32(out, uint32(len(ranges)))
+	boxes := make(map[int]int) // source offset -> offset in the ClipList
+	var boxData []byte
+	boxStart := len(out) + 7*len(ranges)
+	for _, r := range ranges {
+		off, ok := boxes[r.box]
+		if !ok {
+			off = boxStart + len(boxData)
+			boxes[r.box] = off
+			boxData = append(boxData, w.clipBox(r.box)...)
+		}
+		out = appendUint16s(out, uint16(r.start), uint16(r.end))
+		out = append(out, byte(off>>16), byte(off>>8), byte(off))
+	}
+	return append(out, boxData...)
+}
+
+// subsetCOLR subsets the COLR table to the retained color glyphs. The v1
+// variation data is subset to the delta sets still referenced, or applied
+// to the values when the font is instanced.
+// HarfBuzz equivalent: COLR::subset (OT/Color/COLR/COLR.hh)
+func (p *Plan) subsetCOLR() ([]byte, error) {
+	c := p.colr
+	var glyphs []ot.GlyphID
+	for oldGID := range p.colrGlyphs {
+		if _, ok := p.glyphMap[oldGID]; ok {
+			glyphs = append(glyphs, oldGID)
+		}
+	}
+	sort.Slice(glyphs, func(i, j int) bool { return p.glyphMap[glyphs[i]] < p.glyphMap[glyphs[j]] })
+
+	// Version 0 base glyph and layer records
+	var baseRecords, layerRecords []byte
+	numBase, numLayers := 0, 0
+	for _, oldGID := range glyphs {
+		layers := c.v0.GlyphLayers(oldGID)
+		if len(layers) == 0 {
+			continue
+		}
+		first := numLayers
+		for _, l := range layers {
+			if newGID, ok := p.glyphMap[l.GlyphID]; ok {
+				layerRecords = appendUint16s(layerRecords, uint16(newGID), p.colrPalette(l.ColorIndex))
+				numLayers++
+			}
+		}
+		baseRecords = appendUint16s(baseRecords, uint16(p.glyphMap[oldGID]), uint16(first), uint16(numLayers-first))
+		numBase++
+	}
+	if numLayers > 0xFFFF {
+		return nil, ot.ErrInvalidTable
+	}
+
+	// Version 1 paint graphs
+	w := p.newCOLRWriter()
+	var baseGIDs []ot.GlyphID
+	var roots []int
+	for _, oldGID := range glyphs {
+		if off, ok := c.basePaints[oldGID]; ok {
+			baseGIDs = append(baseGIDs, p.glyphMap[oldGID])
+			roots = append(roots, w.paint(off, 0))
+		}
+	}
+	numBasePaints := len(roots)
+	layerOrder := make([]uint32, len(p.colrLayers))
+	for oldIdx, newIdx := range p.colrLayers {
+		layerOrder[newIdx] = oldIdx
+	}
+	for _, oldIdx := range layerOrder {
+		roots = append(roots, w.paint(c.layerPaints[oldIdx], 0))
+	}
+	clipList := w.buildClipList(glyphs)
+	if w.err != nil {
+		return nil, w.err
+	}
+	if numBase == 0 && numBasePaints == 0 {
+		return nil, nil
+	}
+
+	headerSize := 14
+	if numBasePaints > 0 {
+		headerSize = 34
+	}
+	off := headerSize
+	baseOff, layersOff := 0, 0
+	if numBase > 0 {
+		baseOff = off
+		off += len(baseRecords)
+		layersOff = off
+		off += len(layerRecords)
+	}
+	out := appendUint16s(nil, 0, uint16(numBase))
+	out = binary.BigEndian.AppendUint32(out, uint32(baseOff))
+	out = binary.BigEndian.AppendUint32(out, uint32(layersOff))
+	out = binary.BigEndian.AppendUint16(out, uint16(numLayers))
+	if numBasePaints == 0 {
+		return append(append(out, baseRecords...), layerRecords...), nil
+	}
+
+	paints, err := w.layout(roots)
+	if err != nil {
+		return nil, err
+	}
+	var varIdxMap, varStore []byte
+	if len(w.varEntries) > 0 {
+		vb := p.newVarStoreBuilder(c.varStore)
+		for _, idx := range w.varEntries {
+			vb.add(idx)
+		}
+		varStore = vb.build()
+		entries := make([]uint32, len(w.varEntries))
+		for i, idx := range w.varEntries {
+			entries[i] = vb.mapped(idx)
+		}
+		varIdxMap = buildDeltaSetIndexMap(entries)
+	}
+
+	baseListOff := off
+	off += 4 + 6*numBasePaints
+	layerListOff := 0
+	if len(layerOrder) > 0 {
+		layerListOff = off
+		off += 4 + 4*len(layerOrder)
+	}
+	clipListOff := 0
+	if clipList != nil {
+		clipListOff = off
+		off += len(clipList)
+	}
+	paintsOff := off
+	off += len(paints)
+	varIdxMapOff, varStoreOff := 0, 0
+	if varStore != nil {
+		varIdxMapOff = off
+		off += len(varIdxMap)
+		varStoreOff = off
+	}
+
+	binary.BigEndian.PutUint16(out, 1)
+	for _, v := range []int{baseListOff, layerListOff, clipListOff, varIdxMapOff, varStoreOff} {
+		out = binary.BigEndian.AppendUint32(out, uint32(v))
+	}
+	out = append(append(out, baseRecords...), layerRecords...)
+	out = binary.BigEndian.AppendUint32(out, uint32(numBasePaints))
+	for i, gid := range baseGIDs {
+		out = binary.BigEndian.AppendUint16(out, uint16(gid))
+		out = binary.BigEndian.AppendUint32(out, uint32(paintsOff+w.objects[roots[i]].pos-baseListOff))
+	}
+	if layerListOff != 0 {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(layerOrder)))
+		for _, r := range roots[numBasePaints:] {
+			out = binary.BigEndian.AppendUint32(out, uint32(paintsOff+w.objects[r].pos-layerListOff))
+		}
+	}
+	out = append(out, clipList...)
+	out = append(out, paints...)
+	out = append(out, varIdxMap...)
+	return append(out, varStore...), nil
+}
+
+// subsetCPAL keeps the palette entries the retained color glyphs use, in
+// their original order.
+// HarfBuzz equivalent: CPAL::subset (OT/Color/CPAL/CPAL.hh)
+func (p *Plan) subsetCPAL() ([]byte, error) {
+	if len(p.colrPalettes) == 0 {
+		return nil, nil
+	}
+	data, err := p.source.TableData(ot.TagCPAL)
+	if err != nil {
+		return nil, err
+	}
+	cpal, err := ot.ParseCPAL(data)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]uint16, len(p.colrPalettes))
+	for oldIdx, newIdx := range p.colrPalettes {
+		entries[newIdx] = oldIdx
+	}
+	numPalettes, numColors := cpal.NumPalettes(), len(entries)
+	if numPalettes*numColors > 0xFFFF {
+		return nil, ot.ErrInvalidTable
+	}
+
+	// Version 1 arrays: palette types, palette labels and entry labels
+	var typesOff, labelsOff, entryLabelsOff int
+	tail := 12 + 2*numPalettes
+	if cpal.Version >= 1 && tail+12 <= len(data) {
+		typesOff = int(binary.BigEndian.Uint32(data[tail:]))
+		labelsOff = int(binary.BigEndian.Uint32(data[tail+4:]))
+		entryLabelsOff = int(binary.BigEndian.Uint32(data[tail+8:]))
+	}
+	if labelsOff+2*numPalettes > len(data) {
+		labelsOff = 0
+	}
+	if entryLabelsOff+2*cpal.NumColors() > len(data) {
+		entryLabelsOff = 0
+	}
+
+	headerSize := tail
+	if cpal.Version >= 1 {
+		headerSize += 12
+	}
+	out := appendUint16s(nil, cpal.Version, uint16(numColors), uint16(numPalettes), uint16(numPalettes*numColors))
+	out = binary.BigEndian.AppendUint32(out, uint32(headerSize))
+	for i := 0; i < numPalettes; i++ {
+		out = binary.BigEndian.AppendUint16(out, uint16(i*numColors))
+	}
+	if cpal.Version >= 1 {
+		off := headerSize + 4*numPalettes*numColors
+		arrays := []struct {
+			present bool
+			size    int
+		}{
+			{typesOff != 0, 4 * numPalettes},
+			{labelsOff != 0, 2 * numPalettes},
+			{entryLabelsOff != 0, 2 * numColors},
+		}
+		for _, a := range arrays {
+			if !a.present {
+				out = binary.BigEndian.AppendUint32(out, 0)
+				continue
+			}
+			out = binary.BigEndian.AppendUint32(out, uint32(off))
+			off += a.size
+		}
+	}
+
+	for i := 0; i < numPalettes; i++ {
+		colors := cpal.PaletteColors(i)
+		for _, idx := range entries {
+			var c ot.BGRAColor
+			if int(idx) < len(colors) {
+				c = colors[idx]
+			}
+			out = append(out, c.Blue, c.Green, c.Red, c.Alpha)
+		}
+	}
+	if typesOff != 0 {
+		for i := 0; i < numPalettes; i++ {
+			out = binary.BigEndian.AppendUint32(out, uint32(cpal.PaletteFlags(i)))
+		}
+	}
+	if labelsOff != 0 {
+		out = append(out, data[labelsOff:labelsOff+2*numPalettes]...)
+	}
+	if entryLabelsOff != 0 {
+		for _, idx := range entries {
+			label := uint16(0xFFFF)
+			if int(idx) < cpal.NumColors() {
+				label = binary.BigEndian.Uint16(data[entryLabelsOff+2*int(idx):])
+			}
+			out = binary.BigEndian.AppendUint16(out, label)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,342 @@
+package subset
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestSubsetCOLRv0(t *testing.T) {
+	font := loadFont(t, "TwemojiMozilla.subset.ttf")
+	srcColors := font.ColorPaletteColors(0)
+
+	input := NewInput()
+	input.AddString("㊙")
+	_, _, subFont := subsetAndParse(t, font, input)
+	if !subFont.HasTable(ot.TagCOLR) || !subFont.HasTable(ot.TagCPAL) {
+		t.Fatal("color tables dropped")
+	}
+	srcGID, _ := loadCmap(t, font).Lookup(0x3299)
+	gid, _ := loadCmap(t, subFont).Lookup(0x3299)
+	srcLayers := font.GlyphColorLayers(srcGID)
+	layers := subFont.GlyphColorLayers(gid)
+	if len(layers) != len(srcLayers) {
+		t.Fatalf("%d layers, want %d", len(layers), len(srcLayers))
+	}
+	colors := subFont.ColorPaletteColors(0)
+	for i, l := range layers {
+		if l.GlyphID >= ot.GlyphID(subFont.NumGlyphs()) {
+			t.Errorf("layer %d: glyph %d out of range", i, l.GlyphID)
+		}
+		if colors[l.ColorIndex] != srcColors[srcLayers[i].ColorIndex] {
+			t.Errorf("layer %d: color %v, want %v", i, colors[l.ColorIndex], srcColors[srcLayers[i].ColorIndex])
+		}
+	}
+
+	// Without color glyphs both tables are dropped
+	input = NewInput()
+	input.AddString("2")
+	_, _, subFont = subsetAndParse(t, font, input)
+	if subFont.HasTable(ot.TagCOLR) || subFont.HasTable(ot.TagCPAL) {
+		t.Error("color tables kept without color glyphs")
+	}
+}
+
+// buildCOLRv1Font adds a COLRv1 table and a CPAL table with four colors to
+// Roboto. A and E are drawn with layers, D is drawn as A:
+//
+//	A: PaintColrLayers(layers 1-2) with a clip box
+//	   layer 1: PaintGlyph(B, PaintSolid(color 3))
+//	   layer 2: PaintGlyph(C, PaintLinearGradient(colors 1, 3))
+//	D: PaintColrGlyph(A)
+//	E: PaintColrLayers(layer 0)
+//	   layer 0: PaintGlyph(F, PaintSolid(color 0))
+func buildCOLRv1Font(t *testing.T) *ot.Font {
+	t.Helper()
+	font := loadFont(t, "Roboto-Regular.ttf")
+	cmap := loadCmap(t, font)
+	gid := func(r rune) uint16 {
+		g, _ := cmap.Lookup(ot.Codepoint(r))
+		return uint16(g)
+	}
+
+	const (
+		baseList  = 34
+		layerList = baseList + 4 + 3*6
+		clipList  = layerList + 4 + 3*4
+		clipBox   = clipList + 5 + 7
+		paintA    = clipBox + 9
+		paintD    = paintA + 6
+		paintE    = paintD + 3
+		layerE    = paintE + 6
+		layer1    = layerE + 6
+		layer2    = layer1 + 6
+		solid0    = layer2 + 6
+		solid3    = solid0 + 5
+		gradient  = solid3 + 5
+		colorLine = gradient + 16
+	)
+	u24 := func(v int) []byte { return []byte{byte(v >> 16), byte(v >> 8), byte(v)} }
+	u32 := func(v int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(v)) }
+
+	colr := appendUint16s(nil, 1, 0, 0, 0, 0, 0, 0)
+	for _, v := range []int{baseList, layerList, clipList, 0, 0} {
+		colr = append(colr, u32(v)...)
+	}
+	colr = append(colr, u32(3)...)
+	for _, r := range []struct {
+		g     rune
+		paint int
+	}{{'A', paintA}, {'D', paintD}, {'E', paintE}} {
+		colr = append(binary.BigEndian.AppendUint16(colr, gid(r.g)), u32(r.paint-baseList)...)
+	}
+	colr = append(colr, u32(3)...)
+	for _, p := range []int{layerE, layer1, layer2} {
+		colr = append(colr, u32(p-layerList)...)
+	}
+	colr = append(append(append(colr, 1), u32(1)...), appendUint16s(nil, gid('A'), gid('A'))...)
+	colr = append(colr, u24(clipBox-clipList)...)
+	colr = append(colr, 1)
+	colr = appendUint16s(colr, 0, 0xFFF0, 1000, 1500)
+	colr = append(append(colr, 1, 2), u32(1)...)
+	colr = binary.BigEndian.AppendUint16(append(colr, 11), gid('A'))
+	colr = append(append(colr, 1, 1), u32(0)...)
+	for _, l := range []struct {
+		g     rune
+		paint int
+		at    int
+	}{{'F', solid0, layerE}, {'B', solid3, layer1}, {'C', gradient, layer2}} {
+		colr = binary.BigEndian.AppendUint16(append(append(colr, 10), u24(l.paint-l.at)...), gid(l.g))
+	}
+	colr = appendUint16s(append(colr, 2), 0, 0x4000)
+	colr = appendUint16s(append(colr, 2), 3, 0x4000)
+	colr = appendUint16s(append(append(colr, 4), u24(colorLine-gradient)...), 0, 0, 1000, 0, 0, 1000)
+	colr = appendUint16s(append(colr, 0), 2, 0, 1, 0x4000, 0x4000, 3, 0x4000)
+	if len(colr) != colorLine+15 {
+		t.Fatalf("COLR has %d bytes, want %d", len(colr), colorLine+15)
+	}
+
+	cpal := appendUint16s(nil, 0, 4, 1, 4)
+	cpal = append(append(cpal, u32(14)...), 0, 0)
+	cpal = append(cpal, 0, 0, 255, 255, 0, 255, 0, 255, 255, 0, 0, 255, 255, 255, 255, 255)
+
+	builder := NewFontBuilder()
+	for _, tag := range []ot.Tag{ot.TagHead, ot.TagHhea, ot.TagMaxp, ot.TagHmtx, ot.TagLoca,
+		ot.TagGlyf, ot.TagCmap, ot.TagName, ot.TagPost, ot.TagOS2} {
+		builder.AddTable(tag, mustTableData(t, font, tag))
+	}
+	builder.AddTable(ot.TagCOLR, colr)
+	builder.AddTable(ot.TagCPAL, cpal)
+	data, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	colorFont, err := ot.ParseFont(data, 0)
+	if err != nil {
+		t.Fatalf("ParseFont: %v", err)
+	}
+	return colorFont
+}
+
+func TestSubsetCOLRv1(t *testing.T) {
+	font := buildCOLRv1Font(t)
+	srcColors := font.ColorPaletteColors(0)
+	srcCmap := loadCmap(t, font)
+
+	for _, flags := range []Flags{FlagNoLayoutClosure, FlagNoLayoutClosure | FlagRetainGIDs} {
+		input := NewInput()
+		input.AddString("D")
+		input.Flags = flags
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset: %v", err)
+		}
+		newGID := func(r rune) ot.GlyphID {
+			g, _ := srcCmap.Lookup(ot.Codepoint(r))
+			n, ok := plan.MapGlyph(g)
+			if !ok {
+				t.Fatalf("flags %#x: %c not retained", flags, r)
+			}
+			return n
+		}
+
+		// D draws A, which draws B and C; E and F are gone
+		for _, r := range "EF" {
+			g, _ := srcCmap.Lookup(ot.Codepoint(r))
+			if _, ok := plan.MapGlyph(g); ok {
+				t.Errorf("flags %#x: %c retained", flags, r)
+			}
+		}
+		colr, err := parseCOLR(mustTableData(t, subFont, ot.TagCOLR))
+		if err != nil {
+			t.Fatalf("flags %#x: parseCOLR: %v", flags, err)
+		}
+		if len(colr.basePaints) != 2 || len(colr.layerPaints) != 2 {
+			t.Errorf("flags %#x: %d base paints and %d layers, want 2 and 2",
+				flags, len(colr.basePaints), len(colr.layerPaints))
+		}
+		if _, ok := colr.clipBox(newGID('A')); !ok {
+			t.Errorf("flags %#x: clip box of A dropped", flags)
+		}
+
+		cl := newCOLRClosure(colr)
+		cl.addGlyph(newGID('D'))
+		for _, r := range "ABC" {
+			if !cl.glyphs[newGID(r)] {
+				t.Errorf("flags %#x: %c not drawn", flags, r)
+			}
+		}
+		if len(cl.glyphs) != 3 || len(cl.layers) != 2 {
+			t.Errorf("flags %#x: %d glyphs and %d layers drawn, want 3 and 2", flags, len(cl.glyphs), len(cl.layers))
+		}
+
+		// Colors 1 and 3 become 0 and 1
+		if !cl.palettes[0] || !cl.palettes[1] || len(cl.palettes) != 2 {
+			t.Errorf("flags %#x: palette entries %v, want 0 and 1", flags, cl.palettes)
+		}
+		colors := subFont.ColorPaletteColors(0)
+		if len(colors) != 2 || colors[0] != srcColors[1] || colors[1] != srcColors[3] {
+			t.Errorf("flags %#x: colors %v, want %v", flags, colors, []ot.BGRAColor{srcColors[1], srcColors[3]})
+		}
+	}
+}
+
+func TestCOLRWriterSharesSubtables(t *testing.T) {
+	// Two PaintGlyph tables share a PaintSolid that follows them.
+	data := make([]byte, 34)
+	data = append(data, 10, 0, 0, 12, 0, 1) // at 34, paint at 46
+	data = append(data, 10, 0, 0, 6, 0, 2)  // at 40, paint at 46
+	data = append(data, 2, 0, 0, 0x40, 0)   // at 46
+	binary.BigEndian.PutUint16(data, 1)
+
+	p := &Plan{
+		glyphMap:     map[ot.GlyphID]ot.GlyphID{1: 1, 2: 2},
+		colrPalettes: map[uint16]uint16{0: 0},
+		colr:         &colrTable{data: data},
+		input:        NewInput(),
+	}
+	w := p.newCOLRWriter()
+	roots := []int{w.paint(40, 0), w.paint(34, 0)}
+	out, err := w.layout(roots)
+	if err != nil {
+		t.Fatalf("layout: %v", err)
+	}
+	if len(out) != 17 {
+		t.Errorf("%d bytes, want 17 with the PaintSolid written once", len(out))
+	}
+	for _, r := range roots {
+		obj := w.objects[r]
+		if child := obj.pos + int(uint24(out[obj.pos+1:])); out[child] != 2 {
+			t.Errorf("PaintGlyph at %d links to format %d, want PaintSolid", obj.pos, out[child])
+		}
+	}
+}
+
+func TestCOLRWriterVariations(t *testing.T) {
+	// A PaintVarSolid whose alpha grows by 0x1000 towards the axis maximum
+	regions := buildRegionList(1, [][]ot.RegionAxisCoordinates{{{Start: 0, Peak: 0x4000, End: 0x4000}}})
+	store := appendUint16s(nil, 1, 0, 12, 1)
+	store = binary.BigEndian.AppendUint32(store, uint32(12+len(regions)))
+	store = append(append(store, regions...), buildVarData([]uint16{0}, [][]int32{{0x1000}})...)
+	varStore, err := ot.ParseItemVariationStore(store)
+	if err != nil {
+		t.Fatalf("ParseItemVariationStore: %v", err)
+	}
+	data := append(make([]byte, 34), 3, 0, 0, 0x30, 0, 0, 0, 0, 0)
+
+	for _, static := range []bool{false, true} {
+		p := &Plan{
+			colrPalettes: map[uint16]uint16{0: 0},
+			colr:         &colrTable{data: data, varStore: varStore},
+			input:        NewInput(),
+		}
+		if static {
+			p.input.PinAxisLocation(ot.TagAxisWeight, 900)
+			p.instancedAdvances = map[ot.GlyphID]uint16{}
+			p.normalizedCoords = []int{0x4000}
+		}
+		w := p.newCOLRWriter()
+		out, err := w.layout([]int{w.paint(34, 0)})
+		if err != nil {
+			t.Fatalf("layout: %v", err)
+		}
+		if static {
+			if want := []byte{2, 0, 0, 0x40, 0}; string(out) != string(want) {
+				t.Errorf("instanced paint %v, want %v", out, want)
+			}
+			continue
+		}
+		if want := []byte{3, 0, 0, 0x30, 0, 0, 0, 0, 0}; string(out) != string(want) {
+			t.Errorf("variable paint %v, want %v", out, want)
+		}
+		if len(w.varEntries) != 1 || w.varEntries[0] != 0 {
+			t.Errorf("delta sets %v, want [0]", w.varEntries)
+		}
+	}
+}
+
+func TestCOLRWriterVarTransform(t *testing.T) {
+	// A PaintVarTransform of a PaintSolid whose VarAffine2x3 scales x by
+	// 1 + 1/16 towards the axis maximum. PaintVarTransform has no
+	// varIndexBase of its own.
+	regions := buildRegionList(1, [][]ot.RegionAxisCoordinates{{{Start: 0, Peak: 0x4000, End: 0x4000}}})
+	store := appendUint16s(nil, 1, 0, 12, 1)
+	store = binary.BigEndian.AppendUint32(store, uint32(12+len(regions)))
+	store = append(append(store, regions...), buildVarData([]uint16{0}, [][]int32{{0x1000}, {0}, {0}, {0}, {0}, {0}})...)
+	varStore, err := ot.ParseItemVariationStore(store)
+	if err != nil {
+		t.Fatalf("ParseItemVariationStore: %v", err)
+	}
+	data := append(make([]byte, 34), 13, 0, 0, 7, 0, 0, 12) // at 34
+	data = append(data, 2, 0, 0, 0x40, 0)                   // at 41
+	for _, v := range []uint32{0x10000, 0, 0, 0x10000, 0, 0, 0} {
+		data = binary.BigEndian.AppendUint32(data, v) // at 46
+	}
+
+	for _, static := range []bool{false, true} {
+		p := &Plan{
+			colrPalettes: map[uint16]uint16{0: 0},
+			colr:         &colrTable{data: data, varStore: varStore},
+			input:        NewInput(),
+		}
+		if static {
+			p.input.PinAxisLocation(ot.TagAxisWeight, 900)
+			p.instancedAdvances = map[ot.GlyphID]uint16{}
+			p.normalizedCoords = []int{0x4000}
+		}
+		w := p.newCOLRWriter()
+		out, err := w.layout([]int{w.paint(34, 0)})
+		if err != nil {
+			t.Fatalf("layout: %v", err)
+		}
+		format, size, xx := byte(13), 7+5+28, uint32(0x10000)
+		if static {
+			format, size, xx = 12, 7+5+24, 0x11000
+		}
+		if len(out) != size || out[0] != format {
+			t.Fatalf("static %v: format %d with %d bytes, want %d with %d", static, out[0], len(out), format, size)
+		}
+		affine := w.objects[w.index[46]]
+		if got := binary.BigEndian.Uint32(out[affine.pos:]); got != xx {
+			t.Errorf("static %v: xx %#x, want %#x", static, got, xx)
+		}
+		if static {
+			continue
+		}
+		if got := binary.BigEndian.Uint32(out[affine.pos+24:]); got != 0 {
+			t.Errorf("varIndexBase %d, want 0", got)
+		}
+		if len(w.varEntries) != 6 {
+			t.Errorf("delta sets %v, want 6", w.varEntries)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package subset
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/boxesandglue/textshape/ot"
+)
 
 var (
 	// ErrNoTables is returned when building a font with no tables.
@@ -11,4 +15,22 @@ var (
 
 	// ErrInvalidGlyph is returned for invalid glyph references.
 	ErrInvalidGlyph = errors.New("subset: invalid glyph reference")
+
+	// ErrOffsetOverflow is returned when a subset table is too large for
+	// its 16-bit offsets.
+	ErrOffsetOverflow = errors.New("subset: offset overflow")
 )
+
+// TableError is returned by Plan.Execute when a table cannot be subset.
+type TableError struct {
+	Tag ot.Tag
+	Err error
+}
+
+func (e *TableError) Error() string {
+	return "subset: " + e.Tag.String() + ": " + e.Err.Error()
+}
+
+func (e *TableError) Unwrap() error {
+	return e.Err
+}
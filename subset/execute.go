@@ -2,20 +2,38 @@ package subset
 
 import (
 	"encoding/binary"
-	"sort"
+	"maps"
+	"slices"
 
 	"github.com/boxesandglue/textshape/ot"
 )
 
-// cmapMapping is used for cmap construction
-type cmapMapping struct {
-	cp  rune
-	gid ot.GlyphID
-}
-
 // Execute performs the subsetting operation and returns the new font data.
+// A table that fails to parse or subset is returned as a *TableError,
+// unless FlagLenient is set; then the table is dropped and listed in
+// Report.
 func (p *Plan) Execute() ([]byte, error) {
 	builder := NewFontBuilder()
+	p.report.Tables, p.report.Dropped, p.report.SkippedLookups = nil, nil, nil
+	p.layoutVars = nil
+
+	// Tables that failed to parse fail like tables that fail to subset
+	for _, tag := range slices.Sorted(maps.Keys(p.parseErrs)) {
+		if !p.needsTable(tag) {
+			continue
+		}
+		if err := p.addTable(builder, tag, nil, p.parseErrs[tag]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Subset glyf/loca if present (TrueType). The loca format goes to
+	// head.
+	if p.source.HasTable(ot.TagGlyf) {
+		if err := p.subsetGlyf(builder); err != nil {
+			return nil, err
+		}
+	}
 
 	// Subset required tables
 	if err := p.subsetHead(builder); err != nil {
@@ -24,24 +42,28 @@ func (p *Plan) Execute() ([]byte, error) {
 	if err := p.subsetMaxp(builder); err != nil {
 		return nil, err
 	}
-	if err := p.subsetHhea(builder); err != nil {
+	// numberOfHMetrics follows the compacted hmtx
+	if err := p.subsetHmtx(builder); err != nil {
 		return nil, err
 	}
-	if err := p.subsetHmtx(builder); err != nil {
+	if err := p.subsetHhea(builder); err != nil {
 		return nil, err
 	}
 
-	// Subset glyf/loca if present (TrueType)
-	if p.source.HasTable(ot.TagGlyf) {
-		if err := p.subsetGlyf(builder); err != nil {
-			return nil, err
-		}
+	// Vertical metrics (optional)
+	if p.vmtx != nil {
+		p.subsetVmtx(builder)
+		p.subsetVhea(builder)
+	}
+	if p.source.HasTable(ot.TagVORG) && !p.input.ShouldDropTable(ot.TagVORG) {
+		p.subsetVORG(builder)
 	}
 
 	// Subset CFF if present (OpenType/CFF)
 	if p.source.HasTable(ot.TagCFF) && p.cff != nil {
-		if cffData, err := p.subsetCFF(); err == nil && cffData != nil {
-			builder.AddTable(ot.TagCFF, cffData)
+		data, err := p.subsetCFF()
+		if err := p.addTable(builder, ot.TagCFF, data, err); err != nil {
+			return nil, err
 		}
 	}
 
@@ -50,38 +72,94 @@ func (p *Plan) Execute() ([]byte, error) {
 		return nil, err
 	}
 
-	// Subset GSUB/GPOS/GDEF unless FlagDropLayoutTables is set
+	// Subset GSUB/GPOS unless FlagDropLayoutTables is set
 	// (For PDF embedding, these tables are not needed since shaping is already done)
 	if p.input.Flags&FlagDropLayoutTables == 0 {
 		// Subset GSUB (with glyph ID remapping)
-		if p.gsub != nil {
-			if gsubData, err := p.subsetGSUB(); err == nil && gsubData != nil {
-				builder.AddTable(ot.TagGSUB, gsubData)
+		if p.gsub != nil && !p.input.ShouldDropTable(ot.TagGSUB) {
+			data, err := p.subsetGSUB()
+			if err := p.addTable(builder, ot.TagGSUB, data, err); err != nil {
+				return nil, err
 			}
 		}
 
 		// Subset GPOS (with glyph ID remapping)
-		if p.gpos != nil {
-			if gposData, err := p.subsetGPOS(); err == nil && gposData != nil {
-				builder.AddTable(ot.TagGPOS, gposData)
+		if p.gpos != nil && !p.input.ShouldDropTable(ot.TagGPOS) {
+			data, err := p.subsetGPOS()
+			if err := p.addTable(builder, ot.TagGPOS, data, err); err != nil {
+				return nil, err
 			}
 		}
+	}
 
-		// Subset GDEF (with glyph ID remapping)
-		if p.gdef != nil {
-			if gdefData, err := p.subsetGDEF(); err == nil && gdefData != nil {
-				builder.AddTable(ot.TagGDEF, gdefData)
-			}
+	// Copy or subset optional tables
+	if err := p.handleOptionalTables(builder); err != nil {
+		return nil, err
+	}
+
+	// Subset GDEF (with glyph ID remapping) last: its item variation
+	// store holds the delta sets of the device tables written above
+	if p.input.Flags&FlagDropLayoutTables == 0 && p.gdef != nil && !p.input.ShouldDropTable(ot.TagGDEF) {
+		data, err := p.subsetGDEF()
+		if err := p.addTable(builder, ot.TagGDEF, data, err); err != nil {
+			return nil, err
 		}
 	}
 
-	// Copy or subset optional tables
-	p.handleOptionalTables(builder)
+	// The metrics tables hold the values at the new default location
+	if p.partial != nil {
+		p.applyMVARDeltas(builder)
+	} else if p.IsInstanced() {
+		p.applyMVARDeltas(builder)
+		if err := p.applyInstanceStyle(builder); err != nil {
+			return nil, err
+		}
+	}
 
+	p.finishReport(builder)
 	return builder.Build()
 }
 
-// subsetHead subsets the head table.
+// needsTable reports whether a table that failed to parse would be
+// written. Dropped tables, a MATH table passed through and the variation
+// tables of a static instance are not.
+func (p *Plan) needsTable(tag ot.Tag) bool {
+	switch {
+	case p.input.ShouldDropTable(tag):
+		return false
+	case tag == ot.TagGSUB || tag == ot.TagGPOS || tag == ot.TagGDEF:
+		return p.input.Flags&FlagDropLayoutTables == 0
+	case tag == ot.TagMATH:
+		return !p.input.ShouldPassThrough(tag)
+	case slices.Contains(variationTables, tag):
+		return !p.IsInstanced()
+	}
+	return true
+}
+
+// addTable adds the subset data of a table. A subsetting error is
+// returned as a *TableError, or with FlagLenient the table is dropped
+// and reported. Tables without data are dropped as empty.
+func (p *Plan) addTable(builder *FontBuilder, tag ot.Tag, data []byte, err error) error {
+	if err != nil {
+		if p.input.Flags&FlagLenient == 0 {
+			return &TableError{Tag: tag, Err: err}
+		}
+		p.report.drop(tag, DropFailed, err)
+		return nil
+	}
+	if data == nil {
+		p.report.drop(tag, DropEmpty, nil)
+		return nil
+	}
+	builder.AddTable(tag, data)
+	return nil
+}
+
+// subsetHead subsets the head table. The font bounding box is
+// recomputed from the output glyphs and indexToLocFormat follows the
+// output loca table.
+// HarfBuzz equivalent: head::subset (hb-ot-head-table.hh)
 func (p *Plan) subsetHead(builder *FontBuilder) error {
 	data, err := p.source.TableData(ot.TagHead)
 	if err != nil {
@@ -91,16 +169,34 @@ func (p *Plan) subsetHead(builder *FontBuilder) error {
 	// head is 54 bytes, copy it unchanged (checksumAdjustment will be fixed later)
 	newData := make([]byte, len(data))
 	copy(newData, data)
+	if len(newData) < 54 {
+		builder.AddTable(ot.TagHead, newData)
+		return nil
+	}
+
+	if s := p.glyphStats(); s.hasOutlines {
+		binary.BigEndian.PutUint16(newData[36:], uint16(s.bbox.XMin))
+		binary.BigEndian.PutUint16(newData[38:], uint16(s.bbox.YMin))
+		binary.BigEndian.PutUint16(newData[40:], uint16(s.bbox.XMax))
+		binary.BigEndian.PutUint16(newData[42:], uint16(s.bbox.YMax))
+	}
 
-	// Update indexToLocFormat based on output loca format
-	// We'll use long format (1) by default for simplicity
-	binary.BigEndian.PutUint16(newData[50:], 1)
+	// Short loca offsets take two bytes per glyph
+	if loca, ok := builder.tables[ot.TagLoca]; ok {
+		format := uint16(1)
+		if len(loca) == 2*(p.numOutputGlyphs+1) {
+			format = 0
+		}
+		binary.BigEndian.PutUint16(newData[50:], format)
+	}
 
 	builder.AddTable(ot.TagHead, newData)
 	return nil
 }
 
-// subsetMaxp subsets the maxp table.
+// subsetMaxp subsets the maxp table. The outline limits of version 1.0
+// are recomputed from the output glyphs.
+// HarfBuzz equivalent: maxp::subset (hb-ot-maxp-table.hh)
 func (p *Plan) subsetMaxp(builder *FontBuilder) error {
 	data, err := p.source.TableData(ot.TagMaxp)
 	if err != nil {
@@ -113,11 +209,38 @@ func (p *Plan) subsetMaxp(builder *FontBuilder) error {
 	// Update numGlyphs
 	binary.BigEndian.PutUint16(newData[4:], uint16(p.numOutputGlyphs))
 
+	if binary.BigEndian.Uint32(newData) != 0x00010000 || len(newData) < 32 {
+		builder.AddTable(ot.TagMaxp, newData)
+		return nil
+	}
+
+	if p.glyf != nil {
+		s := p.glyphStats()
+		binary.BigEndian.PutUint16(newData[6:], s.maxPoints)
+		binary.BigEndian.PutUint16(newData[8:], s.maxContours)
+		binary.BigEndian.PutUint16(newData[10:], s.maxCompositePoints)
+		binary.BigEndian.PutUint16(newData[12:], s.maxCompositeContours)
+		binary.BigEndian.PutUint16(newData[28:], s.maxComponentElements)
+		binary.BigEndian.PutUint16(newData[30:], s.maxComponentDepth)
+	}
+
+	// Without hinting the TrueType interpreter limits are unused.
+	// HarfBuzz equivalent: maxp::drop_hint_fields (hb-ot-maxp-table.hh)
+	if p.input.Flags&FlagNoHinting != 0 {
+		binary.BigEndian.PutUint16(newData[14:], 1) // maxZones
+		// maxTwilightPoints through maxSizeOfInstructions
+		for off := 16; off < 28; off += 2 {
+			binary.BigEndian.PutUint16(newData[off:], 0)
+		}
+	}
+
 	builder.AddTable(ot.TagMaxp, newData)
 	return nil
 }
 
-// subsetHhea subsets the hhea table.
+// subsetHhea subsets the hhea table. The extrema of the horizontal
+// metrics are recomputed from the output glyphs.
+// HarfBuzz equivalent: hmtxvmtx::subset_update_header (hb-ot-hmtx-table.hh)
 func (p *Plan) subsetHhea(builder *FontBuilder) error {
 	data, err := p.source.TableData(ot.TagHhea)
 	if err != nil {
@@ -127,8 +250,16 @@ func (p *Plan) subsetHhea(builder *FontBuilder) error {
 	newData := make([]byte, len(data))
 	copy(newData, data)
 
-	// Update numberOfHMetrics (we'll have one per glyph for simplicity)
-	binary.BigEndian.PutUint16(newData[34:], uint16(p.numOutputGlyphs))
+	// Update numberOfHMetrics
+	binary.BigEndian.PutUint16(newData[34:], uint16(numLongMetrics(builder.tables[ot.TagHmtx], p.numOutputGlyphs)))
+
+	s := p.glyphStats()
+	binary.BigEndian.PutUint16(newData[10:], s.advanceWidthMax)
+	if s.hasOutlines {
+		binary.BigEndian.PutUint16(newData[12:], uint16(s.minLeftSideBearing))
+		binary.BigEndian.PutUint16(newData[14:], uint16(s.minRightSideBearing))
+		binary.BigEndian.PutUint16(newData[16:], uint16(s.xMaxExtent))
+	}
 
 	builder.AddTable(ot.TagHhea, newData)
 	return nil
@@ -141,9 +272,8 @@ func (p *Plan) subsetHmtx(builder *FontBuilder) error {
 		return ErrMissingTable
 	}
 
-	// Build new hmtx with one longHorMetric per glyph
-	newData := make([]byte, p.numOutputGlyphs*4)
-
+	advances := make([]uint16, p.numOutputGlyphs)
+	lsbs := make([]int16, p.numOutputGlyphs)
 	for newGID := 0; newGID < p.numOutputGlyphs; newGID++ {
 		oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]
 		if !ok {
@@ -152,23 +282,47 @@ func (p *Plan) subsetHmtx(builder *FontBuilder) error {
 		}
 
 		// Use instanced advance if available (includes HVAR deltas)
-		var advance uint16
-		if p.IsInstanced() {
-			advance = p.GetInstancedAdvance(oldGID)
+		if p.instancedAdvances != nil {
+			advances[newGID] = p.GetInstancedAdvance(oldGID)
 		} else {
-			advance = p.hmtx.GetAdvanceWidth(oldGID)
+			advances[newGID] = p.hmtx.GetAdvanceWidth(oldGID)
+		}
+		_, lsbs[newGID] = p.hmtx.GetMetrics(oldGID)
+		if ig, ok := p.instancedGlyphs[oldGID]; ok {
+			lsbs[newGID] = ig.lsb
 		}
-		_, lsb := p.hmtx.GetMetrics(oldGID)
-
-		off := newGID * 4
-		binary.BigEndian.PutUint16(newData[off:], advance)
-		binary.BigEndian.PutUint16(newData[off+2:], uint16(lsb))
 	}
 
-	builder.AddTable(ot.TagHmtx, newData)
+	builder.AddTable(ot.TagHmtx, buildLongMetrics(advances, lsbs))
 	return nil
 }
 
+// buildLongMetrics builds an hmtx or vmtx table. The trailing run of
+// equal advances is stored once; the remaining glyphs only get their side
+// bearings.
+// HarfBuzz equivalent: hmtxvmtx::serialize (hb-ot-hmtx-table.hh)
+func buildLongMetrics(advances []uint16, bearings []int16) []byte {
+	numLong := len(advances)
+	for numLong > 1 && advances[numLong-2] == advances[numLong-1] {
+		numLong--
+	}
+
+	data := make([]byte, 0, 4*numLong+2*(len(advances)-numLong))
+	for i := range advances {
+		if i < numLong {
+			data = binary.BigEndian.AppendUint16(data, advances[i])
+		}
+		data = binary.BigEndian.AppendUint16(data, uint16(bearings[i]))
+	}
+	return data
+}
+
+// numLongMetrics returns the number of long metrics of an hmtx or vmtx
+// table built by buildLongMetrics for numGlyphs glyphs.
+func numLongMetrics(data []byte, numGlyphs int) int {
+	return len(data)/2 - numGlyphs
+}
+
 // subsetGlyf subsets the glyf and loca tables.
 // When instancing (axes are pinned), gvar deltas are applied to glyph outlines.
 func (p *Plan) subsetGlyf(builder *FontBuilder) error {
@@ -194,22 +348,13 @@ func (p *Plan) subsetGlyf(builder *FontBuilder) error {
 			continue
 		}
 
-		// Apply gvar deltas when instancing
-		if p.IsInstanced() && len(glyphBytes) >= 10 {
-			numberOfContours := int16(glyphBytes[0])<<8 | int16(glyphBytes[1])
-			if numberOfContours > 0 {
-				// Simple glyph - parse points and apply deltas
-				points, _, err := ot.ParseSimpleGlyph(glyphBytes)
-				if err == nil && len(points) > 0 {
-					// Use GetGlyphDeltasWithCoords for proper IUP interpolation
-					xDeltas, yDeltas := p.GetGlyphDeltasWithCoords(oldGID, len(points), points)
-					if xDeltas != nil && yDeltas != nil {
-						glyphBytes = ot.InstanceSimpleGlyph(glyphBytes, xDeltas, yDeltas)
-					}
-				}
-			}
-			// Composite glyphs: component transforms may need adjustment
-			// but for now we just pass them through unchanged
+		// Use the outline with gvar deltas applied when instancing
+		if ig, ok := p.instancedGlyphs[oldGID]; ok {
+			glyphBytes = ig.data
+		}
+
+		if p.input.Flags&FlagNoHinting != 0 {
+			glyphBytes = ot.DropGlyphInstructions(glyphBytes)
 		}
 
 		// Remap composite glyph component IDs
@@ -224,8 +369,9 @@ func (p *Plan) subsetGlyf(builder *FontBuilder) error {
 	}
 	offsets[p.numOutputGlyphs] = uint32(len(glyfData))
 
-	// Build loca table (long format)
-	locaData := ot.BuildLoca(offsets, false)
+	// Short offsets hold half the offset in 16 bits; glyphs are padded to
+	// even lengths above.
+	locaData := ot.BuildLoca(offsets, len(glyfData) <= 2*0xFFFF)
 
 	builder.AddTable(ot.TagGlyf, glyfData)
 	builder.AddTable(ot.TagLoca, locaData)
@@ -233,307 +379,222 @@ func (p *Plan) subsetGlyf(builder *FontBuilder) error {
 	return nil
 }
 
-// subsetCmap subsets the cmap table.
-func (p *Plan) subsetCmap(builder *FontBuilder) error {
-	if p.cmap == nil || len(p.unicodeMap) == 0 {
-		return nil
-	}
-
-	// Build a simple format 4 cmap for BMP characters
-	// and format 12 for characters outside BMP
-
-	// Collect mappings
-	var mappings []cmapMapping
-	for cp, gid := range p.unicodeMap {
-		mappings = append(mappings, cmapMapping{cp, gid})
-	}
-	sort.Slice(mappings, func(i, j int) bool { return mappings[i].cp < mappings[j].cp })
-
-	// Check if we need format 12 (characters > 0xFFFF)
-	needsFormat12 := false
-	for _, m := range mappings {
-		if m.cp > 0xFFFF {
-			needsFormat12 = true
-			break
-		}
-	}
-
-	var cmapData []byte
-
-	if needsFormat12 {
-		// Build format 12 cmap
-		cmapData = p.buildCmapFormat12(mappings)
-	} else {
-		// Build format 4 cmap (simpler, sufficient for BMP)
-		cmapData = p.buildCmapFormat4(mappings)
-	}
-
-	builder.AddTable(ot.TagCmap, cmapData)
-	return nil
+// variationTables are the tables of a variable font.
+var variationTables = []ot.Tag{
+	ot.TagFvar,
+	ot.TagAvar,
+	ot.TagHvar,
+	ot.TagVvar,
+	ot.TagGvar,
+	ot.TagSTAT,
+	ot.TagMvar,
+	ot.TagCvar,
 }
 
-// buildCmapFormat4 builds a format 4 cmap subtable for BMP characters.
-func (p *Plan) buildCmapFormat4(mappings []cmapMapping) []byte {
-	// Group consecutive mappings with same delta
-	type segment struct {
-		startCode uint16
-		endCode   uint16
-		delta     int16
-		idOffset  uint16
-	}
-
-	var segments []segment
-	var glyphIDs []uint16
-
-	i := 0
-	for i < len(mappings) {
-		start := mappings[i]
-		if start.cp > 0xFFFF {
-			i++
+// handleOptionalTables copies, subsets or drops the optional tables.
+func (p *Plan) handleOptionalTables(builder *FontBuilder) error {
+	// Hinting tables - required by PDF spec for TrueType fonts. The
+	// instruction tables are copied unless FlagNoHinting is set (see
+	// Input.ShouldDropTable); cvar and gasp are handled below with the
+	// variation and optional tables; hdmx, LTSH and VDMX are dropped.
+	for _, tag := range slices.Sorted(maps.Keys(hintingTables)) {
+		if tag != ot.TagCvt && tag != ot.TagFpgm && tag != ot.TagPrep || p.input.ShouldDropTable(tag) {
 			continue
 		}
-
-		// Try to build a segment with constant delta
-		delta := int(start.gid) - int(start.cp)
-		end := start
-
-		j := i + 1
-		for j < len(mappings) {
-			next := mappings[j]
-			if next.cp > 0xFFFF {
-				break
-			}
-			if int(next.gid)-int(next.cp) != delta || int(next.cp) != int(end.cp)+1 {
-				break
+		if p.source.HasTable(tag) {
+			if data, err := p.source.TableData(tag); err == nil {
+				builder.AddTable(tag, data)
 			}
-			end = next
-			j++
 		}
-
-		segments = append(segments, segment{
-			startCode: uint16(start.cp),
-			endCode:   uint16(end.cp),
-			delta:     int16(delta),
-			idOffset:  0,
-		})
-
-		i = j
-	}
-
-	// Add terminating segment
-	segments = append(segments, segment{
-		startCode: 0xFFFF,
-		endCode:   0xFFFF,
-		delta:     1,
-		idOffset:  0,
-	})
-
-	segCount := len(segments)
-	segCountX2 := segCount * 2
-
-	// Calculate search params
-	searchRange := 2
-	entrySelector := 0
-	for searchRange*2 <= segCountX2 {
-		searchRange *= 2
-		entrySelector++
-	}
-	rangeShift := segCountX2 - searchRange
-
-	// Build format 4 subtable
-	headerLen := 16 + segCount*8 + len(glyphIDs)*2
-	subtableLen := headerLen
-
-	subtable := make([]byte, subtableLen)
-	binary.BigEndian.PutUint16(subtable[0:], 4)                      // format
-	binary.BigEndian.PutUint16(subtable[2:], uint16(subtableLen))    // length
-	binary.BigEndian.PutUint16(subtable[4:], 0)                      // language
-	binary.BigEndian.PutUint16(subtable[6:], uint16(segCountX2))     // segCountX2
-	binary.BigEndian.PutUint16(subtable[8:], uint16(searchRange))    // searchRange
-	binary.BigEndian.PutUint16(subtable[10:], uint16(entrySelector)) // entrySelector
-	binary.BigEndian.PutUint16(subtable[12:], uint16(rangeShift))    // rangeShift
-
-	// endCode array
-	off := 14
-	for _, seg := range segments {
-		binary.BigEndian.PutUint16(subtable[off:], seg.endCode)
-		off += 2
-	}
-
-	// reservedPad
-	binary.BigEndian.PutUint16(subtable[off:], 0)
-	off += 2
-
-	// startCode array
-	for _, seg := range segments {
-		binary.BigEndian.PutUint16(subtable[off:], seg.startCode)
-		off += 2
 	}
 
-	// idDelta array
-	for _, seg := range segments {
-		binary.BigEndian.PutUint16(subtable[off:], uint16(seg.delta))
-		off += 2
-	}
-
-	// idRangeOffset array
-	for _, seg := range segments {
-		binary.BigEndian.PutUint16(subtable[off:], seg.idOffset)
-		off += 2
-	}
-
-	// Build cmap header
-	cmapLen := 4 + 8 + len(subtable) // header + 1 encoding record + subtable
-	cmap := make([]byte, cmapLen)
-
-	binary.BigEndian.PutUint16(cmap[0:], 0) // version
-	binary.BigEndian.PutUint16(cmap[2:], 1) // numTables
-
-	// Encoding record (platform 3, encoding 1 = Windows Unicode BMP)
-	binary.BigEndian.PutUint16(cmap[4:], 3)  // platformID
-	binary.BigEndian.PutUint16(cmap[6:], 1)  // encodingID
-	binary.BigEndian.PutUint32(cmap[8:], 12) // offset to subtable
-
-	// Copy subtable
-	copy(cmap[12:], subtable)
-
-	return cmap
-}
-
-// buildCmapFormat12 builds a format 12 cmap subtable.
-func (p *Plan) buildCmapFormat12(mappings []cmapMapping) []byte {
-	// Group consecutive mappings
-	type group struct {
-		startChar  uint32
-		endChar    uint32
-		startGlyph uint32
+	// Subset OS/2 if present (important for metrics)
+	if !p.input.ShouldDropTable(ot.TagOS2) && p.source.HasTable(ot.TagOS2) {
+		p.subsetOS2(builder)
 	}
 
-	var groups []group
-
-	i := 0
-	for i < len(mappings) {
-		start := mappings[i]
-		startGlyph := uint32(start.gid)
-		endChar := start.cp
-
-		j := i + 1
-		for j < len(mappings) {
-			next := mappings[j]
-			if int(next.cp) != int(endChar)+1 || uint32(next.gid) != startGlyph+uint32(j-i) {
-				break
+	// Variation tables - drop when instanced (axes pinned), otherwise keep
+	if p.partial != nil {
+		// Partially instanced: the variation tables are re-expressed over
+		// the restricted axis ranges. cvar is dropped like the hinting
+		// variations of HarfBuzz's instancer.
+		for _, tag := range variationTables {
+			if p.input.ShouldDropTable(tag) || !p.source.HasTable(tag) || p.parseErrs[tag] != nil {
+				continue
+			}
+			var data []byte
+			var err error
+			switch tag {
+			case ot.TagFvar:
+				data, err = p.instanceFvar()
+			case ot.TagAvar:
+				data, err = p.instanceAvar()
+			case ot.TagSTAT:
+				data, err = p.instanceSTAT()
+			case ot.TagGvar:
+				data, err = p.instanceGvar()
+			case ot.TagHvar:
+				data, err = p.subsetMetricsVar(tag, 3)
+			case ot.TagVvar:
+				data, err = p.subsetMetricsVar(tag, 4)
+			case ot.TagMvar:
+				data, err = p.instanceMVAR()
+			}
+			if err := p.addTable(builder, tag, data, err); err != nil {
+				return err
+			}
+		}
+	} else if !p.IsInstanced() {
+		// Keep variation tables if not instancing, so that the subset
+		// stays variable. Glyph-keyed tables are subset to the new glyph
+		// IDs; the others are glyph independent. MVAR and cvar are only
+		// copied on request.
+		for _, tag := range variationTables {
+			if p.input.ShouldDropTable(tag) || !p.source.HasTable(tag) || p.parseErrs[tag] != nil {
+				continue
+			}
+			if (tag == ot.TagMvar || tag == ot.TagCvar) &&
+				!p.input.ShouldPassThrough(tag) && p.input.Flags&FlagPassUnrecognized == 0 {
+				continue
+			}
+			var data []byte
+			var err error
+			switch tag {
+			case ot.TagGvar:
+				data, err = p.subsetGvar()
+			case ot.TagHvar:
+				data, err = p.subsetMetricsVar(tag, 3)
+			case ot.TagVvar:
+				data, err = p.subsetMetricsVar(tag, 4)
+			default:
+				data, err = p.source.TableData(tag)
+			}
+			if err := p.addTable(builder, tag, data, err); err != nil {
+				return err
 			}
-			endChar = next.cp
-			j++
 		}
-
-		groups = append(groups, group{
-			startChar:  uint32(start.cp),
-			endChar:    uint32(endChar),
-			startGlyph: startGlyph,
-		})
-
-		i = j
-	}
-
-	// Build format 12 subtable
-	subtableLen := 16 + len(groups)*12
-
-	subtable := make([]byte, subtableLen)
-	binary.BigEndian.PutUint16(subtable[0:], 12)                   // format
-	binary.BigEndian.PutUint16(subtable[2:], 0)                    // reserved
-	binary.BigEndian.PutUint32(subtable[4:], uint32(subtableLen))  // length
-	binary.BigEndian.PutUint32(subtable[8:], 0)                    // language
-	binary.BigEndian.PutUint32(subtable[12:], uint32(len(groups))) // numGroups
-
-	off := 16
-	for _, g := range groups {
-		binary.BigEndian.PutUint32(subtable[off:], g.startChar)
-		binary.BigEndian.PutUint32(subtable[off+4:], g.endChar)
-		binary.BigEndian.PutUint32(subtable[off+8:], g.startGlyph)
-		off += 12
 	}
+	// When instanced, variation tables are dropped (not copied)
 
-	// Build cmap header
-	cmapLen := 4 + 8 + len(subtable)
-	cmap := make([]byte, cmapLen)
-
-	binary.BigEndian.PutUint16(cmap[0:], 0) // version
-	binary.BigEndian.PutUint16(cmap[2:], 1) // numTables
-
-	// Encoding record (platform 3, encoding 10 = Windows Unicode full)
-	binary.BigEndian.PutUint16(cmap[4:], 3)  // platformID
-	binary.BigEndian.PutUint16(cmap[6:], 10) // encodingID
-	binary.BigEndian.PutUint32(cmap[8:], 12) // offset to subtable
-
-	copy(cmap[12:], subtable)
-
-	return cmap
-}
-
-// handleOptionalTables copies or drops optional tables.
-func (p *Plan) handleOptionalTables(builder *FontBuilder) {
-	// Hinting tables - required by PDF spec for TrueType fonts.
-	// Copy by default unless FlagNoHinting is set.
-	if p.input.Flags&FlagNoHinting == 0 {
-		hintingTables := []ot.Tag{
-			ot.TagCvt,
-			ot.TagFpgm,
-			ot.TagPrep,
-		}
-		for _, tag := range hintingTables {
-			if p.input.ShouldDropTable(tag) {
+	// Color tables. CPAL is subset along with COLR; without COLR it is
+	// only copied on request.
+	if p.colr != nil {
+		for _, tag := range []ot.Tag{ot.TagCOLR, ot.TagCPAL} {
+			if p.input.ShouldDropTable(tag) || !p.source.HasTable(tag) {
 				continue
 			}
-			if p.source.HasTable(tag) {
+			if p.input.ShouldPassThrough(tag) {
 				if data, err := p.source.TableData(tag); err == nil {
 					builder.AddTable(tag, data)
 				}
+				continue
+			}
+			var data []byte
+			var err error
+			if tag == ot.TagCOLR {
+				data, err = p.subsetCOLR()
+			} else {
+				data, err = p.subsetCPAL()
+			}
+			if err := p.addTable(builder, tag, data, err); err != nil {
+				return err
 			}
 		}
 	}
 
-	// Copy OS/2 if present (important for metrics)
-	if !p.input.ShouldDropTable(ot.TagOS2) && p.source.HasTable(ot.TagOS2) {
-		if data, err := p.source.TableData(ot.TagOS2); err == nil {
-			builder.AddTable(ot.TagOS2, data)
+	// Bitmap and SVG color glyphs. CBLC and CBDT are subset together.
+	if !p.input.ShouldDropTable(ot.TagCBLC) && !p.input.ShouldDropTable(ot.TagCBDT) &&
+		p.source.HasTable(ot.TagCBLC) && p.source.HasTable(ot.TagCBDT) {
+		if p.input.ShouldPassThrough(ot.TagCBLC) || p.input.ShouldPassThrough(ot.TagCBDT) {
+			for _, tag := range []ot.Tag{ot.TagCBLC, ot.TagCBDT} {
+				if data, err := p.source.TableData(tag); err == nil {
+					builder.AddTable(tag, data)
+				}
+			}
+		} else {
+			cblc, cbdt, err := p.subsetCBLC()
+			if err := p.addTable(builder, ot.TagCBLC, cblc, err); err != nil {
+				return err
+			}
+			if err := p.addTable(builder, ot.TagCBDT, cbdt, err); err != nil {
+				return err
+			}
 		}
 	}
-
-	// Variation tables - drop when instanced (axes pinned), otherwise pass through
-	variationTables := []ot.Tag{
-		ot.TagFvar,
-		ot.TagAvar,
-		ot.TagHvar,
-		ot.TagVvar,
-		ot.TagGvar,
-		ot.TagSTAT,
-		ot.TagMvar,
-		ot.TagCvar,
+	for _, tag := range []ot.Tag{ot.TagSbix, ot.TagSVG} {
+		if p.input.ShouldDropTable(tag) || !p.source.HasTable(tag) {
+			continue
+		}
+		if p.input.ShouldPassThrough(tag) {
+			if data, err := p.source.TableData(tag); err == nil {
+				builder.AddTable(tag, data)
+			}
+			continue
+		}
+		var data []byte
+		var err error
+		if tag == ot.TagSbix {
+			data, err = p.subsetSbix()
+		} else {
+			data, err = p.subsetSVG()
+		}
+		if err := p.addTable(builder, tag, data, err); err != nil {
+			return err
+		}
 	}
-	if !p.IsInstanced() {
-		// Keep variation tables if not instancing
-		for _, tag := range variationTables {
-			if p.input.ShouldDropTable(tag) {
-				continue
+
+	// Math layout, legacy kerning and baselines
+	for _, tag := range []ot.Tag{ot.TagMATH, ot.TagKernTable, ot.TagBASE} {
+		if p.input.ShouldDropTable(tag) || !p.source.HasTable(tag) {
+			continue
+		}
+		if p.input.ShouldPassThrough(tag) {
+			if data, err := p.source.TableData(tag); err == nil {
+				builder.AddTable(tag, data)
 			}
-			if p.input.ShouldPassThrough(tag) || p.input.Flags&FlagPassUnrecognized != 0 {
-				if p.source.HasTable(tag) {
-					if data, err := p.source.TableData(tag); err == nil {
-						builder.AddTable(tag, data)
-					}
-				}
+			continue
+		}
+		if p.parseErrs[tag] != nil {
+			continue
+		}
+		var data []byte
+		var err error
+		switch tag {
+		case ot.TagMATH:
+			data, err = p.subsetMATH()
+		case ot.TagKernTable:
+			data, err = p.subsetKern()
+		case ot.TagBASE:
+			data, err = p.subsetBASE()
+		}
+		if err := p.addTable(builder, tag, data, err); err != nil {
+			return err
+		}
+	}
+
+	// name and post are subset unless passed through
+	for _, tag := range []ot.Tag{ot.TagName, ot.TagPost} {
+		if p.input.ShouldDropTable(tag) || !p.source.HasTable(tag) {
+			continue
+		}
+		if p.input.ShouldPassThrough(tag) {
+			if data, err := p.source.TableData(tag); err == nil {
+				builder.AddTable(tag, data)
 			}
+		} else if tag == ot.TagName {
+			p.subsetName(builder)
+		} else {
+			p.subsetPost(builder)
 		}
 	}
-	// When instanced, variation tables are dropped (not copied)
 
 	// Optional tables - only copy if explicitly requested or FlagPassUnrecognized is set
 	optionalTables := []ot.Tag{
-		ot.TagName,
-		ot.TagPost,
 		ot.TagGasp,
 	}
+	if p.colr == nil {
+		optionalTables = append(optionalTables, ot.TagCPAL)
+	}
 	for _, tag := range optionalTables {
 		if p.input.ShouldDropTable(tag) {
 			continue
@@ -546,6 +607,7 @@ func (p *Plan) handleOptionalTables(builder *FontBuilder) {
 	}
 
 	// GPOS and GDEF are now subsetted with glyph remapping in Execute()
+	return nil
 }
 
 // Subset is a convenience function that subsets a font for given codepoints.
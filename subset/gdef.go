@@ -7,21 +7,46 @@ import (
 	"github.com/boxesandglue/textshape/ot"
 )
 
-// subsetGDEF creates a subsetted GDEF table with remapped glyph IDs.
+// subsetGDEF creates a subsetted GDEF table with remapped glyph IDs. It
+// runs after GPOS: the item variation store keeps the delta sets that
+// the device tables of the subset reference.
 func (p *Plan) subsetGDEF() ([]byte, error) {
 	if p.gdef == nil {
 		return nil, nil
 	}
 
 	builder := newGDEFBuilder(p.glyphMap, p.glyphSet, p.gdef)
+	builder.deviceDelta = p.layoutDeviceDelta()
+	builder.vars = p.layoutVarStore()
 	return builder.build()
 }
 
+// layoutVarStore returns the builder of the subset GDEF item variation
+// store, shared by all tables whose VariationIndex device tables refer
+// to it. It is nil if the subset is static, GDEF has no store or is
+// dropped.
+// HarfBuzz equivalent: hb_subset_plan_t::layout_variation_indices
+func (p *Plan) layoutVarStore() *varStoreBuilder {
+	if p.IsInstanced() || p.gdef == nil || p.gdef.VarStore() == nil ||
+		p.input.Flags&FlagDropLayoutTables != 0 || p.input.ShouldDropTable(ot.TagGDEF) {
+		return nil
+	}
+	if p.layoutVars == nil {
+		p.layoutVars = p.newVarStoreBuilder(p.gdef.VarStore())
+	}
+	return p.layoutVars
+}
+
 // gdefBuilder builds a subsetted GDEF table.
 type gdefBuilder struct {
 	glyphMap map[ot.GlyphID]ot.GlyphID
 	glyphSet map[ot.GlyphID]bool
 	gdef     *ot.GDEF
+
+	// deviceDelta and vars treat the device tables of caret values as
+	// in gposBuilder.
+	deviceDelta func(*ot.Device) int16
+	vars        *varStoreBuilder
 }
 
 func newGDEFBuilder(glyphMap map[ot.GlyphID]ot.GlyphID, glyphSet map[ot.GlyphID]bool, gdef *ot.GDEF) *gdefBuilder {
@@ -50,6 +75,17 @@ func (b *gdefBuilder) build() ([]byte, error) {
 		ligCaretList = b.subsetLigCaretList()
 	}
 
+	// The item variation store (version 1.3) keeps the delta sets
+	// referenced by the device tables of the subset; without any, the
+	// table is written as version 1.2.
+	var varStore []byte
+	if b.vars != nil && len(b.vars.outers) > 0 {
+		varStore = b.vars.build()
+		minor = 3
+	} else if minor > 2 {
+		minor = 2
+	}
+
 	if b.gdef.HasMarkAttachClasses() {
 		markAttachClassDef = b.subsetMarkAttachClassDef()
 	}
@@ -63,6 +99,9 @@ func (b *gdefBuilder) build() ([]byte, error) {
 	if minor >= 2 {
 		headerSize = 14 // + markGlyphSetsDefOffset(2)
 	}
+	if minor >= 3 {
+		headerSize = 18 // + itemVarStoreOffset(4)
+	}
 
 	// Calculate offsets
 	offset := headerSize
@@ -96,6 +135,16 @@ func (b *gdefBuilder) build() ([]byte, error) {
 		markGlyphSetsDefOffset = uint16(offset)
 		offset += len(markGlyphSetsDef)
 	}
+	if offset > 0xFFFF {
+		return nil, ErrOffsetOverflow
+	}
+
+	// The store goes last, behind a 32-bit offset
+	varStoreOffset := uint32(0)
+	if len(varStore) > 0 {
+		varStoreOffset = uint32(offset)
+		offset += len(varStore)
+	}
 
 	// Build final table
 	totalSize := offset
@@ -112,6 +161,9 @@ func (b *gdefBuilder) build() ([]byte, error) {
 	if minor >= 2 {
 		binary.BigEndian.PutUint16(data[12:], markGlyphSetsDefOffset)
 	}
+	if minor >= 3 {
+		binary.BigEndian.PutUint32(data[14:], varStoreOffset)
+	}
 
 	// Copy component data
 	off := headerSize
@@ -133,7 +185,9 @@ func (b *gdefBuilder) build() ([]byte, error) {
 	}
 	if minor >= 2 && len(markGlyphSetsDef) > 0 {
 		copy(data[off:], markGlyphSetsDef)
+		off += len(markGlyphSetsDef)
 	}
+	copy(data[off:], varStore)
 
 	return data, nil
 }
@@ -277,17 +331,7 @@ func (b *gdefBuilder) subsetLigCaretList() []byte {
 
 		for j, cv := range e.carets {
 			caretValueOffsets[j] = uint16(ligGlyphHeaderSize + len(caretValueData))
-
-			// CaretValue: format(2) + coordinate/pointIndex(2)
-			cvData := make([]byte, 4)
-			binary.BigEndian.PutUint16(cvData[0:], cv.Format())
-			switch cv.Format() {
-			case 1, 3:
-				binary.BigEndian.PutUint16(cvData[2:], uint16(cv.Coordinate()))
-			case 2:
-				binary.BigEndian.PutUint16(cvData[2:], cv.PointIndex())
-			}
-			caretValueData = append(caretValueData, cvData...)
+			caretValueData = append(caretValueData, b.caretValue(cv)...)
 		}
 
 		// Build LigGlyph table
@@ -318,6 +362,34 @@ func (b *gdefBuilder) subsetLigCaretList() []byte {
 	return data
 }
 
+// caretValue builds a CaretValue table. The delta of a format 3 device
+// table is baked in when instancing; the caret stays format 3 only if the
+// device table is kept.
+func (b *gdefBuilder) caretValue(cv ot.CaretValue) []byte {
+	switch cv.Format() {
+	case 2:
+		// Format 2: format(2) + caretValuePointIndex(2)
+		return appendUint16s(nil, 2, cv.PointIndex())
+	case 3:
+		coord := cv.Coordinate()
+		if b.deviceDelta != nil {
+			coord += b.deviceDelta(cv.Device())
+		}
+		d := cv.Device()
+		if b.vars != nil && d != nil && d.DeltaFormat == ot.DeltaFormatVariationIndex {
+			b.vars.add(d.VarIdx)
+			if idx := b.vars.mapped(d.VarIdx); idx != noVariationsIndex {
+				// Format 3: format(2) + coordinate(2) + deviceOffset(2)
+				data := appendUint16s(nil, 3, uint16(coord), 6)
+				return appendDevice(data, &ot.Device{DeltaFormat: ot.DeltaFormatVariationIndex, VarIdx: idx})
+			}
+		}
+		return appendUint16s(nil, 1, uint16(coord))
+	}
+	// Format 1: format(2) + coordinate(2)
+	return appendUint16s(nil, 1, uint16(cv.Coordinate()))
+}
+
 // subsetMarkAttachClassDef subsets the MarkAttachClassDef.
 func (b *gdefBuilder) subsetMarkAttachClassDef() []byte {
 	var entries []classEntry
@@ -0,0 +1,319 @@
+package subset
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// Outline instancing of glyf glyphs at the pinned variation location.
+//
+// HarfBuzz equivalent: glyf_impl::Glyph::get_points and
+// Glyph::compile_bytes_with_deltas (OT/glyf/Glyph.hh).
+
+// maxInstanceDepth bounds composite glyph recursion.
+// HarfBuzz equivalent: HB_MAX_NESTING_LEVEL
+const maxInstanceDepth = 64
+
+// instancedGlyph is a glyf glyph at the pinned location, with its origin
+// moved to the instanced left phantom point.
+type instancedGlyph struct {
+	data    []byte // glyph data to write; nil for empty glyphs
+	bbox    ot.GlyphBBox
+	empty   bool // no outline data
+	advance uint16
+	lsb     int16
+
+	// Vertical metrics from the top and bottom phantom points (only with
+	// vmtx)
+	advanceHeight uint16
+	tsb           int16
+}
+
+type pointF struct{ x, y float64 }
+
+// glyphPoints are a glyph's instanced points in its own coordinate
+// space: outline points (composites flattened), the four phantom points
+// and, for composites, the new component offsets. The offsets already
+// compensate for the origin shift instanceGlyphs applies to each
+// component glyph.
+type glyphPoints struct {
+	points   []pointF
+	phantoms [4]pointF
+	offsets  []componentOffset
+}
+
+// componentOffset is the offset of a component as written: before the
+// component transform for a scaled offset, so that the flag and any
+// remaining gvar deltas keep their meaning. unit is the offset change
+// that moves the component by one unit along x in glyph space.
+type componentOffset struct {
+	x, y float64
+	unit pointF
+}
+
+// instanceGlyphs applies gvar deltas to every retained glyph, including
+// composite component offsets, and records the resulting outlines,
+// bounding boxes and metrics.
+func (p *Plan) instanceGlyphs() {
+	if p.glyf == nil || p.hmtx == nil {
+		return
+	}
+
+	cache := make(map[ot.GlyphID]*glyphPoints)
+	p.instancedGlyphs = make(map[ot.GlyphID]*instancedGlyph, len(p.glyphSet))
+	for gid := range p.glyphSet {
+		gp := p.glyphPointsAt(gid, cache, 0)
+		ig := &instancedGlyph{}
+
+		// Move the origin to the left phantom point so that lsb == xMin.
+		shift := -math.Round(gp.phantoms[0].x)
+		ig.advance = uint16(math.Max(0, math.Round(gp.phantoms[1].x-gp.phantoms[0].x)))
+		ig.advanceHeight = uint16(math.Max(0, math.Round(gp.phantoms[2].y-gp.phantoms[3].y)))
+
+		data := p.glyf.GetGlyphBytes(gid)
+		ig.empty = len(data) < 10
+		if ig.empty {
+			p.instancedGlyphs[gid] = ig
+			continue
+		}
+
+		if int16(binary.BigEndian.Uint16(data)) >= 0 {
+			orig, _, err := ot.ParseSimpleGlyph(data)
+			if err != nil || len(orig) != len(gp.points) {
+				ig.data = data
+			} else {
+				xd := make([]int16, len(orig))
+				yd := make([]int16, len(orig))
+				for i, pt := range gp.points {
+					xd[i] = int16(math.Round(pt.x+shift)) - orig[i].X
+					yd[i] = int16(math.Round(pt.y)) - orig[i].Y
+				}
+				ig.data = ot.InstanceSimpleGlyph(data, xd, yd)
+			}
+			ig.bbox = glyphHeaderBBox(ig.data)
+		} else {
+			ig.bbox = pointsBBox(gp.points, shift)
+			offsets := make([][2]int16, len(gp.offsets))
+			for i, o := range gp.offsets {
+				offsets[i] = [2]int16{
+					int16(math.Round(o.x + shift*o.unit.x)),
+					int16(math.Round(o.y + shift*o.unit.y)),
+				}
+			}
+			ig.data = ot.InstanceCompositeGlyph(data, offsets, ig.bbox)
+		}
+		ig.lsb = ig.bbox.XMin
+		ig.tsb = int16(math.Round(gp.phantoms[2].y)) - ig.bbox.YMax
+		p.instancedGlyphs[gid] = ig
+	}
+
+	// Without HVAR and VVAR, advances come from the phantom points.
+	if p.hvar == nil || !p.hvar.HasData() {
+		for gid, ig := range p.instancedGlyphs {
+			p.instancedAdvances[gid] = ig.advance
+		}
+	}
+	if p.instancedVAdvances != nil && (p.vvar == nil || !p.vvar.HasData()) {
+		for gid, ig := range p.instancedGlyphs {
+			p.instancedVAdvances[gid] = ig.advanceHeight
+		}
+	}
+}
+
+// glyphPointsAt returns the instanced points of a glyph, computing them
+// recursively for composites.
+func (p *Plan) glyphPointsAt(gid ot.GlyphID, cache map[ot.GlyphID]*glyphPoints, depth int) *glyphPoints {
+	if gp, ok := cache[gid]; ok {
+		return gp
+	}
+	gp := &glyphPoints{}
+	cache[gid] = gp // also breaks component cycles
+	if depth > maxInstanceDepth {
+		return gp
+	}
+
+	data := p.glyf.GetGlyphBytes(gid)
+	advance, lsb := p.hmtx.GetMetrics(gid)
+	var xMin, yMax int16
+	if len(data) >= 10 {
+		xMin = int16(binary.BigEndian.Uint16(data[2:]))
+		yMax = int16(binary.BigEndian.Uint16(data[8:]))
+	}
+	left := float64(xMin) - float64(lsb)
+	gp.phantoms = [4]pointF{{x: left}, {x: left + float64(advance)}}
+	if p.vmtx != nil {
+		top := float64(yMax) + float64(p.vmtx.GetTsb(gid))
+		gp.phantoms[2].y = top
+		gp.phantoms[3].y = top - float64(p.vmtx.GetAdvanceHeight(gid))
+	}
+
+	var components []ot.CompositeComponent
+	var deltas *ot.GlyphDeltas
+	switch {
+	case len(data) < 10:
+		// Empty glyph: only phantom points vary.
+		deltas = p.compositeDeltas(gid, 0)
+	case int16(binary.BigEndian.Uint16(data)) >= 0:
+		orig, _, err := ot.ParseSimpleGlyph(data)
+		if err != nil {
+			return gp
+		}
+		coords := make([]ot.GlyphPoint, len(orig)+4)
+		gp.points = make([]pointF, len(orig))
+		for i, pt := range orig {
+			coords[i] = ot.GlyphPoint{X: pt.X, Y: pt.Y}
+			gp.points[i] = pointF{float64(pt.X), float64(pt.Y)}
+		}
+		if p.gvar != nil && p.gvar.HasData() {
+			deltas = p.gvar.GetGlyphDeltasWithCoords(gid, p.normalizedCoords, len(orig)+4, coords)
+		}
+		if deltas != nil {
+			for i := range gp.points {
+				gp.points[i].x += deltas.XDeltas[i]
+				gp.points[i].y += deltas.YDeltas[i]
+			}
+		}
+	default:
+		components = ot.ParseCompositeGlyph(data)
+		deltas = p.compositeDeltas(gid, len(components))
+	}
+
+	// Phantom point deltas follow the outline points.
+	if deltas != nil && len(deltas.XDeltas) >= 4 {
+		n := len(deltas.XDeltas) - 4
+		for k := range gp.phantoms {
+			gp.phantoms[k].x += deltas.XDeltas[n+k]
+			gp.phantoms[k].y += deltas.YDeltas[n+k]
+		}
+	}
+
+	for i, c := range components {
+		child := p.glyphPointsAt(c.GlyphID, cache, depth+1)
+		m := c.Matrix()
+		pts := make([]pointF, len(child.points))
+		for j, pt := range child.points {
+			x, y := m.Apply(float32(pt.x), float32(pt.y))
+			pts[j] = pointF{float64(x), float64(y)}
+		}
+
+		var dx, dy float64
+		scaled := false
+		off := componentOffset{unit: pointF{1, 0}}
+		if c.Flags&ot.CompositeArgsAreXYValues != 0 {
+			dx, dy = float64(c.Arg1), float64(c.Arg2)
+			if deltas != nil {
+				dx += deltas.XDeltas[i]
+				dy += deltas.YDeltas[i]
+			}
+			off.x, off.y = dx, dy
+			if c.Flags&ot.CompositeScaledOffset != 0 {
+				scaled = true
+				x, y := m.Apply(float32(dx), float32(dy))
+				dx, dy = float64(x), float64(y)
+				// The inverse transform of (1, 0)
+				off.unit = pointF{}
+				if det := float64(m.XX)*float64(m.YY) - float64(m.XY)*float64(m.YX); det != 0 {
+					off.unit = pointF{float64(m.YY) / det, -float64(m.YX) / det}
+				}
+			}
+			if c.Flags&ot.CompositeRoundXYToGrid != 0 {
+				dx, dy = math.Round(dx), math.Round(dy)
+			}
+		} else {
+			// Align point Arg2 of the component with point Arg1 of the
+			// glyph so far.
+			a, b := int(uint16(c.Arg1)), int(uint16(c.Arg2))
+			if a < len(gp.points) && b < len(pts) {
+				dx = gp.points[a].x - pts[b].x
+				dy = gp.points[a].y - pts[b].y
+			}
+		}
+		for j := range pts {
+			pts[j].x += dx
+			pts[j].y += dy
+		}
+		gp.points = append(gp.points, pts...)
+
+		// The component glyph is written with its origin moved by its own
+		// left phantom shift; move the offset back by that shift so the
+		// component stays in place.
+		// fontTools: _setCoordinates (fontTools/ttLib/tables/_g_l_y_f.py)
+		// A scaled offset is moved before the transform.
+		childShift := -math.Round(child.phantoms[0].x)
+		if scaled {
+			off.x -= childShift
+		} else {
+			off.x = dx - float64(m.XX)*childShift
+			off.y = dy - float64(m.YX)*childShift
+		}
+		gp.offsets = append(gp.offsets, off)
+
+		if c.Flags&ot.CompositeUseMyMetrics != 0 {
+			gp.phantoms = child.phantoms
+		}
+	}
+	return gp
+}
+
+// compositeDeltas returns the gvar deltas of a glyph without outline
+// points of its own: numComponents component offsets and the phantom
+// points. Always returns non-nil deltas.
+func (p *Plan) compositeDeltas(gid ot.GlyphID, numComponents int) *ot.GlyphDeltas {
+	if p.gvar != nil && p.gvar.HasData() {
+		if d := p.gvar.GetCompositeGlyphDeltas(gid, p.normalizedCoords, numComponents+4); d != nil {
+			return d
+		}
+	}
+	return &ot.GlyphDeltas{
+		XDeltas: make([]float64, numComponents+4),
+		YDeltas: make([]float64, numComponents+4),
+	}
+}
+
+// pointsBBox returns the rounded bounding box of points shifted by dx.
+func pointsBBox(points []pointF, dx float64) ot.GlyphBBox {
+	if len(points) == 0 {
+		return ot.GlyphBBox{}
+	}
+	xMin, yMin := math.Inf(1), math.Inf(1)
+	xMax, yMax := math.Inf(-1), math.Inf(-1)
+	for _, pt := range points {
+		xMin, xMax = math.Min(xMin, pt.x+dx), math.Max(xMax, pt.x+dx)
+		yMin, yMax = math.Min(yMin, pt.y), math.Max(yMax, pt.y)
+	}
+	return ot.GlyphBBox{
+		XMin: int16(math.Round(xMin)),
+		YMin: int16(math.Round(yMin)),
+		XMax: int16(math.Round(xMax)),
+		YMax: int16(math.Round(yMax)),
+	}
+}
+
+// glyphHeaderBBox reads the bounding box from a glyph header.
+func glyphHeaderBBox(data []byte) ot.GlyphBBox {
+	if len(data) < 10 {
+		return ot.GlyphBBox{}
+	}
+	return ot.GlyphBBox{
+		XMin: int16(binary.BigEndian.Uint16(data[2:])),
+		YMin: int16(binary.BigEndian.Uint16(data[4:])),
+		XMax: int16(binary.BigEndian.Uint16(data[6:])),
+		YMax: int16(binary.BigEndian.Uint16(data[8:])),
+	}
+}
+
+func min16(a, b int16) int16 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max16(a, b int16) int16 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,289 @@
+package subset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// controlBoxPen collects the bounding box of all points drawn, which for
+// TrueType outlines equals the glyf header bounding box.
+type controlBoxPen struct {
+	bbox ot.GlyphBBox
+	ok   bool
+}
+
+func (c *controlBoxPen) add(x, y float32) {
+	px, py := int16(math.Round(float64(x))), int16(math.Round(float64(y)))
+	if !c.ok {
+		c.bbox = ot.GlyphBBox{XMin: px, YMin: py, XMax: px, YMax: py}
+		c.ok = true
+		return
+	}
+	c.bbox.XMin, c.bbox.XMax = min16(c.bbox.XMin, px), max16(c.bbox.XMax, px)
+	c.bbox.YMin, c.bbox.YMax = min16(c.bbox.YMin, py), max16(c.bbox.YMax, py)
+}
+
+func (c *controlBoxPen) MoveTo(x, y float32) { c.add(x, y) }
+func (c *controlBoxPen) LineTo(x, y float32) { c.add(x, y) }
+func (c *controlBoxPen) QuadTo(cx, cy, x, y float32) {
+	c.add(cx, cy)
+	c.add(x, y)
+}
+func (c *controlBoxPen) CubicTo(c1x, c1y, c2x, c2y, x, y float32) {
+	c.add(c1x, c1y)
+	c.add(c2x, c2y)
+	c.add(x, y)
+}
+func (c *controlBoxPen) Close() {}
+
+// checkInstancedBounds checks that every glyph's drawn outline matches
+// its header bounding box and lsb, and that head and hhea agree. It
+// returns the number of composite glyphs.
+func checkInstancedBounds(t *testing.T, plan *Plan, subFont *ot.Font) int {
+	t.Helper()
+	glyf, err := ot.ParseGlyfFromFont(subFont)
+	if err != nil {
+		t.Fatalf("ParseGlyfFromFont: %v", err)
+	}
+	face, err := ot.NewFace(subFont)
+	if err != nil {
+		t.Fatalf("NewFace: %v", err)
+	}
+	hmtxData, _ := subFont.TableData(ot.TagHmtx)
+
+	var composites int
+	var union ot.GlyphBBox
+	var maxAdvance uint16
+	for gid := 0; gid < len(plan.GlyphSet()); gid++ {
+		data := glyf.GetGlyphBytes(ot.GlyphID(gid))
+		advance := binary.BigEndian.Uint16(hmtxData[gid*4:])
+		lsb := int16(binary.BigEndian.Uint16(hmtxData[gid*4+2:]))
+		maxAdvance = max(maxAdvance, advance)
+		if len(data) < 10 {
+			continue
+		}
+		if int16(binary.BigEndian.Uint16(data)) < 0 {
+			composites++
+		}
+
+		header := glyphHeaderBBox(data)
+		var pen controlBoxPen
+		face.DrawGlyph(ot.GlyphID(gid), &pen)
+		if pen.bbox != header {
+			t.Errorf("glyph %d: header bbox %v, outline bbox %v", gid, header, pen.bbox)
+		}
+		if lsb != header.XMin {
+			t.Errorf("glyph %d: lsb %d, xMin %d", gid, lsb, header.XMin)
+		}
+		if union == (ot.GlyphBBox{}) {
+			union = header
+		} else {
+			union = ot.GlyphBBox{
+				XMin: min16(union.XMin, header.XMin), YMin: min16(union.YMin, header.YMin),
+				XMax: max16(union.XMax, header.XMax), YMax: max16(union.YMax, header.YMax),
+			}
+		}
+	}
+
+	head, _ := subFont.TableData(ot.TagHead)
+	got := glyphHeaderBBox(head[34:])
+	if got != union {
+		t.Errorf("head bbox %v, want union of glyphs %v", got, union)
+	}
+	hhea, _ := subFont.TableData(ot.TagHhea)
+	if got := binary.BigEndian.Uint16(hhea[10:]); got != maxAdvance {
+		t.Errorf("hhea advanceWidthMax %d, want %d", got, maxAdvance)
+	}
+	return composites
+}
+
+func TestInstanceCompositeGlyphs(t *testing.T) {
+	const text = "eé"
+	input := NewInput()
+	input.AddString(text)
+	input.PinAxisLocation(ot.TagAxisWeight, 900)
+	plan, _, subFont := subsetAndParse(t, loadFont(t, "Roboto-Variable.ttf"), input)
+	if checkInstancedBounds(t, plan, subFont) == 0 {
+		t.Fatalf("subset of %q has no composite glyphs", text)
+	}
+}
+
+// TestInstanceCompositeChildShift gives the base glyph of "é" an lsb
+// that differs from its xMin, so its left phantom point, and with it
+// the origin shift applied when it is written, is not zero. The
+// composite's component offset has to compensate for that shift.
+func TestInstanceCompositeChildShift(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	cmapData, _ := font.TableData(ot.TagCmap)
+	cmap, err := ot.ParseCmap(cmapData)
+	if err != nil {
+		t.Fatalf("cmap: %v", err)
+	}
+	e, ok := cmap.Lookup('e')
+	if !ok {
+		t.Fatal("no glyph for e")
+	}
+	hmtx, _ := font.TableData(ot.TagHmtx)
+	hhea, _ := font.TableData(ot.TagHhea)
+	if int(e) >= int(binary.BigEndian.Uint16(hhea[34:])) {
+		t.Skip("e is in the trailing lsb array")
+	}
+	// TableData returns a slice of the font data, so this patches the
+	// parsed font in place.
+	lsb := int16(binary.BigEndian.Uint16(hmtx[int(e)*4+2:]))
+	binary.BigEndian.PutUint16(hmtx[int(e)*4+2:], uint16(lsb-37))
+
+	input := NewInput()
+	input.AddString("eé")
+	input.PinAxisLocation(ot.TagAxisWeight, 700)
+	plan, _, subFont := subsetAndParse(t, font, input)
+	if checkInstancedBounds(t, plan, subFont) == 0 {
+		t.Fatal("subset has no composite glyphs")
+	}
+}
+
+func TestInstanceCompositeOffsets(t *testing.T) {
+	// The acute accent moves with the weight of the base glyph.
+	font := loadFont(t, "Roboto-Variable.ttf")
+	input := NewInput()
+	input.AddString("é")
+	input.PinAxisLocation(ot.TagAxisWeight, 100)
+	thinPlan, _, thin := subsetAndParse(t, font, input)
+	input.PinAxisLocation(ot.TagAxisWeight, 900)
+	blackPlan, _, black := subsetAndParse(t, font, input)
+
+	composite := func(plan *Plan, font *ot.Font) []byte {
+		glyf, err := ot.ParseGlyfFromFont(font)
+		if err != nil {
+			t.Fatalf("ParseGlyfFromFont: %v", err)
+		}
+		for gid := 0; gid < len(plan.GlyphSet()); gid++ {
+			data := glyf.GetGlyphBytes(ot.GlyphID(gid))
+			if len(data) >= 10 && int16(binary.BigEndian.Uint16(data)) < 0 {
+				return data
+			}
+		}
+		t.Fatalf("no composite glyph in subset")
+		return nil
+	}
+	a, b := composite(thinPlan, thin), composite(blackPlan, black)
+	if bytes.Equal(a, b) {
+		t.Fatalf("composite glyph identical at wght=100 and wght=900")
+	}
+
+	ca, cb := ot.ParseCompositeGlyph(a), ot.ParseCompositeGlyph(b)
+	if len(ca) != len(cb) {
+		t.Fatalf("component counts differ: %d vs %d", len(ca), len(cb))
+	}
+	moved := false
+	for i := range ca {
+		if ca[i].Arg1 != cb[i].Arg1 || ca[i].Arg2 != cb[i].Arg2 {
+			moved = true
+		}
+	}
+	if !moved {
+		t.Errorf("component offsets unchanged between wght=100 and wght=900")
+	}
+}
+
+// TestInstanceCompositeScaledOffset replaces "é" with a composite whose
+// accent is scaled by one half and has a scaled offset of (200, 2000).
+// The offset is written before the scale, like the flag says, so the
+// accent ends up at (100, 1000), above the base glyph.
+func TestInstanceCompositeScaledOffset(t *testing.T) {
+	src := loadFont(t, "Roboto-Variable.ttf")
+	cmap := loadCmap(t, src)
+	eacute, _ := cmap.Lookup('é')
+	glyf, err := ot.ParseGlyfFromFont(src)
+	if err != nil {
+		t.Fatalf("ParseGlyfFromFont: %v", err)
+	}
+	components := ot.ParseCompositeGlyph(glyf.GetGlyphBytes(eacute))
+	if len(components) != 2 {
+		t.Fatalf("é has %d components, want 2", len(components))
+	}
+	base, accent := components[0].GlyphID, components[1].GlyphID
+	const flags = 0x0001 | 0x0002 // ARG_1_AND_2_ARE_WORDS, ARGS_ARE_XY_VALUES
+	composite := appendUint16s(glyf.GetGlyphBytes(eacute)[:10:10],
+		flags|0x0020, uint16(base), 0, 0, // MORE_COMPONENTS
+		flags|0x0008|ot.CompositeScaledOffset, uint16(accent), 200, 2000, 0x2000) // WE_HAVE_A_SCALE 0.5
+
+	// Rebuild glyf with a long loca
+	var glyfData, loca []byte
+	for gid := range src.NumGlyphs() {
+		loca = binary.BigEndian.AppendUint32(loca, uint32(len(glyfData)))
+		data := glyf.GetGlyphBytes(ot.GlyphID(gid))
+		if ot.GlyphID(gid) == eacute {
+			data = composite
+		}
+		glyfData = append(glyfData, data...)
+		for len(glyfData)%4 != 0 {
+			glyfData = append(glyfData, 0)
+		}
+	}
+	loca = binary.BigEndian.AppendUint32(loca, uint32(len(glyfData)))
+	head := bytes.Clone(mustTableData(t, src, ot.TagHead))
+	binary.BigEndian.PutUint16(head[50:], 1)
+	builder := NewFontBuilder()
+	for _, tag := range src.TableTags() {
+		builder.AddTable(tag, mustTableData(t, src, tag))
+	}
+	builder.AddTable(ot.TagGlyf, glyfData)
+	builder.AddTable(ot.TagLoca, loca)
+	builder.AddTable(ot.TagHead, head)
+	data, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	font, err := ot.ParseFont(data, 0)
+	if err != nil {
+		t.Fatalf("ParseFont: %v", err)
+	}
+
+	for _, weight := range []float32{400, 700} {
+		input := NewInput()
+		input.AddString("é")
+		input.PinAxisLocation(ot.TagAxisWeight, weight)
+		plan, _, subFont := subsetAndParse(t, font, input)
+		subGlyf, err := ot.ParseGlyfFromFont(subFont)
+		if err != nil {
+			t.Fatalf("ParseGlyfFromFont: %v", err)
+		}
+		newGID := func(gid ot.GlyphID) ot.GlyphID {
+			n, ok := plan.MapGlyph(gid)
+			if !ok {
+				t.Fatalf("glyph %d not retained", gid)
+			}
+			return n
+		}
+		out := subGlyf.GetGlyphBytes(newGID(eacute))
+		got := ot.ParseCompositeGlyph(out)
+		if len(got) != 2 || got[1].Flags&ot.CompositeScaledOffset == 0 {
+			t.Fatalf("wght=%v: instanced é %+v, want two components, the second with a scaled offset", weight, got)
+		}
+		// The default location keeps the offsets
+		if c := got[1]; weight == 400 && (c.Arg1 != 200 || c.Arg2 != 2000) {
+			t.Errorf("accent offset (%d, %d), want (200, 2000)", c.Arg1, c.Arg2)
+		}
+
+		// The bounding box follows the outline points, so it has the
+		// accent where the written offset puts it.
+		b, a := glyphHeaderBBox(subGlyf.GetGlyphBytes(newGID(base))), glyphHeaderBBox(subGlyf.GetGlyphBytes(newGID(accent)))
+		b0, a0 := got[0], got[1]
+		want := ot.GlyphBBox{
+			XMin: min16(b.XMin+b0.Arg1, (a.XMin+a0.Arg1)/2),
+			YMin: min16(b.YMin+b0.Arg2, (a.YMin+a0.Arg2)/2),
+			XMax: max16(b.XMax+b0.Arg1, (a.XMax+a0.Arg1)/2),
+			YMax: max16(b.YMax+b0.Arg2, (a.YMax+a0.Arg2)/2),
+		}
+		near := func(a, b int16) bool { return a-b >= -1 && a-b <= 1 }
+		if box := glyphHeaderBBox(out); !near(box.XMin, want.XMin) || !near(box.YMin, want.YMin) ||
+			!near(box.XMax, want.XMax) || !near(box.YMax, want.YMax) {
+			t.Errorf("wght=%v: bbox %+v, want %+v", weight, box, want)
+		}
+	}
+}
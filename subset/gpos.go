@@ -2,6 +2,7 @@ package subset
 
 import (
 	"encoding/binary"
+	"math"
 	"sort"
 
 	"github.com/boxesandglue/textshape/ot"
@@ -9,42 +10,132 @@ import (
 
 // subsetGPOS creates a subsetted GPOS table with remapped glyph IDs.
 func (p *Plan) subsetGPOS() ([]byte, error) {
-	if p.gpos == nil {
+	if p.gpos == nil || p.gposLayout == nil {
 		return nil, nil
 	}
 
-	// Get the 'kern' feature's lookups
-	featList, err := p.gpos.ParseFeatureList()
-	if err != nil {
-		return nil, nil
+	builder := newGPOSBuilder(p.glyphMap, p.glyphSet)
+	builder.deviceDelta = p.layoutDeviceDelta()
+	builder.vars = p.layoutVarStore()
+	indices := p.gposLookupIndices(p.gposLayout.lookups)
+
+	// Drop lookups that lose all subtables (see subsetGSUB).
+	builder.lookupMap = make(map[uint16]uint16, len(indices))
+	for _, idx := range indices {
+		builder.lookupMap[idx] = idx
+	}
+	var kept []uint16
+	for _, idx := range indices {
+		lookup := p.gpos.GetLookup(int(idx))
+		if lookup == nil {
+			continue
+		}
+		if len(lookup.Subtables()) == 0 {
+			// No subtable of a known type could be read
+			p.report.skipLookup(ot.TagGPOS, idx, lookup.Type)
+			continue
+		}
+		if builder.subsetLookup(lookup) != nil {
+			kept = append(kept, idx)
+		}
 	}
 
-	kernLookups := featList.FindFeature(ot.TagKern)
-	if len(kernLookups) == 0 {
+	// If no lookups remain, return nil (don't include empty GPOS)
+	if len(kept) == 0 {
 		return nil, nil
 	}
 
-	builder := newGPOSBuilder(p.glyphMap, p.glyphSet)
+	builder.lookupMap = make(map[uint16]uint16, len(kept))
+	for i, idx := range kept {
+		builder.lookupMap[idx] = uint16(i)
+	}
+	for _, idx := range kept {
+		builder.addLookup(builder.subsetLookup(p.gpos.GetLookup(int(idx))))
+	}
+	builder.features, builder.scripts, builder.variations = p.gposLayout.subsetLists(builder.lookupMap, false)
+
+	return builder.build()
+}
+
+// layoutDeviceDelta returns the evaluator of VariationIndex device tables
+// at the instanced location, or nil if the font is not instanced or has
+// no GDEF item variation store. When partially instancing, this is the
+// new default location.
+// HarfBuzz equivalent: hb_subset_plan_t::layout_variation_idx_delta_map
+func (p *Plan) layoutDeviceDelta() func(*ot.Device) int16 {
+	if p.normalizedCoords == nil || p.gdef == nil || p.gdef.VarStore() == nil {
+		return nil
+	}
+	store := p.gdef.VarStore()
+	return func(d *ot.Device) int16 {
+		if d == nil || d.DeltaFormat != ot.DeltaFormatVariationIndex {
+			return 0
+		}
+		return int16(math.Round(store.GetDelta(d.VarIdx, p.normalizedCoords)))
+	}
+}
 
-	// Only process lookups referenced by 'kern' feature
-	for _, lookupIdx := range kernLookups {
-		lookup := p.gpos.GetLookup(int(lookupIdx))
+// gposLookupIndices returns the given lookups plus every lookup reachable
+// from them through contextual rules that can still match in the subset,
+// sorted by index.
+func (p *Plan) gposLookupIndices(roots []uint16) []uint16 {
+	has := func(g ot.GlyphID) bool { return p.glyphSet[g] }
+	seen := make(map[uint16]bool)
+	queue := append([]uint16(nil), roots...)
+	for len(queue) > 0 {
+		idx := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if seen[idx] {
+			continue
+		}
+		seen[idx] = true
+		lookup := p.gpos.GetLookup(int(idx))
 		if lookup == nil {
 			continue
 		}
-
-		subsetLookup := builder.subsetLookup(lookup)
-		if subsetLookup != nil {
-			builder.addLookup(subsetLookup)
+		for _, st := range lookup.Subtables() {
+			if sc := gposSequenceContext(st); sc != nil {
+				queue = append(queue, sequenceContextLookups(sc, has)...)
+			}
 		}
 	}
+	return sortedLookupIndices(seen)
+}
 
-	// If no lookups remain, return nil (don't include empty GPOS)
-	if len(builder.lookups) == 0 {
-		return nil, nil
+// gposSequenceContext returns the contextual view of a GPOS 7/8
+// subtable, or nil for other types.
+func gposSequenceContext(st ot.GPOSSubtable) *ot.SequenceContext {
+	switch st := st.(type) {
+	case *ot.ContextPos:
+		return st.SequenceContext()
+	case *ot.ChainContextPos:
+		return st.SequenceContext()
 	}
+	return nil
+}
 
-	return builder.build()
+// gposSubtableType returns the lookup type implemented by a subtable;
+// used to unwrap extension lookups (type 9).
+func gposSubtableType(st ot.GPOSSubtable) uint16 {
+	switch st.(type) {
+	case *ot.SinglePos:
+		return ot.GPOSTypeSingle
+	case *ot.PairPos:
+		return ot.GPOSTypePair
+	case *ot.CursivePos:
+		return ot.GPOSTypeCursive
+	case *ot.MarkBasePos:
+		return ot.GPOSTypeMarkBase
+	case *ot.MarkLigPos:
+		return ot.GPOSTypeMarkLig
+	case *ot.MarkMarkPos:
+		return ot.GPOSTypeMarkMark
+	case *ot.ContextPos:
+		return ot.GPOSTypeContext
+	case *ot.ChainContextPos:
+		return ot.GPOSTypeChainContext
+	}
+	return 0
 }
 
 // gposBuilder builds a subsetted GPOS table.
@@ -52,12 +143,34 @@ type gposBuilder struct {
 	glyphMap map[ot.GlyphID]ot.GlyphID
 	glyphSet map[ot.GlyphID]bool
 	lookups  []*gposLookupBuilder
+	features []featureRecord
+	scripts  []scriptRecord
+
+	// lookupMap maps old to new lookup indices, for nested lookups.
+	lookupMap map[uint16]uint16
+
+	// err is set to ErrOffsetOverflow when a subtable outgrows its
+	// 16-bit offsets.
+	err error
+
+	// deviceDelta, if set, returns the delta of a device table at the
+	// instanced location. The deltas are added to the values and anchors;
+	// unless vars is set, the device tables are dropped.
+	deviceDelta func(*ot.Device) int16
+
+	// vars, if set, keeps VariationIndex device tables, remapped to the
+	// subset GDEF item variation store.
+	vars *varStoreBuilder
+
+	// variations is the FeatureVariations table of the subset, or nil.
+	variations []byte
 }
 
 type gposLookupBuilder struct {
 	subtables  [][]byte
 	lookupType uint16
 	flag       uint16
+	markFilter uint16
 }
 
 func newGPOSBuilder(glyphMap map[ot.GlyphID]ot.GlyphID, glyphSet map[ot.GlyphID]bool) *gposBuilder {
@@ -76,11 +189,18 @@ func (b *gposBuilder) subsetLookup(lookup *ot.GPOSLookup) *gposLookupBuilder {
 	lb := &gposLookupBuilder{
 		lookupType: lookup.Type,
 		flag:       lookup.Flag,
+		markFilter: lookup.MarkFilter,
 	}
+	ctx := &contextSubsetter{glyphMap: b.glyphMap, lookupMap: b.lookupMap}
 
 	for _, subtable := range lookup.Subtables() {
 		var data []byte
 
+		// Extension subtables are parsed through; write them unwrapped.
+		if lookup.Type == ot.GPOSTypeExtension {
+			lb.lookupType = gposSubtableType(subtable)
+		}
+
 		switch st := subtable.(type) {
 		case *ot.SinglePos:
 			data = b.subsetSinglePos(st)
@@ -94,7 +214,10 @@ func (b *gposBuilder) subsetLookup(lookup *ot.GPOSLookup) *gposLookupBuilder {
 			data = b.subsetMarkLigPos(st)
 		case *ot.MarkMarkPos:
 			data = b.subsetMarkMarkPos(st)
-			// TODO: Context, ChainContext
+		case *ot.ContextPos:
+			data = ctx.subset(st.SequenceContext())
+		case *ot.ChainContextPos:
+			data = ctx.subset(st.SequenceContext())
 		}
 
 		if data != nil && len(data) > 0 {
@@ -102,12 +225,84 @@ func (b *gposBuilder) subsetLookup(lookup *ot.GPOSLookup) *gposLookupBuilder {
 		}
 	}
 
+	if ctx.err != nil {
+		b.err = ctx.err
+	}
 	if len(lb.subtables) == 0 {
 		return nil
 	}
 	return lb
 }
 
+// valueFormat returns the output format of ValueRecords. When deltas are
+// baked in, the flags of the values the device tables adjust are added;
+// the device flags remain only if device tables are kept.
+// HarfBuzz equivalent: ValueFormat::get_effective_format
+// (OT/Layout/GPOS/ValueFormat.hh)
+func (b *gposBuilder) valueFormat(format uint16) uint16 {
+	if b.deviceDelta == nil {
+		return format
+	}
+	if b.vars != nil {
+		return format | format>>4&0x0F
+	}
+	return format&0x0F | format>>4&0x0F
+}
+
+// value returns vr with the device deltas baked in and the device tables
+// remapped.
+func (b *gposBuilder) value(vr ot.ValueRecord) ot.ValueRecord {
+	if b.deviceDelta != nil {
+		vr.XPlacement += b.deviceDelta(vr.XPlaDevice)
+		vr.YPlacement += b.deviceDelta(vr.YPlaDevice)
+		vr.XAdvance += b.deviceDelta(vr.XAdvDevice)
+		vr.YAdvance += b.deviceDelta(vr.YAdvDevice)
+	}
+	vr.XPlaDevice, vr.YPlaDevice = b.device(vr.XPlaDevice), b.device(vr.YPlaDevice)
+	vr.XAdvDevice, vr.YAdvDevice = b.device(vr.XAdvDevice), b.device(vr.YAdvDevice)
+	return vr
+}
+
+// device returns the VariationIndex device table d with its delta set
+// remapped, or nil if device tables are not kept. Hinting device tables
+// are always dropped.
+// HarfBuzz equivalent: Device::copy with layout_variation_idx_delta_map
+func (b *gposBuilder) device(d *ot.Device) *ot.Device {
+	if b.vars == nil || d == nil || d.DeltaFormat != ot.DeltaFormatVariationIndex {
+		return nil
+	}
+	b.vars.add(d.VarIdx)
+	idx := b.vars.mapped(d.VarIdx)
+	if idx == noVariationsIndex {
+		return nil
+	}
+	return &ot.Device{DeltaFormat: ot.DeltaFormatVariationIndex, VarIdx: idx}
+}
+
+// anchor returns a with the device deltas baked in and the device tables
+// remapped.
+func (b *gposBuilder) anchor(a *ot.Anchor) *ot.Anchor {
+	if a == nil || (a.XDevice == nil && a.YDevice == nil) {
+		return a
+	}
+	out := *a
+	if b.deviceDelta != nil {
+		out.X += b.deviceDelta(a.XDevice)
+		out.Y += b.deviceDelta(a.YDevice)
+	}
+	out.XDevice, out.YDevice = b.device(a.XDevice), b.device(a.YDevice)
+	return &out
+}
+
+// anchors applies anchor to a row of anchors.
+func (b *gposBuilder) anchors(row []*ot.Anchor) []*ot.Anchor {
+	out := make([]*ot.Anchor, len(row))
+	for i, a := range row {
+		out[i] = b.anchor(a)
+	}
+	return out
+}
+
 // subsetSinglePos subsets a SinglePos subtable.
 func (b *gposBuilder) subsetSinglePos(sp *ot.SinglePos) []byte {
 	covGlyphs := sp.Coverage().Glyphs()
@@ -166,6 +361,7 @@ func (b *gposBuilder) subsetSinglePos(sp *ot.SinglePos) []byte {
 
 func (b *gposBuilder) buildSinglePosFormat1(glyphs []ot.GlyphID, vr ot.ValueRecord, valueFormat uint16) []byte {
 	coverage := buildCoverageFormat1(glyphs)
+	valueFormat = b.valueFormat(valueFormat)
 	vrSize := valueRecordSize(valueFormat)
 
 	// Format 1: format(2) + coverageOffset(2) + valueFormat(2) + valueRecord(vrSize)
@@ -175,14 +371,16 @@ func (b *gposBuilder) buildSinglePosFormat1(glyphs []ot.GlyphID, vr ot.ValueReco
 	binary.BigEndian.PutUint16(data[0:], 1)
 	binary.BigEndian.PutUint16(data[2:], uint16(headerSize))
 	binary.BigEndian.PutUint16(data[4:], valueFormat)
-	writeValueRecord(data[6:], vr, valueFormat)
+	var devs deviceTables
+	writeValueRecord(data, 6, b.value(vr), valueFormat, &devs)
 	copy(data[headerSize:], coverage)
 
-	return data
+	return b.appendDevices(data, &devs)
 }
 
 func (b *gposBuilder) buildSinglePosFormat2(glyphs []ot.GlyphID, vrs []ot.ValueRecord, valueFormat uint16) []byte {
 	coverage := buildCoverageFormat1(glyphs)
+	valueFormat = b.valueFormat(valueFormat)
 	vrSize := valueRecordSize(valueFormat)
 
 	// Format 2: format(2) + coverageOffset(2) + valueFormat(2) + valueCount(2) + valueRecords[]
@@ -194,14 +392,15 @@ func (b *gposBuilder) buildSinglePosFormat2(glyphs []ot.GlyphID, vrs []ot.ValueR
 	binary.BigEndian.PutUint16(data[4:], valueFormat)
 	binary.BigEndian.PutUint16(data[6:], uint16(len(vrs)))
 
+	var devs deviceTables
 	off := 8
 	for _, vr := range vrs {
-		writeValueRecord(data[off:], vr, valueFormat)
+		writeValueRecord(data, off, b.value(vr), valueFormat, &devs)
 		off += vrSize
 	}
 	copy(data[headerSize:], coverage)
 
-	return data
+	return b.appendDevices(data, &devs)
 }
 
 // subsetPairPos subsets a PairPos subtable.
@@ -286,6 +485,7 @@ func (b *gposBuilder) buildPairPosFormat1(sets []pairSetEntry, vf1, vf2 uint16)
 		glyphs[i] = s.firstGlyph
 	}
 	coverage := buildCoverageFormat1(glyphs)
+	vf1, vf2 = b.valueFormat(vf1), b.valueFormat(vf2)
 
 	vr1Size := valueRecordSize(vf1)
 	vr2Size := valueRecordSize(vf2)
@@ -305,18 +505,20 @@ func (b *gposBuilder) buildPairPosFormat1(sets []pairSetEntry, vf1, vf2 uint16)
 		pairSetSize := 2 + len(set.pairs)*pairRecordSize
 		pairSet := make([]byte, pairSetSize)
 
+		// Device tables are relative to the PairSet
+		var devs deviceTables
 		binary.BigEndian.PutUint16(pairSet[0:], uint16(len(set.pairs)))
 		off := 2
 		for _, p := range set.pairs {
 			binary.BigEndian.PutUint16(pairSet[off:], uint16(p.secondGlyph))
 			off += 2
-			writeValueRecord(pairSet[off:], p.value1, vf1)
+			writeValueRecord(pairSet, off, b.value(p.value1), vf1, &devs)
 			off += vr1Size
-			writeValueRecord(pairSet[off:], p.value2, vf2)
+			writeValueRecord(pairSet, off, b.value(p.value2), vf2, &devs)
 			off += vr2Size
 		}
 
-		pairSetData = append(pairSetData, pairSet...)
+		pairSetData = append(pairSetData, b.appendDevices(pairSet, &devs)...)
 	}
 
 	totalSize := headerSize + len(pairSetData) + len(coverage)
@@ -404,6 +606,7 @@ func (b *gposBuilder) buildPairPosFormat2(covGlyphs []ot.GlyphID, class1, class2
 	coverage := buildCoverageFormat1(covGlyphs)
 	classDef1 := buildClassDefFormat2(class1)
 	classDef2 := buildClassDefFormat2(class2)
+	vf1, vf2 = b.valueFormat(vf1), b.valueFormat(vf2)
 
 	vr1Size := valueRecordSize(vf1)
 	vr2Size := valueRecordSize(vf2)
@@ -431,14 +634,15 @@ func (b *gposBuilder) buildPairPosFormat2(covGlyphs []ot.GlyphID, class1, class2
 	binary.BigEndian.PutUint16(data[14:], class2Count)
 
 	// Write class matrix
+	var devs deviceTables
 	off := headerSize
 	for c1 := 0; c1 < int(class1Count); c1++ {
 		if c1 < len(classMatrix) {
 			for c2 := 0; c2 < int(class2Count); c2++ {
 				if c2 < len(classMatrix[c1]) {
-					writeValueRecord(data[off:], classMatrix[c1][c2].Value1, vf1)
+					writeValueRecord(data, off, b.value(classMatrix[c1][c2].Value1), vf1, &devs)
 					off += vr1Size
-					writeValueRecord(data[off:], classMatrix[c1][c2].Value2, vf2)
+					writeValueRecord(data, off, b.value(classMatrix[c1][c2].Value2), vf2, &devs)
 					off += vr2Size
 				} else {
 					off += classRecordSize
@@ -453,7 +657,7 @@ func (b *gposBuilder) buildPairPosFormat2(covGlyphs []ot.GlyphID, class1, class2
 	copy(data[classDef2Off:], classDef2)
 	copy(data[coverageOff:], coverage)
 
-	return data
+	return b.appendDevices(data, &devs)
 }
 
 // build serializes the GPOS table.
@@ -461,133 +665,14 @@ func (b *gposBuilder) build() ([]byte, error) {
 	if len(b.lookups) == 0 {
 		return nil, nil
 	}
-
-	// Build lookup list
-	lookupList := b.buildLookupList()
-
-	// Build minimal script list (DFLT/dflt)
-	scriptList := b.buildScriptList(len(b.lookups))
-
-	// Build feature list (all lookups under 'kern' feature)
-	featureList := b.buildFeatureList(len(b.lookups))
-
-	// GPOS header: version(4) + scriptListOff(2) + featureListOff(2) + lookupListOff(2)
-	headerSize := 10
-
-	scriptListOff := headerSize
-	featureListOff := scriptListOff + len(scriptList)
-	lookupListOff := featureListOff + len(featureList)
-
-	totalSize := lookupListOff + len(lookupList)
-	data := make([]byte, totalSize)
-
-	// Version 1.0
-	binary.BigEndian.PutUint16(data[0:], 1)
-	binary.BigEndian.PutUint16(data[2:], 0)
-	binary.BigEndian.PutUint16(data[4:], uint16(scriptListOff))
-	binary.BigEndian.PutUint16(data[6:], uint16(featureListOff))
-	binary.BigEndian.PutUint16(data[8:], uint16(lookupListOff))
-
-	copy(data[scriptListOff:], scriptList)
-	copy(data[featureListOff:], featureList)
-	copy(data[lookupListOff:], lookupList)
-
-	return data, nil
-}
-
-func (b *gposBuilder) buildLookupList() []byte {
-	// LookupList: lookupCount(2) + lookupOffsets[](2*n) + Lookup tables
-	headerSize := 2 + len(b.lookups)*2
-
-	lookupData := make([]byte, 0)
-	lookupOffsets := make([]uint16, len(b.lookups))
-
-	for i, lookup := range b.lookups {
-		lookupOffsets[i] = uint16(headerSize + len(lookupData))
-
-		// Lookup: lookupType(2) + lookupFlag(2) + subTableCount(2) + subTableOffsets[]
-		lookupHeaderSize := 6 + len(lookup.subtables)*2
-
-		subtableData := make([]byte, 0)
-		subtableOffsets := make([]uint16, len(lookup.subtables))
-
-		for j, st := range lookup.subtables {
-			subtableOffsets[j] = uint16(lookupHeaderSize + len(subtableData))
-			subtableData = append(subtableData, st...)
-		}
-
-		lookupTable := make([]byte, lookupHeaderSize+len(subtableData))
-		binary.BigEndian.PutUint16(lookupTable[0:], lookup.lookupType)
-		binary.BigEndian.PutUint16(lookupTable[2:], lookup.flag)
-		binary.BigEndian.PutUint16(lookupTable[4:], uint16(len(lookup.subtables)))
-		for j, off := range subtableOffsets {
-			binary.BigEndian.PutUint16(lookupTable[6+j*2:], off)
-		}
-		copy(lookupTable[lookupHeaderSize:], subtableData)
-
-		lookupData = append(lookupData, lookupTable...)
+	if b.err != nil {
+		return nil, b.err
 	}
-
-	data := make([]byte, headerSize+len(lookupData))
-	binary.BigEndian.PutUint16(data[0:], uint16(len(b.lookups)))
-	for i, off := range lookupOffsets {
-		binary.BigEndian.PutUint16(data[2+i*2:], off)
+	lookups := make([]*lookupBuilder, len(b.lookups))
+	for i, l := range b.lookups {
+		lookups[i] = (*lookupBuilder)(l)
 	}
-	copy(data[headerSize:], lookupData)
-
-	return data
-}
-
-func (b *gposBuilder) buildScriptList(numLookups int) []byte {
-	// Minimal script list: DFLT script with dflt language system
-	scriptCount := 1
-	headerSize := 2 + scriptCount*6
-
-	langSysSize := 6 + 2 // One feature index
-	scriptSize := 4 + langSysSize
-
-	totalSize := headerSize + scriptSize
-	data := make([]byte, totalSize)
-
-	binary.BigEndian.PutUint16(data[0:], uint16(scriptCount))
-	copy(data[2:], []byte("DFLT"))
-	binary.BigEndian.PutUint16(data[6:], uint16(headerSize))
-
-	scriptOff := headerSize
-	binary.BigEndian.PutUint16(data[scriptOff:], 4)
-	binary.BigEndian.PutUint16(data[scriptOff+2:], 0)
-
-	langSysOff := scriptOff + 4
-	binary.BigEndian.PutUint16(data[langSysOff:], 0)
-	binary.BigEndian.PutUint16(data[langSysOff+2:], 0xFFFF)
-	binary.BigEndian.PutUint16(data[langSysOff+4:], 1)
-	binary.BigEndian.PutUint16(data[langSysOff+6:], 0)
-
-	return data
-}
-
-func (b *gposBuilder) buildFeatureList(numLookups int) []byte {
-	// Single 'kern' feature that includes all lookups
-	featureCount := 1
-	headerSize := 2 + featureCount*6
-
-	featureSize := 4 + numLookups*2
-
-	totalSize := headerSize + featureSize
-	data := make([]byte, totalSize)
-
-	binary.BigEndian.PutUint16(data[0:], uint16(featureCount))
-	copy(data[2:], []byte("kern"))
-	binary.BigEndian.PutUint16(data[6:], uint16(headerSize))
-
-	featureOff := headerSize
-	binary.BigEndian.PutUint16(data[featureOff:], 0)
-	binary.BigEndian.PutUint16(data[featureOff+2:], uint16(numLookups))
-	for i := 0; i < numLookups; i++ {
-		binary.BigEndian.PutUint16(data[featureOff+4+i*2:], uint16(i))
-	}
-
-	return data
+	return buildLayoutTable(b.scripts, b.features, lookups, b.variations, ot.GPOSTypeExtension)
 }
 
 // valueRecordSize returns the byte size of a ValueRecord with the given format.
@@ -601,9 +686,10 @@ func valueRecordSize(format uint16) int {
 	return count * 2
 }
 
-// writeValueRecord writes a ValueRecord to data.
-func writeValueRecord(data []byte, vr ot.ValueRecord, format uint16) {
-	off := 0
+// writeValueRecord writes a ValueRecord at data[off:]. The offsets of its
+// device tables are recorded in devs, to be filled in when the device
+// tables are appended to data.
+func writeValueRecord(data []byte, off int, vr ot.ValueRecord, format uint16, devs *deviceTables) {
 	if format&ot.ValueFormatXPlacement != 0 {
 		binary.BigEndian.PutUint16(data[off:], uint16(vr.XPlacement))
 		off += 2
@@ -620,19 +706,70 @@ func writeValueRecord(data []byte, vr ot.ValueRecord, format uint16) {
 		binary.BigEndian.PutUint16(data[off:], uint16(vr.YAdvance))
 		off += 2
 	}
-	// Device tables (not supported, write zeros if present)
-	if format&ot.ValueFormatXPlaDevice != 0 {
-		off += 2
+	for _, d := range []struct {
+		flag   uint16
+		device *ot.Device
+	}{
+		{ot.ValueFormatXPlaDevice, vr.XPlaDevice},
+		{ot.ValueFormatYPlaDevice, vr.YPlaDevice},
+		{ot.ValueFormatXAdvDevice, vr.XAdvDevice},
+		{ot.ValueFormatYAdvDevice, vr.YAdvDevice},
+	} {
+		if format&d.flag != 0 {
+			devs.add(off, d.device)
+			off += 2
+		}
 	}
-	if format&ot.ValueFormatYPlaDevice != 0 {
-		off += 2
+}
+
+// deviceTables collects the device tables referenced from one table.
+// They are written after the table, each distinct one once.
+type deviceTables struct {
+	at      []int // positions of the Offset16 fields
+	devices []*ot.Device
+}
+
+// add records a device table referenced by the offset at position at;
+// a nil device leaves the offset null.
+func (d *deviceTables) add(at int, device *ot.Device) {
+	if device == nil {
+		return
 	}
-	if format&ot.ValueFormatXAdvDevice != 0 {
-		off += 2
+	d.at = append(d.at, at)
+	d.devices = append(d.devices, device)
+}
+
+// appendTo appends the device tables to data and sets their offsets. ok
+// is false if an offset overflows.
+func (d *deviceTables) appendTo(data []byte) (out []byte, ok bool) {
+	written := make(map[uint32]int)
+	for i, at := range d.at {
+		off, done := written[d.devices[i].VarIdx]
+		if !done {
+			off = len(data)
+			written[d.devices[i].VarIdx] = off
+			data = appendDevice(data, d.devices[i])
+		}
+		if off > 0xFFFF {
+			return data, false
+		}
+		binary.BigEndian.PutUint16(data[at:], uint16(off))
 	}
-	if format&ot.ValueFormatYAdvDevice != 0 {
-		off += 2
+	return data, true
+}
+
+// appendDevice appends a VariationIndex device table.
+func appendDevice(data []byte, d *ot.Device) []byte {
+	return appendUint16s(data, uint16(d.VarIdx>>16), uint16(d.VarIdx), ot.DeltaFormatVariationIndex)
+}
+
+// appendDevices is appendTo recording an overflow in b.err.
+func (b *gposBuilder) appendDevices(data []byte, devs *deviceTables) []byte {
+	data, ok := devs.appendTo(data)
+	if !ok {
+		b.err = ErrOffsetOverflow
 	}
+	return data
 }
 
 // buildClassDefFormat2 builds a ClassDef format 2 table from class entries.
@@ -710,8 +847,8 @@ func (b *gposBuilder) subsetCursivePos(cp *ot.CursivePos) []byte {
 		if newG, ok := b.glyphMap[g]; ok {
 			entries = append(entries, cursiveEntry{
 				glyph: newG,
-				entry: records[i].EntryAnchor,
-				exit:  records[i].ExitAnchor,
+				entry: b.anchor(records[i].EntryAnchor),
+				exit:  b.anchor(records[i].ExitAnchor),
 			})
 		}
 	}
@@ -808,7 +945,7 @@ func (b *gposBuilder) subsetMarkBasePos(mb *ot.MarkBasePos) []byte {
 			marks = append(marks, markEntry{
 				glyph:  newG,
 				class:  rec.Class,
-				anchor: rec.Anchor,
+				anchor: b.anchor(rec.Anchor),
 			})
 		}
 	}
@@ -822,7 +959,7 @@ func (b *gposBuilder) subsetMarkBasePos(mb *ot.MarkBasePos) []byte {
 		if newG, ok := b.glyphMap[g]; ok {
 			bases = append(bases, baseEntry{
 				glyph:   newG,
-				anchors: baseArray.Anchors[i],
+				anchors: b.anchors(baseArray.Anchors[i]),
 			})
 		}
 	}
@@ -915,7 +1052,7 @@ func (b *gposBuilder) subsetMarkLigPos(ml *ot.MarkLigPos) []byte {
 			marks = append(marks, markEntry{
 				glyph:  newG,
 				class:  rec.Class,
-				anchor: rec.Anchor,
+				anchor: b.anchor(rec.Anchor),
 			})
 		}
 	}
@@ -928,9 +1065,13 @@ func (b *gposBuilder) subsetMarkLigPos(ml *ot.MarkLigPos) []byte {
 		}
 		if newG, ok := b.glyphMap[g]; ok {
 			la := ligArray.Attachments[i]
+			anchors := make([][]*ot.Anchor, len(la.Anchors))
+			for c, row := range la.Anchors {
+				anchors[c] = b.anchors(row)
+			}
 			ligs = append(ligs, ligEntry{
 				glyph:   newG,
-				anchors: la.Anchors,
+				anchors: anchors,
 			})
 		}
 	}
@@ -1017,7 +1158,7 @@ func (b *gposBuilder) subsetMarkMarkPos(mm *ot.MarkMarkPos) []byte {
 			mark1s = append(mark1s, markEntry{
 				glyph:  newG,
 				class:  rec.Class,
-				anchor: rec.Anchor,
+				anchor: b.anchor(rec.Anchor),
 			})
 		}
 	}
@@ -1031,7 +1172,7 @@ func (b *gposBuilder) subsetMarkMarkPos(mm *ot.MarkMarkPos) []byte {
 		if newG, ok := b.glyphMap[g]; ok {
 			mark2s = append(mark2s, baseEntry{
 				glyph:   newG,
-				anchors: mark2Array.Anchors[i],
+				anchors: b.anchors(mark2Array.Anchors[i]),
 			})
 		}
 	}
@@ -1095,13 +1236,19 @@ func (b *gposBuilder) buildMarkMarkPos(mark1s []markEntry, mark2s []baseEntry, c
 
 // --- Helper functions for building anchor-based tables ---
 
-// buildAnchor builds an Anchor table (format 1).
+// buildAnchor builds an Anchor table: format 1, or format 3 if it keeps
+// device tables.
 func buildAnchor(a *ot.Anchor) []byte {
-	// Format 1: format(2) + x(2) + y(2)
-	data := make([]byte, 6)
-	binary.BigEndian.PutUint16(data[0:], 1) // Use format 1 for simplicity
-	binary.BigEndian.PutUint16(data[2:], uint16(a.X))
-	binary.BigEndian.PutUint16(data[4:], uint16(a.Y))
+	if a.XDevice == nil && a.YDevice == nil {
+		// Format 1: format(2) + x(2) + y(2)
+		return appendUint16s(nil, 1, uint16(a.X), uint16(a.Y))
+	}
+	// Format 3: format(2) + x(2) + y(2) + xDeviceOffset(2) + yDeviceOffset(2)
+	data := appendUint16s(nil, 3, uint16(a.X), uint16(a.Y), 0, 0)
+	var devs deviceTables
+	devs.add(6, a.XDevice)
+	devs.add(8, a.YDevice)
+	data, _ = devs.appendTo(data)
 	return data
 }
 
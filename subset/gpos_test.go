@@ -174,3 +174,107 @@ func TestGPOSPairPositioning(t *testing.T) {
 	t.Logf("Subset has GPOS: %v", subFont.HasTable(ot.TagGPOS))
 	t.Logf("Subset has GDEF: %v", subFont.HasTable(ot.TagGDEF))
 }
+
+func TestGPOSInstanceDeviceDeltas(t *testing.T) {
+	// Roboto-Variable kerns space + T by -40 at the default; a
+	// VariationIndex device table in GDEF's item variation store adds -20
+	// at wght=900.
+	font := loadFont(t, "Roboto-Variable.ttf")
+	for _, tc := range []struct {
+		weight float32
+		kern   int16
+	}{
+		{400, -40},
+		{900, -60},
+	} {
+		input := NewInput()
+		input.AddString(" T")
+		input.PinAxisLocation(ot.TagAxisWeight, tc.weight)
+		plan, _, subFont := subsetAndParse(t, font, input)
+		if plan.layoutDeviceDelta() == nil {
+			t.Fatalf("wght=%v: no device deltas", tc.weight)
+		}
+
+		shape := func(text string) int16 {
+			shaper, err := ot.NewShaper(subFont)
+			if err != nil {
+				t.Fatalf("NewShaper: %v", err)
+			}
+			buf := ot.NewBuffer()
+			buf.AddString(text)
+			shaper.Shape(buf, nil)
+			return buf.Pos[0].XAdvance
+		}
+		if got := shape(" T") - shape(" "); got != tc.kern {
+			t.Errorf("wght=%v: kern %d, want %d", tc.weight, got, tc.kern)
+		}
+
+		gposData, err := subFont.TableData(ot.TagGPOS)
+		if err != nil {
+			t.Fatalf("wght=%v: subset has no GPOS", tc.weight)
+		}
+		gpos, err := ot.ParseGPOS(gposData)
+		if err != nil {
+			t.Fatalf("ParseGPOS: %v", err)
+		}
+		for i := 0; i < gpos.NumLookups(); i++ {
+			for _, st := range gpos.GetLookup(i).Subtables() {
+				if pp, ok := st.(*ot.PairPos); ok && (pp.ValueFormat1()|pp.ValueFormat2())&0xF0 != 0 {
+					t.Errorf("wght=%v: lookup %d keeps device flags %#x/%#x", tc.weight, i, pp.ValueFormat1(), pp.ValueFormat2())
+				}
+			}
+		}
+	}
+}
+
+func TestGPOSVariableDeviceTables(t *testing.T) {
+	// The device table of the space + T kern (see
+	// TestGPOSInstanceDeviceDeltas) survives plain and partially
+	// instanced subsets through the GDEF item variation store.
+	font := loadFont(t, "Roboto-Variable.ttf")
+	for _, tc := range []struct {
+		name     string
+		restrict bool
+	}{
+		{"variable", false},
+		{"restricted", true},
+	} {
+		input := NewInput()
+		input.AddString(" T")
+		if tc.restrict {
+			input.RestrictAxisRange(ot.TagAxisWeight, 400, 400, 900)
+		}
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("%s: CreatePlan: %v", tc.name, err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("%s: Execute: %v", tc.name, err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("%s: Failed to parse subset font: %v", tc.name, err)
+		}
+
+		gdefData, err := subFont.TableData(ot.TagGDEF)
+		if err != nil {
+			t.Fatalf("%s: subset has no GDEF", tc.name)
+		}
+		gdef, err := ot.ParseGDEF(gdefData)
+		if err != nil {
+			t.Fatalf("%s: ParseGDEF: %v", tc.name, err)
+		}
+		if _, minor := gdef.Version(); minor != 3 || gdef.VarStore() == nil {
+			t.Fatalf("%s: GDEF 1.%d without item variation store", tc.name, minor)
+		}
+
+		for _, weight := range []float32{400, 650, 900} {
+			want := shapeAdvances(t, font, " T", weight)
+			got := shapeAdvances(t, subFont, " T", weight)
+			if len(got) != 2 || got[0] != want[0] {
+				t.Errorf("%s: wght=%v: advances %v, want %v", tc.name, weight, got, want)
+			}
+		}
+	}
+}
@@ -9,42 +9,136 @@ import (
 
 // subsetGSUB creates a subsetted GSUB table with remapped glyph IDs.
 func (p *Plan) subsetGSUB() ([]byte, error) {
-	if p.gsub == nil {
+	if p.gsub == nil || p.gsubLayout == nil {
 		return nil, nil
 	}
 
-	// Get the 'liga' feature's lookups
-	featList, err := p.gsub.ParseFeatureList()
-	if err != nil {
-		return nil, nil
+	builder := newGSUBBuilder(p.glyphMap, p.glyphSet)
+	indices := p.gsubLookupIndices(p.gsubLayout.lookups)
+
+	// Drop lookups that lose all subtables. Whether a contextual subtable
+	// survives does not depend on the lookup map, only its nested lookup
+	// records do, so a first pass with the identity map finds them.
+	builder.lookupMap = make(map[uint16]uint16, len(indices))
+	for _, idx := range indices {
+		builder.lookupMap[idx] = idx
+	}
+	var kept []uint16
+	for _, idx := range indices {
+		lookup := p.gsub.GetLookup(int(idx))
+		if lookup == nil {
+			continue
+		}
+		if len(lookup.Subtables()) == 0 {
+			// No subtable of a known type could be read
+			p.report.skipLookup(ot.TagGSUB, idx, lookup.Type)
+			continue
+		}
+		if builder.subsetLookup(lookup) != nil {
+			kept = append(kept, idx)
+		}
 	}
 
-	ligaLookups := featList.FindFeature(ot.TagLiga)
-	if len(ligaLookups) == 0 {
+	// If no lookups remain, return nil (don't include empty GSUB)
+	if len(kept) == 0 {
 		return nil, nil
 	}
 
-	builder := newGSUBBuilder(p.glyphMap, p.glyphSet)
+	builder.lookupMap = make(map[uint16]uint16, len(kept))
+	for i, idx := range kept {
+		builder.lookupMap[idx] = uint16(i)
+	}
+	for _, idx := range kept {
+		builder.addLookup(builder.subsetLookup(p.gsub.GetLookup(int(idx))))
+	}
+	builder.features, builder.scripts, builder.variations = p.gsubLayout.subsetLists(builder.lookupMap, true)
+
+	return builder.build()
+}
 
-	// Only process lookups referenced by 'liga' feature
-	for _, lookupIdx := range ligaLookups {
-		lookup := p.gsub.GetLookup(int(lookupIdx))
+// gsubLookupIndices returns the given lookups plus every lookup reachable
+// from them through contextual rules that can still match in the subset,
+// sorted by index.
+// HarfBuzz equivalent: hb_ot_layout_lookups_substitute_closure's
+// closure_lookups pass (hb-ot-layout.cc)
+func (p *Plan) gsubLookupIndices(roots []uint16) []uint16 {
+	has := func(g ot.GlyphID) bool { return p.glyphSet[g] }
+	seen := make(map[uint16]bool)
+	queue := append([]uint16(nil), roots...)
+	for len(queue) > 0 {
+		idx := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if seen[idx] {
+			continue
+		}
+		seen[idx] = true
+		lookup := p.gsub.GetLookup(int(idx))
 		if lookup == nil {
 			continue
 		}
-
-		subsetLookup := builder.subsetLookup(lookup)
-		if subsetLookup != nil {
-			builder.addLookup(subsetLookup)
+		for _, st := range lookup.Subtables() {
+			if sc := gsubSequenceContext(st); sc != nil {
+				queue = append(queue, sequenceContextLookups(sc, has)...)
+			}
 		}
 	}
+	return sortedLookupIndices(seen)
+}
 
-	// If no lookups remain, return nil (don't include empty GSUB)
-	if len(builder.lookups) == 0 {
-		return nil, nil
+// gsubSequenceContext returns the contextual view of a GSUB 5/6
+// subtable, or nil for other types.
+func gsubSequenceContext(st ot.GSUBSubtable) *ot.SequenceContext {
+	switch st := st.(type) {
+	case *ot.ContextSubst:
+		return st.SequenceContext()
+	case *ot.ChainContextSubst:
+		return st.SequenceContext()
 	}
+	return nil
+}
 
-	return builder.build()
+// gsubSubtableType returns the lookup type implemented by a subtable;
+// used to unwrap extension lookups (type 7).
+func gsubSubtableType(st ot.GSUBSubtable) uint16 {
+	switch st.(type) {
+	case *ot.SingleSubst:
+		return ot.GSUBTypeSingle
+	case *ot.MultipleSubst:
+		return ot.GSUBTypeMultiple
+	case *ot.AlternateSubst:
+		return ot.GSUBTypeAlternate
+	case *ot.LigatureSubst:
+		return ot.GSUBTypeLigature
+	case *ot.ContextSubst:
+		return ot.GSUBTypeContext
+	case *ot.ChainContextSubst:
+		return ot.GSUBTypeChainContext
+	case *ot.ReverseChainSingleSubst:
+		return ot.GSUBTypeReverseChainSingle
+	}
+	return 0
+}
+
+// sortedLookupIndices returns the keys of set in ascending order.
+func sortedLookupIndices(set map[uint16]bool) []uint16 {
+	out := make([]uint16, 0, len(set))
+	for idx := range set {
+		out = append(out, idx)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// mapFeatureLookups renumbers a feature's lookup indices, dropping
+// removed lookups, sorted and without duplicates.
+func mapFeatureLookups(lookups []uint16, lookupMap map[uint16]uint16) []uint16 {
+	set := make(map[uint16]bool)
+	for _, idx := range lookups {
+		if n, ok := lookupMap[idx]; ok {
+			set[n] = true
+		}
+	}
+	return sortedLookupIndices(set)
 }
 
 // gsubBuilder builds a subsetted GSUB table.
@@ -54,17 +148,29 @@ type gsubBuilder struct {
 	lookups  []*lookupBuilder
 	features []featureRecord
 	scripts  []scriptRecord
+
+	// variations is the FeatureVariations table of the subset, or nil.
+	variations []byte
+
+	// lookupMap maps old to new lookup indices, for nested lookups.
+	lookupMap map[uint16]uint16
+
+	// err is set to ErrOffsetOverflow when a subtable outgrows its
+	// 16-bit offsets.
+	err error
 }
 
 type lookupBuilder struct {
 	subtables  [][]byte
 	lookupType uint16
 	flag       uint16
+	markFilter uint16
 }
 
 type featureRecord struct {
 	lookups []uint16
 	tag     ot.Tag
+	params  []byte
 }
 
 type scriptRecord struct {
@@ -95,11 +201,18 @@ func (b *gsubBuilder) subsetLookup(lookup *ot.GSUBLookup) *lookupBuilder {
 	lb := &lookupBuilder{
 		lookupType: lookup.Type,
 		flag:       lookup.Flag,
+		markFilter: lookup.MarkFilter,
 	}
+	ctx := &contextSubsetter{glyphMap: b.glyphMap, lookupMap: b.lookupMap}
 
 	for _, subtable := range lookup.Subtables() {
 		var data []byte
 
+		// Extension subtables are parsed through; write them unwrapped.
+		if lookup.Type == ot.GSUBTypeExtension {
+			lb.lookupType = gsubSubtableType(subtable)
+		}
+
 		switch st := subtable.(type) {
 		case *ot.SingleSubst:
 			data = b.subsetSingleSubst(st)
@@ -109,7 +222,12 @@ func (b *gsubBuilder) subsetLookup(lookup *ot.GSUBLookup) *lookupBuilder {
 			data = b.subsetAlternateSubst(st)
 		case *ot.LigatureSubst:
 			data = b.subsetLigatureSubst(st)
-			// TODO: Context, ChainContext, Extension
+		case *ot.ContextSubst:
+			data = ctx.subset(st.SequenceContext())
+		case *ot.ChainContextSubst:
+			data = ctx.subset(st.SequenceContext())
+		case *ot.ReverseChainSingleSubst:
+			data = b.subsetReverseChainSingleSubst(st)
 		}
 
 		if data != nil && len(data) > 0 {
@@ -117,6 +235,9 @@ func (b *gsubBuilder) subsetLookup(lookup *ot.GSUBLookup) *lookupBuilder {
 		}
 	}
 
+	if ctx.err != nil {
+		b.err = ctx.err
+	}
 	if len(lb.subtables) == 0 {
 		return nil
 	}
@@ -535,153 +656,85 @@ func (b *gsubBuilder) buildLigatureSubst(sets []ligatureSetEntry) []byte {
 	return data
 }
 
-// build serializes the GSUB table.
-func (b *gsubBuilder) build() ([]byte, error) {
-	if len(b.lookups) == 0 {
-		return nil, nil
+// subsetReverseChainSingleSubst subsets a ReverseChainSingleSubst
+// subtable (GSUB type 8). It is dropped if a backtrack or lookahead
+// position loses all of its glyphs.
+func (b *gsubBuilder) subsetReverseChainSingleSubst(st *ot.ReverseChainSingleSubst) []byte {
+	ctx := &contextSubsetter{glyphMap: b.glyphMap}
+	remapAll := func(covs []*ot.Coverage) ([][]byte, bool) {
+		out := make([][]byte, len(covs))
+		for i, cov := range covs {
+			glyphs := ctx.remapCoverage(cov)
+			if len(glyphs) == 0 {
+				return nil, false
+			}
+			out[i] = buildCoverageFormat1(glyphs)
+		}
+		return out, true
+	}
+	backtrack, ok1 := remapAll(st.BacktrackCoverages())
+	lookahead, ok2 := remapAll(st.LookaheadCoverages())
+	if !ok1 || !ok2 || st.Coverage() == nil {
+		return nil
 	}
 
-	// Build lookup list
-	lookupList := b.buildLookupList()
-
-	// Build minimal script list (DFLT/dflt)
-	scriptList := b.buildScriptList(len(b.lookups))
-
-	// Build feature list (all lookups under 'liga' and 'kern' features)
-	featureList := b.buildFeatureList(len(b.lookups))
-
-	// GSUB header: version(4) + scriptListOff(2) + featureListOff(2) + lookupListOff(2)
-	headerSize := 10
-
-	scriptListOff := headerSize
-	featureListOff := scriptListOff + len(scriptList)
-	lookupListOff := featureListOff + len(featureList)
-
-	totalSize := lookupListOff + len(lookupList)
-	data := make([]byte, totalSize)
-
-	// Version 1.0
-	binary.BigEndian.PutUint16(data[0:], 1)
-	binary.BigEndian.PutUint16(data[2:], 0)
-	binary.BigEndian.PutUint16(data[4:], uint16(scriptListOff))
-	binary.BigEndian.PutUint16(data[6:], uint16(featureListOff))
-	binary.BigEndian.PutUint16(data[8:], uint16(lookupListOff))
-
-	copy(data[scriptListOff:], scriptList)
-	copy(data[featureListOff:], featureList)
-	copy(data[lookupListOff:], lookupList)
-
-	return data, nil
-}
-
-func (b *gsubBuilder) buildLookupList() []byte {
-	// LookupList: lookupCount(2) + lookupOffsets[](2*n) + Lookup tables
-	headerSize := 2 + len(b.lookups)*2
-
-	lookupData := make([]byte, 0)
-	lookupOffsets := make([]uint16, len(b.lookups))
-
-	for i, lookup := range b.lookups {
-		lookupOffsets[i] = uint16(headerSize + len(lookupData))
-
-		// Lookup: lookupType(2) + lookupFlag(2) + subTableCount(2) + subTableOffsets[](2*n)
-		lookupHeaderSize := 6 + len(lookup.subtables)*2
-
-		subtableData := make([]byte, 0)
-		subtableOffsets := make([]uint16, len(lookup.subtables))
-
-		for j, st := range lookup.subtables {
-			subtableOffsets[j] = uint16(lookupHeaderSize + len(subtableData))
-			subtableData = append(subtableData, st...)
+	var entries []struct{ in, out ot.GlyphID }
+	subs := st.Substitutes()
+	for i, g := range st.Coverage().Glyphs() {
+		if i >= len(subs) {
+			break
 		}
-
-		lookupTable := make([]byte, lookupHeaderSize+len(subtableData))
-		binary.BigEndian.PutUint16(lookupTable[0:], lookup.lookupType)
-		binary.BigEndian.PutUint16(lookupTable[2:], lookup.flag)
-		binary.BigEndian.PutUint16(lookupTable[4:], uint16(len(lookup.subtables)))
-		for j, off := range subtableOffsets {
-			binary.BigEndian.PutUint16(lookupTable[6+j*2:], off)
+		newIn, okIn := b.glyphMap[g]
+		newOut, okOut := b.glyphMap[subs[i]]
+		if okIn && okOut {
+			entries = append(entries, struct{ in, out ot.GlyphID }{newIn, newOut})
 		}
-		copy(lookupTable[lookupHeaderSize:], subtableData)
-
-		lookupData = append(lookupData, lookupTable...)
 	}
-
-	data := make([]byte, headerSize+len(lookupData))
-	binary.BigEndian.PutUint16(data[0:], uint16(len(b.lookups)))
-	for i, off := range lookupOffsets {
-		binary.BigEndian.PutUint16(data[2+i*2:], off)
+	if len(entries) == 0 {
+		return nil
 	}
-	copy(data[headerSize:], lookupData)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].in < entries[j].in })
 
-	return data
-}
-
-func (b *gsubBuilder) buildScriptList(numLookups int) []byte {
-	// Minimal script list: DFLT script with dflt language system
-	// ScriptList: scriptCount(2) + scriptRecords[](6*n) + Script tables
-
-	// ScriptRecord: scriptTag(4) + scriptOffset(2)
-	scriptCount := 1
-	headerSize := 2 + scriptCount*6
-
-	// Script table: defaultLangSys(2) + langSysCount(2)
-	// DefaultLangSys: lookupOrder(2) + reqFeatureIndex(2) + featureIndexCount(2) + featureIndices[](2*n)
-	langSysSize := 6 + 2 // One feature index
-	scriptSize := 4 + langSysSize
-
-	totalSize := headerSize + scriptSize
-	data := make([]byte, totalSize)
-
-	// Header
-	binary.BigEndian.PutUint16(data[0:], uint16(scriptCount))
-	// DFLT script tag
-	copy(data[2:], []byte("DFLT"))
-	binary.BigEndian.PutUint16(data[6:], uint16(headerSize))
-
-	// Script table
-	scriptOff := headerSize
-	binary.BigEndian.PutUint16(data[scriptOff:], 4)   // offset to default LangSys (relative to script)
-	binary.BigEndian.PutUint16(data[scriptOff+2:], 0) // no other language systems
-
-	// DefaultLangSys
-	langSysOff := scriptOff + 4
-	binary.BigEndian.PutUint16(data[langSysOff:], 0)        // lookupOrder (reserved)
-	binary.BigEndian.PutUint16(data[langSysOff+2:], 0xFFFF) // no required feature
-	binary.BigEndian.PutUint16(data[langSysOff+4:], 1)      // 1 feature
-	binary.BigEndian.PutUint16(data[langSysOff+6:], 0)      // feature index 0
+	glyphs := make([]ot.GlyphID, len(entries))
+	for i, e := range entries {
+		glyphs[i] = e.in
+	}
 
+	// ReverseChainSingleSubstFormat1: format(2) + coverageOffset(2) +
+	// backtrackGlyphCount(2) + backtrackCoverageOffsets[] +
+	// lookaheadGlyphCount(2) + lookaheadCoverageOffsets[] +
+	// glyphCount(2) + substituteGlyphIDs[]
+	header := appendUint16s(nil, 1, 0)
+	offsets := []int{2}
+	children := [][]byte{buildCoverageFormat1(glyphs)}
+	for _, covs := range [][][]byte{backtrack, lookahead} {
+		header = appendUint16s(header, uint16(len(covs)))
+		for _, cov := range covs {
+			offsets = append(offsets, len(header))
+			children = append(children, cov)
+			header = append(header, 0, 0)
+		}
+	}
+	header = appendUint16s(header, uint16(len(entries)))
+	for _, e := range entries {
+		header = appendUint16s(header, uint16(e.out))
+	}
+	data := ctx.pack(header, offsets, children)
+	if ctx.err != nil {
+		b.err = ctx.err
+	}
 	return data
 }
 
-func (b *gsubBuilder) buildFeatureList(numLookups int) []byte {
-	// Single 'liga' feature that includes all lookups
-	// FeatureList: featureCount(2) + featureRecords[](6*n) + Feature tables
-
-	featureCount := 1
-	headerSize := 2 + featureCount*6
-
-	// Feature table: featureParams(2) + lookupIndexCount(2) + lookupListIndices[](2*n)
-	featureSize := 4 + numLookups*2
-
-	totalSize := headerSize + featureSize
-	data := make([]byte, totalSize)
-
-	// Header
-	binary.BigEndian.PutUint16(data[0:], uint16(featureCount))
-	// 'liga' feature tag
-	copy(data[2:], []byte("liga"))
-	binary.BigEndian.PutUint16(data[6:], uint16(headerSize))
-
-	// Feature table
-	featureOff := headerSize
-	binary.BigEndian.PutUint16(data[featureOff:], 0) // no feature params
-	binary.BigEndian.PutUint16(data[featureOff+2:], uint16(numLookups))
-	for i := 0; i < numLookups; i++ {
-		binary.BigEndian.PutUint16(data[featureOff+4+i*2:], uint16(i))
+// build serializes the GSUB table.
+func (b *gsubBuilder) build() ([]byte, error) {
+	if len(b.lookups) == 0 {
+		return nil, nil
 	}
-
-	return data
+	if b.err != nil {
+		return nil, b.err
+	}
+	return buildLayoutTable(b.scripts, b.features, b.lookups, b.variations, ot.GSUBTypeExtension)
 }
 
 // buildCoverageFormat1 builds a format 1 coverage table from sorted glyphs.
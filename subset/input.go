@@ -8,6 +8,9 @@ type Input struct {
 	// Unicodes specifies Unicode codepoints to retain.
 	unicodes map[rune]bool
 
+	// variations specifies Unicode Variation Sequences to retain.
+	variations map[variationSequence]bool
+
 	// Glyphs specifies explicit glyph IDs to retain.
 	glyphs map[ot.GlyphID]bool
 
@@ -18,28 +21,60 @@ type Input struct {
 	passThroughTables map[ot.Tag]bool
 
 	// LayoutFeatures specifies OpenType features to retain.
-	// If empty, all features are retained.
+	// If empty, defaultLayoutFeatures are retained.
 	layoutFeatures map[ot.Tag]bool
 
+	// allLayoutFeatures retains every feature, overriding layoutFeatures.
+	allLayoutFeatures bool
+
+	// layoutScripts specifies GSUB/GPOS script tags to retain.
+	// If empty, all scripts are retained.
+	layoutScripts map[ot.Tag]bool
+
+	// nameIDs specifies name table IDs to retain.
+	// If empty, defaultNameIDs are retained.
+	nameIDs map[uint16]bool
+
+	// nameLanguages specifies name table language IDs to retain.
+	// If empty, only English (United States) names are retained.
+	nameLanguages map[uint16]bool
+
 	// pinnedAxes maps axis tags to pinned values (design-space coordinates).
 	// When axes are pinned, the font is instanced (variation tables removed).
 	pinnedAxes map[ot.Tag]float32
 
+	// axisRanges maps axis tags to restricted ranges (design-space
+	// coordinates). The font stays variable within the ranges.
+	axisRanges map[ot.Tag]axisRange
+
+	// strikePPEM selects the bitmap strikes of CBLC and sbix to retain.
+	// If zero, all strikes are retained.
+	strikePPEM int
+
 	// Flags controls subsetting behavior.
 	Flags Flags
 }
 
+// variationSequence is a base character with a variation selector.
+type variationSequence struct {
+	base, selector rune
+}
+
 // Flags controls various subsetting options.
 type Flags uint32
 
 const (
-	// FlagNoHinting removes hinting instructions.
+	// FlagNoHinting removes hinting: glyph instructions, CFF hint
+	// operators and the hinting tables (cvt, fpgm, prep, cvar, hdmx,
+	// LTSH, VDMX, gasp).
 	FlagNoHinting Flags = 1 << iota
 
 	// FlagRetainGIDs keeps original glyph IDs (pads with empty glyphs).
 	FlagRetainGIDs
 
-	// FlagGlyphNames retains PostScript glyph names.
+	// FlagGlyphNames retains PostScript glyph names: the post table is
+	// written as version 2.0 with the names of the retained glyphs.
+	// Without it, post is written as version 3.0 without names.
 	FlagGlyphNames
 
 	// FlagNotdefOutline retains the .notdef glyph outline.
@@ -54,17 +89,42 @@ const (
 	// FlagDropLayoutTables excludes GSUB/GPOS/GDEF tables from output.
 	// Use this for PDF embedding where shaping is already done.
 	FlagDropLayoutTables
+
+	// FlagDesubroutinize inlines all CFF subroutines into the
+	// charstrings.
+	FlagDesubroutinize
+
+	// FlagSubroutinize rebuilds the CFF subroutines from the charstrings
+	// of the retained glyphs instead of subsetting the original ones.
+	// This usually gives a smaller font when few glyphs are kept; a
+	// subset too small to have repeated outline parts ends up
+	// desubroutinized.
+	FlagSubroutinize
+
+	// FlagMacRomanCmap adds a Mac Roman (platform 1, encoding 0) cmap
+	// subtable for consumers that still need it.
+	FlagMacRomanCmap
+
+	// FlagLenient drops tables that fail to subset instead of failing
+	// Execute. The dropped tables and their errors are listed in
+	// Plan.Report.
+	FlagLenient
 )
 
 // NewInput creates a new subset input configuration.
 func NewInput() *Input {
 	return &Input{
 		unicodes:          make(map[rune]bool),
+		variations:        make(map[variationSequence]bool),
 		glyphs:            make(map[ot.GlyphID]bool),
 		dropTables:        make(map[ot.Tag]bool),
 		passThroughTables: make(map[ot.Tag]bool),
 		layoutFeatures:    make(map[ot.Tag]bool),
+		layoutScripts:     make(map[ot.Tag]bool),
+		nameIDs:           make(map[uint16]bool),
+		nameLanguages:     make(map[uint16]bool),
 		pinnedAxes:        make(map[ot.Tag]float32),
+		axisRanges:        make(map[ot.Tag]axisRange),
 	}
 }
 
@@ -94,6 +154,16 @@ func (i *Input) AddString(s string) {
 	}
 }
 
+// AddVariationSequence adds a Unicode Variation Sequence to retain: a
+// base character followed by a variation selector, such as an
+// ideographic variation sequence or an emoji presentation sequence. The
+// base character and the variant glyph of the sequence are retained, and
+// the sequence is kept in the cmap format 14 subtable.
+func (i *Input) AddVariationSequence(base, selector rune) {
+	i.unicodes[base] = true
+	i.variations[variationSequence{base, selector}] = true
+}
+
 // AddGlyph adds a glyph ID to retain.
 func (i *Input) AddGlyph(gid ot.GlyphID) {
 	i.glyphs[gid] = true
@@ -117,11 +187,55 @@ func (i *Input) PassThroughTable(tag ot.Tag) {
 }
 
 // KeepFeature marks an OpenType feature to retain.
-// If no features are specified, all features are retained.
+// If no features are specified, the default feature set of hb-subset is
+// retained (common shaping features, but not e.g. 'aalt', 'salt' or
+// 'ssXX'). Lookups and glyphs only reachable from other features are
+// dropped.
+// This is similar to hb-subset's --layout-features.
 func (i *Input) KeepFeature(tag ot.Tag) {
 	i.layoutFeatures[tag] = true
 }
 
+// KeepAllFeatures retains every GSUB/GPOS feature.
+// This is similar to hb-subset's --layout-features='*'.
+func (i *Input) KeepAllFeatures() {
+	i.allLayoutFeatures = true
+}
+
+// KeepScript marks a GSUB/GPOS script tag (e.g. 'latn', 'DFLT') to
+// retain. If no scripts are specified, all scripts are retained.
+// This is similar to hb-subset's --layout-scripts.
+func (i *Input) KeepScript(tag ot.Tag) {
+	i.layoutScripts[tag] = true
+}
+
+// KeepNameID marks a name table ID to retain. If no name IDs are
+// specified, IDs 0 to 6 (copyright, family, subfamily, unique ID, full
+// name, version and PostScript name) are retained. Name IDs referenced by
+// the retained fvar and STAT tables are always kept.
+// This is similar to hb-subset's --name-IDs.
+func (i *Input) KeepNameID(id uint16) {
+	i.nameIDs[id] = true
+}
+
+// KeepNameLanguage marks a name table language ID (e.g. 0x409 for
+// Windows English (United States)) to retain. If no languages are
+// specified, only 0x409 is retained. Only Unicode encoded name records
+// are kept.
+// This is similar to hb-subset's --name-languages.
+func (i *Input) KeepNameLanguage(lang uint16) {
+	i.nameLanguages[lang] = true
+}
+
+// KeepStrikeNearest retains only the bitmap strikes of the CBLC and
+// sbix tables that a renderer would pick at ppem: the smallest strike
+// at least as large as ppem, or the largest strike if all are smaller.
+// Strikes of the same size are all kept. By default all strikes are
+// retained.
+func (i *Input) KeepStrikeNearest(ppem int) {
+	i.strikePPEM = ppem
+}
+
 // Unicodes returns the set of Unicode codepoints to retain.
 func (i *Input) Unicodes() map[rune]bool {
 	return i.unicodes
@@ -132,8 +246,16 @@ func (i *Input) Glyphs() map[ot.GlyphID]bool {
 	return i.glyphs
 }
 
-// ShouldDropTable returns true if the table should be excluded.
+// hintingTables are dropped with FlagNoHinting.
+// HarfBuzz equivalent: _should_drop_table (hb-subset.cc)
+var hintingTables = tagSet("cvt ", "fpgm", "prep", "cvar", "hdmx", "LTSH", "VDMX", "gasp")
+
+// ShouldDropTable returns true if the table should be excluded. With
+// FlagNoHinting this includes the hinting tables.
 func (i *Input) ShouldDropTable(tag ot.Tag) bool {
+	if i.Flags&FlagNoHinting != 0 && hintingTables[tag] {
+		return true
+	}
 	return i.dropTables[tag]
 }
 
@@ -149,20 +271,100 @@ func (i *Input) HasLayoutFeatures() bool {
 
 // ShouldKeepFeature returns true if the feature should be retained.
 func (i *Input) ShouldKeepFeature(tag ot.Tag) bool {
+	if i.allLayoutFeatures {
+		return true
+	}
 	if len(i.layoutFeatures) == 0 {
-		return true // Keep all if none specified
+		return defaultLayoutFeatures[tag]
 	}
 	return i.layoutFeatures[tag]
 }
 
+// ShouldKeepScript returns true if the script should be retained.
+func (i *Input) ShouldKeepScript(tag ot.Tag) bool {
+	if len(i.layoutScripts) == 0 {
+		return true // Keep all if none specified
+	}
+	return i.layoutScripts[tag]
+}
+
+// ShouldKeepNameID returns true if name records with the ID should be
+// retained.
+func (i *Input) ShouldKeepNameID(id uint16) bool {
+	if len(i.nameIDs) == 0 {
+		return defaultNameIDs[id]
+	}
+	return i.nameIDs[id]
+}
+
+// ShouldKeepNameLanguage returns true if name records with the language
+// ID should be retained.
+func (i *Input) ShouldKeepNameLanguage(lang uint16) bool {
+	if len(i.nameLanguages) == 0 {
+		return lang == defaultNameLanguage
+	}
+	return i.nameLanguages[lang]
+}
+
+// ShouldKeepStrike returns true if the bitmap strike with ppem should be
+// retained, given the ppems of all strikes of the table.
+func (i *Input) ShouldKeepStrike(ppem int, ppems []int) bool {
+	if i.strikePPEM <= 0 {
+		return true
+	}
+	best := -1
+	for _, s := range ppems {
+		if best < 0 || (i.strikePPEM <= s && s < best) || (i.strikePPEM > best && s > best) {
+			best = s
+		}
+	}
+	return ppem == best
+}
+
 // --- Variation Axis Pinning (Instancing) ---
 
+// axisRange is a restricted axis range in design-space coordinates.
+type axisRange struct {
+	min, def, max float32
+}
+
 // PinAxisLocation pins a variation axis to a specific value.
 // When all axes are pinned, the font is "instanced" to a static font.
 // The value should be in design-space coordinates (e.g., 700 for Bold weight).
 // This is similar to HarfBuzz's hb_subset_input_pin_axis_location().
 func (i *Input) PinAxisLocation(axisTag ot.Tag, value float32) {
 	i.pinnedAxes[axisTag] = value
+	delete(i.axisRanges, axisTag)
+}
+
+// RestrictAxisRange limits a variation axis to [min, max] with a new
+// default value def, keeping the font variable within that range
+// ("partial instancing"). Values are in design-space coordinates and are
+// clamped to the axis range of the font; def is clamped to [min, max].
+// A range with min == max pins the axis.
+//
+// When any axis is restricted, the subset keeps its variation tables:
+// variation data outside the ranges is dropped, the remaining data is
+// renormalized, and named instances outside the ranges are removed.
+// Axes that are neither pinned nor restricted keep their full range.
+// This is similar to HarfBuzz's hb_subset_input_set_axis_range() and
+// fontTools' instancer.
+func (i *Input) RestrictAxisRange(axisTag ot.Tag, min, def, max float32) {
+	if min > max {
+		min, max = max, min
+	}
+	if min == max {
+		i.PinAxisLocation(axisTag, min)
+		return
+	}
+	def = clampFloat32(def, min, max)
+	i.axisRanges[axisTag] = axisRange{min: min, def: def, max: max}
+	delete(i.pinnedAxes, axisTag)
+}
+
+// HasAxisRanges returns true if any axis range has been restricted.
+func (i *Input) HasAxisRanges() bool {
+	return len(i.axisRanges) > 0
 }
 
 // PinAxisToDefault pins a variation axis to its default value.
@@ -184,7 +386,7 @@ func (i *Input) PinAxisToDefault(font *ot.Font, axisTag ot.Tag) bool {
 	if !found {
 		return false
 	}
-	i.pinnedAxes[axisTag] = axis.DefaultValue
+	i.PinAxisLocation(axisTag, axis.DefaultValue)
 	return true
 }
 
@@ -204,7 +406,7 @@ func (i *Input) PinAllAxesToDefault(font *ot.Font) bool {
 		return false
 	}
 	for _, axis := range fvar.AxisInfos() {
-		i.pinnedAxes[axis.Tag] = axis.DefaultValue
+		i.PinAxisLocation(axis.Tag, axis.DefaultValue)
 	}
 	return true
 }
@@ -240,3 +442,13 @@ func (i *Input) IsFullyInstanced(font *ot.Font) bool {
 	}
 	return true
 }
+
+func clampFloat32(v, lo, hi float32) float32 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
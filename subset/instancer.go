@@ -0,0 +1,676 @@
+package subset
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// Partial instancing: restricting axis ranges while keeping the font
+// variable. Outlines and advances are first instanced at the new default
+// location (instanceGlyphs, computeInstancedAdvances); the remaining
+// variation data is then re-expressed over the restricted ranges.
+//
+// HarfBuzz equivalent: hb-subset-instancer-solver.cc and the
+// TupleVariationData/item_variations_t instancing code
+// (hb-ot-var-common.hh); fontTools.varLib.instancer (L3 instancing).
+
+// partialInstance describes the restricted axes of a partial instance.
+type partialInstance struct {
+	axes []ot.AxisInfo // source axes
+
+	// limits are the restricted ranges in the avar-mapped normalized
+	// coordinates of the source font, nil for unchanged axes. Pinned
+	// axes have min == def == max and are removed from the font.
+	limits []*axisLimit
+
+	// defaultLimits are the same ranges normalized without avar.
+	defaultLimits []*axisLimit
+
+	// user are the new design-space ranges of every source axis.
+	user []axisRange
+}
+
+// planPartialInstance computes the axis limits of restricted and pinned
+// axes.
+func (p *Plan) planPartialInstance() {
+	p.partial = p.newPartialInstance()
+}
+
+// newPartialInstance returns the axis limits of the input, or nil if the
+// font has no fvar table.
+func (p *Plan) newPartialInstance() *partialInstance {
+	if p.fvar == nil {
+		return nil
+	}
+	pi := &partialInstance{axes: p.fvar.AxisInfos()}
+	pi.limits = make([]*axisLimit, len(pi.axes))
+	pi.defaultLimits = make([]*axisLimit, len(pi.axes))
+	pi.user = make([]axisRange, len(pi.axes))
+
+	for i, axis := range pi.axes {
+		r := axisRange{axis.MinValue, axis.DefaultValue, axis.MaxValue}
+		if v, ok := p.input.pinnedAxes[axis.Tag]; ok {
+			v = clampFloat32(v, axis.MinValue, axis.MaxValue)
+			r = axisRange{v, v, v}
+		} else if rr, ok := p.input.axisRanges[axis.Tag]; ok {
+			r.min = clampFloat32(rr.min, axis.MinValue, axis.MaxValue)
+			r.max = clampFloat32(rr.max, axis.MinValue, axis.MaxValue)
+			r.def = clampFloat32(rr.def, r.min, r.max)
+		} else {
+			pi.user[i] = r
+			continue
+		}
+		pi.user[i] = r
+
+		distNeg := float64(axis.DefaultValue - axis.MinValue)
+		distPos := float64(axis.MaxValue - axis.DefaultValue)
+		normalize := func(v float32, avar bool) float64 {
+			n := floatToF2DOT14(p.fvar.NormalizeAxisValue(i, v))
+			if avar && p.avar != nil && p.avar.HasData() {
+				n = p.avar.MapValue(i, n)
+			}
+			return float64(n) / 16384
+		}
+		pi.limits[i] = &axisLimit{normalize(r.min, true), normalize(r.def, true), normalize(r.max, true), distNeg, distPos}
+		pi.defaultLimits[i] = &axisLimit{normalize(r.min, false), normalize(r.def, false), normalize(r.max, false), distNeg, distPos}
+	}
+	return pi
+}
+
+// kept reports whether a source axis remains in the font.
+func (pi *partialInstance) kept(axis int) bool {
+	return pi.limits[axis] == nil || !pi.limits[axis].isPinned()
+}
+
+// axisCount returns the number of axes of the output font.
+func (pi *partialInstance) axisCount() int {
+	n := 0
+	for i := range pi.axes {
+		if pi.kept(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// outputAxis returns the index of a kept source axis in the output font.
+func (pi *partialInstance) outputAxis(axis int) uint16 {
+	n := 0
+	for i := 0; i < axis; i++ {
+		if pi.kept(i) {
+			n++
+		}
+	}
+	return uint16(n)
+}
+
+// solveRegion re-expresses a region of the source font over the
+// restricted axes. Regions of the result use the output axes; a nil
+// region is a contribution at the new default location.
+func (pi *partialInstance) solveRegion(region []ot.RegionAxisCoordinates) []regionSolution {
+	type partial struct {
+		scalar float64
+		tents  []tent
+	}
+	tents := make([]tent, len(pi.axes))
+	for i := range tents {
+		if i < len(region) {
+			a := region[i]
+			tents[i] = tent{float64(a.Start) / 16384, float64(a.Peak) / 16384, float64(a.End) / 16384}
+		}
+	}
+	sols := []partial{{1, tents}}
+
+	for i, limit := range pi.limits {
+		if limit == nil {
+			continue
+		}
+		var next []partial
+		for _, s := range sols {
+			t := s.tents[i]
+			if t.peak == 0 {
+				next = append(next, s)
+				continue
+			}
+			if limit.isPinned() {
+				if scalar := supportScalar(limit.def, t); scalar != 0 {
+					s.scalar *= scalar
+					s.tents[i] = tent{}
+					next = append(next, s)
+				}
+				continue
+			}
+			for _, ts := range solveTent(t, *limit) {
+				ns := partial{s.scalar * ts.scalar, append([]tent(nil), s.tents...)}
+				ns.tents[i] = tent{}
+				if ts.region != nil {
+					ns.tents[i] = *ts.region
+				}
+				next = append(next, ns)
+			}
+		}
+		sols = next
+	}
+
+	out := make([]regionSolution, 0, len(sols))
+	for _, s := range sols {
+		var region []ot.RegionAxisCoordinates
+		isDefault := true
+		for i, t := range s.tents {
+			if !pi.kept(i) {
+				continue
+			}
+			a := ot.RegionAxisCoordinates{Start: toF2DOT14(t.lower), Peak: toF2DOT14(t.peak), End: toF2DOT14(t.upper)}
+			if a.Peak != 0 {
+				isDefault = false
+			}
+			region = append(region, a)
+		}
+		if isDefault {
+			region = nil
+		}
+		out = append(out, regionSolution{s.scalar, region})
+	}
+	return out
+}
+
+// toF2DOT14 quantizes a normalized coordinate.
+func toF2DOT14(v float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(v*16384))))
+}
+
+// instanceFvar writes the axes with their new ranges, without pinned
+// axes, and the named instances that lie within the ranges.
+// HarfBuzz equivalent: fvar::subset (hb-ot-var-fvar-table.hh)
+func (p *Plan) instanceFvar() ([]byte, error) {
+	pi := p.partial
+	instances := p.fvar.NamedInstances()
+	withPSName := false
+	for _, inst := range instances {
+		if inst.PostScriptNameID != 0 {
+			withPSName = true
+		}
+	}
+
+	axisCount := pi.axisCount()
+	instanceSize := 4 + axisCount*4
+	if withPSName {
+		instanceSize += 2
+	}
+
+	var kept []ot.NamedInstance
+	for _, inst := range instances {
+		inside := true
+		for i, v := range inst.Coords {
+			if i < len(pi.user) && (v < pi.user[i].min || v > pi.user[i].max) {
+				inside = false
+			}
+		}
+		if inside {
+			kept = append(kept, inst)
+		}
+	}
+
+	out := appendUint16s(nil, 1, 0, 16, 2, uint16(axisCount), 20, uint16(len(kept)), uint16(instanceSize))
+	for i, axis := range pi.axes {
+		if !pi.kept(i) {
+			continue
+		}
+		r := pi.user[i]
+		out = binary.BigEndian.AppendUint32(out, uint32(axis.Tag))
+		out = binary.BigEndian.AppendUint32(out, floatToFixed(r.min))
+		out = binary.BigEndian.AppendUint32(out, floatToFixed(r.def))
+		out = binary.BigEndian.AppendUint32(out, floatToFixed(r.max))
+		out = appendUint16s(out, uint16(axis.Flags), axis.NameID)
+	}
+	for _, inst := range kept {
+		out = appendUint16s(out, inst.SubfamilyNameID, 0)
+		for i, v := range inst.Coords {
+			if i < len(pi.axes) && pi.kept(i) {
+				out = binary.BigEndian.AppendUint32(out, floatToFixed(v))
+			}
+		}
+		if withPSName {
+			out = appendUint16s(out, inst.PostScriptNameID)
+		}
+	}
+	return out, nil
+}
+
+// floatToFixed converts to 16.16 fixed point.
+func floatToFixed(v float32) uint32 {
+	return uint32(int32(math.Round(float64(v) * 65536)))
+}
+
+// instanceAvar renormalizes the segment maps of restricted axes and
+// drops those of pinned axes. It returns nil if no map is left that
+// differs from the identity.
+// HarfBuzz equivalent: avar::subset (hb-ot-var-avar-table.hh)
+func (p *Plan) instanceAvar() ([]byte, error) {
+	pi := p.partial
+	if p.avar == nil || !p.avar.HasData() {
+		return nil, nil
+	}
+
+	var maps [][]ot.AxisValueMap
+	identity := true
+	for i := range pi.axes {
+		if !pi.kept(i) {
+			continue
+		}
+		segments := p.avar.SegmentMap(i)
+		if dl, ml := pi.defaultLimits[i], pi.limits[i]; dl != nil && len(segments) > 0 {
+			byFrom := map[int16]int16{-16384: -16384, 0: 0, 16384: 16384}
+			for _, s := range segments {
+				from := float64(s.FromCoord) / 16384
+				if from < dl.min || from > dl.max {
+					continue
+				}
+				f := toF2DOT14(dl.renormalize(from))
+				if f == -16384 || f == 0 || f == 16384 {
+					continue
+				}
+				byFrom[f] = toF2DOT14(ml.renormalize(float64(s.ToCoord) / 16384))
+			}
+			segments = segments[:0:0]
+			for from, to := range byFrom {
+				segments = append(segments, ot.AxisValueMap{FromCoord: from, ToCoord: to})
+			}
+			sort.Slice(segments, func(a, b int) bool { return segments[a].FromCoord < segments[b].FromCoord })
+		}
+		for _, s := range segments {
+			if s.FromCoord != s.ToCoord {
+				identity = false
+			}
+		}
+		maps = append(maps, segments)
+	}
+	if identity {
+		return nil, nil
+	}
+
+	out := appendUint16s(nil, 1, 0, 0, uint16(len(maps)))
+	for _, segments := range maps {
+		out = appendUint16s(out, uint16(len(segments)))
+		for _, s := range segments {
+			out = appendUint16s(out, uint16(s.FromCoord), uint16(s.ToCoord))
+		}
+	}
+	return out, nil
+}
+
+// instanceSTAT drops axis value tables whose values lie outside the
+// restricted ranges. Design axis records are kept: STAT may describe
+// axes that are not in fvar.
+// HarfBuzz equivalent: STAT::subset (hb-ot-stat-table.hh)
+func (p *Plan) instanceSTAT() ([]byte, error) {
+	data, err := p.source.TableData(ot.TagSTAT)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 18 {
+		return nil, ot.ErrInvalidTable
+	}
+	designAxisSize := int(binary.BigEndian.Uint16(data[4:]))
+	designAxisCount := int(binary.BigEndian.Uint16(data[6:]))
+	designAxesOffset := int(binary.BigEndian.Uint32(data[8:]))
+	axisValueCount := int(binary.BigEndian.Uint16(data[12:]))
+	valuesOffset := int(binary.BigEndian.Uint32(data[14:]))
+	if designAxesOffset+designAxisCount*designAxisSize > len(data) || valuesOffset+axisValueCount*2 > len(data) {
+		return nil, ot.ErrInvalidOffset
+	}
+
+	// Range per design axis, by tag.
+	ranges := make([]*axisRange, designAxisCount)
+	for i := range ranges {
+		tag := ot.Tag(binary.BigEndian.Uint32(data[designAxesOffset+i*designAxisSize:]))
+		for j, axis := range p.partial.axes {
+			if axis.Tag == tag && p.partial.limits[j] != nil {
+				ranges[i] = &p.partial.user[j]
+			}
+		}
+	}
+	inside := func(axisIndex int, value uint32) bool {
+		if axisIndex >= len(ranges) || ranges[axisIndex] == nil {
+			return true
+		}
+		v := float32(int32(value)) / 65536
+		return v >= ranges[axisIndex].min && v <= ranges[axisIndex].max
+	}
+
+	out := append([]byte(nil), data...)
+	kept := 0
+	for i := 0; i < axisValueCount; i++ {
+		off := binary.BigEndian.Uint16(data[valuesOffset+i*2:])
+		v := data[valuesOffset+int(off):]
+		keep := true
+		if len(v) >= 12 {
+			switch format := binary.BigEndian.Uint16(v); format {
+			case 1, 2, 3:
+				keep = inside(int(binary.BigEndian.Uint16(v[2:])), binary.BigEndian.Uint32(v[8:]))
+			case 4:
+				count := int(binary.BigEndian.Uint16(v[2:]))
+				for k := 0; k < count && 8+k*6+6 <= len(v); k++ {
+					rec := v[8+k*6:]
+					keep = keep && inside(int(binary.BigEndian.Uint16(rec)), binary.BigEndian.Uint32(rec[2:]))
+				}
+			}
+		}
+		if keep {
+			binary.BigEndian.PutUint16(out[valuesOffset+kept*2:], off)
+			kept++
+		}
+	}
+	binary.BigEndian.PutUint16(out[12:], uint16(kept))
+	return out, nil
+}
+
+// instanceGvar re-expresses the tuple variations of every retained glyph
+// over the restricted axes. The outlines in glyf already are at the new
+// default location, so contributions at the default are dropped. All
+// tuples carry deltas for every point.
+// HarfBuzz equivalent: glyph_variations_t::instantiate
+// (hb-ot-var-gvar-table.hh)
+func (p *Plan) instanceGvar() ([]byte, error) {
+	if p.gvar == nil || p.glyf == nil {
+		return nil, nil
+	}
+	glyphs := make([][]byte, p.numOutputGlyphs)
+	for newGID := range glyphs {
+		oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]
+		if !ok {
+			continue
+		}
+		data := p.glyf.GetGlyphBytes(oldGID)
+		var numPoints int
+		var orig []ot.GlyphPoint
+		if len(data) >= 10 {
+			if int16(binary.BigEndian.Uint16(data)) >= 0 {
+				points, _, err := ot.ParseSimpleGlyph(data)
+				if err != nil {
+					continue
+				}
+				numPoints = len(points)
+				orig = make([]ot.GlyphPoint, numPoints+4)
+				for i, pt := range points {
+					orig[i] = ot.GlyphPoint{X: pt.X, Y: pt.Y}
+				}
+			} else {
+				numPoints = len(ot.ParseCompositeGlyph(data))
+			}
+		}
+
+		var tuples []gvarTuple
+		index := make(map[string]int)
+		for _, t := range p.gvar.GlyphTuples(oldGID, numPoints+4, orig) {
+			region := make([]ot.RegionAxisCoordinates, len(t.Peak))
+			for i := range region {
+				region[i] = ot.RegionAxisCoordinates{Start: t.Start[i], Peak: t.Peak[i], End: t.End[i]}
+			}
+			for _, s := range p.partial.solveRegion(region) {
+				if s.region == nil {
+					continue
+				}
+				key := regionKey(s.region)
+				k, ok := index[key]
+				if !ok {
+					k = len(tuples)
+					index[key] = k
+					tuples = append(tuples, gvarTuple{
+						region:  s.region,
+						xDeltas: make([]float64, numPoints+4),
+						yDeltas: make([]float64, numPoints+4),
+					})
+				}
+				for i := range t.XDeltas {
+					tuples[k].xDeltas[i] += s.scalar * t.XDeltas[i]
+					tuples[k].yDeltas[i] += s.scalar * t.YDeltas[i]
+				}
+			}
+		}
+		glyphs[newGID] = encodeGlyphVariations(tuples)
+	}
+	return buildGvar(p.partial.axisCount(), 0, nil, glyphs), nil
+}
+
+// gvarTuple is a tuple variation with a delta for every point.
+type gvarTuple struct {
+	region           []ot.RegionAxisCoordinates
+	xDeltas, yDeltas []float64
+}
+
+// encodeGlyphVariations serializes GlyphVariationData with embedded peak
+// tuples, sharing the "all points" point numbers. Tuples whose rounded
+// deltas are all zero are omitted.
+func encodeGlyphVariations(tuples []gvarTuple) []byte {
+	var headers, serialized []byte
+	count := 0
+	for _, t := range tuples {
+		xd := make([]int16, len(t.xDeltas))
+		yd := make([]int16, len(t.yDeltas))
+		zero := true
+		for i := range xd {
+			xd[i] = int16(math.Round(t.xDeltas[i]))
+			yd[i] = int16(math.Round(t.yDeltas[i]))
+			if xd[i] != 0 || yd[i] != 0 {
+				zero = false
+			}
+		}
+		if zero {
+			continue
+		}
+		deltas := append(packDeltas(xd), packDeltas(yd)...)
+
+		tupleIndex := uint16(0x8000) // embedded peak tuple
+		intermediate := false
+		for _, a := range t.region {
+			if a.Start != min16(a.Peak, 0) || a.End != max16(a.Peak, 0) {
+				intermediate = true
+			}
+		}
+		if intermediate {
+			tupleIndex |= 0x4000
+		}
+		headers = appendUint16s(headers, uint16(len(deltas)), tupleIndex)
+		for _, a := range t.region {
+			headers = appendUint16s(headers, uint16(a.Peak))
+		}
+		if intermediate {
+			for _, a := range t.region {
+				headers = appendUint16s(headers, uint16(a.Start))
+			}
+			for _, a := range t.region {
+				headers = appendUint16s(headers, uint16(a.End))
+			}
+		}
+		serialized = append(serialized, deltas...)
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+
+	// Shared point numbers: a single 0 byte selects all points.
+	out := appendUint16s(nil, 0x8000|uint16(count), uint16(4+len(headers)))
+	out = append(out, headers...)
+	out = append(out, 0)
+	return append(out, serialized...)
+}
+
+// packDeltas encodes packed deltas in runs of zeros, bytes and words.
+func packDeltas(values []int16) []byte {
+	var out []byte
+	for i := 0; i < len(values); {
+		j := i
+		switch {
+		case values[i] == 0:
+			for j < len(values) && j-i < 64 && values[j] == 0 {
+				j++
+			}
+			out = append(out, 0x80|byte(j-i-1))
+		case values[i] >= math.MinInt8 && values[i] <= math.MaxInt8:
+			for j < len(values) && j-i < 64 && values[j] >= math.MinInt8 && values[j] <= math.MaxInt8 &&
+				!(values[j] == 0 && j+1 < len(values) && values[j+1] == 0) {
+				j++
+			}
+			out = append(out, byte(j-i-1))
+			for _, v := range values[i:j] {
+				out = append(out, byte(int8(v)))
+			}
+		default:
+			for j < len(values) && j-i < 64 && values[j] != 0 &&
+				(values[j] < math.MinInt8 || values[j] > math.MaxInt8) {
+				j++
+			}
+			out = append(out, 0x40|byte(j-i-1))
+			for _, v := range values[i:j] {
+				out = binary.BigEndian.AppendUint16(out, uint16(v))
+			}
+		}
+		i = j
+	}
+	return out
+}
+
+// instanceMVAR re-expresses the MVAR deltas over the restricted axes.
+// The default contributions are applied to the metrics tables by
+// applyMVARDeltas.
+func (p *Plan) instanceMVAR() ([]byte, error) {
+	mvar, err := p.parseMVAR()
+	if err != nil || mvar == nil {
+		return nil, err
+	}
+	builder := p.newVarStoreBuilder(mvar.store)
+	for _, r := range mvar.records {
+		builder.add(r.varIdx)
+	}
+	storeData := builder.build()
+
+	out := appendUint16s(nil, 1, 0, 0, 8, uint16(len(mvar.records)), 0)
+	for _, r := range mvar.records {
+		out = binary.BigEndian.AppendUint32(out, uint32(r.tag))
+		idx := builder.mapped(r.varIdx)
+		out = appendUint16s(out, uint16(idx>>16), uint16(idx))
+	}
+	binary.BigEndian.PutUint16(out[10:], uint16(len(out)))
+	return append(out, storeData...), nil
+}
+
+// mvarTable is a parsed MVAR table.
+type mvarTable struct {
+	store   *ot.ItemVariationStore
+	records []mvarRecord
+}
+
+type mvarRecord struct {
+	tag    ot.Tag
+	varIdx uint32
+}
+
+// parseMVAR parses the MVAR table of the source font; it returns nil if
+// there is none.
+func (p *Plan) parseMVAR() (*mvarTable, error) {
+	if !p.source.HasTable(ot.TagMvar) {
+		return nil, nil
+	}
+	data, err := p.source.TableData(ot.TagMvar)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || binary.BigEndian.Uint16(data) != 1 {
+		return nil, ot.ErrInvalidTable
+	}
+	recordSize := int(binary.BigEndian.Uint16(data[6:]))
+	count := int(binary.BigEndian.Uint16(data[8:]))
+	storeOffset := int(binary.BigEndian.Uint16(data[10:]))
+	if storeOffset == 0 || storeOffset >= len(data) || recordSize < 8 || 12+count*recordSize > len(data) {
+		return nil, ot.ErrInvalidOffset
+	}
+	store, err := ot.ParseItemVariationStore(data[storeOffset:])
+	if err != nil {
+		return nil, err
+	}
+	m := &mvarTable{store: store}
+	for i := 0; i < count; i++ {
+		rec := data[12+i*recordSize:]
+		m.records = append(m.records, mvarRecord{
+			tag:    ot.Tag(binary.BigEndian.Uint32(rec)),
+			varIdx: binary.BigEndian.Uint32(rec[4:]),
+		})
+	}
+	return m, nil
+}
+
+// mvarField is an int16 or uint16 metrics field varied by MVAR.
+type mvarField struct {
+	table  ot.Tag
+	offset int
+}
+
+// mvarFields maps MVAR value tags to the fields they vary. The
+// horizontal ascender, descender and line gap also apply to hhea.
+// HarfBuzz equivalent: hb_ot_metrics_tag_t and the instancing code in
+// OS2::subset, hhea::subset and post::subset
+var mvarFields = map[ot.Tag][]mvarField{
+	ot.MakeTag('h', 'a', 's', 'c'): {{ot.TagOS2, 68}, {ot.TagHhea, 4}},
+	ot.MakeTag('h', 'd', 's', 'c'): {{ot.TagOS2, 70}, {ot.TagHhea, 6}},
+	ot.MakeTag('h', 'l', 'g', 'p'): {{ot.TagOS2, 72}, {ot.TagHhea, 8}},
+	ot.MakeTag('h', 'c', 'l', 'a'): {{ot.TagOS2, 74}},
+	ot.MakeTag('h', 'c', 'l', 'd'): {{ot.TagOS2, 76}},
+	ot.MakeTag('v', 'a', 's', 'c'): {{ot.TagVhea, 4}},
+	ot.MakeTag('v', 'd', 's', 'c'): {{ot.TagVhea, 6}},
+	ot.MakeTag('v', 'l', 'g', 'p'): {{ot.TagVhea, 8}},
+	ot.MakeTag('h', 'c', 'r', 's'): {{ot.TagHhea, 18}},
+	ot.MakeTag('h', 'c', 'r', 'n'): {{ot.TagHhea, 20}},
+	ot.MakeTag('h', 'c', 'o', 'f'): {{ot.TagHhea, 22}},
+	ot.MakeTag('v', 'c', 'r', 's'): {{ot.TagVhea, 18}},
+	ot.MakeTag('v', 'c', 'r', 'n'): {{ot.TagVhea, 20}},
+	ot.MakeTag('v', 'c', 'o', 'f'): {{ot.TagVhea, 22}},
+	ot.MakeTag('x', 'h', 'g', 't'): {{ot.TagOS2, 86}},
+	ot.MakeTag('c', 'p', 'h', 't'): {{ot.TagOS2, 88}},
+	ot.MakeTag('s', 'b', 'x', 's'): {{ot.TagOS2, 10}},
+	ot.MakeTag('s', 'b', 'y', 's'): {{ot.TagOS2, 12}},
+	ot.MakeTag('s', 'b', 'x', 'o'): {{ot.TagOS2, 14}},
+	ot.MakeTag('s', 'b', 'y', 'o'): {{ot.TagOS2, 16}},
+	ot.MakeTag('s', 'p', 'x', 's'): {{ot.TagOS2, 18}},
+	ot.MakeTag('s', 'p', 'y', 's'): {{ot.TagOS2, 20}},
+	ot.MakeTag('s', 'p', 'x', 'o'): {{ot.TagOS2, 22}},
+	ot.MakeTag('s', 'p', 'y', 'o'): {{ot.TagOS2, 24}},
+	ot.MakeTag('s', 't', 'r', 's'): {{ot.TagOS2, 26}},
+	ot.MakeTag('s', 't', 'r', 'o'): {{ot.TagOS2, 28}},
+	ot.MakeTag('u', 'n', 'd', 's'): {{ot.TagPost, 10}},
+	ot.MakeTag('u', 'n', 'd', 'o'): {{ot.TagPost, 8}},
+}
+
+// applyMVARDeltas adds the MVAR deltas at the instanced location to the
+// metrics fields of the output tables.
+func (p *Plan) applyMVARDeltas(builder *FontBuilder) {
+	mvar, err := p.parseMVAR()
+	if err != nil || mvar == nil || p.normalizedCoords == nil {
+		return
+	}
+	copied := make(map[ot.Tag]bool)
+	for _, r := range mvar.records {
+		delta := int16(math.Round(mvar.store.GetDelta(r.varIdx, p.normalizedCoords)))
+		if delta == 0 {
+			continue
+		}
+		for _, f := range mvarFields[r.tag] {
+			data, ok := builder.tables[f.table]
+			if !ok || f.offset+2 > len(data) {
+				continue
+			}
+			// Tables may still alias the source font.
+			if !copied[f.table] {
+				data = append([]byte(nil), data...)
+				builder.tables[f.table] = data
+				copied[f.table] = true
+			}
+			v := int16(binary.BigEndian.Uint16(data[f.offset:])) + delta
+			binary.BigEndian.PutUint16(data[f.offset:], uint16(v))
+		}
+	}
+}
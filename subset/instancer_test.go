@@ -0,0 +1,169 @@
+package subset
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// shapeAdvances shapes text at the given weight.
+func shapeAdvances(t *testing.T, font *ot.Font, text string, weight float32) []int16 {
+	t.Helper()
+	shaper, err := ot.NewShaper(font)
+	if err != nil {
+		t.Fatalf("NewShaper: %v", err)
+	}
+	shaper.SetVariation(ot.TagAxisWeight, weight)
+	buf := ot.NewBuffer()
+	buf.AddString(text)
+	shaper.Shape(buf, nil)
+	advances := make([]int16, len(buf.Pos))
+	for i, pos := range buf.Pos {
+		advances[i] = pos.XAdvance
+	}
+	return advances
+}
+
+func TestPartialInstanceShaping(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	const text = "Hello"
+
+	for _, r := range [][3]float32{{300, 400, 700}, {300, 700, 900}, {100, 250, 400}} {
+		input := NewInput()
+		input.AddString(text)
+		input.Flags = FlagPassUnrecognized
+		input.RestrictAxisRange(ot.TagAxisWeight, r[0], r[1], r[2])
+		plan, _, subFont := subsetAndParse(t, font, input)
+		if !plan.IsPartiallyInstanced() {
+			t.Fatalf("plan is not partially instanced")
+		}
+		for _, weight := range []float32{r[0], (r[0] + r[1]) / 2, r[1], (r[1] + r[2]) / 2, r[2]} {
+			t.Run(fmt.Sprintf("%v/weight%.0f", r, weight), func(t *testing.T) {
+				got := shapeAdvances(t, subFont, text, weight)
+				want := shapeAdvances(t, font, text, weight)
+				for i := range want {
+					if d := got[i] - want[i]; d < -1 || d > 1 {
+						t.Errorf("glyph %d: advance %d, want %d", i, got[i], want[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPartialInstanceDefault(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	input := NewInput()
+	input.AddString("Hello")
+	input.Flags = FlagPassUnrecognized
+	input.RestrictAxisRange(ot.TagAxisWeight, 300, 700, 900)
+	plan, _, subFont := subsetAndParse(t, font, input)
+	if !plan.IsPartiallyInstanced() {
+		t.Fatalf("plan is not partially instanced")
+	}
+
+	// Without variation settings the font is at its new default, wght=700.
+	shaper, err := ot.NewShaper(subFont)
+	if err != nil {
+		t.Fatalf("NewShaper: %v", err)
+	}
+	buf := ot.NewBuffer()
+	buf.AddString("Hello")
+	shaper.Shape(buf, nil)
+	expected := []int16{1446, 1106, 542, 542, 1156}
+	for i, pos := range buf.Pos {
+		if pos.XAdvance != expected[i] {
+			t.Errorf("glyph %d: advance %d, want %d", i, pos.XAdvance, expected[i])
+		}
+	}
+}
+
+func TestPartialInstanceFvar(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	input := NewInput()
+	input.AddString("Hello")
+	input.Flags = FlagPassUnrecognized
+	input.RestrictAxisRange(ot.TagAxisWeight, 300, 400, 700)
+	plan, _, subFont := subsetAndParse(t, font, input)
+	if !plan.IsPartiallyInstanced() {
+		t.Fatalf("plan is not partially instanced")
+	}
+
+	fvarData, _ := subFont.TableData(ot.TagFvar)
+	fvar, err := ot.ParseFvar(fvarData)
+	if err != nil {
+		t.Fatalf("ParseFvar: %v", err)
+	}
+	axis, ok := fvar.FindAxis(ot.TagAxisWeight)
+	if !ok {
+		t.Fatalf("weight axis missing")
+	}
+	if axis.MinValue != 300 || axis.DefaultValue != 400 || axis.MaxValue != 700 {
+		t.Errorf("weight axis %v/%v/%v, want 300/400/700", axis.MinValue, axis.DefaultValue, axis.MaxValue)
+	}
+	instances := fvar.NamedInstances()
+	if len(instances) == 0 {
+		t.Fatalf("all named instances dropped")
+	}
+	srcFvarData, _ := font.TableData(ot.TagFvar)
+	srcFvar, _ := ot.ParseFvar(srcFvarData)
+	if len(instances) >= len(srcFvar.NamedInstances()) {
+		t.Errorf("named instances outside the range kept")
+	}
+	for _, inst := range instances {
+		if w := inst.Coords[axis.Index]; w < 300 || w > 700 {
+			t.Errorf("named instance at wght=%v", w)
+		}
+	}
+}
+
+func TestPartialInstanceOutlines(t *testing.T) {
+	// Pinning the partial instance must give the same outlines as pinning
+	// the original font.
+	font := loadFont(t, "Roboto-Variable.ttf")
+	const text = "Hoé"
+	input := NewInput()
+	input.AddString(text)
+	input.Flags = FlagPassUnrecognized
+	input.RestrictAxisRange(ot.TagAxisWeight, 300, 400, 700)
+	plan, _, partial := subsetAndParse(t, font, input)
+	if !plan.IsPartiallyInstanced() {
+		t.Fatalf("plan is not partially instanced")
+	}
+
+	bboxes := func(f *ot.Font, weight float32) []ot.GlyphBBox {
+		input := NewInput()
+		input.AddString(text)
+		input.PinAxisLocation(ot.TagAxisWeight, weight)
+		plan, _, subFont := subsetAndParse(t, f, input)
+		glyf, err := ot.ParseGlyfFromFont(subFont)
+		if err != nil {
+			t.Fatalf("ParseGlyfFromFont: %v", err)
+		}
+		var out []ot.GlyphBBox
+		for gid := 0; gid < len(plan.GlyphSet()); gid++ {
+			data := glyf.GetGlyphBytes(ot.GlyphID(gid))
+			if len(data) >= 10 {
+				out = append(out, glyphHeaderBBox(data))
+			}
+		}
+		return out
+	}
+
+	for _, weight := range []float32{300, 550, 700} {
+		got, want := bboxes(partial, weight), bboxes(font, weight)
+		if len(got) != len(want) {
+			t.Fatalf("weight %v: %d glyphs, want %d", weight, len(got), len(want))
+		}
+		for i := range want {
+			g, w := got[i], want[i]
+			for _, d := range []int16{g.XMin - w.XMin, g.YMin - w.YMin, g.XMax - w.XMax, g.YMax - w.YMax} {
+				if d < -1 || d > 1 {
+					t.Errorf("weight %v glyph %d: bbox %v, want %v", weight, i, g, w)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package subset
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// subsetKern subsets the legacy kern table in its Microsoft (version 0)
+// and Apple (version 1) forms. Pair lists (format 0) keep the pairs of
+// retained glyphs; class tables (format 2) are rebuilt for the new glyph
+// IDs and keep their kerning array. Subtables of other formats and
+// subtables left without pairs are dropped. Returns nil if no subtable
+// remains.
+// HarfBuzz equivalent: kern::subset is not implemented; this follows
+// fontTools' subset of the kern table.
+func (p *Plan) subsetKern() ([]byte, error) {
+	data, err := p.source.TableData(ot.TagKernTable)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, ot.ErrInvalidTable
+	}
+
+	// Header and subtable header sizes of the two forms
+	apple := binary.BigEndian.Uint16(data) == 1
+	headerSize, subHeaderSize := 4, 6
+	numTables := int(binary.BigEndian.Uint16(data[2:]))
+	if apple {
+		if len(data) < 8 {
+			return nil, ot.ErrInvalidTable
+		}
+		headerSize, subHeaderSize = 8, 8
+		numTables = int(binary.BigEndian.Uint32(data[4:]))
+	}
+
+	var subtables [][]byte
+	off := headerSize
+	for range numTables {
+		if off+subHeaderSize > len(data) {
+			return nil, ot.ErrInvalidTable
+		}
+		var length int
+		var format uint16
+		if apple {
+			length = int(binary.BigEndian.Uint32(data[off:]))
+			format = binary.BigEndian.Uint16(data[off+4:]) & 0xFF
+		} else {
+			length = int(binary.BigEndian.Uint16(data[off+2:]))
+			format = binary.BigEndian.Uint16(data[off+4:]) >> 8
+		}
+		if length < subHeaderSize || off+length > len(data) {
+			return nil, ot.ErrInvalidTable
+		}
+		sub := data[off : off+length]
+		off += length
+
+		var body []byte
+		switch format {
+		case 0:
+			body = p.subsetKernPairs(sub, subHeaderSize)
+		case 2:
+			body = p.subsetKernClasses(sub, subHeaderSize)
+		}
+		if body == nil {
+			continue
+		}
+		out := append(append([]byte(nil), sub[:subHeaderSize]...), body...)
+		if apple {
+			binary.BigEndian.PutUint32(out, uint32(len(out)))
+		} else {
+			// Large pair lists overflow the 16-bit length; readers
+			// derive it from nPairs.
+			binary.BigEndian.PutUint16(out[2:], uint16(len(out)))
+		}
+		subtables = append(subtables, out)
+	}
+	if len(subtables) == 0 {
+		return nil, nil
+	}
+
+	out := append([]byte(nil), data[:headerSize]...)
+	if apple {
+		binary.BigEndian.PutUint32(out[4:], uint32(len(subtables)))
+	} else {
+		binary.BigEndian.PutUint16(out[2:], uint16(len(subtables)))
+	}
+	for _, sub := range subtables {
+		out = append(out, sub...)
+	}
+	return out, nil
+}
+
+// subsetKernPairs subsets the body of a format 0 subtable: the pairs of
+// retained glyphs, sorted by the new glyph IDs. Returns nil if no pair
+// remains.
+func (p *Plan) subsetKernPairs(sub []byte, headerSize int) []byte {
+	if headerSize+8 > len(sub) {
+		return nil
+	}
+	nPairs := int(binary.BigEndian.Uint16(sub[headerSize:]))
+	if headerSize+8+nPairs*6 > len(sub) {
+		return nil
+	}
+	type kernPair struct {
+		left, right ot.GlyphID
+		value       uint16
+	}
+	var pairs []kernPair
+	for i := range nPairs {
+		rec := sub[headerSize+8+i*6:]
+		left, ok1 := p.glyphMap[binary.BigEndian.Uint16(rec)]
+		right, ok2 := p.glyphMap[binary.BigEndian.Uint16(rec[2:])]
+		if ok1 && ok2 {
+			pairs = append(pairs, kernPair{left, right, binary.BigEndian.Uint16(rec[4:])})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].left != pairs[j].left {
+			return pairs[i].left < pairs[j].left
+		}
+		return pairs[i].right < pairs[j].right
+	})
+
+	// Binary search parameters in units of 6-byte pairs
+	entrySelector := bits.Len(uint(len(pairs))) - 1
+	searchRange := 6 << entrySelector
+	out := appendUint16s(nil, uint16(len(pairs)), uint16(searchRange), uint16(entrySelector),
+		uint16(len(pairs)*6-searchRange))
+	for _, pr := range pairs {
+		out = appendUint16s(out, pr.left, pr.right, pr.value)
+	}
+	return out
+}
+
+// subsetKernClasses subsets the body of a format 2 subtable. The class
+// values are byte offsets into the kerning array: left classes from the
+// subtable start, right classes from the row start. The array is kept
+// as is and only the class tables are rebuilt over the new glyph IDs.
+// Returns nil if no retained glyph has a class.
+func (p *Plan) subsetKernClasses(sub []byte, headerSize int) []byte {
+	if headerSize+8 > len(sub) {
+		return nil
+	}
+	rowWidth := binary.BigEndian.Uint16(sub[headerSize:])
+	leftOff := int(binary.BigEndian.Uint16(sub[headerSize+2:]))
+	rightOff := int(binary.BigEndian.Uint16(sub[headerSize+4:]))
+	arrayOff := int(binary.BigEndian.Uint16(sub[headerSize+6:]))
+	if arrayOff > len(sub) {
+		return nil
+	}
+	left := p.kernClassValues(sub, leftOff)
+	right := p.kernClassValues(sub, rightOff)
+	if left == nil || right == nil {
+		return nil
+	}
+
+	// Left class values move with the kerning array. Glyphs without a
+	// class index the first row.
+	leftTable := buildKernClassTable(left)
+	rightTable := buildKernClassTable(right)
+	newArrayOff := headerSize + 8 + len(leftTable) + len(rightTable)
+	for i := 4; i < len(leftTable); i += 2 {
+		v := int(binary.BigEndian.Uint16(leftTable[i:]))
+		v = max(v-arrayOff, 0) + newArrayOff
+		binary.BigEndian.PutUint16(leftTable[i:], uint16(v))
+	}
+	out := appendUint16s(nil, rowWidth, uint16(headerSize+8), uint16(headerSize+8+len(leftTable)),
+		uint16(newArrayOff))
+	out = append(append(out, leftTable...), rightTable...)
+	return append(out, sub[arrayOff:]...)
+}
+
+// kernClassValues returns the class values of the format 2 class table
+// at off keyed by new glyph ID, or nil if no retained glyph is covered.
+func (p *Plan) kernClassValues(sub []byte, off int) map[ot.GlyphID]uint16 {
+	if off+4 > len(sub) {
+		return nil
+	}
+	first := int(binary.BigEndian.Uint16(sub[off:]))
+	count := int(binary.BigEndian.Uint16(sub[off+2:]))
+	if off+4+count*2 > len(sub) {
+		return nil
+	}
+	values := make(map[ot.GlyphID]uint16)
+	for i := range count {
+		if newGID, ok := p.glyphMap[ot.GlyphID(first+i)]; ok {
+			values[newGID] = binary.BigEndian.Uint16(sub[off+4+i*2:])
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// buildKernClassTable builds a format 2 class table: firstGlyph,
+// nGlyphs and one value per glyph. Glyphs in the range without a value
+// get 0.
+func buildKernClassTable(values map[ot.GlyphID]uint16) []byte {
+	first, last := ot.GlyphID(0xFFFF), ot.GlyphID(0)
+	for gid := range values {
+		if gid < first {
+			first = gid
+		}
+		if gid > last {
+			last = gid
+		}
+	}
+	out := appendUint16s(nil, first, last-first+1)
+	for gid := first; gid <= last; gid++ {
+		out = binary.BigEndian.AppendUint16(out, values[gid])
+	}
+	return out
+}
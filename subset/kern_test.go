@@ -0,0 +1,99 @@
+package subset
+
+import (
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestSubsetKern(t *testing.T) {
+	tests := []struct {
+		font   string
+		glyphs []ot.GlyphID
+	}{
+		// Microsoft kern tables with a format 0 subtable
+		{"8a312e38b9b90183ef154a0c2ab92a9def6cb82f.ttf", []ot.GlyphID{1, 3}},
+		{"b121d4306b2e3add5abbaad21d95fcf04aacbd64.ttf", []ot.GlyphID{1, 2, 3}},
+		// Apple kern table with a format 0 and two format 2 subtables
+		{"e39391c77a6321c2ac7a2d644de0396470cd4bfe.ttf", []ot.GlyphID{5, 36, 40, 50, 55, 56, 68, 80, 90, 120}},
+	}
+	for _, tt := range tests {
+		font := loadFont(t, tt.font)
+		srcData := mustTableData(t, font, ot.TagKernTable)
+		src, err := ot.ParseKern(srcData, font.NumGlyphs())
+		if err != nil {
+			t.Fatalf("%s: ParseKern: %v", tt.font, err)
+		}
+		for _, flags := range []Flags{FlagNoLayoutClosure, FlagNoLayoutClosure | FlagRetainGIDs} {
+			input := NewInput()
+			input.AddGlyphs(tt.glyphs...)
+			input.Flags = flags
+			plan, err := CreatePlan(font, input)
+			if err != nil {
+				t.Fatalf("CreatePlan: %v", err)
+			}
+			result, err := plan.Execute()
+			if err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			subFont, err := ot.ParseFont(result, 0)
+			if err != nil {
+				t.Fatalf("Failed to parse subset: %v", err)
+			}
+			data := mustTableData(t, subFont, ot.TagKernTable)
+			if data[1] != srcData[1] {
+				t.Errorf("%s flags %#x: kern version %d, want %d", tt.font, flags, data[1], srcData[1])
+			}
+			k, err := ot.ParseKern(data, subFont.NumGlyphs())
+			if err != nil {
+				t.Fatalf("%s flags %#x: ParseKern: %v", tt.font, flags, err)
+			}
+			pairs := 0
+			for left := range plan.GlyphSet() {
+				for right := range plan.GlyphSet() {
+					newLeft, _ := plan.MapGlyph(left)
+					newRight, _ := plan.MapGlyph(right)
+					want := src.KernPair(left, right)
+					if got := k.KernPair(newLeft, newRight); got != want {
+						t.Errorf("%s flags %#x: kern %d %d is %d, want %d", tt.font, flags, left, right, got, want)
+					}
+					if want != 0 {
+						pairs++
+					}
+				}
+			}
+			if pairs == 0 {
+				t.Errorf("%s flags %#x: no kerning pairs retained", tt.font, flags)
+			}
+		}
+	}
+}
+
+func TestSubsetKernDropped(t *testing.T) {
+	// Only .notdef is retained, which has no pairs
+	font := loadFont(t, "8a312e38b9b90183ef154a0c2ab92a9def6cb82f.ttf")
+	src, err := ot.ParseKern(mustTableData(t, font, ot.TagKernTable), font.NumGlyphs())
+	if err != nil {
+		t.Fatalf("ParseKern: %v", err)
+	}
+	if src.KernPair(0, 0) != 0 {
+		t.Fatal("test font kerns .notdef")
+	}
+	input := NewInput()
+	input.Flags = FlagNoLayoutClosure
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	result, err := plan.Execute()
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	subFont, err := ot.ParseFont(result, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse subset: %v", err)
+	}
+	if subFont.HasTable(ot.TagKernTable) {
+		t.Error("kern table kept without pairs")
+	}
+}
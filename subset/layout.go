@@ -0,0 +1,594 @@
+package subset
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// Script, language system and feature pruning shared by GSUB and GPOS.
+//
+// HarfBuzz equivalent: _collect_layout_indices and _remap_indexes
+// (hb-subset-plan.cc), hb_prune_langsys_context_t and
+// ScriptList/FeatureList::subset (hb-ot-layout-common.hh).
+
+// defaultLayoutFeatures are the features retained when Input.KeepFeature
+// was never called.
+// HarfBuzz equivalent: default_layout_features (hb-subset-input.cc)
+var defaultLayoutFeatures = tagSet(
+	// common
+	"rvrn", "ccmp", "liga", "locl", "mark", "mkmk", "rlig",
+	// fractions
+	"frac", "numr", "dnom",
+	// horizontal
+	"calt", "clig", "curs", "kern", "rclt",
+	// vertical
+	"valt", "vert", "vkrn", "vpal", "vrt2",
+	// ltr / rtl
+	"ltra", "ltrm", "rtla", "rtlm",
+	// random, justify
+	"rand", "jalt",
+	// East Asian spacing
+	"chws", "vchw", "halt", "vhal",
+	// private
+	"Harf", "HARF", "Buzz", "BUZZ",
+	// arabic
+	"init", "medi", "fina", "isol", "med2", "fin2", "fin3", "cswh", "mset", "stch",
+	// hangul
+	"ljmo", "vjmo", "tjmo",
+	// tibetan
+	"abvs", "blws",
+	// indic
+	"nukt", "akhn", "rphf", "rkrf", "pref", "blwf", "half", "abvf", "pstf",
+	"cfar", "vatu", "cjct", "pres", "psts", "haln", "dist", "abvm", "blwm",
+)
+
+func tagSet(tags ...string) map[ot.Tag]bool {
+	set := make(map[ot.Tag]bool, len(tags))
+	for _, t := range tags {
+		set[ot.MakeTag(t[0], t[1], t[2], t[3])] = true
+	}
+	return set
+}
+
+// layoutPlan records the scripts, language systems, features and lookups
+// of a GSUB or GPOS table that survive the script and feature filters.
+type layoutPlan struct {
+	featureList *ot.FeatureList
+
+	// substitutes replace the lookups of features, from the
+	// FeatureVariations record matching the instanced location.
+	substitutes map[uint16][]uint16
+
+	// variations are the FeatureVariations records that can still match
+	// in a variable subset, with conditions over the output axes.
+	variations []featureVariation
+
+	// scripts are the retained scripts. Their language systems only
+	// reference retained features; those equal to the script's default
+	// language system are dropped.
+	scripts []ot.ScriptRecord
+
+	// features are the retained feature indices, ascending.
+	features []uint16
+
+	// lookups are the lookups referenced by retained features,
+	// ascending. Lookups reached through contextual rules are added
+	// during subsetting.
+	lookups []uint16
+}
+
+// newLayoutPlan applies the input's script and feature filters. For a
+// static instance, coords is its location and the features are
+// substituted as selected by featureVariations. Otherwise the records of
+// featureVariations are kept, restricted to the axis ranges of pi if
+// partially instancing.
+// HarfBuzz equivalent: _collect_layout_indices with
+// hb_subset_plan_t::user_axes_location (hb-subset-plan.cc)
+func newLayoutPlan(input *Input, scriptList *ot.ScriptList, featureList *ot.FeatureList,
+	featureVariations *ot.FeatureVariations, coords []int, pi *partialInstance) *layoutPlan {
+	lp := &layoutPlan{featureList: featureList}
+	used := make(map[uint16]bool)
+
+	if coords != nil {
+		if vi := featureVariations.FindIndex(coords); vi != ot.VariationsNotFoundIndex {
+			lp.substitutes = make(map[uint16][]uint16)
+			for i := 0; i < featureList.Count(); i++ {
+				if lookups := featureVariations.GetSubstituteLookups(vi, uint16(i)); lookups != nil {
+					lp.substitutes[uint16(i)] = lookups
+				}
+			}
+		}
+	} else {
+		lp.planVariations(featureVariations, pi)
+	}
+
+	keep := func(idx int) bool {
+		f, err := featureList.GetFeature(idx)
+		return err == nil && input.ShouldKeepFeature(f.Tag)
+	}
+	filter := func(ls *ot.LangSys) *ot.LangSys {
+		if ls == nil {
+			return nil
+		}
+		out := &ot.LangSys{RequiredFeature: -1}
+		if ls.RequiredFeature >= 0 && keep(ls.RequiredFeature) {
+			out.RequiredFeature = ls.RequiredFeature
+			used[uint16(ls.RequiredFeature)] = true
+		}
+		for _, idx := range ls.FeatureIndices {
+			if keep(int(idx)) {
+				out.FeatureIndices = append(out.FeatureIndices, idx)
+				used[idx] = true
+			}
+		}
+		return out
+	}
+
+	for _, script := range scriptList.Scripts() {
+		if !input.ShouldKeepScript(script.Tag) {
+			continue
+		}
+		rec := ot.ScriptRecord{Tag: script.Tag, DefaultLangSys: filter(script.DefaultLangSys)}
+		for _, ls := range script.LangSys {
+			filtered := filter(ls.LangSys)
+			if rec.DefaultLangSys != nil && sameLangSys(filtered, rec.DefaultLangSys) {
+				continue
+			}
+			rec.LangSys = append(rec.LangSys, ot.LangSysRecord{Tag: ls.Tag, LangSys: filtered})
+		}
+		lp.scripts = append(lp.scripts, rec)
+	}
+
+	lookups := make(map[uint16]bool)
+	for idx := range used {
+		lp.features = append(lp.features, idx)
+		if f, err := featureList.GetFeature(int(idx)); err == nil {
+			for _, l := range lp.featureLookups(idx, f) {
+				lookups[l] = true
+			}
+		}
+		for _, v := range lp.variations {
+			for _, l := range v.substitutes[idx] {
+				lookups[l] = true
+			}
+		}
+	}
+	sort.Slice(lp.features, func(i, j int) bool { return lp.features[i] < lp.features[j] })
+	lp.lookups = sortedLookupIndices(lookups)
+	return lp
+}
+
+// featureLookups returns the lookups of a feature, substituted for the
+// instanced location.
+func (lp *layoutPlan) featureLookups(idx uint16, f *ot.FeatureRecord) []uint16 {
+	if lookups, ok := lp.substitutes[idx]; ok {
+		return lookups
+	}
+	return f.Lookups
+}
+
+// featureVariation is a FeatureVariationRecord of the subset.
+type featureVariation struct {
+	conditions []axisCondition
+	// substitutes maps source feature indices to source lookups.
+	substitutes map[uint16][]uint16
+}
+
+// axisCondition is a ConditionAxisRange: it holds if the coordinate of
+// axis lies within [min, max] (F2DOT14).
+type axisCondition struct {
+	axis     uint16
+	min, max int16
+}
+
+// planVariations keeps the FeatureVariations records that can match
+// within the axis ranges of pi, or all records if pi is nil. Conditions
+// on restricted axes are clipped and renormalized; conditions that hold
+// everywhere are dropped. The first record left without conditions
+// matches everywhere: its substitutions become the default lookups and
+// the records after it are unreachable.
+// HarfBuzz equivalent: FeatureVariations::subset and
+// ConditionAxisRange::keep_with_variations (hb-ot-layout-common.hh)
+func (lp *layoutPlan) planVariations(fv *ot.FeatureVariations, pi *partialInstance) {
+	for i := 0; i < fv.RecordCount(); i++ {
+		conditions, ok := keepConditions(fv.ConditionSet(i), pi)
+		if !ok {
+			continue
+		}
+		substitutes := make(map[uint16][]uint16)
+		for _, f := range fv.SubstitutedFeatures(i) {
+			substitutes[f] = fv.GetSubstituteLookups(uint32(i), f)
+		}
+		if len(conditions) > 0 {
+			lp.variations = append(lp.variations, featureVariation{conditions, substitutes})
+			continue
+		}
+		// The records before keep the source lookups of the features
+		// whose defaults change.
+		for _, v := range lp.variations {
+			for f := range substitutes {
+				if _, ok := v.substitutes[f]; ok {
+					continue
+				}
+				if feature, err := lp.featureList.GetFeature(int(f)); err == nil {
+					v.substitutes[f] = feature.Lookups
+				}
+			}
+		}
+		lp.substitutes = substitutes
+		return
+	}
+}
+
+// keepConditions returns the conditions of cs over the output axes. ok
+// is false if the set cannot match within the axis ranges of pi. Like
+// ot.FeatureVariations.FindIndex, a set without conditions or with a
+// condition of unknown format never matches.
+func keepConditions(cs *ot.ConditionSet, pi *partialInstance) (conditions []axisCondition, ok bool) {
+	if len(cs.Conditions()) == 0 {
+		return nil, false
+	}
+	for _, c := range cs.Conditions() {
+		axis, min, max, ok := c.AxisRange()
+		if !ok || min > max {
+			return nil, false
+		}
+		if pi == nil {
+			conditions = append(conditions, axisCondition{axis, min, max})
+			continue
+		}
+		if int(axis) >= len(pi.axes) {
+			return nil, false
+		}
+		limit := pi.limits[axis]
+		if limit == nil {
+			conditions = append(conditions, axisCondition{pi.outputAxis(int(axis)), min, max})
+			continue
+		}
+		lo := math.Max(float64(min)/16384, limit.min)
+		hi := math.Min(float64(max)/16384, limit.max)
+		if lo > hi {
+			return nil, false
+		}
+		if lo == limit.min && hi == limit.max {
+			// Holds on the whole range, or at the pinned location
+			continue
+		}
+		conditions = append(conditions, axisCondition{
+			axis: pi.outputAxis(int(axis)),
+			min:  toF2DOT14(limit.renormalize(lo)),
+			max:  toF2DOT14(limit.renormalize(hi)),
+		})
+	}
+	return conditions, true
+}
+
+// sameLangSys reports whether two language systems select the same
+// features.
+// HarfBuzz equivalent: LangSys::compare (hb-ot-layout-common.hh)
+func sameLangSys(a, b *ot.LangSys) bool {
+	if a.RequiredFeature != b.RequiredFeature || len(a.FeatureIndices) != len(b.FeatureIndices) {
+		return false
+	}
+	for i := range a.FeatureIndices {
+		if a.FeatureIndices[i] != b.FeatureIndices[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// subsetLists returns the feature and script records and the
+// FeatureVariations table of the subset, given the old to new lookup
+// index map. Features that lose all lookups are dropped unless they
+// carry parameters, gain lookups through a FeatureVariations record or
+// are 'pref', whose presence alone changes shaping. keepEmpty retains
+// language systems and scripts left without features; HarfBuzz does so
+// for GSUB, where they still influence script and language selection.
+func (lp *layoutPlan) subsetLists(lookupMap map[uint16]uint16, keepEmpty bool) ([]featureRecord, []scriptRecord, []byte) {
+	tagPref := ot.MakeTag('p', 'r', 'e', 'f')
+	varied := func(idx uint16) bool {
+		for _, v := range lp.variations {
+			if len(mapFeatureLookups(v.substitutes[idx], lookupMap)) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	var features []featureRecord
+	featureMap := make(map[uint16]uint16)
+	for _, idx := range lp.features {
+		f, err := lp.featureList.GetFeature(int(idx))
+		if err != nil {
+			continue
+		}
+		lookups := mapFeatureLookups(lp.featureLookups(idx, f), lookupMap)
+		if len(lookups) == 0 && f.Params == nil && f.Tag != tagPref && !varied(idx) {
+			continue
+		}
+		featureMap[idx] = uint16(len(features))
+		features = append(features, featureRecord{lookups: lookups, tag: f.Tag, params: f.Params})
+	}
+
+	mapLangSys := func(ls *ot.LangSys, tag ot.Tag) (langSysRecord, bool) {
+		rec := langSysRecord{tag: tag, reqFeat: 0xFFFF}
+		if ls.RequiredFeature >= 0 {
+			if n, ok := featureMap[uint16(ls.RequiredFeature)]; ok {
+				rec.reqFeat = n
+			}
+		}
+		for _, idx := range ls.FeatureIndices {
+			if n, ok := featureMap[idx]; ok {
+				rec.features = append(rec.features, n)
+			}
+		}
+		return rec, len(rec.features) > 0 || rec.reqFeat != 0xFFFF || keepEmpty
+	}
+
+	var scripts []scriptRecord
+	for _, s := range lp.scripts {
+		rec := scriptRecord{tag: s.Tag}
+		if s.DefaultLangSys != nil {
+			if ls, ok := mapLangSys(s.DefaultLangSys, 0); ok {
+				rec.dfltLang = &ls
+			}
+		}
+		for _, l := range s.LangSys {
+			if ls, ok := mapLangSys(l.LangSys, l.Tag); ok {
+				rec.langSys = append(rec.langSys, ls)
+			}
+		}
+		if rec.dfltLang != nil || len(rec.langSys) > 0 || keepEmpty {
+			scripts = append(scripts, rec)
+		}
+	}
+	return features, scripts, lp.buildVariations(featureMap, lookupMap)
+}
+
+// buildVariations serializes the FeatureVariations table with remapped
+// feature and lookup indices. Records left without substitutions are
+// kept, since they still prevent later records from matching, except
+// at the end. Returns nil if no record remains.
+func (lp *layoutPlan) buildVariations(featureMap, lookupMap map[uint16]uint16) []byte {
+	type record struct {
+		conditions []axisCondition
+		features   []uint16 // new feature indices, ascending
+		lookups    [][]uint16
+	}
+	var records []record
+	for _, v := range lp.variations {
+		rec := record{conditions: v.conditions}
+		for old, lookups := range v.substitutes {
+			if n, ok := featureMap[old]; ok {
+				rec.features = append(rec.features, n)
+				rec.lookups = append(rec.lookups, mapFeatureLookups(lookups, lookupMap))
+			}
+		}
+		sort.Sort(byFeature{rec.features, rec.lookups})
+		records = append(records, rec)
+	}
+	for len(records) > 0 && len(records[len(records)-1].features) == 0 {
+		records = records[:len(records)-1]
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	// FeatureVariations: version(4) + recordCount(4) +
+	// FeatureVariationRecords[](8*n), all offsets 32-bit from its start
+	data := appendUint16s(nil, 1, 0)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(records)))
+	data = append(data, make([]byte, 8*len(records))...)
+	for i, rec := range records {
+		// ConditionSet: conditionCount(2) + conditionOffsets[](4*n)
+		start := len(data)
+		binary.BigEndian.PutUint32(data[8+8*i:], uint32(start))
+		data = appendUint16s(data, uint16(len(rec.conditions)))
+		data = append(data, make([]byte, 4*len(rec.conditions))...)
+		for j, c := range rec.conditions {
+			binary.BigEndian.PutUint32(data[start+2+4*j:], uint32(len(data)-start))
+			// ConditionAxisRange: format(2) + axisIndex(2) + min(2) + max(2)
+			data = appendUint16s(data, 1, c.axis, uint16(c.min), uint16(c.max))
+		}
+
+		// FeatureTableSubstitution: version(4) + substitutionCount(2) +
+		// records[](6*n), each a featureIndex and a Feature offset
+		start = len(data)
+		binary.BigEndian.PutUint32(data[8+8*i+4:], uint32(start))
+		data = appendUint16s(data, 1, 0, uint16(len(rec.features)))
+		data = append(data, make([]byte, 6*len(rec.features))...)
+		for j, f := range rec.features {
+			binary.BigEndian.PutUint16(data[start+6+6*j:], f)
+			binary.BigEndian.PutUint32(data[start+6+6*j+2:], uint32(len(data)-start))
+			data = appendUint16s(data, 0, uint16(len(rec.lookups[j])))
+			data = appendUint16s(data, rec.lookups[j]...)
+		}
+	}
+	return data
+}
+
+// byFeature sorts feature substitutions by feature index.
+type byFeature struct {
+	features []uint16
+	lookups  [][]uint16
+}
+
+func (s byFeature) Len() int           { return len(s.features) }
+func (s byFeature) Less(i, j int) bool { return s.features[i] < s.features[j] }
+func (s byFeature) Swap(i, j int) {
+	s.features[i], s.features[j] = s.features[j], s.features[i]
+	s.lookups[i], s.lookups[j] = s.lookups[j], s.lookups[i]
+}
+
+// buildLayoutTable serializes a GSUB or GPOS table, version 1.1 if it
+// has a FeatureVariations table. The lookup list goes last since it is
+// by far the largest part, followed only by the FeatureVariations table
+// behind its 32-bit offset.
+func buildLayoutTable(scripts []scriptRecord, features []featureRecord, lookups []*lookupBuilder,
+	variations []byte, extType uint16) ([]byte, error) {
+	scriptList, err := buildScriptList(scripts)
+	if err != nil {
+		return nil, err
+	}
+	featureList, err := buildFeatureList(features)
+	if err != nil {
+		return nil, err
+	}
+	lookupList, err := buildLookupList(lookups, extType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: version(4) + scriptListOff(2) + featureListOff(2) +
+	// lookupListOff(2) [+ featureVariationsOff(4)]
+	headerSize, minor := 10, uint16(0)
+	if variations != nil {
+		headerSize, minor = 14, 1
+	}
+	featureListOff := headerSize + len(scriptList)
+	lookupListOff := featureListOff + len(featureList)
+	if lookupListOff > 0xFFFF {
+		return nil, ErrOffsetOverflow
+	}
+	data := appendUint16s(nil, 1, minor, uint16(headerSize), uint16(featureListOff), uint16(lookupListOff))
+	if variations != nil {
+		data = binary.BigEndian.AppendUint32(data, uint32(lookupListOff+len(lookupList)))
+	}
+	data = append(data, scriptList...)
+	data = append(data, featureList...)
+	data = append(data, lookupList...)
+	return append(data, variations...), nil
+}
+
+// buildLookupList serializes a LookupList. If a lookup or subtable
+// offset does not fit 16 bits, every lookup is promoted to an Extension
+// lookup of type extType (7 in GSUB, 9 in GPOS), whose subtables reach
+// the real ones through 32-bit offsets.
+// HarfBuzz equivalent: the repacker's _promote_extensions_if_needed
+// (graph/gsubgpos-context.cc), which promotes only as many lookups as
+// needed.
+func buildLookupList(lookups []*lookupBuilder, extType uint16) ([]byte, error) {
+	if data, ok := packLookupList(lookups, 0); ok {
+		return data, nil
+	}
+	if data, ok := packLookupList(lookups, extType); ok {
+		return data, nil
+	}
+	return nil, ErrOffsetOverflow
+}
+
+// packLookupList serializes lookups, as Extension lookups of type
+// extType unless it is 0. In that case every subtable is written as an
+// ExtensionFormat1 record and the wrapped subtables follow all lookups.
+// ok is false if a 16-bit offset overflows.
+func packLookupList(lookups []*lookupBuilder, extType uint16) (data []byte, ok bool) {
+	// LookupList: lookupCount(2) + lookupOffsets[](2*n) + Lookup tables
+	data = appendUint16s(nil, uint16(len(lookups)))
+	data = append(data, make([]byte, 2*len(lookups))...)
+	type extension struct {
+		at       int
+		subtable []byte
+	}
+	var extensions []extension
+	for i, l := range lookups {
+		if len(data) > 0xFFFF {
+			return nil, false
+		}
+		binary.BigEndian.PutUint16(data[2+2*i:], uint16(len(data)))
+
+		// Lookup: lookupType(2) + lookupFlag(2) + subTableCount(2) +
+		// subTableOffsets[](2*n) [+ markFilteringSet(2)]
+		start := len(data)
+		lookupType := l.lookupType
+		if extType != 0 {
+			lookupType = extType
+		}
+		data = appendUint16s(data, lookupType, l.flag, uint16(len(l.subtables)))
+		offsetsAt := len(data)
+		data = append(data, make([]byte, 2*len(l.subtables))...)
+		if l.flag&ot.LookupFlagUseMarkFilteringSet != 0 {
+			data = appendUint16s(data, l.markFilter)
+		}
+		for j, st := range l.subtables {
+			if len(data)-start > 0xFFFF {
+				return nil, false
+			}
+			binary.BigEndian.PutUint16(data[offsetsAt+2*j:], uint16(len(data)-start))
+			if extType == 0 {
+				data = append(data, st...)
+				continue
+			}
+			// ExtensionFormat1: format(2) + extensionLookupType(2) + extensionOffset(4)
+			extensions = append(extensions, extension{at: len(data), subtable: st})
+			data = appendUint16s(data, 1, l.lookupType, 0, 0)
+		}
+	}
+	for _, e := range extensions {
+		binary.BigEndian.PutUint32(data[e.at+4:], uint32(len(data)-e.at))
+		data = append(data, e.subtable...)
+	}
+	return data, true
+}
+
+// buildScriptList serializes a ScriptList.
+func buildScriptList(scripts []scriptRecord) ([]byte, error) {
+	header := appendUint16s(nil, uint16(len(scripts)))
+	var offsets []int
+	var children [][]byte
+	for _, s := range scripts {
+		header = binary.BigEndian.AppendUint32(header, uint32(s.tag))
+		offsets = append(offsets, len(header))
+		header = appendUint16s(header, 0)
+		children = append(children, buildScript(s))
+	}
+	return packTableChecked(header, offsets, children)
+}
+
+// buildScript serializes a Script table with its language systems.
+func buildScript(s scriptRecord) []byte {
+	header := appendUint16s(nil, 0, uint16(len(s.langSys)))
+	var offsets []int
+	var children [][]byte
+	if s.dfltLang != nil {
+		offsets = append(offsets, 0)
+		children = append(children, buildLangSys(*s.dfltLang))
+	}
+	for _, ls := range s.langSys {
+		header = binary.BigEndian.AppendUint32(header, uint32(ls.tag))
+		offsets = append(offsets, len(header))
+		header = appendUint16s(header, 0)
+		children = append(children, buildLangSys(ls))
+	}
+	return packTable(header, offsets, children)
+}
+
+// buildLangSys serializes a LangSys table.
+func buildLangSys(ls langSysRecord) []byte {
+	data := appendUint16s(nil, 0, ls.reqFeat, uint16(len(ls.features)))
+	return appendUint16s(data, ls.features...)
+}
+
+// buildFeatureList serializes a FeatureList.
+func buildFeatureList(features []featureRecord) ([]byte, error) {
+	header := appendUint16s(nil, uint16(len(features)))
+	var offsets []int
+	var children [][]byte
+	for _, f := range features {
+		header = binary.BigEndian.AppendUint32(header, uint32(f.tag))
+		offsets = append(offsets, len(header))
+		header = appendUint16s(header, 0)
+
+		feature := appendUint16s(nil, 0, uint16(len(f.lookups)))
+		feature = appendUint16s(feature, f.lookups...)
+		if f.params != nil {
+			binary.BigEndian.PutUint16(feature, uint16(len(feature)))
+			feature = append(feature, f.params...)
+		}
+		children = append(children, feature)
+	}
+	return packTableChecked(header, offsets, children)
+}
@@ -0,0 +1,517 @@
+package subset
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// Contextual lookup subsetting shared by GSUB (types 5, 6) and GPOS
+// (types 7, 8).
+//
+// HarfBuzz equivalent: ContextFormat1/2/3::subset and
+// ChainContextFormat1/2/3::subset (hb-ot-layout-gsubgpos.hh).
+
+// sequenceContextLookups returns the nested lookup indices of all rules
+// in sc that can still match when only glyphs satisfying has are
+// present. Used both for the GSUB glyph closure and to find the nested
+// lookups a subset must keep.
+func sequenceContextLookups(sc *ot.SequenceContext, has func(ot.GlyphID) bool) []uint16 {
+	var out []uint16
+	add := func(records []ot.LookupRecord) {
+		for _, r := range records {
+			out = append(out, r.LookupIndex)
+		}
+	}
+
+	switch sc.Format {
+	case 1:
+		if sc.Coverage == nil {
+			return nil
+		}
+		for i, g := range sc.Coverage.Glyphs() {
+			if i >= len(sc.RuleSets) || !has(g) {
+				continue
+			}
+			for _, r := range sc.RuleSets[i] {
+				if allGlyphs(r.Backtrack, has) && allGlyphs(r.Input, has) && allGlyphs(r.Lookahead, has) {
+					add(r.LookupRecords)
+				}
+			}
+		}
+	case 2:
+		live := newContextClassLiveness(sc, has)
+		for i, rules := range sc.RuleSets {
+			if !live.first[uint16(i)] {
+				continue
+			}
+			for _, r := range rules {
+				if live.ruleMatches(r) {
+					add(r.LookupRecords)
+				}
+			}
+		}
+	case 3:
+		if allCoverages(sc.BacktrackCoverages, has) && allCoverages(sc.InputCoverages, has) &&
+			allCoverages(sc.LookaheadCoverages, has) {
+			add(sc.LookupRecords)
+		}
+	}
+	return out
+}
+
+func allGlyphs(glyphs []ot.GlyphID, has func(ot.GlyphID) bool) bool {
+	for _, g := range glyphs {
+		if !has(g) {
+			return false
+		}
+	}
+	return true
+}
+
+func allCoverages(covs []*ot.Coverage, has func(ot.GlyphID) bool) bool {
+	for _, c := range covs {
+		if !coverageIntersects(c, has) {
+			return false
+		}
+	}
+	return true
+}
+
+func coverageIntersects(c *ot.Coverage, has func(ot.GlyphID) bool) bool {
+	if c == nil {
+		return false
+	}
+	for _, g := range c.Glyphs() {
+		if has(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// contextClassLiveness records which classes of a format 2 subtable
+// still contain at least one available glyph. Class 0 also holds every
+// glyph not listed in the ClassDef and is always treated as live.
+type contextClassLiveness struct {
+	first                       map[uint16]bool
+	backtrack, input, lookahead map[uint16]bool
+}
+
+func newContextClassLiveness(sc *ot.SequenceContext, has func(ot.GlyphID) bool) *contextClassLiveness {
+	l := &contextClassLiveness{
+		first:     make(map[uint16]bool),
+		backtrack: liveClasses(sc.BacktrackClassDef, has),
+		input:     liveClasses(sc.InputClassDef, has),
+		lookahead: liveClasses(sc.LookaheadClassDef, has),
+	}
+	if sc.Coverage != nil {
+		for _, g := range sc.Coverage.Glyphs() {
+			if has(g) {
+				l.first[uint16(classOf(sc.InputClassDef, g))] = true
+			}
+		}
+	}
+	return l
+}
+
+func (l *contextClassLiveness) ruleMatches(r ot.ChainRule) bool {
+	return allClasses(r.Backtrack, l.backtrack) && allClasses(r.Input, l.input) &&
+		allClasses(r.Lookahead, l.lookahead)
+}
+
+func allClasses(classes []ot.GlyphID, live map[uint16]bool) bool {
+	for _, c := range classes {
+		if !live[uint16(c)] {
+			return false
+		}
+	}
+	return true
+}
+
+func liveClasses(cd *ot.ClassDef, has func(ot.GlyphID) bool) map[uint16]bool {
+	live := map[uint16]bool{0: true}
+	if cd == nil {
+		return live
+	}
+	for g, c := range cd.Mapping() {
+		if has(g) {
+			live[c] = true
+		}
+	}
+	return live
+}
+
+func classOf(cd *ot.ClassDef, g ot.GlyphID) int {
+	if cd == nil {
+		return 0
+	}
+	return cd.GetClass(g)
+}
+
+// contextSubsetter subsets contextual subtables against a glyph map and
+// a map of retained lookup indices.
+type contextSubsetter struct {
+	glyphMap  map[ot.GlyphID]ot.GlyphID
+	lookupMap map[uint16]uint16
+
+	// err is set to ErrOffsetOverflow when a subtable outgrows its
+	// 16-bit offsets.
+	err error
+}
+
+// pack is packTableChecked recording overflow in c.err.
+func (c *contextSubsetter) pack(header []byte, offsetAt []int, children [][]byte) []byte {
+	data, err := packTableChecked(header, offsetAt, children)
+	if err != nil {
+		c.err = err
+	}
+	return data
+}
+
+func (c *contextSubsetter) has(g ot.GlyphID) bool {
+	_, ok := c.glyphMap[g]
+	return ok
+}
+
+// remapGlyphs maps a glyph sequence, reporting false if any glyph was
+// dropped.
+func (c *contextSubsetter) remapGlyphs(glyphs []ot.GlyphID) ([]ot.GlyphID, bool) {
+	out := make([]ot.GlyphID, len(glyphs))
+	for i, g := range glyphs {
+		ng, ok := c.glyphMap[g]
+		if !ok {
+			return nil, false
+		}
+		out[i] = ng
+	}
+	return out, true
+}
+
+// remapLookupRecords renumbers nested lookup indices, dropping records
+// whose lookup is not retained.
+func (c *contextSubsetter) remapLookupRecords(records []ot.LookupRecord) []ot.LookupRecord {
+	out := make([]ot.LookupRecord, 0, len(records))
+	for _, r := range records {
+		if idx, ok := c.lookupMap[r.LookupIndex]; ok {
+			out = append(out, ot.LookupRecord{SequenceIndex: r.SequenceIndex, LookupIndex: idx})
+		}
+	}
+	return out
+}
+
+// remapCoverage returns the sorted new glyph IDs of the retained
+// covered glyphs.
+func (c *contextSubsetter) remapCoverage(cov *ot.Coverage) []ot.GlyphID {
+	if cov == nil {
+		return nil
+	}
+	var out []ot.GlyphID
+	for _, g := range cov.Glyphs() {
+		if ng, ok := c.glyphMap[g]; ok {
+			out = append(out, ng)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func (c *contextSubsetter) remapClassDef(cd *ot.ClassDef) []classEntry {
+	if cd == nil {
+		return nil
+	}
+	var entries []classEntry
+	for g, class := range cd.Mapping() {
+		if ng, ok := c.glyphMap[g]; ok {
+			entries = append(entries, classEntry{ng, class})
+		}
+	}
+	return entries
+}
+
+// subset returns the subsetted subtable, or nil if none of its rules
+// can match any more.
+func (c *contextSubsetter) subset(sc *ot.SequenceContext) []byte {
+	switch sc.Format {
+	case 1:
+		return c.subsetFormat1(sc)
+	case 2:
+		return c.subsetFormat2(sc)
+	case 3:
+		return c.subsetFormat3(sc)
+	}
+	return nil
+}
+
+// contextRuleSetEntry is a retained format 1 rule set keyed by its new
+// first glyph.
+type contextRuleSetEntry struct {
+	rules []ot.ChainRule
+	glyph ot.GlyphID
+}
+
+func (c *contextSubsetter) subsetFormat1(sc *ot.SequenceContext) []byte {
+	if sc.Coverage == nil {
+		return nil
+	}
+	var sets []contextRuleSetEntry
+	for i, g := range sc.Coverage.Glyphs() {
+		ng, ok := c.glyphMap[g]
+		if !ok || i >= len(sc.RuleSets) {
+			continue
+		}
+		var rules []ot.ChainRule
+		for _, r := range sc.RuleSets[i] {
+			bt, ok1 := c.remapGlyphs(r.Backtrack)
+			in, ok2 := c.remapGlyphs(r.Input)
+			la, ok3 := c.remapGlyphs(r.Lookahead)
+			if !ok1 || !ok2 || !ok3 {
+				continue
+			}
+			rules = append(rules, ot.ChainRule{
+				Backtrack:     bt,
+				Input:         in,
+				Lookahead:     la,
+				LookupRecords: c.remapLookupRecords(r.LookupRecords),
+			})
+		}
+		if len(rules) > 0 {
+			sets = append(sets, contextRuleSetEntry{rules: rules, glyph: ng})
+		}
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].glyph < sets[j].glyph })
+
+	glyphs := make([]ot.GlyphID, len(sets))
+	ruleSets := make([][]byte, len(sets))
+	for i, s := range sets {
+		glyphs[i] = s.glyph
+		ruleSets[i] = c.buildContextRuleSet(s.rules, sc.Chained)
+	}
+
+	// Header: format(2) + coverageOffset(2) + ruleSetCount(2) + ruleSetOffsets[](2*n)
+	header := appendUint16s(nil, 1, 0, uint16(len(sets)))
+	header = append(header, make([]byte, 2*len(sets))...)
+	offsets := []int{2}
+	children := [][]byte{buildCoverageFormat1(glyphs)}
+	for i, rs := range ruleSets {
+		offsets = append(offsets, 6+i*2)
+		children = append(children, rs)
+	}
+	return c.pack(header, offsets, children)
+}
+
+func (c *contextSubsetter) subsetFormat2(sc *ot.SequenceContext) []byte {
+	coverage := c.remapCoverage(sc.Coverage)
+	if len(coverage) == 0 {
+		return nil
+	}
+	live := newContextClassLiveness(sc, c.has)
+
+	ruleSets := make([][]byte, len(sc.RuleSets))
+	any := false
+	for i, rules := range sc.RuleSets {
+		if !live.first[uint16(i)] {
+			continue
+		}
+		var kept []ot.ChainRule
+		for _, r := range rules {
+			if !live.ruleMatches(r) {
+				continue
+			}
+			kept = append(kept, ot.ChainRule{
+				Backtrack:     r.Backtrack,
+				Input:         r.Input,
+				Lookahead:     r.Lookahead,
+				LookupRecords: c.remapLookupRecords(r.LookupRecords),
+			})
+		}
+		if len(kept) > 0 {
+			ruleSets[i] = c.buildContextRuleSet(kept, sc.Chained)
+			any = true
+		}
+	}
+	if !any {
+		return nil
+	}
+	// Trailing empty class sets carry no information.
+	for len(ruleSets) > 0 && ruleSets[len(ruleSets)-1] == nil {
+		ruleSets = ruleSets[:len(ruleSets)-1]
+	}
+
+	var header []byte
+	var offsets []int
+	var children [][]byte
+	if sc.Chained {
+		// Header: format(2) + coverageOffset(2) + backtrackClassDefOffset(2) +
+		//         inputClassDefOffset(2) + lookaheadClassDefOffset(2) +
+		//         chainClassSetCount(2) + chainClassSetOffsets[](2*n)
+		header = appendUint16s(nil, 2, 0, 0, 0, 0, uint16(len(ruleSets)))
+		offsets = []int{2, 4, 6, 8}
+		children = [][]byte{
+			buildCoverageFormat1(coverage),
+			buildClassDefFormat2(c.remapClassDef(sc.BacktrackClassDef)),
+			buildClassDefFormat2(c.remapClassDef(sc.InputClassDef)),
+			buildClassDefFormat2(c.remapClassDef(sc.LookaheadClassDef)),
+		}
+	} else {
+		// Header: format(2) + coverageOffset(2) + classDefOffset(2) +
+		//         classSetCount(2) + classSetOffsets[](2*n)
+		header = appendUint16s(nil, 2, 0, 0, uint16(len(ruleSets)))
+		offsets = []int{2, 4}
+		children = [][]byte{
+			buildCoverageFormat1(coverage),
+			buildClassDefFormat2(c.remapClassDef(sc.InputClassDef)),
+		}
+	}
+	setsStart := len(header)
+	header = append(header, make([]byte, 2*len(ruleSets))...)
+	for i, rs := range ruleSets {
+		offsets = append(offsets, setsStart+i*2)
+		children = append(children, rs)
+	}
+	return c.pack(header, offsets, children)
+}
+
+func (c *contextSubsetter) subsetFormat3(sc *ot.SequenceContext) []byte {
+	remapAll := func(covs []*ot.Coverage) ([][]byte, bool) {
+		out := make([][]byte, len(covs))
+		for i, cov := range covs {
+			glyphs := c.remapCoverage(cov)
+			if len(glyphs) == 0 {
+				return nil, false
+			}
+			out[i] = buildCoverageFormat1(glyphs)
+		}
+		return out, true
+	}
+	bt, ok1 := remapAll(sc.BacktrackCoverages)
+	in, ok2 := remapAll(sc.InputCoverages)
+	la, ok3 := remapAll(sc.LookaheadCoverages)
+	if !ok1 || !ok2 || !ok3 || len(in) == 0 {
+		return nil
+	}
+	records := c.remapLookupRecords(sc.LookupRecords)
+
+	var header []byte
+	var offsets []int
+	var children [][]byte
+	addCoverages := func(covs [][]byte) {
+		header = appendUint16s(header, uint16(len(covs)))
+		for _, cov := range covs {
+			offsets = append(offsets, len(header))
+			children = append(children, cov)
+			header = append(header, 0, 0)
+		}
+	}
+	if sc.Chained {
+		// Header: format(2) + backtrackGlyphCount(2) + backtrackCoverageOffsets[] +
+		//         inputGlyphCount(2) + inputCoverageOffsets[] +
+		//         lookaheadGlyphCount(2) + lookaheadCoverageOffsets[] +
+		//         seqLookupCount(2) + seqLookupRecords[]
+		header = appendUint16s(nil, 3)
+		addCoverages(bt)
+		addCoverages(in)
+		addCoverages(la)
+		header = appendUint16s(header, uint16(len(records)))
+	} else {
+		// Header: format(2) + glyphCount(2) + seqLookupCount(2) +
+		//         coverageOffsets[](2*n) + seqLookupRecords[]
+		header = appendUint16s(nil, 3, uint16(len(in)), uint16(len(records)))
+		for _, cov := range in {
+			offsets = append(offsets, len(header))
+			children = append(children, cov)
+			header = append(header, 0, 0)
+		}
+	}
+	header = appendLookupRecords(header, records)
+	return c.pack(header, offsets, children)
+}
+
+// buildContextRuleSet serializes a (Chain)RuleSet or (Chain)ClassSet.
+func (c *contextSubsetter) buildContextRuleSet(rules []ot.ChainRule, chained bool) []byte {
+	// RuleSet: ruleCount(2) + ruleOffsets[](2*n)
+	header := appendUint16s(nil, uint16(len(rules)))
+	header = append(header, make([]byte, 2*len(rules))...)
+	offsets := make([]int, len(rules))
+	children := make([][]byte, len(rules))
+	for i, r := range rules {
+		offsets[i] = 2 + i*2
+		children[i] = buildContextRule(r, chained)
+	}
+	return c.pack(header, offsets, children)
+}
+
+// buildContextRule serializes a (Chain)Rule or (Chain)ClassRule.
+func buildContextRule(r ot.ChainRule, chained bool) []byte {
+	var data []byte
+	if chained {
+		// ChainRule: backtrackGlyphCount(2) + backtrack[] + inputGlyphCount(2) +
+		//            input[] + lookaheadGlyphCount(2) + lookahead[] +
+		//            seqLookupCount(2) + seqLookupRecords[]
+		data = appendUint16s(data, uint16(len(r.Backtrack)))
+		data = appendGlyphs(data, r.Backtrack)
+		data = appendUint16s(data, uint16(len(r.Input)+1))
+		data = appendGlyphs(data, r.Input)
+		data = appendUint16s(data, uint16(len(r.Lookahead)))
+		data = appendGlyphs(data, r.Lookahead)
+		data = appendUint16s(data, uint16(len(r.LookupRecords)))
+	} else {
+		// Rule: glyphCount(2) + seqLookupCount(2) + input[] + seqLookupRecords[]
+		data = appendUint16s(data, uint16(len(r.Input)+1), uint16(len(r.LookupRecords)))
+		data = appendGlyphs(data, r.Input)
+	}
+	return appendLookupRecords(data, r.LookupRecords)
+}
+
+func appendUint16s(b []byte, vs ...uint16) []byte {
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func appendGlyphs(b []byte, glyphs []ot.GlyphID) []byte {
+	for _, g := range glyphs {
+		b = binary.BigEndian.AppendUint16(b, uint16(g))
+	}
+	return b
+}
+
+func appendLookupRecords(b []byte, records []ot.LookupRecord) []byte {
+	for _, r := range records {
+		b = appendUint16s(b, r.SequenceIndex, r.LookupIndex)
+	}
+	return b
+}
+
+// packTable appends children after header and writes each child's
+// 16-bit offset (relative to the table start) at the given header
+// position. Nil children leave a NULL offset.
+func packTable(header []byte, offsetAt []int, children [][]byte) []byte {
+	out := append([]byte(nil), header...)
+	for i, child := range children {
+		if child == nil {
+			continue
+		}
+		binary.BigEndian.PutUint16(out[offsetAt[i]:], uint16(len(out)))
+		out = append(out, child...)
+	}
+	return out
+}
+
+// packTableChecked is packTable for tables that may outgrow 16-bit
+// offsets: it returns ErrOffsetOverflow instead of silently wrapping.
+func packTableChecked(header []byte, offsetAt []int, children [][]byte) ([]byte, error) {
+	size := len(header)
+	for _, child := range children {
+		if child != nil && size > 0xFFFF {
+			return nil, ErrOffsetOverflow
+		}
+		size += len(child)
+	}
+	return packTable(header, offsetAt, children), nil
+}
@@ -0,0 +1,416 @@
+package subset
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func parseCoverageBytes(t *testing.T, glyphs ...ot.GlyphID) *ot.Coverage {
+	t.Helper()
+	cov, err := ot.ParseCoverage(buildCoverageFormat1(glyphs), 0)
+	if err != nil {
+		t.Fatalf("ParseCoverage: %v", err)
+	}
+	return cov
+}
+
+// ligaFeature returns a DFLT script with a single 'liga' feature.
+func ligaFeature(lookups ...uint16) ([]featureRecord, []scriptRecord) {
+	return []featureRecord{{tag: ot.TagLiga, lookups: lookups}},
+		[]scriptRecord{{tag: ot.MakeTag('D', 'F', 'L', 'T'), dfltLang: &langSysRecord{reqFeat: 0xFFFF, features: []uint16{0}}}}
+}
+
+// buildContextTestGSUB builds a GSUB with lookup 0 = chain context
+// format 3 (backtrack {bt}, input {in}, lookahead {la}) applying lookup
+// 1 at the input, and lookup 1 = single substitution in -> out.
+func buildContextTestGSUB(t *testing.T, bt, in, la, out ot.GlyphID) *ot.GSUB {
+	t.Helper()
+
+	header := appendUint16s(nil, 3, 1)
+	var offsets []int
+	var children [][]byte
+	offsets = append(offsets, len(header))
+	header = appendUint16s(header, 0)
+	children = append(children, buildCoverageFormat1([]ot.GlyphID{bt}))
+	header = appendUint16s(header, 1)
+	offsets = append(offsets, len(header))
+	header = appendUint16s(header, 0)
+	children = append(children, buildCoverageFormat1([]ot.GlyphID{in}))
+	header = appendUint16s(header, 1)
+	offsets = append(offsets, len(header))
+	header = appendUint16s(header, 0)
+	children = append(children, buildCoverageFormat1([]ot.GlyphID{la}))
+	header = appendUint16s(header, 1)
+	header = appendLookupRecords(header, []ot.LookupRecord{{SequenceIndex: 0, LookupIndex: 1}})
+	chain := packTable(header, offsets, children)
+
+	b := newGSUBBuilder(nil, nil)
+	b.addLookup(&lookupBuilder{lookupType: ot.GSUBTypeChainContext, subtables: [][]byte{chain}})
+	b.addLookup(&lookupBuilder{
+		lookupType: ot.GSUBTypeSingle,
+		subtables:  [][]byte{b.buildSingleSubstFormat2([]struct{ in, out ot.GlyphID }{{in, out}})},
+	})
+	b.features, b.scripts = ligaFeature(0)
+	data, err := b.build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	gsub, err := ot.ParseGSUB(data)
+	if err != nil {
+		t.Fatalf("ParseGSUB: %v", err)
+	}
+	return gsub
+}
+
+func TestSequenceContextLookups(t *testing.T) {
+	sc := &ot.SequenceContext{
+		Format:             3,
+		Chained:            true,
+		BacktrackCoverages: []*ot.Coverage{parseCoverageBytes(t, 1, 2)},
+		InputCoverages:     []*ot.Coverage{parseCoverageBytes(t, 5)},
+		LookaheadCoverages: []*ot.Coverage{parseCoverageBytes(t, 7)},
+		LookupRecords:      []ot.LookupRecord{{SequenceIndex: 0, LookupIndex: 3}},
+	}
+
+	in := func(glyphs ...ot.GlyphID) func(ot.GlyphID) bool {
+		return func(g ot.GlyphID) bool {
+			for _, h := range glyphs {
+				if g == h {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	if got := sequenceContextLookups(sc, in(2, 5, 7)); !reflect.DeepEqual(got, []uint16{3}) {
+		t.Errorf("live context: got %v, want [3]", got)
+	}
+	if got := sequenceContextLookups(sc, in(2, 5)); len(got) != 0 {
+		t.Errorf("missing lookahead: got %v, want none", got)
+	}
+}
+
+func TestGSUBClosureFollowsContext(t *testing.T) {
+	gsub := buildContextTestGSUB(t, 10, 11, 12, 20)
+
+	p := &Plan{gsub: gsub, glyphSet: map[ot.GlyphID]bool{10: true, 11: true, 12: true}}
+	out := make(map[ot.GlyphID]bool)
+	p.collectGSUBLookupOutputGlyphs(gsub.GetLookup(0), out, 0)
+	if !out[20] {
+		t.Errorf("nested lookup output not reached through the context")
+	}
+
+	delete(p.glyphSet, 12)
+	out = make(map[ot.GlyphID]bool)
+	p.collectGSUBLookupOutputGlyphs(gsub.GetLookup(0), out, 0)
+	if out[20] {
+		t.Errorf("context without lookahead glyph should not fire")
+	}
+}
+
+func TestSubsetChainContextLookup(t *testing.T) {
+	gsub := buildContextTestGSUB(t, 10, 11, 12, 20)
+
+	// Drop nothing but renumber glyphs and swap the lookup order.
+	glyphMap := map[ot.GlyphID]ot.GlyphID{10: 1, 11: 2, 12: 3, 20: 4}
+	glyphSet := map[ot.GlyphID]bool{10: true, 11: true, 12: true, 20: true}
+	b := newGSUBBuilder(glyphMap, glyphSet)
+	b.lookupMap = map[uint16]uint16{0: 1, 1: 0}
+	b.addLookup(b.subsetLookup(gsub.GetLookup(1)))
+	b.addLookup(b.subsetLookup(gsub.GetLookup(0)))
+	b.features, b.scripts = ligaFeature(1)
+
+	data, err := b.build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	out, err := ot.ParseGSUB(data)
+	if err != nil {
+		t.Fatalf("ParseGSUB: %v", err)
+	}
+
+	subtables := out.GetLookup(1).Subtables()
+	if len(subtables) != 1 {
+		t.Fatalf("got %d subtables, want 1", len(subtables))
+	}
+	ccs, ok := subtables[0].(*ot.ChainContextSubst)
+	if !ok {
+		t.Fatalf("got %T, want *ot.ChainContextSubst", subtables[0])
+	}
+	sc := ccs.SequenceContext()
+	for _, c := range []struct {
+		name string
+		cov  []*ot.Coverage
+		want ot.GlyphID
+	}{
+		{"backtrack", sc.BacktrackCoverages, 1},
+		{"input", sc.InputCoverages, 2},
+		{"lookahead", sc.LookaheadCoverages, 3},
+	} {
+		if len(c.cov) != 1 || !reflect.DeepEqual(c.cov[0].Glyphs(), []ot.GlyphID{c.want}) {
+			t.Errorf("%s coverage not remapped to [%d]", c.name, c.want)
+		}
+	}
+	if len(sc.LookupRecords) != 1 || sc.LookupRecords[0].LookupIndex != 0 {
+		t.Errorf("lookup records = %v, want nested lookup 0", sc.LookupRecords)
+	}
+
+	// Without the lookahead glyph the rule can never match.
+	delete(glyphSet, 12)
+	delete(glyphMap, 12)
+	if lb := b.subsetLookup(gsub.GetLookup(0)); lb != nil {
+		t.Errorf("context without lookahead glyph should be dropped")
+	}
+}
+
+// TestSubsetContextRoundTrip subsets every contextual lookup of real
+// fonts with the identity mapping and checks that the result parses back
+// to the same rules.
+func TestSubsetContextRoundTrip(t *testing.T) {
+	for _, name := range []string{"Roboto-Regular.ttf", "SourceSansPro-Regular.otf"} {
+		t.Run(name, func(t *testing.T) {
+			font := loadFont(t, name)
+			gsubData, err := font.TableData(ot.TagGSUB)
+			if err != nil {
+				t.Fatalf("GSUB: %v", err)
+			}
+			gsub, err := ot.ParseGSUB(gsubData)
+			if err != nil {
+				t.Fatalf("ParseGSUB: %v", err)
+			}
+
+			glyphMap := make(map[ot.GlyphID]ot.GlyphID)
+			glyphSet := make(map[ot.GlyphID]bool)
+			for g := 0; g < font.NumGlyphs(); g++ {
+				glyphMap[ot.GlyphID(g)] = ot.GlyphID(g)
+				glyphSet[ot.GlyphID(g)] = true
+			}
+			b := newGSUBBuilder(glyphMap, glyphSet)
+			b.lookupMap = make(map[uint16]uint16)
+			var all []uint16
+			for i := 0; i < gsub.NumLookups(); i++ {
+				b.lookupMap[uint16(i)] = uint16(i)
+				all = append(all, uint16(i))
+			}
+			b.features, b.scripts = ligaFeature(all...)
+			for i := 0; i < gsub.NumLookups(); i++ {
+				lb := b.subsetLookup(gsub.GetLookup(i))
+				if lb == nil {
+					t.Fatalf("lookup %d dropped with the full glyph set", i)
+				}
+				b.addLookup(lb)
+			}
+			data, err := b.build()
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			out, err := ot.ParseGSUB(data)
+			if err != nil {
+				t.Fatalf("ParseGSUB: %v", err)
+			}
+
+			contexts := 0
+			for i := 0; i < gsub.NumLookups(); i++ {
+				orig := gsub.GetLookup(i).Subtables()
+				got := out.GetLookup(i).Subtables()
+				if len(orig) != len(got) {
+					t.Fatalf("lookup %d: %d subtables, want %d", i, len(got), len(orig))
+				}
+				for j := range orig {
+					want := gsubSequenceContext(orig[j])
+					if want == nil {
+						continue
+					}
+					contexts++
+					compareSequenceContexts(t, i, want, gsubSequenceContext(got[j]))
+				}
+			}
+			if contexts == 0 {
+				t.Skip("font has no contextual subtables")
+			}
+			t.Logf("%d contextual subtables round-tripped", contexts)
+		})
+	}
+}
+
+func compareSequenceContexts(t *testing.T, lookup int, want, got *ot.SequenceContext) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("lookup %d: contextual subtable lost", lookup)
+	}
+	if got.Format != want.Format || got.Chained != want.Chained {
+		t.Fatalf("lookup %d: format %d/%v, want %d/%v", lookup, got.Format, got.Chained, want.Format, want.Chained)
+	}
+	coverageGlyphs := func(c *ot.Coverage) []ot.GlyphID {
+		if c == nil {
+			return nil
+		}
+		return c.Glyphs()
+	}
+	if !reflect.DeepEqual(coverageGlyphs(got.Coverage), coverageGlyphs(want.Coverage)) {
+		t.Errorf("lookup %d: coverage differs", lookup)
+	}
+	for _, pair := range [][2]*ot.ClassDef{
+		{got.BacktrackClassDef, want.BacktrackClassDef},
+		{got.InputClassDef, want.InputClassDef},
+		{got.LookaheadClassDef, want.LookaheadClassDef},
+	} {
+		if pair[1] == nil {
+			continue
+		}
+		if !reflect.DeepEqual(nonZeroClasses(pair[0]), nonZeroClasses(pair[1])) {
+			t.Errorf("lookup %d: class definitions differ", lookup)
+		}
+	}
+	// Format 2 drops trailing empty rule sets.
+	wantSets := want.RuleSets
+	for len(wantSets) > 0 && len(wantSets[len(wantSets)-1]) == 0 {
+		wantSets = wantSets[:len(wantSets)-1]
+	}
+	if len(got.RuleSets) != len(wantSets) {
+		t.Fatalf("lookup %d: %d rule sets, want %d", lookup, len(got.RuleSets), len(wantSets))
+	}
+	for i := range wantSets {
+		if len(got.RuleSets[i]) != len(wantSets[i]) {
+			t.Errorf("lookup %d set %d: %d rules, want %d", lookup, i, len(got.RuleSets[i]), len(wantSets[i]))
+			continue
+		}
+		for k, r := range wantSets[i] {
+			g := got.RuleSets[i][k]
+			if !equalGlyphs(g.Backtrack, r.Backtrack) || !equalGlyphs(g.Input, r.Input) ||
+				!equalGlyphs(g.Lookahead, r.Lookahead) || !equalRecords(g.LookupRecords, r.LookupRecords) {
+				t.Errorf("lookup %d set %d rule %d differs", lookup, i, k)
+			}
+		}
+	}
+	for _, pair := range [][2][]*ot.Coverage{
+		{got.BacktrackCoverages, want.BacktrackCoverages},
+		{got.InputCoverages, want.InputCoverages},
+		{got.LookaheadCoverages, want.LookaheadCoverages},
+	} {
+		if len(pair[0]) != len(pair[1]) {
+			t.Errorf("lookup %d: coverage count differs", lookup)
+			continue
+		}
+		for k := range pair[1] {
+			if !reflect.DeepEqual(pair[0][k].Glyphs(), pair[1][k].Glyphs()) {
+				t.Errorf("lookup %d: format 3 coverage %d differs", lookup, k)
+			}
+		}
+	}
+	if !equalRecords(got.LookupRecords, want.LookupRecords) {
+		t.Errorf("lookup %d: lookup records differ", lookup)
+	}
+}
+
+// TestLookupListExtensionPromotion builds a GSUB whose lookup list
+// outgrows 16-bit offsets: the lookups must be promoted to Extension
+// lookups and still parse back to the same substitutions.
+func TestLookupListExtensionPromotion(t *testing.T) {
+	b := newGSUBBuilder(nil, nil)
+	const n = 20000
+	for l := range 3 {
+		entries := make([]struct{ in, out ot.GlyphID }, n)
+		for i := range entries {
+			entries[i].in = ot.GlyphID(i)
+			entries[i].out = ot.GlyphID(i + l + 1)
+		}
+		b.addLookup(&lookupBuilder{
+			lookupType: ot.GSUBTypeSingle,
+			subtables:  [][]byte{b.buildSingleSubstFormat2(entries)},
+		})
+	}
+	b.features, b.scripts = ligaFeature(0, 1, 2)
+	data, err := b.build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	gsub, err := ot.ParseGSUB(data)
+	if err != nil {
+		t.Fatalf("ParseGSUB: %v", err)
+	}
+	lookupList := int(binary.BigEndian.Uint16(data[8:]))
+	for l := range 3 {
+		lookup := lookupList + int(binary.BigEndian.Uint16(data[lookupList+2+2*l:]))
+		if typ := binary.BigEndian.Uint16(data[lookup:]); typ != ot.GSUBTypeExtension {
+			t.Errorf("lookup %d: type %d, want extension", l, typ)
+		}
+		st := gsub.GetLookup(l).Subtables()
+		if len(st) != 1 {
+			t.Fatalf("lookup %d: %d subtables", l, len(st))
+		}
+		single, ok := st[0].(*ot.SingleSubst)
+		if !ok {
+			t.Fatalf("lookup %d: got %T", l, st[0])
+		}
+		m := single.Mapping()
+		if len(m) != n || m[n-1] != ot.GlyphID(n+l) {
+			t.Errorf("lookup %d: %d mappings, %d -> %d", l, len(m), n-1, m[n-1])
+		}
+	}
+
+	// Small tables keep their lookup types.
+	b = newGSUBBuilder(nil, nil)
+	b.addLookup(&lookupBuilder{
+		lookupType: ot.GSUBTypeSingle,
+		subtables:  [][]byte{b.buildSingleSubstFormat2([]struct{ in, out ot.GlyphID }{{1, 2}})},
+	})
+	b.features, b.scripts = ligaFeature(0)
+	data, _ = b.build()
+	lookupList = int(binary.BigEndian.Uint16(data[8:]))
+	lookup := lookupList + int(binary.BigEndian.Uint16(data[lookupList+2:]))
+	if typ := binary.BigEndian.Uint16(data[lookup:]); typ != ot.GSUBTypeSingle {
+		t.Errorf("small lookup: type %d, want single", typ)
+	}
+}
+
+// TestContextOffsetOverflow checks that a contextual subtable too large
+// for its 16-bit rule set offsets is reported instead of wrapped.
+func TestContextOffsetOverflow(t *testing.T) {
+	glyphMap := make(map[ot.GlyphID]ot.GlyphID)
+	glyphSet := make(map[ot.GlyphID]bool)
+	for g := range ot.GlyphID(2000) {
+		glyphMap[g], glyphSet[g] = g, true
+	}
+	input := make([]ot.GlyphID, 40)
+	for i := range input {
+		input[i] = ot.GlyphID(i)
+	}
+	first := make([]ot.GlyphID, 1000)
+	sc := &ot.SequenceContext{Format: 1, Chained: true}
+	for i := range first {
+		first[i] = ot.GlyphID(i)
+		sc.RuleSets = append(sc.RuleSets, []ot.ChainRule{{Input: input}})
+	}
+	sc.Coverage = parseCoverageBytes(t, first...)
+
+	ctx := &contextSubsetter{glyphMap: glyphMap}
+	if data := ctx.subset(sc); data != nil || ctx.err != ErrOffsetOverflow {
+		t.Errorf("got %d bytes, err %v; want ErrOffsetOverflow", len(data), ctx.err)
+	}
+}
+
+func nonZeroClasses(cd *ot.ClassDef) map[ot.GlyphID]uint16 {
+	out := make(map[ot.GlyphID]uint16)
+	if cd == nil {
+		return out
+	}
+	for g, c := range cd.Mapping() {
+		if c != 0 {
+			out[g] = c
+		}
+	}
+	return out
+}
+
+func equalGlyphs(a, b []ot.GlyphID) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
+
+func equalRecords(a, b []ot.LookupRecord) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
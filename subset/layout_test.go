@@ -0,0 +1,292 @@
+package subset
+
+import (
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// subsetLayout subsets SourceSansPro for text and returns the plan and
+// the parsed GSUB of the result.
+func subsetLayout(t *testing.T, text string, configure func(*Input)) (*Plan, *ot.GSUB) {
+	t.Helper()
+	font := loadFont(t, "SourceSansPro-Regular.otf")
+
+	input := NewInput()
+	input.AddString(text)
+	if configure != nil {
+		configure(input)
+	}
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	result, err := plan.Execute()
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	subFont, err := ot.ParseFont(result, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse subset font: %v", err)
+	}
+	data, err := subFont.TableData(ot.TagGSUB)
+	if err != nil {
+		t.Fatalf("subset has no GSUB: %v", err)
+	}
+	gsub, err := ot.ParseGSUB(data)
+	if err != nil {
+		t.Fatalf("ParseGSUB: %v", err)
+	}
+	checkLayoutIndices(t, gsub)
+	return plan, gsub
+}
+
+// checkLayoutIndices verifies that all feature and lookup indices of a
+// subset GSUB are in range.
+func checkLayoutIndices(t *testing.T, gsub *ot.GSUB) {
+	t.Helper()
+	features, err := gsub.ParseFeatureList()
+	if err != nil {
+		t.Fatalf("ParseFeatureList: %v", err)
+	}
+	scripts, err := gsub.ParseScriptList()
+	if err != nil {
+		t.Fatalf("ParseScriptList: %v", err)
+	}
+	for i := 0; i < features.Count(); i++ {
+		f, err := features.GetFeature(i)
+		if err != nil {
+			t.Fatalf("feature %d: %v", i, err)
+		}
+		for _, l := range f.Lookups {
+			if int(l) >= gsub.NumLookups() {
+				t.Errorf("feature %s references lookup %d of %d", f.Tag, l, gsub.NumLookups())
+			}
+		}
+	}
+	check := func(ls *ot.LangSys) {
+		if ls == nil {
+			return
+		}
+		for _, idx := range ls.FeatureIndices {
+			if int(idx) >= features.Count() {
+				t.Errorf("language system references feature %d of %d", idx, features.Count())
+			}
+		}
+	}
+	for _, s := range scripts.Scripts() {
+		check(s.DefaultLangSys)
+		for _, ls := range s.LangSys {
+			check(ls.LangSys)
+		}
+	}
+}
+
+func featureTags(t *testing.T, gsub *ot.GSUB) map[ot.Tag]*ot.FeatureRecord {
+	t.Helper()
+	features, err := gsub.ParseFeatureList()
+	if err != nil {
+		t.Fatalf("ParseFeatureList: %v", err)
+	}
+	tags := make(map[ot.Tag]*ot.FeatureRecord)
+	for i := 0; i < features.Count(); i++ {
+		f, _ := features.GetFeature(i)
+		tags[f.Tag] = f
+	}
+	return tags
+}
+
+func TestLayoutDefaultFeatures(t *testing.T) {
+	defPlan, gsub := subsetLayout(t, "office", nil)
+	tags := featureTags(t, gsub)
+
+	if tags[ot.TagLiga] == nil {
+		t.Errorf("default subset lost 'liga'")
+	}
+	for _, tag := range []string{"aalt", "salt", "ss01", "smcp"} {
+		if tags[ot.MakeTag(tag[0], tag[1], tag[2], tag[3])] != nil {
+			t.Errorf("default subset retained '%s'", tag)
+		}
+	}
+
+	allPlan, gsub := subsetLayout(t, "office", func(in *Input) { in.KeepAllFeatures() })
+	tags = featureTags(t, gsub)
+	ss01 := tags[ot.MakeTag('s', 's', '0', '1')]
+	if ss01 == nil {
+		t.Fatalf("KeepAllFeatures lost 'ss01'")
+	}
+	if len(ss01.Params) != 4 {
+		t.Errorf("'ss01' params = %d bytes, want 4", len(ss01.Params))
+	}
+
+	// Alternates of 'aalt', 'smcp' etc. are only kept with all features.
+	if len(defPlan.GlyphSet()) >= len(allPlan.GlyphSet()) {
+		t.Errorf("default glyph set (%d) not smaller than with all features (%d)",
+			len(defPlan.GlyphSet()), len(allPlan.GlyphSet()))
+	}
+}
+
+func TestLayoutKeepFeature(t *testing.T) {
+	plan, gsub := subsetLayout(t, "office", func(in *Input) { in.KeepFeature(ot.TagLiga) })
+	tags := featureTags(t, gsub)
+	if len(tags) != 1 || tags[ot.TagLiga] == nil {
+		t.Errorf("got features %v, want only 'liga'", tags)
+	}
+
+	// Only the ffi ligature is added to the requested glyphs.
+	if got := len(plan.GlyphSet()); got != 1+5+1 {
+		t.Errorf("glyph set has %d glyphs, want notdef, o, f, i, c, e and ffi", got)
+	}
+}
+
+func TestLayoutKeepScript(t *testing.T) {
+	latn := ot.MakeTag('l', 'a', 't', 'n')
+	_, gsub := subsetLayout(t, "office", func(in *Input) { in.KeepScript(latn) })
+
+	scripts, err := gsub.ParseScriptList()
+	if err != nil {
+		t.Fatalf("ParseScriptList: %v", err)
+	}
+	got := scripts.Scripts()
+	if len(got) != 1 || got[0].Tag != latn {
+		t.Fatalf("got %d scripts, want only 'latn'", len(got))
+	}
+
+	// Language systems identical to the default one are pruned.
+	for _, ls := range got[0].LangSys {
+		if sameLangSys(ls.LangSys, got[0].DefaultLangSys) {
+			t.Errorf("language system %s duplicates the default", ls.Tag)
+		}
+	}
+}
+
+func TestLayoutClosureSkipsDroppedFeatures(t *testing.T) {
+	font := loadFont(t, "SourceSansPro-Regular.otf")
+	cmap := loadCmap(t, font)
+	a, _ := cmap.Lookup('a')
+
+	input := NewInput()
+	input.AddUnicode('a')
+	input.KeepFeature(ot.TagLiga)
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	if got := len(plan.GlyphSet()); got != 2 {
+		t.Errorf("glyph set = %d glyphs, want notdef and a (gid %d)", got, a)
+	}
+}
+
+func loadCmap(t *testing.T, font *ot.Font) *ot.Cmap {
+	t.Helper()
+	data, err := font.TableData(ot.TagCmap)
+	if err != nil {
+		t.Fatalf("cmap: %v", err)
+	}
+	cmap, err := ot.ParseCmap(data)
+	if err != nil {
+		t.Fatalf("ParseCmap: %v", err)
+	}
+	return cmap
+}
+
+func TestLayoutFeatureVariationsInstanced(t *testing.T) {
+	// HarfBuzz test font variations-rvrn: 'rvrn' has no lookups by
+	// default; a FeatureVariations record substitutes a lookup swapping
+	// rvrn_base for rvrn_subst at high FVTT values.
+	font := loadFont(t, "d23d76ea0909c14972796937ba072b5a40c1e257.ttf")
+
+	for _, tc := range []struct {
+		fvtt    float32
+		advance int16
+	}{
+		{0, 1529},   // rvrn_base
+		{630, 1825}, // rvrn_subst
+	} {
+		input := NewInput()
+		input.AddUnicode('r')
+		input.PinAxisLocation(ot.MakeTag('F', 'V', 'T', 'T'), tc.fvtt)
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset font: %v", err)
+		}
+		if subFont.HasTable(ot.TagFvar) {
+			t.Fatalf("FVTT=%v: subset is still variable", tc.fvtt)
+		}
+
+		shaper, err := ot.NewShaper(subFont)
+		if err != nil {
+			t.Fatalf("NewShaper: %v", err)
+		}
+		buf := ot.NewBuffer()
+		buf.AddString("r")
+		shaper.Shape(buf, nil)
+		if got := buf.Pos[0].XAdvance; got != tc.advance {
+			t.Errorf("FVTT=%v: advance %d, want %d", tc.fvtt, got, tc.advance)
+		}
+	}
+}
+
+func TestLayoutFeatureVariationsKept(t *testing.T) {
+	// The rvrn test font (see TestLayoutFeatureVariationsInstanced)
+	// substitutes rvrn_subst for rvrn_base at FVTT >= 0.667 normalized.
+	font := loadFont(t, "d23d76ea0909c14972796937ba072b5a40c1e257.ttf")
+	tagFVTT := ot.MakeTag('F', 'V', 'T', 'T')
+	shape := func(font *ot.Font, fvtt float32) ot.GlyphID {
+		shaper, err := ot.NewShaper(font)
+		if err != nil {
+			t.Fatalf("NewShaper: %v", err)
+		}
+		shaper.SetVariation(tagFVTT, fvtt)
+		buf := ot.NewBuffer()
+		buf.AddString("r")
+		shaper.Shape(buf, nil)
+		return ot.GlyphID(buf.Info[0].GlyphID)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		min, def, max float32
+		locations     []float32
+	}{
+		{"variable", 0, 0, 0, []float32{0, 400, 630}},
+		{"restricted", 0, 0, 500, []float32{0, 300, 500}},
+		{"raised default", 500, 500, 630, []float32{500, 630}},
+	} {
+		input := NewInput()
+		input.AddUnicode('r')
+		if tc.max != 0 {
+			input.RestrictAxisRange(tagFVTT, tc.min, tc.def, tc.max)
+		}
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("%s: CreatePlan: %v", tc.name, err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("%s: Execute: %v", tc.name, err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("%s: Failed to parse subset font: %v", tc.name, err)
+		}
+		for _, fvtt := range tc.locations {
+			want, ok := plan.glyphMap[shape(font, fvtt)]
+			if !ok {
+				t.Errorf("%s: FVTT=%v: glyph %d not retained", tc.name, fvtt, shape(font, fvtt))
+				continue
+			}
+			if got := shape(subFont, fvtt); got != want {
+				t.Errorf("%s: FVTT=%v: glyph %d, want %d", tc.name, fvtt, got, want)
+			}
+		}
+	}
+}
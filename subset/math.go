@@ -0,0 +1,406 @@
+package subset
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// MATH table subsetting.
+//
+// The table is rebuilt from the source bytes: the per-glyph records of
+// MathGlyphInfo and MathVariants are restricted to the retained glyphs
+// and remapped, and their coverage tables are rebuilt. As in GPOS, the
+// VariationIndex device tables of MathValueRecords refer to the GDEF item
+// variation store and are remapped through the builder it is subset with;
+// at an instanced location their deltas are baked into the values. Other
+// device tables are dropped.
+
+// computeMATHClosure adds the size variants and assembly parts of the
+// retained glyphs, and those of the added glyphs in turn.
+// HarfBuzz equivalent: MATH::closure_glyphs (hb-ot-math-table.hh)
+func (p *Plan) computeMATHClosure() {
+	if p.math == nil || p.input.ShouldDropTable(ot.TagMATH) {
+		return
+	}
+	queue := make([]ot.GlyphID, 0, len(p.glyphSet))
+	for gid := range p.glyphSet {
+		queue = append(queue, gid)
+	}
+	for len(queue) > 0 {
+		gid := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		var glyphs []ot.GlyphID
+		for _, v := range p.math.VerticalVariants(gid) {
+			glyphs = append(glyphs, v.GlyphID)
+		}
+		for _, v := range p.math.HorizontalVariants(gid) {
+			glyphs = append(glyphs, v.GlyphID)
+		}
+		for _, a := range []*ot.MathGlyphAssembly{p.math.VerticalAssembly(gid), p.math.HorizontalAssembly(gid)} {
+			if a == nil {
+				continue
+			}
+			for _, part := range a.Parts {
+				glyphs = append(glyphs, part.GlyphID)
+			}
+		}
+		for _, g := range glyphs {
+			if !p.glyphSet[g] {
+				p.glyphSet[g] = true
+				queue = append(queue, g)
+			}
+		}
+	}
+}
+
+// mathWriter rebuilds the subtables of a MATH table.
+type mathWriter struct {
+	p     *Plan
+	data  []byte
+	delta func(*ot.Device) int16
+	vars  *varStoreBuilder
+	err   error
+}
+
+// subsetMATH subsets the MATH table.
+// HarfBuzz equivalent: MATH::subset (hb-ot-math-table.hh)
+func (p *Plan) subsetMATH() ([]byte, error) {
+	data, err := p.source.TableData(ot.TagMATH)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 10 {
+		return nil, ot.ErrInvalidTable
+	}
+	w := &mathWriter{p: p, data: data, delta: p.layoutDeviceDelta(), vars: p.layoutVarStore()}
+	var constants, glyphInfo, variants []byte
+	if off := w.offset(0, 4); off != 0 {
+		constants = w.constants(off)
+	}
+	if off := w.offset(0, 6); off != 0 {
+		glyphInfo = w.glyphInfo(off)
+	}
+	if off := w.offset(0, 8); off != 0 {
+		variants = w.variants(off)
+	}
+	out := w.pack(appendUint16s(nil, 1, 0, 0, 0, 0), []int{4, 6, 8}, [][]byte{constants, glyphInfo, variants})
+	if w.err != nil {
+		return nil, w.err
+	}
+	return out, nil
+}
+
+// offset returns the absolute position of the Offset16 at base+at, or 0
+// for a null offset.
+func (w *mathWriter) offset(base, at int) int {
+	if base+at+2 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return 0
+	}
+	off := int(binary.BigEndian.Uint16(w.data[base+at:]))
+	if off == 0 {
+		return 0
+	}
+	return base + off
+}
+
+// appendValue appends the MathValueRecord at off with the deltas of its
+// device table baked in, recording the remapped device in devs. Device
+// offsets are relative to base, the start of the table holding the
+// record, in the source and in out.
+func (w *mathWriter) appendValue(out []byte, base, off int, devs *deviceTables) []byte {
+	if off+4 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return appendUint16s(out, 0, 0)
+	}
+	v := int16(binary.BigEndian.Uint16(w.data[off:]))
+	var dev *ot.Device
+	if d := int(binary.BigEndian.Uint16(w.data[off+2:])); d != 0 && base+d+6 <= len(w.data) &&
+		binary.BigEndian.Uint16(w.data[base+d+4:]) == ot.DeltaFormatVariationIndex {
+		dev = &ot.Device{DeltaFormat: ot.DeltaFormatVariationIndex, VarIdx: binary.BigEndian.Uint32(w.data[base+d:])}
+	}
+	if w.delta != nil {
+		v += w.delta(dev)
+	}
+	devs.add(len(out)+2, w.device(dev))
+	return appendUint16s(out, uint16(v), 0)
+}
+
+// device returns the VariationIndex device d with its index remapped, or
+// nil if it is dropped.
+func (w *mathWriter) device(d *ot.Device) *ot.Device {
+	if w.vars == nil || d == nil {
+		return nil
+	}
+	w.vars.add(d.VarIdx)
+	idx := w.vars.mapped(d.VarIdx)
+	if idx == noVariationsIndex {
+		return nil
+	}
+	return &ot.Device{DeltaFormat: ot.DeltaFormatVariationIndex, VarIdx: idx}
+}
+
+// appendDevices is deviceTables.appendTo recording an overflow in w.err.
+func (w *mathWriter) appendDevices(data []byte, devs *deviceTables) []byte {
+	data, ok := devs.appendTo(data)
+	if !ok {
+		w.err = ErrOffsetOverflow
+	}
+	return data
+}
+
+// pack is packTable with a check for 16-bit offset overflow.
+func (w *mathWriter) pack(header []byte, offsetAt []int, children [][]byte) []byte {
+	size := len(header)
+	for _, child := range children {
+		if child != nil && size > 0xFFFF {
+			w.err = ErrOffsetOverflow
+		}
+		size += len(child)
+	}
+	return packTable(header, offsetAt, children)
+}
+
+// mathCovered is a retained glyph of a coverage table.
+type mathCovered struct {
+	glyph ot.GlyphID // new glyph ID
+	index int        // coverage index in the source
+}
+
+// covered returns the retained glyphs of the coverage table at off that
+// have one of count records, sorted by new glyph ID.
+func (w *mathWriter) covered(off, count int) []mathCovered {
+	cov, err := ot.ParseCoverage(w.data, off)
+	if err != nil {
+		w.err = err
+		return nil
+	}
+	var out []mathCovered
+	for i, gid := range cov.Glyphs() {
+		if i >= count {
+			break
+		}
+		if newGID, ok := w.p.glyphMap[gid]; ok {
+			out = append(out, mathCovered{newGID, i})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].glyph < out[j].glyph })
+	return out
+}
+
+// coverage builds the coverage table of the covered glyphs.
+func (w *mathWriter) coverage(covered []mathCovered) []byte {
+	glyphs := make([]ot.GlyphID, len(covered))
+	for i, c := range covered {
+		glyphs[i] = c.glyph
+	}
+	return buildCoverageFormat1(glyphs)
+}
+
+// constants copies the MathConstants table: four 16-bit values, 51
+// MathValueRecords and a final 16-bit percentage.
+func (w *mathWriter) constants(off int) []byte {
+	const numValues = 51
+	if off+8+numValues*4+2 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	var devs deviceTables
+	out := append([]byte(nil), w.data[off:off+8]...)
+	for i := range numValues {
+		out = w.appendValue(out, off, off+8+i*4, &devs)
+	}
+	out = append(out, w.data[off+8+numValues*4:off+8+numValues*4+2]...)
+	return w.appendDevices(out, &devs)
+}
+
+// glyphInfo subsets the MathGlyphInfo table.
+func (w *mathWriter) glyphInfo(off int) []byte {
+	var italics, topAccent, extended, kernInfo []byte
+	if t := w.offset(off, 0); t != 0 {
+		italics = w.glyphValues(t)
+	}
+	if t := w.offset(off, 2); t != 0 {
+		topAccent = w.glyphValues(t)
+	}
+	if t := w.offset(off, 4); t != 0 {
+		if covered := w.covered(t, 0xFFFF); len(covered) > 0 {
+			extended = w.coverage(covered)
+		}
+	}
+	if t := w.offset(off, 6); t != 0 {
+		kernInfo = w.kernInfo(t)
+	}
+	return w.pack(make([]byte, 8), []int{0, 2, 4, 6}, [][]byte{italics, topAccent, extended, kernInfo})
+}
+
+// glyphValues subsets a MathItalicsCorrectionInfo or
+// MathTopAccentAttachment table: a coverage with one MathValueRecord per
+// glyph. Returns nil if no glyph is retained.
+func (w *mathWriter) glyphValues(off int) []byte {
+	if off+4 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	count := int(binary.BigEndian.Uint16(w.data[off+2:]))
+	covered := w.covered(w.offset(off, 0), count)
+	if len(covered) == 0 {
+		return nil
+	}
+	var devs deviceTables
+	header := appendUint16s(nil, 0, uint16(len(covered)))
+	for _, c := range covered {
+		header = w.appendValue(header, off, off+4+c.index*4, &devs)
+	}
+	return w.appendDevices(w.pack(header, []int{0}, [][]byte{w.coverage(covered)}), &devs)
+}
+
+// kernInfo subsets the MathKernInfo table: a coverage with four MathKern
+// offsets per glyph, one per corner. Returns nil if no glyph is retained.
+func (w *mathWriter) kernInfo(off int) []byte {
+	if off+4 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	count := int(binary.BigEndian.Uint16(w.data[off+2:]))
+	if off+4+count*8 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	covered := w.covered(w.offset(off, 0), count)
+	if len(covered) == 0 {
+		return nil
+	}
+	header := appendUint16s(nil, 0, uint16(len(covered)))
+	header = append(header, make([]byte, len(covered)*8)...)
+	offsetAt := []int{0}
+	children := [][]byte{w.coverage(covered)}
+	for i, c := range covered {
+		for corner := range 4 {
+			if k := w.offset(off, 4+c.index*8+corner*2); k != 0 {
+				offsetAt = append(offsetAt, 4+i*8+corner*2)
+				children = append(children, w.mathKern(k))
+			}
+		}
+	}
+	return w.pack(header, offsetAt, children)
+}
+
+// mathKern copies a MathKern table: n correction heights and n+1 kern
+// values.
+func (w *mathWriter) mathKern(off int) []byte {
+	if off+2 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	var devs deviceTables
+	n := int(binary.BigEndian.Uint16(w.data[off:]))
+	out := binary.BigEndian.AppendUint16(nil, uint16(n))
+	for i := range 2*n + 1 {
+		out = w.appendValue(out, off, off+2+i*4, &devs)
+	}
+	return w.appendDevices(out, &devs)
+}
+
+// variants subsets the MathVariants table.
+func (w *mathWriter) variants(off int) []byte {
+	if off+10 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	vertCount := int(binary.BigEndian.Uint16(w.data[off+6:]))
+	horizCount := int(binary.BigEndian.Uint16(w.data[off+8:]))
+	if off+10+2*(vertCount+horizCount) > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	var vert, horiz []mathCovered
+	if vertCount > 0 {
+		vert = w.covered(w.offset(off, 2), vertCount)
+	}
+	if horizCount > 0 {
+		horiz = w.covered(w.offset(off, 4), horizCount)
+	}
+
+	minConnectorOverlap := binary.BigEndian.Uint16(w.data[off:])
+	header := appendUint16s(nil, minConnectorOverlap, 0, 0, uint16(len(vert)), uint16(len(horiz)))
+	header = append(header, make([]byte, 2*(len(vert)+len(horiz)))...)
+	var offsetAt []int
+	var children [][]byte
+	if len(vert) > 0 {
+		offsetAt = append(offsetAt, 2)
+		children = append(children, w.coverage(vert))
+	}
+	if len(horiz) > 0 {
+		offsetAt = append(offsetAt, 4)
+		children = append(children, w.coverage(horiz))
+	}
+	for i, c := range vert {
+		offsetAt = append(offsetAt, 10+i*2)
+		children = append(children, w.construction(w.offset(off, 10+c.index*2)))
+	}
+	for i, c := range horiz {
+		offsetAt = append(offsetAt, 10+2*len(vert)+i*2)
+		children = append(children, w.construction(w.offset(off, 10+2*vertCount+c.index*2)))
+	}
+	return w.pack(header, offsetAt, children)
+}
+
+// construction subsets a MathGlyphConstruction table. Variants that are
+// not retained are dropped.
+func (w *mathWriter) construction(off int) []byte {
+	if off == 0 || off+4 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	count := int(binary.BigEndian.Uint16(w.data[off+2:]))
+	if off+4+count*4 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	var records []byte
+	n := 0
+	for i := range count {
+		rec := w.data[off+4+i*4:]
+		if newGID, ok := w.p.glyphMap[binary.BigEndian.Uint16(rec)]; ok {
+			records = appendUint16s(records, newGID, binary.BigEndian.Uint16(rec[2:]))
+			n++
+		}
+	}
+	var assembly []byte
+	if a := w.offset(off, 0); a != 0 {
+		assembly = w.assembly(a)
+	}
+	header := append(appendUint16s(nil, 0, uint16(n)), records...)
+	return w.pack(header, []int{0}, [][]byte{assembly})
+}
+
+// assembly subsets a GlyphAssembly table. Returns nil if a part is not
+// retained.
+func (w *mathWriter) assembly(off int) []byte {
+	if off+6 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	count := int(binary.BigEndian.Uint16(w.data[off+4:]))
+	if off+6+count*10 > len(w.data) {
+		w.err = ot.ErrInvalidTable
+		return nil
+	}
+	var parts []byte
+	for i := range count {
+		part := w.data[off+6+i*10 : off+16+i*10]
+		newGID, ok := w.p.glyphMap[binary.BigEndian.Uint16(part)]
+		if !ok {
+			return nil
+		}
+		parts = binary.BigEndian.AppendUint16(parts, newGID)
+		parts = append(parts, part[2:]...)
+	}
+	var devs deviceTables
+	out := w.appendValue(nil, off, off, &devs)
+	out = binary.BigEndian.AppendUint16(out, uint16(count))
+	return w.appendDevices(append(out, parts...), &devs)
+}
@@ -0,0 +1,226 @@
+package subset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// appendMathValue appends a MathValueRecord without device table.
+func appendMathValue(b []byte, v int16) []byte {
+	return appendUint16s(b, uint16(v), 0)
+}
+
+// buildMATHTable builds a MATH table for Roboto glyphs. Glyph 7 has an
+// italics correction, a top accent attachment, a top right kern, two
+// vertical variants (8 and 9) and an assembly of glyphs 10 to 12. Glyph
+// 20 is an extended shape with a horizontal variant (21); glyph 40 only
+// has an italics correction.
+func buildMATHTable() []byte {
+	valueTable := func(glyphs []ot.GlyphID, values ...int16) []byte {
+		header := appendUint16s(nil, 0, uint16(len(values)))
+		for _, v := range values {
+			header = appendMathValue(header, v)
+		}
+		return packTable(header, []int{0}, [][]byte{buildCoverageFormat1(glyphs)})
+	}
+
+	constants := make([]byte, 8+51*4+2)
+	copy(constants[8:], appendUint16s(nil, 150))     // MathLeading
+	copy(constants[8+51*4:], appendUint16s(nil, 75)) // RadicalDegreeBottomRaisePercent
+
+	mathKern := appendMathValue(appendUint16s(nil, 1), 100)
+	mathKern = appendMathValue(appendMathValue(mathKern, 10), 20)
+	kernInfo := packTable(appendUint16s(nil, 0, 1, 0, 0, 0, 0), []int{0, 4},
+		[][]byte{buildCoverageFormat1([]ot.GlyphID{7}), mathKern})
+	glyphInfo := packTable(make([]byte, 8), []int{0, 2, 4, 6}, [][]byte{
+		valueTable([]ot.GlyphID{7, 20, 40}, 30, 15, 99),
+		valueTable([]ot.GlyphID{7}, 250),
+		buildCoverageFormat1([]ot.GlyphID{20}),
+		kernInfo,
+	})
+
+	assembly := appendUint16s(appendMathValue(nil, 50), 3,
+		10, 0, 30, 200, 0,
+		11, 30, 30, 100, 1,
+		12, 30, 0, 200, 0)
+	vert := packTable(appendUint16s(nil, 0, 2, 8, 400, 9, 600), []int{0}, [][]byte{assembly})
+	horiz := appendUint16s(nil, 0, 1, 21, 500)
+	variants := packTable(appendUint16s(nil, 5, 0, 0, 1, 1, 0, 0), []int{2, 4, 10, 12}, [][]byte{
+		buildCoverageFormat1([]ot.GlyphID{7}),
+		buildCoverageFormat1([]ot.GlyphID{20}),
+		vert,
+		horiz,
+	})
+
+	return packTable(appendUint16s(nil, 1, 0, 0, 0, 0), []int{4, 6, 8},
+		[][]byte{constants, glyphInfo, variants})
+}
+
+func TestSubsetMATH(t *testing.T) {
+	font := buildTestFont(t, map[ot.Tag][]byte{ot.TagMATH: buildMATHTable()})
+	src, err := ot.ParseMath(mustTableData(t, font, ot.TagMATH))
+	if err != nil {
+		t.Fatalf("ParseMath: %v", err)
+	}
+	tests := []struct {
+		glyphs []ot.GlyphID
+		want   []ot.GlyphID // retained glyphs besides .notdef
+	}{
+		{[]ot.GlyphID{7}, []ot.GlyphID{7, 8, 9, 10, 11, 12}},
+		{[]ot.GlyphID{20, 40}, []ot.GlyphID{20, 21, 40}},
+		{[]ot.GlyphID{9, 40}, []ot.GlyphID{9, 40}},
+	}
+	for _, tt := range tests {
+		input := NewInput()
+		input.AddGlyphs(tt.glyphs...)
+		input.Flags = FlagNoLayoutClosure
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		for _, gid := range tt.want {
+			if _, ok := plan.MapGlyph(gid); !ok {
+				t.Errorf("glyphs %v: glyph %d not retained", tt.glyphs, gid)
+			}
+		}
+		if n := plan.NumOutputGlyphs(); n != len(tt.want)+1 {
+			t.Errorf("glyphs %v: %d glyphs, want %d", tt.glyphs, n, len(tt.want)+1)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset: %v", err)
+		}
+		m, err := ot.ParseMath(mustTableData(t, subFont, ot.TagMATH))
+		if err != nil {
+			t.Fatalf("glyphs %v: ParseMath: %v", tt.glyphs, err)
+		}
+		if c := m.Constants(); c == nil || c.MathLeading != 150 || c.RadicalDegreeBottomRaisePercent != 75 {
+			t.Errorf("glyphs %v: constants %+v", tt.glyphs, c)
+		}
+
+		remap := func(v []ot.MathVariant) []ot.MathVariant {
+			var out []ot.MathVariant
+			for _, variant := range v {
+				newGID, _ := plan.MapGlyph(variant.GlyphID)
+				out = append(out, ot.MathVariant{GlyphID: newGID, AdvanceFU: variant.AdvanceFU})
+			}
+			return out
+		}
+		remapAssembly := func(a *ot.MathGlyphAssembly) *ot.MathGlyphAssembly {
+			if a == nil {
+				return nil
+			}
+			out := &ot.MathGlyphAssembly{ItalicsCorrectionFU: a.ItalicsCorrectionFU}
+			for _, part := range a.Parts {
+				part.GlyphID, _ = plan.MapGlyph(part.GlyphID)
+				out.Parts = append(out.Parts, part)
+			}
+			return out
+		}
+		for oldGID := range plan.GlyphSet() {
+			newGID, _ := plan.MapGlyph(oldGID)
+			if got, want := m.ItalicCorrection(newGID), src.ItalicCorrection(oldGID); got != want {
+				t.Errorf("glyphs %v: italics correction of %d is %d, want %d", tt.glyphs, oldGID, got, want)
+			}
+			if got, want := m.TopAccentAttachment(newGID), src.TopAccentAttachment(oldGID); got != want {
+				t.Errorf("glyphs %v: top accent of %d is %d, want %d", tt.glyphs, oldGID, got, want)
+			}
+			if got, want := m.IsExtendedShape(newGID), src.IsExtendedShape(oldGID); got != want {
+				t.Errorf("glyphs %v: extended shape of %d is %v, want %v", tt.glyphs, oldGID, got, want)
+			}
+			got := fmt.Sprint(m.MathKernEntries(newGID, ot.MathKernTopRight), m.VerticalVariants(newGID),
+				m.HorizontalVariants(newGID), m.VerticalAssembly(newGID), m.HorizontalAssembly(newGID))
+			want := fmt.Sprint(src.MathKernEntries(oldGID, ot.MathKernTopRight), remap(src.VerticalVariants(oldGID)),
+				remap(src.HorizontalVariants(oldGID)), remapAssembly(src.VerticalAssembly(oldGID)),
+				remapAssembly(src.HorizontalAssembly(oldGID)))
+			if got != want {
+				t.Errorf("glyphs %v: glyph %d:\n got %s\nwant %s", tt.glyphs, oldGID, got, want)
+			}
+		}
+		if !m.HasMathVariants() || m.MinConnectorOverlap() != 5 {
+			t.Errorf("glyphs %v: minConnectorOverlap %d, want 5", tt.glyphs, m.MinConnectorOverlap())
+		}
+	}
+}
+
+func TestSubsetMATHVariationDevice(t *testing.T) {
+	// MathLeading varies with the first delta set of the GDEF item
+	// variation store of Roboto's variable font.
+	src := loadFont(t, "Roboto-Variable.ttf")
+	constants := make([]byte, 8+51*4+2)
+	copy(constants[8:], appendUint16s(nil, 150, uint16(len(constants))))
+	constants = appendUint16s(constants, 0, 0, ot.DeltaFormatVariationIndex)
+	builder := NewFontBuilder()
+	for _, tag := range src.TableTags() {
+		builder.AddTable(tag, mustTableData(t, src, tag))
+	}
+	builder.AddTable(ot.TagMATH, packTable(appendUint16s(nil, 1, 0, 0, 0, 0), []int{4}, [][]byte{constants}))
+	data, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	font, err := ot.ParseFont(data, 0)
+	if err != nil {
+		t.Fatalf("ParseFont: %v", err)
+	}
+	gdef, err := ot.ParseGDEF(mustTableData(t, font, ot.TagGDEF))
+	if err != nil {
+		t.Fatalf("ParseGDEF: %v", err)
+	}
+
+	for _, pin := range []bool{false, true} {
+		input := NewInput()
+		input.AddString("x")
+		if pin {
+			input.PinAxisLocation(ot.TagAxisWeight, 900)
+		}
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset: %v", err)
+		}
+		math := mustTableData(t, subFont, ot.TagMATH)
+		record := math[binary.BigEndian.Uint16(math[4:])+8:]
+		leading, dev := int16(binary.BigEndian.Uint16(record)), binary.BigEndian.Uint16(record[2:])
+
+		if pin {
+			want := 150 + int16(gdef.VarStore().GetDelta(0, plan.normalizedCoords))
+			if leading != want || dev != 0 {
+				t.Errorf("instanced: MathLeading %d with device %d, want %d without", leading, dev, want)
+			}
+			continue
+		}
+		subGDEF, err := ot.ParseGDEF(mustTableData(t, subFont, ot.TagGDEF))
+		if err != nil || subGDEF.VarStore() == nil {
+			t.Fatalf("subset GDEF without item variation store (%v)", err)
+		}
+		if leading != 150 || dev == 0 {
+			t.Fatalf("MathLeading %d with device %d, want 150 with a device", leading, dev)
+		}
+		device := math[int(binary.BigEndian.Uint16(math[4:]))+int(dev):]
+		if binary.BigEndian.Uint16(device[4:]) != ot.DeltaFormatVariationIndex {
+			t.Fatalf("device format %#x, want VariationIndex", binary.BigEndian.Uint16(device[4:]))
+		}
+		varIdx := binary.BigEndian.Uint32(device)
+		for _, coords := range [][]int{{0x4000, 0}, {-0x4000, 0}, {0x2000, -0x4000}} {
+			want := gdef.VarStore().GetDelta(0, coords)
+			if got := subGDEF.VarStore().GetDelta(varIdx, coords); got != want {
+				t.Errorf("coords %v: delta %v, want %v", coords, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package subset
+
+import (
+	"math"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// glyphStats holds the font-wide values of head, hhea, maxp and OS/2
+// derived from the output glyphs.
+type glyphStats struct {
+	bbox                ot.GlyphBBox
+	advanceWidthMax     uint16
+	minLeftSideBearing  int16
+	minRightSideBearing int16
+	xMaxExtent          int16
+	hasOutlines         bool
+
+	// Extrema of the vertical metrics (only with vmtx)
+	advanceHeightMax     uint16
+	minTopSideBearing    int16
+	minBottomSideBearing int16
+	yMaxExtent           int16
+
+	// avgCharWidth is the average of the non-zero advance widths.
+	avgCharWidth int16
+
+	// Limits of the TrueType outlines (maxp version 1.0)
+	maxPoints            uint16
+	maxContours          uint16
+	maxCompositePoints   uint16
+	maxCompositeContours uint16
+	maxComponentElements uint16
+	maxComponentDepth    uint16
+}
+
+// glyphStats aggregates bounding boxes, metrics and outline limits over
+// the output glyphs. Instanced glyphs contribute their instanced outlines
+// and metrics; glyphs without outlines only contribute advances.
+// HarfBuzz equivalent: glyf::_populate_subset_glyphs (head_maxp_info),
+// hmtxvmtx::subset_update_header and OS2::calc_avg_char_width
+func (p *Plan) glyphStats() *glyphStats {
+	if p.stats != nil {
+		return p.stats
+	}
+	s := &glyphStats{}
+
+	// CFF bounding boxes come from the charstrings
+	var face *ot.Face
+	if p.glyf == nil && p.cff != nil {
+		face, _ = ot.NewFace(p.source)
+	}
+
+	var totalWidth, numWidths int
+	for oldGID := range p.glyphMap {
+		advance := p.GetInstancedAdvance(oldGID)
+		if advance > s.advanceWidthMax {
+			s.advanceWidthMax = advance
+		}
+		if advance != 0 {
+			totalWidth += int(advance)
+			numWidths++
+		}
+
+		var advanceHeight uint16
+		var tsb int16
+		if p.vmtx != nil {
+			advanceHeight, tsb = p.verticalMetrics(oldGID)
+			s.advanceHeightMax = max(s.advanceHeightMax, advanceHeight)
+		}
+
+		if p.glyf != nil {
+			s.addGlyfLimits(p.glyf, oldGID)
+		}
+
+		var lsb int16
+		if p.hmtx != nil {
+			lsb = p.hmtx.GetLsb(oldGID)
+		}
+		var b ot.GlyphBBox
+		hasBBox := false
+		if ig, ok := p.instancedGlyphs[oldGID]; ok {
+			b, hasBBox, lsb = ig.bbox, !ig.empty, ig.lsb
+		} else if p.glyf != nil {
+			if data := p.glyf.GetGlyphBytes(oldGID); len(data) >= 10 {
+				b, hasBBox = glyphHeaderBBox(data), true
+			}
+		} else if face != nil {
+			b, hasBBox = face.GlyphExtents(oldGID)
+		}
+		if !hasBBox {
+			continue
+		}
+
+		rsb := int16(int(advance) - int(lsb) - (int(b.XMax) - int(b.XMin)))
+		extent := lsb + (b.XMax - b.XMin)
+		bsb := int16(int(advanceHeight) - int(tsb) - (int(b.YMax) - int(b.YMin)))
+		vExtent := tsb + (b.YMax - b.YMin)
+		if !s.hasOutlines {
+			s.bbox = b
+			s.minLeftSideBearing, s.minRightSideBearing, s.xMaxExtent = lsb, rsb, extent
+			s.minTopSideBearing, s.minBottomSideBearing, s.yMaxExtent = tsb, bsb, vExtent
+			s.hasOutlines = true
+			continue
+		}
+		s.bbox.XMin = min16(s.bbox.XMin, b.XMin)
+		s.bbox.YMin = min16(s.bbox.YMin, b.YMin)
+		s.bbox.XMax = max16(s.bbox.XMax, b.XMax)
+		s.bbox.YMax = max16(s.bbox.YMax, b.YMax)
+		s.minLeftSideBearing = min16(s.minLeftSideBearing, lsb)
+		s.minRightSideBearing = min16(s.minRightSideBearing, rsb)
+		s.xMaxExtent = max16(s.xMaxExtent, extent)
+		s.minTopSideBearing = min16(s.minTopSideBearing, tsb)
+		s.minBottomSideBearing = min16(s.minBottomSideBearing, bsb)
+		s.yMaxExtent = max16(s.yMaxExtent, vExtent)
+	}
+	if numWidths > 0 {
+		s.avgCharWidth = int16(math.Round(float64(totalWidth) / float64(numWidths)))
+	}
+
+	p.stats = s
+	return s
+}
+
+// addGlyfLimits updates the maxp limits with a glyph of the glyf table.
+// Instancing moves points but does not add any, so the source outlines
+// give the limits.
+func (s *glyphStats) addGlyfLimits(glyf *ot.Glyf, gid ot.GlyphID) {
+	glyph := glyf.GetGlyph(gid)
+	if glyph == nil || glyph.Data == nil {
+		return
+	}
+	points, contours, depth := glyfOutlineCounts(glyf, gid, 0)
+	if glyph.NumberOfContours >= 0 {
+		s.maxPoints = max(s.maxPoints, uint16(points))
+		s.maxContours = max(s.maxContours, uint16(contours))
+		return
+	}
+	s.maxCompositePoints = max(s.maxCompositePoints, uint16(points))
+	s.maxCompositeContours = max(s.maxCompositeContours, uint16(contours))
+	s.maxComponentElements = max(s.maxComponentElements, uint16(len(ot.ParseCompositeGlyph(glyph.Data))))
+	s.maxComponentDepth = max(s.maxComponentDepth, uint16(depth))
+}
+
+// glyfOutlineCounts returns the number of points and contours of a glyph
+// with its components flattened, and the nesting depth of its components
+// (1 for a composite of simple glyphs).
+func glyfOutlineCounts(glyf *ot.Glyf, gid ot.GlyphID, depth int) (points, contours, nesting int) {
+	if depth > maxInstanceDepth {
+		return 0, 0, 0
+	}
+	glyph := glyf.GetGlyph(gid)
+	if glyph == nil || glyph.Data == nil {
+		return 0, 0, 0
+	}
+	if glyph.NumberOfContours >= 0 {
+		return glyf.GetContourPointCount(gid), int(glyph.NumberOfContours), 0
+	}
+	for _, c := range ot.ParseCompositeGlyph(glyph.Data) {
+		pts, cnt, n := glyfOutlineCounts(glyf, c.GlyphID, depth+1)
+		points += pts
+		contours += cnt
+		nesting = max(nesting, n+1)
+	}
+	return points, contours, nesting
+}
@@ -0,0 +1,196 @@
+package subset
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestSubsetHeaderStats(t *testing.T) {
+	font := loadFont(t, "Roboto-Regular.ttf")
+	for _, flags := range []Flags{0, FlagRetainGIDs} {
+		input := NewInput()
+		input.AddString("Hello Äß")
+		input.Flags = flags
+		_, _, subFont := subsetAndParse(t, font, input)
+
+		head, err := ot.ParseHead(mustTableData(t, subFont, ot.TagHead))
+		if err != nil {
+			t.Fatalf("ParseHead: %v", err)
+		}
+		hhea, err := ot.ParseHhea(mustTableData(t, subFont, ot.TagHhea))
+		if err != nil {
+			t.Fatalf("ParseHhea: %v", err)
+		}
+		maxp := mustTableData(t, subFont, ot.TagMaxp)
+		os2, err := ot.ParseOS2(mustTableData(t, subFont, ot.TagOS2))
+		if err != nil {
+			t.Fatalf("ParseOS2: %v", err)
+		}
+		glyf, err := ot.ParseGlyfFromFont(subFont)
+		if err != nil {
+			t.Fatalf("ParseGlyfFromFont: %v", err)
+		}
+		hmtx, err := ot.ParseHmtxFromFont(subFont)
+		if err != nil {
+			t.Fatalf("ParseHmtxFromFont: %v", err)
+		}
+
+		// The glyf table is small enough for short offsets
+		if head.IndexToLocFormat != 0 {
+			t.Errorf("flags %#x: indexToLocFormat %d, want 0", flags, head.IndexToLocFormat)
+		}
+
+		var want glyphStats
+		var totalWidth, numWidths int
+		for gid := 0; gid < subFont.NumGlyphs(); gid++ {
+			advance, lsb := hmtx.GetMetrics(ot.GlyphID(gid))
+			want.advanceWidthMax = max(want.advanceWidthMax, advance)
+			if advance != 0 {
+				totalWidth += int(advance)
+				numWidths++
+			}
+			want.addGlyfLimits(glyf, ot.GlyphID(gid))
+			data := glyf.GetGlyphBytes(ot.GlyphID(gid))
+			if len(data) < 10 {
+				continue
+			}
+			b := glyphHeaderBBox(data)
+			rsb := int16(int(advance) - int(lsb) - int(b.XMax-b.XMin))
+			if !want.hasOutlines {
+				want.bbox, want.hasOutlines = b, true
+				want.minLeftSideBearing, want.minRightSideBearing, want.xMaxExtent = lsb, rsb, lsb+b.XMax-b.XMin
+			}
+			want.bbox = ot.GlyphBBox{
+				XMin: min16(want.bbox.XMin, b.XMin), YMin: min16(want.bbox.YMin, b.YMin),
+				XMax: max16(want.bbox.XMax, b.XMax), YMax: max16(want.bbox.YMax, b.YMax),
+			}
+			want.minLeftSideBearing = min16(want.minLeftSideBearing, lsb)
+			want.minRightSideBearing = min16(want.minRightSideBearing, rsb)
+			want.xMaxExtent = max16(want.xMaxExtent, lsb+b.XMax-b.XMin)
+		}
+
+		if got := (ot.GlyphBBox{XMin: head.XMin, YMin: head.YMin, XMax: head.XMax, YMax: head.YMax}); got != want.bbox {
+			t.Errorf("flags %#x: head bbox %v, want %v", flags, got, want.bbox)
+		}
+		if hhea.AdvanceWidthMax != want.advanceWidthMax || hhea.MinLeftSideBearing != want.minLeftSideBearing ||
+			hhea.MinRightSideBearing != want.minRightSideBearing || hhea.XMaxExtent != want.xMaxExtent {
+			t.Errorf("flags %#x: hhea %d %d %d %d, want %d %d %d %d", flags,
+				hhea.AdvanceWidthMax, hhea.MinLeftSideBearing, hhea.MinRightSideBearing, hhea.XMaxExtent,
+				want.advanceWidthMax, want.minLeftSideBearing, want.minRightSideBearing, want.xMaxExtent)
+		}
+
+		// Ä is a composite of A and the dieresis
+		if want.maxComponentElements < 2 || want.maxComponentDepth < 1 {
+			t.Errorf("flags %#x: no composite glyph in the subset", flags)
+		}
+		for i, v := range []uint16{want.maxPoints, want.maxContours, want.maxCompositePoints, want.maxCompositeContours} {
+			if got := binary.BigEndian.Uint16(maxp[6+2*i:]); got != v {
+				t.Errorf("flags %#x: maxp field at %d is %d, want %d", flags, 6+2*i, got, v)
+			}
+		}
+		if got := binary.BigEndian.Uint16(maxp[28:]); got != want.maxComponentElements {
+			t.Errorf("flags %#x: maxComponentElements %d, want %d", flags, got, want.maxComponentElements)
+		}
+		if got := binary.BigEndian.Uint16(maxp[30:]); got != want.maxComponentDepth {
+			t.Errorf("flags %#x: maxComponentDepth %d, want %d", flags, got, want.maxComponentDepth)
+		}
+
+		if avg := int16(math.Round(float64(totalWidth) / float64(numWidths))); os2.XAvgCharWidth != avg {
+			t.Errorf("flags %#x: xAvgCharWidth %d, want %d", flags, os2.XAvgCharWidth, avg)
+		}
+		if os2.UsFirstCharIndex != ' ' || os2.UsLastCharIndex != 'ß' {
+			t.Errorf("flags %#x: character indices %#x to %#x, want %#x to %#x",
+				flags, os2.UsFirstCharIndex, os2.UsLastCharIndex, ' ', 'ß')
+		}
+		// Basic Latin and Latin-1 Supplement
+		if os2.UlUnicodeRange1 != 0x3 || os2.UlUnicodeRange2|os2.UlUnicodeRange3|os2.UlUnicodeRange4 != 0 {
+			t.Errorf("flags %#x: ulUnicodeRange %08x %08x %08x %08x", flags,
+				os2.UlUnicodeRange1, os2.UlUnicodeRange2, os2.UlUnicodeRange3, os2.UlUnicodeRange4)
+		}
+	}
+}
+
+func TestSubsetHeaderStatsCFF(t *testing.T) {
+	font := loadFont(t, "SourceSansPro-Regular.otf")
+	input := NewInput()
+	input.AddString("Typography")
+	_, _, subFont := subsetAndParse(t, font, input)
+
+	head, err := ot.ParseHead(mustTableData(t, subFont, ot.TagHead))
+	if err != nil {
+		t.Fatalf("ParseHead: %v", err)
+	}
+	face, err := ot.NewFace(subFont)
+	if err != nil {
+		t.Fatalf("NewFace: %v", err)
+	}
+	var want ot.GlyphBBox
+	first := true
+	for gid := 0; gid < subFont.NumGlyphs(); gid++ {
+		b, ok := face.GlyphExtents(ot.GlyphID(gid))
+		if !ok {
+			continue
+		}
+		if first {
+			want, first = b, false
+		}
+		want = ot.GlyphBBox{
+			XMin: min16(want.XMin, b.XMin), YMin: min16(want.YMin, b.YMin),
+			XMax: max16(want.XMax, b.XMax), YMax: max16(want.YMax, b.YMax),
+		}
+	}
+	if got := (ot.GlyphBBox{XMin: head.XMin, YMin: head.YMin, XMax: head.XMax, YMax: head.YMax}); got != want {
+		t.Errorf("head bbox %v, want %v", got, want)
+	}
+	// 'y' and 'p' descend, 'T' is the tallest
+	src, _ := ot.ParseHead(mustTableData(t, font, ot.TagHead))
+	if head.YMin <= src.YMin || head.YMax >= src.YMax {
+		t.Errorf("head bbox %v not smaller than the source bbox", want)
+	}
+}
+
+func TestUnicodeRanges(t *testing.T) {
+	tests := []struct {
+		codes []rune
+		want  [4]uint32
+	}{
+		{[]rune{'A'}, [4]uint32{1 << 0}},
+		{[]rune{'é', 'Ж'}, [4]uint32{1<<1 | 1<<9}},
+		{[]rune{0x20AC, 0x2192}, [4]uint32{0, 1<<(33-32) | 1<<(37-32)}},
+		{[]rune{0x4E2D}, [4]uint32{0, 1 << (59 - 32)}},
+		// Mathematical Alphanumeric Symbols and Non-Plane 0
+		{[]rune{0x1D400}, [4]uint32{0, 1 << (57 - 32), 1 << (89 - 64)}},
+		{[]rune{0x0860}, [4]uint32{}},
+	}
+	for _, tt := range tests {
+		if got := unicodeRanges(tt.codes); got != tt.want {
+			t.Errorf("unicodeRanges(%U) = %08x, want %08x", tt.codes, got, tt.want)
+		}
+	}
+}
+
+func TestCodePageRanges(t *testing.T) {
+	var ascii []rune
+	for cp := rune(0x20); cp < 0x7F; cp++ {
+		ascii = append(ascii, cp)
+	}
+	tests := []struct {
+		name  string
+		codes []rune
+		want  [2]uint32
+	}{
+		{"Latin 1", append([]rune{'Þ'}, ascii...), [2]uint32{1 << 0}},
+		{"Cyrillic", []rune{'Б'}, [2]uint32{1 << 2}},
+		{"Latin 2 without ASCII", []rune{'Ľ'}, [2]uint32{1 << 0}},
+		{"Latin 2", append(ascii, 'Ľ', '┤'), [2]uint32{1 << 1, 1 << (58 - 32)}},
+		{"digits", []rune{'0', '1'}, [2]uint32{1 << 0}},
+	}
+	for _, tt := range tests {
+		if got := codePageRanges(tt.codes); got != tt.want {
+			t.Errorf("%s: ulCodePageRange %08x, want %08x", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,755 @@
+package subset
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// name table subsetting and the style names of static instances.
+//
+// HarfBuzz equivalent: OT::name::subset (hb-ot-name-table.hh). The
+// instance names follow fontTools.varLib.instancer.names, with Adobe's
+// variation PostScript name algorithm (Technical Note #5902) as the
+// fallback.
+
+// Name IDs rewritten for static instances.
+const (
+	nameIDFamily          = 1
+	nameIDSubfamily       = 2
+	nameIDUniqueID        = 3
+	nameIDFull            = 4
+	nameIDPostScript      = 6
+	nameIDTypoFamily      = 16
+	nameIDTypoSubfamily   = 17
+	nameIDVariationPrefix = 25
+)
+
+// defaultNameIDs are the name IDs retained when Input.KeepNameID was
+// never called.
+// HarfBuzz equivalent: hb_subset_input_t::hb_subset_input_t (hb-subset-input.cc)
+var defaultNameIDs = map[uint16]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true, 6: true}
+
+// defaultNameLanguage is the language retained when
+// Input.KeepNameLanguage was never called: Windows English (United States).
+const defaultNameLanguage = 0x409
+
+// isUnicodeName reports whether a record is Unicode encoded: the Unicode
+// platform, or Windows symbol, BMP or full repertoire.
+// HarfBuzz equivalent: NameRecord::isUnicode (hb-ot-name-table.hh)
+func isUnicodeName(r ot.NameRecord) bool {
+	switch r.PlatformID {
+	case 0:
+		return true
+	case 3:
+		return r.EncodingID == 0 || r.EncodingID == 1 || r.EncodingID == 10
+	}
+	return false
+}
+
+// subsetName subsets the name table to the Unicode records with the
+// retained name IDs and languages, and the records the output fvar and
+// STAT tables refer to.
+// HarfBuzz equivalent: name::subset (hb-ot-name-table.hh)
+func (p *Plan) subsetName(builder *FontBuilder) {
+	data, err := p.source.TableData(ot.TagName)
+	if err != nil {
+		return
+	}
+	name, err := ot.ParseName(data)
+	if err != nil {
+		return
+	}
+	referenced := referencedNameIDs(builder)
+	var out []ot.NameRecord
+	for _, r := range name.Records() {
+		// Language tags (0x8000 and up) need a format 1 table
+		if !isUnicodeName(r) || r.LanguageID >= 0x8000 {
+			continue
+		}
+		if !p.input.ShouldKeepNameLanguage(r.LanguageID) {
+			continue
+		}
+		if !p.input.ShouldKeepNameID(r.NameID) && !referenced[r.NameID] {
+			continue
+		}
+		out = append(out, r)
+	}
+	builder.AddTable(ot.TagName, buildName(out))
+}
+
+// referencedNameIDs returns the name IDs of the axes and named instances
+// of the output fvar table, of the axes and axis values of the output
+// STAT table, of the palette and palette entry labels of the output CPAL
+// table and of the feature parameters of the output GSUB and GPOS
+// tables.
+// HarfBuzz equivalent: _collect_name_ids (hb-subset-plan.cc)
+func referencedNameIDs(builder *FontBuilder) map[uint16]bool {
+	ids := make(map[uint16]bool)
+	var featureLists []*ot.FeatureList
+	if gsub, err := ot.ParseGSUB(builder.tables[ot.TagGSUB]); err == nil {
+		if fl, err := gsub.ParseFeatureList(); err == nil {
+			featureLists = append(featureLists, fl)
+		}
+	}
+	if gpos, err := ot.ParseGPOS(builder.tables[ot.TagGPOS]); err == nil {
+		if fl, err := gpos.ParseFeatureList(); err == nil {
+			featureLists = append(featureLists, fl)
+		}
+	}
+	for _, fl := range featureLists {
+		for i := 0; i < fl.Count(); i++ {
+			if f, err := fl.GetFeature(i); err == nil && f.Params != nil {
+				featureParamsNameIDs(f.Tag, f.Params, ids)
+			}
+		}
+	}
+	if cpal := builder.tables[ot.TagCPAL]; len(cpal) >= 12 && binary.BigEndian.Uint16(cpal) >= 1 {
+		numColors := int(binary.BigEndian.Uint16(cpal[2:]))
+		numPalettes := int(binary.BigEndian.Uint16(cpal[4:]))
+		tail := 12 + 2*numPalettes
+		for i, n := range []int{numPalettes, numColors} {
+			if tail+12 > len(cpal) {
+				break
+			}
+			off := int(binary.BigEndian.Uint32(cpal[tail+4+4*i:]))
+			for j := 0; off != 0 && j < n && off+2*j+2 <= len(cpal); j++ {
+				if id := binary.BigEndian.Uint16(cpal[off+2*j:]); id != 0xFFFF {
+					ids[id] = true
+				}
+			}
+		}
+	}
+	if fvar, err := ot.ParseFvar(builder.tables[ot.TagFvar]); err == nil {
+		for _, axis := range fvar.AxisInfos() {
+			ids[axis.NameID] = true
+		}
+		for _, inst := range fvar.NamedInstances() {
+			ids[inst.SubfamilyNameID] = true
+			if inst.PostScriptNameID != 0 && inst.PostScriptNameID != 0xFFFF {
+				ids[inst.PostScriptNameID] = true
+			}
+		}
+	}
+
+	data := builder.tables[ot.TagSTAT]
+	if len(data) < 18 {
+		return ids
+	}
+	designAxisSize := int(binary.BigEndian.Uint16(data[4:]))
+	designAxisCount := int(binary.BigEndian.Uint16(data[6:]))
+	designAxesOffset := int(binary.BigEndian.Uint32(data[8:]))
+	axisValueCount := int(binary.BigEndian.Uint16(data[12:]))
+	valuesOffset := int(binary.BigEndian.Uint32(data[14:]))
+	if binary.BigEndian.Uint16(data[2:]) >= 1 && len(data) >= 20 {
+		ids[binary.BigEndian.Uint16(data[18:])] = true
+	}
+	if designAxisSize >= 8 && designAxesOffset+designAxisCount*designAxisSize <= len(data) {
+		for i := 0; i < designAxisCount; i++ {
+			ids[binary.BigEndian.Uint16(data[designAxesOffset+i*designAxisSize+4:])] = true
+		}
+	}
+	if valuesOffset+axisValueCount*2 <= len(data) {
+		for i := 0; i < axisValueCount; i++ {
+			// All axis value formats have the value name ID at offset 6
+			off := valuesOffset + int(binary.BigEndian.Uint16(data[valuesOffset+i*2:]))
+			if off+8 <= len(data) {
+				ids[binary.BigEndian.Uint16(data[off+6:])] = true
+			}
+		}
+	}
+	return ids
+}
+
+// buildName serializes a format 0 name table. Records are sorted as the
+// format requires and identical strings are stored once.
+func buildName(records []ot.NameRecord) []byte {
+	records = append([]ot.NameRecord(nil), records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.PlatformID != b.PlatformID {
+			return a.PlatformID < b.PlatformID
+		}
+		if a.EncodingID != b.EncodingID {
+			return a.EncodingID < b.EncodingID
+		}
+		if a.LanguageID != b.LanguageID {
+			return a.LanguageID < b.LanguageID
+		}
+		return a.NameID < b.NameID
+	})
+
+	storageOffset := 6 + len(records)*12
+	out := appendUint16s(nil, 0, uint16(len(records)), uint16(storageOffset))
+	var storage []byte
+	offsets := make(map[string]int)
+	for _, r := range records {
+		off, ok := offsets[string(r.Value)]
+		if !ok {
+			off = len(storage)
+			offsets[string(r.Value)] = off
+			storage = append(storage, r.Value...)
+		}
+		out = appendUint16s(out, r.PlatformID, r.EncodingID, r.LanguageID, r.NameID,
+			uint16(len(r.Value)), uint16(off))
+	}
+	return append(out, storage...)
+}
+
+// encodeName encodes s for the platform of a name record. Mac Roman
+// records are limited to ASCII.
+func encodeName(platformID uint16, s string) []byte {
+	if platformID == 1 {
+		out := make([]byte, 0, len(s))
+		for _, r := range s {
+			if r >= 0x80 {
+				r = '?'
+			}
+			out = append(out, byte(r))
+		}
+		return out
+	}
+	var out []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		out = binary.BigEndian.AppendUint16(out, u)
+	}
+	return out
+}
+
+// isEnglishName reports whether a record is one of the English names
+// that are rewritten: Unicode, Windows English (US) or Mac Roman English.
+func isEnglishName(r ot.NameRecord) bool {
+	switch r.PlatformID {
+	case 0:
+		return true
+	case 1:
+		return r.EncodingID == 0 && r.LanguageID == 0
+	case 3:
+		return r.LanguageID == 0x409
+	}
+	return false
+}
+
+// englishName returns the English string of a name ID, preferring the
+// Windows platform.
+func englishName(records []ot.NameRecord, nameID uint16) string {
+	var fallback string
+	for _, r := range records {
+		if r.NameID != nameID || !isEnglishName(r) {
+			continue
+		}
+		if r.PlatformID == 3 {
+			return r.String()
+		}
+		if fallback == "" {
+			fallback = r.String()
+		}
+	}
+	return fallback
+}
+
+// instanceStyle holds the names of a static instance.
+type instanceStyle struct {
+	family string   // typographic family name
+	styles []string // style names, e.g. "Condensed", "Bold"
+	psName string   // PostScript name
+}
+
+// ribbi splits the style names into the legacy RIBBI style (Regular,
+// Italic, Bold, Bold Italic) and the remaining names, which become part
+// of the legacy family name.
+func (s *instanceStyle) ribbi() (ribbi, other string, bold, italic bool) {
+	var others []string
+	for _, name := range s.styles {
+		for _, word := range strings.Fields(name) {
+			switch word {
+			case "Regular":
+			case "Bold":
+				bold = true
+			case "Italic":
+				italic = true
+			default:
+				others = append(others, word)
+			}
+		}
+	}
+	switch {
+	case bold && italic:
+		ribbi = "Bold Italic"
+	case bold:
+		ribbi = "Bold"
+	case italic:
+		ribbi = "Italic"
+	default:
+		ribbi = "Regular"
+	}
+	return ribbi, strings.Join(others, " "), bold, italic
+}
+
+// typoSubfamily returns the full style name.
+func (s *instanceStyle) typoSubfamily() string {
+	if len(s.styles) == 0 {
+		return "Regular"
+	}
+	return strings.Join(s.styles, " ")
+}
+
+// instanceLocation returns the user coordinates of the instance per
+// fvar axis.
+func (p *Plan) instanceLocation() []float32 {
+	axes := p.fvar.AxisInfos()
+	loc := make([]float32, len(axes))
+	for i, axis := range axes {
+		loc[i] = axis.DefaultValue
+		if v, ok := p.input.pinnedAxes[axis.Tag]; ok {
+			loc[i] = clampFloat32(v, axis.MinValue, axis.MaxValue)
+		}
+	}
+	return loc
+}
+
+// instanceStyle returns the names of the static instance, taken from a
+// named instance at the pinned location or from STAT. It returns nil if
+// neither describes the location; only the PostScript name is derived
+// then.
+func (p *Plan) instanceStyle(records []ot.NameRecord) *instanceStyle {
+	family := englishName(records, nameIDTypoFamily)
+	if family == "" {
+		family = englishName(records, nameIDFamily)
+	}
+	loc := p.instanceLocation()
+
+	for _, inst := range p.fvar.NamedInstances() {
+		match := len(inst.Coords) == len(loc)
+		for i := range loc {
+			if match && inst.Coords[i] != loc[i] {
+				match = false
+			}
+		}
+		if !match {
+			continue
+		}
+		style := englishName(records, inst.SubfamilyNameID)
+		if style == "" {
+			break
+		}
+		s := &instanceStyle{family: family, styles: []string{style}}
+		if inst.PostScriptNameID != 0 && inst.PostScriptNameID != 0xFFFF {
+			s.psName = englishName(records, inst.PostScriptNameID)
+		}
+		if s.psName == "" {
+			s.psName = p.psNamePrefix(records) + "-" + psNameChars(s.typoSubfamily())
+		}
+		return s
+	}
+
+	if styles := p.statStyleNames(records, loc); styles != nil {
+		s := &instanceStyle{family: family, styles: styles}
+		s.psName = p.psNamePrefix(records) + "-" + psNameChars(s.typoSubfamily())
+		return s
+	}
+	return nil
+}
+
+// psNamePrefix returns the family part of PostScript names: the
+// variations PostScript name prefix, or the family name without
+// characters that are not allowed.
+func (p *Plan) psNamePrefix(records []ot.NameRecord) string {
+	if prefix := englishName(records, nameIDVariationPrefix); prefix != "" {
+		return psNameChars(prefix)
+	}
+	family := englishName(records, nameIDTypoFamily)
+	if family == "" {
+		family = englishName(records, nameIDFamily)
+	}
+	return psNameChars(family)
+}
+
+// psNameChars removes all characters but ASCII letters and digits.
+func psNameChars(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 0x80 && (r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// variationPSName builds the PostScript name of an instance from its
+// coordinates, for axes away from their default.
+// Adobe Technical Note #5902, "Generating PostScript Names for Fonts
+// Using OpenType Font Variations".
+func (p *Plan) variationPSName(records []ot.NameRecord) string {
+	prefix := p.psNamePrefix(records)
+	name := prefix
+	loc := p.instanceLocation()
+	for i, axis := range p.fvar.AxisInfos() {
+		if loc[i] == axis.DefaultValue {
+			continue
+		}
+		value := strconv.FormatFloat(float64(loc[i]), 'f', -1, 32)
+		name += "_" + value + strings.TrimRight(axis.Tag.String(), " ")
+	}
+	// Last resort name: the prefix with a hash of the full name.
+	if len(name) > 63 {
+		hash := fmt.Sprintf("%X", sha1.Sum([]byte(name)))
+		name = prefix + "-" + hash
+		if len(name) > 60 {
+			name = name[:60]
+		}
+		name += "..."
+	}
+	return name
+}
+
+// statAxisValue is an axis value table of STAT.
+type statAxisValue struct {
+	flags  uint16
+	nameID uint16
+	axes   []statAxisCoord
+}
+
+// statAxisCoord is the location of an axis value on one design axis.
+// Ranges (format 2) have min < max.
+type statAxisCoord struct {
+	axis            int
+	value, min, max int32 // 16.16
+}
+
+// STAT axis value flags.
+const (
+	statElidable = 0x0002
+)
+
+// statStyleNames returns the style names STAT gives the location, in
+// design axis order, or nil if STAT is missing or a fvar axis has no
+// matching axis value.
+// fontTools: instancer.names.getVariationNameIDs / _sortAxisValues
+func (p *Plan) statStyleNames(records []ot.NameRecord, loc []float32) []string {
+	data, err := p.source.TableData(ot.TagSTAT)
+	if err != nil || len(data) < 18 {
+		return nil
+	}
+	designAxisSize := int(binary.BigEndian.Uint16(data[4:]))
+	designAxisCount := int(binary.BigEndian.Uint16(data[6:]))
+	designAxesOffset := int(binary.BigEndian.Uint32(data[8:]))
+	axisValueCount := int(binary.BigEndian.Uint16(data[12:]))
+	valuesOffset := int(binary.BigEndian.Uint32(data[14:]))
+	elidedNameID := uint16(nameIDSubfamily)
+	if binary.BigEndian.Uint16(data[2:]) >= 1 && len(data) >= 20 {
+		elidedNameID = binary.BigEndian.Uint16(data[18:])
+	}
+	if designAxisSize < 8 || designAxesOffset+designAxisCount*designAxisSize > len(data) ||
+		valuesOffset+axisValueCount*2 > len(data) {
+		return nil
+	}
+
+	// Location and ordering per design axis; -1 for axes not in fvar.
+	fvarAxes := p.fvar.AxisInfos()
+	designLoc := make([]int32, designAxisCount)
+	inFvar := make([]bool, designAxisCount)
+	ordering := make([]int, designAxisCount)
+	for i := range designLoc {
+		rec := data[designAxesOffset+i*designAxisSize:]
+		tag := ot.Tag(binary.BigEndian.Uint32(rec))
+		ordering[i] = int(binary.BigEndian.Uint16(rec[6:]))
+		for j, axis := range fvarAxes {
+			if axis.Tag == tag {
+				designLoc[i] = int32(floatToFixed(loc[j]))
+				inFvar[i] = true
+			}
+		}
+	}
+
+	var values []statAxisValue
+	for i := 0; i < axisValueCount; i++ {
+		off := valuesOffset + int(binary.BigEndian.Uint16(data[valuesOffset+i*2:]))
+		if off+12 > len(data) {
+			continue
+		}
+		v := data[off:]
+		av := statAxisValue{flags: binary.BigEndian.Uint16(v[4:]), nameID: binary.BigEndian.Uint16(v[6:])}
+		fixed := func(b []byte) int32 { return int32(binary.BigEndian.Uint32(b)) }
+		switch binary.BigEndian.Uint16(v) {
+		case 1, 3:
+			x := fixed(v[8:])
+			av.axes = []statAxisCoord{{int(binary.BigEndian.Uint16(v[2:])), x, x, x}}
+		case 2:
+			if off+20 > len(data) {
+				continue
+			}
+			av.axes = []statAxisCoord{{int(binary.BigEndian.Uint16(v[2:])), fixed(v[8:]), fixed(v[12:]), fixed(v[16:])}}
+			av.nameID = binary.BigEndian.Uint16(v[6:])
+		case 4:
+			count := int(binary.BigEndian.Uint16(v[2:]))
+			av.flags, av.nameID = binary.BigEndian.Uint16(v[4:]), binary.BigEndian.Uint16(v[6:])
+			if off+8+count*6 > len(data) {
+				continue
+			}
+			for k := 0; k < count; k++ {
+				rec := v[8+k*6:]
+				x := fixed(rec[2:])
+				av.axes = append(av.axes, statAxisCoord{int(binary.BigEndian.Uint16(rec)), x, x, x})
+			}
+		default:
+			continue
+		}
+		values = append(values, av)
+	}
+
+	matches := func(c statAxisCoord) bool {
+		if c.axis >= designAxisCount {
+			return false
+		}
+		if !inFvar[c.axis] {
+			return true // first value of an axis not in fvar
+		}
+		return c.min <= designLoc[c.axis] && designLoc[c.axis] <= c.max
+	}
+
+	// Format 4 values first, then one value per remaining design axis.
+	var chosen []statAxisValue
+	covered := make([]bool, designAxisCount)
+	for _, v := range values {
+		if len(v.axes) < 2 {
+			continue
+		}
+		ok := true
+		for _, c := range v.axes {
+			ok = ok && matches(c) && !covered[c.axis]
+		}
+		if ok {
+			chosen = append(chosen, v)
+			for _, c := range v.axes {
+				covered[c.axis] = true
+			}
+		}
+	}
+	for _, v := range values {
+		if len(v.axes) == 1 && matches(v.axes[0]) && !covered[v.axes[0].axis] {
+			chosen = append(chosen, v)
+			covered[v.axes[0].axis] = true
+		}
+	}
+	for i := range covered {
+		if inFvar[i] && !covered[i] {
+			return nil
+		}
+	}
+	sort.SliceStable(chosen, func(i, j int) bool {
+		return ordering[chosen[i].axes[0].axis] < ordering[chosen[j].axes[0].axis]
+	})
+
+	styles := []string{}
+	for _, v := range chosen {
+		if v.flags&statElidable == 0 {
+			if name := englishName(records, v.nameID); name != "" {
+				styles = append(styles, name)
+			}
+		}
+	}
+	if len(styles) == 0 {
+		if name := englishName(records, elidedNameID); name != "" {
+			styles = append(styles, name)
+		}
+	}
+	return styles
+}
+
+// instanceName rewrites the family, style, full and PostScript names of
+// the English name records of data for a static instance. The names are
+// derived from the source records, which may include records the subset
+// dropped.
+func (p *Plan) instanceName(data []byte, source []ot.NameRecord, style *instanceStyle) ([]byte, error) {
+	name, err := ot.ParseName(data)
+	if err != nil {
+		return nil, err
+	}
+	records := source
+
+	names := make(map[uint16]string)
+	if style != nil {
+		ribbi, other, _, _ := style.ribbi()
+		typoSub := style.typoSubfamily()
+		names[nameIDFamily] = style.family
+		names[nameIDSubfamily] = ribbi
+		if other != "" {
+			names[nameIDFamily] = style.family + " " + other
+			names[nameIDTypoFamily] = style.family
+			names[nameIDTypoSubfamily] = typoSub
+		}
+		names[nameIDFull] = style.family + " " + typoSub
+		names[nameIDPostScript] = style.psName
+	} else {
+		names[nameIDPostScript] = p.variationPSName(records)
+	}
+	if old := englishName(records, nameIDPostScript); old != "" {
+		if uid := englishName(records, nameIDUniqueID); strings.Contains(uid, old) {
+			names[nameIDUniqueID] = strings.ReplaceAll(uid, old, names[nameIDPostScript])
+		}
+	}
+
+	var out []ot.NameRecord
+	written := make(map[uint16]bool)
+	for _, r := range name.Records() {
+		if isEnglishName(r) {
+			if r.NameID == nameIDTypoFamily || r.NameID == nameIDTypoSubfamily {
+				if _, ok := names[r.NameID]; !ok {
+					continue // RIBBI style: legacy names suffice
+				}
+			}
+			if s, ok := names[r.NameID]; ok {
+				r.Value = encodeName(r.PlatformID, s)
+				if r.PlatformID == 3 {
+					written[r.NameID] = true
+				}
+			}
+		}
+		out = append(out, r)
+	}
+	for _, id := range []uint16{nameIDTypoFamily, nameIDTypoSubfamily} {
+		if s, ok := names[id]; ok && !written[id] {
+			out = append(out, ot.NameRecord{PlatformID: 3, EncodingID: 1, LanguageID: 0x409, NameID: id, Value: encodeName(3, s)})
+		}
+	}
+	return buildName(out), nil
+}
+
+// widthClass returns the OS/2 usWidthClass for a wdth axis value.
+// HarfBuzz equivalent: OS2::calc_width_class (hb-ot-os2-table.hh)
+func widthClass(width float32) uint16 {
+	switch {
+	case width < 56.25:
+		return 1
+	case width < 68.75:
+		return 2
+	case width < 81.25:
+		return 3
+	case width < 93.75:
+		return 4
+	case width < 106.25:
+		return 5
+	case width < 118.75:
+		return 6
+	case width < 137.5:
+		return 7
+	case width < 175:
+		return 8
+	}
+	return 9
+}
+
+// applyInstanceStyle updates OS/2, head and name of a static instance:
+// weight and width classes from the pinned coordinates, the RIBBI style
+// bits and the names.
+// HarfBuzz equivalent: OS2::subset with pinned axes (hb-ot-os2-table.hh)
+func (p *Plan) applyInstanceStyle(builder *FontBuilder) error {
+	if p.fvar == nil {
+		return nil
+	}
+	var records []ot.NameRecord
+	if data, err := p.source.TableData(ot.TagName); err == nil {
+		if name, err := ot.ParseName(data); err == nil {
+			records = name.Records()
+		}
+	}
+	style := p.instanceStyle(records)
+
+	if os2, ok := builder.tables[ot.TagOS2]; ok && len(os2) >= 64 {
+		os2 = append([]byte(nil), os2...)
+		if v, ok := p.input.pinnedAxes[ot.TagAxisWeight]; ok {
+			weight := math.Round(float64(clampFloat32(v, 1, 1000)))
+			binary.BigEndian.PutUint16(os2[4:], uint16(weight))
+		}
+		if v, ok := p.input.pinnedAxes[ot.TagAxisWidth]; ok {
+			binary.BigEndian.PutUint16(os2[6:], widthClass(v))
+		}
+		if style != nil {
+			_, _, bold, italic := style.ribbi()
+			// ITALIC (0), BOLD (5) and REGULAR (6)
+			sel := binary.BigEndian.Uint16(os2[62:]) &^ (1<<0 | 1<<5 | 1<<6)
+			switch {
+			case bold && italic:
+				sel |= 1<<5 | 1<<0
+			case bold:
+				sel |= 1 << 5
+			case italic:
+				sel |= 1 << 0
+			default:
+				sel |= 1 << 6
+			}
+			binary.BigEndian.PutUint16(os2[62:], sel)
+		}
+		builder.tables[ot.TagOS2] = os2
+	}
+
+	if head, ok := builder.tables[ot.TagHead]; ok && len(head) >= 46 && style != nil {
+		head = append([]byte(nil), head...)
+		_, _, bold, italic := style.ribbi()
+		macStyle := binary.BigEndian.Uint16(head[44:]) &^ 0x3
+		if bold {
+			macStyle |= 1
+		}
+		if italic {
+			macStyle |= 2
+		}
+		binary.BigEndian.PutUint16(head[44:], macStyle)
+		builder.tables[ot.TagHead] = head
+	}
+
+	if nameData, ok := builder.tables[ot.TagName]; ok {
+		data, err := p.instanceName(nameData, records, style)
+		if err != nil {
+			return err
+		}
+		builder.tables[ot.TagName] = data
+	}
+	return nil
+}
+
+// featureParamsNameIDs adds the name IDs of a FeatureParams table: the
+// subfamily name of 'size', the UI name of 'ssXX' and the label,
+// tooltip, sample text and parameter labels of 'cvXX'. Name ID 0 means
+// none.
+// HarfBuzz equivalent: FeatureParams::collect_name_ids
+// (hb-ot-layout-common.hh)
+func featureParamsNameIDs(tag ot.Tag, params []byte, ids map[uint16]bool) {
+	add := func(id uint16) {
+		if id != 0 && id != 0xFFFF {
+			ids[id] = true
+		}
+	}
+	switch {
+	case tag == ot.MakeTag('s', 'i', 'z', 'e'):
+		// designSize, subfamilyID, subfamilyNameID, rangeStart, rangeEnd
+		if len(params) >= 10 && binary.BigEndian.Uint16(params[2:]) != 0 {
+			add(binary.BigEndian.Uint16(params[4:]))
+		}
+	case byte(tag>>24) == 's' && byte(tag>>16) == 's':
+		// version, uiNameID
+		if len(params) >= 4 {
+			add(binary.BigEndian.Uint16(params[2:]))
+		}
+	case byte(tag>>24) == 'c' && byte(tag>>16) == 'v':
+		// format, featUiLabelNameID, featUiTooltipTextNameID,
+		// sampleTextNameID, numNamedParameters, firstParamUiLabelNameID
+		if len(params) < 12 {
+			return
+		}
+		for _, at := range []int{2, 4, 6} {
+			add(binary.BigEndian.Uint16(params[at:]))
+		}
+		first := binary.BigEndian.Uint16(params[10:])
+		for i := uint16(0); first != 0 && i < binary.BigEndian.Uint16(params[8:]); i++ {
+			add(first + i)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package subset
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// nameRecords returns the records of the name table of font.
+func nameRecords(t *testing.T, font *ot.Font) []ot.NameRecord {
+	t.Helper()
+	name, err := ot.ParseName(mustTableData(t, font, ot.TagName))
+	if err != nil {
+		t.Fatalf("ParseName: %v", err)
+	}
+	return name.Records()
+}
+
+// windowsNames returns the Windows English names by name ID.
+func windowsNames(t *testing.T, font *ot.Font) map[uint16]string {
+	t.Helper()
+	names := make(map[uint16]string)
+	for _, r := range nameRecords(t, font) {
+		if r.PlatformID == 3 && r.LanguageID == 0x409 {
+			names[r.NameID] = r.String()
+		}
+	}
+	return names
+}
+
+func TestBuildNameRoundTrip(t *testing.T) {
+	records := []ot.NameRecord{
+		{PlatformID: 3, EncodingID: 1, LanguageID: 0x409, NameID: 2, Value: encodeName(3, "Bold")},
+		{PlatformID: 1, EncodingID: 0, LanguageID: 0, NameID: 1, Value: encodeName(1, "Fämily")},
+		{PlatformID: 3, EncodingID: 1, LanguageID: 0x409, NameID: 1, Value: encodeName(3, "Fämily")},
+		{PlatformID: 3, EncodingID: 1, LanguageID: 0x409, NameID: 4, Value: encodeName(3, "Bold")},
+	}
+	name, err := ot.ParseName(buildName(records))
+	if err != nil {
+		t.Fatalf("ParseName: %v", err)
+	}
+	got := name.Records()
+	want := []string{"F?mily", "Fämily", "Bold", "Bold"}
+	if len(got) != len(want) {
+		t.Fatalf("%d records, want %d", len(got), len(want))
+	}
+	for i, r := range got {
+		if r.String() != want[i] {
+			t.Errorf("record %d: %q, want %q", i, r.String(), want[i])
+		}
+	}
+	if got[0].PlatformID != 1 || got[3].NameID != 4 {
+		t.Errorf("records not sorted")
+	}
+}
+
+func TestInstanceNamesRIBBI(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	input := NewInput()
+	input.AddString("Hx")
+	input.Flags = FlagPassUnrecognized
+	input.PinAxisLocation(ot.TagAxisWeight, 700)
+	_, _, subFont := subsetAndParse(t, font, input)
+
+	names := windowsNames(t, subFont)
+	for id, want := range map[uint16]string{1: "Roboto", 2: "Bold", 4: "Roboto Bold", 6: "RobotoRoman-Bold"} {
+		if names[id] != want {
+			t.Errorf("name %d: %q, want %q", id, names[id], want)
+		}
+	}
+	if _, ok := names[17]; ok {
+		t.Errorf("typographic subfamily kept for a RIBBI style")
+	}
+
+	os2, _ := subFont.TableData(ot.TagOS2)
+	if w := binary.BigEndian.Uint16(os2[4:]); w != 700 {
+		t.Errorf("usWeightClass %d, want 700", w)
+	}
+	if sel := binary.BigEndian.Uint16(os2[62:]); sel&(1<<5) == 0 || sel&(1<<6) != 0 {
+		t.Errorf("fsSelection %#x, want BOLD", sel)
+	}
+	head, _ := subFont.TableData(ot.TagHead)
+	if macStyle := binary.BigEndian.Uint16(head[44:]); macStyle&1 == 0 {
+		t.Errorf("macStyle %#x, want bold", macStyle)
+	}
+}
+
+func TestInstanceNamesNonRIBBI(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	input := NewInput()
+	input.AddString("Hx")
+	input.Flags = FlagPassUnrecognized
+	input.PinAxisLocation(ot.TagAxisWeight, 800)
+	_, _, subFont := subsetAndParse(t, font, input)
+
+	names := windowsNames(t, subFont)
+	want := map[uint16]string{1: "Roboto ExtraBold", 2: "Regular", 4: "Roboto ExtraBold",
+		16: "Roboto", 17: "ExtraBold", 6: "RobotoRoman-ExtraBold"}
+	for id, w := range want {
+		if names[id] != w {
+			t.Errorf("name %d: %q, want %q", id, names[id], w)
+		}
+	}
+	os2, _ := subFont.TableData(ot.TagOS2)
+	if w := binary.BigEndian.Uint16(os2[4:]); w != 800 {
+		t.Errorf("usWeightClass %d, want 800", w)
+	}
+	if sel := binary.BigEndian.Uint16(os2[62:]); sel&(1<<6) == 0 || sel&(1<<5) != 0 {
+		t.Errorf("fsSelection %#x, want REGULAR", sel)
+	}
+}
+
+func TestInstanceMVAR(t *testing.T) {
+	font := loadFont(t, "NotoSans-VF.abc.ttf")
+	srcOS2, _ := font.TableData(ot.TagOS2)
+	xHeight := int16(binary.BigEndian.Uint16(srcOS2[86:]))
+	strikeout := int16(binary.BigEndian.Uint16(srcOS2[28:]))
+
+	fvarData, _ := font.TableData(ot.TagFvar)
+	fvar, _ := ot.ParseFvar(fvarData)
+	axis, _ := fvar.FindAxis(ot.TagAxisWeight)
+
+	input := NewInput()
+	input.AddString("Hx")
+	input.Flags = FlagPassUnrecognized
+	input.PinAxisLocation(ot.TagAxisWeight, axis.MaxValue)
+	_, _, subFont := subsetAndParse(t, font, input)
+	os2, _ := subFont.TableData(ot.TagOS2)
+	if got := int16(binary.BigEndian.Uint16(os2[86:])); got != xHeight+17 {
+		t.Errorf("sxHeight %d, want %d", got, xHeight+17)
+	}
+	if got := int16(binary.BigEndian.Uint16(os2[28:])); got != strikeout+10 {
+		t.Errorf("yStrikeoutPosition %d, want %d", got, strikeout+10)
+	}
+}
+
+func TestSubsetNameDefaults(t *testing.T) {
+	font := loadFont(t, "Roboto-Regular.ttf")
+	input := NewInput()
+	input.AddString("A")
+	_, _, subFont := subsetAndParse(t, font, input)
+	records := nameRecords(t, subFont)
+
+	ids := make(map[uint16]bool)
+	for _, r := range records {
+		if r.PlatformID != 3 || r.LanguageID != 0x409 || r.NameID > 6 {
+			t.Errorf("record %d/%d/%#x name %d kept", r.PlatformID, r.EncodingID, r.LanguageID, r.NameID)
+		}
+		ids[r.NameID] = true
+	}
+	for id := uint16(1); id <= 6; id++ {
+		if !ids[id] {
+			t.Errorf("name %d dropped", id)
+		}
+	}
+}
+
+func TestSubsetNameKeepIDs(t *testing.T) {
+	font := loadFont(t, "Roboto-Regular.ttf")
+	input := NewInput()
+	input.KeepNameID(1)
+	input.KeepNameID(4)
+	input.AddString("A")
+	_, _, subFont := subsetAndParse(t, font, input)
+	records := nameRecords(t, subFont)
+	if len(records) != 2 || records[0].NameID != 1 || records[1].NameID != 4 {
+		t.Errorf("records %v, want names 1 and 4", records)
+	}
+
+	// Mac Roman records are not Unicode encoded
+	input = NewInput()
+	input.KeepNameLanguage(0)
+	input.AddString("A")
+	_, _, subFont = subsetAndParse(t, font, input)
+	if records := nameRecords(t, subFont); len(records) != 0 {
+		t.Errorf("%d records with language 0, want none", len(records))
+	}
+}
+
+func TestSubsetNameReferencedIDs(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	fvar, err := ot.ParseFvar(mustTableData(t, font, ot.TagFvar))
+	if err != nil {
+		t.Fatalf("ParseFvar: %v", err)
+	}
+	input := NewInput()
+	input.PassThroughTable(ot.TagFvar)
+	input.AddString("A")
+	_, _, subFont := subsetAndParse(t, font, input)
+	ids := make(map[uint16]bool)
+	for _, r := range nameRecords(t, subFont) {
+		ids[r.NameID] = true
+	}
+	for _, axis := range fvar.AxisInfos() {
+		if !ids[axis.NameID] {
+			t.Errorf("axis %s: name %d dropped", axis.Tag, axis.NameID)
+		}
+	}
+	for _, inst := range fvar.NamedInstances() {
+		if !ids[inst.SubfamilyNameID] {
+			t.Errorf("instance name %d dropped", inst.SubfamilyNameID)
+		}
+	}
+}
+
+func TestReferencedNameIDsFeatureParams(t *testing.T) {
+	ss01 := appendUint16s(nil, 0, 256)
+	// label 257, tooltip 258, no sample text, parameter labels 259-260,
+	// no characters
+	cv01 := appendUint16s(nil, 0, 257, 258, 0, 2, 259, 0)
+	gsub, err := buildLayoutTable(nil, []featureRecord{
+		{tag: ot.MakeTag('c', 'v', '0', '1'), params: cv01},
+		{tag: ot.MakeTag('s', 's', '0', '1'), params: ss01},
+	}, nil, nil, 7)
+	if err != nil {
+		t.Fatalf("buildLayoutTable: %v", err)
+	}
+	builder := NewFontBuilder()
+	builder.AddTable(ot.TagGSUB, gsub)
+	ids := referencedNameIDs(builder)
+	for _, id := range []uint16{256, 257, 258, 259, 260} {
+		if !ids[id] {
+			t.Errorf("name %d not referenced", id)
+		}
+	}
+	if ids[0] || len(ids) != 5 {
+		t.Errorf("referenced %v, want 256-260", ids)
+	}
+}
@@ -30,23 +30,64 @@ type Plan struct {
 	gsub *ot.GSUB
 	gpos *ot.GPOS
 	hmtx *ot.Hmtx
+	vmtx *ot.Vmtx
 	glyf *ot.Glyf
 	cff  *ot.CFF
+	math *ot.Math
+
+	// parseErrs holds the errors of the parsed tables that failed to
+	// parse; Execute fails on them as on a table that fails to subset.
+	parseErrs map[ot.Tag]error
+
+	// Color glyphs
+	colr *colrTable
+
+	// colrGlyphs is the glyph set after the COLR closure; only these keep
+	// their color records. colrPalettes and colrLayers map the retained
+	// CPAL entries and COLRv1 layers to their new indices.
+	colrGlyphs   map[ot.GlyphID]bool
+	colrPalettes map[uint16]uint16
+	colrLayers   map[uint32]uint32
+
+	// Retained scripts, features and lookups of GSUB and GPOS
+	gsubLayout *layoutPlan
+	gposLayout *layoutPlan
 
 	// Variation tables (for instancing)
 	fvar *ot.Fvar
 	avar *ot.Avar
 	hvar *ot.Hvar
+	vvar *ot.Hvar // same layout as HVAR for the advances
 	gvar *ot.Gvar
 
 	// Instanced advance widths (computed when axes are pinned)
 	instancedAdvances map[ot.GlyphID]uint16
 
+	// Instanced advance heights (computed when axes are pinned and the
+	// font has vertical metrics)
+	instancedVAdvances map[ot.GlyphID]uint16
+
 	// Normalized coordinates for instancing (F2DOT14 format)
 	normalizedCoords []int
 
+	// Instanced glyf outlines and metrics (computed when axes are pinned)
+	instancedGlyphs map[ot.GlyphID]*instancedGlyph
+
+	// Axis limits when axis ranges are restricted (partial instancing)
+	partial *partialInstance
+
+	// layoutVars collects the delta sets of the GDEF item variation store
+	// referenced by the device tables of the subset (see layoutVarStore).
+	layoutVars *varStoreBuilder
+
 	// numOutputGlyphs is the number of glyphs in the output font.
 	numOutputGlyphs int
+
+	// Statistics of the output glyphs (computed on first use)
+	stats *glyphStats
+
+	// report describes the closure and the last Execute
+	report *SubsetReport
 }
 
 // CreatePlan creates a subset plan from a font and input configuration.
@@ -58,6 +99,7 @@ func CreatePlan(font *ot.Font, input *Input) (*Plan, error) {
 		reverseMap: make(map[ot.GlyphID]ot.GlyphID),
 		unicodeMap: make(map[rune]ot.GlyphID),
 		glyphSet:   make(map[ot.GlyphID]bool),
+		report:     &SubsetReport{},
 	}
 
 	// Parse required tables
@@ -65,15 +107,31 @@ func CreatePlan(font *ot.Font, input *Input) (*Plan, error) {
 		return nil, err
 	}
 
+	// Normalized coordinates of the pinned location, or of the new default
+	// location when axis ranges are restricted
+	if input.HasPinnedAxes() || input.HasAxisRanges() {
+		p.computeNormalizedCoords()
+	}
+
+	// Select retained scripts, features and lookups
+	p.planLayout()
+
 	// Compute glyph closure
 	p.computeGlyphClosure()
 
 	// Create glyph mapping
 	p.createGlyphMapping()
 
-	// Compute instanced advances if axes are pinned
-	if input.HasPinnedAxes() {
+	// Compute instanced advances and outlines at the pinned location, or
+	// at the new default location when axis ranges are restricted
+	if input.HasPinnedAxes() || input.HasAxisRanges() {
 		p.computeInstancedAdvances()
+		if p.instancedAdvances != nil {
+			p.instanceGlyphs()
+			if input.HasAxisRanges() {
+				p.planPartialInstance()
+			}
+		}
 	}
 
 	return p, nil
@@ -81,6 +139,9 @@ func CreatePlan(font *ot.Font, input *Input) (*Plan, error) {
 
 // parseTables parses the font tables needed for subsetting.
 func (p *Plan) parseTables() error {
+	var err error
+	p.parseErrs = make(map[ot.Tag]error)
+
 	// Parse cmap (required)
 	if p.source.HasTable(ot.TagCmap) {
 		data, err := p.source.TableData(ot.TagCmap)
@@ -96,19 +157,25 @@ func (p *Plan) parseTables() error {
 	// Parse GDEF (optional)
 	if p.source.HasTable(ot.TagGDEF) {
 		data, _ := p.source.TableData(ot.TagGDEF)
-		p.gdef, _ = ot.ParseGDEF(data)
+		if p.gdef, err = ot.ParseGDEF(data); err != nil {
+			p.parseErrs[ot.TagGDEF] = err
+		}
 	}
 
 	// Parse GSUB (optional)
 	if p.source.HasTable(ot.TagGSUB) {
 		data, _ := p.source.TableData(ot.TagGSUB)
-		p.gsub, _ = ot.ParseGSUB(data)
+		if p.gsub, err = ot.ParseGSUB(data); err != nil {
+			p.parseErrs[ot.TagGSUB] = err
+		}
 	}
 
 	// Parse GPOS (optional)
 	if p.source.HasTable(ot.TagGPOS) {
 		data, _ := p.source.TableData(ot.TagGPOS)
-		p.gpos, _ = ot.ParseGPOS(data)
+		if p.gpos, err = ot.ParseGPOS(data); err != nil {
+			p.parseErrs[ot.TagGPOS] = err
+		}
 	}
 
 	// Parse hmtx (optional)
@@ -116,6 +183,15 @@ func (p *Plan) parseTables() error {
 		p.hmtx, _ = ot.ParseHmtxFromFont(p.source)
 	}
 
+	// Parse vmtx (optional)
+	if p.source.HasTable(ot.TagVmtx) && p.source.HasTable(ot.TagVhea) {
+		vheaData, _ := p.source.TableData(ot.TagVhea)
+		if vhea, err := ot.ParseVhea(vheaData); err == nil {
+			data, _ := p.source.TableData(ot.TagVmtx)
+			p.vmtx, _ = ot.ParseVmtx(data, int(vhea.NumberOfVMetrics), p.source.NumGlyphs())
+		}
+	}
+
 	// Parse glyf/loca (optional, for TrueType fonts)
 	if p.source.HasTable(ot.TagGlyf) && p.source.HasTable(ot.TagLoca) {
 		p.glyf, _ = ot.ParseGlyfFromFont(p.source)
@@ -124,30 +200,91 @@ func (p *Plan) parseTables() error {
 	// Parse CFF (optional, for OpenType/CFF fonts)
 	if p.source.HasTable(ot.TagCFF) {
 		data, _ := p.source.TableData(ot.TagCFF)
-		p.cff, _ = ot.ParseCFF(data)
+		if p.cff, err = ot.ParseCFF(data); err != nil {
+			p.parseErrs[ot.TagCFF] = err
+		}
+	}
+
+	// Parse MATH (for the glyph variant closure)
+	if p.source.HasTable(ot.TagMATH) {
+		data, _ := p.source.TableData(ot.TagMATH)
+		if p.math, err = ot.ParseMath(data); err != nil {
+			p.parseErrs[ot.TagMATH] = err
+		}
+	}
+
+	// Parse COLR (for the color glyph closure)
+	if p.source.HasTable(ot.TagCOLR) {
+		data, _ := p.source.TableData(ot.TagCOLR)
+		if p.colr, err = parseCOLR(data); err != nil {
+			p.parseErrs[ot.TagCOLR] = err
+		}
 	}
 
 	// Parse variation tables (for instancing)
 	if p.source.HasTable(ot.TagFvar) {
 		data, _ := p.source.TableData(ot.TagFvar)
-		p.fvar, _ = ot.ParseFvar(data)
+		if p.fvar, err = ot.ParseFvar(data); err != nil {
+			p.parseErrs[ot.TagFvar] = err
+		}
 	}
 	if p.source.HasTable(ot.TagAvar) {
 		data, _ := p.source.TableData(ot.TagAvar)
-		p.avar, _ = ot.ParseAvar(data)
+		if p.avar, err = ot.ParseAvar(data); err != nil {
+			p.parseErrs[ot.TagAvar] = err
+		}
 	}
 	if p.source.HasTable(ot.TagHvar) {
 		data, _ := p.source.TableData(ot.TagHvar)
-		p.hvar, _ = ot.ParseHvar(data)
+		if p.hvar, err = ot.ParseHvar(data); err != nil {
+			p.parseErrs[ot.TagHvar] = err
+		}
+	}
+	if p.source.HasTable(ot.TagVvar) {
+		data, _ := p.source.TableData(ot.TagVvar)
+		if p.vvar, err = ot.ParseHvar(data); err != nil {
+			p.parseErrs[ot.TagVvar] = err
+		}
 	}
 	if p.source.HasTable(ot.TagGvar) {
 		data, _ := p.source.TableData(ot.TagGvar)
-		p.gvar, _ = ot.ParseGvar(data)
+		if p.gvar, err = ot.ParseGvar(data); err != nil {
+			p.parseErrs[ot.TagGvar] = err
+		}
 	}
 
 	return nil
 }
 
+// planLayout applies the script and feature filters to GSUB and GPOS.
+// HarfBuzz equivalent: _collect_layout_indices (hb-subset-plan.cc)
+func (p *Plan) planLayout() {
+	// A static instance uses the feature substitutions of the
+	// FeatureVariations record matching its location; a variable subset
+	// keeps the records that can still match.
+	var coords []int
+	var pi *partialInstance
+	if p.input.HasPinnedAxes() && !p.input.HasAxisRanges() {
+		coords = p.normalizedCoords
+	} else if p.input.HasAxisRanges() {
+		pi = p.newPartialInstance()
+	}
+	if p.gsub != nil {
+		scripts, err1 := p.gsub.ParseScriptList()
+		features, err2 := p.gsub.ParseFeatureList()
+		if err1 == nil && err2 == nil {
+			p.gsubLayout = newLayoutPlan(p.input, scripts, features, p.gsub.GetFeatureVariations(), coords, pi)
+		}
+	}
+	if p.gpos != nil {
+		scripts, err1 := p.gpos.ParseScriptList()
+		features, err2 := p.gpos.ParseFeatureList()
+		if err1 == nil && err2 == nil {
+			p.gposLayout = newLayoutPlan(p.input, scripts, features, p.gpos.GetFeatureVariations(), coords, pi)
+		}
+	}
+}
+
 // computeGlyphClosure computes all glyphs that need to be retained.
 func (p *Plan) computeGlyphClosure() {
 	// Always keep .notdef (GID 0)
@@ -160,15 +297,18 @@ func (p *Plan) computeGlyphClosure() {
 				p.glyphSet[gid] = true
 			}
 		}
+		for seq := range p.input.variations {
+			if gid, ok := p.cmap.LookupVariation(ot.Codepoint(seq.base), ot.Codepoint(seq.selector)); ok {
+				p.glyphSet[gid] = true
+			}
+		}
 	}
 
 	// Add explicitly requested glyphs
 	for gid := range p.input.glyphs {
 		p.glyphSet[gid] = true
 	}
-
-	// Compute composite glyph closure (components)
-	p.computeCompositeGlyphClosure()
+	p.report.closurePhase("input", p.glyphSet)
 
 	// Compute GSUB closure (unless disabled or layout tables are dropped)
 	// Skip closure if FlagNoLayoutClosure is set OR if FlagDropLayoutTables is set
@@ -176,6 +316,20 @@ func (p *Plan) computeGlyphClosure() {
 	if p.input.Flags&FlagNoLayoutClosure == 0 && p.input.Flags&FlagDropLayoutTables == 0 {
 		p.computeGSUBClosure()
 	}
+	p.report.closurePhase("GSUB", p.glyphSet)
+
+	// Add the size variants and assembly parts of math glyphs
+	p.computeMATHClosure()
+	p.report.closurePhase("MATH", p.glyphSet)
+
+	// Add the glyphs color glyphs are drawn with
+	p.computeCOLRClosure()
+	p.report.closurePhase("COLR", p.glyphSet)
+
+	// Compute composite glyph closure (components), last so that
+	// substituted and color layer glyphs keep their components
+	p.computeCompositeGlyphClosure()
+	p.report.closurePhase("glyf", p.glyphSet)
 }
 
 // computeCompositeGlyphClosure adds component glyphs from composites.
@@ -205,9 +359,11 @@ func (p *Plan) computeCompositeGlyphClosure() {
 	}
 }
 
-// computeGSUBClosure adds glyphs reachable through GSUB substitutions.
+// computeGSUBClosure adds glyphs reachable through GSUB substitutions of
+// the retained features.
+// HarfBuzz equivalent: hb_ot_layout_lookups_substitute_closure (hb-ot-layout.cc)
 func (p *Plan) computeGSUBClosure() {
-	if p.gsub == nil {
+	if p.gsub == nil || p.gsubLayout == nil {
 		return
 	}
 
@@ -215,15 +371,17 @@ func (p *Plan) computeGSUBClosure() {
 	for {
 		added := false
 
-		// Check each lookup
-		for i := 0; i < p.gsub.NumLookups(); i++ {
-			lookup := p.gsub.GetLookup(i)
+		// Check each lookup of a retained feature; nested lookups are
+		// followed through contextual rules
+		for _, idx := range p.gsubLayout.lookups {
+			lookup := p.gsub.GetLookup(int(idx))
 			if lookup == nil {
 				continue
 			}
 
 			// Get glyphs produced by this lookup for our current glyph set
-			newGlyphs := p.getGSUBLookupOutputGlyphs(lookup)
+			newGlyphs := make(map[ot.GlyphID]bool)
+			p.collectGSUBLookupOutputGlyphs(lookup, newGlyphs, 0)
 			for gid := range newGlyphs {
 				if !p.glyphSet[gid] {
 					p.glyphSet[gid] = true
@@ -238,11 +396,31 @@ func (p *Plan) computeGSUBClosure() {
 	}
 }
 
-// getGSUBLookupOutputGlyphs returns output glyphs for a lookup given current glyph set.
-func (p *Plan) getGSUBLookupOutputGlyphs(lookup *ot.GSUBLookup) map[ot.GlyphID]bool {
-	result := make(map[ot.GlyphID]bool)
+// maxClosureNesting bounds recursion into nested lookups of contextual
+// rules. HarfBuzz equivalent: HB_MAX_NESTING_LEVEL (hb-limits.hh)
+const maxClosureNesting = 64
+
+// collectGSUBLookupOutputGlyphs adds the output glyphs of a lookup, given
+// the current glyph set, to result.
+func (p *Plan) collectGSUBLookupOutputGlyphs(lookup *ot.GSUBLookup, result map[ot.GlyphID]bool, depth int) {
+	if depth > maxClosureNesting {
+		return
+	}
+	has := func(g ot.GlyphID) bool { return p.glyphSet[g] }
 
 	for _, subtable := range lookup.Subtables() {
+		// Contextual rules: if a rule can match, its nested lookups can
+		// fire on the glyph set.
+		// HarfBuzz equivalent: context_closure_lookup (hb-ot-layout-gsubgpos.hh)
+		if sc := gsubSequenceContext(subtable); sc != nil {
+			for _, idx := range sequenceContextLookups(sc, has) {
+				if nested := p.gsub.GetLookup(int(idx)); nested != nil {
+					p.collectGSUBLookupOutputGlyphs(nested, result, depth+1)
+				}
+			}
+			continue
+		}
+
 		switch st := subtable.(type) {
 		case *ot.SingleSubst:
 			// Single substitution: if input glyph is in set, add output
@@ -289,10 +467,21 @@ func (p *Plan) getGSUBLookupOutputGlyphs(lookup *ot.GSUBLookup) map[ot.GlyphID]b
 					}
 				}
 			}
+
+		case *ot.ReverseChainSingleSubst:
+			// Reverse chaining: if the context can match, the covered
+			// glyphs in the set map to their substitutes
+			if !allCoverages(st.BacktrackCoverages(), has) || !allCoverages(st.LookaheadCoverages(), has) {
+				continue
+			}
+			subs := st.Substitutes()
+			for i, g := range st.Coverage().Glyphs() {
+				if i < len(subs) && p.glyphSet[g] {
+					result[subs[i]] = true
+				}
+			}
 		}
 	}
-
-	return result
 }
 
 // createGlyphMapping creates the old->new glyph ID mapping.
@@ -412,12 +601,20 @@ func (p *Plan) Fvar() *ot.Fvar {
 
 // IsInstanced returns true if the plan will produce an instanced (static) font.
 func (p *Plan) IsInstanced() bool {
-	return p.input.HasPinnedAxes() && p.instancedAdvances != nil
+	return p.input.HasPinnedAxes() && p.instancedAdvances != nil && p.partial == nil
 }
 
-// computeInstancedAdvances computes advance widths with HVAR deltas applied.
-func (p *Plan) computeInstancedAdvances() {
-	if p.fvar == nil || p.hmtx == nil {
+// IsPartiallyInstanced returns true if the plan will produce a variable
+// font with restricted axis ranges.
+func (p *Plan) IsPartiallyInstanced() bool {
+	return p.partial != nil
+}
+
+// computeNormalizedCoords computes the normalized coordinates of the
+// pinned location, or of the new default of restricted axes. Unpinned
+// axes stay at their default.
+func (p *Plan) computeNormalizedCoords() {
+	if p.fvar == nil {
 		return
 	}
 
@@ -429,8 +626,10 @@ func (p *Plan) computeInstancedAdvances() {
 	for i, axis := range axes {
 		if value, pinned := p.input.pinnedAxes[axis.Tag]; pinned {
 			normalizedCoords[i] = p.fvar.NormalizeAxisValue(i, value)
+		} else if r, ok := p.input.axisRanges[axis.Tag]; ok {
+			normalizedCoords[i] = p.fvar.NormalizeAxisValue(i, r.def)
 		}
-		// Unpinned axes stay at 0 (default)
+		// Other axes stay at 0 (default)
 	}
 
 	// Convert to F2DOT14 format and apply avar mapping
@@ -441,9 +640,16 @@ func (p *Plan) computeInstancedAdvances() {
 	if p.avar != nil && p.avar.HasData() {
 		normalizedCoordsI = p.avar.MapCoords(normalizedCoordsI)
 	}
-
-	// Save normalized coordinates for gvar instancing
 	p.normalizedCoords = normalizedCoordsI
+}
+
+// computeInstancedAdvances computes advance widths with HVAR deltas applied,
+// and advance heights with VVAR deltas applied, at the pinned location or
+// the new default of restricted axes.
+func (p *Plan) computeInstancedAdvances() {
+	if p.normalizedCoords == nil || p.hmtx == nil {
+		return
+	}
 
 	// Compute instanced advances for all glyphs in the subset
 	p.instancedAdvances = make(map[ot.GlyphID]uint16)
@@ -453,12 +659,27 @@ func (p *Plan) computeInstancedAdvances() {
 
 		// Apply HVAR delta if available
 		if p.hvar != nil && p.hvar.HasData() {
-			delta := p.hvar.GetAdvanceDelta(oldGID, normalizedCoordsI)
+			delta := p.hvar.GetAdvanceDelta(oldGID, p.normalizedCoords)
 			baseAdvance = uint16(int32(baseAdvance) + roundToInt(delta))
 		}
 
 		p.instancedAdvances[oldGID] = baseAdvance
 	}
+
+	// Advance heights with VVAR deltas. Without VVAR, they come from the
+	// phantom points of the instanced glyf outlines.
+	if p.vmtx == nil {
+		return
+	}
+	p.instancedVAdvances = make(map[ot.GlyphID]uint16)
+	for oldGID := range p.glyphSet {
+		advance := p.vmtx.GetAdvanceHeight(oldGID)
+		if p.vvar != nil && p.vvar.HasData() {
+			delta := p.vvar.GetAdvanceDelta(oldGID, p.normalizedCoords)
+			advance = uint16(int32(advance) + roundToInt(delta))
+		}
+		p.instancedVAdvances[oldGID] = advance
+	}
 }
 
 // GetGlyphDeltas returns the gvar deltas for a glyph at the pinned coordinates.
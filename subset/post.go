@@ -0,0 +1,54 @@
+package subset
+
+import (
+	"encoding/binary"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// subsetPost subsets the post table. With FlagGlyphNames the glyph names
+// are remapped to the new glyph IDs in a version 2.0 table; otherwise the
+// names are dropped with version 3.0.
+// HarfBuzz equivalent: post::subset (hb-ot-post-table.hh)
+func (p *Plan) subsetPost(builder *FontBuilder) {
+	data, err := p.source.TableData(ot.TagPost)
+	if err != nil || len(data) < 32 {
+		return
+	}
+	out := make([]byte, 32)
+	copy(out, data)
+
+	post, err := ot.ParsePostTable(data)
+	if p.input.Flags&FlagGlyphNames == 0 || err != nil || !post.HasGlyphNames() {
+		binary.BigEndian.PutUint32(out, 0x00030000)
+		builder.AddTable(ot.TagPost, out)
+		return
+	}
+
+	// Standard names are referenced by their index, the others are
+	// stored once in the string pool after them.
+	binary.BigEndian.PutUint32(out, 0x00020000)
+	out = binary.BigEndian.AppendUint16(out, uint16(p.numOutputGlyphs))
+	var pool []byte
+	custom := make(map[string]int)
+	for newGID := 0; newGID < p.numOutputGlyphs; newGID++ {
+		index := 0 // .notdef for empty slots
+		if oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]; ok {
+			name := post.GetGlyphName(oldGID)
+			if i, ok := ot.StandardGlyphNameIndex(name); ok {
+				index = i
+			} else if name != "" && len(name) < 256 {
+				i, ok := custom[name]
+				if !ok {
+					i = len(custom)
+					custom[name] = i
+					pool = append(pool, byte(len(name)))
+					pool = append(pool, name...)
+				}
+				index = 258 + i
+			}
+		}
+		out = binary.BigEndian.AppendUint16(out, uint16(index))
+	}
+	builder.AddTable(ot.TagPost, append(out, pool...))
+}
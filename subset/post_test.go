@@ -0,0 +1,89 @@
+package subset
+
+import (
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestSubsetPostGlyphNames(t *testing.T) {
+	// Tibetan font with post version 2.0 and uniXXXX glyph names
+	font := loadFont(t, "2de1ab4907ab688c0cfc236b0bf51151db38bf2e.ttf")
+	src, err := ot.ParsePostTable(mustTableData(t, font, ot.TagPost))
+	if err != nil {
+		t.Fatalf("ParsePostTable: %v", err)
+	}
+
+	for _, flags := range []Flags{FlagGlyphNames, FlagGlyphNames | FlagRetainGIDs} {
+		input := NewInput()
+		input.AddGlyphs(2, 3, 7)
+		input.Flags = flags
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset: %v", err)
+		}
+		data := mustTableData(t, subFont, ot.TagPost)
+		post, err := ot.ParsePostTable(data)
+		if err != nil {
+			t.Fatalf("ParsePostTable: %v", err)
+		}
+		if post.Version != 0x00020000 {
+			t.Fatalf("flags %#x: post version %#x, want 2.0", flags, post.Version)
+		}
+
+		// Standard names use their index; the others are stored once
+		size := 34 + 2*subFont.NumGlyphs()
+		for oldGID := range plan.GlyphSet() {
+			newGID, _ := plan.MapGlyph(oldGID)
+			name := src.GetGlyphName(oldGID)
+			if got := post.GetGlyphName(newGID); got != name {
+				t.Errorf("flags %#x: glyph %d named %q, want %q", flags, oldGID, got, name)
+			}
+			if _, ok := ot.StandardGlyphNameIndex(name); !ok {
+				size += 1 + len(name)
+			}
+		}
+		if len(data) != size {
+			t.Errorf("flags %#x: post has %d bytes, want %d", flags, len(data), size)
+		}
+	}
+}
+
+func TestSubsetPostWithoutNames(t *testing.T) {
+	font := loadFont(t, "2de1ab4907ab688c0cfc236b0bf51151db38bf2e.ttf")
+	input := NewInput()
+	input.AddGlyphs(2, 3)
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	result, err := plan.Execute()
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	subFont, err := ot.ParseFont(result, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse subset: %v", err)
+	}
+	data := mustTableData(t, subFont, ot.TagPost)
+	post, err := ot.ParsePostTable(data)
+	if err != nil {
+		t.Fatalf("ParsePostTable: %v", err)
+	}
+	if post.Version != 0x00030000 || len(data) != 32 {
+		t.Errorf("post version %#x with %d bytes, want 3.0 with 32 bytes", post.Version, len(data))
+	}
+	src, _ := ot.ParsePostTable(mustTableData(t, font, ot.TagPost))
+	if post.ItalicAngle != src.ItalicAngle || post.UnderlinePosition != src.UnderlinePosition ||
+		post.UnderlineThickness != src.UnderlineThickness || post.IsFixedPitch != src.IsFixedPitch {
+		t.Errorf("post header changed")
+	}
+}
@@ -0,0 +1,153 @@
+package subset
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// SubsetReport describes how a plan subset its font: the glyphs each
+// closure phase added, the size of every table and the source tables
+// and lookups that did not make it into the output.
+type SubsetReport struct {
+	// Closure lists the glyph set after each closure phase, in the
+	// order the phases ran.
+	Closure []ClosurePhase
+
+	// Tables lists the input and output size of each table of the
+	// source or the output, sorted by tag.
+	Tables []TableSize
+
+	// Dropped lists the source tables missing from the output.
+	Dropped []DroppedTable
+
+	// SkippedLookups lists the retained GSUB and GPOS lookups without a
+	// readable subtable: unknown lookup types and malformed subtables.
+	SkippedLookups []SkippedLookup
+}
+
+// ClosurePhase is the glyph set after a closure phase.
+type ClosurePhase struct {
+	Name   string // "input", "GSUB", "MATH", "COLR" or "glyf"
+	Glyphs int    // glyphs retained after the phase
+	Added  int    // glyphs added by the phase
+}
+
+// TableSize is the input and output size of a table in bytes. A size
+// is 0 if the table is missing.
+type TableSize struct {
+	Tag        ot.Tag
+	InputSize  int
+	OutputSize int
+}
+
+// DropReason says why a source table is missing from the output.
+type DropReason string
+
+const (
+	// DropRequested: the table was dropped by Input.DropTable or
+	// FlagNoHinting.
+	DropRequested DropReason = "dropped by input"
+
+	// DropLayout: GSUB, GPOS and GDEF are dropped with
+	// FlagDropLayoutTables.
+	DropLayout DropReason = "layout tables dropped"
+
+	// DropInstanced: the variation table is no longer needed by the
+	// instanced font.
+	DropInstanced DropReason = "variations instanced"
+
+	// DropEmpty: nothing of the table applies to the retained glyphs.
+	DropEmpty DropReason = "empty after subsetting"
+
+	// DropFailed: the table could not be parsed or subset (FlagLenient
+	// only).
+	DropFailed DropReason = "subsetting failed"
+
+	// DropUnrecognized: the subsetter does not handle the table and
+	// FlagPassUnrecognized is not set.
+	DropUnrecognized DropReason = "not recognized"
+)
+
+// DroppedTable is a source table missing from the output.
+type DroppedTable struct {
+	Tag    ot.Tag
+	Reason DropReason
+	Err    error // the parsing or subsetting error for DropFailed
+}
+
+// SkippedLookup is a lookup without a readable subtable.
+type SkippedLookup struct {
+	Table ot.Tag // ot.TagGSUB or ot.TagGPOS
+	Index uint16 // lookup index in the source
+	Type  uint16 // lookup type
+}
+
+// Report returns the report of the last Execute. Before Execute only
+// the closure phases are filled in.
+func (p *Plan) Report() *SubsetReport {
+	return p.report
+}
+
+// closurePhase records the glyph set after the named closure phase.
+func (r *SubsetReport) closurePhase(name string, glyphSet map[ot.GlyphID]bool) {
+	prev := 0
+	if n := len(r.Closure); n > 0 {
+		prev = r.Closure[n-1].Glyphs
+	}
+	r.Closure = append(r.Closure, ClosurePhase{Name: name, Glyphs: len(glyphSet), Added: len(glyphSet) - prev})
+}
+
+// drop records a dropped source table. The first reason recorded for a
+// table wins.
+func (r *SubsetReport) drop(tag ot.Tag, reason DropReason, err error) {
+	for _, d := range r.Dropped {
+		if d.Tag == tag {
+			return
+		}
+	}
+	r.Dropped = append(r.Dropped, DroppedTable{Tag: tag, Reason: reason, Err: err})
+}
+
+// skipLookup records a lookup without a readable subtable.
+func (r *SubsetReport) skipLookup(table ot.Tag, index, lookupType uint16) {
+	r.SkippedLookups = append(r.SkippedLookups, SkippedLookup{Table: table, Index: index, Type: lookupType})
+}
+
+// finishReport fills in the table sizes and the reasons of the source
+// tables missing from the output that were not recorded while
+// subsetting.
+func (p *Plan) finishReport(builder *FontBuilder) {
+	r := p.report
+	sizes := make(map[ot.Tag]*TableSize)
+	for _, tag := range p.source.TableTags() {
+		data, _ := p.source.TableData(tag)
+		sizes[tag] = &TableSize{Tag: tag, InputSize: len(data)}
+		if _, ok := builder.tables[tag]; ok {
+			continue
+		}
+		switch {
+		case p.input.ShouldDropTable(tag):
+			r.drop(tag, DropRequested, nil)
+		case p.input.Flags&FlagDropLayoutTables != 0 && (tag == ot.TagGSUB || tag == ot.TagGPOS || tag == ot.TagGDEF):
+			r.drop(tag, DropLayout, nil)
+		case p.IsInstanced() && slices.Contains(variationTables, tag):
+			r.drop(tag, DropInstanced, nil)
+		default:
+			r.drop(tag, DropUnrecognized, nil)
+		}
+	}
+	for tag, data := range builder.tables {
+		if sizes[tag] == nil {
+			sizes[tag] = &TableSize{Tag: tag}
+		}
+		sizes[tag].OutputSize = len(data)
+	}
+	r.Tables = r.Tables[:0]
+	for _, s := range sizes {
+		r.Tables = append(r.Tables, *s)
+	}
+	sort.Slice(r.Tables, func(i, j int) bool { return r.Tables[i].Tag < r.Tables[j].Tag })
+	sort.Slice(r.Dropped, func(i, j int) bool { return r.Dropped[i].Tag < r.Dropped[j].Tag })
+}
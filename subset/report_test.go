@@ -0,0 +1,217 @@
+package subset
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestSubsetReport(t *testing.T) {
+	font := loadFont(t, "Roboto-Regular.ttf")
+	input := NewInput()
+	input.AddString("fiAV")
+	input.DropTable(ot.TagGPOS)
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	if _, err := plan.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	r := plan.Report()
+
+	// The fi ligature is added by GSUB
+	if len(r.Closure) != 5 || r.Closure[0].Name != "input" || r.Closure[len(r.Closure)-1].Glyphs != plan.NumOutputGlyphs() {
+		t.Fatalf("closure %+v", r.Closure)
+	}
+	if r.Closure[0].Glyphs != 5 || r.Closure[1].Name != "GSUB" || r.Closure[1].Added == 0 {
+		t.Errorf("closure %+v, want 5 input glyphs and GSUB additions", r.Closure)
+	}
+
+	sizes := make(map[ot.Tag]TableSize)
+	for _, s := range r.Tables {
+		sizes[s.Tag] = s
+	}
+	if s := sizes[ot.TagGlyf]; s.InputSize != len(mustTableData(t, font, ot.TagGlyf)) || s.OutputSize == 0 ||
+		s.OutputSize >= s.InputSize {
+		t.Errorf("glyf sizes %+v", s)
+	}
+	if s := sizes[ot.TagGPOS]; s.InputSize == 0 || s.OutputSize != 0 {
+		t.Errorf("GPOS sizes %+v", s)
+	}
+
+	reasons := make(map[ot.Tag]DropReason)
+	for _, d := range r.Dropped {
+		reasons[d.Tag] = d.Reason
+	}
+	if reasons[ot.TagGPOS] != DropRequested {
+		t.Errorf("GPOS dropped as %q, want %q", reasons[ot.TagGPOS], DropRequested)
+	}
+	for _, s := range r.Tables {
+		if _, dropped := reasons[s.Tag]; dropped != (s.OutputSize == 0) {
+			t.Errorf("%s: output size %d, dropped %v", s.Tag, s.OutputSize, dropped)
+		}
+	}
+}
+
+func TestSubsetTableError(t *testing.T) {
+	// A MATH table too short for its header
+	font := buildTestFont(t, map[ot.Tag][]byte{ot.TagMATH: appendUint16s(nil, 1, 0, 0)})
+	input := NewInput()
+	input.AddString("a")
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	_, err = plan.Execute()
+	var tableErr *TableError
+	if !errors.As(err, &tableErr) || tableErr.Tag != ot.TagMATH || !errors.Is(err, ot.ErrInvalidTable) {
+		t.Fatalf("Execute: %v, want a MATH TableError", err)
+	}
+
+	input.Flags |= FlagLenient
+	result, err := plan.Execute()
+	if err != nil {
+		t.Fatalf("lenient Execute: %v", err)
+	}
+	subFont, err := ot.ParseFont(result, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse subset: %v", err)
+	}
+	if subFont.HasTable(ot.TagMATH) {
+		t.Error("broken MATH table kept")
+	}
+	dropped := false
+	for _, d := range plan.Report().Dropped {
+		if d.Tag == ot.TagMATH {
+			dropped = d.Reason == DropFailed && errors.Is(d.Err, ot.ErrInvalidTable)
+		}
+	}
+	if !dropped {
+		t.Errorf("MATH not reported as failed: %+v", plan.Report().Dropped)
+	}
+}
+
+func TestSubsetParseError(t *testing.T) {
+	for _, tc := range []struct {
+		tag  ot.Tag
+		data []byte
+	}{
+		{ot.TagGSUB, appendUint16s(nil, 2, 0, 10, 10, 10)}, // unknown major version
+		{ot.TagCFF, []byte{2, 0, 4, 1}},                    // CFF2 header
+		{ot.TagMATH, appendUint16s(nil, 2, 0, 10, 10, 10)}, // unknown major version
+		{ot.TagCOLR, appendUint16s(nil, 0, 1)},             // truncated header
+		{ot.TagGvar, make([]byte, 20)},                     // version 0
+	} {
+		font := buildTestFont(t, map[ot.Tag][]byte{tc.tag: tc.data})
+		input := NewInput()
+		input.AddString("a")
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("%s: CreatePlan: %v", tc.tag, err)
+		}
+		_, err = plan.Execute()
+		var tableErr *TableError
+		if !errors.As(err, &tableErr) || tableErr.Tag != tc.tag {
+			t.Errorf("%s: Execute: %v, want a TableError", tc.tag, err)
+		}
+
+		input.Flags |= FlagLenient
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("%s: lenient Execute: %v", tc.tag, err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("%s: Failed to parse subset: %v", tc.tag, err)
+		}
+		if subFont.HasTable(tc.tag) {
+			t.Errorf("%s: broken table kept", tc.tag)
+		}
+		var reason DropReason
+		for _, d := range plan.Report().Dropped {
+			if d.Tag == tc.tag && d.Err != nil {
+				reason = d.Reason
+			}
+		}
+		if reason != DropFailed {
+			t.Errorf("%s: dropped as %q, want %q", tc.tag, reason, DropFailed)
+		}
+	}
+}
+
+// TestSubsetParseErrorInstanced checks that a broken HVAR fails a
+// variable subset but not a static instance, which drops it anyway.
+func TestSubsetParseErrorInstanced(t *testing.T) {
+	src := loadFont(t, "Roboto-Variable.ttf")
+	if !src.HasTable(ot.TagHvar) {
+		t.Skip("font has no HVAR")
+	}
+	builder := NewFontBuilder()
+	for _, tag := range src.TableTags() {
+		builder.AddTable(tag, mustTableData(t, src, tag))
+	}
+	builder.AddTable(ot.TagHvar, appendUint16s(nil, 2, 0)) // truncated header
+	data, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	font, err := ot.ParseFont(data, 0)
+	if err != nil {
+		t.Fatalf("ParseFont: %v", err)
+	}
+
+	input := NewInput()
+	input.AddString("a")
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	_, err = plan.Execute()
+	var tableErr *TableError
+	if !errors.As(err, &tableErr) || tableErr.Tag != ot.TagHvar {
+		t.Errorf("variable subset: Execute: %v, want a TableError", err)
+	}
+
+	input.PinAxisLocation(ot.TagAxisWeight, 700)
+	plan, err = CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	if _, err := plan.Execute(); err != nil {
+		t.Errorf("instance: Execute: %v", err)
+	}
+}
+
+func TestSubsetReportSkippedLookups(t *testing.T) {
+	// A ccmp feature whose only lookup has the unknown type 9
+	gsub := appendUint16s(nil, 1, 0, 10, 30, 44,
+		1, 0x4446, 0x4C54, 8, // ScriptList: DFLT
+		4, 0, // Script
+		0, 0xFFFF, 1, 0, // default LangSys: feature 0
+		1, 0x6363, 0x6D70, 8, // FeatureList: ccmp
+		0, 1, 0, // Feature: lookup 0
+		1, 4, // LookupList
+		9, 0, 1, 8, // Lookup of type 9
+		1, 0)
+	font := buildTestFont(t, map[ot.Tag][]byte{ot.TagGSUB: gsub})
+	input := NewInput()
+	input.AddString("a")
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	if _, err := plan.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	r := plan.Report()
+	if len(r.SkippedLookups) != 1 || r.SkippedLookups[0] != (SkippedLookup{Table: ot.TagGSUB, Index: 0, Type: 9}) {
+		t.Errorf("skipped lookups %+v", r.SkippedLookups)
+	}
+	for _, d := range r.Dropped {
+		if d.Tag == ot.TagGSUB && d.Reason != DropEmpty {
+			t.Errorf("GSUB dropped as %q, want %q", d.Reason, DropEmpty)
+		}
+	}
+}
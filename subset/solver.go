@@ -0,0 +1,192 @@
+package subset
+
+// Tent solver for partial instancing: re-expresses a variation region
+// relative to a restricted axis range.
+//
+// HarfBuzz equivalent: rebase_tent (hb-subset-instancer-solver.cc), a
+// port of fontTools.varLib.instancer.solver.
+
+// solverEpsilon is the smallest F2DOT14 step.
+const solverEpsilon = 1.0 / (1 << 14)
+
+// tent is the start, peak and end of a region along one axis, in
+// normalized coordinates.
+type tent struct {
+	lower, peak, upper float64
+}
+
+func (t tent) reverseNegate() tent {
+	return tent{-t.upper, -t.peak, -t.lower}
+}
+
+// axisLimit is a restricted axis range in the normalized coordinates of
+// the source font. distNeg and distPos are the design-space distances
+// from the original default to the original minimum and maximum; they
+// keep renormalized values proportional across the old default.
+type axisLimit struct {
+	min, def, max    float64
+	distNeg, distPos float64
+}
+
+func (l axisLimit) reverseNegate() axisLimit {
+	return axisLimit{-l.max, -l.def, -l.min, l.distPos, l.distNeg}
+}
+
+// isPinned reports whether the range is a single location.
+func (l axisLimit) isPinned() bool {
+	return l.min == l.max
+}
+
+// renormalize maps a coordinate of the source font to the normalized
+// coordinates of the restricted axis.
+func (l axisLimit) renormalize(v float64) float64 {
+	if v == l.def {
+		return 0
+	}
+	if l.def < 0 {
+		return -l.reverseNegate().renormalize(-v)
+	}
+	if v > l.def {
+		return (v - l.def) / (l.max - l.def)
+	}
+	if l.min >= 0 {
+		return (v - l.def) / (l.def - l.min)
+	}
+	// The range straddles the old default: measure the distance from the
+	// new default in design units.
+	total := l.distNeg*-l.min + l.distPos*l.def
+	var d float64
+	if v >= 0 {
+		d = (l.def - v) * l.distPos
+	} else {
+		d = -v*l.distNeg + l.distPos*l.def
+	}
+	return -d / total
+}
+
+// supportScalar evaluates a tent at v.
+func supportScalar(v float64, t tent) float64 {
+	if t.peak == 0 || v == t.peak {
+		return 1
+	}
+	if t.lower > t.peak || t.peak > t.upper || (t.lower < 0 && t.upper > 0) {
+		return 1
+	}
+	if v <= t.lower || v >= t.upper {
+		return 0
+	}
+	if v < t.peak {
+		return (v - t.lower) / (t.peak - t.lower)
+	}
+	return (t.upper - v) / (t.upper - t.peak)
+}
+
+// tentSolution is one part of a solved tent: its deltas are scaled by
+// scalar and apply within region, or everywhere when region is nil.
+type tentSolution struct {
+	scalar float64
+	region *tent
+}
+
+// solveTent re-expresses tent t over the restricted axis range as a sum
+// of scaled tents in the renormalized coordinates. A solution without
+// region is a contribution at the new default location.
+func solveTent(t tent, limit axisLimit) []tentSolution {
+	var out []tentSolution
+	for _, s := range solveTentRaw(t, limit, false) {
+		if s.scalar == 0 {
+			continue
+		}
+		if s.region != nil {
+			s.region = &tent{
+				limit.renormalize(s.region.lower),
+				limit.renormalize(s.region.peak),
+				limit.renormalize(s.region.upper),
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// solveTentRaw solves in the coordinates of the source font.
+func solveTentRaw(t tent, limit axisLimit, negative bool) []tentSolution {
+	axisMin, axisDef, axisMax := limit.min, limit.def, limit.max
+	lower, peak, upper := t.lower, t.peak, t.upper
+
+	// Mirror the problem such that axisDef <= peak.
+	if axisDef > peak {
+		sols := solveTentRaw(t.reverseNegate(), limit.reverseNegate(), !negative)
+		for i, s := range sols {
+			if s.region != nil {
+				r := s.region.reverseNegate()
+				sols[i].region = &r
+			}
+		}
+		return sols
+	}
+
+	// Case 1: the whole tent lies beyond the new maximum.
+	if axisMax <= lower && axisMax < peak {
+		return nil
+	}
+
+	// Case 2: only the peak and upper end lie beyond the new maximum;
+	// move the peak there and scale.
+	if axisMax < peak {
+		mult := supportScalar(axisMax, t)
+		sols := solveTentRaw(tent{lower, axisMax, axisMax}, limit, negative)
+		for i := range sols {
+			sols[i].scalar *= mult
+		}
+		return sols
+	}
+
+	// lower <= axisDef <= peak <= axisMax
+	gain := supportScalar(axisDef, t)
+	out := []tentSolution{{scalar: gain}}
+
+	// The positive side.
+	outGain := supportScalar(axisMax, t)
+	if gain >= outGain {
+		// Case 3a: the down slope crosses gain before axisMax.
+		crossing := peak + (1-gain)*(upper-peak)
+		out = append(out, tentSolution{1 - gain, &tent{max(lower, axisDef), peak, crossing}})
+
+		if upper >= axisMax {
+			// Case 3a1: one tent to axisMax.
+			out = append(out, tentSolution{outGain - gain, &tent{crossing, axisMax, axisMax}})
+		} else {
+			// Case 3a2: two tents keep the deltas down to axisMax.
+			if upper == axisDef {
+				upper += solverEpsilon
+			}
+			out = append(out,
+				tentSolution{-gain, &tent{crossing, upper, axisMax}},
+				tentSolution{-gain, &tent{upper, axisMax, axisMax}})
+		}
+	} else {
+		// Case 4: chop into two tents, since a triangle with one side
+		// cut off is not a triangle.
+		out = append(out, tentSolution{1 - gain, &tent{max(axisDef, lower), peak, axisMax}})
+		if peak < axisMax {
+			out = append(out, tentSolution{outGain - gain, &tent{peak, axisMax, axisMax}})
+		}
+	}
+
+	// The negative side.
+	if lower <= axisMin {
+		// Case 1neg: chop at axisMin.
+		scalar := supportScalar(axisMin, t)
+		out = append(out, tentSolution{scalar - gain, &tent{axisMin, axisMin, axisDef}})
+	} else {
+		// Case 2neg: two tents keep the deltas down to axisMin.
+		if lower == axisDef {
+			lower -= solverEpsilon
+		}
+		out = append(out,
+			tentSolution{-gain, &tent{axisMin, lower, axisDef}},
+			tentSolution{-gain, &tent{axisMin, axisMin, lower}})
+	}
+	return out
+}
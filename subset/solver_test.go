@@ -0,0 +1,96 @@
+package subset
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSolveTentKnown(t *testing.T) {
+	// fontTools solver_test.py: tent (0, 1, 1) restricted to (0, 0.5, 1).
+	got := solveTent(tent{0, 1, 1}, axisLimit{0, 0.5, 1, 1, 1})
+	want := []tentSolution{
+		{0.5, nil},
+		{0.5, &tent{0, 1, 1}},
+		{-0.5, &tent{-1, -1, 0}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d solutions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].scalar != want[i].scalar || (got[i].region == nil) != (want[i].region == nil) ||
+			(got[i].region != nil && *got[i].region != *want[i].region) {
+			t.Errorf("solution %d: got %v %v, want %v %v", i, got[i].scalar, got[i].region, want[i].scalar, want[i].region)
+		}
+	}
+
+	// A tent entirely outside the new range is dropped.
+	if got := solveTent(tent{0.5, 1, 1}, axisLimit{-1, 0, 0.25, 1, 1}); len(got) != 0 {
+		t.Errorf("tent outside range: got %d solutions", len(got))
+	}
+}
+
+// TestSolveTentEquivalence checks the defining property of the solver:
+// within the restricted range, the solved tents evaluated at the
+// renormalized location sum to the original tent.
+func TestSolveTentEquivalence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	q := func(v float64) float64 { return math.Round(v*16) / 16 }
+
+	for n := 0; n < 2000; n++ {
+		var tn tent
+		for tn.peak == 0 {
+			a, b, c := q(rng.Float64()*2-1), q(rng.Float64()*2-1), q(rng.Float64()*2-1)
+			// Sort into lower <= peak <= upper on one side of zero.
+			vals := []float64{a, b, c}
+			if vals[0] > vals[1] {
+				vals[0], vals[1] = vals[1], vals[0]
+			}
+			if vals[1] > vals[2] {
+				vals[1], vals[2] = vals[2], vals[1]
+			}
+			if vals[0] > vals[1] {
+				vals[0], vals[1] = vals[1], vals[0]
+			}
+			tn = tent{vals[0], vals[1], vals[2]}
+			if tn.peak > 0 {
+				tn.lower = max(tn.lower, 0)
+			} else {
+				tn.upper = math.Min(tn.upper, 0)
+			}
+			// Tents with a vertical side are only well defined at the
+			// ends of the axis, as implicit gvar regions.
+			if tn.lower == tn.peak || tn.peak == tn.upper {
+				p := math.Copysign(1, tn.peak)
+				tn = tent{math.Min(p, 0), p, max(p, 0)}
+			}
+		}
+		lo, hi := q(rng.Float64()*2-1), q(rng.Float64()*2-1)
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo == hi {
+			continue
+		}
+		def := q(lo + rng.Float64()*(hi-lo))
+		limit := axisLimit{lo, def, hi, 100, 200}
+
+		sols := solveTent(tn, limit)
+		for i := 0; i <= 20; i++ {
+			v := lo + (hi-lo)*float64(i)/20
+			want := supportScalar(v, tn)
+			rv := limit.renormalize(v)
+			var got float64
+			for _, s := range sols {
+				if s.region == nil {
+					got += s.scalar
+				} else {
+					got += s.scalar * supportScalar(rv, *s.region)
+				}
+			}
+			if math.Abs(got-want) > 1e-6 {
+				t.Fatalf("tent %v limit %v at %v: got %v, want %v (solutions %v)", tn, limit, v, got, want, sols)
+			}
+		}
+	}
+}
@@ -15,6 +15,42 @@ func findTestFont(name string) string {
 	return testutil.FindTestFont(name)
 }
 
+// loadFont loads a test font, or skips the test if it is missing.
+func loadFont(t *testing.T, name string) *ot.Font {
+	t.Helper()
+	path := findTestFont(name)
+	if path == "" {
+		t.Skipf("%s not found", name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	font, err := ot.ParseFont(data, 0)
+	if err != nil {
+		t.Fatalf("parse %s: %v", name, err)
+	}
+	return font
+}
+
+// subsetAndParse subsets font with input and parses the subset font.
+func subsetAndParse(t *testing.T, font *ot.Font, input *Input) (*Plan, []byte, *ot.Font) {
+	t.Helper()
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	result, err := plan.Execute()
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	subFont, err := ot.ParseFont(result, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse subset font: %v", err)
+	}
+	return plan, result, subFont
+}
+
 func TestSubsetBasic(t *testing.T) {
 	fontPath := findTestFont("Roboto-Regular.ttf")
 	if fontPath == "" {
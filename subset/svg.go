@@ -0,0 +1,170 @@
+package subset
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// svgDocument is a source document of the SVG table with the retained
+// glyphs it covers.
+type svgDocument struct {
+	data     []byte
+	glyphMap map[ot.GlyphID]ot.GlyphID
+	complete bool // all covered glyphs are retained
+	out      int  // offset of the output document, -1 if not written
+	outLen   int
+}
+
+// subsetSVG subsets the SVG table. Documents without retained glyphs are
+// dropped; the others are rewritten for the new glyph IDs with
+// ot.RemapSVGGlyphs, which also removes the elements of dropped glyphs,
+// and are stored once. A document whose glyphs are no longer consecutive
+// is referenced by one index entry per range of new glyph IDs. Returns
+// nil if no document is retained.
+// HarfBuzz has no equivalent (hb-subset copies the table unchanged);
+// this follows fontTools' subset of the SVG table.
+func (p *Plan) subsetSVG() ([]byte, error) {
+	data, err := p.source.TableData(ot.TagSVG)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 10 {
+		return nil, ot.ErrInvalidTable
+	}
+	index := int(binary.BigEndian.Uint32(data[2:]))
+	if index == 0 || index+2 > len(data) {
+		return nil, nil
+	}
+	numEntries := int(binary.BigEndian.Uint16(data[index:]))
+	if index+2+numEntries*12 > len(data) {
+		return nil, ot.ErrInvalidTable
+	}
+
+	// Documents are identified by their source range; entries may share
+	// one.
+	type docKey struct{ offset, length uint32 }
+	docs := make(map[docKey]*svgDocument)
+	glyphDocs := make(map[ot.GlyphID]*svgDocument)
+	for i := range numEntries {
+		e := index + 2 + i*12
+		start := binary.BigEndian.Uint16(data[e:])
+		end := binary.BigEndian.Uint16(data[e+2:])
+		key := docKey{binary.BigEndian.Uint32(data[e+4:]), binary.BigEndian.Uint32(data[e+8:])}
+		docStart := index + int(key.offset)
+		if end < start || docStart+int(key.length) > len(data) {
+			return nil, ot.ErrInvalidTable
+		}
+		doc := docs[key]
+		if doc == nil {
+			doc = &svgDocument{
+				data:     data[docStart : docStart+int(key.length)],
+				glyphMap: make(map[ot.GlyphID]ot.GlyphID),
+				complete: true,
+				out:      -1,
+			}
+			docs[key] = doc
+		}
+		for gid := int(start); gid <= int(end); gid++ {
+			newGID, ok := p.glyphMap[ot.GlyphID(gid)]
+			if !ok {
+				doc.complete = false
+				continue
+			}
+			doc.glyphMap[ot.GlyphID(gid)] = newGID
+			glyphDocs[ot.GlyphID(gid)] = doc
+		}
+	}
+
+	var entries, documents []byte
+	numOut := 0
+	var last *svgDocument
+	for newGID := range p.numOutputGlyphs {
+		oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]
+		doc := glyphDocs[oldGID]
+		if !ok || doc == nil {
+			last = nil
+			continue
+		}
+		if doc.out < 0 {
+			out, err := doc.subset()
+			if err != nil {
+				return nil, err
+			}
+			if out == nil {
+				last = nil
+				continue
+			}
+			doc.out, doc.outLen = len(documents), len(out)
+			documents = append(documents, out...)
+		}
+		if doc == last {
+			binary.BigEndian.PutUint16(entries[len(entries)-10:], uint16(newGID))
+			continue
+		}
+		entries = appendUint16s(entries, uint16(newGID), uint16(newGID))
+		entries = binary.BigEndian.AppendUint32(entries, uint32(doc.out))
+		entries = binary.BigEndian.AppendUint32(entries, uint32(doc.outLen))
+		numOut++
+		last = doc
+	}
+	if numOut == 0 {
+		return nil, nil
+	}
+
+	// Document offsets are relative to the document index
+	docsStart := 2 + len(entries)
+	for i := range numOut {
+		e := entries[i*12+4:]
+		binary.BigEndian.PutUint32(e, binary.BigEndian.Uint32(e)+uint32(docsStart))
+	}
+	out := make([]byte, 10, 10+docsStart+len(documents))
+	binary.BigEndian.PutUint32(out[2:], 10)
+	out = binary.BigEndian.AppendUint16(out, uint16(numOut))
+	out = append(out, entries...)
+	return append(out, documents...), nil
+}
+
+// subset returns the document rewritten for the retained glyphs. It
+// returns the source bytes if the glyph IDs are unchanged and no glyph is
+// dropped, and nil if the document cannot be parsed but would need
+// rewriting. Compressed documents stay compressed.
+func (d *svgDocument) subset() ([]byte, error) {
+	identity := d.complete
+	for oldGID, newGID := range d.glyphMap {
+		if oldGID != newGID {
+			identity = false
+		}
+	}
+	if identity {
+		return d.data, nil
+	}
+	doc := d.data
+	compressed := len(doc) >= 2 && doc[0] == 0x1F && doc[1] == 0x8B
+	if compressed {
+		zr, err := gzip.NewReader(bytes.NewReader(doc))
+		if err != nil {
+			return nil, nil
+		}
+		doc, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, nil
+		}
+	}
+	doc = ot.RemapSVGGlyphs(doc, d.glyphMap)
+	if doc == nil || !compressed {
+		return doc, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(doc); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
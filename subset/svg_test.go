@@ -0,0 +1,125 @@
+package subset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// svgIndex returns the document index entries of an SVG table as
+// (start, end, offset, length).
+func svgIndex(data []byte) [][4]int {
+	index := int(binary.BigEndian.Uint32(data[2:]))
+	var entries [][4]int
+	for i := range int(binary.BigEndian.Uint16(data[index:])) {
+		e := data[index+2+i*12:]
+		entries = append(entries, [4]int{
+			int(binary.BigEndian.Uint16(e)), int(binary.BigEndian.Uint16(e[2:])),
+			int(binary.BigEndian.Uint32(e[4:])), int(binary.BigEndian.Uint32(e[8:])),
+		})
+	}
+	return entries
+}
+
+func TestSubsetSVG(t *testing.T) {
+	// Glyphs 3-7 share one document, glyphs 8-13 another
+	font := loadFont(t, "TestSVGmultiGlyphs.otf")
+	for _, flags := range []Flags{FlagNoLayoutClosure, FlagNoLayoutClosure | FlagRetainGIDs} {
+		input := NewInput()
+		input.AddGlyphs(4, 6, 9)
+		input.Flags = flags
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset: %v", err)
+		}
+		data := mustTableData(t, subFont, ot.TagSVG)
+		svg, err := ot.ParseSVG(data)
+		if err != nil {
+			t.Fatalf("flags %#x: ParseSVG: %v", flags, err)
+		}
+
+		// With FlagRetainGIDs glyphs 4 and 6 are no longer consecutive
+		// and reference the same document from two entries.
+		entries := svgIndex(data)
+		if flags&FlagRetainGIDs == 0 {
+			if len(entries) != 2 || entries[0][0] != 1 || entries[0][1] != 2 {
+				t.Errorf("flags %#x: entries %v, want [1 2] and [3 3]", flags, entries)
+			}
+		} else if len(entries) != 3 || entries[0][2] != entries[1][2] || entries[0][3] != entries[1][3] {
+			t.Errorf("flags %#x: entries %v, want glyphs 4 and 6 sharing a document", flags, entries)
+		}
+
+		for _, oldGID := range []ot.GlyphID{4, 6, 9} {
+			newGID, _ := plan.MapGlyph(oldGID)
+			doc := svg.GlyphSVG(newGID)
+			if !bytes.Contains(doc, []byte(`id="glyph`+strconv.Itoa(int(newGID))+`"`)) {
+				t.Errorf("flags %#x: document of glyph %d lacks glyph%d", flags, oldGID, newGID)
+			}
+			if svg.GlyphElementSVG(newGID, 1000, ot.GlyphBBox{}) == nil {
+				t.Errorf("flags %#x: glyph %d not drawn", flags, newGID)
+			}
+			// Dropped glyphs are removed, the definitions stay
+			want := 1
+			if oldGID != 9 {
+				want = 2
+			}
+			if n := bytes.Count(doc, []byte(`id="glyph`)); n != want {
+				t.Errorf("flags %#x: document of glyph %d has %d glyphs, want %d", flags, oldGID, n, want)
+			}
+			if oldGID == 9 && !bytes.Contains(doc, []byte(`id="hand_fill"`)) {
+				t.Errorf("flags %#x: definitions of glyph 9 removed", flags)
+			}
+		}
+	}
+}
+
+func TestSubsetSVGCompressed(t *testing.T) {
+	font := loadFont(t, "TestSVGgzip.otf")
+	src, err := ot.ParseSVG(mustTableData(t, font, ot.TagSVG))
+	if err != nil {
+		t.Fatalf("ParseSVG: %v", err)
+	}
+	input := NewInput()
+	input.AddGlyphs(3)
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	result, err := plan.Execute()
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	subFont, err := ot.ParseFont(result, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse subset: %v", err)
+	}
+	data := mustTableData(t, subFont, ot.TagSVG)
+	svg, err := ot.ParseSVG(data)
+	if err != nil {
+		t.Fatalf("ParseSVG: %v", err)
+	}
+	entries := svgIndex(data)
+	if len(entries) != 1 {
+		t.Fatalf("entries %v, want one", entries)
+	}
+	index := int(binary.BigEndian.Uint32(data[2:]))
+	if doc := data[index+entries[0][2]:]; doc[0] != 0x1F || doc[1] != 0x8B {
+		t.Error("document not compressed")
+	}
+	newGID, _ := plan.MapGlyph(3)
+	want := bytes.Replace(src.GlyphSVG(3), []byte(`id="glyph3"`), []byte(`id="glyph`+strconv.Itoa(int(newGID))+`"`), 1)
+	if got := svg.GlyphSVG(newGID); !bytes.Equal(got, want) {
+		t.Errorf("document of glyph 3:\n got %.200s\nwant %.200s", got, want)
+	}
+}
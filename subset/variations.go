@@ -0,0 +1,143 @@
+package subset
+
+import (
+	"encoding/binary"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// Subsetting of glyph-keyed variation tables for fonts that stay variable.
+//
+// HarfBuzz equivalent: gvar::subset (hb-ot-var-gvar-table.hh) and
+// HVARVVAR::_subset (hb-ot-var-hvar-table.hh).
+
+// subsetGvar creates a gvar table with the variation data of the
+// retained glyphs under their new glyph IDs. Shared tuples are kept
+// unchanged, since per-glyph data references them by index.
+func (p *Plan) subsetGvar() ([]byte, error) {
+	if p.gvar == nil {
+		return nil, nil
+	}
+
+	glyphs := make([][]byte, p.numOutputGlyphs)
+	for newGID := range glyphs {
+		if oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]; ok {
+			glyphs[newGID] = p.gvar.GlyphVariationData(oldGID)
+		}
+	}
+	return buildGvar(p.gvar.AxisCount(), p.gvar.SharedTupleCount(), p.gvar.SharedTuples(), glyphs), nil
+}
+
+// buildGvar serializes a gvar table from per-glyph GlyphVariationData,
+// with short offsets when the data is small enough.
+func buildGvar(axisCount, sharedTupleCount int, sharedTuples []byte, glyphs [][]byte) []byte {
+	var glyphData []byte
+	offsets := make([]uint32, len(glyphs)+1)
+	for gid, data := range glyphs {
+		offsets[gid] = uint32(len(glyphData))
+		glyphData = append(glyphData, data...)
+		// Short offsets store offset/2.
+		if len(glyphData)%2 != 0 {
+			glyphData = append(glyphData, 0)
+		}
+	}
+	offsets[len(glyphs)] = uint32(len(glyphData))
+
+	longOffsets := len(glyphData) > 0x1FFFE
+	offsetSize := 2
+	var flags uint16
+	if longOffsets {
+		offsetSize = 4
+		flags = 1
+	}
+
+	sharedTuplesOffset := 20 + len(offsets)*offsetSize
+	dataOffset := sharedTuplesOffset + len(sharedTuples)
+
+	out := appendUint16s(nil, 1, 0, uint16(axisCount), uint16(sharedTupleCount))
+	out = binary.BigEndian.AppendUint32(out, uint32(sharedTuplesOffset))
+	out = appendUint16s(out, uint16(len(glyphs)), flags)
+	out = binary.BigEndian.AppendUint32(out, uint32(dataOffset))
+	for _, off := range offsets {
+		if longOffsets {
+			out = binary.BigEndian.AppendUint32(out, off)
+		} else {
+			out = binary.BigEndian.AppendUint16(out, uint16(off/2))
+		}
+	}
+	out = append(out, sharedTuples...)
+	out = append(out, glyphData...)
+	return out
+}
+
+// subsetMetricsVar creates an HVAR or VVAR table for the retained glyphs.
+// Both tables start with an ItemVariationStore offset followed by
+// numMaps DeltaSetIndexMap offsets, the first of which maps advances;
+// HVAR has three maps (advance, lsb, rsb) and VVAR four (advance, tsb,
+// bsb, vorg). Without an advance map, glyph IDs index the first VarData
+// directly. The result always has an explicit advance map. When partially
+// instancing, the deltas are re-expressed over the restricted axes.
+func (p *Plan) subsetMetricsVar(tag ot.Tag, numMaps int) ([]byte, error) {
+	data, err := p.source.TableData(tag)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := 8 + numMaps*4
+	if len(data) < headerSize || binary.BigEndian.Uint16(data) != 1 {
+		return nil, ot.ErrInvalidTable
+	}
+	storeOffset := binary.BigEndian.Uint32(data[4:])
+	if storeOffset == 0 || int(storeOffset) >= len(data) {
+		return nil, ot.ErrInvalidOffset
+	}
+	store, err := ot.ParseItemVariationStore(data[storeOffset:])
+	if err != nil {
+		return nil, err
+	}
+
+	maps := make([]*ot.DeltaSetIndexMap, numMaps)
+	for i := range maps {
+		off := binary.BigEndian.Uint32(data[8+i*4:])
+		if off == 0 || int(off) >= len(data) {
+			continue
+		}
+		if maps[i], err = ot.ParseDeltaSetIndexMap(data[off:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Old delta set index per map and new glyph.
+	entries := make([][]uint32, numMaps)
+	builder := p.newVarStoreBuilder(store)
+	for i, m := range maps {
+		if m == nil && i > 0 {
+			continue
+		}
+		entries[i] = make([]uint32, p.numOutputGlyphs)
+		for newGID := range entries[i] {
+			idx := uint32(noVariationsIndex)
+			if oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]; ok {
+				idx = m.Map(uint32(oldGID)) // nil map: identity
+			}
+			entries[i][newGID] = idx
+			builder.add(idx)
+		}
+	}
+
+	storeData := builder.build()
+	out := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(out, 1)
+	binary.BigEndian.PutUint32(out[4:], uint32(len(out)))
+	out = append(out, storeData...)
+	for i, e := range entries {
+		if e == nil {
+			continue
+		}
+		for j, idx := range e {
+			e[j] = builder.mapped(idx)
+		}
+		binary.BigEndian.PutUint32(out[8+i*4:], uint32(len(out)))
+		out = append(out, buildDeltaSetIndexMap(e)...)
+	}
+	return out, nil
+}
@@ -0,0 +1,203 @@
+package subset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// subsetVariable subsets Roboto-Variable for text, keeping it variable.
+func subsetVariable(t *testing.T, text string) (*ot.Font, *Plan, *ot.Font) {
+	t.Helper()
+	font := loadFont(t, "Roboto-Variable.ttf")
+	input := NewInput()
+	input.AddString(text)
+	plan, _, subFont := subsetAndParse(t, font, input)
+	for _, tag := range []ot.Tag{ot.TagFvar, ot.TagGvar, ot.TagHvar} {
+		if !subFont.HasTable(tag) {
+			t.Fatalf("variable subset has no %s table", tag)
+		}
+	}
+	return font, plan, subFont
+}
+
+func TestSubsetVariableShaping(t *testing.T) {
+	_, _, subFont := subsetVariable(t, "Hello")
+
+	// Same values as TestInstancingAppliesHVAR.
+	expectedAdvances := map[float32][]int16{
+		100: {1438, 1032, 422, 422, 1127},
+		400: {1461, 1086, 498, 498, 1168},
+		900: {1439, 1116, 563, 563, 1151},
+	}
+	for weight, expected := range expectedAdvances {
+		t.Run(fmt.Sprintf("weight%.0f", weight), func(t *testing.T) {
+			shaper, err := ot.NewShaper(subFont)
+			if err != nil {
+				t.Fatalf("NewShaper: %v", err)
+			}
+			shaper.SetVariation(ot.TagAxisWeight, weight)
+			buf := ot.NewBuffer()
+			buf.AddString("Hello")
+			shaper.Shape(buf, nil)
+			for i, pos := range buf.Pos {
+				if pos.XAdvance != expected[i] {
+					t.Errorf("glyph %d: advance %d, want %d", i, pos.XAdvance, expected[i])
+				}
+			}
+		})
+	}
+}
+
+// TestSubsetStringStaysVariable checks that the default subset of a
+// variable font keeps working variations.
+func TestSubsetStringStaysVariable(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	result, err := SubsetString(font, "Hello")
+	if err != nil {
+		t.Fatalf("SubsetString: %v", err)
+	}
+	subFont, err := ot.ParseFont(result, 0)
+	if err != nil {
+		t.Fatalf("ParseFont: %v", err)
+	}
+	for _, tag := range []ot.Tag{ot.TagFvar, ot.TagGvar, ot.TagHvar, ot.TagSTAT} {
+		if font.HasTable(tag) && !subFont.HasTable(tag) {
+			t.Errorf("default subset dropped %s", tag)
+		}
+	}
+	fvarData, _ := subFont.TableData(ot.TagFvar)
+	fvar, err := ot.ParseFvar(fvarData)
+	if err != nil || len(fvar.AxisInfos()) == 0 {
+		t.Fatalf("fvar: %v", err)
+	}
+
+	gvarData, _ := subFont.TableData(ot.TagGvar)
+	gvar, err := ot.ParseGvar(gvarData)
+	if err != nil {
+		t.Fatalf("ParseGvar: %v", err)
+	}
+	varied := 0
+	for gid := range subFont.NumGlyphs() {
+		if d := gvar.GetGlyphDeltas(ot.GlyphID(gid), []int{16384}, 256); d != nil && slices.ContainsFunc(d.XDeltas, func(v float64) bool { return v != 0 }) {
+			varied++
+		}
+	}
+	if varied == 0 {
+		t.Errorf("no glyph varies in the subset gvar")
+	}
+
+	var advances [2][]int16
+	for i, weight := range []float32{100, 900} {
+		shaper, err := ot.NewShaper(subFont)
+		if err != nil {
+			t.Fatalf("NewShaper: %v", err)
+		}
+		shaper.SetVariation(ot.TagAxisWeight, weight)
+		buf := ot.NewBuffer()
+		buf.AddString("Hello")
+		shaper.Shape(buf, nil)
+		for _, pos := range buf.Pos {
+			advances[i] = append(advances[i], pos.XAdvance)
+		}
+	}
+	if slices.Equal(advances[0], advances[1]) {
+		t.Errorf("advances %v do not vary with the weight", advances[0])
+	}
+}
+
+func TestSubsetVariableDeltas(t *testing.T) {
+	font, plan, subFont := subsetVariable(t, "Hé")
+
+	parse := func(f *ot.Font) (*ot.Gvar, *ot.Hvar) {
+		gvarData, _ := f.TableData(ot.TagGvar)
+		gvar, err := ot.ParseGvar(gvarData)
+		if err != nil {
+			t.Fatalf("ParseGvar: %v", err)
+		}
+		hvarData, _ := f.TableData(ot.TagHvar)
+		hvar, err := ot.ParseHvar(hvarData)
+		if err != nil {
+			t.Fatalf("ParseHvar: %v", err)
+		}
+		return gvar, hvar
+	}
+	srcGvar, srcHvar := parse(font)
+	subGvar, subHvar := parse(subFont)
+
+	if subGvar.GlyphCount() != len(plan.GlyphSet()) {
+		t.Errorf("gvar glyph count %d, want %d", subGvar.GlyphCount(), len(plan.GlyphSet()))
+	}
+	srcHvarData, _ := font.TableData(ot.TagHvar)
+	subHvarData, _ := subFont.TableData(ot.TagHvar)
+	if len(subHvarData) >= len(srcHvarData) {
+		t.Errorf("HVAR not compacted: %d bytes, source %d", len(subHvarData), len(srcHvarData))
+	}
+
+	for _, coords := range [][]int{{-16384}, {8192}, {16384}} {
+		for oldGID, newGID := range plan.GlyphMap() {
+			if got, want := subHvar.GetAdvanceDelta(newGID, coords), srcHvar.GetAdvanceDelta(oldGID, coords); got != want {
+				t.Errorf("coords %v glyph %d: advance delta %v, want %v", coords, oldGID, got, want)
+			}
+			const numPoints = 256
+			got := subGvar.GetGlyphDeltas(newGID, coords, numPoints)
+			want := srcGvar.GetGlyphDeltas(oldGID, coords, numPoints)
+			if (got == nil) != (want == nil) {
+				t.Fatalf("coords %v glyph %d: deltas %v, want %v", coords, oldGID, got, want)
+			}
+			if got == nil {
+				continue
+			}
+			for i := range want.XDeltas {
+				if got.XDeltas[i] != want.XDeltas[i] || got.YDeltas[i] != want.YDeltas[i] {
+					t.Fatalf("coords %v glyph %d point %d: delta differs", coords, oldGID, i)
+				}
+			}
+		}
+	}
+}
+
+func TestBuildDeltaSetIndexMap(t *testing.T) {
+	entries := []uint32{0x00000003, 0x00010000, 0x00000001, 0x00000001, 0x00000001}
+	data := buildDeltaSetIndexMap(entries)
+	m, err := ot.ParseDeltaSetIndexMap(data)
+	if err != nil {
+		t.Fatalf("ParseDeltaSetIndexMap: %v", err)
+	}
+	if m.Count() != 3 {
+		t.Errorf("map has %d entries, want trailing duplicates trimmed to 3", m.Count())
+	}
+	for i, want := range entries {
+		if got := m.Map(uint32(i)); got != want {
+			t.Errorf("entry %d: %#x, want %#x", i, got, want)
+		}
+	}
+}
+
+func TestVarStoreNoVariationsIndex(t *testing.T) {
+	font := loadFont(t, "Roboto-Variable.ttf")
+	hvarData, _ := font.TableData(ot.TagHvar)
+	src, err := ot.ParseItemVariationStore(hvarData[binary.BigEndian.Uint32(hvarData[4:]):])
+	if err != nil {
+		t.Fatalf("ParseItemVariationStore: %v", err)
+	}
+	b := newVarStoreBuilder(src)
+	b.add(noVariationsIndex)
+	b.add(0)
+	out, err := ot.ParseItemVariationStore(b.build())
+	if err != nil {
+		t.Fatalf("ParseItemVariationStore: %v", err)
+	}
+	if n := out.DataSetCount(); n != 1 {
+		t.Errorf("%d VarData subtables, want 1", n)
+	}
+	if got := b.mapped(noVariationsIndex); got != noVariationsIndex {
+		t.Errorf("noVariationsIndex mapped to %#x", got)
+	}
+	if got := b.mapped(0); got != 0 {
+		t.Errorf("delta set 0 mapped to %#x", got)
+	}
+}
@@ -0,0 +1,345 @@
+package subset
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// ItemVariationStore and DeltaSetIndexMap subsetting.
+//
+// HarfBuzz equivalent: ItemVariationStore::subset and
+// DeltaSetIndexMap::serialize (hb-ot-layout-common.hh).
+
+// noVariationsIndex marks a delta set without variations.
+// HarfBuzz equivalent: HB_OT_LAYOUT_NO_VARIATIONS_INDEX
+const noVariationsIndex = 0xFFFFFFFF
+
+// varStoreBuilder collects the delta sets of a source store that are
+// still referenced and serializes them into a compacted store: unused
+// delta sets, regions and all-zero region columns are dropped, and every
+// column gets the narrowest delta size that holds its values.
+type varStoreBuilder struct {
+	src *ot.ItemVariationStore
+
+	// solve, if set, replaces each source region by scaled regions in the
+	// output axes (partial instancing). Contributions without a region
+	// are dropped; they are applied to the default instead.
+	solve     func([]ot.RegionAxisCoordinates) []regionSolution
+	axisCount int
+
+	// outers lists the source VarData subtables in order of first use,
+	// each with its delta sets in order of first use.
+	outers   []uint16
+	inners   map[uint16][]uint16
+	varIdxes map[uint32]uint32 // old -> new, filled by add
+}
+
+// regionSolution is a scaled region replacing (part of) a source
+// region. A nil region is a contribution at the default location.
+type regionSolution struct {
+	scalar float64
+	region []ot.RegionAxisCoordinates
+}
+
+func newVarStoreBuilder(src *ot.ItemVariationStore) *varStoreBuilder {
+	b := &varStoreBuilder{
+		src:      src,
+		inners:   make(map[uint16][]uint16),
+		varIdxes: make(map[uint32]uint32),
+	}
+	if rl := src.Regions(); rl != nil {
+		b.axisCount = rl.AxisCount()
+	}
+	return b
+}
+
+// newVarStoreBuilder returns a builder for a store of the source font,
+// re-expressing its regions over the restricted axes when partially
+// instancing.
+func (p *Plan) newVarStoreBuilder(src *ot.ItemVariationStore) *varStoreBuilder {
+	b := newVarStoreBuilder(src)
+	if p.partial != nil {
+		b.solve = p.partial.solveRegion
+		b.axisCount = p.partial.axisCount()
+	}
+	return b
+}
+
+// add marks a delta set as used and assigns its new index. Indices not
+// present in the source are kept as a delta set of zeros;
+// noVariationsIndex maps to itself.
+func (b *varStoreBuilder) add(varIdx uint32) {
+	if _, ok := b.varIdxes[varIdx]; ok {
+		return
+	}
+	if varIdx == noVariationsIndex {
+		b.varIdxes[varIdx] = noVariationsIndex
+		return
+	}
+	outer, inner := uint16(varIdx>>16), uint16(varIdx)
+	if _, ok := b.inners[outer]; !ok {
+		b.outers = append(b.outers, outer)
+	}
+	newOuter := slices.Index(b.outers, outer)
+	b.varIdxes[varIdx] = uint32(newOuter)<<16 | uint32(len(b.inners[outer]))
+	b.inners[outer] = append(b.inners[outer], inner)
+}
+
+// mapped returns the new index of a delta set passed to add.
+func (b *varStoreBuilder) mapped(varIdx uint32) uint32 {
+	return b.varIdxes[varIdx]
+}
+
+// build serializes the store and assigns the new delta set indices.
+func (b *varStoreBuilder) build() []byte {
+	type varData struct {
+		regions []int // output region indices, one per column
+		rows    [][]int32
+	}
+
+	rl := b.src.Regions()
+	var regionCount int
+	if rl != nil {
+		regionCount = rl.RegionCount()
+	}
+
+	// Output regions in order of first use.
+	var regions [][]ot.RegionAxisCoordinates
+	regionIndex := make(map[string]int)
+	outRegion := func(r []ot.RegionAxisCoordinates) int {
+		key := regionKey(r)
+		idx, ok := regionIndex[key]
+		if !ok {
+			idx = len(regions)
+			regionIndex[key] = idx
+			regions = append(regions, r)
+		}
+		return idx
+	}
+	solved := make(map[uint16][]regionSolution)
+	solveRegion := func(r uint16) []regionSolution {
+		if sols, ok := solved[r]; ok {
+			return sols
+		}
+		sols := []regionSolution{{scalar: 1, region: rl.Region(int(r))}}
+		if b.solve != nil {
+			sols = b.solve(sols[0].region)
+		}
+		solved[r] = sols
+		return sols
+	}
+
+	var subtables []varData
+	for newOuter, outer := range b.outers {
+		var vd varData
+		columns := make(map[int]bool)
+		var rows []map[int]float64
+		for newInner, inner := range b.inners[outer] {
+			old := uint32(outer)<<16 | uint32(inner)
+			b.varIdxes[old] = uint32(newOuter)<<16 | uint32(newInner)
+
+			row := make(map[int]float64)
+			srcRegions, deltas := b.src.ItemDeltas(old)
+			for i, r := range srcRegions {
+				if deltas[i] == 0 || int(r) >= regionCount {
+					continue
+				}
+				for _, s := range solveRegion(r) {
+					if s.region != nil {
+						row[outRegion(s.region)] += s.scalar * float64(deltas[i])
+					}
+				}
+			}
+			for c, d := range row {
+				if math.Round(d) == 0 {
+					delete(row, c)
+				} else {
+					columns[c] = true
+				}
+			}
+			rows = append(rows, row)
+		}
+		for c := range columns {
+			vd.regions = append(vd.regions, c)
+		}
+		sort.Ints(vd.regions)
+		for _, row := range rows {
+			deltas := make([]int32, len(vd.regions))
+			for i, c := range vd.regions {
+				deltas[i] = int32(math.Round(row[c]))
+			}
+			vd.rows = append(vd.rows, deltas)
+		}
+		subtables = append(subtables, vd)
+	}
+
+	// Keep only referenced regions, in order of first use.
+	used := make([]bool, len(regions))
+	for _, vd := range subtables {
+		for _, c := range vd.regions {
+			used[c] = true
+		}
+	}
+	regionMap := make([]uint16, len(regions))
+	var kept [][]ot.RegionAxisCoordinates
+	for i, r := range regions {
+		if used[i] {
+			regionMap[i] = uint16(len(kept))
+			kept = append(kept, r)
+		}
+	}
+
+	header := appendUint16s(nil, 1)
+	header = binary.BigEndian.AppendUint32(header, 0) // regionListOffset
+	header = appendUint16s(header, uint16(len(subtables)))
+	for range subtables {
+		header = binary.BigEndian.AppendUint32(header, 0)
+	}
+
+	out := header
+	binary.BigEndian.PutUint32(out[2:], uint32(len(out)))
+	out = append(out, buildRegionList(b.axisCount, kept)...)
+	for i, vd := range subtables {
+		binary.BigEndian.PutUint32(out[8+i*4:], uint32(len(out)))
+		mapped := make([]uint16, len(vd.regions))
+		for j, c := range vd.regions {
+			mapped[j] = regionMap[c]
+		}
+		out = append(out, buildVarData(mapped, vd.rows)...)
+	}
+	return out
+}
+
+// regionKey identifies a region by its coordinates.
+func regionKey(region []ot.RegionAxisCoordinates) string {
+	key := make([]byte, 0, len(region)*6)
+	for _, a := range region {
+		key = appendUint16s(key, uint16(a.Start), uint16(a.Peak), uint16(a.End))
+	}
+	return string(key)
+}
+
+// buildRegionList serializes a VarRegionList.
+func buildRegionList(axisCount int, regions [][]ot.RegionAxisCoordinates) []byte {
+	out := appendUint16s(nil, uint16(axisCount), uint16(len(regions)))
+	for _, r := range regions {
+		for _, a := range r {
+			out = appendUint16s(out, uint16(a.Start), uint16(a.Peak), uint16(a.End))
+		}
+	}
+	return out
+}
+
+// buildVarData serializes a VarData subtable. Columns are reordered so
+// that the wide ones come first, as the format requires.
+func buildVarData(regions []uint16, rows [][]int32) []byte {
+	// Size per column: 1, 2 or 4 bytes.
+	sizes := make([]int, len(regions))
+	longWords := false
+	for c := range regions {
+		sizes[c] = 1
+		for _, row := range rows {
+			switch d := row[c]; {
+			case d < math.MinInt16 || d > math.MaxInt16:
+				sizes[c] = 4
+			case (d < math.MinInt8 || d > math.MaxInt8) && sizes[c] < 2:
+				sizes[c] = 2
+			}
+		}
+		if sizes[c] == 4 {
+			longWords = true
+		}
+	}
+
+	// With long words, columns are 4 or 2 bytes wide; otherwise 2 or 1.
+	wide := 2
+	if longWords {
+		wide = 4
+	}
+	order := make([]int, len(regions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sizes[order[i]] >= wide && sizes[order[j]] < wide
+	})
+	wordCount := 0
+	for _, c := range order {
+		if sizes[c] >= wide {
+			wordCount++
+		}
+	}
+
+	wordSizeCount := uint16(wordCount)
+	if longWords {
+		wordSizeCount |= 0x8000
+	}
+	out := appendUint16s(nil, uint16(len(rows)), wordSizeCount, uint16(len(regions)))
+	for _, c := range order {
+		out = appendUint16s(out, regions[c])
+	}
+	for _, row := range rows {
+		for i, c := range order {
+			size := wide / 2
+			if i < wordCount {
+				size = wide
+			}
+			switch size {
+			case 1:
+				out = append(out, byte(int8(row[c])))
+			case 2:
+				out = binary.BigEndian.AppendUint16(out, uint16(int16(row[c])))
+			case 4:
+				out = binary.BigEndian.AppendUint32(out, uint32(row[c]))
+			}
+		}
+	}
+	return out
+}
+
+// buildDeltaSetIndexMap serializes a DeltaSetIndexMap for the given
+// 16.16 delta set indices. Trailing entries equal to the last distinct
+// one are dropped; lookups past the end use the final entry.
+func buildDeltaSetIndexMap(entries []uint32) []byte {
+	n := len(entries)
+	for n > 1 && entries[n-1] == entries[n-2] {
+		n--
+	}
+	entries = entries[:n]
+
+	var maxOuter, maxInner uint32
+	for _, e := range entries {
+		maxOuter = max(maxOuter, e>>16)
+		maxInner = max(maxInner, e&0xFFFF)
+	}
+	innerBits := 1
+	for maxInner >= 1<<innerBits {
+		innerBits++
+	}
+	outerBits := 0
+	for maxOuter >= 1<<outerBits {
+		outerBits++
+	}
+	width := (innerBits + outerBits + 7) / 8
+	entryFormat := byte((width-1)<<4 | (innerBits - 1))
+
+	var out []byte
+	if len(entries) <= 0xFFFF {
+		out = []byte{0, entryFormat}
+		out = binary.BigEndian.AppendUint16(out, uint16(len(entries)))
+	} else {
+		out = []byte{1, entryFormat}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(entries)))
+	}
+	for _, e := range entries {
+		v := (e>>16)<<innerBits | e&0xFFFF
+		for i := width - 1; i >= 0; i-- {
+			out = append(out, byte(v>>(8*i)))
+		}
+	}
+	return out
+}
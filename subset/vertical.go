@@ -0,0 +1,96 @@
+package subset
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// verticalMetrics returns the advance height and top side bearing of a
+// glyph. When instancing, the advance includes the VVAR or phantom point
+// deltas and the side bearing follows the instanced outline.
+func (p *Plan) verticalMetrics(oldGID ot.GlyphID) (uint16, int16) {
+	advance, tsb := p.vmtx.GetAdvanceHeight(oldGID), p.vmtx.GetTsb(oldGID)
+	if adv, ok := p.instancedVAdvances[oldGID]; ok {
+		advance = adv
+	}
+	if ig, ok := p.instancedGlyphs[oldGID]; ok && !ig.empty {
+		tsb = ig.tsb
+	}
+	return advance, tsb
+}
+
+// subsetVmtx subsets the vmtx table like hmtx.
+// HarfBuzz equivalent: hmtxvmtx::subset (hb-ot-hmtx-table.hh)
+func (p *Plan) subsetVmtx(builder *FontBuilder) {
+	advances := make([]uint16, p.numOutputGlyphs)
+	tsbs := make([]int16, p.numOutputGlyphs)
+	for newGID := 0; newGID < p.numOutputGlyphs; newGID++ {
+		if oldGID, ok := p.reverseMap[ot.GlyphID(newGID)]; ok {
+			advances[newGID], tsbs[newGID] = p.verticalMetrics(oldGID)
+		}
+	}
+	builder.AddTable(ot.TagVmtx, buildLongMetrics(advances, tsbs))
+}
+
+// subsetVhea subsets the vhea table. numberOfVMetrics follows the
+// compacted vmtx and the extrema of the vertical metrics are recomputed
+// from the output glyphs.
+// HarfBuzz equivalent: hmtxvmtx::subset_update_header (hb-ot-hmtx-table.hh)
+func (p *Plan) subsetVhea(builder *FontBuilder) {
+	data, err := p.source.TableData(ot.TagVhea)
+	if err != nil || len(data) < 36 {
+		return
+	}
+	newData := make([]byte, len(data))
+	copy(newData, data)
+
+	binary.BigEndian.PutUint16(newData[34:], uint16(numLongMetrics(builder.tables[ot.TagVmtx], p.numOutputGlyphs)))
+
+	s := p.glyphStats()
+	binary.BigEndian.PutUint16(newData[10:], s.advanceHeightMax)
+	if s.hasOutlines {
+		binary.BigEndian.PutUint16(newData[12:], uint16(s.minTopSideBearing))
+		binary.BigEndian.PutUint16(newData[14:], uint16(s.minBottomSideBearing))
+		binary.BigEndian.PutUint16(newData[16:], uint16(s.yMaxExtent))
+	}
+
+	builder.AddTable(ot.TagVhea, newData)
+}
+
+// subsetVORG subsets the VORG table: the vertOriginYMetrics of the
+// retained glyphs are remapped to the new glyph IDs.
+// HarfBuzz equivalent: VORG::subset (hb-ot-vorg-table.hh)
+func (p *Plan) subsetVORG(builder *FontBuilder) {
+	data, err := p.source.TableData(ot.TagVORG)
+	if err != nil || len(data) < 8 {
+		return
+	}
+	numRecords := int(binary.BigEndian.Uint16(data[6:]))
+	if len(data) < 8+4*numRecords {
+		return
+	}
+
+	type vertOrigin struct {
+		gid ot.GlyphID
+		y   uint16
+	}
+	var origins []vertOrigin
+	for i := 0; i < numRecords; i++ {
+		r := data[8+4*i:]
+		if newGID, ok := p.glyphMap[ot.GlyphID(binary.BigEndian.Uint16(r))]; ok {
+			origins = append(origins, vertOrigin{newGID, binary.BigEndian.Uint16(r[2:])})
+		}
+	}
+	sort.Slice(origins, func(i, j int) bool { return origins[i].gid < origins[j].gid })
+
+	out := make([]byte, 8, 8+4*len(origins))
+	copy(out, data[:6]) // version and defaultVertOriginY
+	binary.BigEndian.PutUint16(out[6:], uint16(len(origins)))
+	for _, o := range origins {
+		out = binary.BigEndian.AppendUint16(out, uint16(o.gid))
+		out = binary.BigEndian.AppendUint16(out, o.y)
+	}
+	builder.AddTable(ot.TagVORG, out)
+}
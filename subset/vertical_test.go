@@ -0,0 +1,171 @@
+package subset
+
+import (
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+func TestBuildLongMetrics(t *testing.T) {
+	tests := []struct {
+		advances []uint16
+		numLong  int
+	}{
+		{[]uint16{500, 600, 1000, 1000, 1000}, 3},
+		{[]uint16{1000, 1000}, 1},
+		{[]uint16{500, 600}, 2},
+		{[]uint16{500}, 1},
+	}
+	for _, tt := range tests {
+		bearings := make([]int16, len(tt.advances))
+		for i := range bearings {
+			bearings[i] = int16(-10 * i)
+		}
+		data := buildLongMetrics(tt.advances, bearings)
+		if got := numLongMetrics(data, len(tt.advances)); got != tt.numLong {
+			t.Errorf("%v: %d long metrics, want %d", tt.advances, got, tt.numLong)
+		}
+		hmtx, err := ot.ParseHmtx(data, tt.numLong, len(tt.advances))
+		if err != nil {
+			t.Fatalf("%v: ParseHmtx: %v", tt.advances, err)
+		}
+		for i := range tt.advances {
+			advance, lsb := hmtx.GetMetrics(ot.GlyphID(i))
+			if advance != tt.advances[i] || lsb != bearings[i] {
+				t.Errorf("%v: glyph %d has %d, %d, want %d, %d", tt.advances, i, advance, lsb, tt.advances[i], bearings[i])
+			}
+		}
+	}
+}
+
+// parseVerticalMetrics parses vhea and vmtx of a font.
+func parseVerticalMetrics(t *testing.T, font *ot.Font) (*ot.Vhea, *ot.Vmtx) {
+	t.Helper()
+	vhea, err := ot.ParseVhea(mustTableData(t, font, ot.TagVhea))
+	if err != nil {
+		t.Fatalf("ParseVhea: %v", err)
+	}
+	vmtx, err := ot.ParseVmtx(mustTableData(t, font, ot.TagVmtx), int(vhea.NumberOfVMetrics), font.NumGlyphs())
+	if err != nil {
+		t.Fatalf("ParseVmtx: %v", err)
+	}
+	return vhea, vmtx
+}
+
+func TestSubsetVerticalTables(t *testing.T) {
+	// CID-keyed CFF with vhea, vmtx and VORG
+	font := loadFont(t, "6991b13ce889466be6de3f66e891de2bc0f117ee.ttf")
+	_, srcVmtx := parseVerticalMetrics(t, font)
+	srcVORG, err := ot.ParseVORG(mustTableData(t, font, ot.TagVORG))
+	if err != nil {
+		t.Fatalf("ParseVORG: %v", err)
+	}
+
+	for _, flags := range []Flags{0, FlagRetainGIDs} {
+		input := NewInput()
+		input.AddGlyphs(3, 8, 10)
+		input.Flags = flags
+		plan, err := CreatePlan(font, input)
+		if err != nil {
+			t.Fatalf("CreatePlan: %v", err)
+		}
+		result, err := plan.Execute()
+		if err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		subFont, err := ot.ParseFont(result, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse subset: %v", err)
+		}
+
+		// The glyphs share their advance height; empty slots of
+		// FlagRetainGIDs have none.
+		vhea, vmtx := parseVerticalMetrics(t, subFont)
+		if flags == 0 && int(vhea.NumberOfVMetrics) >= subFont.NumGlyphs() {
+			t.Errorf("flags %#x: vmtx not compacted: %d long metrics for %d glyphs",
+				flags, vhea.NumberOfVMetrics, subFont.NumGlyphs())
+		}
+		vorg, err := ot.ParseVORG(mustTableData(t, subFont, ot.TagVORG))
+		if err != nil {
+			t.Fatalf("ParseVORG: %v", err)
+		}
+
+		var advanceHeightMax uint16
+		for oldGID := range plan.GlyphSet() {
+			newGID, _ := plan.MapGlyph(oldGID)
+			advance := srcVmtx.GetAdvanceHeight(oldGID)
+			advanceHeightMax = max(advanceHeightMax, advance)
+			if got := vmtx.GetAdvanceHeight(newGID); got != advance {
+				t.Errorf("flags %#x: glyph %d advance height %d, want %d", flags, oldGID, got, advance)
+			}
+			if got, want := vmtx.GetTsb(newGID), srcVmtx.GetTsb(oldGID); got != want {
+				t.Errorf("flags %#x: glyph %d tsb %d, want %d", flags, oldGID, got, want)
+			}
+			if got, want := vorg.GetVertOriginY(newGID), srcVORG.GetVertOriginY(oldGID); got != want {
+				t.Errorf("flags %#x: glyph %d vertical origin %d, want %d", flags, oldGID, got, want)
+			}
+		}
+		if vhea.AdvanceHeightMax != advanceHeightMax {
+			t.Errorf("flags %#x: advanceHeightMax %d, want %d", flags, vhea.AdvanceHeightMax, advanceHeightMax)
+		}
+	}
+}
+
+func TestInstanceVerticalMetrics(t *testing.T) {
+	// gvar without VVAR: the advance heights come from the phantom
+	// points. HarfBuzz places A and B with an advance of 1000 and a
+	// vertical origin of 880 both at the default and at wght=700.
+	font := loadFont(t, "NotoSansCJK-VF.abc.ttf")
+	input := NewInput()
+	input.AddString("AB")
+	input.PinAxisLocation(ot.TagAxisWeight, 700)
+	plan, err := CreatePlan(font, input)
+	if err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	result, err := plan.Execute()
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	subFont, err := ot.ParseFont(result, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse subset: %v", err)
+	}
+	if subFont.HasTable(ot.TagGvar) {
+		t.Fatal("gvar kept in static instance")
+	}
+
+	srcGlyf, _ := ot.ParseGlyfFromFont(font)
+	_, vmtx := parseVerticalMetrics(t, subFont)
+	glyf, err := ot.ParseGlyfFromFont(subFont)
+	if err != nil {
+		t.Fatalf("ParseGlyfFromFont: %v", err)
+	}
+	cmap := loadCmap(t, subFont)
+	changed := false
+	for _, cp := range []ot.Codepoint{'A', 'B'} {
+		gid, _ := cmap.Lookup(cp)
+		oldGID, _ := plan.OldGlyph(gid)
+		if got := vmtx.GetAdvanceHeight(gid); got != 1000 {
+			t.Errorf("%c: advance height %d, want 1000", cp, got)
+		}
+		yMax := glyphHeaderBBox(glyf.GetGlyphBytes(gid)).YMax
+		if origin := vmtx.GetTsb(gid) + yMax; origin != 880 {
+			t.Errorf("%c: vertical origin %d, want 880", cp, origin)
+		}
+		if srcYMax := glyphHeaderBBox(srcGlyf.GetGlyphBytes(oldGID)).YMax; yMax != srcYMax {
+			changed = true
+		}
+	}
+	if !changed {
+		t.Errorf("outlines unchanged at wght=700")
+	}
+
+	vhea, err := ot.ParseVhea(mustTableData(t, subFont, ot.TagVhea))
+	if err != nil {
+		t.Fatalf("ParseVhea: %v", err)
+	}
+	if vhea.AdvanceHeightMax != 1000 {
+		t.Errorf("advanceHeightMax %d, want 1000", vhea.AdvanceHeightMax)
+	}
+}
@@ -0,0 +1,563 @@
+package subset
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"slices"
+
+	"github.com/boxesandglue/textshape/internal/brotli"
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// WOFF and WOFF2 output.
+//
+// Both formats wrap the tables of an sfnt. WOFF 1.0 compresses each
+// table with zlib. WOFF2 rewrites glyf, loca and hmtx in the transformed
+// formats of the WOFF2 specification and compresses all tables as one
+// Brotli stream.
+
+// BuildWOFF produces the font as WOFF 1.0.
+func (b *FontBuilder) BuildWOFF() ([]byte, error) {
+	sfnt, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return EncodeWOFF(sfnt)
+}
+
+// BuildWOFF2 produces the font as WOFF2.
+func (b *FontBuilder) BuildWOFF2() ([]byte, error) {
+	sfnt, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return EncodeWOFF2(sfnt)
+}
+
+// sfntTable is a table of an sfnt.
+type sfntTable struct {
+	tag  ot.Tag
+	data []byte
+}
+
+// sfntTables returns the sfnt version and the tables of a font in tag
+// order.
+func sfntTables(sfnt []byte) (uint32, []sfntTable, error) {
+	font, err := ot.ParseFont(sfnt, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	var tables []sfntTable
+	for _, tag := range font.TableTags() {
+		data, err := font.TableData(tag)
+		if err != nil {
+			return 0, nil, err
+		}
+		tables = append(tables, sfntTable{tag: tag, data: data})
+	}
+	if len(tables) == 0 {
+		return 0, nil, ErrNoTables
+	}
+	return binary.BigEndian.Uint32(sfnt), tables, nil
+}
+
+// sfntSize returns the size of an sfnt of tables with the given lengths.
+func sfntSize(lengths []int) int {
+	size := 12 + 16*len(lengths)
+	for _, l := range lengths {
+		size += (l + 3) &^ 3
+	}
+	return size
+}
+
+// fontVersion returns head.fontRevision as the major and minor version
+// of a WOFF or WOFF2 header, or 1.0 without a head table.
+func fontVersion(tables []sfntTable) (uint16, uint16) {
+	for _, t := range tables {
+		if t.tag == ot.TagHead && len(t.data) >= 8 {
+			return binary.BigEndian.Uint16(t.data[4:]), binary.BigEndian.Uint16(t.data[6:])
+		}
+	}
+	return 1, 0
+}
+
+// EncodeWOFF converts an sfnt font to WOFF 1.0. Each table is compressed
+// with zlib unless that does not make it smaller.
+// fontTools equivalent: SFNTWriter with flavor "woff" (ttLib/sfnt.py)
+func EncodeWOFF(sfnt []byte) ([]byte, error) {
+	flavor, tables, err := sfntTables(sfnt)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 44+20*len(tables))
+	lengths := make([]int, len(tables))
+	for i, t := range tables {
+		data := t.data
+		var buf bytes.Buffer
+		zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		zw.Write(t.data)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(t.data) {
+			data = buf.Bytes()
+		}
+
+		// TableDirectoryEntry
+		rec := 44 + 20*i
+		binary.BigEndian.PutUint32(out[rec:], uint32(t.tag))
+		binary.BigEndian.PutUint32(out[rec+4:], uint32(len(out)))
+		binary.BigEndian.PutUint32(out[rec+8:], uint32(len(data)))
+		binary.BigEndian.PutUint32(out[rec+12:], uint32(len(t.data)))
+		binary.BigEndian.PutUint32(out[rec+16:], calcChecksum(t.data))
+		lengths[i] = len(t.data)
+
+		out = append(out, data...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+
+	// WOFFHeader; there is no metadata or private data
+	major, minor := fontVersion(tables)
+	binary.BigEndian.PutUint32(out[0:], 0x774F4646) // 'wOFF'
+	binary.BigEndian.PutUint32(out[4:], flavor)
+	binary.BigEndian.PutUint32(out[8:], uint32(len(out)))
+	binary.BigEndian.PutUint16(out[12:], uint16(len(tables)))
+	binary.BigEndian.PutUint32(out[16:], uint32(sfntSize(lengths)))
+	binary.BigEndian.PutUint16(out[20:], major)
+	binary.BigEndian.PutUint16(out[22:], minor)
+	return out, nil
+}
+
+// woff2KnownTags are the tags with a table directory flag index (WOFF2
+// section 5.2); other tags use index 63 and are stored explicitly.
+var woff2KnownTags = []ot.Tag{
+	ot.MakeTag('c', 'm', 'a', 'p'), ot.MakeTag('h', 'e', 'a', 'd'), ot.MakeTag('h', 'h', 'e', 'a'),
+	ot.MakeTag('h', 'm', 't', 'x'), ot.MakeTag('m', 'a', 'x', 'p'), ot.MakeTag('n', 'a', 'm', 'e'),
+	ot.MakeTag('O', 'S', '/', '2'), ot.MakeTag('p', 'o', 's', 't'), ot.MakeTag('c', 'v', 't', ' '),
+	ot.MakeTag('f', 'p', 'g', 'm'), ot.MakeTag('g', 'l', 'y', 'f'), ot.MakeTag('l', 'o', 'c', 'a'),
+	ot.MakeTag('p', 'r', 'e', 'p'), ot.MakeTag('C', 'F', 'F', ' '), ot.MakeTag('V', 'O', 'R', 'G'),
+	ot.MakeTag('E', 'B', 'D', 'T'), ot.MakeTag('E', 'B', 'L', 'C'), ot.MakeTag('g', 'a', 's', 'p'),
+	ot.MakeTag('h', 'd', 'm', 'x'), ot.MakeTag('k', 'e', 'r', 'n'), ot.MakeTag('L', 'T', 'S', 'H'),
+	ot.MakeTag('P', 'C', 'L', 'T'), ot.MakeTag('V', 'D', 'M', 'X'), ot.MakeTag('v', 'h', 'e', 'a'),
+	ot.MakeTag('v', 'm', 't', 'x'), ot.MakeTag('B', 'A', 'S', 'E'), ot.MakeTag('G', 'D', 'E', 'F'),
+	ot.MakeTag('G', 'P', 'O', 'S'), ot.MakeTag('G', 'S', 'U', 'B'), ot.MakeTag('E', 'B', 'S', 'C'),
+	ot.MakeTag('J', 'S', 'T', 'F'), ot.MakeTag('M', 'A', 'T', 'H'), ot.MakeTag('C', 'B', 'D', 'T'),
+	ot.MakeTag('C', 'B', 'L', 'C'), ot.MakeTag('C', 'O', 'L', 'R'), ot.MakeTag('C', 'P', 'A', 'L'),
+	ot.MakeTag('S', 'V', 'G', ' '), ot.MakeTag('s', 'b', 'i', 'x'), ot.MakeTag('a', 'c', 'n', 't'),
+	ot.MakeTag('a', 'v', 'a', 'r'), ot.MakeTag('b', 'd', 'a', 't'), ot.MakeTag('b', 'l', 'o', 'c'),
+	ot.MakeTag('b', 's', 'l', 'n'), ot.MakeTag('c', 'v', 'a', 'r'), ot.MakeTag('f', 'd', 's', 'c'),
+	ot.MakeTag('f', 'e', 'a', 't'), ot.MakeTag('f', 'm', 't', 'x'), ot.MakeTag('f', 'v', 'a', 'r'),
+	ot.MakeTag('g', 'v', 'a', 'r'), ot.MakeTag('h', 's', 't', 'y'), ot.MakeTag('j', 'u', 's', 't'),
+	ot.MakeTag('l', 'c', 'a', 'r'), ot.MakeTag('m', 'o', 'r', 't'), ot.MakeTag('m', 'o', 'r', 'x'),
+	ot.MakeTag('o', 'p', 'b', 'd'), ot.MakeTag('p', 'r', 'o', 'p'), ot.MakeTag('t', 'r', 'a', 'k'),
+	ot.MakeTag('Z', 'a', 'p', 'f'), ot.MakeTag('S', 'i', 'l', 'f'), ot.MakeTag('G', 'l', 'a', 't'),
+	ot.MakeTag('G', 'l', 'o', 'c'), ot.MakeTag('F', 'e', 'a', 't'), ot.MakeTag('S', 'i', 'l', 'l'),
+}
+
+// woff2Table is a WOFF2 table directory entry with its stored data.
+type woff2Table struct {
+	tag         ot.Tag
+	origLength  int
+	data        []byte
+	transformed bool
+}
+
+// flags returns the flags byte: the known tag index and the transform
+// version. Version 0 transforms glyf and loca but is the null transform
+// of every other table; version 3 is their null transform.
+func (t *woff2Table) flags() byte {
+	version := 0
+	switch {
+	case (t.tag == ot.TagGlyf || t.tag == ot.TagLoca) && !t.transformed:
+		version = 3
+	case t.tag == ot.TagHmtx && t.transformed:
+		version = 1
+	}
+	index := slices.Index(woff2KnownTags, t.tag)
+	if index < 0 {
+		index = 63
+	}
+	return byte(version<<6 | index)
+}
+
+// EncodeWOFF2 converts an sfnt font to WOFF2. glyf and loca are always
+// transformed, hmtx when the left side bearings of the proportional or
+// monospaced glyphs equal their xMin.
+// fontTools equivalent: WOFF2Writer (ttLib/woff2.py)
+func EncodeWOFF2(sfnt []byte) ([]byte, error) {
+	flavor, tables, err := sfntTables(sfnt)
+	if err != nil {
+		return nil, err
+	}
+	byTag := make(map[ot.Tag][]byte)
+	for _, t := range tables {
+		byTag[t.tag] = t.data
+	}
+
+	var glyf []byte
+	var xMins []int16
+	if byTag[ot.TagGlyf] != nil && byTag[ot.TagLoca] != nil {
+		if glyf, xMins, err = transformGlyf(byTag[ot.TagGlyf], byTag[ot.TagLoca], byTag[ot.TagHead], byTag[ot.TagMaxp]); err != nil {
+			return nil, &TableError{Tag: ot.TagGlyf, Err: err}
+		}
+	}
+
+	// The transformed loca follows glyf; the other tables stay in tag
+	// order
+	entries := make([]woff2Table, 0, len(tables))
+	for _, t := range tables {
+		e := woff2Table{tag: t.tag, origLength: len(t.data), data: t.data}
+		switch {
+		case t.tag == ot.TagLoca && glyf != nil:
+			continue
+		case t.tag == ot.TagGlyf && glyf != nil:
+			e.data, e.transformed = glyf, true
+			entries = append(entries, e, woff2Table{tag: ot.TagLoca, origLength: len(byTag[ot.TagLoca]), transformed: true})
+			continue
+		case t.tag == ot.TagHmtx && xMins != nil:
+			if hmtx := transformHmtx(t.data, byTag[ot.TagHhea], xMins); hmtx != nil {
+				e.data, e.transformed = hmtx, true
+			}
+		case t.tag == ot.TagHead && len(t.data) >= 18:
+			// Bit 11 of flags: the font data went through a lossless
+			// transform
+			e.data = slices.Clone(t.data)
+			binary.BigEndian.PutUint16(e.data[16:], binary.BigEndian.Uint16(e.data[16:])|1<<11)
+		}
+		entries = append(entries, e)
+	}
+
+	var dir, stream []byte
+	lengths := make([]int, len(entries))
+	for i, e := range entries {
+		flags := e.flags()
+		dir = append(dir, flags)
+		if flags&63 == 63 {
+			dir = binary.BigEndian.AppendUint32(dir, uint32(e.tag))
+		}
+		dir = appendUIntBase128(dir, uint32(e.origLength))
+		if e.transformed {
+			dir = appendUIntBase128(dir, uint32(len(e.data)))
+		}
+		stream = append(stream, e.data...)
+		lengths[i] = e.origLength
+	}
+	compressed := brotli.Encode(stream)
+
+	out := make([]byte, 48, 48+len(dir)+len(compressed)+3)
+	out = append(out, dir...)
+	out = append(out, compressed...)
+	for len(out)%4 != 0 {
+		out = append(out, 0)
+	}
+
+	// WOFF2Header; there is no metadata or private data
+	major, minor := fontVersion(tables)
+	binary.BigEndian.PutUint32(out[0:], 0x774F4632) // 'wOF2'
+	binary.BigEndian.PutUint32(out[4:], flavor)
+	binary.BigEndian.PutUint32(out[8:], uint32(len(out)))
+	binary.BigEndian.PutUint16(out[12:], uint16(len(entries)))
+	binary.BigEndian.PutUint32(out[16:], uint32(sfntSize(lengths)))
+	binary.BigEndian.PutUint32(out[20:], uint32(len(compressed)))
+	binary.BigEndian.PutUint16(out[24:], major)
+	binary.BigEndian.PutUint16(out[26:], minor)
+	return out, nil
+}
+
+// appendUIntBase128 appends v in the variable-length UIntBase128
+// encoding.
+func appendUIntBase128(b []byte, v uint32) []byte {
+	n := 1
+	for v>>(7*n) != 0 {
+		n++
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := byte(v>>(7*i)) & 0x7F
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// append255UInt16 appends v in the variable-length 255UInt16 encoding.
+func append255UInt16(b []byte, v int) []byte {
+	switch {
+	case v < 253:
+		return append(b, byte(v))
+	case v < 506:
+		return append(b, 255, byte(v-253))
+	case v < 762:
+		return append(b, 254, byte(v-506))
+	}
+	return append(b, 253, byte(v>>8), byte(v))
+}
+
+// glyfStreams are the substreams of a transformed glyf table.
+type glyfStreams struct {
+	nContours, nPoints, flags, glyphs, composites, bboxes, instructions []byte
+}
+
+// appendTriplet appends a point delta in the triplet encoding (WOFF2
+// section 5.2): a flag with the on-curve bit and the coordinate data.
+func (s *glyfStreams) appendTriplet(onCurve bool, dx, dy int) {
+	var flag byte
+	if !onCurve {
+		flag = 128
+	}
+	absX, absY := dx, dy
+	var xSign, ySign byte = 1, 1
+	if dx < 0 {
+		absX, xSign = -dx, 0
+	}
+	if dy < 0 {
+		absY, ySign = -dy, 0
+	}
+	signs := xSign + 2*ySign
+	switch {
+	case dx == 0 && absY < 1280:
+		s.flags = append(s.flags, flag+byte(absY&0xF00>>7)+ySign)
+		s.glyphs = append(s.glyphs, byte(absY))
+	case dy == 0 && absX < 1280:
+		s.flags = append(s.flags, flag+10+byte(absX&0xF00>>7)+xSign)
+		s.glyphs = append(s.glyphs, byte(absX))
+	case absX < 65 && absY < 65:
+		s.flags = append(s.flags, flag+20+byte((absX-1)&0x30)+byte((absY-1)&0x30>>2)+signs)
+		s.glyphs = append(s.glyphs, byte((absX-1)&0xF<<4|(absY-1)&0xF))
+	case absX < 769 && absY < 769:
+		s.flags = append(s.flags, flag+84+12*byte((absX-1)&0x300>>8)+byte((absY-1)&0x300>>6)+signs)
+		s.glyphs = append(s.glyphs, byte(absX-1), byte(absY-1))
+	case absX < 4096 && absY < 4096:
+		s.flags = append(s.flags, flag+120+signs)
+		s.glyphs = append(s.glyphs, byte(absX>>4), byte(absX&0xF<<4|absY>>8), byte(absY))
+	default:
+		s.flags = append(s.flags, flag+124+signs)
+		s.glyphs = append(s.glyphs, byte(absX>>8), byte(absX), byte(absY>>8), byte(absY))
+	}
+}
+
+// transformGlyf returns the transformed glyf table of WOFF2 section 5.1
+// and the xMin of every glyph, 0 for empty glyphs. It replaces loca.
+func transformGlyf(glyfData, locaData, head, maxp []byte) ([]byte, []int16, error) {
+	if len(head) < 54 || len(maxp) < 6 {
+		return nil, nil, ot.ErrInvalidTable
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	indexFormat := binary.BigEndian.Uint16(head[50:])
+	loca, err := ot.ParseLoca(locaData, numGlyphs, int16(indexFormat))
+	if err != nil {
+		return nil, nil, err
+	}
+	glyf, _ := ot.ParseGlyf(glyfData, loca)
+
+	var s glyfStreams
+	bitmapSize := 4 * ((numGlyphs + 31) / 32)
+	bboxBitmap := make([]byte, bitmapSize)
+	overlapBitmap := make([]byte, bitmapSize)
+	hasOverlap := false
+	xMins := make([]int16, numGlyphs)
+	for gid := range numGlyphs {
+		glyph := glyf.GetGlyph(ot.GlyphID(gid))
+		if glyph == nil {
+			return nil, nil, ot.ErrInvalidTable
+		}
+		if glyph.NumberOfContours == 0 {
+			s.nContours = append(s.nContours, 0, 0)
+			continue
+		}
+		data := glyph.Data
+		if len(data) < 10 {
+			return nil, nil, ot.ErrInvalidTable
+		}
+		xMins[gid] = int16(binary.BigEndian.Uint16(data[2:]))
+		bit := byte(0x80 >> (gid & 7))
+
+		if glyph.NumberOfContours < 0 {
+			end, hasInstructions, err := compositeRecordsEnd(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			s.nContours = appendUint16s(s.nContours, 0xFFFF)
+			s.composites = append(s.composites, data[10:end]...)
+			if hasInstructions {
+				if end+2 > len(data) {
+					return nil, nil, ot.ErrInvalidTable
+				}
+				n := int(binary.BigEndian.Uint16(data[end:]))
+				if end+2+n > len(data) {
+					return nil, nil, ot.ErrInvalidTable
+				}
+				s.glyphs = append255UInt16(s.glyphs, n)
+				s.instructions = append(s.instructions, data[end+2:end+2+n]...)
+			}
+			// Composite glyphs always store their bounding box
+			bboxBitmap[gid>>3] |= bit
+			s.bboxes = append(s.bboxes, data[2:10]...)
+			continue
+		}
+
+		points, numContours, err := ot.ParseSimpleGlyph(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.nContours = appendUint16s(s.nContours, uint16(numContours))
+		prevEnd := -1
+		for i := range numContours {
+			end := int(binary.BigEndian.Uint16(data[10+2*i:]))
+			if end <= prevEnd {
+				return nil, nil, ot.ErrInvalidTable
+			}
+			s.nPoints = append255UInt16(s.nPoints, end-prevEnd)
+			prevEnd = end
+		}
+		if prevEnd+1 != len(points) {
+			return nil, nil, ot.ErrInvalidTable
+		}
+		x, y := 0, 0
+		xMin, yMin, xMax, yMax := points[0].X, points[0].Y, points[0].X, points[0].Y
+		for _, p := range points {
+			s.appendTriplet(p.OnCurve, int(p.X)-x, int(p.Y)-y)
+			x, y = int(p.X), int(p.Y)
+			if p.X < xMin {
+				xMin = p.X
+			}
+			if p.X > xMax {
+				xMax = p.X
+			}
+			if p.Y < yMin {
+				yMin = p.Y
+			}
+			if p.Y > yMax {
+				yMax = p.Y
+			}
+		}
+		instr := 10 + 2*numContours
+		n := int(binary.BigEndian.Uint16(data[instr:]))
+		s.glyphs = append255UInt16(s.glyphs, n)
+		s.instructions = append(s.instructions, data[instr+2:instr+2+n]...)
+		// OVERLAP_SIMPLE of the first point
+		if data[instr+2+n]&0x40 != 0 {
+			overlapBitmap[gid>>3] |= bit
+			hasOverlap = true
+		}
+		// The bounding box is computed from the points unless it differs
+		if !bytes.Equal(data[2:10], appendUint16s(nil, uint16(xMin), uint16(yMin), uint16(xMax), uint16(yMax))) {
+			bboxBitmap[gid>>3] |= bit
+			s.bboxes = append(s.bboxes, data[2:10]...)
+		}
+	}
+
+	var optionFlags uint16
+	if hasOverlap {
+		optionFlags = 1
+	}
+	bboxStream := append(bboxBitmap, s.bboxes...)
+	streams := [][]byte{s.nContours, s.nPoints, s.flags, s.glyphs, s.composites, bboxStream, s.instructions}
+	out := appendUint16s(nil, 0, optionFlags, uint16(numGlyphs), indexFormat)
+	for _, stream := range streams {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(stream)))
+	}
+	for _, stream := range streams {
+		out = append(out, stream...)
+	}
+	if hasOverlap {
+		out = append(out, overlapBitmap...)
+	}
+	return out, xMins, nil
+}
+
+// compositeRecordsEnd returns the end of the component records of a
+// composite glyph and whether instructions follow them.
+func compositeRecordsEnd(data []byte) (int, bool, error) {
+	const (
+		argsAreWords       = 0x0001
+		weHaveAScale       = 0x0008
+		moreComponents     = 0x0020
+		weHaveXYScale      = 0x0040
+		weHave2x2          = 0x0080
+		weHaveInstructions = 0x0100
+	)
+	off, hasInstructions := 10, false
+	for {
+		if off+4 > len(data) {
+			return 0, false, ot.ErrInvalidTable
+		}
+		flags := binary.BigEndian.Uint16(data[off:])
+		off += 4
+		if flags&argsAreWords != 0 {
+			off += 4
+		} else {
+			off += 2
+		}
+		switch {
+		case flags&weHaveAScale != 0:
+			off += 2
+		case flags&weHaveXYScale != 0:
+			off += 4
+		case flags&weHave2x2 != 0:
+			off += 8
+		}
+		hasInstructions = hasInstructions || flags&weHaveInstructions != 0
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	if off > len(data) {
+		return 0, false, ot.ErrInvalidTable
+	}
+	return off, hasInstructions, nil
+}
+
+// transformHmtx returns the transformed hmtx table of WOFF2 section 5.4,
+// or nil if neither left side bearing array can be left out.
+func transformHmtx(hmtx, hhea []byte, xMins []int16) []byte {
+	numGlyphs := len(xMins)
+	if len(hhea) < 36 {
+		return nil
+	}
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	if numHMetrics < 1 || numHMetrics > numGlyphs || len(hmtx) < 4*numHMetrics+2*(numGlyphs-numHMetrics) {
+		return nil
+	}
+	lsbs := hmtx[4*numHMetrics : 4*numHMetrics+2*(numGlyphs-numHMetrics)]
+	proportional, monospaced := true, numGlyphs > numHMetrics
+	for gid, xMin := range xMins {
+		if gid < numHMetrics {
+			proportional = proportional && int16(binary.BigEndian.Uint16(hmtx[4*gid+2:])) == xMin
+		} else {
+			monospaced = monospaced && int16(binary.BigEndian.Uint16(lsbs[2*(gid-numHMetrics):])) == xMin
+		}
+	}
+	if !proportional && !monospaced {
+		return nil
+	}
+
+	var flags byte
+	if proportional {
+		flags |= 1
+	}
+	if monospaced {
+		flags |= 2
+	}
+	out := []byte{flags}
+	for i := range numHMetrics {
+		out = append(out, hmtx[4*i:4*i+2]...)
+	}
+	if !proportional {
+		for i := range numHMetrics {
+			out = append(out, hmtx[4*i+2:4*i+4]...)
+		}
+	}
+	if !monospaced {
+		out = append(out, lsbs...)
+	}
+	return out
+}
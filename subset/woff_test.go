@@ -0,0 +1,183 @@
+package subset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/boxesandglue/textshape/ot"
+)
+
+// woff2Transformed returns the tags of the transformed tables of a WOFF2
+// font.
+func woff2Transformed(data []byte) []ot.Tag {
+	base128 := func(p *int) {
+		for data[*p]&0x80 != 0 {
+			*p++
+		}
+		*p++
+	}
+	var tags []ot.Tag
+	p := 48
+	for range int(binary.BigEndian.Uint16(data[12:])) {
+		flags := data[p]
+		p++
+		tag := woff2KnownTags[min(int(flags&63), 62)]
+		if flags&63 == 63 {
+			tag = ot.Tag(binary.BigEndian.Uint32(data[p:]))
+			p += 4
+		}
+		base128(&p)
+		transformed := flags>>6 != 0
+		if tag == ot.TagGlyf || tag == ot.TagLoca {
+			transformed = flags>>6 == 0
+		}
+		if transformed {
+			tags = append(tags, tag)
+			base128(&p)
+		}
+	}
+	return tags
+}
+
+// parseWOFFFont parses a WOFF or WOFF2 font.
+func parseWOFFFont(t *testing.T, data []byte) *ot.Font {
+	t.Helper()
+	font, err := ot.ParseFont(data, 0)
+	if err != nil {
+		t.Fatalf("ParseFont: %v", err)
+	}
+	return font
+}
+
+// compareTables reports tables of got that differ from want, ignoring
+// checkSumAdjustment and the WOFF2 flag in head, and the skipped tables.
+func compareTables(t *testing.T, want, got *ot.Font, skip ...ot.Tag) {
+	t.Helper()
+	if !slices.Equal(got.TableTags(), want.TableTags()) {
+		t.Fatalf("tables %v, want %v", got.TableTags(), want.TableTags())
+	}
+	for _, tag := range want.TableTags() {
+		if slices.Contains(skip, tag) {
+			continue
+		}
+		w, g := mustTableData(t, want, tag), mustTableData(t, got, tag)
+		if tag == ot.TagHead && len(g) == len(w) && len(g) >= 18 {
+			w, g = slices.Clone(w), slices.Clone(g)
+			clear(w[8:12])
+			clear(g[8:12])
+			g[16] &^= 0x08
+		}
+		if !bytes.Equal(g, w) {
+			t.Errorf("%s: %d bytes differ from %d", tag, len(g), len(w))
+		}
+	}
+}
+
+func TestBuildWOFF(t *testing.T) {
+	input := NewInput()
+	input.AddString("Hello")
+	_, sfnt, want := subsetAndParse(t, loadFont(t, "Roboto-Regular.ttf"), input)
+	woff, err := EncodeWOFF(sfnt)
+	if err != nil {
+		t.Fatalf("EncodeWOFF: %v", err)
+	}
+	if len(woff) >= len(sfnt) {
+		t.Errorf("WOFF %d bytes, sfnt %d", len(woff), len(sfnt))
+	}
+	if v := binary.BigEndian.Uint32(woff[20:]); v != binary.BigEndian.Uint32(mustTableData(t, want, ot.TagHead)[4:]) {
+		t.Errorf("version %#x, want head.fontRevision", v)
+	}
+	compareTables(t, want, parseWOFFFont(t, woff))
+
+	b := NewFontBuilder()
+	for _, tag := range want.TableTags() {
+		b.AddTable(tag, mustTableData(t, want, tag))
+	}
+	fromBuilder, err := b.BuildWOFF()
+	if err != nil {
+		t.Fatalf("BuildWOFF: %v", err)
+	}
+	compareTables(t, want, parseWOFFFont(t, fromBuilder))
+}
+
+func TestBuildWOFF2(t *testing.T) {
+	tests := []struct {
+		font, text  string
+		transformed []ot.Tag
+	}{
+		// Composite glyphs for the accented letters
+		{"Roboto-Regular.ttf", "Héllo wörld fiAV", []ot.Tag{ot.TagGlyf, ot.TagLoca, ot.TagHmtx}},
+		// No glyf, so hmtx is stored as is
+		{"SourceSansPro-Regular.otf", "Hello", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.font, func(t *testing.T) {
+			input := NewInput()
+			input.AddString(tt.text)
+			_, sfnt, want := subsetAndParse(t, loadFont(t, tt.font), input)
+			woff2, err := EncodeWOFF2(sfnt)
+			if err != nil {
+				t.Fatalf("EncodeWOFF2: %v", err)
+			}
+			woff, err := EncodeWOFF(sfnt)
+			if err != nil {
+				t.Fatalf("EncodeWOFF: %v", err)
+			}
+			if len(woff2) >= len(woff) {
+				t.Errorf("WOFF2 %d bytes, WOFF %d", len(woff2), len(woff))
+			}
+			if transformed := woff2Transformed(woff2); !slices.Equal(transformed, tt.transformed) {
+				t.Errorf("transformed %v, want %v", transformed, tt.transformed)
+			}
+
+			got := parseWOFFFont(t, woff2)
+			if head := mustTableData(t, got, ot.TagHead); head[16]&0x08 == 0 {
+				t.Errorf("head flags bit 11 not set")
+			}
+			if tt.transformed == nil {
+				compareTables(t, want, got)
+				return
+			}
+
+			// Reconstructed glyphs have the same outlines but not
+			// necessarily the same encoding or padding
+			compareTables(t, want, got, ot.TagGlyf, ot.TagLoca)
+			wantGlyf, err := ot.ParseGlyfFromFont(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotGlyf, err := ot.ParseGlyfFromFont(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			composites := 0
+			for gid := range want.NumGlyphs() {
+				w, g := wantGlyf.GetGlyph(ot.GlyphID(gid)), gotGlyf.GetGlyph(ot.GlyphID(gid))
+				if w.NumberOfContours != g.NumberOfContours || !bytes.Equal(w.Data[:min(len(w.Data), 10)], g.Data[:min(len(g.Data), 10)]) {
+					t.Errorf("glyph %d: header differs", gid)
+					continue
+				}
+				if w.NumberOfContours < 0 {
+					composites++
+					if !bytes.HasPrefix(w.Data, g.Data) && !bytes.HasPrefix(g.Data, w.Data) {
+						t.Errorf("glyph %d: composite differs\n% x\n% x", gid, g.Data, w.Data)
+					}
+					continue
+				}
+				if w.NumberOfContours == 0 {
+					continue
+				}
+				wp, _, _ := ot.ParseSimpleGlyph(w.Data)
+				gp, _, err := ot.ParseSimpleGlyph(g.Data)
+				if err != nil || !slices.Equal(gp, wp) {
+					t.Errorf("glyph %d: points differ (%v)", gid, err)
+				}
+			}
+			if composites == 0 {
+				t.Errorf("no composite glyphs in subset")
+			}
+		})
+	}
+}